@@ -1,10 +1,13 @@
 package main
 
 import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/policy"
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/server"
 	"bytes"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 )
 
@@ -176,7 +179,10 @@ func TestRequiredUserAllowed(t *testing.T) {
 }
 
 func TestAdditionalPrivilegedUserAllowed(t *testing.T) {
-	authorizer := CreateWebhookAuthorizer(DefaultProtectedNamespaces, []string{"special-user"}, false, 0)
+	authorizer := CreateWebhookAuthorizer(WebhookConfig{Config: policy.Config{
+		ProtectedNamespaces:       DefaultProtectedNamespaces,
+		AdditionalPrivilegedUsers: []string{"special-user"},
+	}})
 	accessTest(t, authorizer, false,
 		[]byte(
 			`{
@@ -199,6 +205,32 @@ func TestAdditionalPrivilegedUserAllowed(t *testing.T) {
 			}`))
 }
 
+func TestGroupsOnlyRequests(t *testing.T) {
+	// Some authentication proxies only send groups, which are never privileged
+	accessTest(t, DefaultAuthorizer, true, []byte(`{
+		"kind":"SubjectAccessReview",
+		"apiVersion":"authorization.k8s.io/v1",
+		"spec":{"resourceAttributes":{"namespace":"kube-system","verb":"get","resource":"secrets"},"groups":["system:authenticated"]}
+	}`))
+	accessTest(t, DefaultAuthorizer, false, []byte(`{
+		"kind":"SubjectAccessReview",
+		"apiVersion":"authorization.k8s.io/v1",
+		"spec":{"resourceAttributes":{"namespace":"default","verb":"get","resource":"secrets"},"uid":"6f1c"}
+	}`))
+}
+
+func TestUnrecognizedVerbsCounted(t *testing.T) {
+	before := unrecognizedVerbs.Value("view")
+	accessTest(t, DefaultAuthorizer, true, []byte(`{
+		"kind":"SubjectAccessReview",
+		"apiVersion":"authorization.k8s.io/v1",
+		"spec":{"resourceAttributes":{"namespace":"kube-system","verb":"view","resource":"pods"},"user":"alice"}
+	}`))
+	if unrecognizedVerbs.Value("view") != before+1 {
+		t.Error("Expected unrecognized verb to be counted")
+	}
+}
+
 func TestUnprivilegedUserDenied(t *testing.T) {
 	accessTest(t, DefaultAuthorizer, true,
 		[]byte(
@@ -452,6 +484,80 @@ func TestAllowedTrueInRequestDenied(t *testing.T) {
 			}`))
 }
 
+func TestDenyReasonReferences(t *testing.T) {
+	body := `{
+		"kind":"SubjectAccessReview",
+		"apiVersion":"authorization.k8s.io/v1",
+		"metadata":{"uid":"0a1b2c"},
+		"spec":{"resourceAttributes":{"namespace":"kube-system","verb":"create","resource":"pods"},"user":"not-admin"}
+		}`
+	for enabled, expected := range map[bool]string{
+		true:  "Cannot write to protected namespace (request 0a1b2c, policy generation 1)",
+		false: "Cannot write to protected namespace",
+	} {
+		authorizer := CreateWebhookAuthorizer(WebhookConfig{Config: DefaultPolicyConfig, DenyReasonReferences: enabled})
+		resp := httptest.NewRecorder()
+		authorizer(resp, httptest.NewRequest(http.MethodPost, "/authorize", bytes.NewBufferString(body)))
+		var sarResponse server.SubjectAccessReviewResponse
+		if err := json.NewDecoder(resp.Body).Decode(&sarResponse); err != nil {
+			t.Fatal(err)
+		}
+		if sarResponse.Status.Reason != expected {
+			t.Errorf("Expected reason %q, got %q", expected, sarResponse.Status.Reason)
+		}
+	}
+	if reason := denyReasonWithReferences("", "", 2); reason != "(policy generation 2)" {
+		t.Errorf("Unexpected reason %q", reason)
+	}
+}
+
+func TestDenyReasonHelp(t *testing.T) {
+	help := "For help, raise a ticket at https://help.example.com"
+	authorizer := CreateWebhookAuthorizer(WebhookConfig{Config: DefaultPolicyConfig, DenyReasonReferences: true, DenyReasonHelp: help})
+	for body, expected := range map[string]string{
+		`{"kind":"SubjectAccessReview","apiVersion":"authorization.k8s.io/v1","metadata":{"uid":"0a1b2c"},
+			"spec":{"resourceAttributes":{"namespace":"kube-system","verb":"create","resource":"pods"},"user":"not-admin"}}`: "Cannot write to protected namespace (request 0a1b2c, policy generation 1). " + help,
+		// Only denials get help
+		`{"kind":"SubjectAccessReview","apiVersion":"authorization.k8s.io/v1",
+			"spec":{"resourceAttributes":{"namespace":"default","verb":"create","resource":"pods"},"user":"not-admin"}}`: "",
+	} {
+		resp := httptest.NewRecorder()
+		authorizer(resp, httptest.NewRequest(http.MethodPost, "/authorize", bytes.NewBufferString(body)))
+		var sarResponse server.SubjectAccessReviewResponse
+		if err := json.NewDecoder(resp.Body).Decode(&sarResponse); err != nil {
+			t.Fatal(err)
+		}
+		if sarResponse.Status.Denied && sarResponse.Status.Reason != expected || !sarResponse.Status.Denied && strings.Contains(sarResponse.Status.Reason, help) {
+			t.Errorf("Expected reason %q, got %q", expected, sarResponse.Status.Reason)
+		}
+	}
+	if reason := denyReasonWithHelp("Denied by hook.", help); reason != "Denied by hook. "+help {
+		t.Errorf("Unexpected reason %q", reason)
+	}
+	if reason := denyReasonWithHelp("", help); reason != help {
+		t.Errorf("Unexpected reason %q", reason)
+	}
+}
+
+func TestBulkSecretReadsFlagged(t *testing.T) {
+	config := DefaultPolicyConfig
+	config.BulkSecretReads = policy.BulkSecretReadsFlag
+	authorizer := CreateWebhookAuthorizer(WebhookConfig{Config: config})
+	before := flaggedBulkSecretReads.Value("no-opinion")
+	// Flagged requests are allowed, and scoped ones not flagged
+	for _, attributes := range []string{
+		`{"namespace":"default","verb":"list","resource":"secrets"}`,
+		`{"namespace":"default","verb":"watch","resource":"secrets","labelSelector":{"rawSelector":"app=web"}}`,
+		`{"namespace":"default","verb":"list","resource":"configmaps"}`,
+	} {
+		accessTest(t, authorizer, false, []byte(`{"kind":"SubjectAccessReview","apiVersion":"authorization.k8s.io/v1",
+			"spec":{"resourceAttributes":`+attributes+`,"user":"not-admin"}}`))
+	}
+	if flaggedBulkSecretReads.Value("no-opinion") != before+1 {
+		t.Error("Expected only the unscoped secret list to be flagged")
+	}
+}
+
 func accessTest(t *testing.T, authorizer func(w http.ResponseWriter, r *http.Request), expectDenied bool, jsonData []byte) {
 	data := bytes.NewBuffer(jsonData)
 	req := httptest.NewRequest(http.MethodPost, "/authorize", data)
@@ -460,7 +566,7 @@ func accessTest(t *testing.T, authorizer func(w http.ResponseWriter, r *http.Req
 
 	authorizer(resp, req)
 
-	var sarResponse SubjectAccessReviewHTTPResponse
+	var sarResponse server.SubjectAccessReviewResponse
 	_ = json.NewDecoder(resp.Body).Decode(&sarResponse)
 	if sarResponse.Status.Denied != expectDenied {
 		var expectedResp string
@@ -0,0 +1,497 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/config"
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/policy"
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/server"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kinds of subject privileges can be granted to
+const (
+	PrivilegeGrantUser  = "user"
+	PrivilegeGrantGroup = "group"
+)
+
+// Privileged status granted to a user or group through the admin API
+type PrivilegeGrant struct {
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+	// Unset for grants which don't expire
+	Expires *time.Time `json:"expires,omitempty"`
+	Reason  string     `json:"reason,omitempty"`
+	// Admin who made the grant
+	GrantedBy string    `json:"grantedBy"`
+	Granted   time.Time `json:"granted"`
+}
+
+func (g PrivilegeGrant) key() string {
+	return g.Kind + "/" + g.Name
+}
+
+func (g PrivilegeGrant) expired(now time.Time) bool {
+	return g.Expires != nil && !now.Before(*g.Expires)
+}
+
+// Request body of POST /admin/privileges
+type PrivilegeGrantRequest struct {
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+	// How long the grant lasts, e.g. 1h. Permanent if empty
+	TTL    string `json:"ttl,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+var privilegeGrantChanges = Metrics.NewCounterVec("azimuth_authz_privilege_grant_changes_total",
+	"Privileges granted, revoked and expired through the admin API, by action", "action")
+
+// Privileges granted at runtime through the admin API, for emergency access without redeploying. Grants
+// are saved to a file after each change, so they survive restarts, and audited
+type PrivilegeGrants struct {
+	mu     sync.Mutex
+	grants map[string]PrivilegeGrant
+	// Not persisted if empty
+	path string
+	// Optional, changes are not audited if nil
+	audit *AuditPipeline
+	// Called after each change, including grants expiring, e.g. to discard cached decisions
+	onChange func()
+	now      func() time.Time
+	// Fires when the next grant expires, so decisions it allowed don't outlive it
+	expiry *time.Timer
+}
+
+// Returns grants saved to path, which may not exist yet
+func LoadPrivilegeGrants(path string, audit *AuditPipeline, onChange func()) (*PrivilegeGrants, error) {
+	g := &PrivilegeGrants{grants: map[string]PrivilegeGrant{}, path: path, audit: audit, onChange: onChange, now: time.Now}
+	if path == "" {
+		return g, nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return g, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var grants []PrivilegeGrant
+	if err := json.Unmarshal(data, &grants); err != nil {
+		return nil, fmt.Errorf("corrupt privilege grants file: %w", err)
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, grant := range grants {
+		g.grants[grant.key()] = grant
+	}
+	g.scheduleExpiryLocked()
+	return g, nil
+}
+
+func (g *PrivilegeGrants) Name() string { return "admin-grants" }
+
+func (g *PrivilegeGrants) IsPrivileged(_ context.Context, spec *policy.SubjectAccessReviewSpec) (bool, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := g.now()
+	if grant, ok := g.grants[PrivilegeGrantUser+"/"+spec.User]; ok && spec.User != "" && !grant.expired(now) {
+		return true, nil
+	}
+	for _, group := range spec.Groups {
+		if grant, ok := g.grants[PrivilegeGrantGroup+"/"+group]; ok && !grant.expired(now) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Returns unexpired grants, ordered by kind and name
+func (g *PrivilegeGrants) List() []PrivilegeGrant {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.pruneLocked()
+	grants := make([]PrivilegeGrant, 0, len(g.grants))
+	for _, grant := range g.grants {
+		grants = append(grants, grant)
+	}
+	slices.SortFunc(grants, func(a, b PrivilegeGrant) int { return strings.Compare(a.key(), b.key()) })
+	return grants
+}
+
+// Adds grant, replacing any existing grant to the same subject
+func (g *PrivilegeGrants) Grant(grant PrivilegeGrant) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.pruneLocked()
+	previous, replaced := g.grants[grant.key()]
+	g.grants[grant.key()] = grant
+	if err := g.saveLocked(); err != nil {
+		if replaced {
+			g.grants[grant.key()] = previous
+		} else {
+			delete(g.grants, grant.key())
+		}
+		return err
+	}
+	g.scheduleExpiryLocked()
+	privilegeGrantChanges.Inc("granted")
+	reason := "Granted privileged status"
+	if grant.Expires != nil {
+		reason += " until " + grant.Expires.Format(time.RFC3339)
+	}
+	if grant.Reason != "" {
+		reason += ": " + grant.Reason
+	}
+	g.recordLocked(grant.GrantedBy, "create", grant, reason)
+	return nil
+}
+
+// Removes the grant to the subject, returning false if there is none
+func (g *PrivilegeGrants) Revoke(kind string, name string, revokedBy string) (bool, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.pruneLocked()
+	grant, ok := g.grants[kind+"/"+name]
+	if !ok {
+		return false, nil
+	}
+	delete(g.grants, grant.key())
+	if err := g.saveLocked(); err != nil {
+		g.grants[grant.key()] = grant
+		return false, err
+	}
+	g.scheduleExpiryLocked()
+	privilegeGrantChanges.Inc("revoked")
+	g.recordLocked(revokedBy, "delete", grant, "Revoked privileged status")
+	return true, nil
+}
+
+// Discards expired grants, which already have no effect, so they're no longer listed or saved, and
+// notifies onChange if there were any
+func (g *PrivilegeGrants) pruneLocked() {
+	now := g.now()
+	pruned := false
+	for key, grant := range g.grants {
+		if grant.expired(now) {
+			delete(g.grants, key)
+			pruned = true
+			privilegeGrantChanges.Inc("expired")
+			log.Printf("Privilege grant to %s %s expired\n", grant.Kind, grant.Name)
+		}
+	}
+	if pruned && g.onChange != nil {
+		g.onChange()
+	}
+}
+
+// Sets the expiry timer to prune grants when the earliest to expire does
+func (g *PrivilegeGrants) scheduleExpiryLocked() {
+	if g.expiry != nil {
+		g.expiry.Stop()
+		g.expiry = nil
+	}
+	var next *time.Time
+	for _, grant := range g.grants {
+		if grant.Expires != nil && (next == nil || grant.Expires.Before(*next)) {
+			next = grant.Expires
+		}
+	}
+	if next == nil {
+		return
+	}
+	g.expiry = time.AfterFunc(next.Sub(g.now()), func() {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		g.pruneLocked()
+		if err := g.saveLocked(); err != nil {
+			log.Printf("error saving privilege grants: %s\n", err)
+		}
+		g.scheduleExpiryLocked()
+	})
+}
+
+// Writes the grants to the file, replacing it atomically
+func (g *PrivilegeGrants) saveLocked() error {
+	if g.path == "" {
+		return nil
+	}
+	grants := make([]PrivilegeGrant, 0, len(g.grants))
+	for _, grant := range g.grants {
+		grants = append(grants, grant)
+	}
+	return saveAdminState(g.path, grants)
+}
+
+// Logs and audits a change made by admin, then notifies onChange
+func (g *PrivilegeGrants) recordLocked(admin string, verb string, grant PrivilegeGrant, reason string) {
+	recordAdminChange(g.audit, AuditEvent{
+		Time:     g.now(),
+		User:     admin,
+		Verb:     verb,
+		Resource: "privileges",
+		Name:     grant.Kind + ":" + grant.Name,
+		Reason:   reason,
+	})
+	if g.onChange != nil {
+		g.onChange()
+	}
+}
+
+// Writes state changed through the admin API to path as JSON, replacing the file atomically
+func saveAdminState(path string, state any) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".admin-state-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Logs and audits a change made through the admin API, described by event's reason
+func recordAdminChange(audit *AuditPipeline, event AuditEvent) {
+	log.Printf("[Admin: %s] %s: %s %s\n", event.User, event.Reason, event.Resource, event.Name)
+	event.Allowed = true
+	audit.Publish(event)
+}
+
+// Protected namespace overrides made through the admin API, applied to the policy source on top of its
+// configured policy. Saved to a file after each change, so they survive restarts, and audited
+type NamespaceOverrideStore struct {
+	mu        sync.Mutex
+	overrides policy.NamespaceOverrides
+	source    *policy.Source
+	// Not persisted if empty
+	path string
+	// Optional, changes are not audited if nil
+	audit *AuditPipeline
+	// Called after each change, e.g. to discard cached decisions
+	onChange func()
+	now      func() time.Time
+}
+
+// Body of GET /admin/protected-namespaces
+type ProtectedNamespacesResponse struct {
+	// Overrides made through the admin API
+	policy.NamespaceOverrides
+	// Entries of the policy in effect, with the overrides applied
+	EffectiveProtected []string `json:"effectiveProtected"`
+	EffectiveExempted  []string `json:"effectiveExempted"`
+}
+
+// Returns overrides saved to path, which may not exist yet, after applying them to source
+func LoadNamespaceOverrides(path string, source *policy.Source, audit *AuditPipeline, onChange func()) (*NamespaceOverrideStore, error) {
+	o := &NamespaceOverrideStore{source: source, path: path, audit: audit, onChange: onChange, now: time.Now}
+	if path == "" {
+		return o, nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return o, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &o.overrides); err != nil {
+		return nil, fmt.Errorf("corrupt namespace overrides file: %w", err)
+	}
+	if err := (policy.Config{ProtectedNamespaces: o.overrides.Protected, ExemptNamespaces: o.overrides.Exempted}).Validate(); err != nil {
+		return nil, err
+	}
+	source.SetNamespaceOverrides(o.overrides)
+	return o, nil
+}
+
+// Returns the overrides and the protected namespace entries in effect
+func (o *NamespaceOverrideStore) Describe() ProtectedNamespacesResponse {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	config := o.source.Current().Config()
+	return ProtectedNamespacesResponse{
+		NamespaceOverrides: o.overrides,
+		EffectiveProtected: slices.DeleteFunc(slices.Clone(config.ProtectedNamespaces), func(entry string) bool { return entry == "" }),
+		EffectiveExempted:  slices.DeleteFunc(slices.Clone(config.ExemptNamespaces), func(entry string) bool { return entry == "" }),
+	}
+}
+
+// Replaces the overrides with those change returns, unless they're unchanged, saving, applying and
+// auditing them. Returns false if they're unchanged
+func (o *NamespaceOverrideStore) Update(admin string, verb string, entry string, reason string, change func(policy.NamespaceOverrides) policy.NamespaceOverrides) (bool, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	updated := change(o.overrides)
+	if slices.Equal(updated.Protected, o.overrides.Protected) && slices.Equal(updated.Exempted, o.overrides.Exempted) {
+		return false, nil
+	}
+	if o.path != "" {
+		if err := saveAdminState(o.path, updated); err != nil {
+			return false, err
+		}
+	}
+	o.overrides = updated
+	o.source.SetNamespaceOverrides(updated)
+	namespaceOverrideChanges.Inc(verb)
+	recordAdminChange(o.audit, AuditEvent{
+		Time:     o.now(),
+		User:     admin,
+		Verb:     verb,
+		Resource: "protectednamespaces",
+		Name:     entry,
+		Reason:   reason,
+	})
+	if o.onChange != nil {
+		o.onChange()
+	}
+	return true, nil
+}
+
+var namespaceOverrideChanges = Metrics.NewCounterVec("azimuth_authz_namespace_override_changes_total",
+	"Protected namespace overrides changed through the admin API, by verb", "verb")
+
+// Returns HTTP handler for the /admin/ API, which must only be served behind authenticateAdmin. settings are
+// the effective settings served at /admin/config
+func CreateAdminHandler(grants *PrivilegeGrants, namespaces *NamespaceOverrideStore, settings []config.Setting) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /admin/protected-namespaces", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(namespaces.Describe())
+	})
+	// Overrides are put to protect or exempt an entry, and deleted to leave it to the configured policy
+	mux.HandleFunc("PUT /admin/protected-namespaces/{override}/{entry...}", func(w http.ResponseWriter, r *http.Request) {
+		entry := r.PathValue("entry")
+		if err := policy.ValidateNamespacePatterns([]string{entry}); err != nil {
+			server.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		var err error
+		switch r.PathValue("override") {
+		case "protected":
+			_, err = namespaces.Update(adminUser(r.Context()), "protect", entry, "Protected namespace", func(overrides policy.NamespaceOverrides) policy.NamespaceOverrides {
+				return overrides.Protect(entry)
+			})
+		case "exempted":
+			_, err = namespaces.Update(adminUser(r.Context()), "exempt", entry, "Exempted namespace from protection", func(overrides policy.NamespaceOverrides) policy.NamespaceOverrides {
+				return overrides.Exempt(entry)
+			})
+		default:
+			server.WriteError(w, http.StatusNotFound, errors.New("override must be protected or exempted"))
+			return
+		}
+		writeNamespaceOverrideResult(w, err, http.StatusNoContent)
+	})
+	mux.HandleFunc("DELETE /admin/protected-namespaces/{override}/{entry...}", func(w http.ResponseWriter, r *http.Request) {
+		entry, override := r.PathValue("entry"), r.PathValue("override")
+		if override != "protected" && override != "exempted" {
+			server.WriteError(w, http.StatusNotFound, errors.New("override must be protected or exempted"))
+			return
+		}
+		updated, err := namespaces.Update(adminUser(r.Context()), "reset", entry, "Removed namespace override", func(overrides policy.NamespaceOverrides) policy.NamespaceOverrides {
+			if override == "protected" && !slices.Contains(overrides.Protected, entry) || override == "exempted" && !slices.Contains(overrides.Exempted, entry) {
+				return overrides
+			}
+			return overrides.Reset(entry)
+		})
+		if err == nil && !updated {
+			server.WriteError(w, http.StatusNotFound, fmt.Errorf("namespace entry %s isn't %s by an override", entry, override))
+			return
+		}
+		writeNamespaceOverrideResult(w, err, http.StatusNoContent)
+	})
+	mux.HandleFunc("GET /admin/privileges", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(grants.List())
+	})
+	mux.HandleFunc("POST /admin/privileges", func(w http.ResponseWriter, r *http.Request) {
+		var request PrivilegeGrantRequest
+		if err := server.DecodeJSON(r.Body, &request, nil); err != nil {
+			server.WriteError(w, http.StatusBadRequest, fmt.Errorf("JSON decoding error: %w", err))
+			return
+		}
+		grant, err := newPrivilegeGrant(request, adminUser(r.Context()), grants.now())
+		if err != nil {
+			server.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		if err := grants.Grant(grant); err != nil {
+			log.Println("Error saving privilege grants:", err)
+			server.WriteError(w, http.StatusInternalServerError, errors.New("saving privilege grants failed"))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(grant)
+	})
+	mux.HandleFunc("DELETE /admin/privileges/{kind}/{name...}", func(w http.ResponseWriter, r *http.Request) {
+		revoked, err := grants.Revoke(r.PathValue("kind"), r.PathValue("name"), adminUser(r.Context()))
+		if err != nil {
+			log.Println("Error saving privilege grants:", err)
+			server.WriteError(w, http.StatusInternalServerError, errors.New("saving privilege grants failed"))
+			return
+		}
+		if !revoked {
+			server.WriteError(w, http.StatusNotFound, fmt.Errorf("no privileges granted to %s %s", r.PathValue("kind"), r.PathValue("name")))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("GET /admin/config", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(settings)
+	})
+	return mux
+}
+
+type adminUserKey struct{}
+
+// Returns the authenticated admin making the request
+func adminUser(ctx context.Context) string {
+	user, _ := ctx.Value(adminUserKey{}).(string)
+	return user
+}
+
+// Returns the grant request asks for, made by admin at now
+func newPrivilegeGrant(request PrivilegeGrantRequest, admin string, now time.Time) (PrivilegeGrant, error) {
+	grant := PrivilegeGrant{Kind: request.Kind, Name: request.Name, Reason: request.Reason, GrantedBy: admin, Granted: now}
+	if grant.Kind != PrivilegeGrantUser && grant.Kind != PrivilegeGrantGroup {
+		return grant, fmt.Errorf("kind must be %s or %s", PrivilegeGrantUser, PrivilegeGrantGroup)
+	}
+	if grant.Name == "" {
+		return grant, errors.New("name is required")
+	}
+	if request.TTL != "" {
+		ttl, err := time.ParseDuration(request.TTL)
+		if err != nil || ttl <= 0 {
+			return grant, fmt.Errorf("invalid ttl %q, expected a positive duration such as 1h", request.TTL)
+		}
+		expires := now.Add(ttl)
+		grant.Expires = &expires
+	}
+	return grant, nil
+}
+
+func writeNamespaceOverrideResult(w http.ResponseWriter, err error, code int) {
+	if err != nil {
+		log.Println("Error saving namespace overrides:", err)
+		server.WriteError(w, http.StatusInternalServerError, errors.New("saving namespace overrides failed"))
+		return
+	}
+	w.WriteHeader(code)
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/policy"
+	"encoding/json"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func adminRequest(t *testing.T, handler http.Handler, method string, path string, token string, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestAdminPrivilegeGrants(t *testing.T) {
+	dir := t.TempDir()
+	tokenFile, grantsFile := filepath.Join(dir, "tokens.csv"), filepath.Join(dir, "grants.json")
+	os.WriteFile(tokenFile, []byte("admin-token,ops,1\n"), 0o600)
+	authenticator, err := NewStaticTokenAuthenticator(tokenFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := &recordingSink{}
+	audit := NewAuditPipeline([]AuditSink{sink}, AuditPipelineOptions{})
+	changes := 0
+	grants, err := LoadPrivilegeGrants(grantsFile, audit, func() { changes++ })
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := authenticateAdmin(authenticator, CreateAdminHandler(grants, nil, nil))
+	evaluate := newEvaluator(WebhookConfig{Config: DefaultPolicyConfig, Privileges: PrivilegeResolvers{grants}})
+	sar := policy.SubjectAccessReview{Spec: policy.SubjectAccessReviewSpec{
+		User:               "alice",
+		ResourceAttributes: &authorizationv1.ResourceAttributes{Namespace: "kube-system", Verb: "delete", Resource: "pods"},
+	}}
+
+	if recorder := adminRequest(t, handler, http.MethodGet, "/admin/privileges", "wrong-token", ""); recorder.Code != http.StatusUnauthorized {
+		t.Errorf("Expected unknown token to be unauthorized, got %d", recorder.Code)
+	}
+	if recorder := adminRequest(t, handler, http.MethodPost, "/admin/privileges", "admin-token", `{"kind": "role", "name": "alice"}`); recorder.Code != http.StatusBadRequest {
+		t.Errorf("Expected unknown kind to be rejected, got %d", recorder.Code)
+	}
+	if status := evaluate(t.Context(), sar); !status.Denied {
+		t.Fatalf("Expected alice to be denied before the grant, got %+v", status)
+	}
+
+	recorder := adminRequest(t, handler, http.MethodPost, "/admin/privileges", "admin-token", `{"kind": "user", "name": "alice", "ttl": "1h", "reason": "incident 42"}`)
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("Expected grant to be created, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+	var grant PrivilegeGrant
+	json.Unmarshal(recorder.Body.Bytes(), &grant)
+	if grant.GrantedBy != "ops" || grant.Expires == nil || grant.Expires.Sub(grant.Granted) != time.Hour {
+		t.Errorf("Unexpected grant %+v", grant)
+	}
+	if status := evaluate(t.Context(), sar); status.Denied {
+		t.Errorf("Expected alice to be privileged after the grant, got %+v", status)
+	}
+
+	// Grants survive restarts
+	reloaded, err := LoadPrivilegeGrants(grantsFile, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if listed := reloaded.List(); len(listed) != 1 || listed[0].Name != "alice" || listed[0].Reason != "incident 42" {
+		t.Errorf("Expected saved grant to be loaded, got %+v", listed)
+	}
+	reloaded.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	if privileged, _ := reloaded.IsPrivileged(t.Context(), &sar.Spec); privileged || len(reloaded.List()) != 0 {
+		t.Error("Expected grant to expire after its TTL")
+	}
+
+	if recorder := adminRequest(t, handler, http.MethodDelete, "/admin/privileges/user/alice", "admin-token", ""); recorder.Code != http.StatusNoContent {
+		t.Errorf("Expected grant to be revoked, got %d", recorder.Code)
+	}
+	if recorder := adminRequest(t, handler, http.MethodDelete, "/admin/privileges/user/alice", "admin-token", ""); recorder.Code != http.StatusNotFound {
+		t.Errorf("Expected missing grant to be not found, got %d", recorder.Code)
+	}
+	if status := evaluate(t.Context(), sar); !status.Denied {
+		t.Errorf("Expected alice to be denied after revocation, got %+v", status)
+	}
+
+	audit.Close()
+	var verbs []string
+	for _, batch := range sink.batches {
+		for _, event := range batch {
+			if event.User != "ops" || event.Name != "user:alice" {
+				t.Errorf("Unexpected audit event %+v", event)
+			}
+			verbs = append(verbs, event.Verb)
+		}
+	}
+	if strings.Join(verbs, ",") != "create,delete" || changes != 2 {
+		t.Errorf("Expected grant and revocation to be audited and notified, got %v and %d changes", verbs, changes)
+	}
+}
+
+func TestPrivilegeGrantsToGroups(t *testing.T) {
+	grants, _ := LoadPrivilegeGrants("", nil, nil)
+	grants.Grant(PrivilegeGrant{Kind: PrivilegeGrantGroup, Name: "oncall", GrantedBy: "ops"})
+	for groups, expected := range map[string]bool{"developers,oncall": true, "developers": false} {
+		spec := policy.SubjectAccessReviewSpec{User: "bob", Groups: strings.Split(groups, ",")}
+		if privileged, _ := grants.IsPrivileged(t.Context(), &spec); privileged != expected {
+			t.Errorf("Expected privileged=%v for groups %s", expected, groups)
+		}
+	}
+}
+
+func TestPrivilegeGrantsNotifyExpiry(t *testing.T) {
+	expired := make(chan struct{}, 1)
+	grants, _ := LoadPrivilegeGrants("", nil, func() {
+		select {
+		case expired <- struct{}{}:
+		default:
+		}
+	})
+	expires := time.Now().Add(50 * time.Millisecond)
+	grants.Grant(PrivilegeGrant{Kind: PrivilegeGrantUser, Name: "alice", GrantedBy: "ops", Expires: &expires})
+	<-expired
+	// Decisions cached while the grant was active are discarded when it expires, not only on the next change
+	select {
+	case <-expired:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected expiry to be notified without any other change")
+	}
+	if listed := grants.List(); len(listed) != 0 {
+		t.Errorf("Expected expired grant to be pruned, got %+v", listed)
+	}
+}
+
+func TestAdminNamespaceOverrides(t *testing.T) {
+	dir := t.TempDir()
+	tokenFile, overridesFile := filepath.Join(dir, "tokens.csv"), filepath.Join(dir, "namespaces.json")
+	os.WriteFile(tokenFile, []byte("admin-token,ops,1\n"), 0o600)
+	authenticator, _ := NewStaticTokenAuthenticator(tokenFile)
+	sink := &recordingSink{}
+	audit := NewAuditPipeline([]AuditSink{sink}, AuditPipelineOptions{})
+	source := policy.NewSource(policy.Config{ProtectedNamespaces: []string{"kube-system", "openstack-*"}})
+	namespaces, err := LoadNamespaceOverrides(overridesFile, source, audit, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := authenticateAdmin(authenticator, CreateAdminHandler(nil, namespaces, nil))
+
+	for _, path := range []string{"/admin/protected-namespaces/protected/billing", "/admin/protected-namespaces/exempted/openstack-sandbox"} {
+		if recorder := adminRequest(t, handler, http.MethodPut, path, "admin-token", ""); recorder.Code != http.StatusNoContent {
+			t.Errorf("Expected PUT %s to succeed, got %d: %s", path, recorder.Code, recorder.Body.String())
+		}
+	}
+	if recorder := adminRequest(t, handler, http.MethodPut, "/admin/protected-namespaces/protected/bad-[", "admin-token", ""); recorder.Code != http.StatusBadRequest {
+		t.Errorf("Expected invalid pattern to be rejected, got %d", recorder.Code)
+	}
+	if !source.Current().IsProtectedNamespace("billing") || source.Current().IsProtectedNamespace("openstack-sandbox") {
+		t.Error("Expected overrides to apply to the policy in effect")
+	}
+
+	recorder := adminRequest(t, handler, http.MethodGet, "/admin/protected-namespaces", "admin-token", "")
+	var described ProtectedNamespacesResponse
+	json.Unmarshal(recorder.Body.Bytes(), &described)
+	if strings.Join(described.EffectiveProtected, ",") != "kube-system,openstack-*,billing" || strings.Join(described.EffectiveExempted, ",") != "openstack-sandbox" {
+		t.Errorf("Unexpected protected namespaces %s", recorder.Body.String())
+	}
+
+	// Overrides survive restarts
+	restarted := policy.NewSource(policy.Config{ProtectedNamespaces: []string{"kube-system"}})
+	if _, err := LoadNamespaceOverrides(overridesFile, restarted, nil, nil); err != nil || !restarted.Current().IsProtectedNamespace("billing") {
+		t.Errorf("Expected saved overrides to be applied on load, got %v", err)
+	}
+
+	if recorder := adminRequest(t, handler, http.MethodDelete, "/admin/protected-namespaces/exempted/openstack-sandbox", "admin-token", ""); recorder.Code != http.StatusNoContent {
+		t.Errorf("Expected exemption to be removed, got %d", recorder.Code)
+	}
+	if recorder := adminRequest(t, handler, http.MethodDelete, "/admin/protected-namespaces/exempted/openstack-sandbox", "admin-token", ""); recorder.Code != http.StatusNotFound {
+		t.Errorf("Expected missing exemption to be not found, got %d", recorder.Code)
+	}
+	if !source.Current().IsProtectedNamespace("openstack-sandbox") {
+		t.Error("Expected namespace to be protected again once its exemption is removed")
+	}
+
+	audit.Close()
+	var verbs []string
+	for _, batch := range sink.batches {
+		for _, event := range batch {
+			verbs = append(verbs, event.Verb+" "+event.Name)
+		}
+	}
+	if strings.Join(verbs, ",") != "protect billing,exempt openstack-sandbox,reset openstack-sandbox" {
+		t.Errorf("Unexpected audit events %v", verbs)
+	}
+}
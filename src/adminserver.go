@@ -0,0 +1,81 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/server"
+	"context"
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// Settings for the admin listener, which serves the admin API and debug endpoints apart from the
+// authorization endpoints, so workload clusters calling those can never reach them
+type AdminServerOptions struct {
+	Address string
+	// Optional, bearer tokens aren't accepted if nil
+	Authenticator TokenAuthenticator
+	// HTTPS is served if set
+	TLSCertFile string
+	TLSKeyFile  string
+	// Optional, client certificates signed by this CA are accepted, identified by their common name. Needs TLS
+	ClientCAFile string
+}
+
+// Returns the admin server serving handler to authenticated callers only
+func NewAdminServer(options AdminServerOptions, handler http.Handler) (*http.Server, error) {
+	if options.Authenticator == nil && options.ClientCAFile == "" {
+		return nil, errors.New("a token file or client CA is required")
+	}
+	if (options.TLSCertFile == "") != (options.TLSKeyFile == "") {
+		return nil, errors.New("TLS needs both a certificate and a key")
+	}
+	if options.ClientCAFile != "" && options.TLSCertFile == "" {
+		return nil, errors.New("client certificates need a TLS certificate and key")
+	}
+	adminServer := &http.Server{Addr: options.Address, Handler: authenticateAdmin(options.Authenticator, handler)}
+	if options.TLSCertFile != "" {
+		certificate, err := tls.LoadX509KeyPair(options.TLSCertFile, options.TLSKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		adminServer.TLSConfig = &tls.Config{Certificates: []tls.Certificate{certificate}, MinVersion: tls.VersionTLS12}
+		if options.ClientCAFile != "" {
+			caConfig, err := tlsConfigWithCA(options.ClientCAFile)
+			if err != nil {
+				return nil, err
+			}
+			adminServer.TLSConfig.ClientCAs = caConfig.RootCAs
+			adminServer.TLSConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+	}
+	return adminServer, nil
+}
+
+// Serves the admin server until it's shut down
+func serveAdmin(adminServer *http.Server) error {
+	if adminServer.TLSConfig != nil {
+		return adminServer.ListenAndServeTLS("", "")
+	}
+	return adminServer.ListenAndServe()
+}
+
+// Returns handler admitting requests presenting a verified client certificate, or a bearer token
+// authenticator accepts, identifying the admin by the certificate's common name or the token's user
+func authenticateAdmin(authenticator TokenAuthenticator, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var admin string
+		if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+			admin = r.TLS.VerifiedChains[0][0].Subject.CommonName
+		} else if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && authenticator != nil {
+			if user, authenticated, err := authenticator.AuthenticateToken(r.Context(), token); err == nil && authenticated {
+				admin = user.Username
+			}
+		}
+		if admin == "" {
+			server.WriteError(w, http.StatusUnauthorized, errors.New("Unauthorized"))
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), adminUserKey{}, admin)))
+	})
+}
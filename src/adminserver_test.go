@@ -0,0 +1,78 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/config"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAuthenticateAdmin(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "tokens.csv")
+	os.WriteFile(tokenFile, []byte("admin-token,ops,1\n"), 0o600)
+	authenticator, err := NewStaticTokenAuthenticator(tokenFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var admin string
+	handler := authenticateAdmin(authenticator, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		admin = adminUser(r.Context())
+	}))
+
+	if recorder := adminRequest(t, handler, http.MethodGet, "/admin/config", "admin-token", ""); recorder.Code != http.StatusOK || admin != "ops" {
+		t.Errorf("expected token to identify ops, got %d, %q", recorder.Code, admin)
+	}
+	for _, token := range []string{"", "wrong"} {
+		if recorder := adminRequest(t, handler, http.MethodGet, "/admin/config", token, ""); recorder.Code != http.StatusUnauthorized {
+			t.Errorf("expected 401 for token %q, got %d", token, recorder.Code)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/config", nil)
+	req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: "oncall"}}}}}
+	recorder := httptest.NewRecorder()
+	authenticateAdmin(nil, handler).ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusOK || admin != "oncall" {
+		t.Errorf("expected client certificate to identify oncall, got %d, %q", recorder.Code, admin)
+	}
+}
+
+func TestNewAdminServerValidation(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "tokens.csv")
+	os.WriteFile(tokenFile, []byte("admin-token,ops,1\n"), 0o600)
+	authenticator, err := NewStaticTokenAuthenticator(tokenFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, options := range map[string]AdminServerOptions{
+		"no authentication":     {Address: ":0"},
+		"certificate alone":     {Address: ":0", Authenticator: authenticator, TLSCertFile: "tls.crt"},
+		"client CA without TLS": {Address: ":0", ClientCAFile: "ca.crt"},
+	} {
+		if _, err := NewAdminServer(options, http.NotFoundHandler()); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+	adminServer, err := NewAdminServer(AdminServerOptions{Address: ":0", Authenticator: authenticator}, http.NotFoundHandler())
+	if err != nil || adminServer.TLSConfig != nil {
+		t.Errorf("expected plain HTTP admin server, got %v", err)
+	}
+}
+
+func TestAdminConfig(t *testing.T) {
+	settings := []config.Setting{{Name: "admin-address", Value: ":8081", Source: config.SourceDefault}, {Name: "log-level", Value: "2", Source: config.SourceEnv}}
+	recorder := adminRequest(t, CreateAdminHandler(nil, nil, settings), http.MethodGet, "/admin/config", "", "")
+	var got []config.Setting
+	if err := json.NewDecoder(recorder.Body).Decode(&got); err != nil || recorder.Code != http.StatusOK {
+		t.Fatalf("expected settings, got %d: %v", recorder.Code, err)
+	}
+	if len(got) != 2 || got[1] != settings[1] {
+		t.Errorf("expected %v, got %v", settings, got)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/policy"
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/server"
+	"encoding/json"
+	"errors"
+	"fmt"
+	admissionv1 "k8s.io/api/admission/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// Minimal AdmissionReview HTTP response
+type AdmissionReviewHTTPResponse struct {
+	ApiVersion string                         `json:"apiVersion"`
+	Kind       string                         `json:"kind"`
+	Response   *admissionv1.AdmissionResponse `json:"response"`
+}
+
+// Returns HTTP request handler implementing a ValidatingAdmissionWebhook which applies the same policy as
+// /authorize to create, update, delete and connect operations. This is a second enforcement layer for
+// clusters where RBAC may grant access before the authorization webhook is consulted
+func CreateAdmissionHandler(config WebhookConfig) func(w http.ResponseWriter, r *http.Request) {
+	policies := config.policySource()
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		var review admissionv1.AdmissionReview
+		if err := json.NewDecoder(r.Body).Decode(&review); err != nil {
+			jsonErr := fmt.Errorf("JSON decoding error: %w", err)
+			log.Println(jsonErr)
+			server.WriteError(w, http.StatusBadRequest, jsonErr)
+			return
+		}
+		if review.APIVersion != "admission.k8s.io/v1" || review.Kind != "AdmissionReview" || review.Request == nil {
+			errString := "Malformed AdmissionReview. Currently support apiVersions: 'admission.k8s.io/v1'"
+			log.Println(errString)
+			server.WriteError(w, http.StatusBadRequest, errors.New(errString))
+			return
+		}
+
+		sar := admissionRequestToSAR(review.Request)
+		compiled := policies.Current()
+		authorized, denyReason := policy.IsRequestAuthorized(sar, compiled)
+		response := &admissionv1.AdmissionResponse{UID: review.Request.UID, Allowed: authorized}
+		status := authorizationv1.SubjectAccessReviewStatus{Denied: !authorized, Reason: denyReason}
+		if !authorized {
+			response.Result = &metav1.Status{
+				Status:  metav1.StatusFailure,
+				Message: denyReason,
+				Reason:  metav1.StatusReasonForbidden,
+				Code:    http.StatusForbidden,
+			}
+		} else {
+			status.Reason = "Admitted"
+		}
+
+		// Clusters are named as for authorization decisions, so the two can be correlated
+		identity := config.Clusters.Identify(r)
+		cluster := identity.String()
+		if identity == nil {
+			cluster = r.Header.Get("X-Forwarded-For")
+		}
+		if config.logLevel() >= 1 {
+			log.Println(decisionLogRecord{cluster: cluster, identity: identity, spec: &sar.Spec, status: &status, generation: compiled.Generation()})
+		}
+		if config.Audit != nil {
+			event := newAuditEvent(sar, cluster, status)
+			event.UID = review.Request.UID
+			event.Source = admissionEventSource
+			config.Audit.Publish(event)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(AdmissionReviewHTTPResponse{
+			ApiVersion: "admission.k8s.io/v1",
+			Kind:       "AdmissionReview",
+			Response:   response,
+		})
+	}
+}
+
+// Expresses admission request as the equivalent SubjectAccessReview so the same rules apply to both
+func admissionRequestToSAR(request *admissionv1.AdmissionRequest) policy.SubjectAccessReview {
+	verb := strings.ToLower(string(request.Operation))
+	if request.Operation == admissionv1.Connect {
+		// Connecting to pods/exec, pods/attach etc. is authorized as 'create' on the subresource
+		verb = "create"
+	}
+	var sar policy.SubjectAccessReview
+	sar.APIVersion = "authorization.k8s.io/v1"
+	sar.Kind = "SubjectAccessReview"
+	sar.Spec = policy.SubjectAccessReviewSpec{
+		User:   request.UserInfo.Username,
+		Groups: request.UserInfo.Groups,
+		UID:    request.UserInfo.UID,
+		ResourceAttributes: &authorizationv1.ResourceAttributes{
+			Namespace:   request.Namespace,
+			Verb:        verb,
+			Group:       request.Resource.Group,
+			Version:     request.Resource.Version,
+			Resource:    request.Resource.Resource,
+			Subresource: request.SubResource,
+			Name:        request.Name,
+		},
+	}
+	if len(request.UserInfo.Extra) > 0 {
+		sar.Spec.Extra = map[string]authorizationv1.ExtraValue{}
+		for key, value := range request.UserInfo.Extra {
+			sar.Spec.Extra[key] = authorizationv1.ExtraValue(value)
+		}
+	}
+	return sar
+}
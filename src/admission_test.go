@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+var DefaultAdmissionHandler = CreateAdmissionHandler(WebhookConfig{Config: DefaultPolicyConfig})
+
+func TestAdmissionDeleteInProtectedNamespaceDenied(t *testing.T) {
+	resp := admissionTest(t, DefaultAdmissionHandler, http.StatusOK,
+		[]byte(
+			`{
+			"apiVersion":"admission.k8s.io/v1",
+			"kind":"AdmissionReview",
+			"request":{
+				"uid":"705ab4f5-6393-11e8-b7cc-42010a800002",
+				"kind":{"group":"apps","version":"v1","kind":"Deployment"},
+				"resource":{"group":"apps","version":"v1","resource":"deployments"},
+				"name":"coredns",
+				"namespace":"kube-system",
+				"operation":"DELETE",
+				"userInfo":{"username":"kubernetes-not-admin","groups":["system:authenticated"]}
+			}
+			}`))
+	if resp.Response.Allowed || resp.Response.UID != "705ab4f5-6393-11e8-b7cc-42010a800002" {
+		t.Errorf("Expected delete to be denied with request UID echoed, got %+v", resp.Response)
+	}
+	if resp.Response.Result == nil || resp.Response.Result.Code != http.StatusForbidden {
+		t.Error("Expected 403 status in admission response")
+	}
+}
+
+func TestAdmissionCreateInUnprotectedNamespaceAllowed(t *testing.T) {
+	resp := admissionTest(t, DefaultAdmissionHandler, http.StatusOK,
+		[]byte(
+			`{
+			"apiVersion":"admission.k8s.io/v1",
+			"kind":"AdmissionReview",
+			"request":{
+				"uid":"1",
+				"kind":{"group":"","version":"v1","kind":"ConfigMap"},
+				"resource":{"group":"","version":"v1","resource":"configmaps"},
+				"name":"settings",
+				"namespace":"safe-namespace",
+				"operation":"CREATE",
+				"userInfo":{"username":"kubernetes-not-admin"}
+			}
+			}`))
+	if !resp.Response.Allowed {
+		t.Error("Expected create in unprotected namespace to be allowed")
+	}
+}
+
+func TestAdmissionExecInProtectedNamespaceDenied(t *testing.T) {
+	resp := admissionTest(t, DefaultAdmissionHandler, http.StatusOK,
+		[]byte(
+			`{
+			"apiVersion":"admission.k8s.io/v1",
+			"kind":"AdmissionReview",
+			"request":{
+				"uid":"2",
+				"kind":{"group":"","version":"v1","kind":"PodExecOptions"},
+				"resource":{"group":"","version":"v1","resource":"pods"},
+				"subResource":"exec",
+				"name":"etcd-0",
+				"namespace":"kube-system",
+				"operation":"CONNECT",
+				"userInfo":{"username":"kubernetes-not-admin"}
+			}
+			}`))
+	if resp.Response.Allowed {
+		t.Error("Expected exec into protected namespace to be denied")
+	}
+}
+
+func TestAdmissionReservedNamespaceCreationDenied(t *testing.T) {
+	config := DefaultPolicyConfig
+	config.NamespaceCreationProtection = true
+	handler := CreateAdmissionHandler(WebhookConfig{Config: config})
+	resp := admissionTest(t, handler, http.StatusOK,
+		[]byte(
+			`{
+			"apiVersion":"admission.k8s.io/v1",
+			"kind":"AdmissionReview",
+			"request":{
+				"uid":"1",
+				"kind":{"group":"","version":"v1","kind":"Namespace"},
+				"resource":{"group":"","version":"v1","resource":"namespaces"},
+				"name":"kube-system2",
+				"namespace":"kube-system2",
+				"operation":"CREATE",
+				"userInfo":{"username":"kubernetes-not-admin"}
+			}
+			}`))
+	if resp.Response.Allowed {
+		t.Error("Expected creating namespace extending a protected namespace's name to be denied")
+	}
+}
+
+func TestAdmissionSystemUserAllowed(t *testing.T) {
+	resp := admissionTest(t, DefaultAdmissionHandler, http.StatusOK,
+		[]byte(
+			`{
+			"apiVersion":"admission.k8s.io/v1",
+			"kind":"AdmissionReview",
+			"request":{
+				"uid":"3",
+				"kind":{"group":"","version":"v1","kind":"Pod"},
+				"resource":{"group":"","version":"v1","resource":"pods"},
+				"namespace":"kube-system",
+				"operation":"CREATE",
+				"userInfo":{"username":"system:serviceaccount:kube-system:replicaset-controller"}
+			}
+			}`))
+	if !resp.Response.Allowed {
+		t.Error("Expected protected namespace service account to be admitted")
+	}
+}
+
+func TestAdmissionMissingRequest(t *testing.T) {
+	admissionTest(t, DefaultAdmissionHandler, http.StatusBadRequest, []byte(`{"apiVersion":"admission.k8s.io/v1","kind":"AdmissionReview"}`))
+}
+
+func admissionTest(t *testing.T, handler func(w http.ResponseWriter, r *http.Request), expectedCode int, jsonData []byte) AdmissionReviewHTTPResponse {
+	req := httptest.NewRequest(http.MethodPost, "/admit", bytes.NewBuffer(jsonData))
+	req.Header.Set("Content-Type", "application/json")
+	resp := httptest.NewRecorder()
+
+	handler(resp, req)
+
+	if resp.Code != expectedCode {
+		t.Fatalf("Expected status %d, got %d", expectedCode, resp.Code)
+	}
+	var review AdmissionReviewHTTPResponse
+	_ = json.NewDecoder(resp.Body).Decode(&review)
+	return review
+}
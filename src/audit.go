@@ -0,0 +1,368 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"k8s.io/apimachinery/pkg/types"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Record of a single authorization decision, exported to audit sinks
+type AuditEvent struct {
+	Time            time.Time         `json:"time"`
+	Cluster         string            `json:"cluster,omitempty"`
+	ClusterLabels   map[string]string `json:"clusterLabels,omitempty"`
+	User            string            `json:"user"`
+	Groups          []string          `json:"groups,omitempty"`
+	Verb            string            `json:"verb,omitempty"`
+	Resource        string            `json:"resource,omitempty"`
+	Namespace       string            `json:"namespace,omitempty"`
+	Name            string            `json:"name,omitempty"`
+	NonResourcePath string            `json:"nonResourcePath,omitempty"`
+	Allowed         bool              `json:"allowed"`
+	Denied          bool              `json:"denied"`
+	Reason          string            `json:"reason,omitempty"`
+	// UID of the SubjectAccessReview, as given in deny reasons, or of the AdmissionReview
+	UID types.UID `json:"uid,omitempty"`
+	// "admission" for decisions of the admission endpoint, empty for authorization decisions
+	Source string `json:"source,omitempty"`
+	// Generation of the policy the decision was made with
+	PolicyGeneration uint64 `json:"policyGeneration,omitempty"`
+	// Name of the policy selected with the named policy header, empty for the webhook's own
+	Policy string `json:"policy,omitempty"`
+	// Names of the policy overlays applied for the calling cluster, in the order applied
+	Overlays []string `json:"overlays,omitempty"`
+	// Set for list and watch requests for secrets without a name or selector flagged by the policy
+	BulkSecretRead bool `json:"bulkSecretRead,omitempty"`
+	// Set for requests marked as simulations with the simulation header, which were answered with no opinion
+	Simulated bool `json:"simulated,omitempty"`
+	// Denials of the user by the same rule within --tarpit-window, set once past --tarpit-threshold
+	RepeatedDenials int `json:"repeatedDenials,omitempty"`
+}
+
+// Destination for batches of audit events. Write is only ever called from the pipeline's
+// worker goroutine so implementations don't need to be safe for concurrent use
+type AuditSink interface {
+	Name() string
+	Write(events []AuditEvent) error
+}
+
+// Behaviour of the audit pipeline when its queue is full
+type AuditOverflowPolicy string
+
+const (
+	// Discard the event being published
+	AuditDropNewest AuditOverflowPolicy = "drop-newest"
+	// Discard the oldest queued event to make room for the one being published
+	AuditDropOldest AuditOverflowPolicy = "drop-oldest"
+)
+
+var auditEventsDropped = Metrics.NewCounterVec("azimuth_authz_audit_events_dropped_total",
+	"Audit events discarded because the audit queue was full", "policy")
+var auditEventsWritten = Metrics.NewCounterVec("azimuth_authz_audit_events_written_total",
+	"Audit events successfully written to a sink", "sink")
+var auditSinkErrors = Metrics.NewCounterVec("azimuth_authz_audit_sink_errors_total",
+	"Failed audit batch writes", "sink")
+
+type AuditPipelineOptions struct {
+	QueueSize      int
+	BatchSize      int
+	FlushInterval  time.Duration
+	OverflowPolicy AuditOverflowPolicy
+}
+
+// Bounded, batching queue between the request path and audit sinks. Publishing never blocks,
+// so a slow or unavailable sink results in dropped events rather than slower decisions
+type AuditPipeline struct {
+	queue   chan AuditEvent
+	sinks   []AuditSink
+	options AuditPipelineOptions
+	done    chan struct{}
+	wg      sync.WaitGroup
+	closeMu sync.Once
+}
+
+// Creates audit pipeline and starts its worker goroutine. Returns nil if no sinks are given
+func NewAuditPipeline(sinks []AuditSink, options AuditPipelineOptions) *AuditPipeline {
+	if len(sinks) == 0 {
+		return nil
+	}
+	if options.QueueSize <= 0 {
+		options.QueueSize = 1024
+	}
+	if options.BatchSize <= 0 {
+		options.BatchSize = 100
+	}
+	if options.FlushInterval <= 0 {
+		options.FlushInterval = time.Second
+	}
+	if options.OverflowPolicy == "" {
+		options.OverflowPolicy = AuditDropNewest
+	}
+
+	p := &AuditPipeline{
+		queue:   make(chan AuditEvent, options.QueueSize),
+		sinks:   sinks,
+		options: options,
+		done:    make(chan struct{}),
+	}
+	Metrics.NewGaugeFunc("azimuth_authz_audit_queue_length", "Audit events waiting to be exported",
+		func() float64 { return float64(len(p.queue)) })
+
+	p.wg.Add(1)
+	go p.run()
+	return p
+}
+
+// Queues event for export, applying the overflow policy if the queue is full. Safe to call on a nil pipeline
+func (p *AuditPipeline) Publish(event AuditEvent) {
+	if p == nil {
+		return
+	}
+	select {
+	case p.queue <- event:
+		return
+	default:
+	}
+
+	auditEventsDropped.Inc(string(p.options.OverflowPolicy))
+	if p.options.OverflowPolicy == AuditDropOldest {
+		select {
+		case <-p.queue:
+		default:
+		}
+		// Another publisher may have claimed the freed slot, in which case this event is lost too
+		select {
+		case p.queue <- event:
+		default:
+			auditEventsDropped.Inc(string(p.options.OverflowPolicy))
+		}
+	}
+}
+
+// Stops accepting events, flushes anything still queued and waits for the worker to exit
+func (p *AuditPipeline) Close() {
+	if p == nil {
+		return
+	}
+	p.closeMu.Do(func() { close(p.done) })
+	p.wg.Wait()
+}
+
+func (p *AuditPipeline) run() {
+	defer p.wg.Done()
+	ticker := time.NewTicker(p.options.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]AuditEvent, 0, p.options.BatchSize)
+	for {
+		select {
+		case event := <-p.queue:
+			batch = append(batch, event)
+			if len(batch) >= p.options.BatchSize {
+				batch = p.flush(batch)
+			}
+		case <-ticker.C:
+			batch = p.flush(batch)
+		case <-p.done:
+			for {
+				select {
+				case event := <-p.queue:
+					batch = append(batch, event)
+					if len(batch) >= p.options.BatchSize {
+						batch = p.flush(batch)
+					}
+				default:
+					p.flush(batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+func (p *AuditPipeline) flush(batch []AuditEvent) []AuditEvent {
+	if len(batch) == 0 {
+		return batch
+	}
+	for _, sink := range p.sinks {
+		if err := sink.Write(batch); err != nil {
+			auditSinkErrors.Inc(sink.Name())
+			log.Println("Error writing audit events to "+sink.Name()+" sink:", err)
+			continue
+		}
+		auditEventsWritten.Add(uint64(len(batch)), sink.Name())
+	}
+	return batch[:0]
+}
+
+// Writes audit events as JSON lines to a file, or stdout if path is "-"
+type FileAuditSink struct {
+	file    *os.File
+	encoder *json.Encoder
+}
+
+func NewFileAuditSink(path string) (*FileAuditSink, error) {
+	file := os.Stdout
+	if path != "-" {
+		var err error
+		file, err = os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &FileAuditSink{file: file, encoder: json.NewEncoder(file)}, nil
+}
+
+func (s *FileAuditSink) Name() string { return "file" }
+
+func (s *FileAuditSink) Write(events []AuditEvent) error {
+	for _, event := range events {
+		if err := s.encoder.Encode(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Pushes audit events to a Grafana Loki instance using its JSON push API
+type LokiAuditSink struct {
+	pushURL string
+	client  *OutboundClient
+}
+
+func NewLokiAuditSink(url string, client *OutboundClient) *LokiAuditSink {
+	return &LokiAuditSink{pushURL: url + "/loki/api/v1/push", client: client}
+}
+
+func (s *LokiAuditSink) Name() string { return "loki" }
+
+type lokiPushRequest struct {
+	Streams []lokiStream `json:"streams"`
+}
+
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+func (s *LokiAuditSink) Write(events []AuditEvent) error {
+	stream := lokiStream{Stream: map[string]string{"job": "azimuth-authorization-webhook"}}
+	for _, event := range events {
+		line, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		stream.Values = append(stream.Values, [2]string{strconv.FormatInt(event.Time.UnixNano(), 10), string(line)})
+	}
+	body, err := json.Marshal(lokiPushRequest{Streams: []lokiStream{stream}})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.pushURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do("loki", 0, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status from Loki: %s", resp.Status)
+	}
+	return nil
+}
+
+type AzimuthAuditSinkOptions struct {
+	URL         string
+	BearerToken string
+	// Attempts after the first failed POST of a batch
+	MaxRetries int
+	// Delay before the first retry, doubled for each subsequent one
+	RetryBackoff time.Duration
+}
+
+// POSTs batches of audit events to the Azimuth audit API, which presents per-tenant authorization
+// history in the Azimuth UI. Batches are retried on connection errors and 5xx or 429 responses; every
+// attempt carries the same Idempotency-Key so that the service can discard duplicates
+type AzimuthAuditSink struct {
+	options AzimuthAuditSinkOptions
+	client  *OutboundClient
+}
+
+func NewAzimuthAuditSink(options AzimuthAuditSinkOptions, client *OutboundClient) *AzimuthAuditSink {
+	if options.MaxRetries < 0 {
+		options.MaxRetries = 0
+	}
+	if options.RetryBackoff <= 0 {
+		options.RetryBackoff = 500 * time.Millisecond
+	}
+	return &AzimuthAuditSink{options: options, client: client}
+}
+
+func (s *AzimuthAuditSink) Name() string { return "azimuth" }
+
+type azimuthAuditRequest struct {
+	Source string       `json:"source"`
+	Events []AuditEvent `json:"events"`
+}
+
+func (s *AzimuthAuditSink) Write(events []AuditEvent) error {
+	body, err := json.Marshal(azimuthAuditRequest{Source: "azimuth-authorization-webhook", Events: events})
+	if err != nil {
+		return err
+	}
+	key := make([]byte, 16)
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+	idempotencyKey := hex.EncodeToString(key)
+
+	backoff := s.options.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		retryable, err := s.post(body, idempotencyKey)
+		if err == nil {
+			return nil
+		}
+		if !retryable || attempt >= s.options.MaxRetries {
+			return err
+		}
+		log.Printf("Retrying audit batch for Azimuth after error: %s\n", err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// Returns whether a failed POST is worth retrying
+func (s *AzimuthAuditSink) post(body []byte, idempotencyKey string) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, s.options.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", idempotencyKey)
+	if s.options.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+s.options.BearerToken)
+	}
+	resp, err := s.client.Do("azimuth-audit", 0, req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		retryable := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+		return retryable, fmt.Errorf("unexpected status from Azimuth audit service: %s", resp.Status)
+	}
+	return false, nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordingSink struct {
+	mu      sync.Mutex
+	batches [][]AuditEvent
+	block   chan struct{}
+}
+
+func (s *recordingSink) Name() string { return "recording" }
+
+func (s *recordingSink) Write(events []AuditEvent) error {
+	if s.block != nil {
+		<-s.block
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.batches = append(s.batches, append([]AuditEvent(nil), events...))
+	return nil
+}
+
+func (s *recordingSink) users() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var users []string
+	for _, batch := range s.batches {
+		for _, event := range batch {
+			users = append(users, event.User)
+		}
+	}
+	return users
+}
+
+func TestAuditPipelineBatchesAndFlushesOnClose(t *testing.T) {
+	sink := &recordingSink{}
+	pipeline := NewAuditPipeline([]AuditSink{sink}, AuditPipelineOptions{BatchSize: 2, FlushInterval: time.Hour})
+	for _, user := range []string{"a", "b", "c"} {
+		pipeline.Publish(AuditEvent{User: user})
+	}
+	pipeline.Close()
+
+	if users := sink.users(); len(users) != 3 {
+		t.Fatalf("Expected 3 audited events, got %v", users)
+	}
+	if len(sink.batches) != 2 || len(sink.batches[0]) != 2 {
+		t.Errorf("Expected events to be written in batches of 2, got %v", sink.batches)
+	}
+}
+
+func TestAuditPipelineDropsNewestWhenFull(t *testing.T) {
+	sink := &recordingSink{block: make(chan struct{})}
+	pipeline := NewAuditPipeline([]AuditSink{sink}, AuditPipelineOptions{QueueSize: 1, BatchSize: 1, OverflowPolicy: AuditDropNewest})
+	dropsBefore := auditEventsDropped.Value(string(AuditDropNewest))
+
+	// First event is taken by the worker, which then blocks in the sink
+	pipeline.Publish(AuditEvent{User: "first"})
+	waitForEmptyQueue(t, pipeline)
+	pipeline.Publish(AuditEvent{User: "queued"})
+	pipeline.Publish(AuditEvent{User: "dropped"})
+
+	close(sink.block)
+	pipeline.Close()
+
+	if drops := auditEventsDropped.Value(string(AuditDropNewest)) - dropsBefore; drops != 1 {
+		t.Errorf("Expected 1 dropped event, got %d", drops)
+	}
+	if users := sink.users(); len(users) != 2 || users[1] != "queued" {
+		t.Errorf("Expected newest event to be dropped, got %v", users)
+	}
+}
+
+func TestAuditPipelineDropsOldestWhenFull(t *testing.T) {
+	sink := &recordingSink{block: make(chan struct{})}
+	pipeline := NewAuditPipeline([]AuditSink{sink}, AuditPipelineOptions{QueueSize: 1, BatchSize: 1, OverflowPolicy: AuditDropOldest})
+
+	pipeline.Publish(AuditEvent{User: "first"})
+	waitForEmptyQueue(t, pipeline)
+	pipeline.Publish(AuditEvent{User: "dropped"})
+	pipeline.Publish(AuditEvent{User: "latest"})
+
+	close(sink.block)
+	pipeline.Close()
+
+	if users := sink.users(); len(users) != 2 || users[1] != "latest" {
+		t.Errorf("Expected oldest queued event to be dropped, got %v", users)
+	}
+}
+
+func TestAuditPublishDoesNotBlockOnSlowSink(t *testing.T) {
+	sink := &recordingSink{block: make(chan struct{})}
+	pipeline := NewAuditPipeline([]AuditSink{sink}, AuditPipelineOptions{QueueSize: 1, BatchSize: 1})
+	defer pipeline.Close()
+	defer close(sink.block)
+
+	start := time.Now()
+	for i := 0; i < 100; i++ {
+		pipeline.Publish(AuditEvent{User: "user"})
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Publishing to a blocked pipeline took %s", elapsed)
+	}
+}
+
+func TestAzimuthAuditSinkRetriesServerErrors(t *testing.T) {
+	var keys []string
+	var received azimuthAuditRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys = append(keys, r.Header.Get("Idempotency-Key"))
+		if r.Header.Get("Authorization") != "Bearer audit-token" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if len(keys) < 3 {
+			http.Error(w, "Unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&received)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	options := AzimuthAuditSinkOptions{URL: server.URL, BearerToken: "audit-token", MaxRetries: 3, RetryBackoff: time.Millisecond}
+	sink := NewAzimuthAuditSink(options, NewOutboundClient(DefaultOutboundClientOptions))
+	if err := sink.Write([]AuditEvent{{User: "alice", Namespace: "az-demo"}, {User: "bob"}}); err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 3 || keys[0] == "" || keys[0] != keys[2] {
+		t.Errorf("Expected 3 attempts with the same idempotency key, got %v", keys)
+	}
+	if len(received.Events) != 2 || received.Events[0].Namespace != "az-demo" {
+		t.Errorf("Expected batch of 2 events, got %+v", received)
+	}
+
+	// Client errors aren't retried
+	keys = nil
+	options.BearerToken = "wrong"
+	sink = NewAzimuthAuditSink(options, NewOutboundClient(DefaultOutboundClientOptions))
+	if err := sink.Write([]AuditEvent{{User: "alice"}}); err == nil || len(keys) != 1 {
+		t.Errorf("Expected single failed attempt, got %d attempts and error %v", len(keys), err)
+	}
+}
+
+func waitForEmptyQueue(t *testing.T, pipeline *AuditPipeline) {
+	deadline := time.Now().Add(time.Second)
+	for len(pipeline.queue) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Audit worker did not consume queued event")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/server"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// Backend able to validate bearer tokens. Returns false with nil error if the token is simply not
+// recognised, so the next backend can be tried
+type TokenAuthenticator interface {
+	Name() string
+	AuthenticateToken(ctx context.Context, token string) (*authenticationv1.UserInfo, bool, error)
+}
+
+var tokenReviews = Metrics.NewCounterVec("azimuth_authn_token_reviews_total",
+	"TokenReview results, by authenticating backend", "backend", "result")
+
+// Minimal TokenReview HTTP response
+type TokenReviewHTTPResponse struct {
+	ApiVersion string                             `json:"apiVersion"`
+	Kind       string                             `json:"kind"`
+	Status     authenticationv1.TokenReviewStatus `json:"status"`
+}
+
+// Returns HTTP request handler implementing the Kubernetes TokenReview webhook API. Backends
+// are tried in order and the first to recognise the token determines the user
+func CreateWebhookAuthenticator(authenticators []TokenAuthenticator, logLevel int) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		var review authenticationv1.TokenReview
+		if err := json.NewDecoder(r.Body).Decode(&review); err != nil {
+			jsonErr := fmt.Errorf("JSON decoding error: %w", err)
+			log.Println(jsonErr)
+			server.WriteError(w, http.StatusBadRequest, jsonErr)
+			return
+		}
+		if review.APIVersion != "authentication.k8s.io/v1" || review.Kind != "TokenReview" {
+			errString := "Malformed TokenReview. Currently support apiVersions: 'authentication.k8s.io/v1'"
+			log.Println(errString)
+			server.WriteError(w, http.StatusBadRequest, errors.New(errString))
+			return
+		}
+
+		response := TokenReviewHTTPResponse{ApiVersion: "authentication.k8s.io/v1", Kind: "TokenReview"}
+		response.Status.Audiences = review.Spec.Audiences
+		var backendErrors []string
+		for _, authenticator := range authenticators {
+			user, ok, err := authenticator.AuthenticateToken(r.Context(), review.Spec.Token)
+			if err != nil {
+				tokenReviews.Inc(authenticator.Name(), "error")
+				log.Println("Error authenticating token with "+authenticator.Name()+" backend:", err)
+				backendErrors = append(backendErrors, authenticator.Name()+": "+err.Error())
+				continue
+			}
+			if ok {
+				tokenReviews.Inc(authenticator.Name(), "authenticated")
+				response.Status.Authenticated = true
+				response.Status.User = *user
+				break
+			}
+		}
+		if !response.Status.Authenticated {
+			tokenReviews.Inc("none", "unauthenticated")
+			response.Status.Error = strings.Join(backendErrors, "; ")
+		}
+
+		if logLevel >= 1 {
+			if response.Status.Authenticated {
+				log.Printf("[Cluster: %s] Authenticated token for %s\n", r.Header.Get("X-Forwarded-For"), response.Status.User.Username)
+			} else {
+				log.Printf("[Cluster: %s] Rejected unrecognised token\n", r.Header.Get("X-Forwarded-For"))
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// Authenticates tokens listed in a CSV file in the same format as kube-apiserver's --token-auth-file:
+// token,user,uid,"group1,group2"
+type StaticTokenAuthenticator struct {
+	users map[string]authenticationv1.UserInfo
+}
+
+func NewStaticTokenAuthenticator(path string) (*StaticTokenAuthenticator, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	reader.Comment = '#'
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("parsing token file %s: %w", path, err)
+	}
+
+	users := map[string]authenticationv1.UserInfo{}
+	for i, record := range records {
+		if len(record) < 3 || record[0] == "" || record[1] == "" {
+			return nil, fmt.Errorf("parsing token file %s: line %d needs at least token, user and uid", path, i+1)
+		}
+		user := authenticationv1.UserInfo{Username: record[1], UID: record[2]}
+		if len(record) > 3 && record[3] != "" {
+			user.Groups = strings.Split(record[3], ",")
+		}
+		users[record[0]] = user
+	}
+	return &StaticTokenAuthenticator{users: users}, nil
+}
+
+func (a *StaticTokenAuthenticator) Name() string { return "static" }
+
+func (a *StaticTokenAuthenticator) AuthenticateToken(_ context.Context, token string) (*authenticationv1.UserInfo, bool, error) {
+	user, ok := a.users[token]
+	if !ok {
+		return nil, false, nil
+	}
+	return &user, true, nil
+}
+
+// Authenticates tokens using an OAuth 2.0 token introspection endpoint (RFC 7662), as provided by
+// most OIDC identity providers
+type OIDCIntrospectionAuthenticator struct {
+	IntrospectionURL string
+	ClientID         string
+	ClientSecret     string
+	// Claim used as the Kubernetes username, defaults to 'sub'
+	UsernameClaim  string
+	UsernamePrefix string
+	// Claim holding a list of groups, groups are not set if empty
+	GroupsClaim  string
+	GroupsPrefix string
+	Client       *OutboundClient
+}
+
+func (a *OIDCIntrospectionAuthenticator) Name() string { return "oidc" }
+
+func (a *OIDCIntrospectionAuthenticator) AuthenticateToken(ctx context.Context, token string) (*authenticationv1.UserInfo, bool, error) {
+	form := url.Values{"token": {token}, "token_type_hint": {"access_token"}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.IntrospectionURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, false, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(a.ClientID), url.QueryEscape(a.ClientSecret))
+
+	resp, err := a.Client.Do("oidc-introspection", 0, req)
+	if err != nil {
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, false, fmt.Errorf("unexpected status from introspection endpoint: %s", resp.Status)
+	}
+
+	var claims map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&claims); err != nil {
+		return nil, false, fmt.Errorf("decoding introspection response: %w", err)
+	}
+	if active, _ := claims["active"].(bool); !active {
+		return nil, false, nil
+	}
+
+	usernameClaim := a.UsernameClaim
+	if usernameClaim == "" {
+		usernameClaim = "sub"
+	}
+	username, _ := claims[usernameClaim].(string)
+	if username == "" {
+		return nil, false, fmt.Errorf("introspection response has no %q claim", usernameClaim)
+	}
+	user := &authenticationv1.UserInfo{Username: a.UsernamePrefix + username}
+	if sub, ok := claims["sub"].(string); ok {
+		user.UID = sub
+	}
+	if issuer, ok := claims["iss"].(string); ok {
+		user.Extra = map[string]authenticationv1.ExtraValue{OIDCIssuerExtraKey: {issuer}}
+	}
+	if a.GroupsClaim != "" {
+		for _, group := range stringListClaim(claims[a.GroupsClaim]) {
+			user.Groups = append(user.Groups, a.GroupsPrefix+group)
+		}
+	}
+	return user, true, nil
+}
+
+// Returns claim value as a list of strings, accepting either a JSON list or a single string
+func stringListClaim(claim any) []string {
+	switch value := claim.(type) {
+	case string:
+		return []string{value}
+	case []any:
+		var values []string
+		for _, item := range value {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// Authenticates OpenStack Keystone tokens using the identity v3 token validation API. Users are
+// given the Keystone project ID as their group, with project and role details as extras, matching
+// the conventions of k8s-keystone-auth
+type KeystoneAuthenticator struct {
+	// Keystone endpoint including version, e.g. https://keystone.example.com:5000/v3
+	URL    string
+	Client *OutboundClient
+}
+
+func (a *KeystoneAuthenticator) Name() string { return "keystone" }
+
+type keystoneTokenResponse struct {
+	Token struct {
+		User struct {
+			ID   string `json:"id"`
+			Name string `json:"name"`
+		} `json:"user"`
+		Project *struct {
+			ID   string `json:"id"`
+			Name string `json:"name"`
+		} `json:"project"`
+		Roles []struct {
+			Name string `json:"name"`
+		} `json:"roles"`
+	} `json:"token"`
+}
+
+func (a *KeystoneAuthenticator) AuthenticateToken(ctx context.Context, token string) (*authenticationv1.UserInfo, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(a.URL, "/")+"/auth/tokens", nil)
+	if err != nil {
+		return nil, false, err
+	}
+	// Keystone allows a token to validate itself
+	req.Header.Set("X-Auth-Token", token)
+	req.Header.Set("X-Subject-Token", token)
+
+	resp, err := a.Client.Do("keystone", 0, req)
+	if err != nil {
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusNotFound:
+		io.Copy(io.Discard, resp.Body)
+		return nil, false, nil
+	case resp.StatusCode != http.StatusOK:
+		return nil, false, fmt.Errorf("unexpected status from Keystone: %s", resp.Status)
+	}
+
+	var body keystoneTokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, false, fmt.Errorf("decoding Keystone token: %w", err)
+	}
+	user := &authenticationv1.UserInfo{
+		Username: body.Token.User.Name,
+		UID:      body.Token.User.ID,
+		Extra:    map[string]authenticationv1.ExtraValue{},
+	}
+	if body.Token.Project != nil {
+		user.Groups = []string{body.Token.Project.ID}
+		user.Extra["alpha.kubernetes.io/identity/project/id"] = authenticationv1.ExtraValue{body.Token.Project.ID}
+		user.Extra["alpha.kubernetes.io/identity/project/name"] = authenticationv1.ExtraValue{body.Token.Project.Name}
+	}
+	var roles authenticationv1.ExtraValue
+	for _, role := range body.Token.Roles {
+		roles = append(roles, role.Name)
+	}
+	if len(roles) > 0 {
+		user.Extra["alpha.kubernetes.io/identity/roles"] = roles
+	}
+	return user, true, nil
+}
+
+// Reads a secret from file, trimming surrounding whitespace. Returns empty string for empty path
+func readSecretFile(path string) (string, error) {
+	if path == "" {
+		return "", nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return string(bytes.TrimSpace(data)), nil
+}
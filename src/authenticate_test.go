@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStaticTokenAuthenticated(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.csv")
+	os.WriteFile(path, []byte("# token,user,uid,groups\nsecret-token,alice,1001,\"admins,devs\"\n"), 0o600)
+	static, err := NewStaticTokenAuthenticator(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authenticator := CreateWebhookAuthenticator([]TokenAuthenticator{static}, 0)
+
+	resp := tokenReviewTest(t, authenticator, http.StatusOK, []byte(`{"apiVersion":"authentication.k8s.io/v1","kind":"TokenReview","spec":{"token":"secret-token"}}`))
+	if !resp.Status.Authenticated || resp.Status.User.Username != "alice" || len(resp.Status.User.Groups) != 2 {
+		t.Errorf("Expected token to authenticate alice with 2 groups, got %+v", resp.Status)
+	}
+
+	resp = tokenReviewTest(t, authenticator, http.StatusOK, []byte(`{"apiVersion":"authentication.k8s.io/v1","kind":"TokenReview","spec":{"token":"wrong-token"}}`))
+	if resp.Status.Authenticated {
+		t.Error("Expected unknown token to be rejected")
+	}
+}
+
+func TestOIDCIntrospectionAuthenticated(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clientID, clientSecret, _ := r.BasicAuth()
+		if clientID != "webhook" || clientSecret != "s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		r.ParseForm()
+		if r.PostForm.Get("token") != "oidc-token" {
+			json.NewEncoder(w).Encode(map[string]any{"active": false})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"active": true, "sub": "1234", "email": "bob@example.com", "groups": []string{"platform-admins"}})
+	}))
+	defer server.Close()
+
+	oidc := &OIDCIntrospectionAuthenticator{
+		IntrospectionURL: server.URL,
+		ClientID:         "webhook",
+		ClientSecret:     "s3cret",
+		UsernameClaim:    "email",
+		UsernamePrefix:   "oidc:",
+		GroupsClaim:      "groups",
+		GroupsPrefix:     "oidc:",
+		Client:           NewOutboundClient(DefaultOutboundClientOptions),
+	}
+	authenticator := CreateWebhookAuthenticator([]TokenAuthenticator{oidc}, 0)
+
+	resp := tokenReviewTest(t, authenticator, http.StatusOK, []byte(`{"apiVersion":"authentication.k8s.io/v1","kind":"TokenReview","spec":{"token":"oidc-token"}}`))
+	user := resp.Status.User
+	if !resp.Status.Authenticated || user.Username != "oidc:bob@example.com" || user.UID != "1234" || len(user.Groups) != 1 || user.Groups[0] != "oidc:platform-admins" {
+		t.Errorf("Unexpected OIDC user %+v", resp.Status)
+	}
+
+	resp = tokenReviewTest(t, authenticator, http.StatusOK, []byte(`{"apiVersion":"authentication.k8s.io/v1","kind":"TokenReview","spec":{"token":"expired"}}`))
+	if resp.Status.Authenticated {
+		t.Error("Expected inactive token to be rejected")
+	}
+}
+
+func TestKeystoneAuthenticatedAfterOtherBackendFails(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v3/auth/tokens" || r.Header.Get("X-Subject-Token") != "keystone-token" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"token":{"user":{"id":"u1","name":"carol"},"project":{"id":"p1","name":"tenant"},"roles":[{"name":"member"},{"name":"k8s_admin"}]}}`))
+	}))
+	defer server.Close()
+
+	broken := &OIDCIntrospectionAuthenticator{IntrospectionURL: "http://127.0.0.1:1", Client: NewOutboundClient(DefaultOutboundClientOptions)}
+	keystone := &KeystoneAuthenticator{URL: server.URL + "/v3", Client: NewOutboundClient(DefaultOutboundClientOptions)}
+	authenticator := CreateWebhookAuthenticator([]TokenAuthenticator{broken, keystone}, 0)
+
+	resp := tokenReviewTest(t, authenticator, http.StatusOK, []byte(`{"apiVersion":"authentication.k8s.io/v1","kind":"TokenReview","spec":{"token":"keystone-token"}}`))
+	user := resp.Status.User
+	if !resp.Status.Authenticated || user.Username != "carol" || len(user.Groups) != 1 || user.Groups[0] != "p1" {
+		t.Errorf("Unexpected Keystone user %+v", resp.Status)
+	}
+	if roles := user.Extra["alpha.kubernetes.io/identity/roles"]; len(roles) != 2 {
+		t.Errorf("Expected Keystone roles in extras, got %v", user.Extra)
+	}
+}
+
+func TestMalformedTokenReview(t *testing.T) {
+	authenticator := CreateWebhookAuthenticator(nil, 0)
+	tokenReviewTest(t, authenticator, http.StatusBadRequest, []byte(`{"apiVersion":"authorization.k8s.io/v1","kind":"SubjectAccessReview"}`))
+	tokenReviewTest(t, authenticator, http.StatusBadRequest, []byte(`{bad json}`))
+}
+
+func tokenReviewTest(t *testing.T, authenticator func(w http.ResponseWriter, r *http.Request), expectedCode int, jsonData []byte) TokenReviewHTTPResponse {
+	req := httptest.NewRequest(http.MethodPost, "/authenticate", bytes.NewBuffer(jsonData))
+	req.Header.Set("Content-Type", "application/json")
+	resp := httptest.NewRecorder()
+
+	authenticator(resp, req)
+
+	if resp.Code != expectedCode {
+		t.Fatalf("Expected status %d, got %d", expectedCode, resp.Code)
+	}
+	var review TokenReviewHTTPResponse
+	_ = json.NewDecoder(resp.Body).Decode(&review)
+	return review
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/policy"
+	"context"
+	"fmt"
+)
+
+// Authorizers which can be chained by name with --authorizers. Each returns nil if it isn't configured
+var authorizerRegistry = map[string]func(config WebhookConfig) policy.Authorizer{
+	"rules": func(config WebhookConfig) policy.Authorizer {
+		rules := policy.RulesAuthorizer{Source: config.policySource()}
+		if len(config.Privileges) == 0 {
+			return rules
+		}
+		return exemptPrivileged(rules)
+	},
+	"tenancy": func(config WebhookConfig) policy.Authorizer {
+		if config.Tenancy == nil {
+			return nil
+		}
+		return config.Tenancy
+	},
+	"hooks": func(config WebhookConfig) policy.Authorizer {
+		if len(config.Hooks) == 0 {
+			return nil
+		}
+		return config.Hooks
+	},
+	"delegate": func(config WebhookConfig) policy.Authorizer {
+		if config.Delegate == nil {
+			return nil
+		}
+		return config.Delegate
+	},
+}
+
+// Authorizers chained when none are named, in the order they are consulted
+var defaultAuthorizers = []string{"rules", "tenancy", "hooks", "delegate"}
+
+// Returns error if any name isn't a registered authorizer
+func validateAuthorizerNames(names []string) error {
+	for _, name := range names {
+		if _, ok := authorizerRegistry[name]; !ok {
+			return fmt.Errorf("unknown authorizer %q, expected one of %v", name, sortedKeys(authorizerRegistry))
+		}
+	}
+	return nil
+}
+
+// Returns chain of the named authorizers, or the default ones if names is empty, skipping any which
+// aren't configured
+func authorizerChain(config WebhookConfig, names []string) policy.Chain {
+	if len(names) == 0 {
+		names = defaultAuthorizers
+	}
+	var chain policy.Chain
+	for _, name := range names {
+		if factory, ok := authorizerRegistry[name]; ok {
+			if authorizer := factory(config); authorizer != nil {
+				chain = append(chain, authorizer)
+			}
+		}
+	}
+	return chain
+}
+
+type privilegeCheckKey struct{}
+
+// Returns ctx carrying check of whether the request's user is privileged, which authorizers share so
+// privilege backends are consulted at most once per request
+func withPrivilegeCheck(ctx context.Context, check func() bool) context.Context {
+	return context.WithValue(ctx, privilegeCheckKey{}, check)
+}
+
+// Returns true if the request's user is privileged. Users are unprivileged if ctx has no check
+func isPrivileged(ctx context.Context) bool {
+	check, ok := ctx.Value(privilegeCheckKey{}).(func() bool)
+	return ok && check()
+}
+
+// Returns authorizer which has no opinion, rather than denying, requests from privileged users, except for
+// denials which apply to privileged users too, such as deleting protected namespaces
+func exemptPrivileged(authorizer policy.Authorizer) policy.Authorizer {
+	return policy.AuthorizerFunc(func(ctx context.Context, spec *policy.SubjectAccessReviewSpec) policy.Decision {
+		decision := authorizer.Authorize(ctx, spec)
+		if decision.Verdict == policy.Deny && !decision.Unexemptible && isPrivileged(ctx) {
+			return policy.Decision{}
+		}
+		return decision
+	})
+}
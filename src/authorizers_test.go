@@ -0,0 +1,86 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/policy"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"testing"
+)
+
+const protectedWriteSAR = `{
+	"kind":"SubjectAccessReview",
+	"apiVersion":"authorization.k8s.io/v1",
+	"spec":{
+		"resourceAttributes":{"namespace":"kube-system","verb":"create","resource":"pods"},
+		"user":"tenant-user"
+	}
+}`
+
+func TestAuthorizerOrder(t *testing.T) {
+	upstream := newTestUpstream(t, authorizationv1.SubjectAccessReviewStatus{Allowed: true}, nil)
+	defer upstream.Close()
+	config := WebhookConfig{
+		Config:   DefaultPolicyConfig,
+		Delegate: &UpstreamDelegate{URL: upstream.URL, Client: NewOutboundClient(DefaultOutboundClientOptions)},
+	}
+
+	// By default local denials are never forwarded
+	accessTest(t, CreateWebhookAuthorizer(config), true, []byte(protectedWriteSAR))
+	// Consulted first, the upstream's allow decides the request
+	config.Authorizers = []string{"delegate", "rules"}
+	accessTest(t, CreateWebhookAuthorizer(config), false, []byte(protectedWriteSAR))
+	// Without the rules, nothing is denied
+	config.Authorizers = []string{"tenancy"}
+	accessTest(t, CreateWebhookAuthorizer(config), false, []byte(protectedWriteSAR))
+}
+
+func TestUnknownAuthorizerName(t *testing.T) {
+	if err := validateAuthorizerNames([]string{"rules", "opa"}); err == nil {
+		t.Error("Expected unknown authorizer to be rejected")
+	}
+	if err := validateAuthorizerNames(defaultAuthorizers); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestImpersonatorMustBePrivilegedToo(t *testing.T) {
+	evaluate := newEvaluator(WebhookConfig{
+		Config:     DefaultPolicyConfig,
+		Privileges: PrivilegeResolvers{NewOIDCClaimResolver(OIDCClaimResolverOptions{PrivilegedGroups: []string{"platform-admins"}})},
+	})
+	for impersonatorGroup, expectDenied := range map[string]bool{"developers": true, "platform-admins": false} {
+		sar := policy.SubjectAccessReview{Spec: policy.SubjectAccessReviewSpec{
+			User:               "alice",
+			Groups:             []string{"platform-admins"},
+			ResourceAttributes: &authorizationv1.ResourceAttributes{Namespace: "kube-system", Verb: "create", Resource: "pods"},
+			Extra: map[string]authorizationv1.ExtraValue{
+				policy.ImpersonatorUserExtraKey:   {"bob"},
+				policy.ImpersonatorGroupsExtraKey: {impersonatorGroup},
+			},
+		}}
+		if status := evaluate(t.Context(), sar); status.Denied != expectDenied {
+			t.Errorf("Expected denied=%v when impersonated by a member of %s, got %+v", expectDenied, impersonatorGroup, status)
+		}
+	}
+}
+
+func TestResolvedPrivilegesDontExemptNamespaceDeletion(t *testing.T) {
+	policyConfig := DefaultPolicyConfig
+	policyConfig.NamespaceDeletionProtection = true
+	evaluate := newEvaluator(WebhookConfig{
+		Config:     policyConfig,
+		Privileges: PrivilegeResolvers{NewOIDCClaimResolver(OIDCClaimResolverOptions{PrivilegedGroups: []string{"platform-admins"}})},
+	})
+	request := func(verb string, resource string, name string) policy.SubjectAccessReview {
+		return policy.SubjectAccessReview{Spec: policy.SubjectAccessReviewSpec{
+			User:               "alice",
+			Groups:             []string{"platform-admins"},
+			ResourceAttributes: &authorizationv1.ResourceAttributes{Namespace: "kube-system", Verb: verb, Resource: resource, Name: name},
+		}}
+	}
+	if status := evaluate(t.Context(), request("create", "pods", "")); status.Denied {
+		t.Errorf("Expected resolved privileges to exempt writes to protected namespaces, got %+v", status)
+	}
+	if status := evaluate(t.Context(), request("delete", "namespaces", "kube-system")); !status.Denied {
+		t.Errorf("Expected deleting a protected namespace to be denied despite resolved privileges, got %+v", status)
+	}
+}
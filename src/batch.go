@@ -0,0 +1,128 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/policy"
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/server"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"log"
+	"net/http"
+	"sync"
+)
+
+// Request body of the /authorize/batch endpoint
+type BatchAuthorizeRequest struct {
+	Items []policy.SubjectAccessReview `json:"items"`
+}
+
+// Decision for a single batch item. Error is set instead of a meaningful status if the item
+// could not be evaluated
+type BatchAuthorizeResponseItem struct {
+	server.SubjectAccessReviewResponse
+	Error string `json:"error,omitempty"`
+}
+
+// Response body of the /authorize/batch endpoint, items are in the same order as the request
+type BatchAuthorizeResponse struct {
+	Items []BatchAuthorizeResponseItem `json:"items"`
+}
+
+// Returns HTTP request handler which evaluates a list of SubjectAccessReviews, intended for
+// simulation tooling and "what can I do" views. Items are decided with every check /authorize makes, so
+// the answers agree with what it enforces. Decisions made here are not audited as they don't correspond
+// to real API requests
+func CreateBatchAuthorizer(config WebhookConfig, maxItems int, concurrency int) func(w http.ResponseWriter, r *http.Request) {
+	config.Policy = config.policySource()
+	evaluate := withDenyReasonHelp(config, withDenyReasonReferences(config, newEvaluator(config)))
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	var compatible []string
+	for _, field := range server.CompatibleSubjectAccessReviewFields {
+		compatible = append(compatible, "items."+field)
+	}
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		var batch BatchAuthorizeRequest
+		if err := server.DecodeJSON(r.Body, &batch, compatible); err != nil {
+			jsonErr := fmt.Errorf("JSON decoding error: %w", err)
+			log.Println(jsonErr)
+			server.WriteError(w, http.StatusBadRequest, jsonErr)
+			return
+		}
+		if len(batch.Items) > maxItems {
+			errString := fmt.Sprintf("Batch of %d items exceeds limit of %d", len(batch.Items), maxItems)
+			log.Println(errString)
+			server.WriteError(w, http.StatusRequestEntityTooLarge, errors.New(errString))
+			return
+		}
+
+		response := BatchAuthorizeResponse{Items: make([]BatchAuthorizeResponseItem, len(batch.Items))}
+		// Each item is charged to the caller's cluster rate limit as a request to /authorize would be, and
+		// items over it aren't evaluated
+		cluster := config.Clusters.Identify(r).String()
+		semaphore := make(chan struct{}, concurrency)
+		var wg sync.WaitGroup
+	items:
+		for i, sar := range batch.Items {
+			if !config.RateLimiter.take(cluster) {
+				response.Items[i] = BatchAuthorizeResponseItem{
+					SubjectAccessReviewResponse: server.NewResponse(sar.TypeMeta, sar.UID, authorizationv1.SubjectAccessReviewStatus{}),
+					Error:                       "Cluster rate limit exceeded",
+				}
+				continue
+			}
+			// Items still waiting are dropped once the caller has given up
+			select {
+			case semaphore <- struct{}{}:
+			case <-r.Context().Done():
+				break items
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() { <-semaphore }()
+				response.Items[i] = evaluateBatchItem(r.Context(), config, evaluate, sar, r.Header)
+			}()
+		}
+		wg.Wait()
+		if err := r.Context().Err(); err != nil {
+			countCancelledRequest(r, err)
+			return
+		}
+
+		if config.logLevel() >= 1 {
+			log.Printf("[Cluster: %s] Evaluated batch of %d SubjectAccessReviews\n", r.Header.Get("X-Forwarded-For"), len(batch.Items))
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+	// The whole batch is evaluated with the same policy, the one the caller's cluster is served
+	return server.Chain(http.HandlerFunc(handler), config.Enrichment.Middleware(config.Clusters), server.PinPolicy(config.Policy),
+		overlayMiddleware(config.Policy, config.Overlays, config.Clusters), config.NamedPolicies.Middleware(config.Clusters)).ServeHTTP
+}
+
+func evaluateBatchItem(ctx context.Context, config WebhookConfig, evaluate server.Evaluator, sar policy.SubjectAccessReview, header http.Header) BatchAuthorizeResponseItem {
+	item := BatchAuthorizeResponseItem{SubjectAccessReviewResponse: server.NewResponse(sar.TypeMeta, sar.UID, authorizationv1.SubjectAccessReviewStatus{})}
+	err := policy.AcceptAPIVersion(&sar, config.APIVersions)
+	if err == nil {
+		err = policy.Normalize(&sar, header)
+	}
+	if err == nil {
+		err = enforceLimits(config, &sar.Spec)
+	}
+	if err == nil {
+		err = policy.Validate(sar)
+	}
+	if err != nil {
+		item.Error = err.Error()
+		return item
+	}
+	item.Status = evaluate(ctx, sar)
+	return item
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBatchDecisionsInRequestOrder(t *testing.T) {
+	authorizer := CreateBatchAuthorizer(WebhookConfig{Config: DefaultPolicyConfig}, 10, 2)
+	resp := batchTest(t, authorizer, http.StatusOK,
+		[]byte(
+			`{"items":[
+			{
+				"kind":"SubjectAccessReview",
+				"apiVersion":"authorization.k8s.io/v1",
+				"spec":{
+					"resourceAttributes":{"namespace":"kube-system","verb":"get","resource":"secrets"},
+					"user":"not-admin"
+				}
+			},
+			{
+				"kind":"SubjectAccessReview",
+				"apiVersion":"authorization.k8s.io/v1beta1",
+				"spec":{
+					"resourceAttributes":{"namespace":"safe-namespace","verb":"get","resource":"secrets"},
+					"user":"not-admin"
+				}
+			},
+			{
+				"kind":"SubjectAccessReview",
+				"apiVersion":"v0",
+				"spec":{"user":"not-admin"}
+			}
+			]}`))
+
+	if len(resp.Items) != 3 {
+		t.Fatalf("Expected 3 decisions, got %d", len(resp.Items))
+	}
+	if !resp.Items[0].Status.Denied || resp.Items[0].Error != "" {
+		t.Error("Expected first request to be denied")
+	}
+	if resp.Items[1].Status.Denied || resp.Items[1].Error != "" {
+		t.Error("Expected second request to be allowed")
+	}
+	if resp.Items[0].ApiVersion != "authorization.k8s.io/v1" || resp.Items[1].ApiVersion != "authorization.k8s.io/v1beta1" {
+		t.Errorf("Expected responses in the apiVersion of each request, got %s and %s", resp.Items[0].ApiVersion, resp.Items[1].ApiVersion)
+	}
+	if resp.Items[2].Error == "" {
+		t.Error("Expected error for invalid third request")
+	}
+}
+
+func TestBatchAgreesWithAuthorize(t *testing.T) {
+	config := WebhookConfig{
+		Config:     DefaultPolicyConfig,
+		Privileges: PrivilegeResolvers{NewOIDCClaimResolver(OIDCClaimResolverOptions{PrivilegedGroups: []string{"platform-admins"}})},
+	}
+	body := []byte(`{"items":[{
+		"kind":"SubjectAccessReview",
+		"apiVersion":"authorization.k8s.io/v1",
+		"spec":{
+			"resourceAttributes":{"namespace":"kube-system","verb":"create","resource":"pods"},
+			"user":"alice",
+			"groups":["platform-admins"]
+		}
+	}]}`)
+	resp := batchTest(t, CreateBatchAuthorizer(config, 10, 1), http.StatusOK, body)
+	if len(resp.Items) != 1 || resp.Items[0].Status.Denied {
+		t.Errorf("Expected user privileged by a resolver to be allowed, as by /authorize, got %+v", resp.Items)
+	}
+	config.Privileges = nil
+	resp = batchTest(t, CreateBatchAuthorizer(config, 10, 1), http.StatusOK, body)
+	if len(resp.Items) != 1 || !resp.Items[0].Status.Denied {
+		t.Errorf("Expected unprivileged user to be denied, got %+v", resp.Items)
+	}
+}
+
+func TestBatchChargedToClusterRateLimit(t *testing.T) {
+	config := WebhookConfig{Config: DefaultPolicyConfig, RateLimiter: NewClusterRateLimiter(ClusterRateLimiterOptions{Rate: 2})}
+	item := `{"kind":"SubjectAccessReview","apiVersion":"authorization.k8s.io/v1",
+		"spec":{"resourceAttributes":{"namespace":"default","verb":"get","resource":"pods"},"user":"alice"}}`
+	before := requestsThrottled.Value("", string(RateLimitNoOpinion))
+	resp := batchTest(t, CreateBatchAuthorizer(config, 10, 1), http.StatusOK, []byte(`{"items":[`+item+","+item+","+item+`]}`))
+	if len(resp.Items) != 3 || resp.Items[0].Error != "" || resp.Items[1].Error != "" || resp.Items[2].Error != "Cluster rate limit exceeded" {
+		t.Errorf("Expected the item over the rate limit not to be evaluated, got %+v", resp.Items)
+	}
+	if requestsThrottled.Value("", string(RateLimitNoOpinion)) != before+1 {
+		t.Error("Expected the item over the rate limit to be counted as throttled")
+	}
+}
+
+func TestBatchTooLarge(t *testing.T) {
+	authorizer := CreateBatchAuthorizer(WebhookConfig{Config: DefaultPolicyConfig}, 1, 1)
+	batchTest(t, authorizer, http.StatusRequestEntityTooLarge,
+		[]byte(`{"items":[{"kind":"SubjectAccessReview"},{"kind":"SubjectAccessReview"}]}`))
+}
+
+func TestBatchInvalidJSON(t *testing.T) {
+	authorizer := CreateBatchAuthorizer(WebhookConfig{Config: DefaultPolicyConfig}, 1, 1)
+	batchTest(t, authorizer, http.StatusBadRequest, []byte(`{bad json}`))
+}
+
+func batchTest(t *testing.T, authorizer func(w http.ResponseWriter, r *http.Request), expectedCode int, jsonData []byte) BatchAuthorizeResponse {
+	req := httptest.NewRequest(http.MethodPost, "/authorize/batch", bytes.NewBuffer(jsonData))
+	req.Header.Set("Content-Type", "application/json")
+	resp := httptest.NewRecorder()
+
+	authorizer(resp, req)
+
+	if resp.Code != expectedCode {
+		t.Fatalf("Expected status %d, got %d", expectedCode, resp.Code)
+	}
+	var batchResponse BatchAuthorizeResponse
+	_ = json.NewDecoder(resp.Body).Decode(&batchResponse)
+	return batchResponse
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/policy"
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/server"
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+type cacheHintKey struct{}
+
+// Records hint as the cache hint of the decision for the request ctx belongs to, if its response has
+// Cache-Control set by cacheControl
+func recordCacheHint(ctx context.Context, hint policy.CacheHint) {
+	if recorded, ok := ctx.Value(cacheHintKey{}).(*policy.CacheHint); ok {
+		*recorded = hint
+	}
+}
+
+// Returns middleware setting Cache-Control on responses from the cache hint of their decision, so callers
+// caching decisions can follow the webhook's own cache: no-store for decisions hinted none, and for
+// simulations, and a max-age of longTTL for those hinted long. Others are left to the caller's defaults
+func cacheControl(longTTL time.Duration) server.Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hint := new(policy.CacheHint)
+			r = r.WithContext(context.WithValue(r.Context(), cacheHintKey{}, hint))
+			next.ServeHTTP(&cacheControlWriter{ResponseWriter: w, header: func() string {
+				switch {
+				case simulatedFrom(r.Context()) || *hint == policy.CacheNone:
+					return "no-store"
+				case *hint == policy.CacheLong && longTTL > 0:
+					return fmt.Sprintf("private, max-age=%d", int(longTTL.Seconds()))
+				}
+				return ""
+			}}, r)
+		})
+	}
+}
+
+// Sets Cache-Control before the response is started
+type cacheControlWriter struct {
+	http.ResponseWriter
+	header  func() string
+	written bool
+}
+
+func (w *cacheControlWriter) setHeader() {
+	if w.written {
+		return
+	}
+	w.written = true
+	if value := w.header(); value != "" {
+		w.Header().Set("Cache-Control", value)
+	}
+}
+
+func (w *cacheControlWriter) WriteHeader(statusCode int) {
+	w.setHeader()
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *cacheControlWriter) Write(data []byte) (int, error) {
+	w.setHeader()
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *cacheControlWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
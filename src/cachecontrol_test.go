@@ -0,0 +1,54 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/policy"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCacheHints(t *testing.T) {
+	config := DefaultPolicyConfig
+	config.ProtectedNamespaces = []string{"kube-system"}
+	config.CacheHints = map[string]policy.CacheHint{policy.RuleDefaultAllow: policy.CacheLong, policy.RuleProtectedWrite: policy.CacheNone}
+	cache := NewDecisionCache(16, time.Second, "")
+	cache.SetLongTTL(time.Hour)
+	simulation, _ := NewSimulation("X-Azimuth-Authz-Dry-Run", []string{"az-staging/*"})
+	handler := CreateWebhookAuthorizer(WebhookConfig{Config: config, DecisionCache: cache, CacheLongTTL: time.Hour, Simulation: simulation})
+	request := func(namespace string, simulated bool) string {
+		body := `{"apiVersion":"authorization.k8s.io/v1","kind":"SubjectAccessReview",
+			"spec":{"user":"alice","resourceAttributes":{"namespace":"` + namespace + `","verb":"delete","resource":"pods"}}}`
+		req := httptest.NewRequest(http.MethodPost, "/authorize", strings.NewReader(body))
+		if simulated {
+			req.Header.Set("X-Azimuth-Authz-Dry-Run", "true")
+			req = req.WithContext(withClusterIdentity(req.Context(), &ClusterIdentity{Namespace: "az-staging", Name: "demo"}))
+		}
+		resp := httptest.NewRecorder()
+		handler(resp, req)
+		return resp.Header().Get("Cache-Control")
+	}
+	spec := func(namespace string) policy.SubjectAccessReviewSpec {
+		return policy.SubjectAccessReviewSpec{User: "alice", ResourceAttributes: &authorizationv1.ResourceAttributes{Namespace: namespace, Verb: "delete", Resource: "pods"}}
+	}
+
+	for range 2 {
+		if header := request("default", false); header != "private, max-age=3600" {
+			t.Errorf("Expected long cached allow to advertise the long TTL, got %q", header)
+		}
+	}
+	if _, hint, ok := cache.Get(spec("default")); !ok || hint != policy.CacheLong {
+		t.Errorf("Expected allow to be cached with hint long, got %q", hint)
+	}
+	if header := request("kube-system", false); header != "no-store" {
+		t.Errorf("Expected uncacheable denial to be marked no-store, got %q", header)
+	}
+	if _, _, ok := cache.Get(spec("kube-system")); ok {
+		t.Error("Expected uncacheable denial not to be cached")
+	}
+	if header := request("default", true); header != "no-store" {
+		t.Errorf("Expected simulation to be marked no-store, got %q", header)
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/internal/exitcode"
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/client"
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/policy"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"os"
+	"strings"
+	"time"
+)
+
+// Parses a kubectl style resource argument, RESOURCE[.GROUP][/NAME]
+func parseResourceArg(arg string) authorizationv1.ResourceAttributes {
+	resourceGroup, name, _ := strings.Cut(arg, "/")
+	resource, group, _ := strings.Cut(resourceGroup, ".")
+	return authorizationv1.ResourceAttributes{Resource: resource, Group: group, Name: name}
+}
+
+// Parses flags, allowing them to be interspersed with positional arguments as kubectl does
+func parseInterspersed(flags *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, err
+		}
+		args = flags.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// Asks a running webhook whether a user can make a request, as kubectl auth can-i does for the whole
+// apiserver. The webhook is reached with the same URL and credentials as kube-apiserver uses, from
+// either a kubeconfig or individual flags. Exits 0 unless the request is denied
+func runCanI(args []string, out io.Writer) int {
+	flags := flag.NewFlagSet("can-i", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: can-i [flags] VERB (RESOURCE[.GROUP][/NAME] | NONRESOURCEURL)")
+		flags.PrintDefaults()
+	}
+	kubeconfigPath := flags.String("kubeconfig", "", "Webhook kubeconfig, as generated by gen-webhook-config, instead of the server flags")
+	serverURL := flags.String("server-url", "http://localhost:8080", "URL of the webhook. '/authorize' is appended if there is no path")
+	caFile := flags.String("ca-file", "", "CA bundle used to verify the webhook, system roots if empty")
+	clientCertFile := flags.String("client-cert-file", "", "Client certificate presented to the webhook")
+	clientKeyFile := flags.String("client-key-file", "", "Private key of the client certificate")
+	tokenFile := flags.String("token-file", "", "File containing bearer token sent to the webhook")
+	user := flags.String("as", "", "User to ask on behalf of. Required")
+	groupsCSL := flags.String("as-group", "", "Comma separated groups of the user")
+	namespace := flags.String("namespace", "", "Namespace of the resource, all namespaces if empty")
+	subresource := flags.String("subresource", "", "Subresource, e.g. log")
+	timeout := flags.Duration("timeout", 10*time.Second, "Timeout for the webhook call")
+	output := flags.String("output", "text", "Output format. Values: [text, json]")
+	positional, err := parseInterspersed(flags, args)
+	if err != nil {
+		return exitcode.Usage
+	}
+	if len(positional) != 2 || (*output != "text" && *output != "json") {
+		flags.Usage()
+		return exitcode.Usage
+	}
+
+	var groups []string
+	if *groupsCSL != "" {
+		groups = strings.Split(*groupsCSL, ",")
+	}
+	var sar policy.SubjectAccessReview
+	if strings.HasPrefix(positional[1], "/") {
+		sar = newRequestSAR(*user, groups, positional[0], authorizationv1.ResourceAttributes{}, positional[1])
+	} else {
+		resource := parseResourceArg(positional[1])
+		resource.Namespace = *namespace
+		resource.Subresource = *subresource
+		sar = newRequestSAR(*user, groups, positional[0], resource, "")
+	}
+	if err := policy.Validate(sar); err != nil {
+		return exitcode.Report(os.Stderr, err)
+	}
+
+	var conn *ClusterConnection
+	if *kubeconfigPath != "" {
+		conn, err = LoadKubeconfig(*kubeconfigPath, "")
+	} else {
+		conn, err = webhookConnection(*serverURL, *caFile, *clientCertFile, *clientKeyFile, *tokenFile)
+	}
+	if err != nil {
+		return exitcode.Report(os.Stderr, exitcode.Wrap(exitcode.Config, err))
+	}
+	webhook, err := client.New(client.Options{URL: conn.Server, BearerToken: conn.BearerToken, TLSConfig: conn.TLSConfig, Timeout: *timeout})
+	if err != nil {
+		return exitcode.Report(os.Stderr, exitcode.Wrap(exitcode.Config, err))
+	}
+	status, err := webhook.Authorize(context.Background(), sar)
+	if err != nil {
+		return exitcode.Report(os.Stderr, exitcode.Wrap(exitcode.Connectivity, err))
+	}
+
+	decision := policy.DecisionLabel(status)
+	if *output == "json" {
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(AccessCheckResponse{Allowed: !status.Denied, Decision: decision, Reason: status.Reason})
+	} else {
+		answer := map[string]string{"allowed": "yes", "denied": "no", "no-opinion": "no opinion"}[decision]
+		if status.Reason != "" {
+			answer += " - " + status.Reason
+		}
+		fmt.Fprintln(out, answer)
+	}
+	if status.Denied {
+		return exitcode.Denied
+	}
+	return 0
+}
+
+// Returns connection to the webhook from the same settings gen-webhook-config takes
+func webhookConnection(serverURL string, caFile string, clientCertFile string, clientKeyFile string, tokenFile string) (*ClusterConnection, error) {
+	options, err := apiserverWebhookOptions("can-i", serverURL, caFile, clientCertFile, clientKeyFile, tokenFile, "")
+	if err != nil {
+		return nil, err
+	}
+	conn := &ClusterConnection{Server: options.ServerURL, BearerToken: options.Token, TLSConfig: &tls.Config{MinVersion: tls.VersionTLS12}}
+	if caFile != "" {
+		if conn.TLSConfig, err = tlsConfigWithCA(caFile); err != nil {
+			return nil, err
+		}
+	}
+	if options.ClientCertData != nil {
+		cert, err := tls.X509KeyPair(options.ClientCertData, options.ClientKeyData)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate: %w", err)
+		}
+		conn.TLSConfig.Certificates = []tls.Certificate{cert}
+	}
+	return conn, nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/internal/exitcode"
+	"bytes"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCanIQueriesRunningWebhook(t *testing.T) {
+	authorizer := CreateWebhookAuthorizer(WebhookConfig{Config: DefaultPolicyConfig})
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer webhook-token" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		authorizer(w, r)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.crt")
+	tokenFile := filepath.Join(dir, "token")
+	os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0o600)
+	os.WriteFile(tokenFile, []byte("webhook-token\n"), 0o600)
+	serverFlags := []string{"--server-url", server.URL, "--ca-file", caFile, "--token-file", tokenFile}
+
+	tests := []struct {
+		args   []string
+		code   int
+		output string
+	}{
+		{[]string{"delete", "pods", "--namespace", "kube-system", "--as", "alice"}, exitcode.Denied, "no - Cannot write to protected namespace\n"},
+		{[]string{"--as", "alice", "get", "deployments.apps/web", "-namespace", "kube-system"}, 0, "no opinion - Webhook doesn't give opinion, delegated to other authorizers\n"},
+		{[]string{"get", "/healthz", "--as", "alice", "--as-group", "system:authenticated"}, 0, "no opinion - Webhook doesn't give opinion, delegated to other authorizers\n"},
+	}
+	for _, test := range tests {
+		var out bytes.Buffer
+		if code := runCanI(append(test.args, serverFlags...), &out); code != test.code || out.String() != test.output {
+			t.Errorf("Expected exit code %d and %q for %v, got %d and %q", test.code, test.output, test.args, code, out.String())
+		}
+	}
+
+	kubeconfig := writeKubeconfig(t, server, "    token: webhook-token\n")
+	if code := runCanI([]string{"--kubeconfig", kubeconfig, "create", "secrets", "--as", "alice", "--namespace", "openstack-system"}, &bytes.Buffer{}); code != exitcode.Denied {
+		t.Errorf("Expected denial using kubeconfig, got exit code %d", code)
+	}
+	if code := runCanI([]string{"get", "pods", "--as", "alice", "--server-url", server.URL, "--ca-file", caFile}, &bytes.Buffer{}); code != exitcode.Connectivity {
+		t.Errorf("Expected error without token, got exit code %d", code)
+	}
+}
+
+func TestParseResourceArg(t *testing.T) {
+	resource := parseResourceArg("deployments.apps/web")
+	if resource.Resource != "deployments" || resource.Group != "apps" || resource.Name != "web" {
+		t.Errorf("Unexpected resource %+v", resource)
+	}
+	if resource := parseResourceArg("pods"); resource.Resource != "pods" || resource.Group != "" || resource.Name != "" {
+		t.Errorf("Unexpected resource %+v", resource)
+	}
+}
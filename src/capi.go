@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+)
+
+// Annotation on a CAPI Cluster listing additional comma separated addresses its apiserver calls the
+// webhook from, e.g. a NAT gateway, when these differ from the control plane endpoint
+const SourceAddressesAnnotation = "authorization.azimuth-cloud.io/source-addresses"
+
+var clusterDecisions = Metrics.NewCounterVec("azimuth_authz_cluster_decisions_total",
+	"Authorization decisions by identified calling cluster", "cluster", "decision")
+
+// Workload cluster a request was identified as coming from
+type ClusterIdentity struct {
+	Namespace string
+	Name      string
+	// Values of the configured CAPI Cluster labels, keyed by their short names (e.g. tenant, flavor)
+	Labels map[string]string
+}
+
+// Returns namespace/name of the cluster, or the empty string for an unidentified caller
+func (c *ClusterIdentity) String() string {
+	if c == nil {
+		return ""
+	}
+	return c.Namespace + "/" + c.Name
+}
+
+// Reports whether identity matches any of the namespace/name patterns. Unidentified callers match none
+func clusterPatternsAllow(patterns []string, identity *ClusterIdentity) bool {
+	if identity == nil {
+		return false
+	}
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, identity.String()); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Subset of a cluster.x-k8s.io Cluster object needed to identify callers
+type capiCluster struct {
+	Metadata struct {
+		Namespace       string            `json:"namespace"`
+		Name            string            `json:"name"`
+		ResourceVersion string            `json:"resourceVersion"`
+		Labels          map[string]string `json:"labels"`
+		Annotations     map[string]string `json:"annotations"`
+	} `json:"metadata"`
+	Spec struct {
+		ControlPlaneEndpoint struct {
+			Host string `json:"host"`
+		} `json:"controlPlaneEndpoint"`
+	} `json:"spec"`
+}
+
+type ClusterRegistryOptions struct {
+	// Maps short label names used in logs and audit events to CAPI Cluster label keys
+	LabelKeys map[string]string
+	// Request header carrying the client certificate subject set by a TLS terminating proxy. Ignored if empty
+	ClientCertHeader string
+}
+
+// Maps callers to workload clusters using CAPI Cluster objects watched on the management cluster.
+// Callers are matched by client certificate common name (the cluster name, or namespace/name) and
+// by source address (the control plane endpoint and SourceAddressesAnnotation)
+type ClusterRegistry struct {
+	watcher *kubeWatcher
+	options ClusterRegistryOptions
+
+	mu        sync.RWMutex
+	clusters  map[string]*clusterEntry    // namespace/name -> cluster
+	byName    map[string]*ClusterIdentity // common name -> identity, nil if ambiguous
+	byAddress map[string]*ClusterIdentity
+}
+
+type clusterEntry struct {
+	identity  *ClusterIdentity
+	addresses []string
+}
+
+func NewClusterRegistry(conn *ClusterConnection, client *OutboundClient, options ClusterRegistryOptions) *ClusterRegistry {
+	r := &ClusterRegistry{options: options}
+	r.watcher = &kubeWatcher{
+		conn:    conn,
+		client:  client.WithTLSConfig(conn.TLSConfig),
+		backend: "capi",
+		path:    "/apis/cluster.x-k8s.io/v1beta1/clusters",
+		health:  newHealth(true),
+		replace: func(items []json.RawMessage) error {
+			clusters := make([]capiCluster, len(items))
+			for i, item := range items {
+				if err := json.Unmarshal(item, &clusters[i]); err != nil {
+					return fmt.Errorf("decoding cluster list: %w", err)
+				}
+			}
+			r.replace(clusters)
+			return nil
+		},
+		update: func(object json.RawMessage, deleted bool) error {
+			var cluster capiCluster
+			if err := json.Unmarshal(object, &cluster); err != nil {
+				return fmt.Errorf("decoding watch event: %w", err)
+			}
+			r.update(&cluster, deleted)
+			return nil
+		},
+	}
+	r.replace(nil)
+	Metrics.NewGaugeFunc("azimuth_authz_capi_clusters", "CAPI clusters known to the cluster identity registry",
+		func() float64 {
+			r.mu.RLock()
+			defer r.mu.RUnlock()
+			return float64(len(r.clusters))
+		})
+	return r
+}
+
+type clusterIdentityKey struct{}
+
+// Returns ctx recording that the request is known to come from identity, e.g. from its fleet route
+func withClusterIdentity(ctx context.Context, identity *ClusterIdentity) context.Context {
+	return context.WithValue(ctx, clusterIdentityKey{}, identity)
+}
+
+// Returns the identity recorded in ctx by withClusterIdentity, or nil if it has none
+func clusterIdentityFrom(ctx context.Context) *ClusterIdentity {
+	identity, _ := ctx.Value(clusterIdentityKey{}).(*ClusterIdentity)
+	return identity
+}
+
+// Identifies the cluster the request came from, returning nil if it doesn't match a known cluster.
+// Safe to call on a nil registry
+func (r *ClusterRegistry) Identify(req *http.Request) *ClusterIdentity {
+	if identity := clusterIdentityFrom(req.Context()); identity != nil {
+		return identity
+	}
+	if r == nil {
+		return nil
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if cn := r.clientCommonName(req); cn != "" {
+		if entry := r.clusters[cn]; entry != nil {
+			return entry.identity
+		}
+		if identity := r.byName[cn]; identity != nil {
+			return identity
+		}
+	}
+	for _, address := range callerAddresses(req) {
+		if identity := r.byAddress[address]; identity != nil {
+			return identity
+		}
+	}
+	return nil
+}
+
+func (r *ClusterRegistry) clientCommonName(req *http.Request) string {
+	if req.TLS != nil && len(req.TLS.PeerCertificates) > 0 {
+		return req.TLS.PeerCertificates[0].Subject.CommonName
+	}
+	if r.options.ClientCertHeader != "" {
+		return commonNameFromSubject(req.Header.Get(r.options.ClientCertHeader))
+	}
+	return ""
+}
+
+// Extracts the CN from a subject DN such as "CN=cluster,O=azimuth", as forwarded by ingress controllers
+func commonNameFromSubject(subject string) string {
+	for _, part := range strings.Split(subject, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if ok && strings.EqualFold(key, "CN") {
+			return value
+		}
+	}
+	return ""
+}
+
+// Returns the addresses in X-Forwarded-For followed by the direct peer address
+func callerAddresses(req *http.Request) []string {
+	var addresses []string
+	for _, header := range req.Header.Values("X-Forwarded-For") {
+		for _, address := range strings.Split(header, ",") {
+			if address = strings.TrimSpace(address); address != "" {
+				addresses = append(addresses, address)
+			}
+		}
+	}
+	if host, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		addresses = append(addresses, host)
+	}
+	return addresses
+}
+
+// Lists and then watches CAPI clusters until ctx is cancelled, relisting after errors
+func (r *ClusterRegistry) Run(ctx context.Context) {
+	r.watcher.Run(ctx)
+}
+
+func (r *ClusterRegistry) list(ctx context.Context) (string, error) {
+	return r.watcher.list(ctx)
+}
+
+func (r *ClusterRegistry) watch(ctx context.Context, resourceVersion string) (string, error) {
+	return r.watcher.watch(ctx, resourceVersion)
+}
+
+func (r *ClusterRegistry) entryFor(cluster *capiCluster) *clusterEntry {
+	identity := &ClusterIdentity{Namespace: cluster.Metadata.Namespace, Name: cluster.Metadata.Name, Labels: map[string]string{}}
+	for shortName, labelKey := range r.options.LabelKeys {
+		if value, ok := cluster.Metadata.Labels[labelKey]; ok {
+			identity.Labels[shortName] = value
+		}
+	}
+	entry := &clusterEntry{identity: identity}
+	if host := cluster.Spec.ControlPlaneEndpoint.Host; host != "" {
+		entry.addresses = append(entry.addresses, host)
+	}
+	for _, address := range strings.Split(cluster.Metadata.Annotations[SourceAddressesAnnotation], ",") {
+		if address = strings.TrimSpace(address); address != "" {
+			entry.addresses = append(entry.addresses, address)
+		}
+	}
+	return entry
+}
+
+func (r *ClusterRegistry) replace(clusters []capiCluster) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.clusters = map[string]*clusterEntry{}
+	for i := range clusters {
+		entry := r.entryFor(&clusters[i])
+		r.clusters[entry.identity.String()] = entry
+	}
+	r.reindexLocked()
+}
+
+func (r *ClusterRegistry) update(cluster *capiCluster, deleted bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entry := r.entryFor(cluster)
+	if deleted {
+		delete(r.clusters, entry.identity.String())
+	} else {
+		r.clusters[entry.identity.String()] = entry
+	}
+	r.reindexLocked()
+}
+
+// Rebuilds lookup indexes from scratch. Cluster counts are small and change rarely, so this is
+// simpler than maintaining the indexes incrementally
+func (r *ClusterRegistry) reindexLocked() {
+	r.byName = map[string]*ClusterIdentity{}
+	r.byAddress = map[string]*ClusterIdentity{}
+	for _, entry := range r.clusters {
+		if _, ok := r.byName[entry.identity.Name]; ok {
+			// The same name in several namespaces can only be matched by namespace/name
+			r.byName[entry.identity.Name] = nil
+		} else {
+			r.byName[entry.identity.Name] = entry.identity
+		}
+		for _, address := range entry.addresses {
+			r.byAddress[address] = entry.identity
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/policy"
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/server"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+const capiClusterJSON = `{
+	"metadata":{
+		"namespace":"az-tenant-a","name":"%s","resourceVersion":"%s",
+		"labels":{"azimuth.stackhpc.com/tenant-id":"tenant-a","azimuth.stackhpc.com/cluster-template":"small"},
+		"annotations":{"` + SourceAddressesAnnotation + `":"192.0.2.10, 192.0.2.11"}
+	},
+	"spec":{"controlPlaneEndpoint":{"host":"%s","port":6443}}
+}`
+
+func newTestClusterRegistry(t *testing.T) *ClusterRegistry {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/apis/cluster.x-k8s.io/v1beta1/clusters" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.URL.Query().Get("watch") == "" {
+			fmt.Fprintf(w, `{"metadata":{"resourceVersion":"1"},"items":[`+capiClusterJSON+`]}`, "demo", "1", "203.0.113.5")
+			return
+		}
+		// Moves the cluster's endpoint, then ends the watch
+		fmt.Fprintf(w, `{"type":"MODIFIED","object":`+capiClusterJSON+`}`, "demo", "2", "203.0.113.6")
+	}))
+	t.Cleanup(server.Close)
+
+	conn, err := LoadKubeconfig(writeKubeconfig(t, server, "    token: x\n"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewClusterRegistry(conn, NewOutboundClient(DefaultOutboundClientOptions), ClusterRegistryOptions{
+		LabelKeys:        map[string]string{"tenant": "azimuth.stackhpc.com/tenant-id", "flavor": "azimuth.stackhpc.com/cluster-template"},
+		ClientCertHeader: "Ssl-Client-Subject-Dn",
+	})
+}
+
+func requestFrom(remoteAddr string, headers map[string]string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/authorize", nil)
+	r.RemoteAddr = remoteAddr
+	for key, value := range headers {
+		r.Header.Set(key, value)
+	}
+	return r
+}
+
+func TestClusterRegistryIdentifiesCallers(t *testing.T) {
+	registry := newTestClusterRegistry(t)
+	if _, err := registry.list(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		request *http.Request
+		want    string
+	}{
+		{"control plane endpoint", requestFrom("203.0.113.5:1234", nil), "az-tenant-a/demo"},
+		{"annotated source address", requestFrom("10.0.0.1:1234", map[string]string{"X-Forwarded-For": "192.0.2.11, 10.0.0.2"}), "az-tenant-a/demo"},
+		{"certificate name", requestFrom("10.0.0.1:1234", map[string]string{"Ssl-Client-Subject-Dn": "CN=demo,O=azimuth"}), "az-tenant-a/demo"},
+		{"certificate namespace and name", requestFrom("10.0.0.1:1234", map[string]string{"Ssl-Client-Subject-Dn": "CN=az-tenant-a/demo"}), "az-tenant-a/demo"},
+		{"unknown", requestFrom("10.0.0.1:1234", nil), ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := registry.Identify(test.request).String(); got != test.want {
+				t.Errorf("Expected %q, got %q", test.want, got)
+			}
+		})
+	}
+
+	identity := registry.Identify(requestFrom("203.0.113.5:1234", nil))
+	if identity.Labels["tenant"] != "tenant-a" || identity.Labels["flavor"] != "small" {
+		t.Errorf("Expected configured labels, got %v", identity.Labels)
+	}
+}
+
+func TestClusterRegistryAppliesWatchEvents(t *testing.T) {
+	registry := newTestClusterRegistry(t)
+	if _, err := registry.list(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	resourceVersion, err := registry.watch(t.Context(), "1")
+	if err != nil || resourceVersion != "2" {
+		t.Fatalf("Expected watch to end cleanly at resource version 2, got %q, %v", resourceVersion, err)
+	}
+	if registry.Identify(requestFrom("203.0.113.5:1234", nil)) != nil {
+		t.Error("Expected old control plane endpoint to no longer match")
+	}
+	if registry.Identify(requestFrom("203.0.113.6:1234", nil)) == nil {
+		t.Error("Expected new control plane endpoint to match")
+	}
+
+	var deleted capiCluster
+	deleted.Metadata.Namespace, deleted.Metadata.Name = "az-tenant-a", "demo"
+	registry.update(&deleted, true)
+	if registry.Identify(requestFrom("192.0.2.10:1234", nil)) != nil {
+		t.Error("Expected deleted cluster to no longer match")
+	}
+}
+
+func TestIdentifiedClusterIsLogged(t *testing.T) {
+	registry := newTestClusterRegistry(t)
+	if _, err := registry.list(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	authorizer := CreateWebhookAuthorizer(WebhookConfig{Config: DefaultPolicyConfig, LogLevel: 1, Clusters: registry})
+	request := httptest.NewRequest(http.MethodPost, "/authorize", strings.NewReader(unprotectedWriteSAR))
+	request.RemoteAddr = "203.0.113.5:1234"
+	recorder := httptest.NewRecorder()
+	authorizer(recorder, request)
+
+	var response server.SubjectAccessReviewResponse
+	json.NewDecoder(recorder.Body).Decode(&response)
+	if !strings.Contains(logs.String(), "[Cluster: az-tenant-a/demo flavor=small tenant=tenant-a]") {
+		t.Errorf("Expected cluster identity in decision log, got %q", logs.String())
+	}
+	if clusterDecisions.Value("az-tenant-a/demo", policy.DecisionLabel(response.Status)) == 0 {
+		t.Error("Expected decision to be counted against the identified cluster")
+	}
+}
@@ -0,0 +1,790 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/internal/lru"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Interpreter for the subset of CEL used by kube-apiserver authorization matchConditions. Kept
+// dependency free like the rest of the webhook. Supported:
+//   - literals: strings, integers, booleans, null and lists
+//   - operators: ! - && || == != < <= > >= + in, ternary ?:, parentheses
+//   - field selection and indexing: request.resourceAttributes.namespace, request.extra['key']
+//   - functions: has(), size(), startsWith(), endsWith(), contains(), matches(), lowerAscii()
+//   - macros: list.exists(x, predicate), list.all(x, predicate)
+type celProgram struct {
+	source string
+	root   celNode
+}
+
+type celNode interface {
+	eval(env map[string]any) (any, error)
+}
+
+// Compiles expression, reporting syntax errors with their position
+func compileCEL(source string) (*celProgram, error) {
+	tokens, err := celLex(source)
+	if err != nil {
+		return nil, err
+	}
+	p := &celParser{tokens: tokens}
+	root, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != celTokenEOF {
+		return nil, fmt.Errorf("unexpected %q at position %d", p.peek().text, p.peek().pos)
+	}
+	return &celProgram{source: source, root: root}, nil
+}
+
+// Evaluates program, which must produce a boolean
+func (p *celProgram) EvalBool(env map[string]any) (bool, error) {
+	value, err := p.root.eval(env)
+	if err != nil {
+		return false, err
+	}
+	result, ok := value.(bool)
+	if !ok {
+		return false, fmt.Errorf("expression produced %T rather than bool", value)
+	}
+	return result, nil
+}
+
+// Lexer
+
+type celTokenKind int
+
+const (
+	celTokenEOF celTokenKind = iota
+	celTokenIdent
+	celTokenString
+	celTokenInt
+	celTokenOperator
+)
+
+type celToken struct {
+	kind celTokenKind
+	text string
+	pos  int
+}
+
+var celOperators = []string{"&&", "||", "==", "!=", "<=", ">=", "!", "<", ">", "+", "-", "(", ")", "[", "]", ".", ",", "?", ":"}
+
+func celLex(source string) ([]celToken, error) {
+	var tokens []celToken
+	for i := 0; i < len(source); {
+		c := rune(source[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '\'' || c == '"':
+			value, length, err := celLexString(source[i:])
+			if err != nil {
+				return nil, fmt.Errorf("%w at position %d", err, i)
+			}
+			tokens = append(tokens, celToken{celTokenString, value, i})
+			i += length
+		case unicode.IsDigit(c):
+			start := i
+			for i < len(source) && unicode.IsDigit(rune(source[i])) {
+				i++
+			}
+			tokens = append(tokens, celToken{celTokenInt, source[start:i], start})
+		case c == '_' || unicode.IsLetter(c):
+			start := i
+			for i < len(source) && (source[i] == '_' || unicode.IsLetter(rune(source[i])) || unicode.IsDigit(rune(source[i]))) {
+				i++
+			}
+			tokens = append(tokens, celToken{celTokenIdent, source[start:i], start})
+		default:
+			matched := false
+			for _, op := range celOperators {
+				if strings.HasPrefix(source[i:], op) {
+					tokens = append(tokens, celToken{celTokenOperator, op, i})
+					i += len(op)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("unexpected character %q at position %d", c, i)
+			}
+		}
+	}
+	return append(tokens, celToken{celTokenEOF, "end of expression", len(source)}), nil
+}
+
+// Returns unquoted value and length in source of a quoted string literal
+func celLexString(source string) (string, int, error) {
+	quote := source[0]
+	var sb strings.Builder
+	for i := 1; i < len(source); i++ {
+		switch source[i] {
+		case quote:
+			return sb.String(), i + 1, nil
+		case '\\':
+			i++
+			if i >= len(source) {
+				break
+			}
+			switch source[i] {
+			case 'n':
+				sb.WriteByte('\n')
+			case 't':
+				sb.WriteByte('\t')
+			default:
+				sb.WriteByte(source[i])
+			}
+		default:
+			sb.WriteByte(source[i])
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated string")
+}
+
+// Parser, by precedence climbing
+
+type celParser struct {
+	tokens []celToken
+	pos    int
+}
+
+func (p *celParser) peek() celToken { return p.tokens[p.pos] }
+
+func (p *celParser) next() celToken {
+	token := p.tokens[p.pos]
+	if token.kind != celTokenEOF {
+		p.pos++
+	}
+	return token
+}
+
+func (p *celParser) accept(op string) bool {
+	if token := p.peek(); token.kind == celTokenOperator && token.text == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *celParser) expect(op string) error {
+	if !p.accept(op) {
+		return fmt.Errorf("expected %q at position %d, got %q", op, p.peek().pos, p.peek().text)
+	}
+	return nil
+}
+
+func (p *celParser) expr() (celNode, error) {
+	condition, err := p.or()
+	if err != nil || !p.accept("?") {
+		return condition, err
+	}
+	ifTrue, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+	ifFalse, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	return &celTernary{condition, ifTrue, ifFalse}, nil
+}
+
+func (p *celParser) or() (celNode, error) {
+	left, err := p.and()
+	for err == nil && p.accept("||") {
+		var right celNode
+		if right, err = p.and(); err == nil {
+			left = &celLogical{op: "||", left: left, right: right}
+		}
+	}
+	return left, err
+}
+
+func (p *celParser) and() (celNode, error) {
+	left, err := p.relation()
+	for err == nil && p.accept("&&") {
+		var right celNode
+		if right, err = p.relation(); err == nil {
+			left = &celLogical{op: "&&", left: left, right: right}
+		}
+	}
+	return left, err
+}
+
+func (p *celParser) relation() (celNode, error) {
+	left, err := p.additive()
+	for err == nil {
+		token := p.peek()
+		isRelation := token.kind == celTokenOperator && strings.Contains(" == != < <= > >= ", " "+token.text+" ")
+		if !isRelation && !(token.kind == celTokenIdent && token.text == "in") {
+			break
+		}
+		p.next()
+		var right celNode
+		if right, err = p.additive(); err == nil {
+			left = &celBinary{op: token.text, left: left, right: right}
+		}
+	}
+	return left, err
+}
+
+func (p *celParser) additive() (celNode, error) {
+	left, err := p.unary()
+	for err == nil {
+		op := p.peek().text
+		if p.peek().kind != celTokenOperator || (op != "+" && op != "-") {
+			break
+		}
+		p.next()
+		var right celNode
+		if right, err = p.unary(); err == nil {
+			left = &celBinary{op: op, left: left, right: right}
+		}
+	}
+	return left, err
+}
+
+func (p *celParser) unary() (celNode, error) {
+	if p.accept("!") {
+		operand, err := p.unary()
+		return &celNot{operand}, err
+	}
+	if p.accept("-") {
+		operand, err := p.unary()
+		return &celBinary{op: "-", left: &celLiteral{int64(0)}, right: operand}, err
+	}
+	return p.member()
+}
+
+func (p *celParser) member() (celNode, error) {
+	node, err := p.primary()
+	for err == nil {
+		switch {
+		case p.accept("."):
+			name := p.next()
+			if name.kind != celTokenIdent {
+				return nil, fmt.Errorf("expected field name at position %d", name.pos)
+			}
+			if !p.accept("(") {
+				node = &celSelect{operand: node, field: name.text}
+				continue
+			}
+			if name.text == "exists" || name.text == "all" {
+				node, err = p.macro(node, name.text)
+				continue
+			}
+			var args []celNode
+			if args, err = p.args(); err == nil {
+				node = &celCall{function: name.text, target: node, args: args}
+			}
+		case p.accept("["):
+			var index celNode
+			if index, err = p.expr(); err == nil {
+				err = p.expect("]")
+				node = &celIndex{operand: node, index: index}
+			}
+		default:
+			return node, nil
+		}
+	}
+	return nil, err
+}
+
+// Parses the remainder of list.exists(x, predicate) or list.all(x, predicate)
+func (p *celParser) macro(target celNode, name string) (celNode, error) {
+	variable := p.next()
+	if variable.kind != celTokenIdent {
+		return nil, fmt.Errorf("expected variable name at position %d", variable.pos)
+	}
+	if err := p.expect(","); err != nil {
+		return nil, err
+	}
+	predicate, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	return &celComprehension{all: name == "all", target: target, variable: variable.text, predicate: predicate}, p.expect(")")
+}
+
+// Parses call arguments after the opening parenthesis
+func (p *celParser) args() ([]celNode, error) {
+	var args []celNode
+	if p.accept(")") {
+		return args, nil
+	}
+	for {
+		arg, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		if p.accept(")") {
+			return args, nil
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *celParser) primary() (celNode, error) {
+	token := p.next()
+	switch token.kind {
+	case celTokenString:
+		return &celLiteral{token.text}, nil
+	case celTokenInt:
+		n, err := strconv.ParseInt(token.text, 10, 64)
+		return &celLiteral{n}, err
+	case celTokenIdent:
+		switch token.text {
+		case "true", "false":
+			return &celLiteral{token.text == "true"}, nil
+		case "null":
+			return &celLiteral{nil}, nil
+		}
+		if !p.accept("(") {
+			return &celIdent{token.text}, nil
+		}
+		args, err := p.args()
+		if err != nil {
+			return nil, err
+		}
+		if token.text == "has" {
+			if len(args) != 1 {
+				return nil, fmt.Errorf("has() takes a single field selection")
+			}
+			selection, ok := args[0].(*celSelect)
+			if !ok {
+				return nil, fmt.Errorf("has() argument must be a field selection")
+			}
+			return &celHas{selection}, nil
+		}
+		return &celCall{function: token.text, args: args}, nil
+	case celTokenOperator:
+		switch token.text {
+		case "(":
+			node, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			return node, p.expect(")")
+		case "[":
+			args, err := p.listItems()
+			return &celList{args}, err
+		}
+	}
+	return nil, fmt.Errorf("unexpected %q at position %d", token.text, token.pos)
+}
+
+func (p *celParser) listItems() ([]celNode, error) {
+	var items []celNode
+	if p.accept("]") {
+		return items, nil
+	}
+	for {
+		item, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+		if p.accept("]") {
+			return items, nil
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Evaluation. Values are string, int64, bool, nil, []any and map[string]any, so environments can be
+// built by decoding JSON
+
+type celLiteral struct{ value any }
+
+func (n *celLiteral) eval(map[string]any) (any, error) { return n.value, nil }
+
+type celIdent struct{ name string }
+
+func (n *celIdent) eval(env map[string]any) (any, error) {
+	value, ok := env[n.name]
+	if !ok {
+		return nil, fmt.Errorf("undeclared reference to %q", n.name)
+	}
+	return value, nil
+}
+
+type celList struct{ items []celNode }
+
+func (n *celList) eval(env map[string]any) (any, error) {
+	values := make([]any, len(n.items))
+	for i, item := range n.items {
+		value, err := item.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+type celSelect struct {
+	operand celNode
+	field   string
+}
+
+func (n *celSelect) eval(env map[string]any) (any, error) {
+	operand, err := n.operand.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	fields, ok := operand.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("cannot select field %q from %T", n.field, operand)
+	}
+	value, ok := fields[n.field]
+	if !ok {
+		return nil, fmt.Errorf("no such key: %s", n.field)
+	}
+	return value, nil
+}
+
+type celHas struct{ selection *celSelect }
+
+func (n *celHas) eval(env map[string]any) (any, error) {
+	operand, err := n.selection.operand.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	fields, ok := operand.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("has() cannot test field %q of %T", n.selection.field, operand)
+	}
+	value, ok := fields[n.selection.field]
+	return ok && value != nil, nil
+}
+
+type celIndex struct{ operand, index celNode }
+
+func (n *celIndex) eval(env map[string]any) (any, error) {
+	operand, err := n.operand.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	index, err := n.index.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	switch container := operand.(type) {
+	case map[string]any:
+		key, ok := index.(string)
+		if !ok {
+			return nil, fmt.Errorf("map index must be a string, got %T", index)
+		}
+		value, ok := container[key]
+		if !ok {
+			return nil, fmt.Errorf("no such key: %s", key)
+		}
+		return value, nil
+	case []any:
+		i, ok := index.(int64)
+		if !ok || i < 0 || i >= int64(len(container)) {
+			return nil, fmt.Errorf("invalid list index %v", index)
+		}
+		return container[i], nil
+	}
+	return nil, fmt.Errorf("cannot index %T", operand)
+}
+
+type celNot struct{ operand celNode }
+
+func (n *celNot) eval(env map[string]any) (any, error) {
+	value, err := n.operand.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	b, ok := value.(bool)
+	if !ok {
+		return nil, fmt.Errorf("! requires bool, got %T", value)
+	}
+	return !b, nil
+}
+
+type celLogical struct {
+	op          string
+	left, right celNode
+}
+
+func (n *celLogical) eval(env map[string]any) (any, error) {
+	left, err := n.left.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	l, ok := left.(bool)
+	if !ok {
+		return nil, fmt.Errorf("%s requires bool, got %T", n.op, left)
+	}
+	if (n.op == "&&" && !l) || (n.op == "||" && l) {
+		return l, nil
+	}
+	right, err := n.right.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	r, ok := right.(bool)
+	if !ok {
+		return nil, fmt.Errorf("%s requires bool, got %T", n.op, right)
+	}
+	return r, nil
+}
+
+type celTernary struct{ condition, ifTrue, ifFalse celNode }
+
+func (n *celTernary) eval(env map[string]any) (any, error) {
+	condition, err := n.condition.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	b, ok := condition.(bool)
+	if !ok {
+		return nil, fmt.Errorf("ternary condition must be bool, got %T", condition)
+	}
+	if b {
+		return n.ifTrue.eval(env)
+	}
+	return n.ifFalse.eval(env)
+}
+
+type celBinary struct {
+	op          string
+	left, right celNode
+}
+
+func (n *celBinary) eval(env map[string]any) (any, error) {
+	left, err := n.left.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	right, err := n.right.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "==":
+		return celEqual(left, right), nil
+	case "!=":
+		return !celEqual(left, right), nil
+	case "in":
+		switch container := right.(type) {
+		case []any:
+			for _, item := range container {
+				if celEqual(left, item) {
+					return true, nil
+				}
+			}
+			return false, nil
+		case map[string]any:
+			key, ok := left.(string)
+			_, found := container[key]
+			return ok && found, nil
+		}
+		return nil, fmt.Errorf("in requires list or map, got %T", right)
+	}
+
+	switch l := left.(type) {
+	case int64:
+		r, ok := right.(int64)
+		if !ok {
+			break
+		}
+		switch n.op {
+		case "+":
+			return l + r, nil
+		case "-":
+			return l - r, nil
+		case "<":
+			return l < r, nil
+		case "<=":
+			return l <= r, nil
+		case ">":
+			return l > r, nil
+		case ">=":
+			return l >= r, nil
+		}
+	case string:
+		r, ok := right.(string)
+		if !ok {
+			break
+		}
+		switch n.op {
+		case "+":
+			return l + r, nil
+		case "<":
+			return l < r, nil
+		case "<=":
+			return l <= r, nil
+		case ">":
+			return l > r, nil
+		case ">=":
+			return l >= r, nil
+		}
+	case []any:
+		if r, ok := right.([]any); ok && n.op == "+" {
+			return append(append([]any(nil), l...), r...), nil
+		}
+	}
+	return nil, fmt.Errorf("no such overload: %T %s %T", left, n.op, right)
+}
+
+func celEqual(left any, right any) bool {
+	switch l := left.(type) {
+	case []any:
+		r, ok := right.([]any)
+		if !ok || len(l) != len(r) {
+			return false
+		}
+		for i := range l {
+			if !celEqual(l[i], r[i]) {
+				return false
+			}
+		}
+		return true
+	case map[string]any:
+		r, ok := right.(map[string]any)
+		if !ok || len(l) != len(r) {
+			return false
+		}
+		for key, value := range l {
+			if other, ok := r[key]; !ok || !celEqual(value, other) {
+				return false
+			}
+		}
+		return true
+	}
+	return left == right
+}
+
+type celComprehension struct {
+	all       bool
+	target    celNode
+	variable  string
+	predicate celNode
+}
+
+func (n *celComprehension) eval(env map[string]any) (any, error) {
+	target, err := n.target.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	var items []any
+	switch container := target.(type) {
+	case []any:
+		items = container
+	case map[string]any:
+		for _, key := range sortedKeys(container) {
+			items = append(items, key)
+		}
+	default:
+		return nil, fmt.Errorf("cannot iterate over %T", target)
+	}
+
+	scope := make(map[string]any, len(env)+1)
+	for key, value := range env {
+		scope[key] = value
+	}
+	for _, item := range items {
+		scope[n.variable] = item
+		value, err := n.predicate.eval(scope)
+		if err != nil {
+			return nil, err
+		}
+		b, ok := value.(bool)
+		if !ok {
+			return nil, fmt.Errorf("predicate must be bool, got %T", value)
+		}
+		if b != n.all {
+			return b, nil
+		}
+	}
+	return n.all, nil
+}
+
+type celCall struct {
+	function string
+	target   celNode
+	args     []celNode
+}
+
+// Compiled patterns for matches(), shared since expressions are evaluated on every request
+var celPatterns = lru.New[string, *regexp.Regexp](256)
+
+func (n *celCall) eval(env map[string]any) (any, error) {
+	var values []any
+	if n.target != nil {
+		target, err := n.target.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, target)
+	}
+	for _, arg := range n.args {
+		value, err := arg.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+
+	if n.function == "size" && len(values) == 1 {
+		switch value := values[0].(type) {
+		case string:
+			return int64(len([]rune(value))), nil
+		case []any:
+			return int64(len(value)), nil
+		case map[string]any:
+			return int64(len(value)), nil
+		}
+		return nil, fmt.Errorf("no such overload: size(%T)", values[0])
+	}
+	if n.function == "lowerAscii" && len(values) == 1 {
+		if s, ok := values[0].(string); ok {
+			return strings.ToLower(s), nil
+		}
+	}
+
+	if n.target == nil || len(values) != 2 {
+		return nil, fmt.Errorf("unknown function %s with %d arguments", n.function, len(values))
+	}
+	s, ok1 := values[0].(string)
+	arg, ok2 := values[1].(string)
+	if !ok1 || !ok2 {
+		return nil, fmt.Errorf("no such overload: %T.%s(%T)", values[0], n.function, values[1])
+	}
+	switch n.function {
+	case "startsWith":
+		return strings.HasPrefix(s, arg), nil
+	case "endsWith":
+		return strings.HasSuffix(s, arg), nil
+	case "contains":
+		return strings.Contains(s, arg), nil
+	case "matches":
+		pattern, ok := celPatterns.Get(arg)
+		if !ok {
+			var err error
+			if pattern, err = regexp.Compile(arg); err != nil {
+				return nil, err
+			}
+			celPatterns.Add(arg, pattern)
+		}
+		return pattern.MatchString(s), nil
+	}
+	return nil, fmt.Errorf("unknown function %s", n.function)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/policy"
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/server"
+	"encoding/json"
+	"errors"
+	"fmt"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"log"
+	"net/http"
+)
+
+// Prefix of SubjectAccessReview extras carrying the context of a /v1/check request, so that match
+// conditions and privilege resolvers can see it
+const CheckContextExtraPrefix = "check.azimuth-cloud.io/"
+
+// Request body of the /v1/check endpoint, for Azimuth components asking authorization questions
+// without building SubjectAccessReviews
+type AccessCheckRequest struct {
+	Subject  AccessCheckSubject  `json:"subject"`
+	Action   string              `json:"action"`
+	Resource AccessCheckResource `json:"resource"`
+	// Free-form attributes of the request, e.g. the Azimuth tenancy or Zenith service
+	Context map[string]string `json:"context,omitempty"`
+}
+
+type AccessCheckSubject struct {
+	User   string              `json:"user"`
+	Groups []string            `json:"groups,omitempty"`
+	Extra  map[string][]string `json:"extra,omitempty"`
+}
+
+// Either a Kubernetes-style resource, identified by type, or a path
+type AccessCheckResource struct {
+	Namespace   string `json:"namespace,omitempty"`
+	Group       string `json:"group,omitempty"`
+	Type        string `json:"type,omitempty"`
+	Subresource string `json:"subresource,omitempty"`
+	Name        string `json:"name,omitempty"`
+	Path        string `json:"path,omitempty"`
+}
+
+// Response body of the /v1/check endpoint. Allowed is true unless the request is denied, as callers
+// have no other authorizers to fall back on; Decision distinguishes an explicit allow from no opinion
+type AccessCheckResponse struct {
+	Allowed  bool   `json:"allowed"`
+	Decision string `json:"decision"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// Returns description of why request can't be evaluated, or empty string if it is valid
+func validateAccessCheck(request AccessCheckRequest) string {
+	switch {
+	case request.Subject.User == "" && len(request.Subject.Groups) == 0:
+		return "subject.user or subject.groups is required"
+	case request.Action == "":
+		return "action is required"
+	case (request.Resource.Type == "") == (request.Resource.Path == ""):
+		return "exactly one of resource.type and resource.path is required"
+	}
+	return ""
+}
+
+// Returns the SubjectAccessReview equivalent to request
+func accessCheckSAR(request AccessCheckRequest) policy.SubjectAccessReview {
+	sar := policy.SubjectAccessReview{}
+	sar.APIVersion = "authorization.k8s.io/v1"
+	sar.Kind = "SubjectAccessReview"
+	sar.Spec.User = request.Subject.User
+	sar.Spec.Groups = request.Subject.Groups
+	if len(request.Subject.Extra) > 0 || len(request.Context) > 0 {
+		sar.Spec.Extra = map[string]authorizationv1.ExtraValue{}
+	}
+	for key, values := range request.Subject.Extra {
+		sar.Spec.Extra[key] = values
+	}
+	for key, value := range request.Context {
+		sar.Spec.Extra[CheckContextExtraPrefix+key] = authorizationv1.ExtraValue{value}
+	}
+
+	resource := request.Resource
+	if resource.Path != "" {
+		sar.Spec.NonResourceAttributes = &authorizationv1.NonResourceAttributes{Path: resource.Path, Verb: request.Action}
+		return sar
+	}
+	sar.Spec.ResourceAttributes = &authorizationv1.ResourceAttributes{
+		Namespace:   resource.Namespace,
+		Verb:        request.Action,
+		Group:       resource.Group,
+		Resource:    resource.Type,
+		Subresource: resource.Subresource,
+		Name:        resource.Name,
+	}
+	return sar
+}
+
+// Returns HTTP request handler for /v1/check, making the same decisions as /authorize
+func CreateAccessCheckHandler(config WebhookConfig) func(w http.ResponseWriter, r *http.Request) {
+	evaluate := newEvaluator(config)
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+		if r.Method != http.MethodPost {
+			server.WriteError(w, http.StatusMethodNotAllowed, errors.New("Method not allowed"))
+			return
+		}
+
+		var request AccessCheckRequest
+		if err := server.DecodeJSON(r.Body, &request, nil); err != nil {
+			jsonErr := fmt.Errorf("JSON decoding error: %w", err)
+			log.Println(jsonErr)
+			server.WriteError(w, http.StatusBadRequest, jsonErr)
+			return
+		}
+		if errString := validateAccessCheck(request); errString != "" {
+			log.Println(errString)
+			server.WriteError(w, http.StatusBadRequest, errors.New(errString))
+			return
+		}
+
+		sar := accessCheckSAR(request)
+		status := evaluate(r.Context(), sar)
+		caller := r.Header.Get("X-Forwarded-For")
+		if config.logLevel() >= 1 {
+			log.Println(decisionLogRecord{cluster: caller, spec: &sar.Spec, status: &status})
+		}
+		if config.Audit != nil {
+			config.Audit.Publish(newAuditEvent(sar, caller, status))
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(AccessCheckResponse{
+			Allowed:  !status.Denied,
+			Decision: policy.DecisionLabel(status),
+			Reason:   status.Reason,
+		})
+	}
+}
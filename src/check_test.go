@@ -0,0 +1,71 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/config"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func accessCheckTest(t *testing.T, handler http.HandlerFunc, body string) (int, AccessCheckResponse) {
+	resp := httptest.NewRecorder()
+	handler(resp, httptest.NewRequest(http.MethodPost, "/v1/check", bytes.NewBufferString(body)))
+	var response AccessCheckResponse
+	if resp.Code == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp.Code, response
+}
+
+func TestAccessCheckDecisions(t *testing.T) {
+	handler := CreateAccessCheckHandler(WebhookConfig{Config: DefaultPolicyConfig})
+	tests := []struct {
+		body     string
+		allowed  bool
+		decision string
+	}{
+		{`{"subject":{"user":"not-admin"},"action":"get","resource":{"namespace":"kube-system","type":"secrets"}}`, false, "denied"},
+		{`{"subject":{"user":"not-admin"},"action":"get","resource":{"namespace":"tenant","type":"secrets"}}`, true, "no-opinion"},
+		{`{"subject":{"user":"not-admin"},"action":"get","resource":{"path":"/healthz"},"context":{"service":"portal"}}`, true, "no-opinion"},
+	}
+	for _, test := range tests {
+		code, response := accessCheckTest(t, handler, test.body)
+		if code != http.StatusOK || response.Allowed != test.allowed || response.Decision != test.decision {
+			t.Errorf("Expected %v (%s) for %s, got %d %+v", test.allowed, test.decision, test.body, code, response)
+		}
+	}
+}
+
+func TestAccessCheckContextVisibleToMatchConditions(t *testing.T) {
+	conditions, err := CompileMatchConditions([]config.MatchCondition{{
+		Name:       "portal-only",
+		Expression: "request.extra['check.azimuth-cloud.io/service'] == ['portal']",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := CreateAccessCheckHandler(WebhookConfig{Config: DefaultPolicyConfig, MatchConditions: conditions})
+	_, response := accessCheckTest(t, handler, `{"subject":{"user":"not-admin"},"action":"get","resource":{"namespace":"kube-system","type":"secrets"},"context":{"service":"zenith"}}`)
+	if !response.Allowed {
+		t.Errorf("Expected request from other services to be excluded, got %+v", response)
+	}
+}
+
+func TestAccessCheckRejectsInvalidRequests(t *testing.T) {
+	handler := CreateAccessCheckHandler(WebhookConfig{Config: DefaultPolicyConfig})
+	for _, body := range []string{
+		`{bad json}`,
+		`{"action":"get","resource":{"type":"pods"}}`,
+		`{"subject":{"user":"a"},"resource":{"type":"pods"}}`,
+		`{"subject":{"user":"a"},"action":"get","resource":{}}`,
+		`{"subject":{"user":"a"},"action":"get","resource":{"type":"pods","path":"/"}}`,
+	} {
+		if code, _ := accessCheckTest(t, handler, body); code != http.StatusBadRequest {
+			t.Errorf("Expected %s to be rejected, got %d", body, code)
+		}
+	}
+}
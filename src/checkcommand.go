@@ -0,0 +1,179 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/internal/exitcode"
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/client"
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/policy"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"net/http"
+	"os"
+	"sigs.k8s.io/yaml"
+	"strings"
+)
+
+// Reads a SubjectAccessReview, or its Local and Self variants, as JSON or YAML
+func readCheckSAR(path string) (policy.SubjectAccessReview, error) {
+	var sar policy.SubjectAccessReview
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return sar, err
+	}
+	if data, err = yaml.YAMLToJSON(data); err != nil {
+		return sar, err
+	}
+	if err := json.Unmarshal(data, &sar); err != nil {
+		return sar, err
+	}
+	return sar, nil
+}
+
+// Returns SubjectAccessReview for a request by user, for path if set and otherwise resource
+func newRequestSAR(user string, groups []string, verb string, resource authorizationv1.ResourceAttributes, path string) policy.SubjectAccessReview {
+	if path != "" {
+		return client.NewNonResourceRequest(user, groups, verb, path)
+	}
+	resource.Verb = verb
+	return client.NewResourceRequest(user, groups, resource)
+}
+
+// Evaluates a SubjectAccessReview against a local policy, giving policy authors a fast feedback loop.
+// Exits 0 unless the request is denied
+func runCheck(args []string, out io.Writer) int {
+	flags := flag.NewFlagSet("check", flag.ContinueOnError)
+	file := flags.String("file", "", "JSON or YAML SubjectAccessReview to evaluate, '-' for stdin. Built from the request flags if empty")
+	loadPolicy := addPolicyFlags(flags)
+	user := flags.String("user", "", "User making the request")
+	groupsCSL := flags.String("groups", "", "Comma separated groups of the user")
+	verb := flags.String("verb", "", "Verb of the request, e.g. get")
+	group := flags.String("group", "", "API group of the resource")
+	resource := flags.String("resource", "", "Resource type, e.g. secrets")
+	subresource := flags.String("subresource", "", "Subresource, e.g. log")
+	namespace := flags.String("namespace", "", "Namespace of the resource, all namespaces if empty")
+	name := flags.String("name", "", "Name of the resource")
+	path := flags.String("path", "", "Non-resource URL path, instead of a resource")
+	output := flags.String("output", "text", "Output format. Values: [text, json]")
+	trace := flags.Bool("trace", false, "Also print the classifications of the request and the outcome of every rule")
+	if err := flags.Parse(args); err != nil {
+		return exitcode.Usage
+	}
+	if *output != "text" && *output != "json" {
+		fmt.Fprintf(os.Stderr, "error: unknown output format %q\n", *output)
+		return exitcode.Usage
+	}
+
+	policyFile, err := loadPolicy()
+	if err != nil {
+		return exitcode.Report(os.Stderr, err)
+	}
+
+	var sar policy.SubjectAccessReview
+	if *file != "" {
+		if sar, err = readCheckSAR(*file); err != nil {
+			return exitcode.Report(os.Stderr, err)
+		}
+	} else {
+		var groups []string
+		if *groupsCSL != "" {
+			groups = strings.Split(*groupsCSL, ",")
+		}
+		sar = newRequestSAR(*user, groups, *verb, authorizationv1.ResourceAttributes{
+			Namespace:   *namespace,
+			Group:       *group,
+			Resource:    *resource,
+			Subresource: *subresource,
+			Name:        *name,
+		}, *path)
+	}
+	err = policy.Normalize(&sar, http.Header{})
+	if err == nil {
+		err = policy.Validate(sar)
+	}
+	if err != nil {
+		return exitcode.Report(os.Stderr, err)
+	}
+
+	compiled := policy.Compile(policyFile.PolicyConfig())
+	traced := policy.TraceDecision(sar, compiled, policyFile.AllowOpinionMode)
+	explanation := traced.Explanation
+	if *output == "json" {
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		if *trace {
+			encoder.Encode(traced)
+		} else {
+			encoder.Encode(explanation)
+		}
+	} else {
+		fmt.Fprintf(out, "Decision: %s\nRule:     %s\n", explanation.Decision, explanation.Rule)
+		if explanation.Reason != "" {
+			fmt.Fprintf(out, "Reason:   %s\n", explanation.Reason)
+		}
+		if explanation.ProtectedBy != "" {
+			fmt.Fprintf(out, "Namespace protected by entry %q\n", explanation.ProtectedBy)
+		}
+		if explanation.PrivilegedSystemUser {
+			fmt.Fprintln(out, "User is a privileged system user")
+		}
+		if *trace {
+			printTrace(out, traced, "")
+		}
+	}
+	if explanation.Decision == "denied" {
+		return exitcode.Denied
+	}
+	return 0
+}
+
+// Prints the classifications and rules of trace, then those of the impersonator's trace if any
+func printTrace(out io.Writer, trace policy.Trace, indent string) {
+	c := trace.Classifications
+	fmt.Fprintf(out, "%sClassifications:\n", indent)
+	for _, classification := range []struct {
+		name  string
+		value bool
+	}{
+		{"additional privileged user", c.AdditionalPrivilegedUser},
+		{"privileged system user", c.PrivilegedSystemUser},
+		{"protected namespace", c.ProtectedNamespace},
+		{"all namespaces", c.AllNamespaces},
+		{"secret", c.Secret},
+		{"read-only verb", c.ReadonlyVerb},
+		{"* resource", c.AllResources},
+		{"* verb or resource", c.Wildcard},
+	} {
+		fmt.Fprintf(out, "%s  %-27s %t\n", indent, classification.name+":", classification.value)
+	}
+	if c.ProtectedBy != "" {
+		fmt.Fprintf(out, "%s  %-27s %s\n", indent, "protected by:", c.ProtectedBy)
+	}
+	if c.ExemptedBy != "" {
+		fmt.Fprintf(out, "%s  %-27s %s\n", indent, "exempted by:", c.ExemptedBy)
+	}
+	if c.VerbClass != "" {
+		fmt.Fprintf(out, "%s  %-27s %s\n", indent, "verb class:", c.VerbClass)
+	}
+	fmt.Fprintf(out, "%sRules:\n", indent)
+	for _, rule := range trace.Rules {
+		outcome := "doesn't apply"
+		if rule.Decisive {
+			outcome = "applies, decisive"
+		} else if rule.Applies {
+			outcome = "applies"
+		}
+		fmt.Fprintf(out, "%s  %-38s %-17s %s\n", indent, rule.Rule, outcome, rule.Description)
+	}
+	if trace.Impersonator != nil {
+		fmt.Fprintf(out, "%sImpersonator %s, decision %s by %s:\n", indent, c.Impersonator, trace.Impersonator.Decision, trace.Impersonator.Rule)
+		printTrace(out, *trace.Impersonator, indent+"  ")
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/internal/exitcode"
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/policy"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckCommandWithPolicyFile(t *testing.T) {
+	dir := t.TempDir()
+	policyPath := filepath.Join(dir, "policy.yaml")
+	sarPath := filepath.Join(dir, "sar.yaml")
+	os.WriteFile(policyPath, []byte("protectedNamespaces: [kube-system, 'openstack-*']\nadditionalPrivilegedUsers: [admin]\n"), 0o600)
+	os.WriteFile(sarPath, []byte(`apiVersion: authorization.k8s.io/v1
+kind: LocalSubjectAccessReview
+metadata:
+  namespace: openstack-keystone
+spec:
+  user: alice
+  resourceAttributes:
+    namespace: openstack-keystone
+    verb: delete
+    resource: pods
+`), 0o600)
+
+	var out bytes.Buffer
+	if code := runCheck([]string{"--policy-file", policyPath, "--file", sarPath, "--output", "json"}, &out); code != exitcode.Denied {
+		t.Fatalf("Expected denied exit code, got %d", code)
+	}
+	var explanation policy.Explanation
+	if err := json.Unmarshal(out.Bytes(), &explanation); err != nil {
+		t.Fatal(err)
+	}
+	if explanation.Decision != "denied" || explanation.Rule != policy.RuleProtectedWrite || explanation.ProtectedBy != "openstack-*" {
+		t.Errorf("Unexpected explanation %+v", explanation)
+	}
+}
+
+func TestCheckCommandWithRequestFlags(t *testing.T) {
+	tests := []struct {
+		args []string
+		code int
+		rule string
+	}{
+		{[]string{"--user", "alice", "--verb", "get", "--resource", "secrets"}, exitcode.Denied, policy.RuleProtectedSecrets},
+		{[]string{"--user", "alice", "--verb", "get", "--resource", "pods", "--namespace", "kube-system"}, 0, policy.RuleDefaultAllow},
+		{[]string{"--user", "admin", "--additional-privileged-users", "admin", "--verb", "delete", "--resource", "*", "--namespace", "kube-system"}, 0, policy.RuleAdditionalPrivilegedUser},
+		{[]string{"--user", "alice", "--verb", "get", "--path", "/healthz", "--allow-opinion-mode"}, 0, policy.RuleDefaultAllow},
+	}
+	for _, test := range tests {
+		var out bytes.Buffer
+		if code := runCheck(test.args, &out); code != test.code || !strings.Contains(out.String(), "Rule:     "+test.rule+"\n") {
+			t.Errorf("Expected exit code %d and rule %s for %v, got %d:\n%s", test.code, test.rule, test.args, code, out.String())
+		}
+	}
+
+	if code := runCheck([]string{"--verb", "get", "--resource", "pods"}, &bytes.Buffer{}); code != 1 {
+		t.Errorf("Expected request without user to be rejected, got exit code %d", code)
+	}
+}
+
+func TestCheckCommandTrace(t *testing.T) {
+	args := []string{"--protected-namespaces", "kube-system", "--user", "alice", "--verb", "create", "--resource", "pods", "--namespace", "kube-system", "--trace"}
+	var out bytes.Buffer
+	if code := runCheck(append(args, "--output", "json"), &out); code != exitcode.Denied {
+		t.Fatalf("Expected denied exit code, got %d", code)
+	}
+	var trace policy.Trace
+	if err := json.Unmarshal(out.Bytes(), &trace); err != nil {
+		t.Fatal(err)
+	}
+	if trace.Rule != policy.RuleProtectedWrite || !trace.Classifications.ProtectedNamespace || len(trace.Rules) == 0 {
+		t.Errorf("Unexpected trace %+v", trace)
+	}
+
+	out.Reset()
+	runCheck(args, &out)
+	if !strings.Contains(out.String(), "protected-namespace-write") || !strings.Contains(out.String(), "applies, decisive") {
+		t.Errorf("Expected rules in text output, got:\n%s", out.String())
+	}
+}
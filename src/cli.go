@@ -0,0 +1,146 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/internal/exitcode"
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/config"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"slices"
+	"strings"
+)
+
+// Version of the binary, set at build time with -ldflags "-X main.version=..."
+var version = "dev"
+
+// Command of the binary, run with the arguments following its name
+type subcommand struct {
+	run     func(args []string, out io.Writer) int
+	summary string
+}
+
+// Commands of the binary, by name. The webhook is served if no command is given, as deployments pass
+// the server's flags alone
+var subcommands = map[string]subcommand{
+	"analyze-rbac":       {runAnalyzeRBAC, "Report subjects whose RBAC permissions the policy would restrict"},
+	"can-i":              {runCanI, "Ask a running webhook whether a request would be allowed"},
+	"check":              {runCheck, "Evaluate a SubjectAccessReview against a local policy"},
+	"conformance":        {runConformance, "Check a cluster's decisions agree with the local policy"},
+	"gen-manifests":      {runGenManifests, "Generate deployment manifests for the webhook with the given server flags"},
+	"gen-webhook-config": {runGenWebhookConfig, "Generate apiserver configuration for the webhook"},
+	"import-policy":      {runImportPolicy, "Suggest policy from Gatekeeper constraints and Kyverno policies"},
+	"render-policy":      {runRenderPolicy, "Print the local policy with the overlays matching a cluster applied"},
+	"replay":             {runReplay, "Replay a recorded corpus against a local policy, reporting changed decisions"},
+	"report":             {runReport, "Report denials per tenant from a running webhook or an audit file"},
+	"schema":             {runSchema, "Print the JSON Schema of policy, overlay or settings files for editors"},
+	"serve":              {runServe, "Serve the webhook, the default if no command is given"},
+	"summarize":          {runSummarize, "Summarize policy changes and the recorded decisions they change as JSON for review tooling"},
+	"top":                {runTop, "Show live decision rates and recent denials from a running webhook"},
+	"validate":           {runValidate, "Validate a local policy and self-test it against the built-in corpus"},
+	"version":            {runVersion, "Print the version"},
+}
+
+func main() {
+	name, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	if name == "help" {
+		printUsage(os.Stdout)
+		os.Exit(0)
+	}
+	command, ok := subcommands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "error: unknown command %q\n\n", name)
+		printUsage(os.Stderr)
+		os.Exit(exitcode.Usage)
+	}
+	os.Exit(command.run(args, os.Stdout))
+}
+
+func printUsage(out io.Writer) {
+	fmt.Fprintln(out, "Usage: azimuth-authorization-webhook [command] [flags]")
+	fmt.Fprintln(out, "\nCommands:")
+	names := make([]string, 0, len(subcommands))
+	for name := range subcommands {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		fmt.Fprintf(out, "  %-20s%s\n", name, subcommands[name].summary)
+	}
+	fmt.Fprintln(out, "\nRun 'azimuth-authorization-webhook COMMAND -h' for the flags of a command")
+}
+
+func runServe(args []string, _ io.Writer) int {
+	return serve(args, nil)
+}
+
+func runGenManifests(args []string, out io.Writer) int {
+	return serve(nil, func(webhookFlags *flag.FlagSet) int {
+		return genManifests(args, out, webhookFlags)
+	})
+}
+
+func runVersion(args []string, out io.Writer) int {
+	flags := flag.NewFlagSet("version", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return exitcode.Usage
+	}
+	fmt.Fprintf(out, "azimuth-authorization-webhook %s", version)
+	if revision := buildRevision(); revision != "" {
+		fmt.Fprintf(out, " (%s)", revision)
+	}
+	fmt.Fprintf(out, " %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	return 0
+}
+
+// Returns the commit the binary was built from, empty if it wasn't built from a checkout
+func buildRevision() string {
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" {
+				return setting.Value
+			}
+		}
+	}
+	return ""
+}
+
+var buildInfo = Metrics.NewInfoFunc("azimuth_authz_build_info", "Version, commit and Go version the webhook was built with",
+	func() []string { return []string{version, buildRevision(), runtime.Version()} }, "version", "revision", "go_version")
+
+// Registers the flags giving the policy to commands evaluating it locally, returning the function loading
+// it once they're parsed
+func addPolicyFlags(flags *flag.FlagSet) func() (config.PolicyFile, error) {
+	policyFilePath := flags.String("policy-file", "", "YAML policy file, overriding the policy flags")
+	protectedNamespacesCSL := flags.String("protected-namespaces", "kube-system,openstack-system", "Comma separated list of protected namespaces")
+	additionalPrivilegedUsersCSL := flags.String("additional-privileged-users", "", "Comma separated list of users given read/write access to protected namespaces")
+	opinionMode := flags.Bool("allow-opinion-mode", false, "Whether the webhook gives its opinion on requests it doesn't deny")
+	return func() (config.PolicyFile, error) {
+		if *policyFilePath != "" {
+			policyFile, err := config.LoadPolicyFile(*policyFilePath)
+			return policyFile, policyError(err)
+		}
+		policyFile := config.PolicyFile{
+			ProtectedNamespaces:       strings.Split(*protectedNamespacesCSL, ","),
+			AdditionalPrivilegedUsers: strings.Split(*additionalPrivilegedUsersCSL, ","),
+			AllowOpinionMode:          *opinionMode,
+		}
+		return policyFile, policyError(policyFile.PolicyConfig().Validate())
+	}
+}
+
+// Returns error loading policy, exiting with exitcode.Policy unless it is a file which can't be read
+func policyError(err error) error {
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		return err
+	}
+	return exitcode.Wrap(exitcode.Policy, err)
+}
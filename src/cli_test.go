@@ -0,0 +1,128 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/internal/exitcode"
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/config"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVersionCommand(t *testing.T) {
+	var out bytes.Buffer
+	if code := runVersion(nil, &out); code != 0 || !strings.HasPrefix(out.String(), "azimuth-authorization-webhook dev") {
+		t.Errorf("Unexpected version output %q, exit code %d", out.String(), code)
+	}
+}
+
+func TestUsageListsCommands(t *testing.T) {
+	var out bytes.Buffer
+	printUsage(&out)
+	for name := range subcommands {
+		if !strings.Contains(out.String(), "  "+name+" ") {
+			t.Errorf("Usage doesn't list command %s:\n%s", name, out.String())
+		}
+	}
+}
+
+func TestServeRejectsArguments(t *testing.T) {
+	if code := serve([]string{"--protected-namespaces", "kube-system", "extra"}, nil); code != 2 {
+		t.Errorf("Expected exit code 2 for an unexpected argument, got %d", code)
+	}
+	if code := serve([]string{"--no-such-flag"}, nil); code != 2 {
+		t.Errorf("Expected exit code 2 for an unknown flag, got %d", code)
+	}
+}
+
+func TestValidateCommand(t *testing.T) {
+	var out bytes.Buffer
+	if code := runValidate([]string{"--protected-namespaces", "kube-system,tenant-*"}, &out); code != 0 {
+		t.Errorf("Expected valid policy, got exit code %d:\n%s", code, out.String())
+	}
+	out.Reset()
+	if code := runValidate([]string{"--protected-namespaces", "kube-system,["}, &out); code != exitcode.Policy {
+		t.Errorf("Expected invalid policy, got exit code %d:\n%s", code, out.String())
+	}
+}
+
+func TestServeRejectsInvalidSettings(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "settings.yaml")
+	os.WriteFile(path, []byte("protected-namespace: [kube-system]\n"), 0o600)
+	if code := serve([]string{"--config-file", path}, nil); code != exitcode.Config {
+		t.Errorf("Expected configuration error for an unknown setting, got exit code %d", code)
+	}
+	// Startup failures are returned rather than exiting, so deferred cleanup runs
+	if code := serve([]string{"--protected-namespaces", "kube-system,["}, nil); code != exitcode.Policy {
+		t.Errorf("Expected policy error for an invalid protected namespace, got exit code %d", code)
+	}
+	if code := serve([]string{"--load-shed-mode", "drop"}, nil); code != exitcode.Config {
+		t.Errorf("Expected configuration error for an unknown load shedding mode, got exit code %d", code)
+	}
+	if code := serve([]string{"--enable-pprof-endpoints"}, nil); code != exitcode.Config {
+		t.Errorf("Expected configuration error for profiling endpoints without the admin listener, got exit code %d", code)
+	}
+	if code := serve([]string{"--simulation-header", "X-Azimuth-Authz-Dry-Run"}, nil); code != exitcode.Config {
+		t.Errorf("Expected configuration error for simulations without clusters, got exit code %d", code)
+	}
+	if code := serve([]string{"--simulation-header", "X-Azimuth-Authz-Dry-Run", "--simulation-clusters", "az-staging/*"}, nil); code != exitcode.Config {
+		t.Errorf("Expected configuration error for simulations without cluster identification, got exit code %d", code)
+	}
+}
+
+func TestSchemaCommand(t *testing.T) {
+	for _, kind := range []string{"policy", "overlays", "settings"} {
+		var out bytes.Buffer
+		if code := runSchema([]string{"--kind", kind}, &out); code != 0 {
+			t.Errorf("Expected %s schema, got exit code %d", kind, code)
+		}
+		var schema config.Schema
+		if err := json.Unmarshal(out.Bytes(), &schema); err != nil || !strings.HasSuffix(schema["$id"].(string), ":v1") {
+			t.Errorf("Unexpected %s schema %s: %v", kind, out.String(), err)
+		}
+	}
+	var out bytes.Buffer
+	runSchema([]string{"--kind", "settings"}, &out)
+	if !strings.Contains(out.String(), `"protected-namespaces"`) || strings.Contains(out.String(), `"config-file"`) {
+		t.Errorf("Expected settings schema to list the server's flags but --config-file:\n%s", out.String())
+	}
+	if code := runSchema([]string{"--kind", "kubeconfig"}, &out); code != 2 {
+		t.Errorf("Expected exit code 2 for an unknown kind, got %d", code)
+	}
+}
+
+func TestExitCodes(t *testing.T) {
+	dir := t.TempDir()
+	invalidPolicy := filepath.Join(dir, "policy.yaml")
+	os.WriteFile(invalidPolicy, []byte("protectedNamespaces: kube-system\n"), 0o600)
+	cases := []struct {
+		args []string
+		code int
+	}{
+		{[]string{"--no-such-flag"}, exitcode.Usage},
+		{[]string{"--policy-file", filepath.Join(dir, "missing.yaml")}, exitcode.Config},
+		{[]string{"--policy-file", invalidPolicy}, exitcode.Policy},
+		{[]string{"--protected-namespaces", "kube-system,["}, exitcode.Policy},
+	}
+	for _, c := range cases {
+		if code := runValidate(c.args, &bytes.Buffer{}); code != c.code {
+			t.Errorf("Expected exit code %d for %v, got %d", c.code, c.args, code)
+		}
+	}
+
+	_, err := http.Get("http://127.0.0.1:1")
+	if code := exitcode.Of(fmt.Errorf("fetching report: %w", err)); code != exitcode.Connectivity {
+		t.Errorf("Expected connection failure to be a connectivity error, got %d", code)
+	}
+	if code := exitcode.Of(exitcode.Wrap(exitcode.Config, err)); code != exitcode.Config {
+		t.Errorf("Expected the code errors are wrapped with to take precedence, got %d", code)
+	}
+	if exitcode.Wrap(exitcode.Policy, nil) != nil || exitcode.Of(nil) != exitcode.OK || exitcode.Of(errors.New("malformed")) != exitcode.Failure {
+		t.Error("Expected nil errors to exit 0 and unclassified errors 1")
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/internal/exitcode"
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/config"
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/policy"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Unprotected namespace used when the policy's namespaces don't rule it out
+const conformanceUnprotectedNamespace = "azimuth-conformance"
+
+// Request checked by the conformance command, with the decision the local policy makes for it
+type conformanceCase struct {
+	User       string
+	Groups     []string
+	Attributes authorizationv1.ResourceAttributes
+	Expected   policy.Explanation
+}
+
+func (c conformanceCase) String() string {
+	namespace := c.Attributes.Namespace
+	if namespace == "" {
+		namespace = "<all>"
+	}
+	return fmt.Sprintf("%s %s in %s as %s", c.Attributes.Verb, c.Attributes.Resource, namespace, c.User)
+}
+
+// Returns a namespace matched by a protected namespace entry, or the empty string for patterns too
+// complex to instantiate
+func conformanceNamespace(entry string) string {
+	if strings.ContainsAny(entry, "[\\") {
+		return ""
+	}
+	return strings.NewReplacer("*", "conformance", "?", "x").Replace(entry)
+}
+
+// Returns the matrix of users, namespaces, verbs and resources exercising each rule of policy
+func conformanceCases(policyFile config.PolicyFile) []conformanceCase {
+	compiled := policy.Compile(policyFile.PolicyConfig())
+
+	namespaces := []string{""}
+	seen := stringSet{}
+	for _, entry := range policyFile.ProtectedNamespaces {
+		namespace := conformanceNamespace(entry)
+		if namespace != "" && !seen.Has(namespace) {
+			seen[namespace] = struct{}{}
+			namespaces = append(namespaces, namespace)
+		}
+	}
+	if !compiled.IsProtectedNamespace(conformanceUnprotectedNamespace) {
+		namespaces = append(namespaces, conformanceUnprotectedNamespace)
+	}
+
+	type subject struct {
+		user   string
+		groups []string
+	}
+	subjects := []subject{{"azimuth-conformance:unprivileged", []string{"azimuth-conformance"}}}
+	if privileged := toSet(policyFile.AdditionalPrivilegedUsers); len(privileged) > 0 {
+		subjects = append(subjects, subject{sortedKeys(privileged)[0], nil})
+	}
+	if len(namespaces) > 1 && compiled.IsProtectedNamespace(namespaces[1]) {
+		subjects = append(subjects, subject{policy.ServiceAccountUserPrefix + namespaces[1] + ":conformance", []string{"system:serviceaccounts"}})
+	}
+	subjects = append(subjects, subject{policy.NodeUserPrefix + "conformance", []string{"system:nodes"}})
+
+	var cases []conformanceCase
+	for _, subject := range subjects {
+		for _, namespace := range namespaces {
+			for _, verb := range []string{"get", "list", "create", "delete"} {
+				for _, resource := range []string{"pods", "secrets", "*"} {
+					c := conformanceCase{
+						User:       subject.user,
+						Groups:     subject.groups,
+						Attributes: authorizationv1.ResourceAttributes{Namespace: namespace, Verb: verb, Resource: resource},
+					}
+					sar := newRequestSAR(c.User, c.Groups, verb, c.Attributes, "")
+					c.Expected = policy.Explain(sar, compiled, policyFile.AllowOpinionMode)
+					cases = append(cases, c)
+				}
+			}
+		}
+	}
+	return cases
+}
+
+// Asks the apiserver whether the impersonated user can make the request, returning its decision
+func clusterAccessReview(client *http.Client, conn *ClusterConnection, c conformanceCase) (authorizationv1.SubjectAccessReviewStatus, error) {
+	var review authorizationv1.SelfSubjectAccessReview
+	review.APIVersion = "authorization.k8s.io/v1"
+	review.Kind = "SelfSubjectAccessReview"
+	attributes := c.Attributes
+	review.Spec.ResourceAttributes = &attributes
+	body, err := json.Marshal(review)
+	if err != nil {
+		return review.Status, err
+	}
+	req, err := http.NewRequest(http.MethodPost, conn.Server+"/apis/authorization.k8s.io/v1/selfsubjectaccessreviews", bytes.NewReader(body))
+	if err != nil {
+		return review.Status, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if conn.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+conn.BearerToken)
+	}
+	req.Header.Set("Impersonate-User", c.User)
+	for _, group := range c.Groups {
+		req.Header.Add("Impersonate-Group", group)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return review.Status, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return review.Status, fmt.Errorf("unexpected status from apiserver: %s: %s", resp.Status, bytes.TrimSpace(message))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&review); err != nil {
+		return review.Status, fmt.Errorf("decoding SelfSubjectAccessReview: %w", err)
+	}
+	return review.Status, nil
+}
+
+// Checks that a cluster with the webhook installed enforces the local policy end to end, by asking the
+// apiserver about a matrix of requests while impersonating users the policy treats differently. Only
+// denials are compared, as requests the webhook doesn't deny are decided by the cluster's other authorizers
+func runConformance(args []string, out io.Writer) int {
+	flags := flag.NewFlagSet("conformance", flag.ContinueOnError)
+	kubeconfigPath := flags.String("kubeconfig", "", "Kubeconfig for the cluster under test, whose user must be allowed to impersonate users and groups. Required")
+	contextName := flags.String("context", "", "Context to use from the kubeconfig, current context if empty")
+	loadPolicy := addPolicyFlags(flags)
+	timeout := flags.Duration("timeout", 10*time.Second, "Timeout for each request to the apiserver")
+	verbose := flags.Bool("v", false, "Print every case, not just failures")
+	if err := flags.Parse(args); err != nil {
+		return exitcode.Usage
+	}
+	if *kubeconfigPath == "" {
+		fmt.Fprintln(os.Stderr, "error: --kubeconfig is required")
+		return exitcode.Usage
+	}
+
+	policyFile, err := loadPolicy()
+	if err != nil {
+		return exitcode.Report(os.Stderr, err)
+	}
+	conn, err := LoadKubeconfig(*kubeconfigPath, *contextName)
+	if err != nil {
+		return exitcode.Report(os.Stderr, exitcode.Wrap(exitcode.Config, err))
+	}
+	client := &http.Client{Timeout: *timeout, Transport: &http.Transport{TLSClientConfig: conn.TLSConfig}}
+
+	cases := conformanceCases(policyFile)
+	failures, errored := 0, 0
+	for _, c := range cases {
+		status, err := clusterAccessReview(client, conn, c)
+		expectDenied := c.Expected.Decision == "denied"
+		switch {
+		case err != nil:
+			failures++
+			errored++
+			fmt.Fprintf(out, "ERROR %s: %s\n", c, err)
+		case status.Denied != expectDenied:
+			failures++
+			fmt.Fprintf(out, "FAIL  %s: expected denied=%t by rule %s, cluster gave denied=%t (%s)\n",
+				c, expectDenied, c.Expected.Rule, status.Denied, status.Reason)
+		case *verbose:
+			fmt.Fprintf(out, "PASS  %s: denied=%t by rule %s\n", c, expectDenied, c.Expected.Rule)
+		}
+	}
+	fmt.Fprintf(out, "%d cases, %d failed\n", len(cases), failures)
+	switch {
+	case failures > errored:
+		return exitcode.Mismatch
+	case errored > 0:
+		return exitcode.Connectivity
+	}
+	return 0
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/internal/exitcode"
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/config"
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/policy"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Fake apiserver answering SelfSubjectAccessReviews for impersonated users with the webhook's decisions
+func newConformanceAPIServer(t *testing.T, config WebhookConfig) *httptest.Server {
+	evaluate := newEvaluator(config)
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/apis/authorization.k8s.io/v1/selfsubjectaccessreviews" || r.Header.Get("Authorization") != "Bearer admin" {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		var sar policy.SubjectAccessReview
+		if err := json.NewDecoder(r.Body).Decode(&sar); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		sar.Spec.User = r.Header.Get("Impersonate-User")
+		sar.Spec.Groups = r.Header.Values("Impersonate-Group")
+		review := toUpstreamSAR(sar)
+		review.Status = evaluate(r.Context(), sar)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(review)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestConformancePassesWhenClusterEnforcesPolicy(t *testing.T) {
+	server := newConformanceAPIServer(t, WebhookConfig{Config: policy.Config{
+		ProtectedNamespaces:       []string{"kube-system", "openstack-*"},
+		AdditionalPrivilegedUsers: []string{"admin"},
+	}})
+	kubeconfig := writeKubeconfig(t, server, "    token: admin\n")
+
+	var out bytes.Buffer
+	code := runConformance([]string{"--kubeconfig", kubeconfig, "--protected-namespaces", "kube-system,openstack-*", "--additional-privileged-users", "admin"}, &out)
+	if code != 0 || !strings.HasSuffix(out.String(), " 0 failed\n") {
+		t.Errorf("Expected conformance to pass, got exit code %d:\n%s", code, out.String())
+	}
+}
+
+func TestConformanceReportsUnenforcedDenials(t *testing.T) {
+	// The cluster's webhook doesn't protect openstack namespaces
+	server := newConformanceAPIServer(t, WebhookConfig{Config: policy.Config{ProtectedNamespaces: []string{"kube-system"}}})
+	kubeconfig := writeKubeconfig(t, server, "    token: admin\n")
+
+	var out bytes.Buffer
+	if code := runConformance([]string{"--kubeconfig", kubeconfig, "--protected-namespaces", "kube-system,openstack-*"}, &out); code != exitcode.Mismatch {
+		t.Errorf("Expected conformance failure, got exit code %d", code)
+	}
+	if !strings.Contains(out.String(), "FAIL  create pods in openstack-conformance as azimuth-conformance:unprivileged: expected denied=true by rule protected-namespace-write") {
+		t.Errorf("Expected failure for unprotected openstack namespace, got:\n%s", out.String())
+	}
+}
+
+func TestConformanceCasesCoverRules(t *testing.T) {
+	cases := conformanceCases(config.PolicyFile{ProtectedNamespaces: []string{"kube-system", "*-system", "[ab]-ns"}, AdditionalPrivilegedUsers: []string{"admin"}})
+	rules := stringSet{}
+	namespaces := stringSet{}
+	for _, c := range cases {
+		rules[c.Expected.Rule] = struct{}{}
+		namespaces[c.Attributes.Namespace] = struct{}{}
+	}
+	for _, rule := range []string{policy.RuleAdditionalPrivilegedUser, policy.RuleProtectedAllResources, policy.RuleProtectedSecrets, policy.RuleProtectedWrite, policy.RuleDefaultAllow} {
+		if !rules.Has(rule) {
+			t.Errorf("Expected a case decided by rule %s", rule)
+		}
+	}
+	if len(namespaces) != 4 || !namespaces.Has("conformance-system") || !namespaces.Has(conformanceUnprotectedNamespace) {
+		t.Errorf("Unexpected namespaces %v", sortedKeys(namespaces))
+	}
+}
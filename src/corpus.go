@@ -0,0 +1,142 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/policy"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"log"
+	mathrand "math/rand/v2"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var corpusRecords = Metrics.NewCounterVec("azimuth_authz_corpus_records_total",
+	"SubjectAccessReviews considered for the recorded corpus, by result", "result")
+
+// Line of a recorded corpus file: a SubjectAccessReview as received, after sanitisation, and the
+// decision it was given
+type CorpusRecord struct {
+	Time    time.Time                                 `json:"time"`
+	Cluster string                                    `json:"cluster,omitempty"`
+	Request authorizationv1.SubjectAccessReview       `json:"request"`
+	Status  authorizationv1.SubjectAccessReviewStatus `json:"status"`
+}
+
+type CorpusRecorderOptions struct {
+	// Fraction of requests recorded, between 0 and 1
+	SampleRate float64
+	// Recording stops after this many records. Unlimited if 0
+	MaxRecords int64
+	// Replace users and groups, other than system: ones, with stable pseudonyms
+	Pseudonymize bool
+}
+
+// Appends sampled SubjectAccessReviews to a JSON lines corpus for replay and load testing. Records are
+// written by a background goroutine, and dropped rather than delaying decisions if it falls behind
+type CorpusRecorder struct {
+	options  CorpusRecorderOptions
+	file     *os.File
+	queue    chan CorpusRecord
+	recorded atomic.Int64
+	// Keys pseudonyms, so they are stable within a run but can't be reversed by hashing known names
+	pseudonymKey []byte
+	wg           sync.WaitGroup
+	closeOnce    sync.Once
+}
+
+func NewCorpusRecorder(path string, options CorpusRecorderOptions) (*CorpusRecorder, error) {
+	pseudonymKey := make([]byte, 32)
+	if _, err := rand.Read(pseudonymKey); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	r := &CorpusRecorder{options: options, file: file, queue: make(chan CorpusRecord, 1024), pseudonymKey: pseudonymKey}
+	r.wg.Add(1)
+	go r.run()
+	return r, nil
+}
+
+// Queues sar for recording if it is sampled. Safe to call on a nil recorder
+func (r *CorpusRecorder) Record(sar policy.SubjectAccessReview, cluster string, status authorizationv1.SubjectAccessReviewStatus) {
+	if r == nil {
+		return
+	}
+	if mathrand.Float64() >= r.options.SampleRate {
+		corpusRecords.Inc("sampled-out")
+		return
+	}
+	if r.options.MaxRecords > 0 && r.recorded.Add(1) > r.options.MaxRecords {
+		corpusRecords.Inc("limit-reached")
+		return
+	}
+	record := CorpusRecord{Time: time.Now(), Cluster: cluster, Request: r.sanitise(sar), Status: status}
+	select {
+	case r.queue <- record:
+	default:
+		corpusRecords.Inc("dropped")
+	}
+}
+
+// Returns the request without the UID and extras, which may hold session identifiers, with users and
+// groups pseudonymised if configured
+func (r *CorpusRecorder) sanitise(sar policy.SubjectAccessReview) authorizationv1.SubjectAccessReview {
+	request := toUpstreamSAR(sar)
+	// Local and Self variants have been normalised into the spec
+	request.TypeMeta.Kind = "SubjectAccessReview"
+	request.ObjectMeta = metav1.ObjectMeta{}
+	request.Spec.UID = ""
+	request.Spec.Extra = nil
+	request.Status = authorizationv1.SubjectAccessReviewStatus{}
+	if r.options.Pseudonymize {
+		request.Spec.User = r.pseudonym(request.Spec.User)
+		groups := make([]string, len(request.Spec.Groups))
+		for i, group := range request.Spec.Groups {
+			groups[i] = r.pseudonym(group)
+		}
+		request.Spec.Groups = groups
+	}
+	return request
+}
+
+func (r *CorpusRecorder) pseudonym(name string) string {
+	if name == "" || strings.HasPrefix(name, "system:") {
+		return name
+	}
+	mac := hmac.New(sha256.New, r.pseudonymKey)
+	mac.Write([]byte(name))
+	return "anon-" + hex.EncodeToString(mac.Sum(nil)[:8])
+}
+
+func (r *CorpusRecorder) run() {
+	defer r.wg.Done()
+	encoder := json.NewEncoder(r.file)
+	for record := range r.queue {
+		if err := encoder.Encode(record); err != nil {
+			log.Println("Error writing corpus record:", err)
+			corpusRecords.Inc("error")
+			continue
+		}
+		corpusRecords.Inc("recorded")
+	}
+}
+
+// Writes queued records and closes the corpus file. Safe to call on a nil recorder
+func (r *CorpusRecorder) Close() {
+	if r == nil {
+		return
+	}
+	r.closeOnce.Do(func() { close(r.queue) })
+	r.wg.Wait()
+	r.file.Close()
+}
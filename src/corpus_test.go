@@ -0,0 +1,76 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/policy"
+	"bufio"
+	"encoding/json"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCorpusRecorderSanitisesRequests(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "corpus.jsonl")
+	recorder, err := NewCorpusRecorder(path, CorpusRecorderOptions{SampleRate: 1, MaxRecords: 2, Pseudonymize: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	authorizer := CreateWebhookAuthorizer(WebhookConfig{Config: DefaultPolicyConfig, Corpus: recorder})
+	request := []byte(`{
+		"apiVersion":"authorization.k8s.io/v1",
+		"kind":"SubjectAccessReview",
+		"spec":{
+			"resourceAttributes":{"namespace":"kube-system","verb":"delete","resource":"pods"},
+			"user":"alice",
+			"groups":["staff","system:authenticated"],
+			"uid":"1234",
+			"extra":{"authentication.kubernetes.io/credential-id":["JTI=secret"]}
+		}
+	}`)
+	for i := 0; i < 3; i++ {
+		accessTest(t, authorizer, true, request)
+	}
+	recorder.Close()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var records []CorpusRecord
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record CorpusRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+	if len(records) != 2 {
+		t.Fatalf("Expected recording to stop after 2 records, got %d", len(records))
+	}
+	spec := records[0].Request.Spec
+	if spec.UID != "" || spec.Extra != nil {
+		t.Errorf("Expected UID and extras to be removed, got %+v", spec)
+	}
+	if spec.User == "alice" || spec.User != records[1].Request.Spec.User || spec.Groups[0] == "staff" || spec.Groups[1] != "system:authenticated" {
+		t.Errorf("Expected stable pseudonyms for non-system names, got %q %v", spec.User, spec.Groups)
+	}
+	if spec.ResourceAttributes == nil || spec.ResourceAttributes.Namespace != "kube-system" || !records[0].Status.Denied {
+		t.Errorf("Expected request attributes and decision to be recorded, got %+v", records[0])
+	}
+}
+
+func TestCorpusRecorderSampling(t *testing.T) {
+	recorder, err := NewCorpusRecorder(filepath.Join(t.TempDir(), "corpus.jsonl"), CorpusRecorderOptions{SampleRate: 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer recorder.Close()
+	before := corpusRecords.Value("sampled-out")
+	recorder.Record(policy.SubjectAccessReview{}, "", authorizationv1.SubjectAccessReviewStatus{})
+	if corpusRecords.Value("sampled-out") != before+1 {
+		t.Error("Expected request to be sampled out")
+	}
+}
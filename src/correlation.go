@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Source of audit events recorded by the admission endpoint, those of authorization decisions having none
+const admissionEventSource = "admission"
+
+// Outcomes of correlated decisions
+const (
+	// Denied by the webhook when authorized, so never reached admission
+	correlationDenied = "denied"
+	// Authorized, then admitted, so the change was made unless a later admission webhook rejected it
+	correlationAdmitted = "admitted"
+	// Authorized, or authorized by another authorizer, then rejected by the webhook at admission
+	correlationRejected = "rejected"
+	// Authorized within the window, so its admission may not have been seen yet
+	correlationPending = "pending"
+	// Authorized, but not admitted within the window, so failed elsewhere or wasn't sent to /admit
+	correlationUnadmitted = "unadmitted"
+)
+
+// Verbs of authorization requests which are admitted, as the admission operation they are admitted as
+var admittedVerbs = map[string]string{
+	"create":           "create",
+	"update":           "update",
+	"patch":            "update",
+	"delete":           "delete",
+	"deletecollection": "delete",
+}
+
+// An authorization decision and the admission decision for the same request, either of which may be
+// missing: authorizers before the webhook, such as RBAC, decide requests it never sees
+type CorrelatedDecision struct {
+	Authorization *AuditEvent `json:"authorization,omitempty"`
+	Admission     *AuditEvent `json:"admission,omitempty"`
+	Outcome       string      `json:"outcome"`
+}
+
+// Audit sink joining authorization decisions for writes with the admission decisions for the same user,
+// cluster and object which follow them within the window, so operators can see what a user attempted
+// beside what was changed. Holds the most recent size decisions
+type DecisionCorrelator struct {
+	mu      sync.Mutex
+	window  time.Duration
+	size    int
+	entries []*CorrelatedDecision
+}
+
+func NewDecisionCorrelator(size int, window time.Duration) *DecisionCorrelator {
+	return &DecisionCorrelator{size: size, window: window}
+}
+
+func (c *DecisionCorrelator) Name() string {
+	return "correlation"
+}
+
+func (c *DecisionCorrelator) Write(events []AuditEvent) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, event := range events {
+		if event.Simulated {
+			// Answered with no opinion, so never admitted as the decision recorded
+			continue
+		} else if event.Source == admissionEventSource {
+			c.admit(event)
+		} else if _, ok := admittedVerbs[event.Verb]; ok && event.Resource != "" {
+			c.entries = append(c.entries, &CorrelatedDecision{Authorization: &event})
+		}
+	}
+	if overflow := len(c.entries) - c.size; overflow > 0 {
+		c.entries = slices.Delete(c.entries, 0, overflow)
+	}
+	return nil
+}
+
+// Attaches the admission decision to the latest authorization decision it follows, or records it alone
+func (c *DecisionCorrelator) admit(event AuditEvent) {
+	for i := len(c.entries) - 1; i >= 0; i-- {
+		entry := c.entries[i]
+		if entry.Authorization == nil || entry.Admission != nil || entry.Authorization.Denied {
+			continue
+		}
+		if event.Time.Sub(entry.Authorization.Time) > c.window {
+			break
+		}
+		if correlates(*entry.Authorization, event) {
+			entry.Admission = &event
+			return
+		}
+	}
+	c.entries = append(c.entries, &CorrelatedDecision{Admission: &event})
+}
+
+// Returns true if admission is plausibly of the request authorization authorized. Creates are authorized
+// without the name of the object, so any name matches
+func correlates(authorization AuditEvent, admission AuditEvent) bool {
+	return authorization.User == admission.User && authorization.Cluster == admission.Cluster &&
+		authorization.Resource == admission.Resource && authorization.Namespace == admission.Namespace &&
+		(authorization.Name == "" || authorization.Name == admission.Name) && admittedVerbs[authorization.Verb] == admission.Verb
+}
+
+func (c *DecisionCorrelator) outcome(entry *CorrelatedDecision, now time.Time) string {
+	switch {
+	case entry.Admission != nil && entry.Admission.Denied:
+		return correlationRejected
+	case entry.Admission != nil:
+		return correlationAdmitted
+	case entry.Authorization.Denied:
+		return correlationDenied
+	case now.Sub(entry.Authorization.Time) <= c.window:
+		return correlationPending
+	default:
+		return correlationUnadmitted
+	}
+}
+
+// Serves correlated decisions as JSON, newest first, filtered by ?user=, ?cluster=, ?namespace= and
+// ?outcome=, made since ?since=, a duration or RFC 3339 time, at most ?limit=, 100 by default
+func (c *DecisionCorrelator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	query := r.URL.Query()
+	var since time.Time
+	if value := query.Get("since"); value != "" {
+		parsed, err := parseSince(value, now)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid since %q, expected a duration or RFC 3339 time", value), http.StatusBadRequest)
+			return
+		}
+		since = parsed
+	}
+	limit := 100
+	if value := query.Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			http.Error(w, fmt.Sprintf("invalid limit %q, expected a positive number", value), http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+	matches := func(field string, value string) bool {
+		return query.Get(field) == "" || query.Get(field) == value
+	}
+
+	c.mu.Lock()
+	decisions := []CorrelatedDecision{}
+	for i := len(c.entries) - 1; i >= 0 && len(decisions) < limit; i-- {
+		decision := *c.entries[i]
+		decision.Outcome = c.outcome(&decision, now)
+		event := decision.Authorization
+		if event == nil {
+			event = decision.Admission
+		}
+		if event.Time.Before(since) {
+			break
+		}
+		if matches("user", event.User) && matches("cluster", event.Cluster) && matches("namespace", event.Namespace) && matches("outcome", decision.Outcome) {
+			decisions = append(decisions, decision)
+		}
+	}
+	c.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(decisions)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecisionCorrelator(t *testing.T) {
+	correlator := NewDecisionCorrelator(6, 30*time.Second)
+	now := time.Now()
+	authorization := func(offset time.Duration, user string, verb string, name string, denied bool) AuditEvent {
+		return AuditEvent{Time: now.Add(offset), Cluster: "az-acme/demo", User: user, Verb: verb, Resource: "configmaps", Namespace: "default", Name: name, Denied: denied, Allowed: !denied}
+	}
+	admission := func(offset time.Duration, user string, verb string, name string, denied bool) AuditEvent {
+		event := authorization(offset, user, verb, name, denied)
+		event.Source = admissionEventSource
+		return event
+	}
+	correlator.Write([]AuditEvent{
+		authorization(-5*time.Minute, "alice", "create", "", false),
+		admission(-5*time.Minute+time.Second, "alice", "create", "settings", true),
+		authorization(-4*time.Minute, "alice", "patch", "settings", false),
+		admission(-4*time.Minute+time.Second, "alice", "update", "settings", false),
+		authorization(-3*time.Minute, "bob", "delete", "settings", true),
+		authorization(-2*time.Minute, "bob", "update", "settings", false),
+		// Outside the window of bob's update
+		admission(-time.Minute, "bob", "update", "settings", false),
+		authorization(-time.Second, "carol", "create", "", false),
+		// Reads are never admitted
+		authorization(0, "carol", "get", "settings", false),
+	})
+
+	query := func(url string) []CorrelatedDecision {
+		resp := httptest.NewRecorder()
+		correlator.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, url, nil))
+		var decisions []CorrelatedDecision
+		if err := json.NewDecoder(resp.Body).Decode(&decisions); err != nil {
+			t.Fatalf("%s: %s", url, err)
+		}
+		return decisions
+	}
+	var outcomes []string
+	for _, decision := range query("/admin/decisions/correlated") {
+		outcomes = append(outcomes, decision.Outcome)
+	}
+	expected := []string{correlationPending, correlationAdmitted, correlationUnadmitted, correlationDenied, correlationAdmitted, correlationRejected}
+	if strings.Join(outcomes, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected outcomes %v newest first, got %v", expected, outcomes)
+	}
+
+	decisions := query("/admin/decisions/correlated?user=alice&outcome=rejected")
+	if len(decisions) != 1 || decisions[0].Authorization == nil || decisions[0].Admission.Name != "settings" {
+		t.Errorf("Expected alice's create to be joined with its rejection, got %+v", decisions)
+	}
+	if decisions := query("/admin/decisions/correlated?since=150s&limit=1"); len(decisions) != 1 || decisions[0].Authorization.User != "carol" {
+		t.Errorf("Expected only the newest decision, got %+v", decisions)
+	}
+	if decisions := query("/admin/decisions/correlated?user=bob&outcome=admitted"); len(decisions) != 1 || decisions[0].Authorization != nil {
+		t.Errorf("Expected bob's late admission to be recorded alone, got %+v", decisions)
+	}
+
+	correlator.Write([]AuditEvent{authorization(time.Second, "dave", "create", "", false)})
+	if decisions := query("/admin/decisions/correlated?limit=100"); len(decisions) != 6 || decisions[5].Outcome != correlationAdmitted {
+		t.Errorf("Expected the oldest decision to be dropped beyond the history size, got %+v", decisions)
+	}
+}
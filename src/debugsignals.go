@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Debug logging switched on for a running webhook with signals, so it can be enabled with
+// `kubectl exec ... kill` without changing the Deployment. SIGUSR1 toggles logging of every decision,
+// and SIGUSR2 dumps requests for a limited time, or stops dumping if they're already being dumped
+type DebugToggles struct {
+	// How long requests are dumped for after SIGUSR2
+	DumpDuration time.Duration
+	verbose      atomic.Bool
+	// Unix time in nanoseconds until which requests are dumped
+	dumpUntil atomic.Int64
+	now       func() time.Time
+}
+
+func NewDebugToggles(dumpDuration time.Duration) *DebugToggles {
+	return &DebugToggles{DumpDuration: dumpDuration, now: time.Now}
+}
+
+// Returns the log level in effect given the configured one. Nil toggles leave it unchanged
+func (t *DebugToggles) LogLevel(configured int) int {
+	if t == nil {
+		return configured
+	}
+	if t.now().UnixNano() < t.dumpUntil.Load() {
+		return max(configured, 2)
+	}
+	if t.verbose.Load() {
+		return max(configured, 1)
+	}
+	return configured
+}
+
+// Applies signals until the context is done
+func (t *DebugToggles) Run(ctx context.Context) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1, syscall.SIGUSR2)
+	defer signal.Stop(signals)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case received := <-signals:
+			t.handle(received)
+		}
+	}
+}
+
+func (t *DebugToggles) handle(received os.Signal) {
+	switch received {
+	case syscall.SIGUSR1:
+		verbose := !t.verbose.Load()
+		t.verbose.Store(verbose)
+		if verbose {
+			log.Println("Received SIGUSR1, logging every decision")
+		} else {
+			log.Println("Received SIGUSR1, logging at the configured log level")
+		}
+	case syscall.SIGUSR2:
+		now := t.now()
+		if now.UnixNano() < t.dumpUntil.Load() {
+			t.dumpUntil.Store(0)
+			log.Println("Received SIGUSR2, stopped dumping requests")
+			return
+		}
+		t.dumpUntil.Store(now.Add(t.DumpDuration).UnixNano())
+		log.Printf("Received SIGUSR2, dumping requests for %s\n", t.DumpDuration)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestDebugTogglesLogLevel(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	toggles := NewDebugToggles(time.Minute)
+	toggles.now = func() time.Time { return now }
+	var nilToggles *DebugToggles
+	if nilToggles.LogLevel(0) != 0 || toggles.LogLevel(0) != 0 {
+		t.Error("Expected configured log level without signals")
+	}
+
+	toggles.handle(syscall.SIGUSR1)
+	if toggles.LogLevel(0) != 1 || toggles.LogLevel(2) != 2 {
+		t.Error("Expected SIGUSR1 to raise the log level to 1")
+	}
+	toggles.handle(syscall.SIGUSR1)
+	if toggles.LogLevel(0) != 0 {
+		t.Error("Expected second SIGUSR1 to restore the configured log level")
+	}
+
+	toggles.handle(syscall.SIGUSR2)
+	if toggles.LogLevel(0) != 2 {
+		t.Error("Expected SIGUSR2 to dump requests")
+	}
+	now = now.Add(time.Minute)
+	if toggles.LogLevel(0) != 0 {
+		t.Error("Expected dumping to stop after the dump duration")
+	}
+	toggles.handle(syscall.SIGUSR2)
+	toggles.handle(syscall.SIGUSR2)
+	if toggles.LogLevel(0) != 0 {
+		t.Error("Expected SIGUSR2 while dumping to stop dumping")
+	}
+}
+
+func TestDebugTogglesAuthorizerLogging(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+	toggles := NewDebugToggles(time.Minute)
+	authorizer := CreateWebhookAuthorizer(WebhookConfig{Config: DefaultPolicyConfig, LogLevel: 0, Debug: toggles})
+	authorize := func() string {
+		logs.Reset()
+		body := `{"kind":"SubjectAccessReview","apiVersion":"authorization.k8s.io/v1","spec":{"resourceAttributes":{"namespace":"kube-system","verb":"get","resource":"pods"},"user":"alice"}}`
+		authorizer(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/authorize", strings.NewReader(body)))
+		return logs.String()
+	}
+
+	if output := authorize(); output != "" {
+		t.Errorf("Expected nothing logged at log level 0, got %q", output)
+	}
+	toggles.handle(syscall.SIGUSR1)
+	if output := authorize(); !strings.Contains(output, "request from alice") || strings.Contains(output, "HTTP Dump") {
+		t.Errorf("Expected decision to be logged, got %q", output)
+	}
+	toggles.handle(syscall.SIGUSR2)
+	if output := authorize(); !strings.Contains(output, "HTTP Dump") {
+		t.Errorf("Expected request to be dumped, got %q", output)
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/internal/lru"
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/policy"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+var decisionCacheLookups = Metrics.NewCounterVec("azimuth_authz_decision_cache_lookups_total",
+	"Decision cache lookups", "result")
+
+// Bounded cache of decisions keyed on the SubjectAccessReview spec, with per-entry expiry
+type DecisionCache struct {
+	entries *lru.Cache[string, cachedDecision]
+	ttl     time.Duration
+	// For decisions hinted policy.CacheLong, ttl if zero
+	longTTL time.Duration
+	// Identifies the policy decisions were made with, so persisted decisions aren't reused after a policy change
+	mu         sync.Mutex
+	policyHash string
+}
+
+type cachedDecision struct {
+	Status  authorizationv1.SubjectAccessReviewStatus `json:"status"`
+	Cache   policy.CacheHint                          `json:"cache,omitempty"`
+	Expires time.Time                                 `json:"expires"`
+}
+
+// On-disk format of a persisted decision cache
+type decisionCacheFile struct {
+	PolicyHash string                 `json:"policyHash"`
+	Entries    []persistedCacheRecord `json:"entries"`
+}
+
+type persistedCacheRecord struct {
+	Key string `json:"key"`
+	cachedDecision
+}
+
+func NewDecisionCache(size int, ttl time.Duration, policyHash string) *DecisionCache {
+	return &DecisionCache{entries: lru.New[string, cachedDecision](size), ttl: ttl, policyHash: policyHash}
+}
+
+// Sets the time for which decisions hinted policy.CacheLong are reused, the cache's TTL by default
+func (c *DecisionCache) SetLongTTL(ttl time.Duration) {
+	c.longTTL = ttl
+}
+
+// Returns the time for which decisions with hint are reused, zero if they aren't cached
+func (c *DecisionCache) TTL(hint policy.CacheHint) time.Duration {
+	switch hint {
+	case policy.CacheNone:
+		return 0
+	case policy.CacheLong:
+		return max(c.longTTL, c.ttl)
+	}
+	return c.ttl
+}
+
+// Returns hash identifying everything other than the request which influences decisions. The policy is
+// read from the policy in effect, so copies of config taken before policy sync or admin changes hash the
+// same inputs as the original
+func HashDecisionInputs(config WebhookConfig) string {
+	inputs, _ := json.Marshal(struct {
+		Policy      policy.Config
+		OpinionMode bool
+	}{config.policySource().Current().Config(), config.OpinionMode})
+	sum := sha256.Sum256(inputs)
+	return hex.EncodeToString(sum[:])
+}
+
+// Returns short hash identifying a policy, equal for replicas and clusters with the same policy in effect
+func HashPolicy(config policy.Config) string {
+	encoded, _ := json.Marshal(config)
+	sum := sha256.Sum256(encoded)
+	return hex.EncodeToString(sum[:8])
+}
+
+func decisionCacheKey(spec policy.SubjectAccessReviewSpec) string {
+	key, _ := json.Marshal(spec)
+	return string(key)
+}
+
+// Returns cached status for spec, and the cache hint it was made with, if present and unexpired. Safe to
+// call on a nil cache
+func (c *DecisionCache) Get(spec policy.SubjectAccessReviewSpec) (authorizationv1.SubjectAccessReviewStatus, policy.CacheHint, bool) {
+	if c == nil {
+		return authorizationv1.SubjectAccessReviewStatus{}, policy.CacheDefault, false
+	}
+	decision, ok := c.entries.Get(decisionCacheKey(spec))
+	if !ok || time.Now().After(decision.Expires) {
+		decisionCacheLookups.Inc("miss")
+		return authorizationv1.SubjectAccessReviewStatus{}, policy.CacheDefault, false
+	}
+	decisionCacheLookups.Inc("hit")
+	return decision.Status, decision.Cache, true
+}
+
+// Caches status for the time its cache hint allows, not at all if hinted policy.CacheNone. Safe to call
+// on a nil cache
+func (c *DecisionCache) Add(spec policy.SubjectAccessReviewSpec, status authorizationv1.SubjectAccessReviewStatus, hint policy.CacheHint) {
+	if c == nil || hint == policy.CacheNone {
+		return
+	}
+	c.entries.Add(decisionCacheKey(spec), cachedDecision{Status: status, Cache: hint, Expires: time.Now().Add(c.TTL(hint))})
+}
+
+// Discards all decisions when the policy changes to the one identified by policyHash. Safe to call on a nil cache
+func (c *DecisionCache) Reset(policyHash string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.policyHash = policyHash
+	c.entries.Clear()
+}
+
+// Writes unexpired entries to path, replacing the file atomically
+func (c *DecisionCache) Save(path string) error {
+	c.mu.Lock()
+	cacheFile := decisionCacheFile{PolicyHash: c.policyHash}
+	c.mu.Unlock()
+	now := time.Now()
+	for _, entry := range c.entries.Entries() {
+		if now.Before(entry.Value.Expires) {
+			cacheFile.Entries = append(cacheFile.Entries, persistedCacheRecord{Key: entry.Key, cachedDecision: entry.Value})
+		}
+	}
+	data, err := json.Marshal(cacheFile)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".decision-cache-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Loads unexpired entries persisted by Save. Entries are discarded if they were made with a different
+// policy, and a missing file is not an error. Returns number of entries loaded
+func (c *DecisionCache) Load(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	var cacheFile decisionCacheFile
+	if err := json.Unmarshal(data, &cacheFile); err != nil {
+		return 0, fmt.Errorf("corrupt decision cache file: %w", err)
+	}
+	c.mu.Lock()
+	policyHash := c.policyHash
+	c.mu.Unlock()
+	if cacheFile.PolicyHash != policyHash {
+		return 0, nil
+	}
+
+	loaded := 0
+	now := time.Now()
+	for _, record := range cacheFile.Entries {
+		if !now.Before(record.Expires) || record.Cache == policy.CacheNone {
+			continue
+		}
+		// Don't trust expiry times further out than the current TTL allows
+		if maxExpiry := now.Add(c.TTL(record.Cache)); record.Expires.After(maxExpiry) {
+			record.Expires = maxExpiry
+		}
+		c.entries.Add(record.Key, record.cachedDecision)
+		loaded++
+	}
+	return loaded, nil
+}
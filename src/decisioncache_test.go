@@ -0,0 +1,80 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/policy"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var cacheTestSpec = policy.SubjectAccessReviewSpec{
+	User:               "not-admin",
+	ResourceAttributes: &authorizationv1.ResourceAttributes{Namespace: "kube-system", Verb: "get", Resource: "secrets"},
+}
+
+func TestDecisionCacheExpiry(t *testing.T) {
+	cache := NewDecisionCache(8, 20*time.Millisecond, "policy")
+	cache.Add(cacheTestSpec, authorizationv1.SubjectAccessReviewStatus{Denied: true}, policy.CacheDefault)
+	if status, _, ok := cache.Get(cacheTestSpec); !ok || !status.Denied {
+		t.Fatal("Expected cached decision")
+	}
+	time.Sleep(30 * time.Millisecond)
+	if _, _, ok := cache.Get(cacheTestSpec); ok {
+		t.Error("Expected cached decision to expire")
+	}
+}
+
+func TestHashDecisionInputsFollowsPolicyInEffect(t *testing.T) {
+	config := WebhookConfig{Config: DefaultPolicyConfig, Policy: policy.NewSource(DefaultPolicyConfig)}
+	// Copies taken at startup, such as policy sync's, must hash the same inputs as the original
+	startup := config
+	before := HashDecisionInputs(config)
+	config.Policy.SetNamespaceOverrides(policy.NamespaceOverrides{Protected: []string{"billing"}})
+	if HashDecisionInputs(config) == before {
+		t.Error("Expected namespace overrides to change the hash")
+	}
+	updated := DefaultPolicyConfig
+	updated.ProtectedNamespaces = []string{"az-demo"}
+	config.Policy.Set(updated)
+	if HashDecisionInputs(startup) != HashDecisionInputs(config) {
+		t.Error("Expected copies of the config to hash the policy in effect")
+	}
+}
+
+func TestDecisionCachePersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "decisions.json")
+	cache := NewDecisionCache(8, time.Minute, "policy")
+	cache.Add(cacheTestSpec, authorizationv1.SubjectAccessReviewStatus{Denied: true, Reason: "cached"}, policy.CacheLong)
+	if err := cache.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := NewDecisionCache(8, time.Minute, "policy")
+	if loaded, err := restored.Load(path); err != nil || loaded != 1 {
+		t.Fatalf("Expected 1 decision to be loaded, got %d (%v)", loaded, err)
+	}
+	if status, hint, ok := restored.Get(cacheTestSpec); !ok || status.Reason != "cached" || hint != policy.CacheLong {
+		t.Error("Expected persisted decision to be served after reload")
+	}
+
+	changedPolicy := NewDecisionCache(8, time.Minute, "other-policy")
+	if loaded, _ := changedPolicy.Load(path); loaded != 0 {
+		t.Error("Expected decisions made with a different policy to be discarded")
+	}
+}
+
+func TestDecisionCacheLoadSkipsExpiredAndMissing(t *testing.T) {
+	dir := t.TempDir()
+	cache := NewDecisionCache(8, time.Minute, "policy")
+	if loaded, err := cache.Load(filepath.Join(dir, "missing.json")); err != nil || loaded != 0 {
+		t.Errorf("Expected missing cache file to be ignored, got %d (%v)", loaded, err)
+	}
+
+	path := filepath.Join(dir, "expired.json")
+	os.WriteFile(path, []byte(`{"policyHash":"policy","entries":[{"key":"k","status":{"allowed":false},"expires":"2000-01-01T00:00:00Z"}]}`), 0o600)
+	if loaded, err := cache.Load(path); err != nil || loaded != 0 {
+		t.Errorf("Expected expired entries to be skipped, got %d (%v)", loaded, err)
+	}
+}
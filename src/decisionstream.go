@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+)
+
+// Events buffered for each subscriber, beyond which events are dropped for that subscriber
+const decisionStreamBuffer = 256
+
+var decisionStreamDropped = Metrics.NewCounterVec("azimuth_authz_decision_stream_events_dropped_total",
+	"Audit events not sent to a decision stream subscriber which fell behind")
+
+// Audit sink fanning events out to the clients of GET /admin/decisions, which receive them as newline
+// delimited JSON as they are exported. Slow clients miss events rather than holding up the pipeline
+type DecisionStream struct {
+	mu          sync.Mutex
+	subscribers map[chan AuditEvent]struct{}
+	// Closed to end every stream when the server shuts down
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func NewDecisionStream() *DecisionStream {
+	s := &DecisionStream{subscribers: map[chan AuditEvent]struct{}{}, closed: make(chan struct{})}
+	Metrics.NewGaugeFunc("azimuth_authz_decision_stream_subscribers", "Clients connected to the decision stream",
+		func() float64 {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			return float64(len(s.subscribers))
+		})
+	return s
+}
+
+func (s *DecisionStream) Name() string {
+	return "stream"
+}
+
+func (s *DecisionStream) Write(events []AuditEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for subscriber := range s.subscribers {
+		for _, event := range events {
+			select {
+			case subscriber <- event:
+			default:
+				decisionStreamDropped.Inc()
+			}
+		}
+	}
+	return nil
+}
+
+// Ends every stream, as http.Server.Shutdown waits for handlers to return
+func (s *DecisionStream) Close() {
+	s.closeOnce.Do(func() { close(s.closed) })
+}
+
+func (s *DecisionStream) subscribe() chan AuditEvent {
+	subscriber := make(chan AuditEvent, decisionStreamBuffer)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subscribers[subscriber] = struct{}{}
+	return subscriber
+}
+
+func (s *DecisionStream) unsubscribe(subscriber chan AuditEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.subscribers, subscriber)
+}
+
+// Streams events until the client disconnects or the stream is closed. With ?denied=true only denials are sent
+func (s *DecisionStream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	deniedOnly := r.URL.Query().Get("denied") == "true"
+	subscriber := s.subscribe()
+	defer s.unsubscribe(subscriber)
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	controller := http.NewResponseController(w)
+	controller.Flush()
+	encoder := json.NewEncoder(w)
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.closed:
+			return
+		case event := <-subscriber:
+			if deniedOnly && !event.Denied {
+				continue
+			}
+			if err := encoder.Encode(event); err != nil {
+				return
+			}
+			controller.Flush()
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDecisionStream(t *testing.T) {
+	stream := NewDecisionStream()
+	server := httptest.NewServer(stream)
+	// Registered first so it runs after the clients disconnect
+	t.Cleanup(server.Close)
+
+	connect := func(query string) *bufio.Scanner {
+		resp, err := http.Get(server.URL + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		if contentType := resp.Header.Get("Content-Type"); contentType != "application/x-ndjson" {
+			t.Errorf("Expected NDJSON content type, got %s", contentType)
+		}
+		return bufio.NewScanner(resp.Body)
+	}
+	all, denied := connect(""), connect("?denied=true")
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		stream.mu.Lock()
+		subscribers := len(stream.subscribers)
+		stream.mu.Unlock()
+		if subscribers == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for subscribers")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	stream.Write([]AuditEvent{{User: "alice", Allowed: true}, {User: "bob", Denied: true}})
+	next := func(scanner *bufio.Scanner) AuditEvent {
+		var event AuditEvent
+		if !scanner.Scan() {
+			t.Fatal("Stream ended early")
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatal(err)
+		}
+		return event
+	}
+	if first, second := next(all), next(all); first.User != "alice" || second.User != "bob" {
+		t.Errorf("Expected every event in order, got %s then %s", first.User, second.User)
+	}
+	if event := next(denied); event.User != "bob" {
+		t.Errorf("Expected only denials, got %+v", event)
+	}
+
+	// Closing ends the streams so the server can shut down
+	stream.Close()
+	if all.Scan() || denied.Scan() {
+		t.Error("Expected streams to end when closed")
+	}
+}
+
+func TestDecisionStreamDropsForSlowSubscribers(t *testing.T) {
+	stream := NewDecisionStream()
+	subscriber := stream.subscribe()
+	defer stream.unsubscribe(subscriber)
+
+	before := decisionStreamDropped.Value()
+	stream.Write(make([]AuditEvent, decisionStreamBuffer+3))
+	if len(subscriber) != decisionStreamBuffer || decisionStreamDropped.Value() != before+3 {
+		t.Errorf("Expected events beyond the buffer to be dropped, buffered %d", len(subscriber))
+	}
+}
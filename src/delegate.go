@@ -0,0 +1,107 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/policy"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"net/http"
+	"time"
+)
+
+// Outcome when the upstream authorizer can't be reached or returns an invalid response
+type DelegateFailurePolicy string
+
+const (
+	// Keep this webhook's own decision
+	DelegateFailNoOpinion DelegateFailurePolicy = "no-opinion"
+	// Deny the request
+	DelegateFailDeny DelegateFailurePolicy = "deny"
+)
+
+var delegatedDecisions = Metrics.NewCounterVec("azimuth_authz_delegated_decisions_total",
+	"SubjectAccessReviews forwarded to the upstream authorizer, by upstream outcome", "outcome")
+
+// Forwards SubjectAccessReviews which the local policy doesn't deny to an upstream authorization
+// webhook, enabling chained authorization topologies without apiserver reconfiguration
+type UpstreamDelegate struct {
+	URL           string
+	BearerToken   string
+	Timeout       time.Duration
+	FailurePolicy DelegateFailurePolicy
+	Client        *OutboundClient
+	// Optional, failing while the upstream authorizer can't be reached
+	health *health
+}
+
+// Returns the upstream authorizer's decision. Requests are only forwarded if authorizers before the
+// delegate in the chain have no opinion, so local denials never are
+func (d *UpstreamDelegate) Authorize(ctx context.Context, spec *policy.SubjectAccessReviewSpec) policy.Decision {
+	upstream, err := d.authorize(ctx, policy.SubjectAccessReview{Spec: *spec})
+	if err != nil {
+		d.health.failed(err)
+		delegatedDecisions.Inc("error")
+		if d.FailurePolicy == DelegateFailDeny {
+			return policy.Decision{Verdict: policy.Deny, Reason: "Upstream authorizer unavailable", EvaluationError: err.Error()}
+		}
+		return policy.Decision{EvaluationError: "upstream authorizer: " + err.Error()}
+	}
+	d.health.succeeded()
+	switch {
+	case upstream.Denied:
+		delegatedDecisions.Inc("denied")
+		return policy.Decision{Verdict: policy.Deny, Reason: upstream.Reason, EvaluationError: upstream.EvaluationError}
+	case upstream.Allowed:
+		delegatedDecisions.Inc("allowed")
+		return policy.Decision{Verdict: policy.Allow, Reason: upstream.Reason, EvaluationError: upstream.EvaluationError}
+	}
+	// Left to other authorizers, and this webhook's opinion mode
+	delegatedDecisions.Inc("no-opinion")
+	return policy.Decision{}
+}
+
+func (d *UpstreamDelegate) authorize(ctx context.Context, sar policy.SubjectAccessReview) (authorizationv1.SubjectAccessReviewStatus, error) {
+	body, err := json.Marshal(toUpstreamSAR(sar))
+	if err != nil {
+		return authorizationv1.SubjectAccessReviewStatus{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.URL, bytes.NewReader(body))
+	if err != nil {
+		return authorizationv1.SubjectAccessReviewStatus{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if d.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+d.BearerToken)
+	}
+
+	resp, err := d.Client.Do("delegate", d.Timeout, req)
+	if err != nil {
+		return authorizationv1.SubjectAccessReviewStatus{}, err
+	}
+	defer resp.Body.Close()
+	// Kubernetes API servers answer SubjectAccessReview creation with 201, webhooks with 200
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return authorizationv1.SubjectAccessReviewStatus{}, fmt.Errorf("unexpected status from upstream authorizer: %s", resp.Status)
+	}
+	var review authorizationv1.SubjectAccessReview
+	if err := json.NewDecoder(resp.Body).Decode(&review); err != nil {
+		return authorizationv1.SubjectAccessReviewStatus{}, fmt.Errorf("decoding upstream response: %w", err)
+	}
+	return review.Status, nil
+}
+
+// Converts to the upstream API type for sending to other webhooks
+func toUpstreamSAR(sar policy.SubjectAccessReview) authorizationv1.SubjectAccessReview {
+	typeMeta := sar.TypeMeta
+	if typeMeta.Kind == "" {
+		typeMeta.APIVersion = "authorization.k8s.io/v1"
+		typeMeta.Kind = "SubjectAccessReview"
+	}
+	return authorizationv1.SubjectAccessReview{
+		TypeMeta:   typeMeta,
+		ObjectMeta: sar.ObjectMeta,
+		Spec:       authorizationv1.SubjectAccessReviewSpec(sar.Spec),
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/policy"
+	"encoding/json"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newTestUpstream(t *testing.T, status authorizationv1.SubjectAccessReviewStatus, received *authorizationv1.SubjectAccessReview) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if received != nil {
+			json.NewDecoder(r.Body).Decode(received)
+		}
+		json.NewEncoder(w).Encode(authorizationv1.SubjectAccessReview{Status: status})
+	}))
+}
+
+func delegatedAuthorizer(url string, failurePolicy DelegateFailurePolicy) func(w http.ResponseWriter, r *http.Request) {
+	return CreateWebhookAuthorizer(WebhookConfig{
+		Config:   DefaultPolicyConfig,
+		Delegate: &UpstreamDelegate{URL: url, FailurePolicy: failurePolicy, Client: NewOutboundClient(DefaultOutboundClientOptions)},
+	})
+}
+
+const unprotectedWriteSAR = `{
+	"kind":"SubjectAccessReview",
+	"apiVersion":"authorization.k8s.io/v1",
+	"spec":{
+		"resourceAttributes":{"namespace":"tenant","verb":"delete","resource":"pods"},
+		"user":"tenant-user",
+		"group":["tenant-group"]
+	}
+	}`
+
+func TestDelegateUpstreamDenyTakesPrecedence(t *testing.T) {
+	var received authorizationv1.SubjectAccessReview
+	upstream := newTestUpstream(t, authorizationv1.SubjectAccessReviewStatus{Denied: true, Reason: "upstream says no"}, &received)
+	defer upstream.Close()
+
+	accessTest(t, delegatedAuthorizer(upstream.URL, DelegateFailNoOpinion), true, []byte(unprotectedWriteSAR))
+	if received.Spec.User != "tenant-user" || len(received.Spec.Groups) != 1 || received.Spec.Groups[0] != "tenant-group" {
+		t.Errorf("Expected upstream to receive user and merged groups, got %+v", received.Spec)
+	}
+}
+
+func TestDelegateNotConsultedForLocalDenials(t *testing.T) {
+	var received authorizationv1.SubjectAccessReview
+	upstream := newTestUpstream(t, authorizationv1.SubjectAccessReviewStatus{Allowed: true}, &received)
+	defer upstream.Close()
+
+	accessTest(t, delegatedAuthorizer(upstream.URL, DelegateFailNoOpinion), true,
+		[]byte(`{
+		"kind":"SubjectAccessReview",
+		"apiVersion":"authorization.k8s.io/v1",
+		"spec":{
+			"resourceAttributes":{"namespace":"kube-system","verb":"get","resource":"secrets"},
+			"user":"tenant-user"
+		}
+		}`))
+	if received.Spec.User != "" {
+		t.Error("Expected locally denied request not to be forwarded")
+	}
+}
+
+func TestDelegateAuthorize(t *testing.T) {
+	spec := &policy.SubjectAccessReviewSpec{User: "tenant-user"}
+
+	allow := newTestUpstream(t, authorizationv1.SubjectAccessReviewStatus{Allowed: true}, nil)
+	defer allow.Close()
+	delegate := &UpstreamDelegate{URL: allow.URL, Client: NewOutboundClient(DefaultOutboundClientOptions)}
+	if decision := delegate.Authorize(t.Context(), spec); decision.Verdict != policy.Allow {
+		t.Error("Expected upstream allow to be returned")
+	}
+
+	noOpinion := newTestUpstream(t, authorizationv1.SubjectAccessReviewStatus{Reason: "upstream no opinion"}, nil)
+	defer noOpinion.Close()
+	delegate.URL = noOpinion.URL
+	if decision := delegate.Authorize(t.Context(), spec); decision != (policy.Decision{}) {
+		t.Errorf("Expected no opinion when upstream has no opinion, got %+v", decision)
+	}
+
+	delegate.URL = "http://127.0.0.1:1"
+	if decision := delegate.Authorize(t.Context(), spec); decision.Verdict != policy.NoOpinion || decision.EvaluationError == "" {
+		t.Error("Expected no opinion with evaluation error when upstream fails open")
+	}
+	delegate.FailurePolicy = DelegateFailDeny
+	if decision := delegate.Authorize(t.Context(), spec); decision.Verdict != policy.Deny {
+		t.Error("Expected denial when upstream fails closed")
+	}
+}
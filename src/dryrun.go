@@ -0,0 +1,138 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/internal/exitcode"
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/config"
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/policy"
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Time allowed for each source to be reached in a dry run
+const dryRunProbeTimeout = 10 * time.Second
+
+// Source the webhook lists or fetches from on startup, reached once in a dry run
+type dryRunProbe struct {
+	name  string
+	probe func(ctx context.Context) error
+}
+
+// Prints the effective settings and policy, whether each source can be reached and the result of the
+// self-test, which runs after the probes so it covers any synced policy. Unreachable sources are reported
+// without failing, as the webhook retries them once running. Returns 0 if the self-test passes
+func dryRun(out io.Writer, settings []config.Setting, policyConfig policy.Config, opinionMode bool, probes []dryRunProbe, selfTest *SelfTest) int {
+	fmt.Fprintln(out, "Settings:")
+	for _, setting := range settings {
+		fmt.Fprintf(out, "  --%s=%q (%s)\n", setting.Name, setting.Value, setting.Source)
+	}
+
+	fmt.Fprintln(out, "Policy:")
+	fmt.Fprintf(out, "  Protected namespaces:        %s\n", dryRunList(policyConfig.ProtectedNamespaces))
+	fmt.Fprintf(out, "  Exempt namespaces:           %s\n", dryRunList(policyConfig.ExemptNamespaces))
+	fmt.Fprintf(out, "  Additional privileged users: %s\n", dryRunList(policyConfig.AdditionalPrivilegedUsers))
+	fmt.Fprintf(out, "  Cluster scoped resources:    %s\n", dryRunList(policyConfig.ClusterScopedResources))
+	fmt.Fprintf(out, "  Additional readonly verbs:   %s\n", dryRunList(policyConfig.AdditionalReadonlyVerbs))
+	fmt.Fprintf(out, "  Additional write verbs:      %s\n", dryRunList(policyConfig.AdditionalWriteVerbs))
+	fmt.Fprintf(out, "  Wildcard requests:           %s\n", policyConfig.WildcardRequests)
+	fmt.Fprintf(out, "  Bulk secret reads:           %s\n", policyConfig.BulkSecretReads)
+	fmt.Fprintf(out, "  Service account allowlist:   %s\n", dryRunServiceAccountAllowlist(policyConfig))
+	fmt.Fprintf(out, "  Deny impersonated writes:    %t\n", policyConfig.DenyImpersonatedProtectedWrites)
+	fmt.Fprintf(out, "  Namespace creation:          %s\n", dryRunNamespaceCreation(policyConfig))
+	fmt.Fprintf(out, "  Namespace deletion:          %s\n", dryRunNamespaceDeletion(policyConfig))
+	fmt.Fprintf(out, "  Node restriction:            %t\n", policyConfig.NodeRestriction)
+	fmt.Fprintf(out, "  Name rules:                  %s\n", dryRunNameRules(policyConfig.NameRules))
+	fmt.Fprintf(out, "  Webhook objects:             %s\n", dryRunWebhookObjects(policyConfig))
+	fmt.Fprintf(out, "  Cache hints:                 %s\n", dryRunCacheHints(policyConfig))
+	fmt.Fprintf(out, "  Opinion mode:                %t\n", opinionMode)
+
+	if len(probes) > 0 {
+		fmt.Fprintln(out, "Sources:")
+	}
+	for _, probe := range probes {
+		ctx, cancel := context.WithTimeout(context.Background(), dryRunProbeTimeout)
+		err := probe.probe(ctx)
+		cancel()
+		if err != nil {
+			fmt.Fprintf(out, "  %s: unreachable: %s\n", probe.name, err)
+		} else {
+			fmt.Fprintf(out, "  %s: reachable\n", probe.name)
+		}
+	}
+
+	if err := selfTest.health.check(0); err != nil {
+		fmt.Fprintf(out, "Self-test: failed: %s\n", err)
+		return exitcode.Policy
+	}
+	fmt.Fprintln(out, "Self-test: passed")
+	return 0
+}
+
+// Returns the non-empty values, comma separated, or 'none'
+func dryRunList(values []string) string {
+	values = slices.DeleteFunc(slices.Clone(values), func(value string) bool { return value == "" })
+	if len(values) == 0 {
+		return "none"
+	}
+	return strings.Join(values, ", ")
+}
+
+// Returns the objects the webhook depends on which policyConfig protects, for printing
+func dryRunWebhookObjects(policyConfig policy.Config) string {
+	if policyConfig.DisableWebhookProtection {
+		return "disabled"
+	}
+	return dryRunList(append(slices.Clone(policy.DefaultWebhookObjects), policyConfig.WebhookObjects...))
+}
+
+// Returns the names and effects of rules, for printing
+func dryRunNameRules(rules []policy.NameRule) string {
+	var described []string
+	for _, rule := range rules {
+		described = append(described, rule.Name+" ("+string(rule.Effect)+")")
+	}
+	return dryRunList(described)
+}
+
+// Returns the rules with cache hints and their hints, name rules by name, for printing
+func dryRunCacheHints(policyConfig policy.Config) string {
+	var described []string
+	for _, rule := range sortedKeys(policyConfig.CacheHints) {
+		described = append(described, rule+"="+string(policyConfig.CacheHints[rule]))
+	}
+	for _, rule := range policyConfig.NameRules {
+		if rule.Cache != policy.CacheDefault {
+			described = append(described, "name rule "+rule.Name+"="+string(rule.Cache))
+		}
+	}
+	return dryRunList(described)
+}
+
+// Returns the namespaces reserved for privileged users to create, if protected, for printing
+func dryRunNamespaceCreation(policyConfig policy.Config) string {
+	if !policyConfig.NamespaceCreationProtection {
+		return "off"
+	}
+	reserved := append([]string{"protected namespaces"}, policyConfig.ReservedNamespaces...)
+	return "on for " + dryRunList(reserved)
+}
+
+// Returns the namespaces whose deletion is protected and who may delete them, if protected, for printing
+func dryRunNamespaceDeletion(policyConfig policy.Config) string {
+	if !policyConfig.NamespaceDeletionProtection {
+		return "off"
+	}
+	protected := append([]string{"protected namespaces"}, policyConfig.DeletionProtectedNamespaces...)
+	return "on for " + dryRunList(protected) + "; deleters: " + dryRunList(policyConfig.NamespaceDeleters)
+}
+
+// Returns the allowlist mode, and the listed service accounts unless it's off, for printing
+func dryRunServiceAccountAllowlist(policyConfig policy.Config) string {
+	if policyConfig.ServiceAccountAllowlist == "" || policyConfig.ServiceAccountAllowlist == policy.ServiceAccountAllowlistOff {
+		return string(policy.ServiceAccountAllowlistOff)
+	}
+	return string(policyConfig.ServiceAccountAllowlist) + " " + dryRunList(policyConfig.PrivilegedServiceAccounts)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/internal/exitcode"
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/config"
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/policy"
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestDryRun(t *testing.T) {
+	policyConfig := policy.Config{ProtectedNamespaces: []string{"kube-system", "openstack-*"}, AdditionalPrivilegedUsers: []string{""}}
+	selfTest := NewSelfTest()
+	selfTest.Run(policyConfig, policy.Compile(policyConfig))
+	settings := []config.Setting{{Name: "log-level", Value: "2", Source: config.SourceEnv}}
+	probes := []dryRunProbe{
+		{name: "capi", probe: func(context.Context) error { return nil }},
+		{name: "fleet", probe: func(context.Context) error { return errors.New("connection refused") }},
+	}
+
+	var out bytes.Buffer
+	if code := dryRun(&out, settings, policyConfig, false, probes, selfTest); code != 0 {
+		t.Errorf("Expected exit code 0, got %d:\n%s", code, out.String())
+	}
+	for _, expected := range []string{
+		"  --log-level=\"2\" (env)\n",
+		"  Protected namespaces:        kube-system, openstack-*\n",
+		"  Additional privileged users: none\n",
+		"  capi: reachable\n",
+		"  fleet: unreachable: connection refused\n",
+		"Self-test: passed\n",
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("Expected output to contain %q:\n%s", expected, out.String())
+		}
+	}
+
+	policyConfig.AdditionalPrivilegedUsers = []string{"system:anonymous"}
+	selfTest.Run(policyConfig, policy.Compile(policyConfig))
+	out.Reset()
+	if code := dryRun(&out, nil, policyConfig, false, nil, selfTest); code != exitcode.Policy || !strings.Contains(out.String(), "Self-test: failed") {
+		t.Errorf("Expected failed self-test, got exit code %d:\n%s", code, out.String())
+	}
+}
+
+func TestServeDryRunExits(t *testing.T) {
+	if code := serve([]string{"--dry-run", "--protected-namespaces", "kube-system"}, nil); code != 0 {
+		t.Errorf("Expected dry run to exit with 0, got %d", code)
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/internal/lru"
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/policy"
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/server"
+	"context"
+	"errors"
+	"fmt"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"log"
+	"maps"
+	"net/http"
+	"os"
+	"regexp"
+	"sigs.k8s.io/yaml"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Prefix of the SubjectAccessReview extras enrichment plugins set, followed by the plugin's name. Extras
+// the request already has under it are removed, so callers can't supply their own attributes
+const enrichmentExtraPrefix = "enrichment.azimuth-cloud.io/"
+
+// Timeout of plugins which don't set one, kept short as every request waits on enrichment
+const defaultEnrichmentTimeout = 500 * time.Millisecond
+
+var enrichmentResults = Metrics.NewCounterVec("azimuth_authz_enrichment_results_total",
+	"Enrichment plugin lookups, by plugin and result", "plugin", "result")
+
+// Enrichment plugin, as configured in the enrichment file
+type EnrichmentConfig struct {
+	// Attributes are set as the extra enrichment.azimuth-cloud.io/NAME, or NAME.KEY for those with keys
+	Name string `json:"name"`
+	// Values: [namespacePattern, capiLabels, keystoneRoles]
+	Type string `json:"type"`
+	// For namespacePattern, regular expression whose first group is the attribute, e.g. ^tenant-([a-z0-9]+)-
+	Pattern   string `json:"pattern,omitempty"`
+	Timeout   string `json:"timeout,omitempty"`
+	CacheTTL  string `json:"cacheTTL,omitempty"`
+	CacheSize int    `json:"cacheSize,omitempty"`
+}
+
+// Backend deriving attributes of a request, such as its tenant, from what the request already says
+type Enricher interface {
+	// Returns the part of the request the attributes are derived from, which their cache is keyed on,
+	// and false if the request has nothing to derive them from
+	Key(ctx context.Context, spec *policy.SubjectAccessReviewSpec) (string, bool)
+	// Returns the attributes by key, the empty key for the plugin's own attribute
+	Enrich(ctx context.Context, spec *policy.SubjectAccessReviewSpec) (map[string][]string, error)
+}
+
+type enrichmentPlugin struct {
+	name     string
+	enricher Enricher
+	timeout  time.Duration
+	cacheTTL time.Duration
+	cache    *lru.Cache[string, cachedEnrichment]
+}
+
+type cachedEnrichment struct {
+	attributes map[string][]string
+	expires    time.Time
+}
+
+// Plugins attaching derived attributes to requests before they're evaluated, as extras, so match
+// conditions, hooks and delegates can use them without the rules knowing where they come from. A plugin
+// which fails or times out adds nothing, so anything relying on its attributes must treat their absence
+// as it would an unknown tenant or role
+type Enrichment []*enrichmentPlugin
+
+// Returns spec with the attributes of every plugin set as extras. Safe to call on nil enrichment
+func (e Enrichment) Enrich(ctx context.Context, spec policy.SubjectAccessReviewSpec) policy.SubjectAccessReviewSpec {
+	if len(e) == 0 {
+		return spec
+	}
+	extra := maps.Clone(spec.Extra)
+	maps.DeleteFunc(extra, func(key string, _ authorizationv1.ExtraValue) bool {
+		return strings.HasPrefix(key, enrichmentExtraPrefix)
+	})
+	for _, plugin := range e {
+		for key, values := range plugin.attributes(ctx, &spec) {
+			name := enrichmentExtraPrefix + plugin.name
+			if key != "" {
+				name += "." + key
+			}
+			if extra == nil {
+				extra = map[string]authorizationv1.ExtraValue{}
+			}
+			extra[name] = values
+		}
+	}
+	spec.Extra = extra
+	return spec
+}
+
+func (p *enrichmentPlugin) attributes(ctx context.Context, spec *policy.SubjectAccessReviewSpec) map[string][]string {
+	key, ok := p.enricher.Key(ctx, spec)
+	if !ok {
+		return nil
+	}
+	if cached, ok := p.cache.Get(key); ok && time.Now().Before(cached.expires) {
+		enrichmentResults.Inc(p.name, "cached")
+		return cached.attributes
+	}
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+	attributes, err := p.enricher.Enrich(ctx, spec)
+	if err != nil {
+		enrichmentResults.Inc(p.name, "error")
+		log.Printf("Error enriching request from %s: %s\n", p.name, err)
+		return nil
+	}
+	enrichmentResults.Inc(p.name, "fetched")
+	p.cache.Add(key, cachedEnrichment{attributes: attributes, expires: time.Now().Add(p.cacheTTL)})
+	return attributes
+}
+
+// Sets the attribute to the first group of pattern matching the request's namespace
+type namespacePatternEnricher struct {
+	pattern *regexp.Regexp
+}
+
+func (n namespacePatternEnricher) Key(_ context.Context, spec *policy.SubjectAccessReviewSpec) (string, bool) {
+	if spec.ResourceAttributes == nil || spec.ResourceAttributes.Namespace == "" {
+		return "", false
+	}
+	return spec.ResourceAttributes.Namespace, true
+}
+
+func (n namespacePatternEnricher) Enrich(_ context.Context, spec *policy.SubjectAccessReviewSpec) (map[string][]string, error) {
+	if match := n.pattern.FindStringSubmatch(spec.ResourceAttributes.Namespace); match != nil {
+		return map[string][]string{"": {match[1]}}, nil
+	}
+	return nil, nil
+}
+
+// Sets an attribute for each of the --capi-labels of the identified calling cluster, keyed by its short
+// name, and the plugin's own attribute to the cluster's namespace/name
+type capiLabelsEnricher struct{}
+
+func (capiLabelsEnricher) Key(ctx context.Context, _ *policy.SubjectAccessReviewSpec) (string, bool) {
+	identity := clusterIdentityFrom(ctx)
+	return identity.String(), identity != nil
+}
+
+func (capiLabelsEnricher) Enrich(ctx context.Context, _ *policy.SubjectAccessReviewSpec) (map[string][]string, error) {
+	identity := clusterIdentityFrom(ctx)
+	attributes := map[string][]string{"": {identity.String()}}
+	for name, value := range identity.Labels {
+		attributes[name] = []string{value}
+	}
+	return attributes, nil
+}
+
+// Sets the attribute to the Keystone roles of the user identified by the SAR UID, as for
+// --keystone-privileged-roles
+type keystoneRolesEnricher struct {
+	resolver *KeystoneRoleResolver
+}
+
+func (k keystoneRolesEnricher) Key(_ context.Context, spec *policy.SubjectAccessReviewSpec) (string, bool) {
+	return spec.UID, spec.UID != ""
+}
+
+func (k keystoneRolesEnricher) Enrich(ctx context.Context, spec *policy.SubjectAccessReviewSpec) (map[string][]string, error) {
+	roles, err := k.resolver.rolesFor(ctx, spec.UID)
+	if err != nil {
+		return nil, err
+	}
+	return map[string][]string{"": slices.Sorted(maps.Keys(roles))}, nil
+}
+
+// Creates enrichment from its configuration, reporting every invalid plugin. keystone may be nil if no
+// plugin looks up Keystone roles
+func CreateEnrichment(configs []EnrichmentConfig, keystone *KeystoneRoleResolver) (Enrichment, error) {
+	enrichment := Enrichment{}
+	names := stringSet{}
+	var errs []error
+	for _, config := range configs {
+		if config.Name == "" || names.Has(config.Name) || strings.ContainsAny(config.Name, "./") {
+			errs = append(errs, fmt.Errorf("enrichment plugin names must be unique, not empty and without '.' or '/', got %q", config.Name))
+			continue
+		}
+		names[config.Name] = struct{}{}
+		plugin, err := createEnrichmentPlugin(config, keystone)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", config.Name, err))
+			continue
+		}
+		enrichment = append(enrichment, plugin)
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, fmt.Errorf("invalid enrichment plugins: %w", err)
+	}
+	return enrichment, nil
+}
+
+func createEnrichmentPlugin(config EnrichmentConfig, keystone *KeystoneRoleResolver) (*enrichmentPlugin, error) {
+	plugin := &enrichmentPlugin{name: config.Name, timeout: defaultEnrichmentTimeout, cacheTTL: time.Minute}
+	switch config.Type {
+	case "namespacePattern":
+		pattern, err := regexp.Compile(config.Pattern)
+		if err != nil || pattern.NumSubexp() < 1 {
+			return nil, fmt.Errorf("pattern %q must be a regular expression with a group", config.Pattern)
+		}
+		plugin.enricher = namespacePatternEnricher{pattern: pattern}
+	case "capiLabels":
+		plugin.enricher = capiLabelsEnricher{}
+	case "keystoneRoles":
+		if keystone == nil {
+			return nil, errors.New("keystoneRoles requires --keystone-url and --keystone-application-credential-id")
+		}
+		plugin.enricher = keystoneRolesEnricher{resolver: keystone}
+	default:
+		return nil, fmt.Errorf("unknown type %q, expected namespacePattern, capiLabels or keystoneRoles", config.Type)
+	}
+	for _, duration := range []struct {
+		value  string
+		target *time.Duration
+	}{{config.Timeout, &plugin.timeout}, {config.CacheTTL, &plugin.cacheTTL}} {
+		if duration.value == "" {
+			continue
+		}
+		parsed, err := time.ParseDuration(duration.value)
+		if err != nil || parsed <= 0 {
+			return nil, fmt.Errorf("invalid duration %q", duration.value)
+		}
+		*duration.target = parsed
+	}
+	if config.CacheSize <= 0 {
+		config.CacheSize = 1024
+	}
+	plugin.cache = lru.New[string, cachedEnrichment](config.CacheSize)
+	return plugin, nil
+}
+
+// Reads and creates a YAML or JSON list of enrichment plugins
+func LoadEnrichment(path string, keystone *KeystoneRoleResolver) (Enrichment, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var configs []EnrichmentConfig
+	if err := yaml.UnmarshalStrict(data, &configs); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return CreateEnrichment(configs, keystone)
+}
+
+// Returns middleware recording the calling cluster's identity in the request context, so plugins
+// evaluated without the HTTP request can use it
+func (e Enrichment) Middleware(clusters *ClusterRegistry) server.Middleware {
+	return func(next http.Handler) http.Handler {
+		if len(e) == 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if identity := clusters.Identify(r); identity != nil {
+				r = r.WithContext(withClusterIdentity(r.Context(), identity))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/internal/lru"
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/policy"
+	"context"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestEnrichment(t *testing.T) {
+	enrichment, err := CreateEnrichment([]EnrichmentConfig{
+		{Name: "tenant", Type: "namespacePattern", Pattern: "^tenant-([a-z0-9]+)-"},
+		{Name: "cluster", Type: "capiLabels"},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec := policy.SubjectAccessReviewSpec{
+		User:               "alice",
+		ResourceAttributes: &authorizationv1.ResourceAttributes{Namespace: "tenant-acme-web", Verb: "get", Resource: "pods"},
+		// Callers can't supply their own attributes
+		Extra: map[string]authorizationv1.ExtraValue{"enrichment.azimuth-cloud.io/tenant": {"other"}, "scopes": {"read"}},
+	}
+	ctx := withClusterIdentity(context.Background(), &ClusterIdentity{Namespace: "az-acme", Name: "demo", Labels: map[string]string{"flavor": "gpu"}})
+
+	before := enrichmentResults.Value("tenant", "cached")
+	for range 2 {
+		enriched := enrichment.Enrich(ctx, spec)
+		for key, expected := range map[string][]string{
+			"enrichment.azimuth-cloud.io/tenant":         {"acme"},
+			"enrichment.azimuth-cloud.io/cluster":        {"az-acme/demo"},
+			"enrichment.azimuth-cloud.io/cluster.flavor": {"gpu"},
+			"scopes": {"read"},
+		} {
+			if !slices.Equal(enriched.Extra[key], expected) {
+				t.Errorf("Expected extra %s to be %v, got %v", key, expected, enriched.Extra[key])
+			}
+		}
+	}
+	if enrichmentResults.Value("tenant", "cached") != before+1 {
+		t.Error("Expected the second lookup to be cached")
+	}
+	if spec.Extra["enrichment.azimuth-cloud.io/tenant"][0] != "other" {
+		t.Error("Expected the request's own spec to be left unchanged")
+	}
+
+	// Nothing to derive attributes from
+	spec.ResourceAttributes.Namespace = "kube-system"
+	enriched := enrichment.Enrich(context.Background(), spec)
+	if len(enriched.Extra) != 1 {
+		t.Errorf("Expected only the request's own extras, got %v", enriched.Extra)
+	}
+}
+
+type slowEnricher struct{}
+
+func (slowEnricher) Key(context.Context, *policy.SubjectAccessReviewSpec) (string, bool) {
+	return "key", true
+}
+
+func (slowEnricher) Enrich(ctx context.Context, _ *policy.SubjectAccessReviewSpec) (map[string][]string, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestEnrichmentTimeout(t *testing.T) {
+	enrichment := Enrichment{{name: "slow", enricher: slowEnricher{}, timeout: 10 * time.Millisecond, cacheTTL: time.Minute, cache: lru.New[string, cachedEnrichment](1)}}
+	before := enrichmentResults.Value("slow", "error")
+	if enriched := enrichment.Enrich(context.Background(), policy.SubjectAccessReviewSpec{User: "alice"}); len(enriched.Extra) != 0 {
+		t.Errorf("Expected a plugin timing out to add nothing, got %v", enriched.Extra)
+	}
+	if enrichmentResults.Value("slow", "error") != before+1 {
+		t.Error("Expected the timeout to be counted")
+	}
+}
+
+func TestCreateEnrichmentRejectsInvalidPlugins(t *testing.T) {
+	for name, config := range map[string]EnrichmentConfig{
+		"name":     {Name: "a.b", Type: "capiLabels"},
+		"type":     {Name: "tenant", Type: "ldapGroups"},
+		"pattern":  {Name: "tenant", Type: "namespacePattern", Pattern: "^tenant-"},
+		"keystone": {Name: "roles", Type: "keystoneRoles"},
+		"timeout":  {Name: "cluster", Type: "capiLabels", Timeout: "soon"},
+	} {
+		if _, err := CreateEnrichment([]EnrichmentConfig{config}, nil); err == nil {
+			t.Errorf("Expected plugin with invalid %s to be rejected", name)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/policy"
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/server"
+	"encoding/json"
+	"errors"
+	"fmt"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"log"
+	"net/http"
+)
+
+// Response body of the /v1/explain endpoint
+type ExplainResponse struct {
+	// Decision the webhook makes, including authorizers besides the policy's rules such as privilege
+	// resolvers, tenancy, hooks and delegation
+	Status authorizationv1.SubjectAccessReviewStatus `json:"status"`
+	// How the policy's rules decided the request
+	Trace policy.Trace `json:"trace"`
+}
+
+// Returns HTTP request handler tracing how a SubjectAccessReview is decided, for answering "why was
+// this denied?". Like batch evaluation, requests aren't audited as they don't correspond to real
+// API requests
+func CreateExplainHandler(config WebhookConfig) func(w http.ResponseWriter, r *http.Request) {
+	policies := config.policySource()
+	config.Policy = policies
+	evaluate := newEvaluator(config)
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+		if r.Method != http.MethodPost {
+			server.WriteError(w, http.StatusMethodNotAllowed, errors.New("Method not allowed"))
+			return
+		}
+
+		var sar policy.SubjectAccessReview
+		if err := server.DecodeJSON(r.Body, &sar, server.CompatibleSubjectAccessReviewFields); err != nil {
+			jsonErr := fmt.Errorf("JSON decoding error: %w", err)
+			log.Println(jsonErr)
+			server.WriteError(w, http.StatusBadRequest, jsonErr)
+			return
+		}
+		err := policy.AcceptAPIVersion(&sar, config.APIVersions)
+		if err == nil {
+			err = policy.Normalize(&sar, r.Header)
+		}
+		if err == nil {
+			err = enforceLimits(config, &sar.Spec)
+		}
+		if err == nil {
+			err = policy.Validate(sar)
+		}
+		if err != nil {
+			log.Println(err)
+			server.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		// The trace and the decision use the same policy
+		compiled := policies.Current()
+		response := ExplainResponse{
+			Status: evaluate(policy.WithSnapshot(r.Context(), compiled), sar),
+			Trace:  policy.TraceDecision(sar, compiled, config.OpinionMode),
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
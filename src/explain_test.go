@@ -0,0 +1,41 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/policy"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestExplainHandler(t *testing.T) {
+	handler := CreateExplainHandler(WebhookConfig{Config: DefaultPolicyConfig})
+	body := `{"apiVersion":"authorization.k8s.io/v1","kind":"SubjectAccessReview",
+		"spec":{"user":"alice","resourceAttributes":{"namespace":"kube-system","verb":"delete","resource":"pods"}}}`
+	resp := httptest.NewRecorder()
+	handler(resp, httptest.NewRequest(http.MethodPost, "/v1/explain", strings.NewReader(body)))
+	var response ExplainResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil || resp.Code != http.StatusOK {
+		t.Fatalf("Expected explanation, got %d: %v", resp.Code, err)
+	}
+	if !response.Status.Denied || response.Trace.Rule != policy.RuleProtectedWrite || response.Trace.Classifications.ProtectedBy != "kube-system" {
+		t.Errorf("Unexpected explanation %+v", response)
+	}
+
+	for _, test := range []struct {
+		method string
+		body   string
+		code   int
+	}{
+		{http.MethodGet, "", http.StatusMethodNotAllowed},
+		{http.MethodPost, `{"apiVersion":"authorization.k8s.io/v1","kind":"SubjectAccessReview","spec":{"user":"alice"}}`, http.StatusBadRequest},
+		{http.MethodPost, `{"spec":`, http.StatusBadRequest},
+	} {
+		resp := httptest.NewRecorder()
+		handler(resp, httptest.NewRequest(test.method, "/v1/explain", strings.NewReader(test.body)))
+		if resp.Code != test.code {
+			t.Errorf("Expected %d for %s %q, got %d", test.code, test.method, test.body, resp.Code)
+		}
+	}
+}
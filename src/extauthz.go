@@ -0,0 +1,353 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/policy"
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/server"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// gRPC method implementing Envoy's external authorization protocol
+const ExtAuthzCheckPath = "/envoy.service.auth.v3.Authorization/Check"
+
+var extAuthzChecks = Metrics.NewCounterVec("azimuth_authz_ext_authz_checks_total",
+	"Envoy external authorization checks, by decision", "decision")
+
+// gRPC status codes used in responses
+const (
+	grpcOK               = 0
+	grpcInvalidArgument  = 3
+	grpcPermissionDenied = 7
+	grpcUnimplemented    = 12
+)
+
+// Minimal protobuf wire format support, enough to read a CheckRequest and write a CheckResponse.
+// Kept dependency free like the rest of the webhook
+
+const (
+	protoVarint          = 0
+	protoFixed64         = 1
+	protoLengthDelimited = 2
+	protoFixed32         = 5
+)
+
+type protoField struct {
+	number   int
+	wireType int
+	varint   uint64
+	bytes    []byte
+}
+
+// Splits message into its fields, in wire order
+func protoFields(message []byte) ([]protoField, error) {
+	var fields []protoField
+	for len(message) > 0 {
+		key, n := binary.Uvarint(message)
+		if n <= 0 {
+			return nil, fmt.Errorf("malformed protobuf field key")
+		}
+		message = message[n:]
+		field := protoField{number: int(key >> 3), wireType: int(key & 7)}
+		switch field.wireType {
+		case protoVarint:
+			field.varint, n = binary.Uvarint(message)
+			if n <= 0 {
+				return nil, fmt.Errorf("malformed protobuf varint")
+			}
+			message = message[n:]
+		case protoLengthDelimited:
+			length, n := binary.Uvarint(message)
+			if n <= 0 || uint64(len(message)-n) < length {
+				return nil, fmt.Errorf("malformed protobuf length")
+			}
+			field.bytes = message[n : n+int(length)]
+			message = message[n+int(length):]
+		case protoFixed64, protoFixed32:
+			size := 8
+			if field.wireType == protoFixed32 {
+				size = 4
+			}
+			if len(message) < size {
+				return nil, fmt.Errorf("truncated protobuf fixed field")
+			}
+			message = message[size:]
+		default:
+			return nil, fmt.Errorf("unsupported protobuf wire type %d", field.wireType)
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// Returns the last length-delimited value of field number, as protobuf merges repeated singular fields
+func protoBytes(fields []protoField, number int) []byte {
+	var value []byte
+	for _, field := range fields {
+		if field.number == number && field.wireType == protoLengthDelimited {
+			value = field.bytes
+		}
+	}
+	return value
+}
+
+// Returns entries of a map<string, string> field
+func protoStringMap(fields []protoField, number int) (map[string]string, error) {
+	values := map[string]string{}
+	for _, field := range fields {
+		if field.number != number || field.wireType != protoLengthDelimited {
+			continue
+		}
+		entry, err := protoFields(field.bytes)
+		if err != nil {
+			return nil, err
+		}
+		values[string(protoBytes(entry, 1))] = string(protoBytes(entry, 2))
+	}
+	return values, nil
+}
+
+func protoAppendVarint(message []byte, number int, value uint64) []byte {
+	message = binary.AppendUvarint(message, uint64(number)<<3|protoVarint)
+	return binary.AppendUvarint(message, value)
+}
+
+func protoAppendBytes(message []byte, number int, value []byte) []byte {
+	message = binary.AppendUvarint(message, uint64(number)<<3|protoLengthDelimited)
+	message = binary.AppendUvarint(message, uint64(len(value)))
+	return append(message, value...)
+}
+
+// Request fields the webhook uses from envoy.service.auth.v3.CheckRequest
+type extAuthzRequest struct {
+	sourceAddress     string
+	sourcePrincipal   string
+	method            string
+	path              string
+	headers           map[string]string
+	contextExtensions map[string]string
+}
+
+// Decodes the CheckRequest message fields used by the webhook
+func decodeExtAuthzRequest(message []byte) (*extAuthzRequest, error) {
+	check, err := protoFields(message)
+	if err != nil {
+		return nil, err
+	}
+	// CheckRequest.attributes
+	attributes, err := protoFields(protoBytes(check, 1))
+	if err != nil {
+		return nil, err
+	}
+	// AttributeContext.source
+	source, err := protoFields(protoBytes(attributes, 1))
+	if err != nil {
+		return nil, err
+	}
+	// AttributeContext.request.http
+	request, err := protoFields(protoBytes(attributes, 4))
+	if err != nil {
+		return nil, err
+	}
+	httpRequest, err := protoFields(protoBytes(request, 2))
+	if err != nil {
+		return nil, err
+	}
+
+	decoded := &extAuthzRequest{
+		method:          string(protoBytes(httpRequest, 2)),
+		path:            string(protoBytes(httpRequest, 4)),
+		sourcePrincipal: string(protoBytes(source, 4)),
+	}
+	// Peer.address is a config.core.v3.Address, whose socket_address has the IP
+	if address, err := protoFields(protoBytes(source, 1)); err == nil {
+		if socketAddress, err := protoFields(protoBytes(address, 1)); err == nil {
+			decoded.sourceAddress = string(protoBytes(socketAddress, 2))
+		}
+	}
+	if decoded.headers, err = protoStringMap(httpRequest, 3); err != nil {
+		return nil, err
+	}
+	if decoded.contextExtensions, err = protoStringMap(attributes, 10); err != nil {
+		return nil, err
+	}
+	return decoded, nil
+}
+
+// Encodes a CheckResponse, with a 403 denied response carrying reason if code isn't OK
+func encodeExtAuthzResponse(code int, reason string) []byte {
+	status := protoAppendVarint(nil, 1, uint64(code))
+	status = protoAppendBytes(status, 2, []byte(reason))
+	response := protoAppendBytes(nil, 1, status)
+	if code == grpcOK {
+		return protoAppendBytes(response, 3, nil)
+	}
+	httpStatus := protoAppendVarint(nil, 1, http.StatusForbidden)
+	denied := protoAppendBytes(nil, 1, httpStatus)
+	denied = protoAppendBytes(denied, 3, []byte(reason+"\n"))
+	return protoAppendBytes(response, 2, denied)
+}
+
+// Settings for the Envoy external authorization endpoint
+type ExtAuthzOptions struct {
+	// Request headers carrying the authenticated user and comma separated groups, which Envoy must
+	// strip from client requests
+	UserHeader   string
+	GroupsHeader string
+}
+
+// Returns HTTP handler implementing Envoy's ext_authz gRPC Check method with the same decision
+// making as /authorize. HTTP requests are evaluated as requests proxied to a cluster service: the
+// route's context extensions give the namespace and name of the service (and optionally resource,
+// subresource and group, defaulting to services/proxy), and the method gives the verb as for the
+// Kubernetes API. Routes without a namespace are evaluated as non-resource requests for the path.
+// Users without the user header are evaluated as system:anonymous. Denied requests get a 403,
+// anything else is allowed, as Envoy has no equivalent of "no opinion"
+func CreateExtAuthzHandler(config WebhookConfig, options ExtAuthzOptions) func(w http.ResponseWriter, r *http.Request) {
+	evaluate := newEvaluator(config)
+	if options.UserHeader == "" {
+		options.UserHeader = "x-remote-user"
+	}
+	if options.GroupsHeader == "" {
+		options.GroupsHeader = "x-remote-group"
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+		if r.Method != http.MethodPost || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+			server.WriteError(w, http.StatusUnsupportedMediaType, errors.New("gRPC requests only"))
+			return
+		}
+		w.Header().Set("Content-Type", "application/grpc")
+
+		message, err := readGRPCMessage(r.Body)
+		if err != nil {
+			code := grpcInvalidArgument
+			if errors.Is(err, errGRPCCompressed) {
+				code = grpcUnimplemented
+			}
+			writeGRPCStatus(w, code, err.Error())
+			return
+		}
+		request, err := decodeExtAuthzRequest(message)
+		if err != nil {
+			writeGRPCStatus(w, grpcInvalidArgument, err.Error())
+			return
+		}
+
+		sar := extAuthzSAR(request, options)
+		status := evaluate(r.Context(), sar)
+		code, decision := grpcOK, "allowed"
+		if status.Denied {
+			code, decision = grpcPermissionDenied, "denied"
+		}
+		extAuthzChecks.Inc(decision)
+
+		if config.logLevel() >= 1 {
+			log.Println(decisionLogRecord{cluster: request.sourceAddress, spec: &sar.Spec, status: &status})
+		}
+		if config.Audit != nil {
+			config.Audit.Publish(newAuditEvent(sar, request.sourceAddress, status))
+		}
+
+		writeGRPCMessage(w, encodeExtAuthzResponse(code, status.Reason))
+		writeGRPCStatus(w, grpcOK, "")
+	}
+}
+
+var errGRPCCompressed = errors.New("compressed gRPC messages are not supported")
+
+// Reads a single length-prefixed gRPC message
+func readGRPCMessage(body io.Reader) ([]byte, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(body, prefix[:]); err != nil {
+		return nil, fmt.Errorf("reading gRPC message: %w", err)
+	}
+	if prefix[0] != 0 {
+		return nil, errGRPCCompressed
+	}
+	length := binary.BigEndian.Uint32(prefix[1:])
+	if length > 4<<20 {
+		return nil, fmt.Errorf("gRPC message of %d bytes too large", length)
+	}
+	message := make([]byte, length)
+	if _, err := io.ReadFull(body, message); err != nil {
+		return nil, fmt.Errorf("reading gRPC message: %w", err)
+	}
+	return message, nil
+}
+
+// Writes a single length-prefixed, uncompressed gRPC message
+func writeGRPCMessage(w http.ResponseWriter, message []byte) {
+	frame := make([]byte, 5, 5+len(message))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(message)))
+	w.Write(append(frame, message...))
+}
+
+// Sets the gRPC status trailers, which are sent once the handler returns
+func writeGRPCStatus(w http.ResponseWriter, code int, message string) {
+	w.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(code))
+	if message != "" {
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message", message)
+	}
+}
+
+// Returns SubjectAccessReview equivalent to the HTTP request being checked
+func extAuthzSAR(request *extAuthzRequest, options ExtAuthzOptions) policy.SubjectAccessReview {
+	sar := policy.SubjectAccessReview{}
+	sar.APIVersion = "authorization.k8s.io/v1"
+	sar.Kind = "SubjectAccessReview"
+	sar.Spec.User = request.headers[strings.ToLower(options.UserHeader)]
+	if sar.Spec.User == "" {
+		sar.Spec.User = request.sourcePrincipal
+	}
+	for _, group := range strings.Split(request.headers[strings.ToLower(options.GroupsHeader)], ",") {
+		if group = strings.TrimSpace(group); group != "" {
+			sar.Spec.Groups = append(sar.Spec.Groups, group)
+		}
+	}
+	if sar.Spec.User == "" && len(sar.Spec.Groups) == 0 {
+		sar.Spec.User = "system:anonymous"
+		sar.Spec.Groups = []string{"system:unauthenticated"}
+	}
+
+	verb := extAuthzVerbs[request.method]
+	if verb == "" {
+		verb = strings.ToLower(request.method)
+	}
+	path, _, _ := strings.Cut(request.path, "?")
+	extensions := request.contextExtensions
+	if extensions["namespace"] == "" {
+		sar.Spec.NonResourceAttributes = &authorizationv1.NonResourceAttributes{Path: path, Verb: verb}
+		return sar
+	}
+	resource, subresource := extensions["resource"], extensions["subresource"]
+	if resource == "" {
+		resource, subresource = "services", "proxy"
+	}
+	sar.Spec.ResourceAttributes = &authorizationv1.ResourceAttributes{
+		Namespace:   extensions["namespace"],
+		Verb:        verb,
+		Group:       extensions["group"],
+		Resource:    resource,
+		Subresource: subresource,
+		Name:        extensions["name"],
+	}
+	return sar
+}
+
+// Kubernetes API verbs for HTTP methods, as kube-apiserver assigns them to proxy requests
+var extAuthzVerbs = map[string]string{
+	http.MethodGet:     "get",
+	http.MethodHead:    "get",
+	http.MethodOptions: "get",
+	http.MethodPost:    "create",
+	http.MethodPut:     "update",
+	http.MethodPatch:   "patch",
+	http.MethodDelete:  "delete",
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Encodes a CheckRequest for an HTTP request from user to a service in namespace
+func extAuthzCheckRequest(method string, user string, namespace string) []byte {
+	entry := func(key string, value string) []byte {
+		return protoAppendBytes(protoAppendBytes(nil, 1, []byte(key)), 2, []byte(value))
+	}
+	httpRequest := protoAppendBytes(nil, 2, []byte(method))
+	httpRequest = protoAppendBytes(httpRequest, 3, entry("x-remote-user", user))
+	httpRequest = protoAppendBytes(httpRequest, 3, entry("x-remote-group", "staff, tenants"))
+	httpRequest = protoAppendBytes(httpRequest, 4, []byte("/dashboard?tab=1"))
+	socketAddress := protoAppendBytes(nil, 2, []byte("10.0.0.7"))
+	source := protoAppendBytes(nil, 1, protoAppendBytes(nil, 1, socketAddress))
+
+	attributes := protoAppendBytes(nil, 1, source)
+	attributes = protoAppendBytes(attributes, 4, protoAppendBytes(nil, 2, httpRequest))
+	attributes = protoAppendBytes(attributes, 10, entry("namespace", namespace))
+	attributes = protoAppendBytes(attributes, 10, entry("name", "dashboard"))
+	return protoAppendBytes(nil, 1, attributes)
+}
+
+func TestExtAuthzCheckOverHTTP2(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(CreateExtAuthzHandler(WebhookConfig{Config: DefaultPolicyConfig}, ExtAuthzOptions{})))
+	server.Config.Protocols = new(http.Protocols)
+	server.Config.Protocols.SetUnencryptedHTTP2(true)
+	server.Start()
+	defer server.Close()
+	client := &http.Client{Transport: &http.Transport{Protocols: server.Config.Protocols}}
+
+	tests := []struct {
+		method    string
+		namespace string
+		code      uint64
+	}{
+		{http.MethodGet, "kube-system", grpcOK},
+		{http.MethodPost, "kube-system", grpcPermissionDenied},
+		{http.MethodPost, "tenant", grpcOK},
+	}
+	for _, test := range tests {
+		message := extAuthzCheckRequest(test.method, "not-admin", test.namespace)
+		frame := binary.BigEndian.AppendUint32([]byte{0}, uint32(len(message)))
+		resp, err := client.Post(server.URL+ExtAuthzCheckPath, "application/grpc", bytes.NewReader(append(frame, message...)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.ProtoMajor != 2 || resp.Trailer.Get("Grpc-Status") != "0" {
+			t.Fatalf("Expected successful HTTP/2 gRPC call, got %s with status %q", resp.Proto, resp.Trailer.Get("Grpc-Status"))
+		}
+
+		response, err := protoFields(body[5:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		status, _ := protoFields(protoBytes(response, 1))
+		if len(status) == 0 || status[0].varint != test.code {
+			t.Errorf("Expected code %d for %s in %s, got %v", test.code, test.method, test.namespace, status)
+		}
+		if denied := protoBytes(response, 2); (test.code == grpcPermissionDenied) != (denied != nil) {
+			t.Errorf("Expected denied response only when denied, got %v", denied)
+		}
+	}
+}
+
+func TestExtAuthzSARMapping(t *testing.T) {
+	request, err := decodeExtAuthzRequest(extAuthzCheckRequest(http.MethodDelete, "alice", "az-demo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if request.sourceAddress != "10.0.0.7" {
+		t.Errorf("Expected source address, got %q", request.sourceAddress)
+	}
+	sar := extAuthzSAR(request, ExtAuthzOptions{UserHeader: "X-Remote-User", GroupsHeader: "X-Remote-Group"})
+	attributes := sar.Spec.ResourceAttributes
+	if sar.Spec.User != "alice" || len(sar.Spec.Groups) != 2 || sar.Spec.Groups[1] != "tenants" {
+		t.Errorf("Unexpected user info %+v", sar.Spec)
+	}
+	if attributes == nil || attributes.Verb != "delete" || attributes.Resource != "services" || attributes.Subresource != "proxy" ||
+		attributes.Namespace != "az-demo" || attributes.Name != "dashboard" {
+		t.Errorf("Unexpected resource attributes %+v", attributes)
+	}
+
+	request.contextExtensions = map[string]string{}
+	request.headers = map[string]string{}
+	sar = extAuthzSAR(request, ExtAuthzOptions{})
+	if sar.Spec.User != "system:anonymous" || sar.Spec.NonResourceAttributes == nil || sar.Spec.NonResourceAttributes.Path != "/dashboard" {
+		t.Errorf("Expected anonymous non-resource request, got %+v", sar.Spec)
+	}
+}
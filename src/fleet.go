@@ -0,0 +1,390 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/policy"
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/server"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Route serving SubjectAccessReviews for the workload cluster declared by a ClusterAuthorization
+const FleetAuthorizePattern = "POST /clusters/{namespace}/{name}/authorize"
+
+const fleetAPIPath = "/apis/authorization.azimuth-cloud.io/v1alpha1"
+
+var fleetRequestsRejected = Metrics.NewCounterVec("azimuth_authz_fleet_requests_rejected_total",
+	"Fleet requests rejected before evaluation", "reason")
+
+var fleetStatusWrites = Metrics.NewCounterVec("azimuth_authz_fleet_status_writes_total",
+	"ClusterAuthorization status updates written by the leader, by result", "result")
+
+// Delay before status updates which failed are retried
+const fleetStatusRetryInterval = 10 * time.Second
+
+// Subset of an authorization.azimuth-cloud.io ClusterAuthorization, declaring the credentials and
+// policy of one workload cluster served in fleet mode
+type clusterAuthorization struct {
+	Metadata struct {
+		Namespace   string            `json:"namespace"`
+		Name        string            `json:"name"`
+		UID         string            `json:"uid"`
+		Generation  int64             `json:"generation"`
+		Annotations map[string]string `json:"annotations"`
+	} `json:"metadata"`
+	Status clusterAuthorizationStatus `json:"status"`
+	Spec   struct {
+		// Override the webhook's flags if set
+		ProtectedNamespaces       []string `json:"protectedNamespaces"`
+		AdditionalPrivilegedUsers []string `json:"additionalPrivilegedUsers"`
+		AllowOpinionMode          *bool    `json:"allowOpinionMode"`
+		// Secret in the same namespace holding the bearer token the cluster's apiserver presents
+		TokenSecretRef struct {
+			Name string `json:"name"`
+			Key  string `json:"key"`
+		} `json:"tokenSecretRef"`
+	} `json:"spec"`
+}
+
+// Reports whether a ClusterAuthorization is served, and why not
+type clusterAuthorizationStatus struct {
+	ObservedGeneration int64  `json:"observedGeneration"`
+	Served             bool   `json:"served"`
+	Message            string `json:"message"`
+}
+
+type FleetOptions struct {
+	// Restricts ClusterAuthorizations to one namespace of the management cluster if set
+	Namespace string
+	// Timeout for reading token secrets
+	Timeout time.Duration
+	// Optional, statuses are written back to ClusterAuthorizations while it elects this replica. Every
+	// replica serves the fleet either way
+	Elector *LeaderElector
+	// Optional, tokens and webhook kubeconfigs of the clusters are written while Elector elects this
+	// replica if set
+	Kubeconfigs *FleetKubeconfigOptions
+}
+
+// Serves many workload clusters from one deployment. Each ClusterAuthorization on the management
+// cluster gets its own route, authenticated with the token from its secret and evaluated with its own
+// policy. Routes are added, changed and removed as the resources are
+type Fleet struct {
+	watcher *kubeWatcher
+	options FleetOptions
+	// Settings shared by every cluster, with the policy as the default
+	base WebhookConfig
+
+	mu        sync.RWMutex
+	clusters  map[string]*fleetCluster        // namespace/name -> cluster
+	statuses  map[string]*fleetStatus         // namespace/name -> status
+	resources map[string]clusterAuthorization // namespace/name -> resource
+	// Signalled when a status or kubeconfig may need writing
+	statusPending     chan struct{}
+	kubeconfigPending chan struct{}
+	now               func() time.Time
+}
+
+type fleetCluster struct {
+	handler http.Handler
+}
+
+type fleetStatus struct {
+	namespace string
+	name      string
+	// Status last seen on the resource and the status it should have
+	observed clusterAuthorizationStatus
+	desired  clusterAuthorizationStatus
+}
+
+func NewFleet(conn *ClusterConnection, client *OutboundClient, base WebhookConfig, options FleetOptions) *Fleet {
+	// Decisions depend on each cluster's policy, and the delegate and mirror are specific to one cluster
+	base.DecisionCache = nil
+	base.Delegate = nil
+	base.Mirror = nil
+	base.Clusters = nil
+	base.Policy = nil
+
+	f := &Fleet{
+		options:           options,
+		base:              base,
+		clusters:          map[string]*fleetCluster{},
+		statuses:          map[string]*fleetStatus{},
+		resources:         map[string]clusterAuthorization{},
+		statusPending:     make(chan struct{}, 1),
+		kubeconfigPending: make(chan struct{}, 1),
+		now:               time.Now,
+	}
+	path := fleetAPIPath + "/clusterauthorizations"
+	if options.Namespace != "" {
+		path = fleetAPIPath + "/namespaces/" + url.PathEscape(options.Namespace) + "/clusterauthorizations"
+	}
+	f.watcher = &kubeWatcher{
+		conn:    conn,
+		client:  client.WithTLSConfig(conn.TLSConfig),
+		backend: "fleet",
+		path:    path,
+		replace: f.replace,
+		update:  f.update,
+		health:  newHealth(true),
+	}
+	Metrics.NewGaugeFunc("azimuth_authz_fleet_clusters", "Workload clusters served in fleet mode",
+		func() float64 {
+			f.mu.RLock()
+			defer f.mu.RUnlock()
+			return float64(len(f.clusters))
+		})
+	return f
+}
+
+// Lists and then watches ClusterAuthorizations until ctx is cancelled, writing their statuses and
+// kubeconfigs while elected
+func (f *Fleet) Run(ctx context.Context) {
+	if f.options.Elector != nil {
+		go f.runStatusWriter(ctx)
+		if f.options.Kubeconfigs != nil {
+			go f.runKubeconfigWriter(ctx)
+		}
+	}
+	f.watcher.Run(ctx)
+}
+
+// Requests statuses which differ from the resources' be written, e.g. on becoming the leader
+func (f *Fleet) RequestStatusWrite() {
+	select {
+	case f.statusPending <- struct{}{}:
+	default:
+	}
+}
+
+func (f *Fleet) runStatusWriter(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-f.statusPending:
+		}
+		if !f.options.Elector.IsLeader() {
+			continue
+		}
+		if err := f.writeStatuses(ctx); err != nil && ctx.Err() == nil {
+			log.Println("Error writing ClusterAuthorization status:", err)
+			time.AfterFunc(fleetStatusRetryInterval, f.RequestStatusWrite)
+		}
+	}
+}
+
+// Patches the status of each resource whose status differs from the one it should have
+func (f *Fleet) writeStatuses(ctx context.Context) error {
+	f.mu.RLock()
+	var pending []fleetStatus
+	for _, status := range f.statuses {
+		if status.observed != status.desired {
+			pending = append(pending, *status)
+		}
+	}
+	f.mu.RUnlock()
+
+	var errs []error
+	for _, status := range pending {
+		body, err := json.Marshal(map[string]any{"status": status.desired})
+		if err != nil {
+			return err
+		}
+		path := fleetAPIPath + "/namespaces/" + url.PathEscape(status.namespace) + "/clusterauthorizations/" + url.PathEscape(status.name) + "/status"
+		resp, err := kubeSend(ctx, f.watcher.conn, f.watcher.client, "fleet", http.MethodPatch, path, "application/merge-patch+json", body, f.options.Timeout)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				err = fmt.Errorf("unexpected status from management cluster: %s", resp.Status)
+			}
+		}
+		if err != nil {
+			fleetStatusWrites.Inc("error")
+			errs = append(errs, fmt.Errorf("%s/%s: %w", status.namespace, status.name, err))
+			continue
+		}
+		fleetStatusWrites.Inc("written")
+		// Not written again before the watch delivers the change, unless the desired status changes
+		f.mu.Lock()
+		if current := f.statuses[status.namespace+"/"+status.name]; current != nil && current.desired == status.desired {
+			current.observed = status.desired
+		}
+		f.mu.Unlock()
+	}
+	return errors.Join(errs...)
+}
+
+func (f *Fleet) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	identity := &ClusterIdentity{Namespace: r.PathValue("namespace"), Name: r.PathValue("name")}
+	f.mu.RLock()
+	cluster := f.clusters[identity.String()]
+	f.mu.RUnlock()
+	if cluster == nil {
+		fleetRequestsRejected.Inc("unknown-cluster")
+		server.WriteError(w, http.StatusNotFound, errors.New("Unknown cluster"))
+		return
+	}
+	cluster.handler.ServeHTTP(w, r.WithContext(withClusterIdentity(r.Context(), identity)))
+}
+
+// Builds the route for resource, reading its token from the management cluster
+func (f *Fleet) clusterFor(resource *clusterAuthorization) (*fleetCluster, error) {
+	spec := resource.Spec
+	if spec.TokenSecretRef.Name == "" {
+		return nil, fmt.Errorf("spec.tokenSecretRef.name is required")
+	}
+	key := spec.TokenSecretRef.Key
+	if key == "" {
+		key = "token"
+	}
+	tokens, err := f.readTokens(resource.Metadata.Namespace, spec.TokenSecretRef.Name, key)
+	if err != nil {
+		return nil, err
+	}
+
+	config := f.base
+	if spec.ProtectedNamespaces != nil {
+		config.ProtectedNamespaces = spec.ProtectedNamespaces
+	}
+	if spec.AdditionalPrivilegedUsers != nil {
+		config.AdditionalPrivilegedUsers = spec.AdditionalPrivilegedUsers
+	}
+	if spec.AllowOpinionMode != nil {
+		config.OpinionMode = *spec.AllowOpinionMode
+	}
+	// Applied after the cluster's own settings, so they can't drop the fleet's guardrails
+	config.Config, _ = policy.ApplyOverlays(config.Config, config.Overlays, f.key(resource))
+	config.Overlays = nil
+	if err := config.Config.Validate(); err != nil {
+		return nil, err
+	}
+	authenticate := server.Authenticate(func(r *http.Request) bool {
+		presented, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		for _, token := range tokens {
+			if subtle.ConstantTimeCompare([]byte(presented), []byte(token)) == 1 {
+				return true
+			}
+		}
+		fleetRequestsRejected.Inc("unauthorized")
+		return false
+	})
+	return &fleetCluster{handler: server.Chain(http.HandlerFunc(CreateWebhookAuthorizer(config)), authenticate)}, nil
+}
+
+// Returns the token under key, followed by the token it replaced if the secret has one
+func (f *Fleet) readTokens(namespace string, name string, key string) ([]string, error) {
+	path := "/api/v1/namespaces/" + url.PathEscape(namespace) + "/secrets/" + url.PathEscape(name)
+	resp, err := kubeGet(context.Background(), f.watcher.conn, f.watcher.client, "fleet", path, f.options.Timeout)
+	if err != nil {
+		return nil, fmt.Errorf("reading secret %s/%s: %w", namespace, name, err)
+	}
+	defer resp.Body.Close()
+	var secret struct {
+		Data map[string][]byte `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&secret); err != nil {
+		return nil, fmt.Errorf("decoding secret %s/%s: %w", namespace, name, err)
+	}
+	token := strings.TrimSpace(string(secret.Data[key]))
+	if token == "" {
+		return nil, fmt.Errorf("secret %s/%s has no %q key", namespace, name, key)
+	}
+	tokens := []string{token}
+	if previous := strings.TrimSpace(string(secret.Data[previousTokenKey(key)])); previous != "" {
+		tokens = append(tokens, previous)
+	}
+	return tokens, nil
+}
+
+// Resources which can't be served are logged and skipped, so that one broken resource doesn't stop
+// the rest of the fleet being served
+func (f *Fleet) replace(items []json.RawMessage) error {
+	clusters := map[string]*fleetCluster{}
+	statuses := map[string]*fleetStatus{}
+	resources := map[string]clusterAuthorization{}
+	for _, item := range items {
+		var resource clusterAuthorization
+		if err := json.Unmarshal(item, &resource); err != nil {
+			return fmt.Errorf("decoding ClusterAuthorization list: %w", err)
+		}
+		cluster, status := f.build(&resource)
+		if cluster != nil {
+			clusters[f.key(&resource)] = cluster
+		}
+		statuses[f.key(&resource)] = status
+		resources[f.key(&resource)] = resource
+	}
+	f.mu.Lock()
+	f.clusters = clusters
+	f.statuses = statuses
+	f.resources = resources
+	f.mu.Unlock()
+	f.RequestStatusWrite()
+	f.RequestKubeconfigWrite()
+	return nil
+}
+
+func (f *Fleet) update(object json.RawMessage, deleted bool) error {
+	var resource clusterAuthorization
+	if err := json.Unmarshal(object, &resource); err != nil {
+		return fmt.Errorf("decoding watch event: %w", err)
+	}
+	var cluster *fleetCluster
+	var status *fleetStatus
+	if !deleted {
+		cluster, status = f.build(&resource)
+	}
+	f.mu.Lock()
+	previous, known := f.resources[f.key(&resource)]
+	if deleted {
+		delete(f.resources, f.key(&resource))
+	} else {
+		f.resources[f.key(&resource)] = resource
+	}
+	if cluster == nil {
+		delete(f.clusters, f.key(&resource))
+	} else {
+		f.clusters[f.key(&resource)] = cluster
+	}
+	if status == nil {
+		delete(f.statuses, f.key(&resource))
+	} else {
+		f.statuses[f.key(&resource)] = status
+	}
+	f.mu.Unlock()
+	if status != nil && status.observed != status.desired {
+		f.RequestStatusWrite()
+	}
+	// Status writes and token annotations don't change what the kubeconfig should be
+	if !deleted && (!known || previous.Metadata.Generation != resource.Metadata.Generation) {
+		f.RequestKubeconfigWrite()
+	}
+	return nil
+}
+
+// Returns the route for resource, or nil if it can't be served, and the status it should have
+func (f *Fleet) build(resource *clusterAuthorization) (*fleetCluster, *fleetStatus) {
+	status := &fleetStatus{namespace: resource.Metadata.Namespace, name: resource.Metadata.Name, observed: resource.Status}
+	status.desired.ObservedGeneration = resource.Metadata.Generation
+	cluster, err := f.clusterFor(resource)
+	if err != nil {
+		log.Printf("Not serving ClusterAuthorization %s: %s\n", f.key(resource), err)
+		status.desired.Message = err.Error()
+	} else {
+		status.desired.Served = true
+	}
+	return cluster, status
+}
+
+func (f *Fleet) key(resource *clusterAuthorization) string {
+	return resource.Metadata.Namespace + "/" + resource.Metadata.Name
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/policy"
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/server"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const clusterAuthorizationJSON = `{
+	"metadata":{"namespace":"az-tenant-a","name":"%s","resourceVersion":"%s"},
+	"spec":{"protectedNamespaces":["az-secret"],"allowOpinionMode":true,"tokenSecretRef":{"name":"%[1]s-webhook"}}
+}`
+
+// Status patches are recorded in statuses by resource name if it's not nil
+func newTestFleet(t *testing.T, options FleetOptions, statuses map[string]string) (*Fleet, *http.ServeMux) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if name, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, fleetAPIPath+"/namespaces/az-tenant-a/clusterauthorizations/"), "/status"); ok && r.Method == http.MethodPatch {
+			body, _ := io.ReadAll(r.Body)
+			statuses[name] = string(body)
+			return
+		}
+		switch r.URL.Path {
+		case fleetAPIPath + "/namespaces/az-tenant-a/clusterauthorizations":
+			if r.URL.Query().Get("watch") == "" {
+				fmt.Fprintf(w, `{"metadata":{"resourceVersion":"1"},"items":[%s,%s]}`,
+					fmt.Sprintf(clusterAuthorizationJSON, "demo", "1"), fmt.Sprintf(clusterAuthorizationJSON, "broken", "1"))
+				return
+			}
+			fmt.Fprintf(w, `{"type":"DELETED","object":`+clusterAuthorizationJSON+`}`, "demo", "2")
+		case "/api/v1/namespaces/az-tenant-a/secrets/demo-webhook":
+			// "demo-token\n" base64 encoded
+			fmt.Fprint(w, `{"data":{"token":"ZGVtby10b2tlbgo="}}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	conn, err := LoadKubeconfig(writeKubeconfig(t, server, "    token: x\n"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	options.Namespace = "az-tenant-a"
+	fleet := NewFleet(conn, NewOutboundClient(DefaultOutboundClientOptions), WebhookConfig{Config: DefaultPolicyConfig}, options)
+	mux := http.NewServeMux()
+	mux.Handle(FleetAuthorizePattern, fleet)
+	return fleet, mux
+}
+
+func fleetRequest(t *testing.T, mux *http.ServeMux, cluster string, token string, namespace string) (int, server.SubjectAccessReviewResponse) {
+	sar := policy.SubjectAccessReview{}
+	sar.APIVersion = "authorization.k8s.io/v1"
+	sar.Kind = "SubjectAccessReview"
+	sar.Spec.User = "not-admin"
+	sar.Spec.ResourceAttributes = &authorizationv1.ResourceAttributes{Namespace: namespace, Verb: "get", Resource: "secrets"}
+	body, _ := json.Marshal(sar)
+	req := httptest.NewRequest(http.MethodPost, "/clusters/az-tenant-a/"+cluster+"/authorize", bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp := httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+
+	var response server.SubjectAccessReviewResponse
+	if resp.Code == http.StatusOK {
+		json.NewDecoder(resp.Body).Decode(&response)
+	}
+	return resp.Code, response
+}
+
+func TestFleetServesClustersWithTheirPolicies(t *testing.T) {
+	fleet, mux := newTestFleet(t, FleetOptions{}, nil)
+	if _, err := fleet.watcher.list(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+
+	// The cluster's policy replaces the default protected namespaces, and enables opinion mode
+	if code, response := fleetRequest(t, mux, "demo", "demo-token", "az-secret"); code != http.StatusOK || !response.Status.Denied {
+		t.Errorf("Expected denial in namespace protected by the cluster's policy, got %d %+v", code, response.Status)
+	}
+	if code, response := fleetRequest(t, mux, "demo", "demo-token", "kube-system"); code != http.StatusOK || !response.Status.Allowed {
+		t.Errorf("Expected allowed opinion outside the cluster's protected namespaces, got %d %+v", code, response.Status)
+	}
+	if code, _ := fleetRequest(t, mux, "demo", "other-token", "az-secret"); code != http.StatusUnauthorized {
+		t.Errorf("Expected wrong token to be rejected, got %d", code)
+	}
+	// Its token secret doesn't exist, so the cluster isn't served
+	if code, _ := fleetRequest(t, mux, "broken", "", "az-secret"); code != http.StatusNotFound {
+		t.Errorf("Expected cluster without readable token to be unknown, got %d", code)
+	}
+
+	if _, err := fleet.watcher.watch(t.Context(), "1"); err != nil {
+		t.Fatal(err)
+	}
+	if code, _ := fleetRequest(t, mux, "demo", "demo-token", "az-secret"); code != http.StatusNotFound {
+		t.Errorf("Expected route to be removed with its ClusterAuthorization, got %d", code)
+	}
+}
+
+func TestFleetLeaderWritesStatuses(t *testing.T) {
+	statuses := map[string]string{}
+	fleet, _ := newTestFleet(t, FleetOptions{Elector: &LeaderElector{}}, statuses)
+	if _, err := fleet.watcher.list(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := fleet.writeStatuses(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(statuses["demo"], `"served":true`) {
+		t.Errorf("Expected demo to be reported as served, got %s", statuses["demo"])
+	}
+	if !strings.Contains(statuses["broken"], `"served":false`) || !strings.Contains(statuses["broken"], "broken-webhook") {
+		t.Errorf("Expected broken to be reported as not served with the reason, got %s", statuses["broken"])
+	}
+
+	// Statuses already written aren't written again
+	clear(statuses)
+	if err := fleet.writeStatuses(t.Context()); err != nil || len(statuses) != 0 {
+		t.Errorf("Expected no further writes, got %v, %v", statuses, err)
+	}
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/config"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	// Annotation on token secrets generated by the webhook recording when the token was generated, and on
+	// ClusterAuthorizations so that every replica rereads the token when it changes
+	TokenRotatedAnnotation = "authorization.azimuth-cloud.io/token-rotated"
+	// Label marking secrets generated by the webhook, whose tokens it rotates
+	managedByLabel = "app.kubernetes.io/managed-by"
+	managedBy      = "azimuth-authorization-webhook"
+	// Key of the kubeconfig in kubeconfig secrets, as for Cluster API's own kubeconfig secrets
+	kubeconfigSecretKey = "value"
+	// Interval at which every kubeconfig secret is checked, so rotations fall due and edits are reverted
+	fleetKubeconfigResyncInterval = 10 * time.Minute
+)
+
+var fleetKubeconfigWrites = Metrics.NewCounterVec("azimuth_authz_fleet_kubeconfig_writes_total",
+	"Token and kubeconfig secrets written by the leader, by secret and result", "secret", "result")
+
+// Settings for rendering the webhook kubeconfigs of workload clusters served in fleet mode
+type FleetKubeconfigOptions struct {
+	// URL the workload clusters' apiservers reach the webhook at, to which each cluster's route is appended
+	ServerURL string
+	// PEM encoded CA bundle the workload clusters verify the webhook with, system roots if empty
+	CAData []byte
+	// Age at which generated tokens are replaced, never if zero. The previous token is accepted until the
+	// token is next replaced, so clusters have a rotation period to pick up the new kubeconfig
+	RotationPeriod time.Duration
+}
+
+// Returns the name of the secret holding the webhook kubeconfig for the ClusterAuthorization name
+func kubeconfigSecretName(name string) string {
+	return name + "-authorization-webhook-kubeconfig"
+}
+
+// Returns the key of the previous token in a token secret whose current token has key
+func previousTokenKey(key string) string {
+	return "previous-" + key
+}
+
+// Subset of a v1 Secret
+type kubeSecret struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Metadata   struct {
+		Namespace       string               `json:"namespace"`
+		Name            string               `json:"name"`
+		ResourceVersion string               `json:"resourceVersion,omitempty"`
+		Labels          map[string]string    `json:"labels,omitempty"`
+		Annotations     map[string]string    `json:"annotations,omitempty"`
+		OwnerReferences []kubeOwnerReference `json:"ownerReferences,omitempty"`
+	} `json:"metadata"`
+	Type string            `json:"type,omitempty"`
+	Data map[string][]byte `json:"data"`
+}
+
+type kubeOwnerReference struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Name       string `json:"name"`
+	UID        string `json:"uid"`
+}
+
+// Requests every cluster's token and kubeconfig secrets be checked, e.g. on becoming the leader
+func (f *Fleet) RequestKubeconfigWrite() {
+	select {
+	case f.kubeconfigPending <- struct{}{}:
+	default:
+	}
+}
+
+func (f *Fleet) runKubeconfigWriter(ctx context.Context) {
+	ticker := time.NewTicker(fleetKubeconfigResyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-f.kubeconfigPending:
+		}
+		if !f.options.Elector.IsLeader() {
+			continue
+		}
+		f.mu.RLock()
+		resources := make([]clusterAuthorization, 0, len(f.resources))
+		for _, resource := range f.resources {
+			resources = append(resources, resource)
+		}
+		f.mu.RUnlock()
+		var errs []error
+		for _, resource := range resources {
+			if err := f.writeKubeconfig(ctx, resource); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", f.key(&resource), err))
+			}
+		}
+		if err := errors.Join(errs...); err != nil && ctx.Err() == nil {
+			log.Println("Error writing webhook kubeconfig secrets:", err)
+			time.AfterFunc(fleetStatusRetryInterval, f.RequestKubeconfigWrite)
+		}
+	}
+}
+
+// Generates or rotates the resource's token if the webhook manages it, and renders the kubeconfig
+// presenting it. The resource is annotated with when its token was generated, so every replica rereads
+// it when it changes
+func (f *Fleet) writeKubeconfig(ctx context.Context, resource clusterAuthorization) error {
+	options := f.options.Kubeconfigs
+	namespace, name := resource.Metadata.Namespace, resource.Metadata.Name
+	ref := resource.Spec.TokenSecretRef
+	if ref.Name == "" {
+		return errors.New("spec.tokenSecretRef.name is required")
+	}
+	key := ref.Key
+	if key == "" {
+		key = "token"
+	}
+	tokenSecret, err := f.getSecret(ctx, namespace, ref.Name)
+	if err != nil {
+		return err
+	}
+	now := f.now()
+	var rotated bool
+	switch {
+	case tokenSecret == nil:
+		tokenSecret = f.newSecret(resource, ref.Name)
+		tokenSecret.Data[key] = []byte(generateToken())
+		tokenSecret.Metadata.Annotations = map[string]string{TokenRotatedAnnotation: now.Format(time.RFC3339)}
+		rotated = true
+	case tokenSecret.Metadata.Labels[managedByLabel] == managedBy && options.RotationPeriod > 0:
+		generated, _ := time.Parse(time.RFC3339, tokenSecret.Metadata.Annotations[TokenRotatedAnnotation])
+		if now.Before(generated.Add(options.RotationPeriod)) {
+			break
+		}
+		tokenSecret.Data[previousTokenKey(key)] = tokenSecret.Data[key]
+		tokenSecret.Data[key] = []byte(generateToken())
+		if tokenSecret.Metadata.Annotations == nil {
+			tokenSecret.Metadata.Annotations = map[string]string{}
+		}
+		tokenSecret.Metadata.Annotations[TokenRotatedAnnotation] = now.Format(time.RFC3339)
+		rotated = true
+	}
+	if rotated {
+		if err := f.putSecret(ctx, tokenSecret, "token"); err != nil {
+			return err
+		}
+		log.Printf("Generated token for ClusterAuthorization %s/%s\n", namespace, name)
+	}
+
+	token := string(bytes.TrimSpace(tokenSecret.Data[key]))
+	if token == "" {
+		return fmt.Errorf("secret %s/%s has no %q key", namespace, ref.Name, key)
+	}
+	serverURL, err := url.JoinPath(options.ServerURL, "clusters", namespace, name, "authorize")
+	if err != nil {
+		return err
+	}
+	kubeconfig, err := config.GenerateWebhookKubeconfig(config.ApiserverWebhookOptions{
+		Name:      "azimuth-authorization-webhook",
+		ServerURL: serverURL,
+		CAData:    options.CAData,
+		Token:     token,
+	})
+	if err != nil {
+		return err
+	}
+	kubeconfigSecret, err := f.getSecret(ctx, namespace, kubeconfigSecretName(name))
+	if err != nil {
+		return err
+	}
+	if kubeconfigSecret == nil {
+		kubeconfigSecret = f.newSecret(resource, kubeconfigSecretName(name))
+	}
+	if !bytes.Equal(kubeconfigSecret.Data[kubeconfigSecretKey], kubeconfig) {
+		kubeconfigSecret.Data = map[string][]byte{kubeconfigSecretKey: kubeconfig}
+		if err := f.putSecret(ctx, kubeconfigSecret, "kubeconfig"); err != nil {
+			return err
+		}
+	}
+
+	// Also retries annotating after failures, as the token won't be rotated again
+	if generated := tokenSecret.Metadata.Annotations[TokenRotatedAnnotation]; generated != resource.Metadata.Annotations[TokenRotatedAnnotation] {
+		body, err := json.Marshal(map[string]any{"metadata": map[string]any{"annotations": map[string]string{TokenRotatedAnnotation: generated}}})
+		if err != nil {
+			return err
+		}
+		path := fleetAPIPath + "/namespaces/" + url.PathEscape(namespace) + "/clusterauthorizations/" + url.PathEscape(name)
+		resp, err := kubeSend(ctx, f.watcher.conn, f.watcher.client, "fleet", http.MethodPatch, path, "application/merge-patch+json", body, f.options.Timeout)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("unexpected status annotating ClusterAuthorization: %s", resp.Status)
+		}
+	}
+	return nil
+}
+
+// Returns a secret owned by resource, so it's deleted with it, and labelled with the cluster's name as
+// Cluster API labels a cluster's secrets
+func (f *Fleet) newSecret(resource clusterAuthorization, name string) *kubeSecret {
+	secret := &kubeSecret{APIVersion: "v1", Kind: "Secret", Type: "Opaque", Data: map[string][]byte{}}
+	secret.Metadata.Namespace = resource.Metadata.Namespace
+	secret.Metadata.Name = name
+	secret.Metadata.Labels = map[string]string{
+		managedByLabel:                  managedBy,
+		"cluster.x-k8s.io/cluster-name": resource.Metadata.Name,
+	}
+	secret.Metadata.OwnerReferences = []kubeOwnerReference{{
+		APIVersion: "authorization.azimuth-cloud.io/v1alpha1",
+		Kind:       "ClusterAuthorization",
+		Name:       resource.Metadata.Name,
+		UID:        resource.Metadata.UID,
+	}}
+	return secret
+}
+
+// Returns the secret, or nil if it doesn't exist
+func (f *Fleet) getSecret(ctx context.Context, namespace string, name string) (*kubeSecret, error) {
+	path := "/api/v1/namespaces/" + url.PathEscape(namespace) + "/secrets/" + url.PathEscape(name)
+	resp, err := kubeSend(ctx, f.watcher.conn, f.watcher.client, "fleet", http.MethodGet, path, "", nil, f.options.Timeout)
+	if err != nil {
+		return nil, fmt.Errorf("reading secret %s/%s: %w", namespace, name, err)
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("reading secret %s/%s: unexpected status from management cluster: %s", namespace, name, resp.Status)
+	}
+	var secret kubeSecret
+	if err := json.NewDecoder(resp.Body).Decode(&secret); err != nil {
+		return nil, fmt.Errorf("decoding secret %s/%s: %w", namespace, name, err)
+	}
+	if secret.Data == nil {
+		secret.Data = map[string][]byte{}
+	}
+	return &secret, nil
+}
+
+// Creates the secret, or replaces it if it has a resource version
+func (f *Fleet) putSecret(ctx context.Context, secret *kubeSecret, kind string) error {
+	method, path := http.MethodPost, "/api/v1/namespaces/"+url.PathEscape(secret.Metadata.Namespace)+"/secrets"
+	result := "created"
+	if secret.Metadata.ResourceVersion != "" {
+		method, path = http.MethodPut, path+"/"+url.PathEscape(secret.Metadata.Name)
+		result = "updated"
+	}
+	body, err := json.Marshal(secret)
+	if err != nil {
+		return err
+	}
+	resp, err := kubeSend(ctx, f.watcher.conn, f.watcher.client, "fleet", method, path, "application/json", body, f.options.Timeout)
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+			err = fmt.Errorf("unexpected status from management cluster: %s", resp.Status)
+		}
+	}
+	if err != nil {
+		fleetKubeconfigWrites.Inc(kind, "error")
+		return fmt.Errorf("writing secret %s/%s: %w", secret.Metadata.Namespace, secret.Metadata.Name, err)
+	}
+	fleetKubeconfigWrites.Inc(kind, result)
+	return nil
+}
+
+// Returns a random bearer token
+func generateToken() string {
+	token := make([]byte, 32)
+	rand.Read(token)
+	return hex.EncodeToString(token)
+}
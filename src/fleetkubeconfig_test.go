@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Management cluster API holding secrets in memory, recording ClusterAuthorization annotation patches
+type fakeSecretAPI struct {
+	mu      sync.Mutex
+	secrets map[string]*kubeSecret
+	writes  []string
+	patches []string
+}
+
+func (a *fakeSecretAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	const secretsPath = "/api/v1/namespaces/az-tenant-a/secrets"
+	if strings.HasPrefix(r.URL.Path, fleetAPIPath) && r.Method == http.MethodPatch {
+		body, _ := io.ReadAll(r.Body)
+		a.patches = append(a.patches, string(body))
+		return
+	}
+	switch {
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, secretsPath+"/"):
+		secret := a.secrets[strings.TrimPrefix(r.URL.Path, secretsPath+"/")]
+		if secret == nil {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(secret)
+	case r.Method == http.MethodPost && r.URL.Path == secretsPath, r.Method == http.MethodPut:
+		var secret kubeSecret
+		json.NewDecoder(r.Body).Decode(&secret)
+		secret.Metadata.ResourceVersion = "1"
+		a.secrets[secret.Metadata.Name] = &secret
+		a.writes = append(a.writes, r.Method+" "+secret.Metadata.Name)
+		w.WriteHeader(http.StatusCreated)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func newKubeconfigTestFleet(t *testing.T, api *fakeSecretAPI) *Fleet {
+	server := httptest.NewTLSServer(api)
+	t.Cleanup(server.Close)
+	conn, err := LoadKubeconfig(writeKubeconfig(t, server, "    token: x\n"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewFleet(conn, NewOutboundClient(DefaultOutboundClientOptions), WebhookConfig{Config: DefaultPolicyConfig}, FleetOptions{
+		Kubeconfigs: &FleetKubeconfigOptions{ServerURL: "https://authz.example.com/", CAData: []byte("ca"), RotationPeriod: time.Hour},
+	})
+}
+
+func TestFleetKubeconfigGeneratesAndRotatesTokens(t *testing.T) {
+	api := &fakeSecretAPI{secrets: map[string]*kubeSecret{}}
+	fleet := newKubeconfigTestFleet(t, api)
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	fleet.now = func() time.Time { return now }
+	var resource clusterAuthorization
+	json.Unmarshal([]byte(`{"metadata":{"namespace":"az-tenant-a","name":"demo","uid":"1234"},"spec":{"tokenSecretRef":{"name":"demo-webhook"}}}`), &resource)
+
+	if err := fleet.writeKubeconfig(t.Context(), resource); err != nil {
+		t.Fatal(err)
+	}
+	tokenSecret, kubeconfigSecret := api.secrets["demo-webhook"], api.secrets["demo-authorization-webhook-kubeconfig"]
+	if tokenSecret == nil || kubeconfigSecret == nil {
+		t.Fatalf("Expected token and kubeconfig secrets to be created, got %v", api.writes)
+	}
+	token := string(tokenSecret.Data["token"])
+	if len(token) != 64 || tokenSecret.Metadata.OwnerReferences[0].UID != "1234" || tokenSecret.Metadata.Labels["cluster.x-k8s.io/cluster-name"] != "demo" {
+		t.Errorf("Unexpected token secret %+v", tokenSecret)
+	}
+	kubeconfig := string(kubeconfigSecret.Data["value"])
+	for _, expected := range []string{"server: https://authz.example.com/clusters/az-tenant-a/demo/authorize", "token: " + token, "certificate-authority-data: Y2E="} {
+		if !strings.Contains(kubeconfig, expected) {
+			t.Errorf("Expected %q in kubeconfig:\n%s", expected, kubeconfig)
+		}
+	}
+	// Every replica rereads the token once the resource is annotated
+	if len(api.patches) != 1 || !strings.Contains(api.patches[0], `"authorization.azimuth-cloud.io/token-rotated":"2026-01-01T00:00:00Z"`) {
+		t.Errorf("Expected resource to be annotated, got %v", api.patches)
+	}
+
+	// Nothing is written while the secrets are up to date
+	resource.Metadata.Annotations = map[string]string{TokenRotatedAnnotation: "2026-01-01T00:00:00Z"}
+	api.writes = nil
+	now = now.Add(30 * time.Minute)
+	if err := fleet.writeKubeconfig(t.Context(), resource); err != nil || len(api.writes) != 0 || len(api.patches) != 1 {
+		t.Errorf("Expected no writes before rotation, got %v %v %v", err, api.writes, api.patches)
+	}
+
+	now = now.Add(30 * time.Minute)
+	if err := fleet.writeKubeconfig(t.Context(), resource); err != nil {
+		t.Fatal(err)
+	}
+	rotated := string(api.secrets["demo-webhook"].Data["token"])
+	if rotated == token || string(api.secrets["demo-webhook"].Data["previous-token"]) != token || !slices.Equal(api.writes, []string{"PUT demo-webhook", "PUT demo-authorization-webhook-kubeconfig"}) {
+		t.Errorf("Expected token to be rotated, got writes %v", api.writes)
+	}
+	if !strings.Contains(string(api.secrets["demo-authorization-webhook-kubeconfig"].Data["value"]), "token: "+rotated) || len(api.patches) != 2 {
+		t.Errorf("Expected kubeconfig and annotation to be updated, got %v", api.patches)
+	}
+
+	// Routes accept the rotated token and the one it replaced
+	tokens, err := fleet.readTokens("az-tenant-a", "demo-webhook", "token")
+	if err != nil || !slices.Equal(tokens, []string{rotated, token}) {
+		t.Errorf("Expected current and previous tokens, got %v %v", tokens, err)
+	}
+}
+
+func TestFleetKubeconfigLeavesProvidedTokens(t *testing.T) {
+	provided := &kubeSecret{Data: map[string][]byte{"apiserver": []byte("provided\n")}}
+	provided.Metadata.Name = "demo-webhook"
+	provided.Metadata.ResourceVersion = "1"
+	api := &fakeSecretAPI{secrets: map[string]*kubeSecret{"demo-webhook": provided}}
+	fleet := newKubeconfigTestFleet(t, api)
+	fleet.now = func() time.Time { return time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC) }
+	var resource clusterAuthorization
+	json.Unmarshal([]byte(`{"metadata":{"namespace":"az-tenant-a","name":"demo"},"spec":{"tokenSecretRef":{"name":"demo-webhook","key":"apiserver"}}}`), &resource)
+
+	if err := fleet.writeKubeconfig(t.Context(), resource); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(api.writes, []string{"POST demo-authorization-webhook-kubeconfig"}) || len(api.patches) != 0 {
+		t.Errorf("Expected only the kubeconfig to be written, got %v %v", api.writes, api.patches)
+	}
+	if !strings.Contains(string(api.secrets["demo-authorization-webhook-kubeconfig"].Data["value"]), "token: provided\n") {
+		t.Error("Expected kubeconfig to present the provided token")
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/policy"
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/server"
+	"errors"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"net/http"
+	"strings"
+)
+
+// gRPC method of azimuth.authorization.v1.DecisionService, defined in proto/azimuth/authorization/v1
+const DecisionServiceAuthorizePath = "/azimuth.authorization.v1.DecisionService/Authorize"
+
+// Returns values of a repeated string field
+func protoStrings(fields []protoField, number int) []string {
+	var values []string
+	for _, field := range fields {
+		if field.number == number && field.wireType == protoLengthDelimited {
+			values = append(values, string(field.bytes))
+		}
+	}
+	return values
+}
+
+// Decodes an AuthorizeRequest into the equivalent SubjectAccessReview
+func decodeAuthorizeRequest(message []byte) (policy.SubjectAccessReview, error) {
+	sar := policy.SubjectAccessReview{}
+	sar.APIVersion = "authorization.k8s.io/v1"
+	sar.Kind = "SubjectAccessReview"
+	request, err := protoFields(message)
+	if err != nil {
+		return sar, err
+	}
+	sar.Spec.User = string(protoBytes(request, 1))
+	sar.Spec.Groups = protoStrings(request, 2)
+	sar.Spec.UID = string(protoBytes(request, 4))
+	for _, field := range request {
+		if field.number != 3 || field.wireType != protoLengthDelimited {
+			continue
+		}
+		entry, err := protoFields(field.bytes)
+		if err != nil {
+			return sar, err
+		}
+		value, err := protoFields(protoBytes(entry, 2))
+		if err != nil {
+			return sar, err
+		}
+		if sar.Spec.Extra == nil {
+			sar.Spec.Extra = map[string]authorizationv1.ExtraValue{}
+		}
+		sar.Spec.Extra[string(protoBytes(entry, 1))] = protoStrings(value, 1)
+	}
+
+	if resource := protoBytes(request, 5); resource != nil {
+		attributes, err := protoFields(resource)
+		if err != nil {
+			return sar, err
+		}
+		sar.Spec.ResourceAttributes = &authorizationv1.ResourceAttributes{
+			Namespace:   string(protoBytes(attributes, 1)),
+			Verb:        string(protoBytes(attributes, 2)),
+			Group:       string(protoBytes(attributes, 3)),
+			Version:     string(protoBytes(attributes, 4)),
+			Resource:    string(protoBytes(attributes, 5)),
+			Subresource: string(protoBytes(attributes, 6)),
+			Name:        string(protoBytes(attributes, 7)),
+		}
+	}
+	if nonResource := protoBytes(request, 6); nonResource != nil {
+		attributes, err := protoFields(nonResource)
+		if err != nil {
+			return sar, err
+		}
+		sar.Spec.NonResourceAttributes = &authorizationv1.NonResourceAttributes{
+			Path: string(protoBytes(attributes, 1)),
+			Verb: string(protoBytes(attributes, 2)),
+		}
+	}
+	if (sar.Spec.ResourceAttributes == nil) == (sar.Spec.NonResourceAttributes == nil) {
+		return sar, errors.New("exactly one of resource_attributes and non_resource_attributes is required")
+	}
+	return sar, nil
+}
+
+// Encodes status as an AuthorizeResponse, omitting default values as proto3 does
+func encodeAuthorizeResponse(status authorizationv1.SubjectAccessReviewStatus) []byte {
+	var response []byte
+	if status.Allowed {
+		response = protoAppendVarint(response, 1, 1)
+	}
+	if status.Denied {
+		response = protoAppendVarint(response, 2, 1)
+	}
+	if status.Reason != "" {
+		response = protoAppendBytes(response, 3, []byte(status.Reason))
+	}
+	if status.EvaluationError != "" {
+		response = protoAppendBytes(response, 4, []byte(status.EvaluationError))
+	}
+	return response
+}
+
+// Returns HTTP handler implementing the DecisionService Authorize gRPC method. Requests are decided,
+// logged, counted and audited exactly as the equivalent SubjectAccessReview sent to /authorize
+func CreateDecisionServiceHandler(config WebhookConfig) func(w http.ResponseWriter, r *http.Request) {
+	config.Policy = config.policySource()
+	evaluate := withDenyReasonHelp(config, withDenyReasonReferences(config, newEvaluator(config)))
+	decided := newDecisionRecorder(config)
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+		if r.Method != http.MethodPost || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+			server.WriteError(w, http.StatusUnsupportedMediaType, errors.New("gRPC requests only"))
+			return
+		}
+		w.Header().Set("Content-Type", "application/grpc")
+
+		message, err := readGRPCMessage(r.Body)
+		if err != nil {
+			code := grpcInvalidArgument
+			if errors.Is(err, errGRPCCompressed) {
+				code = grpcUnimplemented
+			}
+			writeGRPCStatus(w, code, err.Error())
+			return
+		}
+		sar, err := decodeAuthorizeRequest(message)
+		if err == nil {
+			err = policy.Normalize(&sar, nil)
+		}
+		if err == nil {
+			err = enforceLimits(config, &sar.Spec)
+		}
+		if err == nil {
+			err = policy.Validate(sar)
+		}
+		if err != nil {
+			writeGRPCStatus(w, grpcInvalidArgument, err.Error())
+			return
+		}
+
+		r = r.WithContext(policy.WithSnapshot(r.Context(), config.Policy.Current()))
+		status := evaluate(r.Context(), sar)
+		if err := r.Context().Err(); err != nil {
+			countCancelledRequest(r, err)
+			return
+		}
+		decided(r, sar, status)
+		writeGRPCMessage(w, encodeAuthorizeResponse(status))
+		writeGRPCStatus(w, grpcOK, "")
+	}
+}
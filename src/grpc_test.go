@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Encodes an AuthorizeRequest for a resource request by user in namespace
+func authorizeRequest(user string, verb string, namespace string) []byte {
+	extra := protoAppendBytes(protoAppendBytes(nil, 1, []byte("scopes")), 2, protoAppendBytes(nil, 1, []byte("admin")))
+	resource := protoAppendBytes(nil, 1, []byte(namespace))
+	resource = protoAppendBytes(resource, 2, []byte(verb))
+	resource = protoAppendBytes(resource, 5, []byte("secrets"))
+	request := protoAppendBytes(nil, 1, []byte(user))
+	request = protoAppendBytes(request, 2, []byte("staff"))
+	request = protoAppendBytes(request, 2, []byte("tenants"))
+	request = protoAppendBytes(request, 3, extra)
+	return protoAppendBytes(request, 5, resource)
+}
+
+func TestDecodeAuthorizeRequest(t *testing.T) {
+	sar, err := decodeAuthorizeRequest(authorizeRequest("alice", "get", "kube-system"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec := sar.Spec
+	if spec.User != "alice" || len(spec.Groups) != 2 || spec.Groups[1] != "tenants" || spec.Extra["scopes"][0] != "admin" {
+		t.Errorf("Unexpected user info %+v", spec)
+	}
+	if spec.ResourceAttributes == nil || spec.ResourceAttributes.Namespace != "kube-system" || spec.ResourceAttributes.Resource != "secrets" {
+		t.Errorf("Unexpected resource attributes %+v", spec.ResourceAttributes)
+	}
+}
+
+func TestDecisionServiceOverHTTP2(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(CreateDecisionServiceHandler(WebhookConfig{Config: DefaultPolicyConfig})))
+	server.Config.Protocols = new(http.Protocols)
+	server.Config.Protocols.SetUnencryptedHTTP2(true)
+	server.Start()
+	defer server.Close()
+	client := &http.Client{Transport: &http.Transport{Protocols: server.Config.Protocols}}
+
+	call := func(message []byte) (*http.Response, []protoField) {
+		frame := binary.BigEndian.AppendUint32([]byte{0}, uint32(len(message)))
+		resp, err := client.Post(server.URL+DecisionServiceAuthorizePath, "application/grpc", bytes.NewReader(append(frame, message...)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if len(body) < 5 {
+			return resp, nil
+		}
+		response, err := protoFields(body[5:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp, response
+	}
+
+	resp, response := call(authorizeRequest("alice", "get", "kube-system"))
+	if resp.Trailer.Get("Grpc-Status") != "0" || len(response) == 0 || response[0].number != 2 || response[0].varint != 1 {
+		t.Errorf("Expected denial, got status %q and %v", resp.Trailer.Get("Grpc-Status"), response)
+	}
+	if reason := string(protoBytes(response, 3)); reason == "" {
+		t.Error("Expected denial reason")
+	}
+	resp, response = call(authorizeRequest("alice", "get", "default"))
+	if resp.Trailer.Get("Grpc-Status") != "0" || protoBytes(response, 3) == nil {
+		t.Errorf("Expected no opinion, got %v", response)
+	}
+	for _, field := range response {
+		if field.wireType == protoVarint {
+			t.Errorf("Expected neither allowed nor denied, got field %d", field.number)
+		}
+	}
+	// Requests with only groups are evaluated, but one must identify someone
+	resp, response = call(authorizeRequest("", "get", "kube-system"))
+	if resp.Trailer.Get("Grpc-Status") != "0" || len(response) == 0 || response[0].number != 2 {
+		t.Errorf("Expected denial of groups only request, got status %q and %v", resp.Trailer.Get("Grpc-Status"), response)
+	}
+	anonymous := protoAppendBytes(nil, 5, protoAppendBytes(protoAppendBytes(nil, 1, []byte("default")), 2, []byte("get")))
+	for _, invalid := range [][]byte{protoAppendBytes(nil, 1, []byte("alice")), anonymous} {
+		if resp, _ = call(invalid); resp.Trailer.Get("Grpc-Status") != "3" {
+			t.Errorf("Expected invalid argument, got status %q", resp.Trailer.Get("Grpc-Status"))
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/policy"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"net/http"
+	"os"
+	"os/exec"
+	"sigs.k8s.io/yaml"
+	"strings"
+	"time"
+)
+
+// Timeout of hooks which don't set one, kept short as every matching request waits on the hook
+const defaultHookTimeout = time.Second
+
+// Largest response read from a hook, so a misbehaving one can't exhaust memory
+const maxHookResponseBytes = 1 << 20
+
+var hookDecisions = Metrics.NewCounterVec("azimuth_authz_hook_decisions_total",
+	"SubjectAccessReviews sent to external hooks, by hook and outcome", "hook", "outcome")
+
+// External entitlement check, as configured in the hooks file. Exactly one of Command and URL is set
+type HookConfig struct {
+	Name string `json:"name"`
+	// CEL expression selecting the requests sent to the hook, with the SubjectAccessReview spec as
+	// 'request' like match conditions. Every request is sent if empty
+	Match string `json:"match,omitempty"`
+	// Executable and arguments, run with the SubjectAccessReview on stdin
+	Command []string `json:"command,omitempty"`
+	// Endpoint the SubjectAccessReview is posted to, as for an authorization webhook
+	URL           string                `json:"url,omitempty"`
+	CAFile        string                `json:"caFile,omitempty"`
+	TokenFile     string                `json:"tokenFile,omitempty"`
+	Timeout       string                `json:"timeout,omitempty"`
+	FailurePolicy DelegateFailurePolicy `json:"failurePolicy,omitempty"`
+}
+
+// Authorizer consulting sites' own entitlement systems for matching requests. Commands are given the
+// SubjectAccessReview as JSON on stdin and URLs are posted it, and both answer with a
+// SubjectAccessReview whose status is the verdict. Commands may also exit non-zero to fail
+type Hook struct {
+	Name          string
+	match         *celProgram
+	Command       []string
+	URL           string
+	BearerToken   string
+	Timeout       time.Duration
+	FailurePolicy DelegateFailurePolicy
+	Client        *OutboundClient
+}
+
+// Hooks consulted in order, the first to allow or deny a request deciding it
+type Hooks []*Hook
+
+func (h Hooks) Authorize(ctx context.Context, spec *policy.SubjectAccessReviewSpec) policy.Decision {
+	chain := make(policy.Chain, len(h))
+	for i, hook := range h {
+		chain[i] = hook
+	}
+	return chain.Authorize(ctx, spec)
+}
+
+// Returns the hook's decision, or no opinion if the request doesn't match it
+func (h *Hook) Authorize(ctx context.Context, spec *policy.SubjectAccessReviewSpec) policy.Decision {
+	sar := policy.SubjectAccessReview{Spec: *spec}
+	if h.match != nil {
+		env, err := matchConditionEnv(sar)
+		if err != nil {
+			return h.failed(err)
+		}
+		matched, err := h.match.EvalBool(env)
+		if err != nil {
+			return h.failed(fmt.Errorf("evaluating match: %w", err))
+		}
+		if !matched {
+			return policy.Decision{}
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, h.Timeout)
+	defer cancel()
+	body, err := json.Marshal(toUpstreamSAR(sar))
+	if err != nil {
+		return h.failed(err)
+	}
+	var response []byte
+	if len(h.Command) > 0 {
+		response, err = h.exec(ctx, body)
+	} else {
+		response, err = h.post(ctx, body)
+	}
+	if err != nil {
+		return h.failed(err)
+	}
+	var review authorizationv1.SubjectAccessReview
+	if err := json.Unmarshal(response, &review); err != nil {
+		return h.failed(fmt.Errorf("decoding response: %w", err))
+	}
+	switch {
+	case review.Status.Denied:
+		hookDecisions.Inc(h.Name, "denied")
+		return policy.Decision{Verdict: policy.Deny, Reason: review.Status.Reason, EvaluationError: review.Status.EvaluationError}
+	case review.Status.Allowed:
+		hookDecisions.Inc(h.Name, "allowed")
+		return policy.Decision{Verdict: policy.Allow, Reason: review.Status.Reason, EvaluationError: review.Status.EvaluationError}
+	}
+	hookDecisions.Inc(h.Name, "no-opinion")
+	return policy.Decision{}
+}
+
+func (h *Hook) failed(err error) policy.Decision {
+	hookDecisions.Inc(h.Name, "error")
+	if h.FailurePolicy == DelegateFailDeny {
+		return policy.Decision{Verdict: policy.Deny, Reason: "Hook " + h.Name + " unavailable", EvaluationError: err.Error()}
+	}
+	return policy.Decision{EvaluationError: "hook " + h.Name + ": " + err.Error()}
+}
+
+func (h *Hook) exec(ctx context.Context, body []byte) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, h.Command[0], h.Command[1:]...)
+	cmd.Stdin = bytes.NewReader(body)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &limitedWriter{buffer: &stdout, remaining: maxHookResponseBytes}
+	cmd.Stderr = &limitedWriter{buffer: &stderr, remaining: 4096}
+	// Don't wait on grandchildren holding the output pipes open after the command is killed
+	cmd.WaitDelay = 100 * time.Millisecond
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("timed out after %s", h.Timeout)
+		}
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return nil, fmt.Errorf("%w: %s", err, message)
+		}
+		return nil, err
+	}
+	return stdout.Bytes(), nil
+}
+
+func (h *Hook) post(ctx context.Context, body []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if h.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+h.BearerToken)
+	}
+	resp, err := h.Client.Do("hook", h.Timeout, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return nil, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxHookResponseBytes))
+}
+
+// Writer keeping at most remaining bytes and failing once they're used up
+type limitedWriter struct {
+	buffer    *bytes.Buffer
+	remaining int
+}
+
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	if len(p) > w.remaining {
+		w.buffer.Write(p[:w.remaining])
+		w.remaining = 0
+		return 0, errors.New("output too large")
+	}
+	w.remaining -= len(p)
+	return w.buffer.Write(p)
+}
+
+// Creates hooks from their configuration, reporting every invalid one
+func CreateHooks(configs []HookConfig, client *OutboundClient) (Hooks, error) {
+	hooks := Hooks{}
+	var errs []string
+	for i, config := range configs {
+		if config.Name == "" {
+			config.Name = fmt.Sprintf("hook %d", i)
+		}
+		hook, err := createHook(config, client)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", config.Name, err))
+			continue
+		}
+		hooks = append(hooks, hook)
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid hooks: %v", errs)
+	}
+	return hooks, nil
+}
+
+func createHook(config HookConfig, client *OutboundClient) (*Hook, error) {
+	if (len(config.Command) == 0) == (config.URL == "") {
+		return nil, errors.New("exactly one of command and url must be set")
+	}
+	if config.FailurePolicy == "" {
+		config.FailurePolicy = DelegateFailNoOpinion
+	}
+	if config.FailurePolicy != DelegateFailNoOpinion && config.FailurePolicy != DelegateFailDeny {
+		return nil, fmt.Errorf("unknown failure policy %q", config.FailurePolicy)
+	}
+	hook := &Hook{Name: config.Name, Command: config.Command, URL: config.URL, Timeout: defaultHookTimeout, FailurePolicy: config.FailurePolicy, Client: client}
+	if config.Timeout != "" {
+		timeout, err := time.ParseDuration(config.Timeout)
+		if err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("invalid timeout %q", config.Timeout)
+		}
+		hook.Timeout = timeout
+	}
+	if config.Match != "" {
+		program, err := compileCEL(config.Match)
+		if err != nil {
+			return nil, fmt.Errorf("match: %w", err)
+		}
+		hook.match = program
+	}
+	if config.CAFile != "" {
+		tlsConfig, err := tlsConfigWithCA(config.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		hook.Client = client.WithTLSConfig(tlsConfig)
+	}
+	token, err := readSecretFile(config.TokenFile)
+	if err != nil {
+		return nil, err
+	}
+	hook.BearerToken = token
+	return hook, nil
+}
+
+// Reads and creates a YAML or JSON list of hooks
+func LoadHooks(path string, client *OutboundClient) (Hooks, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var configs []HookConfig
+	if err := yaml.Unmarshal(data, &configs); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return CreateHooks(configs, client)
+}
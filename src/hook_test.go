@@ -0,0 +1,88 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/policy"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Writes an executable shell script running script
+func writeHookScript(t *testing.T, script string) string {
+	path := filepath.Join(t.TempDir(), "hook.sh")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+script+"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestHookCommand(t *testing.T) {
+	// The script only allows users named in the request it reads
+	script := writeHookScript(t, `grep -q '"user":"entitled"' && echo '{"status":{"allowed":true,"reason":"entitled"}}' || echo '{"status":{"denied":true,"reason":"not entitled"}}'`)
+	hooks, err := CreateHooks([]HookConfig{{
+		Name:    "entitlements",
+		Match:   "request.resourceAttributes.namespace == 'tenant'",
+		Command: []string{script},
+	}}, NewOutboundClient(DefaultOutboundClientOptions))
+	if err != nil {
+		t.Fatal(err)
+	}
+	authorizer := CreateWebhookAuthorizer(WebhookConfig{Config: DefaultPolicyConfig, Hooks: hooks})
+
+	accessTest(t, authorizer, false, tenancySAR("entitled", "tenant", "delete"))
+	accessTest(t, authorizer, true, tenancySAR("other", "tenant", "delete"))
+	// Unmatched requests aren't sent to the hook
+	accessTest(t, authorizer, false, tenancySAR("other", "default", "delete"))
+	// Denials by the rules are never overridden
+	accessTest(t, authorizer, true, tenancySAR("entitled", "kube-system", "delete"))
+}
+
+func TestHookFailure(t *testing.T) {
+	spec := &policy.SubjectAccessReviewSpec{User: "tenant-user"}
+	hooks, err := CreateHooks([]HookConfig{
+		{Name: "slow", Command: []string{writeHookScript(t, "sleep 5")}, Timeout: "100ms"},
+		{Name: "failing", Command: []string{writeHookScript(t, "echo broken >&2; exit 1")}},
+	}, NewOutboundClient(DefaultOutboundClientOptions))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decision := hooks.Authorize(t.Context(), spec)
+	if decision.Verdict != policy.NoOpinion || !strings.Contains(decision.EvaluationError, "timed out") || !strings.Contains(decision.EvaluationError, "broken") {
+		t.Errorf("Expected no opinion with both hook errors, got %+v", decision)
+	}
+	hooks[0].FailurePolicy = DelegateFailDeny
+	if decision := hooks.Authorize(t.Context(), spec); decision.Verdict != policy.Deny {
+		t.Errorf("Expected denial when hook fails closed, got %+v", decision)
+	}
+}
+
+func TestHookURL(t *testing.T) {
+	var received authorizationv1.SubjectAccessReview
+	upstream := newTestUpstream(t, authorizationv1.SubjectAccessReviewStatus{Allowed: true, Reason: "entitled"}, &received)
+	defer upstream.Close()
+	hooks, err := CreateHooks([]HookConfig{{URL: upstream.URL}}, NewOutboundClient(DefaultOutboundClientOptions))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decision := hooks.Authorize(t.Context(), &policy.SubjectAccessReviewSpec{User: "tenant-user"})
+	if decision.Verdict != policy.Allow || decision.Reason != "entitled" || received.Spec.User != "tenant-user" {
+		t.Errorf("Expected hook allow for the posted request, got %+v", decision)
+	}
+}
+
+func TestInvalidHooks(t *testing.T) {
+	for _, config := range []HookConfig{
+		{},
+		{Command: []string{"true"}, URL: "http://example.com"},
+		{Command: []string{"true"}, Timeout: "soon"},
+		{Command: []string{"true"}, FailurePolicy: "allow"},
+		{Command: []string{"true"}, Match: "request.user =="},
+	} {
+		if _, err := CreateHooks([]HookConfig{config}, NewOutboundClient(DefaultOutboundClientOptions)); err == nil {
+			t.Errorf("Expected %+v to be rejected", config)
+		}
+	}
+}
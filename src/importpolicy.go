@@ -0,0 +1,268 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/internal/exitcode"
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/config"
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/policy"
+	"bytes"
+	"cmp"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sigs.k8s.io/yaml"
+	"strings"
+)
+
+// Fields common to the admission policy objects read by import-policy
+type importedObject struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Metadata   struct {
+		Name      string `json:"name"`
+		Namespace string `json:"namespace"`
+	} `json:"metadata"`
+}
+
+func (o importedObject) String() string {
+	if o.Metadata.Namespace != "" {
+		return fmt.Sprintf("%s %s/%s", o.Kind, o.Metadata.Namespace, o.Metadata.Name)
+	}
+	return fmt.Sprintf("%s %s", o.Kind, o.Metadata.Name)
+}
+
+type gatekeeperConstraint struct {
+	Spec struct {
+		EnforcementAction string `json:"enforcementAction"`
+		Match             struct {
+			Namespaces []string `json:"namespaces"`
+		} `json:"match"`
+	} `json:"spec"`
+}
+
+// Resource and subject filters of a Kyverno rule's match or exclude block
+type kyvernoFilter struct {
+	Resources struct {
+		Namespaces []string `json:"namespaces"`
+	} `json:"resources"`
+	Subjects []struct {
+		Kind      string `json:"kind"`
+		Name      string `json:"name"`
+		Namespace string `json:"namespace"`
+	} `json:"subjects"`
+	ClusterRoles []string `json:"clusterRoles"`
+	Roles        []string `json:"roles"`
+}
+
+// Match or exclude block, either a single filter or any/all lists of them
+type kyvernoMatch struct {
+	kyvernoFilter
+	Any []kyvernoFilter `json:"any"`
+	All []kyvernoFilter `json:"all"`
+}
+
+func (m kyvernoMatch) filters() []kyvernoFilter {
+	return append(append([]kyvernoFilter{m.kyvernoFilter}, m.Any...), m.All...)
+}
+
+type kyvernoPolicy struct {
+	Spec struct {
+		ValidationFailureAction string `json:"validationFailureAction"`
+		Rules                   []struct {
+			Name     string       `json:"name"`
+			Match    kyvernoMatch `json:"match"`
+			Exclude  kyvernoMatch `json:"exclude"`
+			Validate *struct {
+				FailureAction string `json:"failureAction"`
+			} `json:"validate"`
+		} `json:"rules"`
+	} `json:"spec"`
+}
+
+// Policy suggested by admission policies, with notes on what couldn't be converted
+type policyHints struct {
+	protectedNamespaces []string
+	privilegedUsers     []string
+	notes               []string
+}
+
+func (h *policyHints) note(format string, args ...any) {
+	h.notes = append(h.notes, fmt.Sprintf(format, args...))
+}
+
+var yamlDocumentSeparator = regexp.MustCompile(`(?m)^---[ \t]*$`)
+
+// Adds hints from the Gatekeeper constraints and Kyverno policies in a YAML stream, skipping other
+// objects. Only enforced policies are used, as audit and warn policies don't restrict anyone
+func (h *policyHints) add(data []byte) error {
+	for _, document := range yamlDocumentSeparator.Split(string(data), -1) {
+		if strings.TrimSpace(document) == "" {
+			continue
+		}
+		var object importedObject
+		if err := yaml.Unmarshal([]byte(document), &object); err != nil {
+			return err
+		}
+		if object.Kind == "List" {
+			var list struct {
+				Items []any `json:"items"`
+			}
+			if err := yaml.Unmarshal([]byte(document), &list); err != nil {
+				return err
+			}
+			for _, item := range list.Items {
+				itemData, err := yaml.Marshal(item)
+				if err != nil {
+					return err
+				}
+				if err := h.add(itemData); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		group, _, _ := strings.Cut(object.APIVersion, "/")
+		var err error
+		switch {
+		case group == "constraints.gatekeeper.sh":
+			err = h.addGatekeeperConstraint(object, []byte(document))
+		case group == "kyverno.io" && (object.Kind == "ClusterPolicy" || object.Kind == "Policy"):
+			err = h.addKyvernoPolicy(object, []byte(document))
+		case object.Kind != "":
+			h.note("%s: skipped, not a Gatekeeper constraint or Kyverno policy", object)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", object, err)
+		}
+	}
+	return nil
+}
+
+func (h *policyHints) addGatekeeperConstraint(object importedObject, document []byte) error {
+	var constraint gatekeeperConstraint
+	if err := yaml.Unmarshal(document, &constraint); err != nil {
+		return err
+	}
+	if action := constraint.Spec.EnforcementAction; action != "" && action != "deny" {
+		h.note("%s: skipped, enforcementAction is %s", object, action)
+		return nil
+	}
+	if len(constraint.Spec.Match.Namespaces) == 0 {
+		h.note("%s: skipped, not limited to namespaces", object)
+		return nil
+	}
+	h.protectedNamespaces = append(h.protectedNamespaces, constraint.Spec.Match.Namespaces...)
+	return nil
+}
+
+func (h *policyHints) addKyvernoPolicy(object importedObject, document []byte) error {
+	var kyverno kyvernoPolicy
+	if err := yaml.Unmarshal(document, &kyverno); err != nil {
+		return err
+	}
+	for _, rule := range kyverno.Spec.Rules {
+		if rule.Validate == nil {
+			continue
+		}
+		action := rule.Validate.FailureAction
+		if action == "" {
+			action = kyverno.Spec.ValidationFailureAction
+		}
+		if !strings.EqualFold(action, "enforce") {
+			h.note("%s rule %s: skipped, failure action is %s", object, rule.Name, cmp.Or(action, "Audit"))
+			continue
+		}
+
+		var namespaces []string
+		for _, filter := range rule.Match.filters() {
+			namespaces = append(namespaces, filter.Resources.Namespaces...)
+		}
+		if object.Kind == "Policy" {
+			namespaces = append(namespaces, object.Metadata.Namespace)
+		}
+		if len(namespaces) == 0 {
+			h.note("%s rule %s: skipped, not limited to namespaces", object, rule.Name)
+			continue
+		}
+		h.protectedNamespaces = append(h.protectedNamespaces, namespaces...)
+
+		for _, filter := range rule.Exclude.filters() {
+			for _, subject := range filter.Subjects {
+				switch subject.Kind {
+				case "User":
+					h.privilegedUsers = append(h.privilegedUsers, subject.Name)
+				case "ServiceAccount":
+					h.privilegedUsers = append(h.privilegedUsers, policy.ServiceAccountUserPrefix+subject.Namespace+":"+subject.Name)
+				default:
+					h.note("%s rule %s: excluded %s %s can't be privileged, only users can", object, rule.Name, subject.Kind, subject.Name)
+				}
+			}
+			for _, role := range append(filter.ClusterRoles, filter.Roles...) {
+				h.note("%s rule %s: excluded role %s can't be privileged, only users can", object, rule.Name, role)
+			}
+		}
+	}
+	return nil
+}
+
+// Implements the import-policy command, suggesting a policy file from existing Gatekeeper constraints
+// and Kyverno policies. Namespaces enforced policies apply to are suggested as protected namespaces, and
+// users Kyverno rules exclude as privileged users. Returns the exit code
+func runImportPolicy(args []string, out io.Writer) int {
+	flags := flag.NewFlagSet("import-policy", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: import-policy [flags] FILE...")
+		flags.PrintDefaults()
+	}
+	policyFilePath := flags.String("policy-file", "", "YAML policy file the suggestions are added to")
+	if err := flags.Parse(args); err != nil {
+		return exitcode.Usage
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return exitcode.Usage
+	}
+
+	hints := &policyHints{}
+	for _, path := range flags.Args() {
+		var data []byte
+		var err error
+		if path == "-" {
+			data, err = io.ReadAll(os.Stdin)
+		} else {
+			data, err = os.ReadFile(path)
+		}
+		if err == nil {
+			err = hints.add(data)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s: %s\n", path, err)
+			return exitcode.Of(err)
+		}
+	}
+
+	var policyFile config.PolicyFile
+	if *policyFilePath != "" {
+		var err error
+		if policyFile, err = config.LoadPolicyFile(*policyFilePath); err != nil {
+			return exitcode.Report(os.Stderr, policyError(err))
+		}
+	}
+	policyFile.ProtectedNamespaces = sortedKeys(toSet(append(policyFile.ProtectedNamespaces, hints.protectedNamespaces...)))
+	policyFile.AdditionalPrivilegedUsers = sortedKeys(toSet(append(policyFile.AdditionalPrivilegedUsers, hints.privilegedUsers...)))
+	if err := policyFile.PolicyConfig().Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, "error: suggested policy is invalid:", err)
+		return exitcode.Policy
+	}
+
+	for _, note := range hints.notes {
+		fmt.Fprintln(os.Stderr, "note:", note)
+	}
+	data, err := yaml.Marshal(policyFile)
+	if err != nil {
+		return exitcode.Report(os.Stderr, err)
+	}
+	fmt.Fprintf(out, "# Suggested from admission policies by import-policy. Review before use\n%s", bytes.TrimLeft(data, "\n"))
+	return 0
+}
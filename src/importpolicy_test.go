@@ -0,0 +1,113 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/config"
+	"bytes"
+	"os"
+	"path/filepath"
+	"sigs.k8s.io/yaml"
+	"slices"
+	"strings"
+	"testing"
+)
+
+const admissionPolicies = `apiVersion: constraints.gatekeeper.sh/v1beta1
+kind: K8sBlockWrites
+metadata:
+  name: platform
+spec:
+  match:
+    namespaces: [kube-system, "openstack-*"]
+---
+apiVersion: constraints.gatekeeper.sh/v1beta1
+kind: K8sRequiredLabels
+metadata:
+  name: labels
+spec:
+  enforcementAction: dryrun
+  match:
+    namespaces: [tenant]
+---
+apiVersion: kyverno.io/v1
+kind: ClusterPolicy
+metadata:
+  name: protect-monitoring
+spec:
+  validationFailureAction: Enforce
+  rules:
+    - name: block-changes
+      match:
+        any:
+          - resources:
+              kinds: [Secret]
+              namespaces: [monitoring-system]
+      exclude:
+        any:
+          - subjects:
+              - kind: User
+                name: platform-admin
+              - kind: ServiceAccount
+                name: argocd
+                namespace: argocd
+              - kind: Group
+                name: operators
+      validate:
+        deny: {}
+    - name: audit-only
+      match:
+        resources:
+          namespaces: [audited]
+      validate:
+        failureAction: Audit
+        deny: {}
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: unrelated
+`
+
+func TestImportPolicy(t *testing.T) {
+	dir := t.TempDir()
+	policiesPath := filepath.Join(dir, "policies.yaml")
+	policyPath := filepath.Join(dir, "policy.yaml")
+	os.WriteFile(policiesPath, []byte(admissionPolicies), 0o600)
+	os.WriteFile(policyPath, []byte("protectedNamespaces: [kube-system]\nadditionalPrivilegedUsers: [admin]\n"), 0o600)
+
+	var out bytes.Buffer
+	if code := runImportPolicy([]string{"--policy-file", policyPath, policiesPath}, &out); code != 0 {
+		t.Fatalf("Expected success, got %d", code)
+	}
+	var policyFile config.PolicyFile
+	if err := yaml.UnmarshalStrict(out.Bytes(), &policyFile); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(policyFile.ProtectedNamespaces, []string{"kube-system", "monitoring-system", "openstack-*"}) {
+		t.Errorf("Unexpected protected namespaces %v", policyFile.ProtectedNamespaces)
+	}
+	if !slices.Equal(policyFile.AdditionalPrivilegedUsers, []string{"admin", "platform-admin", "system:serviceaccount:argocd:argocd"}) {
+		t.Errorf("Unexpected privileged users %v", policyFile.AdditionalPrivilegedUsers)
+	}
+}
+
+func TestImportPolicyNotes(t *testing.T) {
+	hints := &policyHints{}
+	if err := hints.add([]byte(admissionPolicies)); err != nil {
+		t.Fatal(err)
+	}
+	notes := strings.Join(hints.notes, "\n")
+	for _, expected := range []string{
+		"K8sRequiredLabels labels: skipped, enforcementAction is dryrun",
+		"rule audit-only: skipped, failure action is Audit",
+		"excluded Group operators can't be privileged",
+		"ConfigMap unrelated: skipped",
+	} {
+		if !strings.Contains(notes, expected) {
+			t.Errorf("Expected note %q, got:\n%s", expected, notes)
+		}
+	}
+
+	if err := hints.add([]byte("apiVersion: kyverno.io/v1\nkind: ClusterPolicy\nspec: [")); err == nil {
+		t.Error("Expected malformed YAML to be rejected")
+	}
+}
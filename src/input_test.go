@@ -1,9 +1,15 @@
 package main
 
 import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/policy"
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/server"
 	"bytes"
+	"encoding/json"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"net/http"
 	"net/http/httptest"
+	"slices"
+	"strings"
 	"testing"
 )
 
@@ -58,6 +64,39 @@ func TestInvalidAPIVersion(t *testing.T) {
 			}`))
 }
 
+func TestAcceptedAPIVersions(t *testing.T) {
+	authorizer := CreateWebhookAuthorizer(WebhookConfig{Config: DefaultPolicyConfig, APIVersions: []string{"authorization.k8s.io/v1", "authorization.k8s.io/v2"}})
+	review := func(apiVersion string) []byte {
+		return []byte(`{
+		"kind":"SubjectAccessReview",
+		"apiVersion":"` + apiVersion + `",
+		"spec":{"resourceAttributes":{"namespace":"kube-system","verb":"get","resource":"secrets"},"user":"alice"}
+		}`)
+	}
+
+	before := unsupportedAPIVersions.Value("authorization.k8s.io/v1beta1")
+	req := httptest.NewRequest(http.MethodPost, "/authorize", bytes.NewReader(review("authorization.k8s.io/v1beta1")))
+	resp := httptest.NewRecorder()
+	authorizer(resp, req)
+	var status metav1.Status
+	json.NewDecoder(resp.Body).Decode(&status)
+	if resp.Code != http.StatusBadRequest || status.Details == nil || status.Details.Causes[0].Field != "apiVersion" ||
+		status.Message != "authorization.k8s.io/v1beta1 not supported. Currently support apiVersions: 'authorization.k8s.io/v1', 'authorization.k8s.io/v2'" {
+		t.Errorf("Expected disabled apiVersion to be rejected, got %d %+v", resp.Code, status)
+	}
+	if unsupportedAPIVersions.Value("authorization.k8s.io/v1beta1") != before+1 {
+		t.Error("Expected unsupported apiVersion to be counted")
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/authorize", bytes.NewReader(review("authorization.k8s.io/v2")))
+	resp = httptest.NewRecorder()
+	authorizer(resp, req)
+	var response server.SubjectAccessReviewResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil || response.ApiVersion != "authorization.k8s.io/v2" || !response.Status.Denied {
+		t.Errorf("Expected enabled future apiVersion to be evaluated as v1, got %+v, %v", response, err)
+	}
+}
+
 func TestEmptySpec(t *testing.T) {
 	inputTest(t, DefaultAuthorizer,
 		[]byte(
@@ -71,6 +110,43 @@ func TestEmptySpec(t *testing.T) {
 			}`))
 }
 
+func TestBothAttributes(t *testing.T) {
+	before := inconsistentRequests.Value(policy.InconsistencyBothAttributes)
+	inputTest(t, DefaultAuthorizer,
+		[]byte(
+			`{
+			"kind":"SubjectAccessReview",
+			"apiVersion":"authorization.k8s.io/v1",
+			"spec":{
+				"resourceAttributes":{"namespace":"default","verb":"get","resource":"pods"},
+				"nonResourceAttributes":{"verb":"get","path":"/healthz"},
+				"user":"alice"
+			}
+			}`))
+	if inconsistentRequests.Value(policy.InconsistencyBothAttributes) != before+1 {
+		t.Error("Expected inconsistent request to be counted")
+	}
+}
+
+func TestOversizedRequest(t *testing.T) {
+	authorizer := CreateWebhookAuthorizer(WebhookConfig{Config: DefaultPolicyConfig, Limits: policy.Limits{MaxGroups: 1}})
+	before := oversizedRequests.Value(policy.LimitGroups, "rejected")
+	inputTest(t, authorizer,
+		[]byte(
+			`{
+			"kind":"SubjectAccessReview",
+			"apiVersion":"authorization.k8s.io/v1",
+			"spec":{
+				"resourceAttributes":{"namespace":"default","verb":"get","resource":"pods"},
+				"user":"alice",
+				"groups":["staff","system:authenticated"]
+			}
+			}`))
+	if oversizedRequests.Value(policy.LimitGroups, "rejected") != before+1 {
+		t.Error("Expected oversized request to be counted")
+	}
+}
+
 func TestBadAttributesFields(t *testing.T) {
 	inputTest(t, DefaultAuthorizer,
 		[]byte(
@@ -117,6 +193,90 @@ func TestBadUser(t *testing.T) {
 			}`))
 }
 
+func TestV1beta1SubjectAccessReview(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/authorize", strings.NewReader(`{
+		"kind":"SubjectAccessReview",
+		"apiVersion":"authorization.k8s.io/v1beta1",
+		"spec":{
+			"resourceAttributes":{"namespace":"kube-system","verb":"create","resource":"pods"},
+			"user":"not-admin",
+			"group":["system:authenticated"]
+		}
+	}`))
+	resp := httptest.NewRecorder()
+	DefaultAuthorizer(resp, req)
+
+	var review server.SubjectAccessReviewResponse
+	if err := json.NewDecoder(resp.Body).Decode(&review); err != nil {
+		t.Fatal(err)
+	}
+	if review.ApiVersion != "authorization.k8s.io/v1beta1" || !review.Status.Denied {
+		t.Errorf("Expected v1beta1 denial, got %+v", review)
+	}
+
+	var sar policy.SubjectAccessReview
+	json.Unmarshal([]byte(`{"apiVersion":"authorization.k8s.io/v1beta1","spec":{"group":["staff"]}}`), &sar)
+	policy.Normalize(&sar, nil)
+	if sar.APIVersion != "authorization.k8s.io/v1" || !slices.Equal(sar.Spec.Groups, []string{"staff"}) {
+		t.Errorf("Expected v1beta1 groups to be converted to v1, got %+v", sar)
+	}
+}
+
+func TestLocalSubjectAccessReviewUsesObjectNamespace(t *testing.T) {
+	accessTest(t, DefaultAuthorizer, true,
+		[]byte(
+			`{
+			"kind":"LocalSubjectAccessReview",
+			"apiVersion":"authorization.k8s.io/v1",
+			"metadata":{"namespace":"kube-system"},
+			"spec":{
+				"resourceAttributes":{"verb":"get","resource":"secrets"},
+				"user":"not-admin"
+			}
+			}`))
+}
+
+func TestLocalSubjectAccessReviewNamespaceMismatch(t *testing.T) {
+	inputTest(t, DefaultAuthorizer,
+		[]byte(
+			`{
+			"kind":"LocalSubjectAccessReview",
+			"apiVersion":"authorization.k8s.io/v1",
+			"metadata":{"namespace":"safe-namespace"},
+			"spec":{
+				"resourceAttributes":{"namespace":"kube-system","verb":"get","resource":"secrets"},
+				"user":"not-admin"
+			}
+			}`))
+}
+
+func TestSelfSubjectAccessReviewUserFromHeaders(t *testing.T) {
+	selfSAR := `{
+		"kind":"SelfSubjectAccessReview",
+		"apiVersion":"authorization.k8s.io/v1",
+		"spec":{
+			"resourceAttributes":{"namespace":"kube-system","verb":"get","resource":"secrets"}
+		}
+		}`
+	inputTest(t, DefaultAuthorizer, []byte(selfSAR))
+
+	for user, expectDenied := range map[string]bool{"not-admin": true, "kubernetes-admin": false} {
+		req := httptest.NewRequest(http.MethodPost, "/authorize", bytes.NewBufferString(selfSAR))
+		req.Header.Set("X-Remote-User", user)
+		req.Header.Add("X-Remote-Group", "system:authenticated")
+		resp := httptest.NewRecorder()
+		DefaultAuthorizer(resp, req)
+
+		var sarResponse server.SubjectAccessReviewResponse
+		if err := json.NewDecoder(resp.Body).Decode(&sarResponse); err != nil {
+			t.Fatal(err)
+		}
+		if sarResponse.Status.Denied != expectDenied {
+			t.Errorf("Expected denied=%v for %s, got %+v", expectDenied, user, sarResponse.Status)
+		}
+	}
+}
+
 func inputTest(t *testing.T, authorizer func(w http.ResponseWriter, r *http.Request), jsonData []byte) {
 	data := bytes.NewBuffer(jsonData)
 	req := httptest.NewRequest(http.MethodPost, "/authorize", data)
@@ -128,4 +288,8 @@ func inputTest(t *testing.T, authorizer func(w http.ResponseWriter, r *http.Requ
 	if resp.Code != http.StatusBadRequest {
 		t.Error("Expected 400 error")
 	}
+	var status metav1.Status
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil || status.Reason != metav1.StatusReasonBadRequest || status.Message == "" {
+		t.Errorf("Expected BadRequest Status, got %+v, %v", status, err)
+	}
 }
@@ -0,0 +1,355 @@
+//go:build integration
+
+package main
+
+// Runs a real etcd and kube-apiserver, from the binaries installed by setup-envtest, configured to
+// consult the webhook, and checks requests made through the apiserver are allowed and denied as
+// expected. Run with:
+//
+//	KUBEBUILDER_ASSETS=$(setup-envtest use -p path) go test -tags integration -run Integration
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/config"
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/policy"
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	integrationAdminToken = "admin-token"
+	integrationAliceToken = "alice-token"
+	integrationSlowToken  = "slow-token"
+)
+
+// Webhook served over TLS for the apiserver, recording the SubjectAccessReview versions it receives
+type integrationWebhook struct {
+	server   *httptest.Server
+	mu       sync.Mutex
+	versions stringSet
+}
+
+func startIntegrationWebhook(t *testing.T, delay time.Duration) *integrationWebhook {
+	t.Helper()
+	authorizer := CreateWebhookAuthorizer(WebhookConfig{Config: DefaultPolicyConfig})
+	webhook := &integrationWebhook{versions: stringSet{}}
+	webhook.server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var sar policy.SubjectAccessReview
+		json.Unmarshal(body, &sar)
+		webhook.mu.Lock()
+		webhook.versions[sar.APIVersion] = struct{}{}
+		webhook.mu.Unlock()
+		// Delays the user "slow" past the apiserver's timeout
+		if sar.Spec.User == "slow" {
+			select {
+			case <-time.After(delay):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		authorizer(w, r)
+	}))
+	t.Cleanup(webhook.server.Close)
+	return webhook
+}
+
+func (webhook *integrationWebhook) Received(version string) bool {
+	webhook.mu.Lock()
+	defer webhook.mu.Unlock()
+	return webhook.versions.Has(version)
+}
+
+// Writes the kubeconfig the apiserver uses to reach the webhook, returning its options
+func (webhook *integrationWebhook) Options(t *testing.T, dir string) config.ApiserverWebhookOptions {
+	t.Helper()
+	options := config.ApiserverWebhookOptions{
+		Name:            "azimuth-authorization-webhook",
+		ServerURL:       webhook.server.URL + "/authorize",
+		CAData:          pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: webhook.server.Certificate().Raw}),
+		KubeconfigPath:  filepath.Join(dir, "webhook-kubeconfig.yaml"),
+		Timeout:         time.Second,
+		AuthorizedTTL:   time.Second,
+		UnauthorizedTTL: time.Second,
+		FailurePolicy:   "NoOpinion",
+	}
+	kubeconfig, err := config.GenerateWebhookKubeconfig(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(options.KubeconfigPath, kubeconfig, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return options
+}
+
+func freePort(t *testing.T) int {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+// Starts a binary from KUBEBUILDER_ASSETS, killing it when the test finishes and logging its output
+// if the test failed
+func startAsset(t *testing.T, name string, args ...string) {
+	t.Helper()
+	cmd := exec.Command(filepath.Join(os.Getenv("KUBEBUILDER_ASSETS"), name), args...)
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+		if t.Failed() {
+			t.Logf("%s output:\n%s", name, output.String())
+		}
+	})
+}
+
+// Client for an apiserver started by startIntegrationAPIServer, authenticating with static tokens
+type integrationClient struct {
+	server string
+	client *http.Client
+}
+
+func (c *integrationClient) Do(token string, method string, path string, body any) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, c.server+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	return c.client.Do(req)
+}
+
+// Returns the status code of a GET request, failing the test if it can't be made
+func (c *integrationClient) Status(t *testing.T, token string, path string) int {
+	t.Helper()
+	status, _ := c.Get(t, token, path)
+	return status
+}
+
+// Returns the status code and body of a GET request, failing the test if it can't be made
+func (c *integrationClient) Get(t *testing.T, token string, path string) (int, string) {
+	t.Helper()
+	resp, err := c.Do(token, http.MethodGet, path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+// Checks a GET request is forbidden by the webhook, rather than for lack of an RBAC rule
+func expectWebhookDenied(t *testing.T, c *integrationClient, token string, path string) {
+	t.Helper()
+	status, body := c.Get(t, token, path)
+	if status != http.StatusForbidden || !strings.Contains(body, "Cannot access secrets in protected namespace") {
+		t.Errorf("GET %s: expected denial by the webhook, got %d: %s", path, status, body)
+	}
+}
+
+// Starts etcd and a kube-apiserver with the given authorization flags, returning once it is ready. The
+// apiserver authenticates admin-token as a member of system:masters, and alice-token and slow-token as
+// unprivileged users
+func startIntegrationAPIServer(t *testing.T, dir string, authorizationArgs ...string) *integrationClient {
+	t.Helper()
+	if os.Getenv("KUBEBUILDER_ASSETS") == "" {
+		t.Skip("KUBEBUILDER_ASSETS not set, install the control plane binaries with setup-envtest")
+	}
+
+	etcdURL := fmt.Sprintf("http://127.0.0.1:%d", freePort(t))
+	startAsset(t, "etcd",
+		"--data-dir="+filepath.Join(dir, "etcd"),
+		"--listen-client-urls="+etcdURL,
+		"--advertise-client-urls="+etcdURL,
+		fmt.Sprintf("--listen-peer-urls=http://127.0.0.1:%d", freePort(t)),
+	)
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(dir, "sa.key")
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	tokensFile := filepath.Join(dir, "tokens.csv")
+	tokens := fmt.Sprintf("%s,kubernetes-admin,1,\"system:masters\"\n%s,alice,2\n%s,slow,3\n",
+		integrationAdminToken, integrationAliceToken, integrationSlowToken)
+	for path, data := range map[string][]byte{keyFile: keyPEM, tokensFile: []byte(tokens)} {
+		if err := os.WriteFile(path, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	port := freePort(t)
+	args := []string{
+		"--etcd-servers=" + etcdURL,
+		"--bind-address=127.0.0.1",
+		fmt.Sprintf("--secure-port=%d", port),
+		"--cert-dir=" + filepath.Join(dir, "certs"),
+		"--service-cluster-ip-range=10.0.0.0/24",
+		"--service-account-issuer=https://kubernetes.default.svc",
+		"--service-account-key-file=" + keyFile,
+		"--service-account-signing-key-file=" + keyFile,
+		"--token-auth-file=" + tokensFile,
+		"--disable-admission-plugins=ServiceAccount",
+	}
+	startAsset(t, "kube-apiserver", append(args, authorizationArgs...)...)
+
+	// The apiserver's serving certificate is self-signed in cert-dir
+	c := &integrationClient{
+		server: fmt.Sprintf("https://127.0.0.1:%d", port),
+		client: &http.Client{Timeout: time.Minute, Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}},
+	}
+	deadline := time.Now().Add(time.Minute)
+	for {
+		resp, err := c.Do(integrationAdminToken, http.MethodGet, "/readyz", nil)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return c
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("kube-apiserver not ready: %v", err)
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+// Writes the structured authorization configuration for options, returning the flag that loads it
+func authorizationConfigArg(t *testing.T, dir string, options config.ApiserverWebhookOptions) string {
+	t.Helper()
+	config, err := config.GenerateAuthorizationConfiguration(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "authorization-config.yaml")
+	if err := os.WriteFile(path, config, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return "--authorization-config=" + path
+}
+
+// Waits for the status of a request to become expected, as RBAC changes propagate asynchronously
+func eventuallyStatus(t *testing.T, c *integrationClient, token string, path string, expected int) {
+	t.Helper()
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		status := c.Status(t, token, path)
+		if status == expected {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("GET %s: expected status %d, got %d", path, expected, status)
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+func TestIntegrationLegacyFlags(t *testing.T) {
+	dir := t.TempDir()
+	webhook := startIntegrationWebhook(t, 0)
+	c := startIntegrationAPIServer(t, dir, config.GenerateApiserverFlags(webhook.Options(t, dir))...)
+
+	eventuallyStatus(t, c, integrationAdminToken, "/api/v1/namespaces/kube-system/secrets", http.StatusOK)
+	expectWebhookDenied(t, c, integrationAliceToken, "/api/v1/namespaces/kube-system/secrets")
+
+	// Not denied by the webhook, so decided by RBAC
+	if status := c.Status(t, integrationAliceToken, "/api/v1/namespaces/default/secrets"); status != http.StatusForbidden {
+		t.Errorf("Expected alice to be forbidden in default before being bound a role, got %d", status)
+	}
+	binding := map[string]any{
+		"apiVersion": "rbac.authorization.k8s.io/v1",
+		"kind":       "ClusterRoleBinding",
+		"metadata":   map[string]string{"name": "alice-edit"},
+		"roleRef":    map[string]string{"apiGroup": "rbac.authorization.k8s.io", "kind": "ClusterRole", "name": "edit"},
+		"subjects":   []any{map[string]string{"apiGroup": "rbac.authorization.k8s.io", "kind": "User", "name": "alice"}},
+	}
+	resp, err := c.Do(integrationAdminToken, http.MethodPost, "/apis/rbac.authorization.k8s.io/v1/clusterrolebindings", binding)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Creating ClusterRoleBinding: %s", resp.Status)
+	}
+	eventuallyStatus(t, c, integrationAliceToken, "/api/v1/namespaces/default/secrets", http.StatusOK)
+	// The binding covers all namespaces, but the webhook still protects kube-system
+	expectWebhookDenied(t, c, integrationAliceToken, "/api/v1/namespaces/kube-system/secrets")
+
+	if !webhook.Received("authorization.k8s.io/v1") {
+		t.Error("Expected the webhook to receive v1 SubjectAccessReviews")
+	}
+}
+
+func TestIntegrationV1beta1(t *testing.T) {
+	dir := t.TempDir()
+	webhook := startIntegrationWebhook(t, 0)
+	options := webhook.Options(t, dir)
+	options.SubjectAccessReviewVersion = "v1beta1"
+	c := startIntegrationAPIServer(t, dir, authorizationConfigArg(t, dir, options))
+
+	// The apiserver rejects responses of the wrong version, which would leave the decision to RBAC
+	expectWebhookDenied(t, c, integrationAliceToken, "/api/v1/namespaces/kube-system/secrets")
+	if !webhook.Received("authorization.k8s.io/v1beta1") || webhook.Received("authorization.k8s.io/v1") {
+		t.Error("Expected the webhook to receive only v1beta1 SubjectAccessReviews")
+	}
+}
+
+func TestIntegrationTimeout(t *testing.T) {
+	for _, failurePolicy := range []string{"Deny", "NoOpinion"} {
+		t.Run(failurePolicy, func(t *testing.T) {
+			dir := t.TempDir()
+			webhook := startIntegrationWebhook(t, 5*time.Second)
+			options := webhook.Options(t, dir)
+			options.FailurePolicy = failurePolicy
+			c := startIntegrationAPIServer(t, dir, authorizationConfigArg(t, dir, options))
+
+			// Discovery is allowed to all authenticated users by RBAC, so only the failure policy can deny it
+			expected := http.StatusOK
+			if failurePolicy == "Deny" {
+				expected = http.StatusForbidden
+			}
+			if status := c.Status(t, integrationSlowToken, "/api"); status != expected {
+				t.Errorf("Expected status %d when the webhook times out, got %d", expected, status)
+			}
+			if status := c.Status(t, integrationAliceToken, "/api"); status != http.StatusOK {
+				t.Errorf("Expected requests answered in time to be unaffected, got %d", status)
+			}
+		})
+	}
+}
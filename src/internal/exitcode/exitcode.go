@@ -0,0 +1,77 @@
+// Package exitcode defines the exit codes shared by the commands of the binary, so pipelines can branch on
+// why a command failed rather than just that it did
+package exitcode
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+)
+
+const (
+	OK = 0
+	// Failures with no more specific code, e.g. malformed input files
+	Failure = 1
+	// Invalid flags or arguments
+	Usage = 2
+	// The request checked was denied
+	Denied = 3
+	// Configuration files which can't be read, or settings and kubeconfigs which are invalid
+	Config = 4
+	// Policy which fails schema validation, validation or the self-test
+	Policy = 5
+	// Decisions which don't match those expected, e.g. replayed decisions which changed or failed
+	// conformance cases
+	Mismatch = 6
+	// The webhook, apiserver or another service couldn't be reached or answered with an error
+	Connectivity = 7
+)
+
+// Error exiting with Code
+type Error struct {
+	Code int
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Returns err exiting with code, nil if err is nil
+func Wrap(code int, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Code: code, Err: err}
+}
+
+// Returns the code to exit with for err: the code it was wrapped with, Config for files which can't be read,
+// Connectivity for network errors, or Failure
+func Of(err error) int {
+	var exitErr *Error
+	var pathErr *fs.PathError
+	var netErr net.Error
+	switch {
+	case err == nil:
+		return OK
+	case errors.As(err, &exitErr):
+		return exitErr.Code
+	case errors.As(err, &pathErr):
+		return Config
+	case errors.As(err, &netErr):
+		return Connectivity
+	}
+	return Failure
+}
+
+// Prints err to w, returning the code to exit with for it
+func Report(w io.Writer, err error) int {
+	fmt.Fprintln(w, "error:", err)
+	return Of(err)
+}
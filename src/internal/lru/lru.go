@@ -0,0 +1,76 @@
+// Package lru provides a generic least recently used cache
+package lru
+
+import (
+	"container/list"
+	"sync"
+)
+
+// Fixed capacity, least recently used cache safe for concurrent use
+type Cache[K comparable, V any] struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List
+	entries  map[K]*list.Element
+}
+
+type Entry[K comparable, V any] struct {
+	Key   K
+	Value V
+}
+
+func New[K comparable, V any](capacity int) *Cache[K, V] {
+	return &Cache[K, V]{capacity: capacity, order: list.New(), entries: make(map[K]*list.Element, capacity)}
+}
+
+func (c *Cache[K, V]) Get(key K) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(*Entry[K, V]).Value, true
+}
+
+func (c *Cache[K, V]) Add(key K, value V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[key]; ok {
+		element.Value.(*Entry[K, V]).Value = value
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&Entry[K, V]{Key: key, Value: value})
+	if c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*Entry[K, V]).Key)
+	}
+}
+
+func (c *Cache[K, V]) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.order.Init()
+	clear(c.entries)
+}
+
+func (c *Cache[K, V]) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// Returns all entries, from least to most recently used
+func (c *Cache[K, V]) Entries() []Entry[K, V] {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entries := make([]Entry[K, V], 0, c.order.Len())
+	for element := c.order.Back(); element != nil; element = element.Prev() {
+		entries = append(entries, *element.Value.(*Entry[K, V]))
+	}
+	return entries
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/internal/lru"
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/policy"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+type KeystoneRoleResolverOptions struct {
+	// Keystone endpoint including version, e.g. https://keystone.example.com:5000/v3
+	URL string
+	// Application credential the webhook authenticates to Keystone with. It needs to be able to
+	// list role assignments, e.g. via the reader role
+	ApplicationCredentialID     string
+	ApplicationCredentialSecret string
+	// Roles granting privileged status in any project, e.g. k8s_admin
+	PrivilegedRoles []string
+	CacheSize       int
+	CacheTTL        time.Duration
+}
+
+// Grants privileged status to Keystone users holding one of the configured roles. Users are identified
+// by the SAR UID, which the Keystone TokenReview backend sets to the Keystone user ID
+type KeystoneRoleResolver struct {
+	options         KeystoneRoleResolverOptions
+	client          *OutboundClient
+	privilegedRoles stringSet
+	cache           *lru.Cache[string, cachedKeystoneRoles]
+
+	tokenMu      sync.Mutex
+	token        string
+	tokenExpires time.Time
+}
+
+type cachedKeystoneRoles struct {
+	roles   stringSet
+	expires time.Time
+}
+
+type keystoneRoleAssignments struct {
+	RoleAssignments []struct {
+		Role struct {
+			Name string `json:"name"`
+		} `json:"role"`
+	} `json:"role_assignments"`
+}
+
+func NewKeystoneRoleResolver(options KeystoneRoleResolverOptions, client *OutboundClient) *KeystoneRoleResolver {
+	if options.CacheSize <= 0 {
+		options.CacheSize = 1024
+	}
+	options.URL = strings.TrimSuffix(options.URL, "/")
+	return &KeystoneRoleResolver{
+		options:         options,
+		client:          client,
+		privilegedRoles: toSet(options.PrivilegedRoles),
+		cache:           lru.New[string, cachedKeystoneRoles](options.CacheSize),
+	}
+}
+
+func (k *KeystoneRoleResolver) Name() string { return "keystone" }
+
+func (k *KeystoneRoleResolver) IsPrivileged(ctx context.Context, spec *policy.SubjectAccessReviewSpec) (bool, error) {
+	if spec.UID == "" {
+		return false, nil
+	}
+	roles, err := k.rolesFor(ctx, spec.UID)
+	if err != nil {
+		return false, err
+	}
+	for role := range roles {
+		if k.privilegedRoles.Has(role) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Returns names of the roles effectively assigned to the user in any project, cached for the configured TTL
+func (k *KeystoneRoleResolver) rolesFor(ctx context.Context, userID string) (stringSet, error) {
+	if cached, ok := k.cache.Get(userID); ok && time.Now().Before(cached.expires) {
+		return cached.roles, nil
+	}
+	token, err := k.serviceToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	query := url.Values{"user.id": {userID}, "effective": {""}, "include_names": {"true"}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, k.options.URL+"/role_assignments?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Auth-Token", token)
+	resp, err := k.client.Do("keystone", 0, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized {
+		// Token revoked early, fetch a new one on the next lookup
+		k.tokenMu.Lock()
+		k.token = ""
+		k.tokenMu.Unlock()
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status listing Keystone role assignments: %s", resp.Status)
+	}
+
+	var body keystoneRoleAssignments
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("decoding Keystone role assignments: %w", err)
+	}
+	roles := stringSet{}
+	for _, assignment := range body.RoleAssignments {
+		roles[assignment.Role.Name] = struct{}{}
+	}
+	k.cache.Add(userID, cachedKeystoneRoles{roles: roles, expires: time.Now().Add(k.options.CacheTTL)})
+	return roles, nil
+}
+
+// Returns a token for the webhook's application credential, reissuing it shortly before it expires
+func (k *KeystoneRoleResolver) serviceToken(ctx context.Context) (string, error) {
+	k.tokenMu.Lock()
+	defer k.tokenMu.Unlock()
+	if k.token != "" && time.Now().Add(time.Minute).Before(k.tokenExpires) {
+		return k.token, nil
+	}
+
+	var auth struct {
+		Auth struct {
+			Identity struct {
+				Methods               []string `json:"methods"`
+				ApplicationCredential struct {
+					ID     string `json:"id"`
+					Secret string `json:"secret"`
+				} `json:"application_credential"`
+			} `json:"identity"`
+		} `json:"auth"`
+	}
+	auth.Auth.Identity.Methods = []string{"application_credential"}
+	auth.Auth.Identity.ApplicationCredential.ID = k.options.ApplicationCredentialID
+	auth.Auth.Identity.ApplicationCredential.Secret = k.options.ApplicationCredentialSecret
+	body, err := json.Marshal(auth)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, k.options.URL+"/auth/tokens", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := k.client.Do("keystone", 0, req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return "", fmt.Errorf("unexpected status authenticating to Keystone: %s", resp.Status)
+	}
+	var issued struct {
+		Token struct {
+			ExpiresAt time.Time `json:"expires_at"`
+		} `json:"token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&issued); err != nil {
+		return "", fmt.Errorf("decoding Keystone token: %w", err)
+	}
+	k.token = resp.Header.Get("X-Subject-Token")
+	k.tokenExpires = issued.Token.ExpiresAt
+	return k.token, nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newTestKeystone(t *testing.T, tokensIssued *int, lookups *int) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v3/auth/tokens":
+			*tokensIssued++
+			w.Header().Set("X-Subject-Token", "service-token")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(map[string]any{"token": map[string]any{"expires_at": time.Now().Add(time.Hour)}})
+		case "/v3/role_assignments":
+			*lookups++
+			if r.Header.Get("X-Auth-Token") != "service-token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			roles := []string{"member"}
+			if r.URL.Query().Get("user.id") == "admin-id" {
+				roles = append(roles, "k8s_admin")
+			}
+			var assignments []map[string]any
+			for _, role := range roles {
+				assignments = append(assignments, map[string]any{"role": map[string]string{"name": role}})
+			}
+			json.NewEncoder(w).Encode(map[string]any{"role_assignments": assignments})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func keystoneSAR(user string, uid string) []byte {
+	return []byte(`{
+	"kind":"SubjectAccessReview",
+	"apiVersion":"authorization.k8s.io/v1",
+	"spec":{
+		"resourceAttributes":{"namespace":"kube-system","verb":"get","resource":"secrets"},
+		"user":"` + user + `",
+		"uid":"` + uid + `"
+	}
+	}`)
+}
+
+func TestKeystoneRolesGrantPrivilegedStatus(t *testing.T) {
+	var tokensIssued, lookups int
+	server := newTestKeystone(t, &tokensIssued, &lookups)
+	authorizer := CreateWebhookAuthorizer(WebhookConfig{
+		Config: DefaultPolicyConfig,
+		Privileges: PrivilegeResolvers{NewKeystoneRoleResolver(KeystoneRoleResolverOptions{
+			URL:             server.URL + "/v3/",
+			PrivilegedRoles: []string{"k8s_admin"},
+			CacheTTL:        time.Minute,
+		}, NewOutboundClient(DefaultOutboundClientOptions))},
+	})
+
+	accessTest(t, authorizer, false, keystoneSAR("admin", "admin-id"))
+	accessTest(t, authorizer, false, keystoneSAR("admin", "admin-id"))
+	accessTest(t, authorizer, true, keystoneSAR("member", "member-id"))
+	// Without a UID there is no Keystone user to look up
+	accessTest(t, authorizer, true, keystoneSAR("admin", ""))
+
+	if tokensIssued != 1 || lookups != 2 {
+		t.Errorf("Expected one service token and one cached lookup per user, got %d tokens and %d lookups", tokensIssued, lookups)
+	}
+}
+
+func TestKeystoneRoleLookupFailureWithholdsPrivileges(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	authorizer := CreateWebhookAuthorizer(WebhookConfig{
+		Config: DefaultPolicyConfig,
+		Privileges: PrivilegeResolvers{NewKeystoneRoleResolver(KeystoneRoleResolverOptions{
+			URL:             server.URL,
+			PrivilegedRoles: []string{"k8s_admin"},
+		}, NewOutboundClient(DefaultOutboundClientOptions))},
+	})
+	accessTest(t, authorizer, true, keystoneSAR("admin", "admin-id"))
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sigs.k8s.io/yaml"
+	"strings"
+)
+
+// Subset of the kubeconfig format needed to reach a cluster's API server with static credentials
+type kubeconfig struct {
+	CurrentContext string `json:"current-context"`
+	Clusters       []struct {
+		Name    string `json:"name"`
+		Cluster struct {
+			Server                   string `json:"server"`
+			TLSServerName            string `json:"tls-server-name"`
+			InsecureSkipTLSVerify    bool   `json:"insecure-skip-tls-verify"`
+			CertificateAuthority     string `json:"certificate-authority"`
+			CertificateAuthorityData string `json:"certificate-authority-data"`
+		} `json:"cluster"`
+	} `json:"clusters"`
+	Users []struct {
+		Name string `json:"name"`
+		User struct {
+			Token                 string `json:"token"`
+			TokenFile             string `json:"tokenFile"`
+			ClientCertificate     string `json:"client-certificate"`
+			ClientCertificateData string `json:"client-certificate-data"`
+			ClientKey             string `json:"client-key"`
+			ClientKeyData         string `json:"client-key-data"`
+		} `json:"user"`
+	} `json:"users"`
+	Contexts []struct {
+		Name    string `json:"name"`
+		Context struct {
+			Cluster string `json:"cluster"`
+			User    string `json:"user"`
+		} `json:"context"`
+	} `json:"contexts"`
+}
+
+// Connection details for a Kubernetes API server resolved from a kubeconfig context
+type ClusterConnection struct {
+	Server      string
+	BearerToken string
+	TLSConfig   *tls.Config
+}
+
+// Resolves the named context, or the current context if empty, from the kubeconfig at path.
+// Only static credentials are supported; exec and auth provider plugins are rejected
+func LoadKubeconfig(path string, contextName string) (*ClusterConnection, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config kubeconfig
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("parsing kubeconfig %s: %w", path, err)
+	}
+	if contextName == "" {
+		contextName = config.CurrentContext
+	}
+	// Relative file references are resolved against the kubeconfig's directory, as kubectl does
+	baseDir := filepath.Dir(path)
+
+	var clusterName, userName string
+	found := false
+	for _, c := range config.Contexts {
+		if c.Name == contextName {
+			clusterName, userName, found = c.Context.Cluster, c.Context.User, true
+			break
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("context %q not found in kubeconfig %s", contextName, path)
+	}
+
+	conn := &ClusterConnection{TLSConfig: &tls.Config{MinVersion: tls.VersionTLS12}}
+	found = false
+	for _, c := range config.Clusters {
+		if c.Name != clusterName {
+			continue
+		}
+		found = true
+		conn.Server = strings.TrimSuffix(c.Cluster.Server, "/")
+		conn.TLSConfig.ServerName = c.Cluster.TLSServerName
+		conn.TLSConfig.InsecureSkipVerify = c.Cluster.InsecureSkipTLSVerify
+		caPEM, err := inlineOrFile(c.Cluster.CertificateAuthorityData, c.Cluster.CertificateAuthority, baseDir)
+		if err != nil {
+			return nil, fmt.Errorf("reading cluster CA: %w", err)
+		}
+		if caPEM != nil {
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(caPEM) {
+				return nil, fmt.Errorf("no certificates found in CA of cluster %q", clusterName)
+			}
+			conn.TLSConfig.RootCAs = pool
+		}
+		break
+	}
+	if !found || conn.Server == "" {
+		return nil, fmt.Errorf("cluster %q not found in kubeconfig %s", clusterName, path)
+	}
+
+	for _, u := range config.Users {
+		if u.Name != userName {
+			continue
+		}
+		conn.BearerToken = u.User.Token
+		if conn.BearerToken == "" && u.User.TokenFile != "" {
+			if conn.BearerToken, err = readSecretFile(resolvePath(u.User.TokenFile, baseDir)); err != nil {
+				return nil, err
+			}
+		}
+		certPEM, err := inlineOrFile(u.User.ClientCertificateData, u.User.ClientCertificate, baseDir)
+		if err != nil {
+			return nil, fmt.Errorf("reading client certificate: %w", err)
+		}
+		keyPEM, err := inlineOrFile(u.User.ClientKeyData, u.User.ClientKey, baseDir)
+		if err != nil {
+			return nil, fmt.Errorf("reading client key: %w", err)
+		}
+		if certPEM != nil || keyPEM != nil {
+			cert, err := tls.X509KeyPair(certPEM, keyPEM)
+			if err != nil {
+				return nil, fmt.Errorf("loading client certificate: %w", err)
+			}
+			conn.TLSConfig.Certificates = []tls.Certificate{cert}
+		}
+		if conn.BearerToken == "" && certPEM == nil {
+			return nil, fmt.Errorf("user %q has no token or client certificate; exec and auth provider credentials are not supported", userName)
+		}
+		return conn, nil
+	}
+	return nil, fmt.Errorf("user %q not found in kubeconfig %s", userName, path)
+}
+
+// Returns base64 decoded inline data if set, otherwise the contents of file, or nil if neither is set
+func inlineOrFile(inline string, file string, baseDir string) ([]byte, error) {
+	if inline != "" {
+		return base64.StdEncoding.DecodeString(inline)
+	}
+	if file != "" {
+		return os.ReadFile(resolvePath(file, baseDir))
+	}
+	return nil, nil
+}
+
+func resolvePath(path string, baseDir string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(baseDir, path)
+}
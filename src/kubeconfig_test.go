@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeKubeconfig(t *testing.T, server *httptest.Server, user string) string {
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	config := `apiVersion: v1
+kind: Config
+current-context: management
+clusters:
+- name: management
+  cluster:
+    server: ` + server.URL + `
+    certificate-authority-data: ` + base64.StdEncoding.EncodeToString(caPEM) + `
+contexts:
+- name: management
+  context:
+    cluster: management
+    user: webhook
+users:
+- name: webhook
+  user:
+` + user
+	path := filepath.Join(t.TempDir(), "kubeconfig")
+	if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestManagementClusterDelegatePostsToSubjectAccessReviewAPI(t *testing.T) {
+	var received authorizationv1.SubjectAccessReview
+	var path, authorization string
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, authorization = r.URL.Path, r.Header.Get("Authorization")
+		json.NewDecoder(r.Body).Decode(&received)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(authorizationv1.SubjectAccessReview{Status: authorizationv1.SubjectAccessReviewStatus{Denied: true, Reason: "management RBAC"}})
+	}))
+	defer server.Close()
+
+	delegate, err := createManagementClusterDelegate(writeKubeconfig(t, server, "    token: secret-token\n"), "", time.Second, DelegateFailNoOpinion, NewOutboundClient(DefaultOutboundClientOptions))
+	if err != nil {
+		t.Fatal(err)
+	}
+	accessTest(t, CreateWebhookAuthorizer(WebhookConfig{Config: DefaultPolicyConfig, Delegate: delegate}), true, []byte(unprotectedWriteSAR))
+
+	if path != "/apis/authorization.k8s.io/v1/subjectaccessreviews" {
+		t.Errorf("Expected SubjectAccessReview API to be called, got %s", path)
+	}
+	if authorization != "Bearer secret-token" {
+		t.Errorf("Expected kubeconfig token to be sent, got %q", authorization)
+	}
+	if received.Kind != "SubjectAccessReview" || received.Spec.User != "tenant-user" {
+		t.Errorf("Unexpected SubjectAccessReview sent to management cluster: %+v", received)
+	}
+}
+
+func TestLoadKubeconfigRejectsPluginCredentials(t *testing.T) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+
+	_, err := LoadKubeconfig(writeKubeconfig(t, server, "    exec:\n      command: get-token\n"), "")
+	if err == nil || !strings.Contains(err.Error(), "not supported") {
+		t.Errorf("Expected exec credentials to be rejected, got %v", err)
+	}
+	if _, err := LoadKubeconfig(writeKubeconfig(t, server, "    token: x\n"), "missing"); err == nil {
+		t.Error("Expected error for unknown context")
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Lists and then watches a collection of Kubernetes objects, passing them to replace and update so
+// that the caller can maintain an in-memory copy
+type kubeWatcher struct {
+	conn   *ClusterConnection
+	client *OutboundClient
+	// Backend name used in outbound request metrics
+	backend string
+	// API path of the collection, e.g. /apis/cluster.x-k8s.io/v1beta1/clusters
+	path string
+	// Called with every object after each list
+	replace func(items []json.RawMessage) error
+	// Called with each object added, modified or deleted during a watch
+	update func(object json.RawMessage, deleted bool) error
+	// Optional, failing until the first list succeeds and while relisting fails
+	health *health
+}
+
+type kubeObjectList struct {
+	Metadata struct {
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Items []json.RawMessage `json:"items"`
+}
+
+type kubeWatchEvent struct {
+	Type   string          `json:"type"`
+	Object json.RawMessage `json:"object"`
+}
+
+// Lists and then watches objects until ctx is cancelled, relisting after errors
+func (w *kubeWatcher) Run(ctx context.Context) {
+	backoff := time.Second
+	for ctx.Err() == nil {
+		resourceVersion, err := w.list(ctx)
+		if err == nil {
+			w.health.succeeded()
+		}
+		for err == nil && ctx.Err() == nil {
+			resourceVersion, err = w.watch(ctx, resourceVersion)
+			backoff = time.Second
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("Error watching %s, relisting: %s\n", w.path, err)
+		w.health.failed(err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, time.Minute)
+	}
+}
+
+func (w *kubeWatcher) list(ctx context.Context) (string, error) {
+	resp, err := kubeGet(ctx, w.conn, w.client, w.backend, w.path, 0)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var list kubeObjectList
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return "", fmt.Errorf("decoding list: %w", err)
+	}
+	if err := w.replace(list.Items); err != nil {
+		return "", err
+	}
+	return list.Metadata.ResourceVersion, nil
+}
+
+// Applies watch events until the server ends the watch, returning the last seen resource version
+func (w *kubeWatcher) watch(ctx context.Context, resourceVersion string) (string, error) {
+	const watchSeconds = 300
+	query := url.Values{
+		"watch":               {"1"},
+		"resourceVersion":     {resourceVersion},
+		"allowWatchBookmarks": {"true"},
+		"timeoutSeconds":      {fmt.Sprint(watchSeconds)},
+	}
+	resp, err := kubeGet(ctx, w.conn, w.client, w.backend, w.path+"?"+query.Encode(), (watchSeconds+30)*time.Second)
+	if err != nil {
+		return resourceVersion, err
+	}
+	defer resp.Body.Close()
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var event kubeWatchEvent
+		if err := decoder.Decode(&event); err != nil {
+			// The server closing the stream at timeoutSeconds ends the watch normally
+			if ctx.Err() == nil && errors.Is(err, io.EOF) {
+				return resourceVersion, nil
+			}
+			return resourceVersion, err
+		}
+		if event.Type == "ERROR" {
+			// Typically 410 Gone once resourceVersion has been compacted, requiring a relist
+			return resourceVersion, fmt.Errorf("watch error: %s", event.Object)
+		}
+		var object struct {
+			Metadata struct {
+				ResourceVersion string `json:"resourceVersion"`
+			} `json:"metadata"`
+		}
+		if err := json.Unmarshal(event.Object, &object); err != nil {
+			return resourceVersion, fmt.Errorf("decoding watch event: %w", err)
+		}
+		resourceVersion = object.Metadata.ResourceVersion
+		switch event.Type {
+		case "ADDED", "MODIFIED":
+			err = w.update(event.Object, false)
+		case "DELETED":
+			err = w.update(event.Object, true)
+		}
+		if err != nil {
+			return resourceVersion, err
+		}
+	}
+}
+
+// Sends a GET request for path to the cluster's API server, returning an error unless the response is 200 OK
+func kubeGet(ctx context.Context, conn *ClusterConnection, client *OutboundClient, backend string, path string, timeout time.Duration) (*http.Response, error) {
+	resp, err := kubeSend(ctx, conn, client, backend, http.MethodGet, path, "", nil, timeout)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected status from management cluster: %s", resp.Status)
+	}
+	return resp, nil
+}
+
+// Sends a request with an optional body of contentType to the cluster's API server, returning the
+// response whatever its status
+func kubeSend(ctx context.Context, conn *ClusterConnection, client *OutboundClient, backend string, method string, path string, contentType string, body []byte, timeout time.Duration) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, conn.Server+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if conn.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+conn.BearerToken)
+	}
+	return client.Do(backend, timeout, req)
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/internal/lru"
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/policy"
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Minimal LDAPv3 client supporting just simple bind and an equality search, which is all group
+// lookups need. Kept dependency free like the rest of the webhook.
+
+const (
+	berTagInteger     = 0x02
+	berTagOctetString = 0x04
+	berTagBoolean     = 0x01
+	berTagEnumerated  = 0x0a
+	berTagSequence    = 0x30
+	berTagSet         = 0x31
+
+	ldapBindRequest       = 0x60
+	ldapBindResponse      = 0x61
+	ldapUnbindRequest     = 0x42
+	ldapSearchRequest     = 0x63
+	ldapSearchResultEntry = 0x64
+	ldapSearchResultDone  = 0x65
+	ldapSimpleAuth        = 0x80
+	ldapEqualityFilter    = 0xa3
+)
+
+// BER encoded tag, length and value
+type berElement struct {
+	tag      byte
+	value    []byte
+	children []berElement
+}
+
+func berEncode(tag byte, value []byte) []byte {
+	var length []byte
+	switch n := len(value); {
+	case n < 0x80:
+		length = []byte{byte(n)}
+	case n <= 0xff:
+		length = []byte{0x81, byte(n)}
+	case n <= 0xffff:
+		length = []byte{0x82, byte(n >> 8), byte(n)}
+	default:
+		length = []byte{0x84, byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)}
+	}
+	return append(append([]byte{tag}, length...), value...)
+}
+
+func berConstructed(tag byte, children ...[]byte) []byte {
+	var value []byte
+	for _, child := range children {
+		value = append(value, child...)
+	}
+	return berEncode(tag, value)
+}
+
+func berString(s string) []byte { return berEncode(berTagOctetString, []byte(s)) }
+
+func berInt(tag byte, n int) []byte {
+	// Minimal two's complement encoding, only non-negative values are needed
+	value := []byte{byte(n)}
+	for n > 0x7f {
+		n >>= 8
+		value = append([]byte{byte(n)}, value...)
+	}
+	return berEncode(tag, value)
+}
+
+type berReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// Reads one BER element, decoding children of constructed elements
+func berRead(r berReader) (berElement, error) {
+	tag, err := r.ReadByte()
+	if err != nil {
+		return berElement{}, err
+	}
+	first, err := r.ReadByte()
+	if err != nil {
+		return berElement{}, err
+	}
+	length := int(first)
+	if first&0x80 != 0 {
+		octets := int(first & 0x7f)
+		if octets == 0 || octets > 4 {
+			return berElement{}, fmt.Errorf("unsupported BER length encoding")
+		}
+		length = 0
+		for i := 0; i < octets; i++ {
+			b, err := r.ReadByte()
+			if err != nil {
+				return berElement{}, err
+			}
+			length = length<<8 | int(b)
+		}
+	}
+	if length > 16<<20 {
+		return berElement{}, fmt.Errorf("BER element of %d bytes too large", length)
+	}
+	value := make([]byte, length)
+	if _, err := io.ReadFull(r, value); err != nil {
+		return berElement{}, err
+	}
+	element := berElement{tag: tag, value: value}
+	if tag&0x20 != 0 {
+		reader := bytes.NewReader(value)
+		for {
+			child, err := berRead(reader)
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return berElement{}, err
+			}
+			element.children = append(element.children, child)
+		}
+	}
+	return element, nil
+}
+
+func (e berElement) int() int {
+	n := 0
+	for _, b := range e.value {
+		n = n<<8 | int(b)
+	}
+	return n
+}
+
+type LDAPGroupResolverOptions struct {
+	// ldap:// or ldaps:// URL of the directory server
+	URL          string
+	BindDN       string
+	BindPassword string
+	// Base DN and attribute used to find the user's entry, e.g. sAMAccountName for Active Directory
+	UserBaseDN    string
+	UserAttribute string
+	// Attribute of the user's entry listing group DNs
+	GroupAttribute string
+	// Group common names granting privileged status
+	PrivilegedGroups []string
+	Timeout          time.Duration
+	CacheSize        int
+	CacheTTL         time.Duration
+	TLSConfig        *tls.Config
+}
+
+// Grants privileged status to users whose LDAP entry lists one of the configured groups, for sites
+// whose source of truth for admin membership is a directory rather than Kubernetes groups
+type LDAPGroupResolver struct {
+	options          LDAPGroupResolverOptions
+	privilegedGroups stringSet
+	cache            *lru.Cache[string, cachedLDAPGroups]
+}
+
+type cachedLDAPGroups struct {
+	groups  stringSet
+	expires time.Time
+}
+
+func NewLDAPGroupResolver(options LDAPGroupResolverOptions) *LDAPGroupResolver {
+	if options.CacheSize <= 0 {
+		options.CacheSize = 1024
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = 2 * time.Second
+	}
+	if options.UserAttribute == "" {
+		options.UserAttribute = "uid"
+	}
+	if options.GroupAttribute == "" {
+		options.GroupAttribute = "memberOf"
+	}
+	return &LDAPGroupResolver{
+		options:          options,
+		privilegedGroups: toSet(options.PrivilegedGroups),
+		cache:            lru.New[string, cachedLDAPGroups](options.CacheSize),
+	}
+}
+
+func (l *LDAPGroupResolver) Name() string { return "ldap" }
+
+func (l *LDAPGroupResolver) IsPrivileged(ctx context.Context, spec *policy.SubjectAccessReviewSpec) (bool, error) {
+	if spec.User == "" {
+		return false, nil
+	}
+	groups, err := l.groupsFor(ctx, spec.User)
+	if err != nil {
+		return false, err
+	}
+	for group := range groups {
+		if l.privilegedGroups.Has(group) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Returns common names of the groups listed on the user's entry, cached for the configured TTL
+func (l *LDAPGroupResolver) groupsFor(ctx context.Context, user string) (stringSet, error) {
+	if cached, ok := l.cache.Get(user); ok && time.Now().Before(cached.expires) {
+		return cached.groups, nil
+	}
+	groupDNs, err := l.search(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	groups := stringSet{}
+	for _, dn := range groupDNs {
+		if cn := commonNameFromSubject(dn); cn != "" {
+			groups[cn] = struct{}{}
+		}
+	}
+	l.cache.Add(user, cachedLDAPGroups{groups: groups, expires: time.Now().Add(l.options.CacheTTL)})
+	return groups, nil
+}
+
+func (l *LDAPGroupResolver) dial(ctx context.Context) (net.Conn, error) {
+	u, err := url.Parse(l.options.URL)
+	if err != nil {
+		return nil, err
+	}
+	dialer := &net.Dialer{Timeout: l.options.Timeout}
+	switch u.Scheme {
+	case "ldap":
+		host := u.Host
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "389")
+		}
+		return dialer.DialContext(ctx, "tcp", host)
+	case "ldaps":
+		host := u.Host
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "636")
+		}
+		tlsConfig := l.options.TLSConfig
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		}
+		return (&tls.Dialer{NetDialer: dialer, Config: tlsConfig}).DialContext(ctx, "tcp", host)
+	}
+	return nil, fmt.Errorf("unsupported LDAP URL scheme %q", u.Scheme)
+}
+
+// Binds and returns the values of the group attribute on the user's entry. Users without an entry have no groups
+func (l *LDAPGroupResolver) search(ctx context.Context, user string) ([]string, error) {
+	conn, err := l.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	deadline := time.Now().Add(l.options.Timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	conn.SetDeadline(deadline)
+	reader := bufio.NewReader(conn)
+
+	bind := berConstructed(ldapBindRequest, berInt(berTagInteger, 3), berString(l.options.BindDN),
+		berEncode(ldapSimpleAuth, []byte(l.options.BindPassword)))
+	if _, err := conn.Write(berConstructed(berTagSequence, berInt(berTagInteger, 1), bind)); err != nil {
+		return nil, err
+	}
+	response, err := readLDAPResponse(reader, ldapBindResponse)
+	if err != nil {
+		return nil, fmt.Errorf("LDAP bind: %w", err)
+	}
+	if err := ldapResultError(response); err != nil {
+		return nil, fmt.Errorf("LDAP bind: %w", err)
+	}
+
+	search := berConstructed(ldapSearchRequest,
+		berString(l.options.UserBaseDN),
+		berInt(berTagEnumerated, 2), // wholeSubtree
+		berInt(berTagEnumerated, 0), // neverDerefAliases
+		berInt(berTagInteger, 2),    // sizeLimit, more than one match is ambiguous
+		berInt(berTagInteger, int(l.options.Timeout.Seconds())+1),
+		berEncode(berTagBoolean, []byte{0}),
+		berConstructed(ldapEqualityFilter, berString(l.options.UserAttribute), berString(user)),
+		berConstructed(berTagSequence, berString(l.options.GroupAttribute)),
+	)
+	if _, err := conn.Write(berConstructed(berTagSequence, berInt(berTagInteger, 2), search)); err != nil {
+		return nil, err
+	}
+
+	var groups []string
+	entries := 0
+	for {
+		op, err := readLDAPResponse(reader, 0)
+		if err != nil {
+			return nil, fmt.Errorf("LDAP search: %w", err)
+		}
+		switch op.tag {
+		case ldapSearchResultEntry:
+			entries++
+			if entries > 1 {
+				return nil, fmt.Errorf("LDAP search: multiple entries match %s=%s", l.options.UserAttribute, user)
+			}
+			if len(op.children) < 2 {
+				continue
+			}
+			for _, attribute := range op.children[1].children {
+				if len(attribute.children) == 2 && strings.EqualFold(string(attribute.children[0].value), l.options.GroupAttribute) {
+					for _, value := range attribute.children[1].children {
+						groups = append(groups, string(value.value))
+					}
+				}
+			}
+		case ldapSearchResultDone:
+			conn.Write(berConstructed(berTagSequence, berInt(berTagInteger, 3), berEncode(ldapUnbindRequest, nil)))
+			if err := ldapResultError(op); err != nil {
+				return nil, fmt.Errorf("LDAP search: %w", err)
+			}
+			return groups, nil
+		}
+	}
+}
+
+// Reads an LDAPMessage and returns its protocol operation, checking its tag if expected is non-zero
+func readLDAPResponse(reader *bufio.Reader, expected byte) (berElement, error) {
+	message, err := berRead(reader)
+	if err != nil {
+		return berElement{}, err
+	}
+	if message.tag != berTagSequence || len(message.children) < 2 {
+		return berElement{}, fmt.Errorf("malformed LDAP message")
+	}
+	op := message.children[1]
+	if expected != 0 && op.tag != expected {
+		return berElement{}, fmt.Errorf("unexpected LDAP operation 0x%x", op.tag)
+	}
+	return op, nil
+}
+
+// Returns error for an unsuccessful LDAPResult
+func ldapResultError(result berElement) error {
+	if len(result.children) < 3 {
+		return fmt.Errorf("malformed LDAP result")
+	}
+	if code := result.children[0].int(); code != 0 {
+		return fmt.Errorf("result code %d: %s", code, result.children[2].value)
+	}
+	return nil
+}
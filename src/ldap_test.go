@@ -0,0 +1,103 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/policy"
+	"bufio"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func ldapResult(tag byte, code int) []byte {
+	return berConstructed(tag, berInt(berTagEnumerated, code), berString(""), berString(""))
+}
+
+// Serves simple bind and search requests, returning memberOf values for users in groups
+func newTestLDAPServer(t *testing.T, groups map[string][]string, searches *atomic.Int32) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				for {
+					message, err := berRead(reader)
+					if err != nil {
+						return
+					}
+					id := berInt(berTagInteger, message.children[0].int())
+					op := message.children[1]
+					switch op.tag {
+					case ldapBindRequest:
+						code := 0
+						if string(op.children[2].value) != "secret" {
+							code = 49 // invalidCredentials
+						}
+						conn.Write(berConstructed(berTagSequence, id, ldapResult(ldapBindResponse, code)))
+					case ldapSearchRequest:
+						searches.Add(1)
+						user := string(op.children[6].children[1].value)
+						if memberOf, ok := groups[user]; ok {
+							var values [][]byte
+							for _, group := range memberOf {
+								values = append(values, berString(group))
+							}
+							attribute := berConstructed(berTagSequence, berString("memberOf"), berConstructed(berTagSet, values...))
+							entry := berConstructed(ldapSearchResultEntry, berString("uid="+user+",ou=people,dc=example,dc=com"),
+								berConstructed(berTagSequence, attribute))
+							conn.Write(berConstructed(berTagSequence, id, entry))
+						}
+						conn.Write(berConstructed(berTagSequence, id, ldapResult(ldapSearchResultDone, 0)))
+					default:
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return "ldap://" + listener.Addr().String()
+}
+
+func TestLDAPGroupsGrantPrivilegedStatus(t *testing.T) {
+	var searches atomic.Int32
+	url := newTestLDAPServer(t, map[string][]string{
+		"alice": {"cn=k8s-admins,ou=groups,dc=example,dc=com", "cn=staff,ou=groups,dc=example,dc=com"},
+		"bob":   {"cn=staff,ou=groups,dc=example,dc=com"},
+	}, &searches)
+	resolver := NewLDAPGroupResolver(LDAPGroupResolverOptions{
+		URL:              url,
+		BindDN:           "cn=webhook,dc=example,dc=com",
+		BindPassword:     "secret",
+		UserBaseDN:       "ou=people,dc=example,dc=com",
+		PrivilegedGroups: []string{"k8s-admins"},
+		CacheTTL:         time.Minute,
+	})
+	authorizer := CreateWebhookAuthorizer(WebhookConfig{Config: DefaultPolicyConfig, Privileges: PrivilegeResolvers{resolver}})
+
+	accessTest(t, authorizer, false, keystoneSAR("alice", ""))
+	accessTest(t, authorizer, false, keystoneSAR("alice", ""))
+	accessTest(t, authorizer, true, keystoneSAR("bob", ""))
+	accessTest(t, authorizer, true, keystoneSAR("unknown", ""))
+	if searches.Load() != 3 {
+		t.Errorf("Expected one cached search per user, got %d searches", searches.Load())
+	}
+}
+
+func TestLDAPBindFailureWithholdsPrivileges(t *testing.T) {
+	var searches atomic.Int32
+	url := newTestLDAPServer(t, map[string][]string{"alice": {"cn=k8s-admins,dc=example,dc=com"}}, &searches)
+	resolver := NewLDAPGroupResolver(LDAPGroupResolverOptions{URL: url, BindPassword: "wrong", PrivilegedGroups: []string{"k8s-admins"}})
+
+	if privileged, err := resolver.IsPrivileged(t.Context(), &policy.SubjectAccessReviewSpec{User: "alice"}); privileged || err == nil {
+		t.Errorf("Expected bind failure to be reported, got %v, %v", privileged, err)
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+)
+
+// Format of Kubernetes MicroTime fields
+const leaseTimeFormat = "2006-01-02T15:04:05.000000Z07:00"
+
+var leaderTransitions = Metrics.NewCounterVec("azimuth_authz_leader_transitions_total",
+	"Times this replica became or stopped being the leader", "transition")
+
+type LeaderElectionOptions struct {
+	// Lease on the management cluster replicas compete for
+	Namespace string
+	Name      string
+	// Identifies this replica as the holder of the lease, e.g. the pod name
+	Identity string
+	// Time after its last renewal for which a lease is held
+	LeaseDuration time.Duration
+	// Called each time this replica becomes the leader
+	OnStartedLeading func()
+}
+
+// Elects one of several replicas as the leader using a coordination.k8s.io Lease, so that only one of
+// them writes to the management cluster while all of them serve. Other holders' leases are judged
+// expired by when this replica saw them change, so clocks needn't agree
+type LeaderElector struct {
+	conn    *ClusterConnection
+	client  *OutboundClient
+	options LeaderElectionOptions
+	now     func() time.Time
+
+	leader atomic.Bool
+	// Lease as last written by this replica, used to release it
+	lease *kubeLease
+	// Holder's lease spec as last seen and when it was seen
+	observed   leaseSpec
+	observedAt time.Time
+	renewedAt  time.Time
+}
+
+type kubeLease struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Metadata   struct {
+		Namespace       string `json:"namespace"`
+		Name            string `json:"name"`
+		ResourceVersion string `json:"resourceVersion,omitempty"`
+	} `json:"metadata"`
+	Spec leaseSpec `json:"spec"`
+}
+
+type leaseSpec struct {
+	HolderIdentity       string `json:"holderIdentity,omitempty"`
+	LeaseDurationSeconds int    `json:"leaseDurationSeconds,omitempty"`
+	AcquireTime          string `json:"acquireTime,omitempty"`
+	RenewTime            string `json:"renewTime,omitempty"`
+	LeaseTransitions     int    `json:"leaseTransitions,omitempty"`
+}
+
+func NewLeaderElector(conn *ClusterConnection, client *OutboundClient, options LeaderElectionOptions) (*LeaderElector, error) {
+	if options.Namespace == "" || options.Name == "" {
+		return nil, errors.New("lease namespace and name are required")
+	}
+	if options.Identity == "" {
+		return nil, errors.New("leader election identity is required")
+	}
+	if options.LeaseDuration < time.Second {
+		return nil, fmt.Errorf("lease duration %s is shorter than 1s", options.LeaseDuration)
+	}
+	e := &LeaderElector{conn: conn, client: client.WithTLSConfig(conn.TLSConfig), options: options, now: time.Now}
+	Metrics.NewGaugeFunc("azimuth_authz_leader", "1 if this replica is the leader, 0 otherwise", func() float64 {
+		if e.IsLeader() {
+			return 1
+		}
+		return 0
+	})
+	return e, nil
+}
+
+// Reports if this replica currently holds the lease
+func (e *LeaderElector) IsLeader() bool {
+	return e.leader.Load()
+}
+
+// Acquires or renews the lease every fifth of its duration until ctx is cancelled, then releases it
+// so another replica can take over without waiting for it to expire
+func (e *LeaderElector) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.options.LeaseDuration / 5)
+	defer ticker.Stop()
+	for {
+		leader, err := e.tryAcquireOrRenew(ctx)
+		switch {
+		case ctx.Err() != nil:
+		case err != nil:
+			log.Printf("Error renewing lease %s/%s: %s\n", e.options.Namespace, e.options.Name, err)
+			// Step down before other replicas can consider the lease expired
+			if e.now().Sub(e.renewedAt) > e.options.LeaseDuration*2/3 {
+				e.setLeader(false)
+			}
+		default:
+			e.setLeader(leader)
+		}
+		select {
+		case <-ctx.Done():
+			e.release()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (e *LeaderElector) setLeader(leader bool) {
+	if e.leader.Swap(leader) == leader {
+		return
+	}
+	if leader {
+		leaderTransitions.Inc("started")
+		log.Printf("Became leader with lease %s/%s\n", e.options.Namespace, e.options.Name)
+		if e.options.OnStartedLeading != nil {
+			e.options.OnStartedLeading()
+		}
+	} else {
+		leaderTransitions.Inc("stopped")
+		log.Printf("Stopped being leader with lease %s/%s\n", e.options.Namespace, e.options.Name)
+	}
+}
+
+// Returns true if this replica holds the lease after creating, taking over or renewing it. Conflicting
+// writes by other replicas are reported as not holding it rather than as errors
+func (e *LeaderElector) tryAcquireOrRenew(ctx context.Context) (bool, error) {
+	now := e.now()
+	lease, err := e.get(ctx)
+	if err != nil {
+		return false, err
+	}
+	if lease == nil {
+		lease = &kubeLease{APIVersion: "coordination.k8s.io/v1", Kind: "Lease"}
+		lease.Metadata.Namespace = e.options.Namespace
+		lease.Metadata.Name = e.options.Name
+		lease.Spec = leaseSpec{AcquireTime: now.Format(leaseTimeFormat)}
+		return e.write(ctx, http.MethodPost, e.collectionPath(), lease, now)
+	}
+
+	if lease.Spec != e.observed {
+		e.observed = lease.Spec
+		e.observedAt = now
+	}
+	holder := lease.Spec.HolderIdentity
+	if holder != "" && holder != e.options.Identity {
+		duration := e.options.LeaseDuration
+		if lease.Spec.LeaseDurationSeconds > 0 {
+			duration = time.Duration(lease.Spec.LeaseDurationSeconds) * time.Second
+		}
+		if now.Before(e.observedAt.Add(duration)) {
+			return false, nil
+		}
+	}
+	if holder != e.options.Identity {
+		lease.Spec.AcquireTime = now.Format(leaseTimeFormat)
+		if holder != "" {
+			lease.Spec.LeaseTransitions++
+		}
+	}
+	return e.write(ctx, http.MethodPut, e.collectionPath()+"/"+url.PathEscape(e.options.Name), lease, now)
+}
+
+// Returns the lease, or nil if it doesn't exist yet
+func (e *LeaderElector) get(ctx context.Context) (*kubeLease, error) {
+	resp, err := kubeSend(ctx, e.conn, e.client, "leader-election", http.MethodGet, e.collectionPath()+"/"+url.PathEscape(e.options.Name), "", nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unexpected status reading lease: %s", resp.Status)
+	}
+	var lease kubeLease
+	if err := json.NewDecoder(resp.Body).Decode(&lease); err != nil {
+		return nil, fmt.Errorf("decoding lease: %w", err)
+	}
+	return &lease, nil
+}
+
+// Writes lease held by this replica, renewed at now
+func (e *LeaderElector) write(ctx context.Context, method string, path string, lease *kubeLease, now time.Time) (bool, error) {
+	lease.Spec.HolderIdentity = e.options.Identity
+	lease.Spec.LeaseDurationSeconds = int(e.options.LeaseDuration / time.Second)
+	lease.Spec.RenewTime = now.Format(leaseTimeFormat)
+	body, err := json.Marshal(lease)
+	if err != nil {
+		return false, err
+	}
+	resp, err := kubeSend(ctx, e.conn, e.client, "leader-election", method, path, "application/json", body, 0)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated:
+	case http.StatusConflict:
+		return false, nil
+	default:
+		return false, fmt.Errorf("unexpected status writing lease: %s", resp.Status)
+	}
+	var written kubeLease
+	if err := json.NewDecoder(resp.Body).Decode(&written); err != nil {
+		return false, fmt.Errorf("decoding lease: %w", err)
+	}
+	e.lease = &written
+	e.observed = written.Spec
+	e.observedAt = now
+	e.renewedAt = now
+	return true, nil
+}
+
+// Gives up the lease if this replica holds it
+func (e *LeaderElector) release() {
+	if !e.IsLeader() || e.lease == nil {
+		return
+	}
+	e.setLeader(false)
+	lease := *e.lease
+	lease.Spec = leaseSpec{LeaseTransitions: lease.Spec.LeaseTransitions}
+	body, err := json.Marshal(lease)
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), e.options.LeaseDuration/5)
+	defer cancel()
+	resp, err := kubeSend(ctx, e.conn, e.client, "leader-election", http.MethodPut, e.collectionPath()+"/"+url.PathEscape(e.options.Name), "application/json", body, 0)
+	if err != nil {
+		log.Printf("Error releasing lease %s/%s: %s\n", e.options.Namespace, e.options.Name, err)
+		return
+	}
+	resp.Body.Close()
+}
+
+func (e *LeaderElector) collectionPath() string {
+	return "/apis/coordination.k8s.io/v1/namespaces/" + url.PathEscape(e.options.Namespace) + "/leases"
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testLeasePath = "/apis/coordination.k8s.io/v1/namespaces/azimuth/leases/webhook"
+
+// Serves a single lease, rejecting writes based on a stale resourceVersion like the apiserver
+func newTestLeaseServer(t *testing.T) (*httptest.Server, func() *kubeLease) {
+	var mu sync.Mutex
+	var stored *kubeLease
+	version := 0
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		var lease kubeLease
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == testLeasePath:
+			if stored == nil {
+				http.NotFound(w, r)
+				return
+			}
+			json.NewEncoder(w).Encode(stored)
+			return
+		case r.Method == http.MethodPost && r.URL.Path == "/apis/coordination.k8s.io/v1/namespaces/azimuth/leases":
+			if stored != nil {
+				w.WriteHeader(http.StatusConflict)
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+		case r.Method == http.MethodPut && r.URL.Path == testLeasePath:
+			json.NewDecoder(r.Body).Decode(&lease)
+			if stored == nil || lease.Metadata.ResourceVersion != stored.Metadata.ResourceVersion {
+				w.WriteHeader(http.StatusConflict)
+				return
+			}
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method == http.MethodPost {
+			json.NewDecoder(r.Body).Decode(&lease)
+		}
+		version++
+		lease.Metadata.ResourceVersion = strconv.Itoa(version)
+		stored = &lease
+		json.NewEncoder(w).Encode(stored)
+	}))
+	t.Cleanup(server.Close)
+	return server, func() *kubeLease {
+		mu.Lock()
+		defer mu.Unlock()
+		return stored
+	}
+}
+
+func newTestElector(t *testing.T, server *httptest.Server, identity string, now *time.Time) *LeaderElector {
+	conn, err := LoadKubeconfig(writeKubeconfig(t, server, "    token: x\n"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	elector, err := NewLeaderElector(conn, NewOutboundClient(DefaultOutboundClientOptions), LeaderElectionOptions{
+		Namespace:     "azimuth",
+		Name:          "webhook",
+		Identity:      identity,
+		LeaseDuration: 15 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	elector.now = func() time.Time { return *now }
+	return elector
+}
+
+func TestLeaderElection(t *testing.T) {
+	server, stored := newTestLeaseServer(t)
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	first := newTestElector(t, server, "webhook-0", &now)
+	second := newTestElector(t, server, "webhook-1", &now)
+
+	if leader, err := first.tryAcquireOrRenew(t.Context()); err != nil || !leader {
+		t.Fatalf("Expected first replica to create the lease, got %v, %v", leader, err)
+	}
+	if leader, err := second.tryAcquireOrRenew(t.Context()); err != nil || leader {
+		t.Fatalf("Expected second replica to follow while the lease is held, got %v, %v", leader, err)
+	}
+	now = now.Add(10 * time.Second)
+	if leader, err := first.tryAcquireOrRenew(t.Context()); err != nil || !leader {
+		t.Fatalf("Expected first replica to renew the lease, got %v, %v", leader, err)
+	}
+	// The renewal restarts the lease duration as seen by the second replica
+	now = now.Add(10 * time.Second)
+	if leader, _ := second.tryAcquireOrRenew(t.Context()); leader {
+		t.Fatal("Expected second replica to follow while the lease is renewed")
+	}
+
+	// The first replica stops renewing, so the second takes over once the lease expires
+	now = now.Add(16 * time.Second)
+	if leader, err := second.tryAcquireOrRenew(t.Context()); err != nil || !leader {
+		t.Fatalf("Expected second replica to take over the expired lease, got %v, %v", leader, err)
+	}
+	if lease := stored(); lease.Spec.HolderIdentity != "webhook-1" || lease.Spec.LeaseTransitions != 1 {
+		t.Errorf("Expected lease held by webhook-1 after one transition, got %+v", lease.Spec)
+	}
+	// Writes based on the lease the first replica last saw conflict, so it doesn't become leader again
+	if leader, err := first.tryAcquireOrRenew(t.Context()); err != nil || leader {
+		t.Errorf("Expected first replica to follow after the takeover, got %v, %v", leader, err)
+	}
+}
+
+func TestLeaderElectionReleasesLease(t *testing.T) {
+	server, stored := newTestLeaseServer(t)
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	first := newTestElector(t, server, "webhook-0", &now)
+	second := newTestElector(t, server, "webhook-1", &now)
+	started := 0
+	first.options.OnStartedLeading = func() { started++ }
+
+	leader, err := first.tryAcquireOrRenew(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	first.setLeader(leader)
+	if !first.IsLeader() || started != 1 {
+		t.Fatalf("Expected first replica to be leader, got %v after %d starts", first.IsLeader(), started)
+	}
+	first.release()
+	if first.IsLeader() || stored().Spec.HolderIdentity != "" {
+		t.Fatalf("Expected released lease, got %+v", stored().Spec)
+	}
+	// Without waiting for the lease duration
+	if leader, err := second.tryAcquireOrRenew(t.Context()); err != nil || !leader {
+		t.Errorf("Expected second replica to take over the released lease, got %v, %v", leader, err)
+	}
+}
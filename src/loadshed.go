@@ -0,0 +1,134 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/server"
+	"encoding/json"
+	"errors"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"math"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Response sent for requests rejected by the load shedder
+type LoadShedMode string
+
+const (
+	// Valid SubjectAccessReview response with neither allowed nor denied set, so other authorizers decide
+	LoadShedNoOpinion LoadShedMode = "no-opinion"
+	// HTTP 503, leaving the outcome to the apiserver's webhook failure handling
+	LoadShedUnavailable LoadShedMode = "unavailable"
+)
+
+var requestDuration = Metrics.NewHistogramVec("azimuth_authz_request_duration_seconds",
+	"Time taken to handle authorization requests, excluding shed requests", DefaultLatencyBuckets)
+var requestsShed = Metrics.NewCounterVec("azimuth_authz_requests_shed_total",
+	"Authorization requests rejected by adaptive load shedding", "mode")
+
+type LoadShedderOptions struct {
+	// Handler latency above which the concurrency limit is reduced. Shedding is disabled if 0.
+	// Should be comfortably below the apiserver's webhook timeout
+	TargetLatency time.Duration
+	Mode          LoadShedMode
+	MinLimit      int
+	MaxLimit      int
+}
+
+// Adaptive concurrency limiter using additive increase/multiplicative decrease on observed handler
+// latency. While requests complete within the target latency the limit grows slowly; when they
+// don't it shrinks quickly, and requests over the limit get a fast response instead of queueing.
+// A slow webhook is worse for cluster health than one that declines to give an opinion
+type LoadShedder struct {
+	options  LoadShedderOptions
+	inflight atomic.Int64
+	mu       sync.Mutex
+	limit    float64
+}
+
+func NewLoadShedder(options LoadShedderOptions) *LoadShedder {
+	if options.MinLimit < 1 {
+		options.MinLimit = 1
+	}
+	if options.MaxLimit < options.MinLimit {
+		options.MaxLimit = options.MinLimit
+	}
+	if options.Mode == "" {
+		options.Mode = LoadShedNoOpinion
+	}
+	s := &LoadShedder{options: options, limit: float64(options.MaxLimit)}
+	Metrics.NewGaugeFunc("azimuth_authz_concurrency_limit", "Current adaptive concurrency limit for authorization requests",
+		func() float64 { return s.Limit() })
+	Metrics.NewGaugeFunc("azimuth_authz_requests_inflight", "Authorization requests currently being handled",
+		func() float64 { return float64(s.inflight.Load()) })
+	return s
+}
+
+func (s *LoadShedder) Limit() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.limit
+}
+
+// Middleware measuring handler latency and, if enabled, shedding load
+func (s *LoadShedder) Wrap(next http.Handler) http.Handler {
+	return s.wrap(next, s.options.Mode)
+}
+
+// Middleware shedding load as Wrap does, but answering shed requests as unavailable whatever the mode, for
+// endpoints whose responses aren't SubjectAccessReviews such as /authorize/batch
+func (s *LoadShedder) WrapUnavailable(next http.Handler) http.Handler {
+	return s.wrap(next, LoadShedUnavailable)
+}
+
+func (s *LoadShedder) wrap(next http.Handler, mode LoadShedMode) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inflight := s.inflight.Add(1)
+		defer s.inflight.Add(-1)
+		if s.options.TargetLatency > 0 && float64(inflight) > math.Floor(s.Limit()) {
+			s.shed(w, r, mode)
+			return
+		}
+
+		start := time.Now()
+		next.ServeHTTP(w, r)
+		elapsed := time.Since(start)
+		requestDuration.Observe(elapsed.Seconds())
+		if s.options.TargetLatency > 0 {
+			s.observe(elapsed)
+		}
+	})
+}
+
+func (s *LoadShedder) observe(elapsed time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if elapsed > s.options.TargetLatency {
+		s.limit = math.Max(float64(s.options.MinLimit), s.limit*0.9)
+	} else {
+		s.limit = math.Min(float64(s.options.MaxLimit), s.limit+1/s.limit)
+	}
+}
+
+func (s *LoadShedder) shed(w http.ResponseWriter, r *http.Request, mode LoadShedMode) {
+	requestsShed.Inc(string(mode))
+	if mode == LoadShedUnavailable {
+		w.Header().Set("Retry-After", "1")
+		server.WriteError(w, http.StatusServiceUnavailable, errors.New("Webhook overloaded"))
+		return
+	}
+	// Only the request's type and UID are decoded, enough to answer it without evaluating it
+	var request struct {
+		metav1.TypeMeta
+		Metadata struct {
+			UID types.UID `json:"uid"`
+		} `json:"metadata"`
+	}
+	json.NewDecoder(r.Body).Decode(&request)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(server.NewResponse(request.TypeMeta, request.Metadata.UID,
+		authorizationv1.SubjectAccessReviewStatus{Reason: "Webhook overloaded, delegated to other authorizers"}))
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/server"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLoadShedderReducesLimitOnSlowRequests(t *testing.T) {
+	shedder := NewLoadShedder(LoadShedderOptions{TargetLatency: time.Millisecond, MinLimit: 2, MaxLimit: 10})
+	slow := shedder.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { time.Sleep(5 * time.Millisecond) }))
+	for i := 0; i < 50; i++ {
+		slow.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/authorize", nil))
+	}
+	if limit := shedder.Limit(); limit != 2 {
+		t.Errorf("Expected limit to fall to minimum of 2, got %g", limit)
+	}
+
+	fast := shedder.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for i := 0; i < 50; i++ {
+		fast.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/authorize", nil))
+	}
+	if limit := shedder.Limit(); limit <= 2 {
+		t.Errorf("Expected limit to recover once requests are fast, got %g", limit)
+	}
+}
+
+func TestLoadShedderModes(t *testing.T) {
+	for _, mode := range []LoadShedMode{LoadShedNoOpinion, LoadShedUnavailable} {
+		shedder := NewLoadShedder(LoadShedderOptions{TargetLatency: time.Second, Mode: mode, MinLimit: 1, MaxLimit: 1})
+		release := make(chan struct{})
+		started := make(chan struct{})
+		handler := shedder.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(started)
+			<-release
+		}))
+
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/authorize", nil))
+		}()
+		<-started
+
+		before := requestsShed.Value(string(mode))
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/authorize", nil))
+		close(release)
+		wg.Wait()
+
+		if requestsShed.Value(string(mode)) != before+1 {
+			t.Errorf("Expected shed request to be counted for mode %s", mode)
+		}
+		switch mode {
+		case LoadShedUnavailable:
+			if resp.Code != http.StatusServiceUnavailable {
+				t.Errorf("Expected 503 when shedding, got %d", resp.Code)
+			}
+		case LoadShedNoOpinion:
+			var sarResponse server.SubjectAccessReviewResponse
+			_ = json.NewDecoder(resp.Body).Decode(&sarResponse)
+			if resp.Code != http.StatusOK || sarResponse.Status.Allowed || sarResponse.Status.Denied {
+				t.Error("Expected no opinion response when shedding")
+			}
+		}
+	}
+}
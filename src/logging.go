@@ -0,0 +1,89 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/policy"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"strconv"
+	"strings"
+)
+
+// Middleware logging HTTP dumps of requests once they're handled. Dumping reads and buffers the whole
+// body, so should only be used when the dump will be logged
+func dumpRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		dump, err := httputil.DumpRequest(r, true)
+		if err != nil {
+			log.Println("Error dumping request:", err)
+			return
+		}
+		next.ServeHTTP(w, r)
+		log.Printf("HTTP Dump: \n%s\n", dump)
+	})
+}
+
+// Structured fields describing a decision. Only rendered to a string when the record is actually
+// logged, so requests handled with logging disabled don't pay for building log lines
+type decisionLogRecord struct {
+	cluster  string
+	identity *ClusterIdentity
+	spec     *policy.SubjectAccessReviewSpec
+	status   *authorizationv1.SubjectAccessReviewStatus
+	// Generation of the policy the decision was made with, omitted if zero
+	generation uint64
+	// UID of the SubjectAccessReview, omitted if empty
+	uid types.UID
+	// Set for simulations, answered with no opinion whatever the decision
+	simulated bool
+}
+
+func (r decisionLogRecord) String() string {
+	var sb strings.Builder
+	sb.Grow(128)
+	sb.WriteString("[Cluster: ")
+	sb.WriteString(r.cluster)
+	if r.identity != nil {
+		for _, key := range sortedKeys(r.identity.Labels) {
+			sb.WriteString(" ")
+			sb.WriteString(key)
+			sb.WriteString("=")
+			sb.WriteString(r.identity.Labels[key])
+		}
+	}
+	sb.WriteString("] ")
+	if r.status.Denied {
+		sb.WriteString("Denied")
+	} else {
+		sb.WriteString("Allowed")
+	}
+	if r.spec.ResourceAttributes != nil {
+		sb.WriteString(" request from ")
+		sb.WriteString(policy.DescribeSubject(*r.spec))
+		sb.WriteString(" to ")
+		sb.WriteString(r.spec.ResourceAttributes.Verb)
+		sb.WriteString(" ")
+		sb.WriteString(r.spec.ResourceAttributes.Resource)
+		sb.WriteString(" in namespace ")
+		sb.WriteString(r.spec.ResourceAttributes.Namespace)
+	} else {
+		sb.WriteString(" non-resource request from ")
+		sb.WriteString(policy.DescribeSubject(*r.spec))
+	}
+	sb.WriteString(". Reason: ")
+	sb.WriteString(r.status.Reason)
+	if r.uid != "" {
+		sb.WriteString(". Request UID: ")
+		sb.WriteString(string(r.uid))
+	}
+	if r.generation != 0 {
+		sb.WriteString(". Policy generation: ")
+		sb.WriteString(strconv.FormatUint(r.generation, 10))
+	}
+	if r.simulated {
+		sb.WriteString(". Simulated, answered with no opinion")
+	}
+	return sb.String()
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/policy"
+	"bytes"
+	"io"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestDecisionLogRecordFormat(t *testing.T) {
+	status := authorizationv1.SubjectAccessReviewStatus{Denied: true, Reason: "Cannot write to protected namespace"}
+	spec := policy.SubjectAccessReviewSpec{
+		User:               "not-admin",
+		ResourceAttributes: &authorizationv1.ResourceAttributes{Verb: "delete", Resource: "pods", Namespace: "kube-system"},
+	}
+	expected := "[Cluster: 10.0.0.1] Denied request from not-admin to delete pods in namespace kube-system. Reason: Cannot write to protected namespace"
+	if actual := (decisionLogRecord{cluster: "10.0.0.1", spec: &spec, status: &status}).String(); actual != expected {
+		t.Errorf("Unexpected log line %q", actual)
+	}
+
+	status = authorizationv1.SubjectAccessReviewStatus{Reason: "Webhook doesn't give opinion, delegated to other authorizers"}
+	spec = policy.SubjectAccessReviewSpec{User: "not-admin", NonResourceAttributes: &authorizationv1.NonResourceAttributes{Path: "/healthz", Verb: "get"}}
+	expected = "[Cluster: ] Allowed non-resource request from not-admin. Reason: Webhook doesn't give opinion, delegated to other authorizers"
+	if actual := (decisionLogRecord{spec: &spec, status: &status}).String(); actual != expected {
+		t.Errorf("Unexpected log line %q", actual)
+	}
+	expected += ". Request UID: 1234. Policy generation: 3"
+	if actual := (decisionLogRecord{spec: &spec, status: &status, generation: 3, uid: "1234"}).String(); actual != expected {
+		t.Errorf("Unexpected log line %q", actual)
+	}
+}
+
+func BenchmarkAuthorizeNoLogging(b *testing.B) {
+	benchmarkAuthorize(b, 0)
+}
+
+func BenchmarkAuthorizeDecisionLogging(b *testing.B) {
+	benchmarkAuthorize(b, 1)
+}
+
+func benchmarkAuthorize(b *testing.B, logLevel int) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	authorizer := CreateWebhookAuthorizer(WebhookConfig{Config: DefaultPolicyConfig, LogLevel: logLevel})
+	body := []byte(`{"kind":"SubjectAccessReview","apiVersion":"authorization.k8s.io/v1","spec":{"resourceAttributes":{"namespace":"kube-system","verb":"get","resource":"pods"},"user":"not-admin"}}`)
+
+	b.ReportAllocs()
+	for b.Loop() {
+		req := httptest.NewRequest(http.MethodPost, "/authorize", bytes.NewReader(body))
+		authorizer(httptest.NewRecorder(), req)
+	}
+}
@@ -1,202 +1,1605 @@
 package main
 
 import (
-	"encoding/json"
+	"azimuth-cloud/azimuth-authorizaton-webhook/internal/exitcode"
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/config"
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/policy"
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/server"
+	"context"
+	"errors"
 	"flag"
+	"fmt"
 	authorizationv1 "k8s.io/api/authorization/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"log"
 	"net/http"
-	"net/http/httputil"
+	"net/http/pprof"
+	"net/url"
 	"os"
+	"os/signal"
 	"regexp"
-	"slices"
+	"runtime"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 )
 
-// Creating mirror of authorizationv1.SubjectAccessReview struct but with modified Spec
-// to account for disparity in name of group key between Go ('Groups') and HTTP ('Group') API
-// causing issues with JSON unmarshalling
-// Should not be written as HTTP response
-type SubjectAccessReviewAPI struct {
-	metav1.TypeMeta
-	metav1.ObjectMeta
-
-	Spec SubjectAccessReviewSpecAPI
-
-	Status authorizationv1.SubjectAccessReviewStatus
-}
-type SubjectAccessReviewSpecAPI struct {
-	ResourceAttributes    *authorizationv1.ResourceAttributes
-	NonResourceAttributes *authorizationv1.NonResourceAttributes
-	User                  string
-	Group                 []string
-	Groups                []string
-	Extra                 map[string]authorizationv1.ExtraValue
-	UID                   string
-}
-
-// Minimal SubjectAccessReview HTTP response
-type SubjectAccessReviewHTTPResponse struct {
-	ApiVersion string                                    `json:"apiVersion"`
-	Kind       string                                    `json:"kind"`
-	Status     authorizationv1.SubjectAccessReviewStatus `json:"status"`
-}
-
-var readonlyVerbs = []string{"get", "list", "watch", "proxy"}
-
-// Returns true if user is a service account with correct privileges or a privileged internal K8s system user
-func isPrivilegedSystemUser(user string, protectedNamespaces []string) bool {
-
-	requiredUsers := []string{"system:kube-controller-manager", "system:kube-scheduler", "kubernetes-admin", "kube-apiserver-kubelet-client"}
-	serviceAccountRegex, _ := regexp.Compile("system:serviceaccount:.+")
-	nodeAccountRegex, _ := regexp.Compile("system:node:.+")
-	bootstrapAccountRegex, _ := regexp.Compile("system:bootstrap:.+")
-
-	if slices.Contains(requiredUsers, user) {
-		return true
-	} else if serviceAccountRegex.MatchString(user) {
-		// Allows service accounts if they originate from protected namespaces
-		serviceAccountNamespace := strings.Split(user, ":")[2]
-		return slices.Contains(protectedNamespaces, serviceAccountNamespace)
-	} else if nodeAccountRegex.MatchString(user) || bootstrapAccountRegex.MatchString(user) {
-		// All node and bootstrap accounts allowed
-		return true
-	}
-
-	return false
-}
-
-// Returns true if request passes webhook's resource access checks. If false, string with reason for rejection will also be returned, otherwise nil string
-func isRequestAuthorized(sar SubjectAccessReviewAPI, protectedNamespaces []string, additionalPrivilegedUsers []string) (bool, string) {
-	isPrivilegedUser := slices.Contains(additionalPrivilegedUsers, sar.Spec.User)
-	isPrivilegedSystemUser := sar.Spec.ResourceAttributes != nil && isPrivilegedSystemUser(sar.Spec.User, protectedNamespaces)
-	isProtectedNamespace := sar.Spec.ResourceAttributes != nil && slices.Contains(protectedNamespaces, sar.Spec.ResourceAttributes.Namespace)
-	isSecret := sar.Spec.ResourceAttributes != nil && sar.Spec.ResourceAttributes.Resource == "secrets"
-	isReadonlyVerb := sar.Spec.ResourceAttributes != nil && slices.Contains(readonlyVerbs, sar.Spec.ResourceAttributes.Verb)
-	isAllNamespaceRequest := sar.Spec.ResourceAttributes != nil && sar.Spec.ResourceAttributes.Namespace == ""
-	isAllResourceRequest := sar.Spec.ResourceAttributes != nil && sar.Spec.ResourceAttributes.Resource == "*"
-
-	var denyReason string
-	authorized := false
-	if isPrivilegedUser {
-		authorized = true
-	} else if isProtectedNamespace && !isPrivilegedSystemUser && isAllResourceRequest {
-		authorized = false
-		denyReason = "Cannot make * resource requests in protected namespace"
-	} else if (isAllNamespaceRequest || isProtectedNamespace) && !isPrivilegedSystemUser && isSecret {
-		authorized = false
-		denyReason = "Cannot access secrets in protected namespace"
-	} else if isProtectedNamespace && !isPrivilegedSystemUser && !isReadonlyVerb {
-		authorized = false
-		denyReason = "Cannot write to protected namespace"
-	} else {
-		authorized = true
+// Settings for the SubjectAccessReview request handler
+type WebhookConfig struct {
+	policy.Config
+	OpinionMode bool
+	LogLevel    int
+	// Optional, raises LogLevel at runtime when signalled
+	Debug *DebugToggles
+	// Optional, decisions are not audited if nil
+	Audit *AuditPipeline
+	// Optional, decisions are not cached if nil
+	DecisionCache *DecisionCache
+	// Advertised in Cache-Control as the time decisions hinted policy.CacheLong may be cached for, omitted
+	// if zero
+	CacheLongTTL time.Duration
+	// Optional upstream authorizer consulted for requests the policy doesn't deny
+	Delegate *UpstreamDelegate
+	// External commands and endpoints consulted for the requests they match
+	Hooks Hooks
+	// Optional, callers are identified by X-Forwarded-For if nil
+	Clusters *ClusterRegistry
+	// Optional, restricts writes in tenant namespaces to the owning Azimuth tenancy
+	Tenancy *TenancyResolver
+	// Backends consulted for privileges of users the policy would otherwise deny
+	Privileges PrivilegeResolvers
+	// Optional, requests from each cluster are unlimited if nil
+	RateLimiter *ClusterRateLimiter
+	// Optional secondary webhook whose decisions are compared with, but never affect, ours
+	Mirror *MirrorWebhook
+	// Attributes set as extras before requests are evaluated
+	Enrichment Enrichment
+	// Requests failing any condition get no opinion without being evaluated
+	MatchConditions MatchConditions
+	// Optional, policy.Config is compiled once if nil
+	Policy *policy.Source
+	// Applied to Policy for the identified clusters they match
+	Overlays []policy.Overlay
+	// Optional, policies callers can select by name instead of Policy
+	NamedPolicies *NamedPolicies
+	// Optional, requests marked as simulations are answered with no opinion whatever the decision
+	Simulation *Simulation
+	// Optional, identities repeatedly denied by the same rule are flagged and their denials delayed
+	Tarpit *Tarpit
+	// Optional, sampled SubjectAccessReviews are recorded for replay if set
+	Corpus *CorpusRecorder
+	// Names of the authorizers consulted, in order. Unconfigured authorizers are skipped, and the
+	// default ones used if empty
+	Authorizers []string
+	// Decision when evaluation fails without a verdict, no opinion if empty
+	EvaluationFailurePolicy server.FailurePolicy
+	// Response to requests which can't be evaluated, rejected if empty
+	MalformedRequestPolicy server.MalformedRequestPolicy
+	// Appends the request UID and policy generation to the reasons of denials
+	DenyReasonReferences bool
+	// Appended to the reasons of denials to tell users where to get help, if set
+	DenyReasonHelp string
+	// Caps on the size of SubjectAccessReviews, unlimited if zero
+	Limits policy.Limits
+	// apiVersions of SubjectAccessReviews accepted, policy.DefaultAPIVersions if empty
+	APIVersions []string
+}
+
+// Returns the configured policy source, or one holding policy.Config
+func (config WebhookConfig) policySource() *policy.Source {
+	if config.Policy != nil {
+		return config.Policy
 	}
-	return authorized, denyReason
+	return policy.NewSource(config.Config)
 }
 
-func inputIsSanitised(sar SubjectAccessReviewAPI, httpWriter http.ResponseWriter) bool {
-	inputError := false
-	var errString string
-	if sar.APIVersion != "authorization.k8s.io/v1" {
-		errString = sar.APIVersion + " not supported. Currently support apiVersions: 'authorization.k8s.io/v1'"
-		inputError = true
+// Returns function making the decision for a SubjectAccessReview with every configured check, shared
+// by the endpoints answering authorization questions
+func newEvaluator(config WebhookConfig) server.Evaluator {
+	policies := config.policySource()
+	config.Policy = policies
+	chain := authorizerChain(config, config.Authorizers)
+	return server.WithFailurePolicy(func(ctx context.Context, sar policy.SubjectAccessReview) authorizationv1.SubjectAccessReviewStatus {
+		// Every check uses the same policy, even if policy sync replaces it meanwhile
+		compiled := policies.Snapshot(ctx)
+		ctx = policy.WithSnapshot(ctx, compiled)
+		countUnrecognizedVerb(compiled, sar.Spec)
+		sar.Spec = config.Enrichment.Enrich(ctx, sar.Spec)
+		// Decisions made with named policies or overlays aren't cached, as the cache is keyed on the request alone
+		decisionCache := config.DecisionCache
+		if namedPolicyFrom(ctx) != "" || len(overlaysFrom(ctx)) > 0 {
+			decisionCache = nil
+		}
+		var status authorizationv1.SubjectAccessReviewStatus
+		if excludedBy := config.MatchConditions.Excludes(sar); excludedBy != "" {
+			// Out of scope, so left to other authorizers without evaluation
+			status.Reason = "Excluded by match condition " + excludedBy
+		} else if cachedStatus, hint, cached := decisionCache.Get(sar.Spec); cached {
+			status = cachedStatus
+			recordCacheHint(ctx, hint)
+		} else {
+			// Resolved at most once, and only if a decision depends on it. Impersonated requests are only
+			// privileged if the impersonator is too, and never exempt from the impersonated write rule
+			ctx = withPrivilegeCheck(ctx, sync.OnceValue(func() bool {
+				privileged := func(spec *policy.SubjectAccessReviewSpec) bool {
+					return compiled.IsPrivilegedUser(spec.User) || config.Privileges.Resolve(ctx, spec)
+				}
+				if compiled.DeniesImpersonatedWrite(sar.Spec) || !privileged(&sar.Spec) {
+					return false
+				}
+				impersonator, impersonated := policy.Impersonator(sar.Spec)
+				return !impersonated || privileged(&impersonator)
+			}))
+			status = chain.Authorize(ctx, &sar.Spec).Status(config.OpinionMode)
+			hint := compiled.CacheHint(sar.Spec)
+			recordCacheHint(ctx, hint)
+			// Decisions made with a policy that's since been replaced would outlive the cache reset, and those
+			// of abandoned requests may be incomplete
+			if policies.Current() == compiled && ctx.Err() == nil {
+				decisionCache.Add(sar.Spec, status, hint)
+			}
+		}
+		return status
+	}, config.EvaluationFailurePolicy, func(any) { panics.Inc("evaluation") })
+}
+
+var panics = Metrics.NewCounterVec("azimuth_authz_panics_total",
+	"Panics recovered from while answering authorization requests, by stage", "stage")
+
+var unrecognizedVerbs = Metrics.NewCounterVec("azimuth_authz_unrecognized_verbs_total",
+	"Resource requests with verbs neither built in nor configured as reads or writes, treated as writes, by verb", "verb")
+
+// Verbs given their own label by unrecognizedVerbs, any others being counted as 'other' so callers can't
+// create unbounded series
+const maxUnrecognizedVerbLabels = 32
+
+var unrecognizedVerbLabels = struct {
+	sync.Mutex
+	seen stringSet
+}{seen: stringSet{}}
+
+// Counts and logs the first sighting of resource requests with verbs the policy doesn't recognize
+func countUnrecognizedVerb(compiled *policy.Policy, spec policy.SubjectAccessReviewSpec) {
+	attributes := spec.ResourceAttributes
+	if attributes == nil || compiled.ClassifyVerb(attributes.Verb) != policy.VerbUnrecognized {
+		return
 	}
-	// Most other issues will have been caught as JSON decoding errors
-	if sar.Kind != "SubjectAccessReview" || sar.Spec.User == "" {
-		errString = "Malformed SubjectAccessReview"
-		inputError = true
+	unrecognizedVerbLabels.Lock()
+	label := attributes.Verb
+	if !unrecognizedVerbLabels.seen.Has(label) {
+		if len(unrecognizedVerbLabels.seen) < maxUnrecognizedVerbLabels {
+			unrecognizedVerbLabels.seen[label] = struct{}{}
+			log.Printf("Treating unrecognized verb %q as a write, configure it with --additional-readonly-verbs or --additional-write-verbs\n", label)
+		} else {
+			label = "other"
+		}
 	}
-	if inputError {
-		log.Println(errString)
-		http.Error(httpWriter, errString, http.StatusBadRequest)
-		return false
-	} else {
-		return true
+	unrecognizedVerbLabels.Unlock()
+	unrecognizedVerbs.Inc(label)
+}
+
+// Returns evaluate with the request UID and policy generation appended to the reasons of denials if
+// config.DenyReasonReferences is set, so users reporting a denial give operators what finds its record
+func withDenyReasonReferences(config WebhookConfig, evaluate server.Evaluator) server.Evaluator {
+	if !config.DenyReasonReferences {
+		return evaluate
+	}
+	policies := config.policySource()
+	return func(ctx context.Context, sar policy.SubjectAccessReview) authorizationv1.SubjectAccessReviewStatus {
+		status := evaluate(ctx, sar)
+		if status.Denied {
+			status.Reason = denyReasonWithReferences(status.Reason, sar.UID, policies.Snapshot(ctx).Generation())
+		}
+		return status
+	}
+}
+
+// Returns evaluate with config.DenyReasonHelp appended to the reasons of denials, so users denied by the
+// policy know who to ask rather than reporting it as a bug
+func withDenyReasonHelp(config WebhookConfig, evaluate server.Evaluator) server.Evaluator {
+	if config.DenyReasonHelp == "" {
+		return evaluate
+	}
+	return func(ctx context.Context, sar policy.SubjectAccessReview) authorizationv1.SubjectAccessReviewStatus {
+		status := evaluate(ctx, sar)
+		if status.Denied {
+			status.Reason = denyReasonWithHelp(status.Reason, config.DenyReasonHelp)
+		}
+		return status
 	}
 }
 
+func denyReasonWithHelp(reason string, help string) string {
+	if reason == "" {
+		return help
+	}
+	return strings.TrimSuffix(reason, ".") + ". " + help
+}
+
+func denyReasonWithReferences(reason string, uid types.UID, generation uint64) string {
+	references := fmt.Sprintf("policy generation %d", generation)
+	if uid != "" {
+		references = fmt.Sprintf("request %s, %s", uid, references)
+	}
+	if reason == "" {
+		return "(" + references + ")"
+	}
+	return reason + " (" + references + ")"
+}
+
+// Log level in effect, the configured one unless raised by a debug signal
+func (c WebhookConfig) logLevel() int {
+	return c.Debug.LogLevel(c.LogLevel)
+}
+
 // Returns HTTP request handler to handle SubjectAccessReview API requests
-func CreateWebhookAuthorizer(protectedNamespaces []string, additionalPrivilegedUsers []string, opinionMode bool, logLevel int) func(w http.ResponseWriter, r *http.Request) {
+func CreateWebhookAuthorizer(config WebhookConfig) func(w http.ResponseWriter, r *http.Request) {
+	config.Policy = config.policySource()
+	handler := server.NewHandler(server.Options{
+		Evaluate:          withDenyReasonHelp(config, withDenyReasonReferences(config, newEvaluator(config))),
+		Decided:           newDecisionRecorder(config),
+		Answer:            simulationAnswer,
+		MalformedRequests: config.MalformedRequestPolicy,
+		Rejected:          countRejectedRequest,
+		Limits:            config.Limits,
+		APIVersions:       config.APIVersions,
+		Truncated:         func(_ *http.Request, limit string) { oversizedRequests.Inc(limit, "truncated") },
+		Cancelled:         countCancelledRequest,
+		// Panics outside evaluation are answered as if evaluation had failed
+		PanicFailurePolicy: config.EvaluationFailurePolicy,
+		Panicked:           func(*http.Request, any) { panics.Inc("handler") },
+		Middleware:         []server.Middleware{config.RateLimiter.Middleware(config.Clusters), config.Enrichment.Middleware(config.Clusters), server.PinPolicy(config.Policy), overlayMiddleware(config.Policy, config.Overlays, config.Clusters), config.NamedPolicies.Middleware(config.Clusters), config.Simulation.Middleware(config.Clusters), cacheControl(config.CacheLongTTL)},
+	})
+	if config.LogLevel >= 2 {
+		return server.Chain(handler, dumpRequests).ServeHTTP
+	}
+	if config.Debug == nil {
+		return handler
+	}
+	dumped := dumpRequests(http.HandlerFunc(handler))
 	return func(w http.ResponseWriter, r *http.Request) {
+		if config.logLevel() >= 2 {
+			dumped.ServeHTTP(w, r)
+		} else {
+			handler(w, r)
+		}
+	}
+}
+
+var inconsistentRequests = Metrics.NewCounterVec("azimuth_authz_inconsistent_requests_total",
+	"SubjectAccessReviews with inconsistent attributes, by inconsistency", "inconsistency")
+
+var flaggedBulkSecretReads = Metrics.NewCounterVec("azimuth_authz_bulk_secret_reads_total",
+	"List and watch requests for secrets without a name or selector flagged by --bulk-secret-reads=flag, by decision", "decision")
+
+var serviceAccountAllowlistViolations = Metrics.NewCounterVec("azimuth_authz_service_account_allowlist_violations_total",
+	"Requests --privileged-service-accounts-mode=enforce would deny, from service accounts of protected namespaces not in --privileged-service-accounts, by namespace", "namespace")
+
+var nodeRestrictedRequests = Metrics.NewCounterVec("azimuth_authz_node_restricted_requests_total",
+	"Requests by node users not treated as privileged by --node-restriction as they aren't related to the node, by decision", "decision")
+
+var unsupportedAPIVersions = Metrics.NewCounterVec("azimuth_authz_unsupported_api_versions_total",
+	"SubjectAccessReviews rejected for an apiVersion that isn't accepted, by apiVersion", "api_version")
+
+// Matches apiVersions counted under their own label, others are counted as 'other' so callers can't
+// create unbounded series
+var apiVersionLabelPattern = regexp.MustCompile(`^authorization\.k8s\.io/v[0-9]{1,2}((alpha|beta)[0-9]{1,2})?$`)
+
+var oversizedRequests = Metrics.NewCounterVec("azimuth_authz_oversized_requests_total",
+	"SubjectAccessReviews exceeding size limits, by limit and action", "limit", "action")
+
+// Counts requests rejected for inconsistent attributes, unsupported apiVersions or exceeding limits
+func countRejectedRequest(_ *http.Request, err error) {
+	var inconsistencyErr *policy.InconsistencyError
+	var limitErr *policy.LimitError
+	var versionErr *policy.UnsupportedAPIVersionError
+	switch {
+	case errors.As(err, &inconsistencyErr):
+		inconsistentRequests.Inc(inconsistencyErr.Inconsistency)
+	case errors.As(err, &versionErr):
+		label := "other"
+		if apiVersionLabelPattern.MatchString(versionErr.APIVersion) {
+			label = versionErr.APIVersion
+		}
+		unsupportedAPIVersions.Inc(label)
+	case errors.As(err, &limitErr):
+		oversizedRequests.Inc(limitErr.Limit, "rejected")
+	}
+}
+
+// Enforces config.Limits on spec as /authorize does, counting requests which exceed them
+func enforceLimits(config WebhookConfig, spec *policy.SubjectAccessReviewSpec) error {
+	truncated, err := config.Limits.Enforce(spec)
+	for _, limit := range truncated {
+		oversizedRequests.Inc(limit, "truncated")
+	}
+	countRejectedRequest(nil, err)
+	return err
+}
+
+var cancelledRequests = Metrics.NewCounterVec("azimuth_authz_requests_cancelled_total",
+	"Requests abandoned because the caller disconnected or timed out, by reason", "reason")
+
+// Counts requests abandoned with err from their context
+func countCancelledRequest(_ *http.Request, err error) {
+	if errors.Is(err, context.DeadlineExceeded) {
+		cancelledRequests.Inc("deadline-exceeded")
+	} else {
+		cancelledRequests.Inc("canceled")
+	}
+}
+
+// Returns function logging, counting, mirroring, recording and auditing each decision, shared by the
+// endpoints serving kube-apiserver and other Azimuth services
+func newDecisionRecorder(config WebhookConfig) func(r *http.Request, sar policy.SubjectAccessReview, status authorizationv1.SubjectAccessReviewStatus) {
+	policies := config.policySource()
+	return func(r *http.Request, sar policy.SubjectAccessReview, status authorizationv1.SubjectAccessReviewStatus) {
+		compiled := policies.Snapshot(r.Context())
+		generation := compiled.Generation()
+		if inconsistency := policy.Inconsistency(sar.Spec); inconsistency != "" {
+			inconsistentRequests.Inc(inconsistency)
+		}
+		simulated := simulatedFrom(r.Context())
+		identity := config.Clusters.Identify(r)
+		cluster := identity.String()
+		if (identity != nil || config.Clusters != nil) && !simulated {
+			clusterDecisions.Inc(cluster, policy.DecisionLabel(status))
+		}
+		if identity == nil {
+			cluster = r.Header.Get("X-Forwarded-For")
+		}
+		if config.logLevel() >= 1 && (sar.Spec.ResourceAttributes != nil || sar.Spec.NonResourceAttributes != nil) {
+			log.Println(decisionLogRecord{cluster: cluster, identity: identity, spec: &sar.Spec, status: &status, generation: generation, uid: sar.UID, simulated: simulated})
+		}
+
+		// Logged whatever the log level, as they may be secrets being harvested
+		bulkSecretRead := compiled.FlagsBulkSecretRead(sar.Spec)
+		if bulkSecretRead {
+			flaggedBulkSecretReads.Inc(policy.DecisionLabel(status))
+			log.Printf("Bulk secret read: %s %s secrets in namespace %q from cluster %q (request %s)\n",
+				policy.DescribeSubject(sar.Spec), sar.Spec.ResourceAttributes.Verb, sar.Spec.ResourceAttributes.Namespace, cluster, sar.UID)
+		}
+
+		// Logged whatever the log level, so the allowlist can be completed before it's enforced
+		if serviceAccount, reason, violation := compiled.ServiceAccountAllowlistViolation(sar); violation && !status.Denied {
+			namespace, _, _ := strings.Cut(serviceAccount, "/")
+			serviceAccountAllowlistViolations.Inc(namespace)
+			log.Printf("Service account allowlist violation: request %s from %s would be denied as it isn't in --privileged-service-accounts: %s\n",
+				sar.UID, serviceAccount, reason)
+		}
+
+		// Denials logged whatever the log level, as they may be stolen kubelet credentials being used
+		if compiled.RestrictsNodeRequest(sar.Spec) {
+			nodeRestrictedRequests.Inc(policy.DecisionLabel(status))
+			if status.Denied {
+				log.Printf("Node restriction: denied %s %s %s in namespace %q unrelated to the node, from cluster %q (request %s)\n",
+					sar.Spec.User, sar.Spec.ResourceAttributes.Verb, sar.Spec.ResourceAttributes.Resource, sar.Spec.ResourceAttributes.Namespace, cluster, sar.UID)
+			}
+		}
+
+		// Counted whatever the log level, so probing the policy for gaps is noisy and slow
+		var denyRule string
+		var repeatedDenials int
+		var tarpitDelay time.Duration
+		if status.Denied && !simulated && config.Tarpit != nil {
+			var authorized bool
+			if denyRule, authorized, _ = policy.MatchRule(sar, compiled); !authorized {
+				repeatedDenials, tarpitDelay = config.Tarpit.observe(cluster, sar.Spec.User, denyRule)
+			}
+		}
+
+		config.Mirror.Compare(sar, cluster, status)
+		// Simulations aren't real traffic, so would skew a corpus sampled from it
+		if !simulated {
+			config.Corpus.Record(sar, cluster, status)
+		}
+		if config.Audit != nil {
+			event := newAuditEvent(sar, cluster, status)
+			event.UID = sar.UID
+			event.PolicyGeneration = generation
+			event.Policy = namedPolicyFrom(r.Context())
+			event.Overlays = overlaysFrom(r.Context())
+			event.BulkSecretRead = bulkSecretRead
+			event.Simulated = simulated
+			event.RepeatedDenials = repeatedDenials
+			if identity != nil {
+				event.ClusterLabels = identity.Labels
+			}
+			config.Audit.Publish(event)
+		}
+		// Last, as the response is only written once decisions are recorded
+		if tarpitDelay > 0 {
+			config.Tarpit.wait(r.Context(), denyRule, tarpitDelay)
+		}
+	}
+}
+
+func newAuditEvent(sar policy.SubjectAccessReview, cluster string, status authorizationv1.SubjectAccessReviewStatus) AuditEvent {
+	event := AuditEvent{
+		Time:    time.Now(),
+		Cluster: cluster,
+		User:    sar.Spec.User,
+		Groups:  sar.Spec.Groups,
+		Allowed: status.Allowed,
+		Denied:  status.Denied,
+		Reason:  status.Reason,
+	}
+	if sar.Spec.ResourceAttributes != nil {
+		event.Verb = sar.Spec.ResourceAttributes.Verb
+		event.Resource = sar.Spec.ResourceAttributes.Resource
+		event.Namespace = sar.Spec.ResourceAttributes.Namespace
+		event.Name = sar.Spec.ResourceAttributes.Name
+	} else if sar.Spec.NonResourceAttributes != nil {
+		event.Verb = sar.Spec.NonResourceAttributes.Verb
+		event.NonResourcePath = sar.Spec.NonResourceAttributes.Path
+	}
+	return event
+}
 
-		dump, dumperr := httputil.DumpRequest(r, true)
-		if dumperr != nil {
-			log.Println("Error dumping request:", dumperr)
-			return
+// Builds audit pipeline from command line settings and any other sinks, returns nil pipeline if no sinks
+// are configured
+func createAuditPipeline(auditFile string, auditLokiURL string, azimuth AzimuthAuditSinkOptions, client *OutboundClient, options AuditPipelineOptions, sinks ...AuditSink) (*AuditPipeline, error) {
+	if auditFile != "" {
+		fileSink, err := NewFileAuditSink(auditFile)
+		if err != nil {
+			return nil, err
 		}
+		sinks = append(sinks, fileSink)
+	}
+	if auditLokiURL != "" {
+		sinks = append(sinks, NewLokiAuditSink(auditLokiURL, client))
+	}
+	if azimuth.URL != "" {
+		sinks = append(sinks, NewAzimuthAuditSink(azimuth, client))
+	}
+	if options.OverflowPolicy != AuditDropNewest && options.OverflowPolicy != AuditDropOldest {
+		return nil, fmt.Errorf("unknown audit overflow policy %q", options.OverflowPolicy)
+	}
+	return NewAuditPipeline(sinks, options), nil
+}
 
-		var sar SubjectAccessReviewAPI
-		err := json.NewDecoder(r.Body).Decode(&sar)
+func createUpstreamDelegate(url string, caFile string, tokenFile string, timeout time.Duration, failurePolicy DelegateFailurePolicy, client *OutboundClient) (*UpstreamDelegate, error) {
+	if failurePolicy != DelegateFailNoOpinion && failurePolicy != DelegateFailDeny {
+		return nil, fmt.Errorf("unknown delegate failure policy %q", failurePolicy)
+	}
+	if caFile != "" {
+		tlsConfig, err := tlsConfigWithCA(caFile)
 		if err != nil {
-			jsonErrString := "JSON decoding error: " + err.Error()
-			log.Println(jsonErrString)
-			http.Error(w, jsonErrString, http.StatusBadRequest)
-			return
+			return nil, err
 		}
+		client = client.WithTLSConfig(tlsConfig)
+	}
+	token, err := readSecretFile(tokenFile)
+	if err != nil {
+		return nil, err
+	}
+	return &UpstreamDelegate{URL: url, BearerToken: token, Timeout: timeout, FailurePolicy: failurePolicy, Client: client, health: newHealth(false)}, nil
+}
 
-		defer r.Body.Close()
+// Creates delegate posting SubjectAccessReviews to the API server of the cluster in the kubeconfig, so that
+// RBAC defined there applies to this cluster too
+func createManagementClusterDelegate(kubeconfigPath string, contextName string, timeout time.Duration, failurePolicy DelegateFailurePolicy, client *OutboundClient) (*UpstreamDelegate, error) {
+	if failurePolicy != DelegateFailNoOpinion && failurePolicy != DelegateFailDeny {
+		return nil, fmt.Errorf("unknown delegate failure policy %q", failurePolicy)
+	}
+	conn, err := LoadKubeconfig(kubeconfigPath, contextName)
+	if err != nil {
+		return nil, err
+	}
+	return &UpstreamDelegate{
+		URL:           conn.Server + "/apis/authorization.k8s.io/v1/subjectaccessreviews",
+		BearerToken:   conn.BearerToken,
+		Timeout:       timeout,
+		FailurePolicy: failurePolicy,
+		Client:        client.WithTLSConfig(conn.TLSConfig),
+		health:        newHealth(false),
+	}, nil
+}
 
-		if !inputIsSanitised(sar, w) {
-			return
+// Builds policy sync from command line settings, discarding cached decisions whenever the policy changes.
+// When failing static, policies failing the self-test are rejected rather than applied
+func createPolicySync(url string, ociRef string, caFile string, tokenFile string, ociCredentialsFile string, publicKeyFile string, interval time.Duration, failStatic bool, config WebhookConfig, client *OutboundClient, selfTest *SelfTest) (*PolicySync, error) {
+	if url != "" && ociRef != "" {
+		return nil, fmt.Errorf("only one of --policy-sync-url and --policy-sync-oci-ref may be given")
+	}
+	if publicKeyFile == "" {
+		return nil, fmt.Errorf("--policy-sync-public-key-file is required")
+	}
+	publicKeys, err := readEd25519PublicKeys(publicKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	token, err := readSecretFile(tokenFile)
+	if err != nil {
+		return nil, err
+	}
+	if caFile != "" {
+		tlsConfig, err := tlsConfigWithCA(caFile)
+		if err != nil {
+			return nil, err
+		}
+		client = client.WithTLSConfig(tlsConfig)
+	}
+	var oci *OCIReference
+	if ociRef != "" {
+		if oci, err = ParseOCIReference(ociRef); err != nil {
+			return nil, err
 		}
+	}
+	credentials, err := readSecretFile(ociCredentialsFile)
+	if err != nil {
+		return nil, err
+	}
+	username, password, _ := strings.Cut(credentials, ":")
+	options := PolicySyncOptions{
+		URL:         url,
+		OCI:         oci,
+		BearerToken: token,
+		Username:    username,
+		Password:    password,
+		PublicKeys:  publicKeys,
+		Interval:    interval,
+		OnUpdate: func(updated policy.Config) {
+			config.DecisionCache.Reset(HashDecisionInputs(config))
+			selfTest.Run(updated, config.Policy.Current())
+		},
+		FailStatic: failStatic,
+	}
+	if failStatic {
+		options.Check = func(updated policy.Config) error {
+			if violations := selfTestViolations(updated, policy.Compile(updated)); len(violations) > 0 {
+				return fmt.Errorf("violates invariants: %s", strings.Join(violations, "; "))
+			}
+			return nil
+		}
+	}
+	return NewPolicySync(options, client, config.Policy, config.Config), nil
+}
 
-		authorized, denyReason := isRequestAuthorized(sar, protectedNamespaces, additionalPrivilegedUsers)
+func createMirrorWebhook(url string, caFile string, tokenFile string, timeout time.Duration, maxInflight int, client *OutboundClient) (*MirrorWebhook, error) {
+	if caFile != "" {
+		tlsConfig, err := tlsConfigWithCA(caFile)
+		if err != nil {
+			return nil, err
+		}
+		client = client.WithTLSConfig(tlsConfig)
+	}
+	token, err := readSecretFile(tokenFile)
+	if err != nil {
+		return nil, err
+	}
+	return NewMirrorWebhook(url, token, timeout, maxInflight, client), nil
+}
 
-		status := new(authorizationv1.SubjectAccessReviewStatus)
-		status.Denied = !authorized
-		status.Allowed = opinionMode && authorized
+func createKeystoneRoleResolver(url string, appCredID string, appCredSecretFile string, privilegedRoles []string, cacheTTL time.Duration, client *OutboundClient) (*KeystoneRoleResolver, error) {
+	if url == "" || appCredID == "" {
+		return nil, fmt.Errorf("--keystone-url and --keystone-application-credential-id are required for role lookup")
+	}
+	secret, err := readSecretFile(appCredSecretFile)
+	if err != nil {
+		return nil, err
+	}
+	return NewKeystoneRoleResolver(KeystoneRoleResolverOptions{
+		URL:                         url,
+		ApplicationCredentialID:     appCredID,
+		ApplicationCredentialSecret: secret,
+		PrivilegedRoles:             privilegedRoles,
+		CacheTTL:                    cacheTTL,
+	}, client), nil
+}
 
-		if status.Denied {
-			status.Reason = denyReason
-		} else if !opinionMode {
-			status.Reason = "Webhook doesn't give opinion, delegated to other authorizers"
+func createTenancyResolver(url string, tokenFile string, tenantNamespaces []string, cacheTTL time.Duration, client *OutboundClient) (*TenancyResolver, error) {
+	if err := policy.ValidateNamespacePatterns(tenantNamespaces); err != nil {
+		return nil, err
+	}
+	token, err := readSecretFile(tokenFile)
+	if err != nil {
+		return nil, err
+	}
+	return NewTenancyResolver(TenancyResolverOptions{
+		URL:              url,
+		BearerToken:      token,
+		TenantNamespaces: tenantNamespaces,
+		CacheTTL:         cacheTTL,
+	}, client), nil
+}
+
+func createClusterRegistry(kubeconfigPath string, contextName string, labelsCSL string, clientCertHeader string, client *OutboundClient) (*ClusterRegistry, error) {
+	conn, err := LoadKubeconfig(kubeconfigPath, contextName)
+	if err != nil {
+		return nil, err
+	}
+	labelKeys, err := parseKeyValueList(labelsCSL)
+	if err != nil {
+		return nil, err
+	}
+	return NewClusterRegistry(conn, client, ClusterRegistryOptions{LabelKeys: labelKeys, ClientCertHeader: clientCertHeader}), nil
+}
+
+// Adds the webhook's Service, given as namespace/name, and comma separated namespace/name list of Secrets to
+// the objects config protects
+func appendWebhookObjects(config *policy.Config, service string, secretsCSL string) error {
+	if service != "" {
+		namespace, name, found := strings.Cut(service, "/")
+		if !found || namespace == "" || name == "" {
+			return fmt.Errorf("invalid webhook service %q, expected namespace/name", service)
 		}
+		config.WebhookObjects = append(config.WebhookObjects, policy.WebhookServiceObjects(namespace, name)...)
+	}
+	for _, secret := range strings.Split(secretsCSL, ",") {
+		if secret == "" {
+			continue
+		}
+		namespace, name, found := strings.Cut(secret, "/")
+		if !found || namespace == "" || name == "" {
+			return fmt.Errorf("invalid webhook secret %q, expected namespace/name", secret)
+		}
+		config.WebhookObjects = append(config.WebhookObjects, namespace+"/secrets/"+name)
+	}
+	return nil
+}
 
-		responseReview := new(SubjectAccessReviewHTTPResponse)
-		responseReview.ApiVersion = "authorization.k8s.io/v1"
-		responseReview.Kind = "SubjectAccessReview"
-		responseReview.Status = *status
+// Returns nil if no cluster is rate limited
+func createClusterRateLimiter(rate float64, burst int, overridesCSL string, mode string) (*ClusterRateLimiter, error) {
+	if mode := RateLimitMode(mode); mode != RateLimitNoOpinion && mode != RateLimitTooManyRequests {
+		return nil, fmt.Errorf("unknown mode %q", mode)
+	}
+	pairs, err := parseKeyValueList(overridesCSL)
+	if err != nil {
+		return nil, err
+	}
+	overrides := map[string]float64{}
+	for cluster, value := range pairs {
+		overrides[cluster], err = strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("rate for %s: %w", cluster, err)
+		}
+	}
+	if rate <= 0 && len(overrides) == 0 {
+		return nil, nil
+	}
+	return NewClusterRateLimiter(ClusterRateLimiterOptions{Rate: rate, Burst: burst, Overrides: overrides, Mode: RateLimitMode(mode)}), nil
+}
 
-		var deniedLogOutput string
-		if status.Denied {
-			deniedLogOutput = "Denied"
-		} else {
-			deniedLogOutput = "Allowed"
+// Returns an elector competing for lease, given as namespace/name, identified by the hostname, which
+// is the pod name in Kubernetes
+func createFleetElector(conn *ClusterConnection, client *OutboundClient, lease string, leaseDuration time.Duration, onStartedLeading func()) (*LeaderElector, error) {
+	namespace, name, ok := strings.Cut(lease, "/")
+	if !ok {
+		return nil, fmt.Errorf("lease %q is not of the form namespace/name", lease)
+	}
+	identity, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	return NewLeaderElector(conn, client, LeaderElectionOptions{
+		Namespace:        namespace,
+		Name:             name,
+		Identity:         identity,
+		LeaseDuration:    leaseDuration,
+		OnStartedLeading: onStartedLeading,
+	})
+}
+
+// Returns options for writing fleet kubeconfigs, which only the elected replica writes
+func createFleetKubeconfigOptions(serverURL string, caFile string, rotationPeriod time.Duration, elected bool) (*FleetKubeconfigOptions, error) {
+	if !elected {
+		return nil, errors.New("--fleet-kubeconfig-server-url requires --fleet-leader-election-lease")
+	}
+	if u, err := url.Parse(serverURL); err != nil || u.Host == "" {
+		return nil, fmt.Errorf("--fleet-kubeconfig-server-url must be an absolute URL")
+	}
+	if rotationPeriod < 0 {
+		return nil, fmt.Errorf("--fleet-token-rotation-period must not be negative")
+	}
+	options := &FleetKubeconfigOptions{ServerURL: serverURL, RotationPeriod: rotationPeriod}
+	if caFile != "" {
+		var err error
+		if options.CAData, err = os.ReadFile(caFile); err != nil {
+			return nil, err
 		}
+	}
+	return options, nil
+}
 
-		// TODO: find way to map cluster IPs from X-Forward headers to clusters
-		if logLevel >= 1 && sar.Spec.NonResourceAttributes != nil {
-			log.Println("[Cluster: " + r.Header.Get("X-Forwarded-For") + "] " + deniedLogOutput + " non-resource request from " + sar.Spec.User + ". Reason: " + status.Reason)
+// Command line settings for the /authenticate endpoint's backends
+type tokenAuthConfig struct {
+	tokenFile            string
+	oidcIntrospectionURL string
+	oidcClientID         string
+	oidcClientSecretFile string
+	oidcUsernameClaim    string
+	oidcUsernamePrefix   string
+	oidcGroupsClaim      string
+	oidcGroupsPrefix     string
+	keystoneURL          string
+}
+
+// Builds token authentication backends in the order they are consulted. Returns no backends if
+// none are configured, in which case /authenticate is not served
+func createTokenAuthenticators(config tokenAuthConfig, client *OutboundClient) ([]TokenAuthenticator, error) {
+	var authenticators []TokenAuthenticator
+	if config.tokenFile != "" {
+		static, err := NewStaticTokenAuthenticator(config.tokenFile)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, static)
+	}
+	if config.oidcIntrospectionURL != "" {
+		clientSecret, err := readSecretFile(config.oidcClientSecretFile)
+		if err != nil {
+			return nil, err
 		}
-		if logLevel >= 1 && sar.Spec.ResourceAttributes != nil {
-			log.Println("[Cluster: " + r.Header.Get("X-Forwarded-For") + "] " + deniedLogOutput + " request from " + sar.Spec.User + " to " + sar.Spec.ResourceAttributes.Verb + " " + sar.Spec.ResourceAttributes.Resource + " in namespace " + sar.Spec.ResourceAttributes.Namespace + ". Reason: " + status.Reason)
+		authenticators = append(authenticators, &OIDCIntrospectionAuthenticator{
+			IntrospectionURL: config.oidcIntrospectionURL,
+			ClientID:         config.oidcClientID,
+			ClientSecret:     clientSecret,
+			UsernameClaim:    config.oidcUsernameClaim,
+			UsernamePrefix:   config.oidcUsernamePrefix,
+			GroupsClaim:      config.oidcGroupsClaim,
+			GroupsPrefix:     config.oidcGroupsPrefix,
+			Client:           client,
+		})
+	}
+	if config.keystoneURL != "" {
+		authenticators = append(authenticators, &KeystoneAuthenticator{URL: config.keystoneURL, Client: client})
+	}
+	return authenticators, nil
+}
+
+// Parses 'key1=value1,key2=value2' into a map
+func parseKeyValueList(csl string) (map[string]string, error) {
+	values := map[string]string{}
+	if csl == "" {
+		return values, nil
+	}
+	for _, pair := range strings.Split(csl, ",") {
+		key, value, found := strings.Cut(pair, "=")
+		if !found || key == "" {
+			return nil, fmt.Errorf("expected key=value, got %q", pair)
 		}
-		if logLevel >= 2 {
-			log.Printf("HTTP Dump: \n%s\n", string(dump))
+		values[key] = value
+	}
+	return values, nil
+}
+
+// Parses comma separated key=value list in which keys may be repeated
+func parseMultiValueList(csl string) (map[string][]string, error) {
+	values := map[string][]string{}
+	if csl == "" {
+		return values, nil
+	}
+	for _, pair := range strings.Split(csl, ",") {
+		key, value, found := strings.Cut(pair, "=")
+		if !found || key == "" {
+			return nil, fmt.Errorf("expected key=value, got %q", pair)
 		}
+		values[key] = append(values[key], value)
+	}
+	return values, nil
+}
+
+// Prefix of the environment variables setting the server's flags
+const SettingsEnvPrefix = "AZIMUTH_AUTHZ_"
 
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(responseReview)
+// Logs the source of each setting not left at its default
+func logSettings(settings []config.Setting) {
+	defaults := 0
+	for _, setting := range settings {
+		if setting.Source == config.SourceDefault {
+			defaults++
+			continue
+		}
+		log.Printf("Setting --%s=%q from %s\n", setting.Name, setting.Value, setting.Source)
 	}
+	log.Printf("%d other settings left at their defaults\n", defaults)
 }
 
-func main() {
-	var additionalPrivilegedUsersCSL = flag.String("additional-privileged-users", "", "Comma separated list of users that should be allowed to write to protected namespaces, excluding 'system:*' users")
-	var protectedNamespacesCSL = flag.String("protected-namespaces", "kube-system,openstack-system", "Comma separated list of namespaces which unprivileged users will have limited permissions for")
-	var logLevel = flag.Int("log-level", 1, "Verbosity of logs. Values: [0-2]")
-	var opinionMode = flag.Bool("allow-opinion-mode", false, "Specifies if this webhook should give its opinion on requests which it doesn't deny. If true, will set 'allowed' to true in SubjectAccessReview.")
-	flag.Parse()
+// Runs the webhook server with the flags in args, returning the exit code once it stops or fails to start.
+// If inspect is set, it's called with the server's flags once they're registered instead, for commands
+// which use them
+func serve(args []string, inspect func(flags *flag.FlagSet) int) int {
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	var adminAddress = flags.String("admin-address", ":8081", "Address of the admin listener, serving the /admin/ API and debug endpoints apart from the authorization endpoints")
+	var adminTokenAuthFile = flags.String("admin-token-auth-file", "", "CSV file of tokens accepted by the admin listener, in kube-apiserver --token-auth-file format. The admin listener is disabled if neither this nor --admin-client-ca-file is set")
+	var adminClientCAFile = flags.String("admin-client-ca-file", "", "CA bundle verifying client certificates accepted by the admin listener, which are identified by their common name. Requires --admin-tls-cert-file")
+	var adminTLSCertFile = flags.String("admin-tls-cert-file", "", "Certificate the admin listener serves HTTPS with, plain HTTP if empty")
+	var adminTLSKeyFile = flags.String("admin-tls-key-file", "", "Private key for --admin-tls-cert-file")
+	var adminPrivilegesFile = flags.String("admin-privileges-file", "", "File privileges granted through the /admin/ API are saved to and loaded from on startup. Not persisted if empty")
+	var adminNamespacesFile = flags.String("admin-namespaces-file", "", "File protected namespace overrides made through the /admin/ API are saved to and loaded from on startup. Not persisted if empty")
+	var reportRetention = flags.Duration("report-retention", 0, "Period decisions are aggregated per tenant for GET /admin/report, rounded up to whole days. Requires the admin listener. Disabled if 0")
+	var correlationHistorySize = flags.Int("correlation-history-size", 0, "Number of write decisions kept to correlate with admission decisions for GET /admin/decisions/correlated. Requires the admin listener. Disabled if 0")
+	var correlationWindow = flags.Duration("correlation-window", 30*time.Second, "Time after an authorization decision within which an admission decision for the same request is correlated with it")
+	var reportGroupBy = flags.String("report-group-by", "namespace", "What tenants are in reports: namespace, cluster, or label:NAME for a label of the identified calling cluster")
+	var dryRunMode = flags.Bool("dry-run", false, "Load and validate the configuration, print it with a policy summary and whether each source can be reached, then exit without serving")
+	flags.String("config-file", "", "YAML file of settings keyed by flag name, overridden by environment variables and flags. Disabled if empty")
+	var additionalPrivilegedUsersCSL = flags.String("additional-privileged-users", "", "Comma separated list of users that should be allowed to write to protected namespaces, excluding 'system:*' users")
+	var protectedNamespacesCSL = flags.String("protected-namespaces", "kube-system,openstack-system", "Comma separated list of namespaces which unprivileged users will have limited permissions for. Entries may be prefixes ending in '*' or glob patterns")
+	var readinessGracePeriod = flags.Duration("readiness-grace-period", time.Minute, "Time for which policy sync, the CAPI and fleet watches and the delegate may fail, or not have synced yet, before /readyz fails")
+	var maxGroups = flags.Int("max-groups", 1024, "Maximum number of groups in a SubjectAccessReview. Unlimited if 0")
+	var maxExtraKeys = flags.Int("max-extra-keys", 64, "Maximum number of extra keys in a SubjectAccessReview. Unlimited if 0")
+	var maxExtraValues = flags.Int("max-extra-values", 256, "Maximum number of values of each extra key in a SubjectAccessReview. Unlimited if 0")
+	var maxFieldLength = flags.Int("max-field-length", 4096, "Maximum length in bytes of any string in a SubjectAccessReview, such as the user, a group or an attribute. Unlimited if 0")
+	var truncateGroups = flags.Bool("truncate-groups", false, "Drop groups beyond --max-groups and groups longer than --max-field-length, rather than rejecting the SubjectAccessReview as malformed")
+	var bulkSecretReads = flags.String("bulk-secret-reads", string(policy.BulkSecretReadsAllow), "Treatment of list and watch requests for secrets without a name or selector outside protected namespaces, which read every secret at once. 'flag' logs, counts and audits them, 'deny' denies them unless the user is privileged. Values: [allow, flag, deny]")
+	var denyImpersonatedProtectedWrites = flags.Bool("deny-impersonated-protected-writes", false, "Deny writes to protected namespaces by impersonated users, identified by the "+policy.ImpersonatorUserExtraKey+" SAR extra, even if both identities are privileged")
+	var privilegedServiceAccounts = flags.String("privileged-service-accounts", "", "Comma separated list of service accounts of protected namespaces, as namespace/name, which are privileged when --privileged-service-accounts-mode is enforce")
+	var nodeRestriction = flags.Bool("node-restriction", false, "Only treat node users as privileged system users for requests plausibly related to their node, such as their own Node and Lease and the objects their pods use, so others are subject to the protected namespace rules")
+	var namespaceCreationProtection = flags.Bool("namespace-creation-protection", false, "Deny creating namespaces with reserved names to users who aren't privileged: protected namespaces, those in --reserved-namespaces and those extending the name of a protected namespace, such as kube-system2. Only enforced where the name is known, such as through /admit")
+	var reservedNamespacesCSL = flags.String("reserved-namespaces", "", "Comma separated list of namespaces only privileged users may create besides protected namespaces. Supports the same patterns as --protected-namespaces")
+	var namespaceDeletionProtection = flags.Bool("namespace-deletion-protection", false, "Deny deleting protected namespaces, and those in --deletion-protected-namespaces, to every user but --namespace-deleters, even privileged ones")
+	var deletionProtectedNamespacesCSL = flags.String("deletion-protected-namespaces", "", "Comma separated list of namespaces whose deletion is protected besides protected namespaces, e.g. tenant namespaces. Supports the same patterns as --protected-namespaces")
+	var namespaceDeletersCSL = flags.String("namespace-deleters", "", "Comma separated list of users who may delete namespaces whose deletion is protected")
+	var privilegedServiceAccountsMode = flags.String("privileged-service-accounts-mode", string(policy.ServiceAccountAllowlistOff), "Whether every service account of a protected namespace is privileged. 'log' logs and counts the requests 'enforce' would deny, 'enforce' only privileges those in --privileged-service-accounts. Values: [off, log, enforce]")
+	var nameRulesFile = flags.String("name-rules-file", "", "YAML file listing rules for requests for particular objects by name, e.g. denying reads of one secret to all but one service account, considered before the protected namespace rules. Disabled if empty")
+	var webhookService = flags.String("webhook-service", "", "Service kube-apiserver reaches the webhook through, as namespace/name. Writes to it and its Endpoints and EndpointSlices are denied unless the user is privileged, even in exempt namespaces")
+	var webhookSecretsCSL = flags.String("webhook-secrets", "", "Comma separated namespace/name list of Secrets the webhook depends on, e.g. its serving certificate. Writes to them are denied unless the user is privileged, even in exempt namespaces")
+	var webhookObjectsCSL = flags.String("webhook-objects", "", "Comma separated list of other objects the webhook depends on, as NAMESPACE/RESOURCE[.GROUP]/NAME, protected as --webhook-secrets are. NAMESPACE and NAME may be glob patterns")
+	var disableWebhookProtection = flags.Bool("disable-webhook-protection", false, "Leave the objects the webhook depends on, including kubeadm-config and the kube-apiserver ConfigMaps in kube-system, to the protected namespace rules alone")
+	var wildcardRequests = flags.String("wildcard-requests", string(policy.WildcardProtectedNamespaces), "Treatment of requests for verb '*' or resource '*' by unprivileged users. 'protected-namespaces' restricts them in protected namespaces only, 'deny' denies them everywhere. Values: [protected-namespaces, deny]")
+	var additionalReadonlyVerbsCSL = flags.String("additional-readonly-verbs", "", "Comma separated list of custom verbs which can't modify resources, besides get, list, watch and proxy")
+	var additionalWriteVerbsCSL = flags.String("additional-write-verbs", "", "Comma separated list of custom verbs which modify resources, so they aren't counted as unrecognized. Unrecognized verbs are treated as writes")
+	var clusterScopedResourcesCSL = flags.String("cluster-scoped-resources", "", "Comma separated list of resources without namespaces besides the built-in ones, as RESOURCE[.GROUP], so requests for them aren't treated as across all namespaces")
+	var logLevel = flags.Int("log-level", 1, "Verbosity of logs. Values: [0-2]")
+	var debugDumpDuration = flags.Duration("debug-dump-duration", 10*time.Minute, "How long requests are dumped for after SIGUSR2, as if --log-level were 2")
+	var authorizersCSL = flags.String("authorizers", strings.Join(defaultAuthorizers, ","), "Comma separated list of authorizers consulted in order, the first to allow or deny a request deciding it. Authorizers which aren't configured are skipped. Values: [rules, tenancy, hooks, delegate]")
+	var acceptedAPIVersionsCSL = flags.String("accepted-api-versions", strings.Join(policy.DefaultAPIVersions, ","), "Comma separated list of SubjectAccessReview apiVersions accepted. Versions other than v1beta1 are decoded as v1")
+	var opinionMode = flags.Bool("allow-opinion-mode", false, "Specifies if this webhook should give its opinion on requests which it doesn't deny. If true, will set 'allowed' to true in SubjectAccessReview.")
+	var auditFile = flags.String("audit-file", "", "Path of file to append JSON audit events to, '-' for stdout. Disabled if empty")
+	var auditLokiURL = flags.String("audit-loki-url", "", "Base URL of Loki instance to push audit events to. Disabled if empty")
+	var auditAzimuthURL = flags.String("audit-azimuth-url", "", "URL of Azimuth audit API to POST batches of audit events to. Disabled if empty")
+	var auditAzimuthTokenFile = flags.String("audit-azimuth-token-file", "", "File containing bearer token sent to the Azimuth audit API")
+	var auditAzimuthMaxRetries = flags.Int("audit-azimuth-max-retries", 3, "Number of times a failed batch is retried before it is discarded")
+	var auditAzimuthRetryBackoff = flags.Duration("audit-azimuth-retry-backoff", 500*time.Millisecond, "Delay before the first retry of a failed batch, doubled for each subsequent retry")
+	var auditQueueSize = flags.Int("audit-queue-size", 1024, "Maximum number of audit events buffered before the overflow policy applies")
+	var auditBatchSize = flags.Int("audit-batch-size", 100, "Maximum number of audit events written to sinks at once")
+	var auditFlushInterval = flags.Duration("audit-flush-interval", time.Second, "Maximum time an audit event is buffered before being written")
+	var auditOverflowPolicy = flags.String("audit-overflow-policy", string(AuditDropNewest), "Action when the audit queue is full. Values: [drop-newest, drop-oldest]")
+	var classificationCacheSize = flags.Int("user-classification-cache-size", 1024, "Number of users whose privilege classification is cached. Disabled if 0")
+	var batchMaxItems = flags.Int("batch-max-items", 1000, "Maximum number of SubjectAccessReviews accepted in one /authorize/batch request")
+	var batchConcurrency = flags.Int("batch-concurrency", runtime.GOMAXPROCS(0), "Maximum number of SubjectAccessReviews from one batch request evaluated concurrently")
+	var outboundMaxIdleConnsPerHost = flags.Int("outbound-max-idle-conns-per-host", DefaultOutboundClientOptions.MaxIdleConnsPerHost, "Maximum idle connections kept open to each outbound backend")
+	var outboundIdleConnTimeout = flags.Duration("outbound-idle-conn-timeout", DefaultOutboundClientOptions.IdleConnTimeout, "Time after which idle outbound connections are closed")
+	var outboundDialTimeout = flags.Duration("outbound-dial-timeout", DefaultOutboundClientOptions.DialTimeout, "Timeout for establishing outbound connections")
+	var outboundTimeout = flags.Duration("outbound-timeout", DefaultOutboundClientOptions.Timeout, "Default deadline for a complete call to an outbound backend")
+	var loadShedTargetLatency = flags.Duration("load-shed-target-latency", 0, "Handler latency above which the adaptive concurrency limit is reduced and excess requests are shed. Disabled if 0")
+	var loadShedMode = flags.String("load-shed-mode", string(LoadShedNoOpinion), "Response to shed requests. Values: [no-opinion, unavailable]")
+	var loadShedMinConcurrency = flags.Int("load-shed-min-concurrency", 4, "Lower bound of the adaptive concurrency limit")
+	var loadShedMaxConcurrency = flags.Int("load-shed-max-concurrency", 256, "Upper bound and initial value of the adaptive concurrency limit")
+	var decisionCacheSize = flags.Int("decision-cache-size", 0, "Number of decisions cached, keyed on the SubjectAccessReview spec. Disabled if 0")
+	var decisionCacheTTL = flags.Duration("decision-cache-ttl", 10*time.Second, "Time for which cached decisions are reused")
+	var decisionCacheLongTTL = flags.Duration("decision-cache-long-ttl", 5*time.Minute, "Time for which decisions of rules hinted long by --cache-hints are reused, and may be cached by callers as advertised in Cache-Control. At least --decision-cache-ttl")
+	var cacheHintsCSL = flags.String("cache-hints", "", "Comma separated list of RULE=HINT, giving how long decisions made by each rule may be cached: 'long' for --decision-cache-long-ttl, 'none' never to cache them, e.g. default-allow=long,protected-namespace-write=none. Name rules may give their own with cache")
+	var decisionCacheFile = flags.String("decision-cache-file", "", "File the decision cache is saved to on shutdown and loaded from on startup. Not persisted if empty")
+	var profilingServerURL = flags.String("profiling-server-url", "", "Base URL of Pyroscope compatible server to push CPU and heap profiles to. Disabled if empty")
+	var profilingInterval = flags.Duration("profiling-interval", time.Minute, "Time between consecutive profile pushes")
+	var profilingCPUDuration = flags.Duration("profiling-cpu-duration", 10*time.Second, "Length of each pushed CPU profile, must be shorter than the interval")
+	var profilingLabelsCSL = flags.String("profiling-labels", "", "Comma separated key=value labels attached to pushed profiles, e.g. cluster=prod-1")
+	var denyReasonHelp = flags.String("deny-reason-help", "", "Text appended to the reasons of denials telling users where to get help, e.g. a URL, email address or ticket queue. Nothing is appended if empty")
+	var denyReasonReferences = flags.Bool("deny-reason-references", false, "Append the SubjectAccessReview's UID and the policy generation to the reasons of denials, so users reporting one give operators what finds its decision record. Leave disabled where UIDs are considered sensitive, or clients match on reasons")
+	var enablePprofEndpoints = flags.Bool("enable-pprof-endpoints", false, "Serve net/http/pprof endpoints under /debug/pprof/ on the admin listener for pull based profilers such as Parca. Requires --admin-token-auth-file or --admin-client-ca-file")
+	var tokenAuthFile = flags.String("token-auth-file", "", "CSV file of static tokens accepted by /authenticate, in kube-apiserver --token-auth-file format")
+	var oidcIntrospectionURL = flags.String("oidc-introspection-url", "", "OAuth 2.0 token introspection endpoint used by /authenticate to validate OIDC tokens")
+	var oidcClientID = flags.String("oidc-client-id", "", "Client ID used to authenticate to the token introspection endpoint")
+	var oidcClientSecretFile = flags.String("oidc-client-secret-file", "", "File containing the client secret used to authenticate to the token introspection endpoint")
+	var oidcUsernameClaim = flags.String("oidc-username-claim", "sub", "Introspection response claim used as the username")
+	var oidcUsernamePrefix = flags.String("oidc-username-prefix", "", "Prefix added to usernames from OIDC tokens")
+	var oidcGroupsClaim = flags.String("oidc-groups-claim", "", "Introspection response claim holding the user's groups")
+	var oidcGroupsPrefix = flags.String("oidc-groups-prefix", "", "Prefix added to groups from OIDC tokens")
+	var keystoneURL = flags.String("keystone-url", "", "Keystone identity v3 endpoint used by /authenticate to validate OpenStack tokens, e.g. https://keystone:5000/v3")
+	var delegateURL = flags.String("delegate-url", "", "URL of upstream authorization webhook consulted for requests this webhook doesn't deny. Disabled if empty")
+	var delegateCAFile = flags.String("delegate-ca-file", "", "CA bundle used to verify the upstream authorization webhook, system roots if empty")
+	var delegateTokenFile = flags.String("delegate-token-file", "", "File containing bearer token sent to the upstream authorization webhook")
+	var delegateTimeout = flags.Duration("delegate-timeout", 2*time.Second, "Timeout for upstream authorization webhook calls")
+	var evaluationFailurePolicy = flags.String("evaluation-failure-policy", string(server.FailNoOpinion), "Decision when evaluating a request fails without a verdict, e.g. as a backend timed out or the webhook panicked. Values: [no-opinion, deny]")
+	var malformedRequestPolicy = flags.String("malformed-request-policy", string(server.RejectMalformed), "Response to SubjectAccessReviews which can't be decoded or aren't supported. Values: [reject, deny]")
+	var delegateFailurePolicy = flags.String("delegate-failure-policy", string(DelegateFailNoOpinion), "Decision when the upstream authorization webhook fails. Values: [no-opinion, deny]")
+	var managementKubeconfig = flags.String("management-kubeconfig", "", "Kubeconfig for a management cluster whose SubjectAccessReview API is consulted for requests this webhook doesn't deny. Disabled if empty")
+	var managementContext = flags.String("management-context", "", "Context to use from the management cluster kubeconfig, current context if empty")
+	var capiKubeconfig = flags.String("capi-kubeconfig", "", "Kubeconfig for the management cluster whose CAPI Cluster objects identify calling clusters. Disabled if empty")
+	var capiContext = flags.String("capi-context", "", "Context to use from the CAPI kubeconfig, current context if empty")
+	var capiLabelsCSL = flags.String("capi-labels", "", "Comma separated name=label-key pairs of CAPI Cluster labels included in logs and audit events, e.g. tenant=example.com/tenant")
+	var fleetKubeconfig = flags.String("fleet-kubeconfig", "", "Kubeconfig for the management cluster whose ClusterAuthorization resources declare the workload clusters served in fleet mode. Disabled if empty")
+	var fleetContext = flags.String("fleet-context", "", "Context to use from the fleet kubeconfig, current context if empty")
+	var fleetKubeconfigServerURL = flags.String("fleet-kubeconfig-server-url", "", "URL workload clusters reach the webhook at. If set, the fleet leader generates each ClusterAuthorization's token if its secret doesn't exist and writes a webhook kubeconfig secret for it. Requires --fleet-leader-election-lease")
+	var fleetKubeconfigCAFile = flags.String("fleet-kubeconfig-ca-file", "", "CA bundle embedded in generated webhook kubeconfigs, for workload clusters to verify the webhook with. System roots if empty")
+	var fleetTokenRotationPeriod = flags.Duration("fleet-token-rotation-period", 720*time.Hour, "Age at which tokens generated for ClusterAuthorizations are replaced. The previous token is accepted until the next rotation. Never rotated if 0")
+	var fleetLeaderElectionLease = flags.String("fleet-leader-election-lease", "", "Lease on the fleet management cluster, as namespace/name, electing the replica which writes ClusterAuthorization statuses. Statuses aren't written if empty")
+	var fleetLeaderElectionLeaseDuration = flags.Duration("fleet-leader-election-lease-duration", 15*time.Second, "Time after the leader last renewed the lease before another replica can take over")
+	var fleetNamespace = flags.String("fleet-namespace", "", "Namespace to watch ClusterAuthorization resources in, all namespaces if empty")
+	var clusterRateLimit = flags.Float64("cluster-rate-limit", 0, "Authorization requests per second allowed from each identified cluster, callers that aren't identified sharing one limit. Unlimited if 0")
+	var clusterRateLimitBurst = flags.Int("cluster-rate-limit-burst", 0, "Requests a cluster can make at once after being idle, its rate rounded up if 0")
+	var clusterRateLimitOverridesCSL = flags.String("cluster-rate-limit-overrides", "", "Comma separated namespace/name=rate pairs replacing --cluster-rate-limit for particular clusters, e.g. az-tenant-a/big=500")
+	var clusterRateLimitMode = flags.String("cluster-rate-limit-mode", string(RateLimitNoOpinion), "Response to requests over their cluster's rate limit. Values: [no-opinion, too-many-requests]")
+	var clientCertSubjectHeader = flags.String("client-cert-subject-header", "", "Header carrying the client certificate subject DN from a TLS terminating proxy, used to identify clusters")
+	var tenancyURL = flags.String("tenancy-url", "", "Azimuth endpoint listing the tenancies and namespaces a user belongs to. Tenancy checks are disabled if empty")
+	var tenancyTokenFile = flags.String("tenancy-token-file", "", "File containing bearer token sent to the Azimuth tenancy endpoint")
+	var tenancyNamespacesCSL = flags.String("tenancy-namespaces", "az-*", "Comma separated list of namespaces in which writes require tenancy ownership. Entries may be prefixes ending in '*' or glob patterns")
+	var tenancyCacheTTL = flags.Duration("tenancy-cache-ttl", time.Minute, "Time for which a user's tenancy namespaces are cached")
+	var keystonePrivilegedRolesCSL = flags.String("keystone-privileged-roles", "", "Comma separated list of Keystone roles granting privileged status, looked up by the SAR UID at --keystone-url. Disabled if empty")
+	var keystoneAppCredID = flags.String("keystone-application-credential-id", "", "ID of the application credential used to list Keystone role assignments")
+	var keystoneAppCredSecretFile = flags.String("keystone-application-credential-secret-file", "", "File containing the secret of the application credential used to list Keystone role assignments")
+	var keystoneRoleCacheTTL = flags.Duration("keystone-role-cache-ttl", time.Minute, "Time for which a user's Keystone roles are cached")
+	var oidcPrivilegedGroupsCSL = flags.String("oidc-privileged-groups", "", "Comma separated list of OIDC groups, without --oidc-groups-prefix, granting privileged status")
+	var oidcPrivilegedExtrasCSL = flags.String("oidc-privileged-extras", "", "Comma separated key=value list of SAR extras granting privileged status to OIDC users. Keys may be repeated")
+	var oidcIssuer = flags.String("oidc-issuer", "", "If set, OIDC users are only privileged when the extra named by --oidc-issuer-extra-key holds this issuer")
+	var oidcIssuerExtraKey = flags.String("oidc-issuer-extra-key", OIDCIssuerExtraKey, "SAR extra key holding the OIDC issuer")
+	var ldapURL = flags.String("ldap-url", "", "ldap:// or ldaps:// URL of directory server whose groups can grant privileged status. Disabled if empty")
+	var ldapCAFile = flags.String("ldap-ca-file", "", "CA bundle used to verify the LDAP server, system roots if empty")
+	var ldapBindDN = flags.String("ldap-bind-dn", "", "DN the webhook binds to the LDAP server as")
+	var ldapBindPasswordFile = flags.String("ldap-bind-password-file", "", "File containing the password for --ldap-bind-dn")
+	var ldapUserBaseDN = flags.String("ldap-user-base-dn", "", "Base DN searched for user entries")
+	var ldapUserAttribute = flags.String("ldap-user-attribute", "uid", "Attribute matched against the username, e.g. sAMAccountName for Active Directory")
+	var ldapGroupAttribute = flags.String("ldap-group-attribute", "memberOf", "Attribute of user entries listing group DNs")
+	var ldapPrivilegedGroupsCSL = flags.String("ldap-privileged-groups", "", "Comma separated list of LDAP group common names granting privileged status")
+	var ldapTimeout = flags.Duration("ldap-timeout", 2*time.Second, "Timeout for LDAP lookups")
+	var ldapCacheTTL = flags.Duration("ldap-cache-ttl", time.Minute, "Time for which a user's LDAP groups are cached")
+	var enrichmentFile = flags.String("enrichment-file", "", "YAML file listing plugins setting attributes derived from requests, such as their tenant, as extras before they're evaluated. Disabled if empty")
+	var hooksFile = flags.String("hooks-file", "", "YAML file listing external commands and HTTP endpoints consulted for the requests they match. Disabled if empty")
+	var matchConditionsFile = flags.String("match-conditions-file", "", "YAML file listing CEL match conditions, as for kube-apiserver structured authorization. Requests failing any condition get no opinion without being evaluated")
+	var policyOverlaysFile = flags.String("policy-overlays-file", "", "YAML file listing overlays adding to and removing from the policy for the identified clusters they match, applied in order. Disabled if empty")
+	var namedPoliciesFile = flags.String("named-policies-file", "", "YAML file listing policies callers can select by name with --named-policy-header instead of the webhook's own, e.g. a staging policy during a migration. Disabled if empty")
+	var simulationHeader = flags.String("simulation-header", "", "Request header marking /authorize requests as simulations when set to true, e.g. X-Azimuth-Authz-Dry-Run. Simulations are evaluated, logged and audited as usual but answered with no opinion. Must only be settable by trusted callers or routing layers. Disabled if empty")
+	var simulationClustersCSL = flags.String("simulation-clusters", "", "Comma separated patterns matching the namespace/name of identified clusters allowed to send simulations, e.g. az-staging/*. Required with --simulation-header")
+	var tarpitThreshold = flags.Int("tarpit-threshold", 0, "Denials of a user by the same rule within --tarpit-window after which the user is flagged and further denials by the rule are delayed. Disabled if 0")
+	var tarpitWindow = flags.Duration("tarpit-window", time.Minute, "Window in which denials are counted towards --tarpit-threshold")
+	var tarpitDelay = flags.Duration("tarpit-delay", 100*time.Millisecond, "Delay of the first denial past --tarpit-threshold, doubling with each one after")
+	var tarpitMaxDelay = flags.Duration("tarpit-max-delay", 5*time.Second, "Longest delay of a denial. Users past the threshold are only flagged if 0")
+	var tarpitRulesCSL = flags.String("tarpit-rules", "", "Comma separated deny rules whose denials are counted towards --tarpit-threshold. Every deny rule if empty")
+	var namedPolicyHeader = flags.String("named-policy-header", "X-Azimuth-Policy", "Request header selecting a named policy. Must only be settable by trusted callers or routing layers, as each policy's clusters are only checked against the identified cluster")
+	var grpcDecisionService = flags.Bool("grpc-decision-service", false, "Serve the decision engine as the azimuth.authorization.v1.DecisionService gRPC service, enabling unencrypted HTTP/2 on the listener")
+	var extAuthz = flags.Bool("ext-authz", false, "Serve Envoy's external authorization gRPC protocol, enabling unencrypted HTTP/2 on the listener")
+	var extAuthzUserHeader = flags.String("ext-authz-user-header", "x-remote-user", "Request header giving the authenticated user in Envoy external authorization checks")
+	var extAuthzGroupsHeader = flags.String("ext-authz-groups-header", "x-remote-group", "Request header giving comma separated groups in Envoy external authorization checks")
+	var recordCorpus = flags.String("record-corpus", "", "Path of file to append sampled, sanitised SubjectAccessReviews to as JSON lines, for replay and load testing. Disabled if empty")
+	var recordCorpusSampleRate = flags.Float64("record-corpus-sample-rate", 1, "Fraction of SubjectAccessReviews recorded, between 0 and 1")
+	var recordCorpusMaxRecords = flags.Int64("record-corpus-max-records", 0, "Number of SubjectAccessReviews after which recording stops. Unlimited if 0")
+	var recordCorpusPseudonymize = flags.Bool("record-corpus-pseudonymize", false, "Replace users and groups, other than system: ones, with pseudonyms in the recorded corpus")
+	var policySyncURL = flags.String("policy-sync-url", "", "URL of central policy service to fetch signed policy bundles from, replacing the protected namespaces and privileged users flags. Disabled if empty")
+	var policySyncOCIRef = flags.String("policy-sync-oci-ref", "", "OCI registry reference to pull signed policy bundles from instead of --policy-sync-url, as REGISTRY/REPOSITORY:TAG or REGISTRY/REPOSITORY@sha256:DIGEST. Disabled if empty")
+	var policySyncOCICredentialsFile = flags.String("policy-sync-oci-credentials-file", "", "File containing USERNAME:PASSWORD used to authenticate to the OCI registry, anonymous if empty")
+	var policySyncCAFile = flags.String("policy-sync-ca-file", "", "CA bundle used to verify the central policy service, system roots if empty")
+	var policySyncTokenFile = flags.String("policy-sync-token-file", "", "File containing bearer token sent to the central policy service")
+	var policySyncPublicKeyFile = flags.String("policy-sync-public-key-file", "", "PEM encoded Ed25519 public keys policy bundles must be signed with one of. Required with --policy-sync-url")
+	var policySyncInterval = flags.Duration("policy-sync-interval", time.Minute, "Interval between policy bundle fetches")
+	var policyFailStatic = flags.Bool("policy-fail-static", false, "Keep serving the last-known-good policy and stay ready while policy syncs fail, rejecting bundles which fail the self-test")
+	var mirrorURL = flags.String("mirror-url", "", "URL of secondary authorization webhook sent every SubjectAccessReview for comparison. Its decisions are never used. Disabled if empty")
+	var mirrorCAFile = flags.String("mirror-ca-file", "", "CA bundle used to verify the mirror webhook, system roots if empty")
+	var mirrorTokenFile = flags.String("mirror-token-file", "", "File containing bearer token sent to the mirror webhook")
+	var mirrorTimeout = flags.Duration("mirror-timeout", 2*time.Second, "Timeout for mirror webhook calls")
+	var mirrorMaxInflight = flags.Int("mirror-max-inflight", 64, "Maximum concurrent mirror webhook calls, further comparisons are skipped")
+	if inspect != nil {
+		return inspect(flags)
+	}
+	if err := flags.Parse(args); err != nil {
+		return exitcode.Usage
+	}
+	if flags.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "error: unexpected argument %q\n", flags.Arg(0))
+		return exitcode.Usage
+	}
+	settings, err := config.ApplySettings(flags, config.SettingsOptions{EnvPrefix: SettingsEnvPrefix, FileFlag: "config-file"})
+	if err != nil {
+		log.Printf("error reading settings: %s\n", err)
+		return exitcode.Config
+	}
+	logSettings(settings)
 
 	protectedNamespaces := strings.Split(*protectedNamespacesCSL, ",")
 	additionalPrivilegedUsers := strings.Split(*additionalPrivilegedUsersCSL, ",")
 
-	http.HandleFunc("/authorize", CreateWebhookAuthorizer(protectedNamespaces, additionalPrivilegedUsers, *opinionMode, *logLevel))
-	log.Printf("Server started\n")
-	err := http.ListenAndServe(":8080", nil)
+	outboundOptions := DefaultOutboundClientOptions
+	outboundOptions.MaxIdleConnsPerHost = *outboundMaxIdleConnsPerHost
+	outboundOptions.IdleConnTimeout = *outboundIdleConnTimeout
+	outboundOptions.DialTimeout = *outboundDialTimeout
+	outboundOptions.Timeout = *outboundTimeout
+	outboundClient := NewOutboundClient(outboundOptions)
+
+	auditAzimuthToken, err := readSecretFile(*auditAzimuthTokenFile)
+	if err != nil {
+		log.Printf("error reading Azimuth audit token: %s\n", err)
+		return exitcode.Config
+	}
+	azimuthAudit := AzimuthAuditSinkOptions{
+		URL:          *auditAzimuthURL,
+		BearerToken:  auditAzimuthToken,
+		MaxRetries:   *auditAzimuthMaxRetries,
+		RetryBackoff: *auditAzimuthRetryBackoff,
+	}
+	// Decisions are streamed to the admin interface, so are published even without other audit sinks
+	adminEnabled := *adminTokenAuthFile != "" || *adminClientCAFile != ""
+	var decisionStream *DecisionStream
+	var streamSinks []AuditSink
+	if adminEnabled {
+		decisionStream = NewDecisionStream()
+		streamSinks = append(streamSinks, decisionStream)
+	}
+	if *enablePprofEndpoints && !adminEnabled {
+		log.Println("error configuring profiling: --enable-pprof-endpoints requires --admin-token-auth-file or --admin-client-ca-file")
+		return exitcode.Config
+	}
+	var decisionCorrelator *DecisionCorrelator
+	if *correlationHistorySize > 0 {
+		if !adminEnabled || *correlationWindow <= 0 {
+			log.Println("error configuring correlation: --correlation-history-size requires a positive --correlation-window, and --admin-token-auth-file or --admin-client-ca-file")
+			return exitcode.Config
+		}
+		decisionCorrelator = NewDecisionCorrelator(*correlationHistorySize, *correlationWindow)
+		streamSinks = append(streamSinks, decisionCorrelator)
+	}
+	var decisionReporter *DecisionReporter
+	if *reportRetention > 0 {
+		if err := validateReportGroupBy(*reportGroupBy); err != nil {
+			log.Printf("error configuring reports: %s\n", err)
+			return exitcode.Config
+		}
+		if !adminEnabled {
+			log.Println("error configuring reports: --report-retention requires --admin-token-auth-file or --admin-client-ca-file")
+			return exitcode.Config
+		}
+		decisionReporter = NewDecisionReporter(*reportGroupBy, *reportRetention)
+		streamSinks = append(streamSinks, decisionReporter)
+	}
+	audit, err := createAuditPipeline(*auditFile, *auditLokiURL, azimuthAudit, outboundClient, AuditPipelineOptions{
+		QueueSize:      *auditQueueSize,
+		BatchSize:      *auditBatchSize,
+		FlushInterval:  *auditFlushInterval,
+		OverflowPolicy: AuditOverflowPolicy(*auditOverflowPolicy),
+	}, streamSinks...)
+	if err != nil {
+		log.Printf("error configuring audit: %s\n", err)
+		return exitcode.Config
+	}
+	defer audit.Close()
+
+	mux := http.NewServeMux()
+	policyConfig := policy.Config{
+		ProtectedNamespaces:             protectedNamespaces,
+		AdditionalPrivilegedUsers:       additionalPrivilegedUsers,
+		ClassificationCacheSize:         *classificationCacheSize,
+		ClusterScopedResources:          strings.Split(*clusterScopedResourcesCSL, ","),
+		WildcardRequests:                policy.WildcardPolicy(*wildcardRequests),
+		BulkSecretReads:                 policy.BulkSecretReadPolicy(*bulkSecretReads),
+		PrivilegedServiceAccounts:       strings.Split(*privilegedServiceAccounts, ","),
+		ServiceAccountAllowlist:         policy.ServiceAccountAllowlistMode(*privilegedServiceAccountsMode),
+		DenyImpersonatedProtectedWrites: *denyImpersonatedProtectedWrites,
+		NamespaceDeletionProtection:     *namespaceDeletionProtection,
+		NodeRestriction:                 *nodeRestriction,
+		NamespaceCreationProtection:     *namespaceCreationProtection,
+		ReservedNamespaces:              strings.Split(*reservedNamespacesCSL, ","),
+		DeletionProtectedNamespaces:     strings.Split(*deletionProtectedNamespacesCSL, ","),
+		NamespaceDeleters:               strings.Split(*namespaceDeletersCSL, ","),
+		AdditionalReadonlyVerbs:         strings.Split(*additionalReadonlyVerbsCSL, ","),
+		AdditionalWriteVerbs:            strings.Split(*additionalWriteVerbsCSL, ","),
+		WebhookObjects:                  strings.Split(*webhookObjectsCSL, ","),
+		DisableWebhookProtection:        *disableWebhookProtection,
+	}
+	if err := appendWebhookObjects(&policyConfig, *webhookService, *webhookSecretsCSL); err != nil {
+		log.Printf("error configuring policy: %s\n", err)
+		return exitcode.Policy
+	}
+	cacheHints, err := parseKeyValueList(*cacheHintsCSL)
+	if err != nil {
+		log.Printf("error parsing --cache-hints: %s\n", err)
+		return exitcode.Config
+	}
+	for rule, hint := range cacheHints {
+		if policyConfig.CacheHints == nil {
+			policyConfig.CacheHints = map[string]policy.CacheHint{}
+		}
+		policyConfig.CacheHints[rule] = policy.CacheHint(hint)
+	}
+	if *nameRulesFile != "" {
+		if policyConfig.NameRules, err = config.LoadNameRules(*nameRulesFile); err != nil {
+			log.Printf("error loading name rules: %s\n", err)
+			return exitcode.Policy
+		}
+	}
+	if err := policyConfig.Validate(); err != nil {
+		log.Printf("error configuring policy: %s\n", err)
+		return exitcode.Policy
+	}
+
+	if mode := LoadShedMode(*loadShedMode); mode != LoadShedNoOpinion && mode != LoadShedUnavailable {
+		log.Printf("error configuring load shedding: unknown mode %q\n", mode)
+		return exitcode.Config
+	}
+	loadShedder := NewLoadShedder(LoadShedderOptions{
+		TargetLatency: *loadShedTargetLatency,
+		Mode:          LoadShedMode(*loadShedMode),
+		MinLimit:      *loadShedMinConcurrency,
+		MaxLimit:      *loadShedMaxConcurrency,
+	})
+
+	rateLimiter, err := createClusterRateLimiter(*clusterRateLimit, *clusterRateLimitBurst, *clusterRateLimitOverridesCSL, *clusterRateLimitMode)
+	if err != nil {
+		log.Printf("error configuring cluster rate limits: %s\n", err)
+		return exitcode.Config
+	}
+
+	if *debugDumpDuration <= 0 {
+		log.Printf("error configuring debug signals: --debug-dump-duration must be positive\n")
+		return exitcode.Config
+	}
+	debugToggles := NewDebugToggles(*debugDumpDuration)
+
+	webhookConfig := WebhookConfig{
+		Config:                  policyConfig,
+		CacheLongTTL:            max(*decisionCacheLongTTL, *decisionCacheTTL),
+		RateLimiter:             rateLimiter,
+		OpinionMode:             *opinionMode,
+		LogLevel:                *logLevel,
+		Debug:                   debugToggles,
+		Audit:                   audit,
+		Authorizers:             strings.Split(*authorizersCSL, ","),
+		EvaluationFailurePolicy: server.FailurePolicy(*evaluationFailurePolicy),
+		MalformedRequestPolicy:  server.MalformedRequestPolicy(*malformedRequestPolicy),
+		DenyReasonReferences:    *denyReasonReferences,
+		DenyReasonHelp:          *denyReasonHelp,
+		APIVersions:             strings.Split(*acceptedAPIVersionsCSL, ","),
+		Limits: policy.Limits{
+			MaxGroups:      *maxGroups,
+			MaxExtraKeys:   *maxExtraKeys,
+			MaxExtraValues: *maxExtraValues,
+			MaxFieldLength: *maxFieldLength,
+			TruncateGroups: *truncateGroups,
+		},
+	}
+	if err := policy.ValidateAPIVersions(webhookConfig.APIVersions); err != nil {
+		log.Printf("error configuring accepted apiVersions: %s\n", err)
+		return exitcode.Config
+	}
+	if webhookConfig.EvaluationFailurePolicy != server.FailNoOpinion && webhookConfig.EvaluationFailurePolicy != server.FailDeny {
+		log.Printf("error configuring evaluation: unknown failure policy %q\n", *evaluationFailurePolicy)
+		return exitcode.Config
+	}
+	if webhookConfig.MalformedRequestPolicy != server.RejectMalformed && webhookConfig.MalformedRequestPolicy != server.DenyMalformed {
+		log.Printf("error configuring evaluation: unknown malformed request policy %q\n", *malformedRequestPolicy)
+		return exitcode.Config
+	}
+	if err := validateAuthorizerNames(webhookConfig.Authorizers); err != nil {
+		log.Println("error configuring authorizers:", err)
+		return exitcode.Config
+	}
+	if *delegateURL != "" && *managementKubeconfig != "" {
+		log.Println("error configuring delegation: --delegate-url and --management-kubeconfig are mutually exclusive")
+		return exitcode.Config
+	}
+	if *managementKubeconfig != "" {
+		webhookConfig.Delegate, err = createManagementClusterDelegate(*managementKubeconfig, *managementContext, *delegateTimeout, DelegateFailurePolicy(*delegateFailurePolicy), outboundClient)
+		if err != nil {
+			log.Printf("error configuring delegation: %s\n", err)
+			return exitcode.Config
+		}
+	}
+	if *delegateURL != "" {
+		webhookConfig.Delegate, err = createUpstreamDelegate(*delegateURL, *delegateCAFile, *delegateTokenFile, *delegateTimeout, DelegateFailurePolicy(*delegateFailurePolicy), outboundClient)
+		if err != nil {
+			log.Printf("error configuring delegation: %s\n", err)
+			return exitcode.Config
+		}
+	}
+	// Roles are looked up for enrichment plugins even if none are privileged
+	var keystoneRoles *KeystoneRoleResolver
+	if *keystonePrivilegedRolesCSL != "" || (*keystoneURL != "" && *keystoneAppCredID != "") {
+		keystoneRoles, err = createKeystoneRoleResolver(*keystoneURL, *keystoneAppCredID, *keystoneAppCredSecretFile, strings.Split(*keystonePrivilegedRolesCSL, ","), *keystoneRoleCacheTTL, outboundClient)
+		if err != nil {
+			log.Printf("error configuring Keystone role lookup: %s\n", err)
+			return exitcode.Config
+		}
+	}
+	if *keystonePrivilegedRolesCSL != "" {
+		webhookConfig.Privileges = append(webhookConfig.Privileges, keystoneRoles)
+	}
+	if *oidcPrivilegedGroupsCSL != "" || *oidcPrivilegedExtrasCSL != "" {
+		privilegedExtras, err := parseMultiValueList(*oidcPrivilegedExtrasCSL)
+		if err != nil {
+			log.Printf("error configuring OIDC privileges: %s\n", err)
+			return exitcode.Config
+		}
+		webhookConfig.Privileges = append(webhookConfig.Privileges, NewOIDCClaimResolver(OIDCClaimResolverOptions{
+			UsernamePrefix:   *oidcUsernamePrefix,
+			GroupsPrefix:     *oidcGroupsPrefix,
+			PrivilegedGroups: strings.Split(*oidcPrivilegedGroupsCSL, ","),
+			PrivilegedExtras: privilegedExtras,
+			Issuer:           *oidcIssuer,
+			IssuerExtraKey:   *oidcIssuerExtraKey,
+		}))
+	}
+	if *ldapURL != "" {
+		options := LDAPGroupResolverOptions{
+			URL:              *ldapURL,
+			BindDN:           *ldapBindDN,
+			UserBaseDN:       *ldapUserBaseDN,
+			UserAttribute:    *ldapUserAttribute,
+			GroupAttribute:   *ldapGroupAttribute,
+			PrivilegedGroups: strings.Split(*ldapPrivilegedGroupsCSL, ","),
+			Timeout:          *ldapTimeout,
+			CacheTTL:         *ldapCacheTTL,
+		}
+		options.BindPassword, err = readSecretFile(*ldapBindPasswordFile)
+		if err == nil && *ldapCAFile != "" {
+			options.TLSConfig, err = tlsConfigWithCA(*ldapCAFile)
+		}
+		if err != nil {
+			log.Printf("error configuring LDAP group lookup: %s\n", err)
+			return exitcode.Config
+		}
+		webhookConfig.Privileges = append(webhookConfig.Privileges, NewLDAPGroupResolver(options))
+	}
+	if *enrichmentFile != "" {
+		webhookConfig.Enrichment, err = LoadEnrichment(*enrichmentFile, keystoneRoles)
+		if err != nil {
+			log.Printf("error loading enrichment plugins: %s\n", err)
+			return exitcode.Config
+		}
+	}
+	if *hooksFile != "" {
+		webhookConfig.Hooks, err = LoadHooks(*hooksFile, outboundClient)
+		if err != nil {
+			log.Printf("error loading hooks: %s\n", err)
+			return exitcode.Config
+		}
+	}
+	if *matchConditionsFile != "" {
+		webhookConfig.MatchConditions, err = LoadMatchConditions(*matchConditionsFile)
+		if err != nil {
+			log.Printf("error loading match conditions: %s\n", err)
+			return exitcode.Config
+		}
+	}
+	if *policyOverlaysFile != "" {
+		if webhookConfig.Overlays, err = config.LoadOverlays(*policyOverlaysFile); err == nil {
+			err = validateOverlays(policyConfig, webhookConfig.Overlays)
+		}
+		if err != nil {
+			log.Printf("error loading policy overlays: %s\n", err)
+			return exitcode.Policy
+		}
+	}
+	if *namedPoliciesFile != "" {
+		webhookConfig.NamedPolicies, err = LoadNamedPolicies(*namedPoliciesFile, *namedPolicyHeader)
+		if err != nil {
+			log.Printf("error loading named policies: %s\n", err)
+			return exitcode.Policy
+		}
+	}
+	if *simulationHeader != "" {
+		webhookConfig.Simulation, err = NewSimulation(*simulationHeader, strings.Split(*simulationClustersCSL, ","))
+		if err != nil {
+			log.Printf("error configuring simulations: %s\n", err)
+			return exitcode.Config
+		}
+	}
+	if *tarpitThreshold > 0 {
+		webhookConfig.Tarpit, err = NewTarpit(TarpitOptions{
+			Threshold: *tarpitThreshold,
+			Window:    *tarpitWindow,
+			Delay:     *tarpitDelay,
+			MaxDelay:  *tarpitMaxDelay,
+			Rules:     strings.Split(*tarpitRulesCSL, ","),
+		})
+		if err != nil {
+			log.Printf("error configuring tarpit: %s\n", err)
+			return exitcode.Config
+		}
+	}
+	if *mirrorURL != "" {
+		webhookConfig.Mirror, err = createMirrorWebhook(*mirrorURL, *mirrorCAFile, *mirrorTokenFile, *mirrorTimeout, *mirrorMaxInflight, outboundClient)
+		if err != nil {
+			log.Printf("error configuring mirror webhook: %s\n", err)
+			return exitcode.Config
+		}
+	}
+	if *tenancyURL != "" {
+		webhookConfig.Tenancy, err = createTenancyResolver(*tenancyURL, *tenancyTokenFile, strings.Split(*tenancyNamespacesCSL, ","), *tenancyCacheTTL, outboundClient)
+		if err != nil {
+			log.Printf("error configuring tenancy checks: %s\n", err)
+			return exitcode.Config
+		}
+	}
+	if *capiKubeconfig != "" {
+		webhookConfig.Clusters, err = createClusterRegistry(*capiKubeconfig, *capiContext, *capiLabelsCSL, *clientCertSubjectHeader, outboundClient)
+		if err != nil {
+			log.Printf("error configuring CAPI cluster identification: %s\n", err)
+			return exitcode.Config
+		}
+	}
+	if webhookConfig.Simulation != nil && webhookConfig.Clusters == nil {
+		log.Println("error configuring simulations: --simulation-header requires --capi-kubeconfig, to identify the clusters allowed to send them")
+		return exitcode.Config
+	}
+	if *recordCorpus != "" {
+		if *recordCorpusSampleRate < 0 || *recordCorpusSampleRate > 1 {
+			log.Printf("error configuring corpus recording: sample rate must be between 0 and 1\n")
+			return exitcode.Config
+		}
+		webhookConfig.Corpus, err = NewCorpusRecorder(*recordCorpus, CorpusRecorderOptions{
+			SampleRate:   *recordCorpusSampleRate,
+			MaxRecords:   *recordCorpusMaxRecords,
+			Pseudonymize: *recordCorpusPseudonymize,
+		})
+		if err != nil {
+			log.Printf("error configuring corpus recording: %s\n", err)
+			return exitcode.Config
+		}
+		defer webhookConfig.Corpus.Close()
+	}
+	var adminGrants *PrivilegeGrants
+	if adminEnabled {
+		adminGrants, err = LoadPrivilegeGrants(*adminPrivilegesFile, audit, func() {
+			// Decisions made before the change may have been made with, or without, the privileges changed
+			webhookConfig.DecisionCache.Reset(HashDecisionInputs(webhookConfig))
+		})
+		if err != nil {
+			log.Printf("error loading admin privilege grants: %s\n", err)
+			return exitcode.Config
+		}
+		webhookConfig.Privileges = append(webhookConfig.Privileges, adminGrants)
+	}
+	webhookConfig.Policy = policy.NewSource(policyConfig)
+	Metrics.NewGaugeFunc("azimuth_authz_policy_generation", "Generation of the policy in effect, incremented each time it's replaced",
+		func() float64 { return float64(webhookConfig.Policy.Current().Generation()) })
+	Metrics.NewInfoFunc("azimuth_authz_policy_info", "Hash and generation of the policy in effect",
+		func() []string {
+			current := webhookConfig.Policy.Current()
+			return []string{HashPolicy(current.Config()), strconv.FormatUint(current.Generation(), 10)}
+		}, "hash", "generation")
+	selfTest := NewSelfTest()
+	var adminNamespaces *NamespaceOverrideStore
+	if adminEnabled {
+		adminNamespaces, err = LoadNamespaceOverrides(*adminNamespacesFile, webhookConfig.Policy, audit, func() {
+			webhookConfig.DecisionCache.Reset(HashDecisionInputs(webhookConfig))
+			selfTest.Run(webhookConfig.Policy.Current().Config(), webhookConfig.Policy.Current())
+		})
+		if err != nil {
+			log.Printf("error loading admin namespace overrides: %s\n", err)
+			return exitcode.Config
+		}
+	}
+	// Created once the namespace overrides are applied, so persisted decisions are only reused if they were
+	// made with the same overrides
+	if *decisionCacheSize > 0 {
+		webhookConfig.DecisionCache = NewDecisionCache(*decisionCacheSize, *decisionCacheTTL, HashDecisionInputs(webhookConfig))
+		webhookConfig.DecisionCache.SetLongTTL(*decisionCacheLongTTL)
+		if *decisionCacheFile != "" {
+			loaded, err := webhookConfig.DecisionCache.Load(*decisionCacheFile)
+			if err != nil {
+				log.Println("Error loading decision cache, starting cold:", err)
+			} else {
+				log.Printf("Loaded %d cached decisions\n", loaded)
+			}
+		}
+	}
+	selfTest.Run(webhookConfig.Policy.Current().Config(), webhookConfig.Policy.Current())
+	var policySync *PolicySync
+	if *policySyncURL != "" || *policySyncOCIRef != "" {
+		policySync, err = createPolicySync(*policySyncURL, *policySyncOCIRef, *policySyncCAFile, *policySyncTokenFile, *policySyncOCICredentialsFile, *policySyncPublicKeyFile, *policySyncInterval, *policyFailStatic, webhookConfig, outboundClient, selfTest)
+		if err != nil {
+			log.Printf("error configuring policy sync: %s\n", err)
+			return exitcode.Config
+		}
+	}
+	mux.Handle("/authorize", server.Chain(http.HandlerFunc(CreateWebhookAuthorizer(webhookConfig)), loadShedder.Wrap))
+	mux.HandleFunc("/admit", CreateAdmissionHandler(webhookConfig))
+	mux.Handle("/authorize/batch", server.Chain(http.HandlerFunc(CreateBatchAuthorizer(webhookConfig, *batchMaxItems, *batchConcurrency)), loadShedder.WrapUnavailable))
+	authenticators, err := createTokenAuthenticators(tokenAuthConfig{
+		tokenFile:            *tokenAuthFile,
+		oidcIntrospectionURL: *oidcIntrospectionURL,
+		oidcClientID:         *oidcClientID,
+		oidcClientSecretFile: *oidcClientSecretFile,
+		oidcUsernameClaim:    *oidcUsernameClaim,
+		oidcUsernamePrefix:   *oidcUsernamePrefix,
+		oidcGroupsClaim:      *oidcGroupsClaim,
+		oidcGroupsPrefix:     *oidcGroupsPrefix,
+		keystoneURL:          *keystoneURL,
+	}, outboundClient)
 	if err != nil {
+		log.Printf("error configuring authentication: %s\n", err)
+		return exitcode.Config
+	}
+	if len(authenticators) > 0 {
+		mux.HandleFunc("/authenticate", CreateWebhookAuthenticator(authenticators, *logLevel))
+	}
+	mux.Handle("/metrics", Metrics.Handler())
+	mux.HandleFunc("/openapi.json", OpenAPIHandler)
+	mux.HandleFunc("/v1/check", CreateAccessCheckHandler(webhookConfig))
+	mux.HandleFunc("/v1/explain", CreateExplainHandler(webhookConfig))
+	var fleet *Fleet
+	if *fleetKubeconfig != "" {
+		conn, err := LoadKubeconfig(*fleetKubeconfig, *fleetContext)
+		if err != nil {
+			log.Printf("error configuring fleet mode: %s\n", err)
+			return exitcode.Config
+		}
+		options := FleetOptions{Namespace: *fleetNamespace}
+		if *fleetLeaderElectionLease != "" {
+			options.Elector, err = createFleetElector(conn, outboundClient, *fleetLeaderElectionLease, *fleetLeaderElectionLeaseDuration, func() {
+				fleet.RequestStatusWrite()
+				fleet.RequestKubeconfigWrite()
+			})
+			if err != nil {
+				log.Printf("error configuring fleet leader election: %s\n", err)
+				return exitcode.Config
+			}
+		}
+		if *fleetKubeconfigServerURL != "" {
+			options.Kubeconfigs, err = createFleetKubeconfigOptions(*fleetKubeconfigServerURL, *fleetKubeconfigCAFile, *fleetTokenRotationPeriod, options.Elector != nil)
+			if err != nil {
+				log.Printf("error configuring fleet kubeconfigs: %s\n", err)
+				return exitcode.Config
+			}
+		}
+		fleet = NewFleet(conn, outboundClient, webhookConfig, options)
+		mux.Handle(FleetAuthorizePattern, server.Chain(fleet, loadShedder.Wrap))
+	}
+	readinessChecks := []readinessCheck{{name: "self-test", health: selfTest.health, immediate: true}}
+	if policySync != nil {
+		readinessChecks = append(readinessChecks, readinessCheck{name: "policy-sync", health: policySync.health, detail: policySync.staleDetail})
+	}
+	if webhookConfig.Clusters != nil {
+		readinessChecks = append(readinessChecks, readinessCheck{name: "capi", health: webhookConfig.Clusters.watcher.health})
+	}
+	if fleet != nil {
+		readinessChecks = append(readinessChecks, readinessCheck{name: "fleet", health: fleet.watcher.health})
+	}
+	if webhookConfig.Delegate != nil {
+		readinessChecks = append(readinessChecks, readinessCheck{name: "delegate", health: webhookConfig.Delegate.health})
+	}
+	mux.HandleFunc("/readyz", readinessHandler(readinessChecks, *readinessGracePeriod))
+	if *grpcDecisionService {
+		mux.HandleFunc(DecisionServiceAuthorizePath, CreateDecisionServiceHandler(webhookConfig))
+	}
+	if *extAuthz {
+		mux.HandleFunc(ExtAuthzCheckPath, CreateExtAuthzHandler(webhookConfig, ExtAuthzOptions{
+			UserHeader:   *extAuthzUserHeader,
+			GroupsHeader: *extAuthzGroupsHeader,
+		}))
+	}
+	// Admin and debug endpoints are only served on the admin listener, never on the unauthenticated main one
+	var adminServer *http.Server
+	if adminEnabled {
+		options := AdminServerOptions{
+			Address:      *adminAddress,
+			TLSCertFile:  *adminTLSCertFile,
+			TLSKeyFile:   *adminTLSKeyFile,
+			ClientCAFile: *adminClientCAFile,
+		}
+		if *adminTokenAuthFile != "" {
+			options.Authenticator, err = NewStaticTokenAuthenticator(*adminTokenAuthFile)
+		}
+		adminMux := http.NewServeMux()
+		adminMux.Handle("/admin/", CreateAdminHandler(adminGrants, adminNamespaces, settings))
+		adminMux.Handle("GET /admin/decisions", decisionStream)
+		if decisionReporter != nil {
+			adminMux.Handle("GET /admin/report", decisionReporter)
+		}
+		if decisionCorrelator != nil {
+			adminMux.Handle("GET /admin/decisions/correlated", decisionCorrelator)
+		}
+		if *enablePprofEndpoints {
+			// For pull based continuous profilers such as Parca
+			adminMux.HandleFunc("/debug/pprof/", pprof.Index)
+			adminMux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+			adminMux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+			adminMux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+		}
+		if err == nil {
+			adminServer, err = NewAdminServer(options, adminMux)
+		}
+		if err != nil {
+			log.Printf("error configuring admin interface: %s\n", err)
+			return exitcode.Config
+		}
+		adminServer.RegisterOnShutdown(decisionStream.Close)
+	}
+	if *dryRunMode {
+		var probes []dryRunProbe
+		if policySync != nil {
+			probes = append(probes, dryRunProbe{name: "policy-sync", probe: policySync.Sync})
+		}
+		if webhookConfig.Clusters != nil {
+			probes = append(probes, dryRunProbe{name: "capi", probe: func(ctx context.Context) error {
+				_, err := webhookConfig.Clusters.list(ctx)
+				return err
+			}})
+		}
+		if fleet != nil {
+			probes = append(probes, dryRunProbe{name: "fleet", probe: func(ctx context.Context) error {
+				_, err := fleet.watcher.list(ctx)
+				return err
+			}})
+		}
+		return dryRun(os.Stdout, settings, webhookConfig.Policy.Current().Config(), *opinionMode, probes, selfTest)
+	}
+	profilingLabels, err := parseKeyValueList(*profilingLabelsCSL)
+	if err != nil {
+		log.Printf("error configuring profiling: %s\n", err)
+		return exitcode.Config
+	}
+	server := &http.Server{Addr: ":8080", Handler: mux}
+	if *extAuthz || *grpcDecisionService {
+		// gRPC needs HTTP/2, which Envoy and other in-cluster clients speak without TLS
+		server.Protocols = new(http.Protocols)
+		server.Protocols.SetHTTP1(true)
+		server.Protocols.SetUnencryptedHTTP2(true)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	var adminFailed atomic.Bool
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+		if adminServer != nil {
+			adminServer.Shutdown(shutdownCtx)
+		}
+	}()
+	if adminServer != nil {
+		go func() {
+			if err := serveAdmin(adminServer); err != nil && err != http.ErrServerClosed {
+				// Stops the server too, so serve returns having cleaned up
+				log.Printf("error starting admin server: %s\n", err)
+				adminFailed.Store(true)
+				stop()
+			}
+		}()
+	}
+
+	go webhookConfig.Debug.Run(ctx)
+	if webhookConfig.Clusters != nil {
+		go webhookConfig.Clusters.Run(ctx)
+	}
+	if policySync != nil {
+		go policySync.Run(ctx)
+	}
+	var elected sync.WaitGroup
+	defer func() {
+		stop()
+		elected.Wait()
+	}()
+	if fleet != nil {
+		go fleet.Run(ctx)
+		if fleet.options.Elector != nil {
+			// Waited for on return, so the lease is released before exiting
+			elected.Add(1)
+			go func() {
+				defer elected.Done()
+				fleet.options.Elector.Run(ctx)
+			}()
+		}
+	}
+
+	if *profilingServerURL != "" {
+		profiler := NewProfiler(ProfilerOptions{
+			ServerURL:       *profilingServerURL,
+			ApplicationName: "azimuth-authorization-webhook",
+			Labels:          profilingLabels,
+			Interval:        *profilingInterval,
+			CPUDuration:     *profilingCPUDuration,
+		}, outboundClient)
+		go profiler.Run(ctx)
+	}
+
+	log.Printf("Server started\n")
+	err = server.ListenAndServe()
+	if err != nil && err != http.ErrServerClosed {
 		log.Printf("error starting server: %s\n", err)
-		os.Exit(1)
+		return exitcode.Failure
+	}
+	if webhookConfig.DecisionCache != nil && *decisionCacheFile != "" {
+		if err := webhookConfig.DecisionCache.Save(*decisionCacheFile); err != nil {
+			log.Println("Error saving decision cache:", err)
+		}
+	}
+	if adminFailed.Load() {
+		return exitcode.Failure
 	}
+	return exitcode.OK
 }
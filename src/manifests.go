@@ -0,0 +1,237 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/internal/exitcode"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sigs.k8s.io/yaml"
+	"strings"
+)
+
+// Webhook flags naming files read at startup. Their contents are packed into the generated ConfigMap,
+// or Secret if they hold credentials, and the flags rewritten to where those are mounted
+var (
+	manifestConfigFileFlags = toSet([]string{"match-conditions-file", "delegate-ca-file", "mirror-ca-file", "ldap-ca-file"})
+	manifestSecretFileFlags = toSet([]string{
+		"token-auth-file", "oidc-client-secret-file", "delegate-token-file", "management-kubeconfig", "capi-kubeconfig",
+		"tenancy-token-file", "keystone-application-credential-secret-file", "ldap-bind-password-file", "mirror-token-file",
+	})
+	// Files written by the webhook, kept on an emptyDir volume
+	manifestStateFileFlags = toSet([]string{"audit-file", "decision-cache-file"})
+)
+
+const (
+	manifestConfigDir = "/etc/azimuth-authorization-webhook/config"
+	manifestSecretDir = "/etc/azimuth-authorization-webhook/secrets"
+	manifestStateDir  = "/var/lib/azimuth-authorization-webhook"
+)
+
+// Settings for generated deployment manifests
+type ManifestOptions struct {
+	Name      string
+	Namespace string
+	Image     string
+	Replicas  int
+	// Webhook command line, as name and value of each flag set
+	Args [][2]string
+	// Contents of files packed into the ConfigMap and Secret, by flag name
+	ConfigFiles map[string]string
+	SecretFiles map[string]string
+	// Whether the webhook writes files, which need an emptyDir volume
+	StateFiles bool
+	// Whether the webhook calls outbound backends, so needs egress beyond DNS
+	Outbound bool
+}
+
+// Implements the gen-manifests command, writing Deployment, Service, ConfigMap, Secret and NetworkPolicy
+// manifests running the webhook with the flags after '--', as parsed by webhookFlags. Returns the exit code
+func genManifests(args []string, out io.Writer, webhookFlags *flag.FlagSet) int {
+	flags := flag.NewFlagSet("gen-manifests", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: azimuth-authorization-webhook gen-manifests [options] -- [webhook flags]")
+		flags.PrintDefaults()
+	}
+	name := flags.String("name", "azimuth-authorization-webhook", "Name of generated resources")
+	namespace := flags.String("namespace", "azimuth-authorization-webhook", "Namespace of generated resources")
+	image := flags.String("image", "ghcr.io/azimuth-cloud/azimuth-authorization-webhook:latest", "Webhook container image")
+	replicas := flags.Int("replicas", 2, "Number of webhook replicas")
+	if err := flags.Parse(args); err != nil {
+		return exitcode.Usage
+	}
+	if err := webhookFlags.Parse(flags.Args()); err != nil {
+		return exitcode.Usage
+	}
+	if webhookFlags.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "error: unexpected argument %q\n", webhookFlags.Arg(0))
+		return exitcode.Usage
+	}
+
+	options := ManifestOptions{
+		Name:        *name,
+		Namespace:   *namespace,
+		Image:       *image,
+		Replicas:    *replicas,
+		ConfigFiles: map[string]string{},
+		SecretFiles: map[string]string{},
+	}
+	var err error
+	webhookFlags.Visit(func(f *flag.Flag) {
+		value := f.Value.String()
+		if value != "" && (strings.HasSuffix(f.Name, "-url") || strings.HasSuffix(f.Name, "-kubeconfig")) {
+			options.Outbound = true
+		}
+		switch {
+		case err != nil || value == "":
+		case manifestConfigFileFlags.Has(f.Name), manifestSecretFileFlags.Has(f.Name):
+			var data []byte
+			if data, err = os.ReadFile(value); err != nil {
+				return
+			}
+			if manifestSecretFileFlags.Has(f.Name) {
+				options.SecretFiles[f.Name] = string(data)
+				value = path.Join(manifestSecretDir, f.Name)
+			} else {
+				options.ConfigFiles[f.Name] = string(data)
+				value = path.Join(manifestConfigDir, f.Name)
+			}
+		case manifestStateFileFlags.Has(f.Name) && value != "-":
+			options.StateFiles = true
+			value = path.Join(manifestStateDir, path.Base(value))
+		}
+		options.Args = append(options.Args, [2]string{f.Name, value})
+	})
+	if err != nil {
+		return exitcode.Report(os.Stderr, err)
+	}
+	manifests, err := GenerateManifests(options)
+	if err != nil {
+		return exitcode.Report(os.Stderr, exitcode.Wrap(exitcode.Config, err))
+	}
+	out.Write(manifests)
+	return 0
+}
+
+// Returns multi-document YAML of the resources running the webhook
+func GenerateManifests(options ManifestOptions) ([]byte, error) {
+	labels := map[string]string{"app": options.Name}
+	metadata := func(annotations map[string]string) map[string]any {
+		meta := map[string]any{"name": options.Name, "namespace": options.Namespace, "labels": labels}
+		if len(annotations) > 0 {
+			meta["annotations"] = annotations
+		}
+		return meta
+	}
+	// Metrics are served on the API port
+	scrapeAnnotations := map[string]string{"prometheus.io/scrape": "true", "prometheus.io/port": "8080", "prometheus.io/path": "/metrics"}
+
+	var args []string
+	for _, arg := range options.Args {
+		args = append(args, "--"+arg[0]+"="+arg[1])
+	}
+	container := map[string]any{
+		"name":  "webhook",
+		"image": options.Image,
+		"args":  args,
+		"ports": []any{map[string]any{"name": "http", "containerPort": 8080, "protocol": "TCP"}},
+		"readinessProbe": map[string]any{
+			"httpGet":       map[string]any{"path": "/readyz", "port": "http"},
+			"periodSeconds": 10,
+		},
+		"securityContext": map[string]any{
+			"allowPrivilegeEscalation": false,
+			"readOnlyRootFilesystem":   true,
+			"runAsNonRoot":             true,
+			"runAsUser":                65532,
+			"capabilities":             map[string]any{"drop": []string{"ALL"}},
+		},
+	}
+	var volumes, mounts []any
+	var resources []any
+	if len(options.ConfigFiles) > 0 {
+		volumes = append(volumes, map[string]any{"name": "config", "configMap": map[string]string{"name": options.Name}})
+		mounts = append(mounts, map[string]any{"name": "config", "mountPath": manifestConfigDir, "readOnly": true})
+		resources = append(resources, map[string]any{"apiVersion": "v1", "kind": "ConfigMap", "metadata": metadata(nil), "data": options.ConfigFiles})
+	}
+	if len(options.SecretFiles) > 0 {
+		volumes = append(volumes, map[string]any{"name": "secrets", "secret": map[string]string{"secretName": options.Name}})
+		mounts = append(mounts, map[string]any{"name": "secrets", "mountPath": manifestSecretDir, "readOnly": true})
+		resources = append(resources, map[string]any{"apiVersion": "v1", "kind": "Secret", "metadata": metadata(nil), "stringData": options.SecretFiles})
+	}
+	if options.StateFiles {
+		volumes = append(volumes, map[string]any{"name": "state", "emptyDir": map[string]any{}})
+		mounts = append(mounts, map[string]any{"name": "state", "mountPath": manifestStateDir})
+	}
+	if len(mounts) > 0 {
+		container["volumeMounts"] = mounts
+	}
+	podSpec := map[string]any{"containers": []any{container}}
+	if len(volumes) > 0 {
+		podSpec["volumes"] = volumes
+	}
+
+	resources = append(resources,
+		map[string]any{
+			"apiVersion": "apps/v1",
+			"kind":       "Deployment",
+			"metadata":   metadata(nil),
+			"spec": map[string]any{
+				"replicas": options.Replicas,
+				"selector": map[string]any{"matchLabels": labels},
+				"template": map[string]any{
+					"metadata": map[string]any{"labels": labels, "annotations": scrapeAnnotations},
+					"spec":     podSpec,
+				},
+			},
+		},
+		map[string]any{
+			"apiVersion": "v1",
+			"kind":       "Service",
+			"metadata":   metadata(scrapeAnnotations),
+			"spec": map[string]any{
+				"selector": labels,
+				"ports":    []any{map[string]any{"name": "api", "port": 8080, "targetPort": "http", "protocol": "TCP"}},
+			},
+		},
+		networkPolicy(options, metadata(nil), labels),
+	)
+
+	var manifests []byte
+	for i, resource := range resources {
+		data, err := yaml.Marshal(resource)
+		if err != nil {
+			return nil, err
+		}
+		if i > 0 {
+			manifests = append(manifests, "---\n"...)
+		}
+		manifests = append(manifests, data...)
+	}
+	return manifests, nil
+}
+
+// Admits callers on the API port, which must include kube-apiserver on host networking so can't be
+// narrowed by selector. Egress is limited to DNS unless outbound backends are configured
+func networkPolicy(options ManifestOptions, metadata map[string]any, labels map[string]string) map[string]any {
+	dns := map[string]any{"ports": []any{
+		map[string]any{"port": 53, "protocol": "UDP"},
+		map[string]any{"port": 53, "protocol": "TCP"},
+	}}
+	egress := []any{dns}
+	if options.Outbound {
+		egress = []any{map[string]any{}}
+	}
+	return map[string]any{
+		"apiVersion": "networking.k8s.io/v1",
+		"kind":       "NetworkPolicy",
+		"metadata":   metadata,
+		"spec": map[string]any{
+			"podSelector": map[string]any{"matchLabels": labels},
+			"policyTypes": []string{"Ingress", "Egress"},
+			"ingress":     []any{map[string]any{"ports": []any{map[string]any{"port": 8080, "protocol": "TCP"}}}},
+			"egress":      egress,
+		},
+	}
+}
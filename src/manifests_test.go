@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"sigs.k8s.io/yaml"
+	"strings"
+	"testing"
+)
+
+func TestGeneratedManifestsReflectWebhookFlags(t *testing.T) {
+	dir := t.TempDir()
+	conditionsFile, tokenFile := filepath.Join(dir, "conditions.yaml"), filepath.Join(dir, "token")
+	os.WriteFile(conditionsFile, []byte("- expression: 'true'\n"), 0o600)
+	os.WriteFile(tokenFile, []byte("mirror-token"), 0o600)
+
+	webhookFlags := flag.NewFlagSet("webhook", flag.ContinueOnError)
+	webhookFlags.String("protected-namespaces", "kube-system", "")
+	webhookFlags.String("match-conditions-file", "", "")
+	webhookFlags.String("mirror-url", "", "")
+	webhookFlags.String("mirror-token-file", "", "")
+	webhookFlags.String("audit-file", "", "")
+
+	var out bytes.Buffer
+	code := genManifests([]string{"--namespace", "authz", "--", "--protected-namespaces=kube-system,tenant-*",
+		"--match-conditions-file", conditionsFile, "--mirror-url", "https://mirror", "--mirror-token-file", tokenFile,
+		"--audit-file", "/tmp/audit.log"}, &out, webhookFlags)
+	if code != 0 {
+		t.Fatalf("Expected success, got exit code %d", code)
+	}
+
+	kinds := map[string]map[string]any{}
+	for _, document := range strings.Split(out.String(), "---\n") {
+		var resource map[string]any
+		if err := yaml.Unmarshal([]byte(document), &resource); err != nil {
+			t.Fatal(err)
+		}
+		kinds[resource["kind"].(string)] = resource
+		if namespace := resource["metadata"].(map[string]any)["namespace"]; namespace != "authz" {
+			t.Errorf("Expected %s in namespace authz, got %v", resource["kind"], namespace)
+		}
+	}
+	for _, kind := range []string{"ConfigMap", "Secret", "Deployment", "Service", "NetworkPolicy"} {
+		if kinds[kind] == nil {
+			t.Errorf("Expected a %s", kind)
+		}
+	}
+	if data := kinds["Secret"]["stringData"].(map[string]any); data["mirror-token-file"] != "mirror-token" {
+		t.Errorf("Expected mirror token in Secret, got %v", data)
+	}
+
+	expectedArgs := []string{
+		"--audit-file=/var/lib/azimuth-authorization-webhook/audit.log",
+		"--match-conditions-file=/etc/azimuth-authorization-webhook/config/match-conditions-file",
+		"--mirror-token-file=/etc/azimuth-authorization-webhook/secrets/mirror-token-file",
+		"--mirror-url=https://mirror",
+		"--protected-namespaces=kube-system,tenant-*",
+	}
+	manifests := out.String()
+	for _, arg := range expectedArgs {
+		if !strings.Contains(manifests, arg) {
+			t.Errorf("Expected container argument %s", arg)
+		}
+	}
+	if egress := kinds["NetworkPolicy"]["spec"].(map[string]any)["egress"].([]any); len(egress) != 1 || len(egress[0].(map[string]any)) != 0 {
+		t.Errorf("Expected unrestricted egress for the mirror, got %v", egress)
+	}
+}
+
+func TestGenManifestsRejectsMissingFiles(t *testing.T) {
+	webhookFlags := flag.NewFlagSet("webhook", flag.ContinueOnError)
+	webhookFlags.String("token-auth-file", "", "")
+	if code := genManifests([]string{"--", "--token-auth-file", "/nonexistent"}, &bytes.Buffer{}, webhookFlags); code == 0 {
+		t.Error("Expected missing file to be rejected")
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/config"
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/policy"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sigs.k8s.io/yaml"
+)
+
+var matchConditionResults = Metrics.NewCounterVec("azimuth_authz_match_condition_results_total",
+	"SubjectAccessReviews checked against match conditions, by result", "result")
+
+type compiledMatchCondition struct {
+	name    string
+	program *celProgram
+}
+
+// Pre-filter applied before evaluation, for clusters whose kube-apiserver can't be configured with
+// matchConditions. As there, a request is only evaluated if every condition is true, otherwise the
+// webhook gives no opinion without evaluating it. Conditions see the SubjectAccessReview spec as
+// 'request', with the same field names as the API
+type MatchConditions []compiledMatchCondition
+
+// Compiles conditions, reporting every invalid expression
+func CompileMatchConditions(conditions []config.MatchCondition) (MatchConditions, error) {
+	compiled := MatchConditions{}
+	var errs []string
+	for i, condition := range conditions {
+		if condition.Name == "" {
+			condition.Name = fmt.Sprintf("condition %d", i)
+		}
+		program, err := compileCEL(condition.Expression)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", condition.Name, err))
+			continue
+		}
+		compiled = append(compiled, compiledMatchCondition{name: condition.Name, program: program})
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid match conditions: %v", errs)
+	}
+	return compiled, nil
+}
+
+// Reads and compiles a YAML or JSON list of match conditions
+func LoadMatchConditions(path string) (MatchConditions, error) {
+	conditions, err := readMatchConditions(path)
+	if err != nil {
+		return nil, err
+	}
+	return CompileMatchConditions(conditions)
+}
+
+func readMatchConditions(path string) ([]config.MatchCondition, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var conditions []config.MatchCondition
+	if err := yaml.Unmarshal(data, &conditions); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return conditions, nil
+}
+
+// Returns the name of the first condition sar doesn't satisfy, or empty string if it should be
+// evaluated. Conditions that fail to evaluate don't exclude the request, as skipping evaluation
+// could allow what the policy denies
+func (m MatchConditions) Excludes(sar policy.SubjectAccessReview) string {
+	if len(m) == 0 {
+		return ""
+	}
+	env, err := matchConditionEnv(sar)
+	if err != nil {
+		matchConditionResults.Inc("error")
+		log.Println("Error preparing match condition input:", err)
+		return ""
+	}
+	result := "matched"
+	for _, condition := range m {
+		matched, err := condition.program.EvalBool(env)
+		if err != nil {
+			result = "error"
+			log.Printf("Error evaluating match condition %s: %s\n", condition.name, err)
+			continue
+		}
+		if !matched {
+			matchConditionResults.Inc("excluded")
+			return condition.name
+		}
+	}
+	matchConditionResults.Inc(result)
+	return ""
+}
+
+// Returns CEL environment with the spec as it would be sent to the API, so group aliases are merged
+func matchConditionEnv(sar policy.SubjectAccessReview) (map[string]any, error) {
+	data, err := json.Marshal(toUpstreamSAR(sar).Spec)
+	if err != nil {
+		return nil, err
+	}
+	var request map[string]any
+	if err := json.Unmarshal(data, &request); err != nil {
+		return nil, err
+	}
+	// Omitted fields read as empty, as they do in kube-apiserver
+	for key, empty := range map[string]any{"user": "", "groups": []any{}, "extra": map[string]any{}, "uid": ""} {
+		if _, ok := request[key]; !ok {
+			request[key] = empty
+		}
+	}
+	return map[string]any{"request": request}, nil
+}
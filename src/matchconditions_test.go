@@ -0,0 +1,66 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/config"
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/policy"
+	"testing"
+)
+
+func TestMatchConditionsExcludeOutOfScopeRequests(t *testing.T) {
+	conditions, err := CompileMatchConditions([]config.MatchCondition{
+		{Name: "not-kube-system", Expression: "!has(request.resourceAttributes) || request.resourceAttributes.namespace != 'kube-system'"},
+		// Errors for resource requests, which must then be evaluated rather than excluded
+		{Name: "broken", Expression: "request.nonResourceAttributes.path != '/excluded' || request.user == 'nobody'"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	authorizer := CreateWebhookAuthorizer(WebhookConfig{Config: DefaultPolicyConfig, MatchConditions: conditions})
+	excludedBefore := matchConditionResults.Value("excluded")
+
+	accessTest(t, authorizer, false, tenancySAR("tenant-user", "kube-system", "delete"))
+	accessTest(t, authorizer, true, tenancySAR("tenant-user", "openstack-system", "delete"))
+	if excluded := matchConditionResults.Value("excluded") - excludedBefore; excluded != 1 {
+		t.Errorf("Expected 1 excluded request, got %d", excluded)
+	}
+}
+
+func TestMatchConditionExpressions(t *testing.T) {
+	env, err := matchConditionEnv(policy.SubjectAccessReview{Spec: policy.SubjectAccessReviewSpec{
+		User:   "system:serviceaccount:kube-system:coredns",
+		Groups: []string{"system:serviceaccounts", "system:serviceaccounts:kube-system"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]bool{
+		"request.user.startsWith('system:serviceaccount:kube-system:')":              true,
+		"'system:serviceaccounts:kube-system' in request.groups":                     true,
+		"request.groups.exists(g, g.endsWith(':kube-system'))":                       true,
+		"request.groups.all(g, g.startsWith('system:'))":                             true,
+		"size(request.groups) == 2 && request.groups[0] == 'system:serviceaccounts'": true,
+		"request.user.matches('^system:serviceaccount:[^:]+:coredns$')":              true,
+		"has(request.resourceAttributes)":                                            false,
+		"!('admin' in request.extra)":                                                true,
+		"request.uid == '' ? request.user.contains('coredns') : false":               true,
+		"[1, 2] + [3] == [1, 2, 3] && -1 < 0":                                        true,
+	}
+	for expression, expected := range tests {
+		program, err := compileCEL(expression)
+		if err != nil {
+			t.Errorf("Compiling %q: %s", expression, err)
+			continue
+		}
+		if result, err := program.EvalBool(env); err != nil || result != expected {
+			t.Errorf("Expected %q to be %v, got %v, %v", expression, expected, result, err)
+		}
+	}
+}
+
+func TestInvalidMatchConditionsRejected(t *testing.T) {
+	for _, expression := range []string{"request.user ==", "request.user.startsWith('a'", "'unterminated", "request.user === 'a'", "has(request)"} {
+		if _, err := CompileMatchConditions([]config.MatchCondition{{Expression: expression}}); err == nil {
+			t.Errorf("Expected %q to be rejected", expression)
+		}
+	}
+}
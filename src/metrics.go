@@ -0,0 +1,243 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Minimal Prometheus text-format metrics registry. Kept dependency free so the webhook
+// binary stays small; only the metric types the webhook actually needs are implemented.
+type MetricsRegistry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+type metric interface {
+	name() string
+	write(sb *strings.Builder)
+}
+
+var Metrics = NewMetricsRegistry()
+
+func NewMetricsRegistry() *MetricsRegistry {
+	return &MetricsRegistry{}
+}
+
+// Registers metric, replacing any existing metric of the same name
+func (r *MetricsRegistry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics = slices.DeleteFunc(r.metrics, func(existing metric) bool { return existing.name() == m.name() })
+	r.metrics = append(r.metrics, m)
+}
+
+// Returns HTTP handler serving all registered metrics in Prometheus text exposition format
+func (r *MetricsRegistry) Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		r.mu.Lock()
+		metrics := slices.Clone(r.metrics)
+		r.mu.Unlock()
+		sort.Slice(metrics, func(i, j int) bool { return metrics[i].name() < metrics[j].name() })
+
+		var sb strings.Builder
+		for _, m := range metrics {
+			m.write(&sb)
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.Write([]byte(sb.String()))
+	}
+}
+
+// Counter with a fixed set of label names. Label values are supplied per increment
+type CounterVec struct {
+	metricName string
+	help       string
+	labelNames []string
+	values     sync.Map // joined label values -> *atomic.Uint64
+}
+
+func (r *MetricsRegistry) NewCounterVec(name string, help string, labelNames ...string) *CounterVec {
+	c := &CounterVec{metricName: name, help: help, labelNames: labelNames}
+	r.register(c)
+	return c
+}
+
+func (c *CounterVec) name() string { return c.metricName }
+
+// Increments the counter for the given label values, which must match the label names in order
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *CounterVec) Add(delta uint64, labelValues ...string) {
+	key := strings.Join(labelValues, "\x00")
+	value, ok := c.values.Load(key)
+	if !ok {
+		value, _ = c.values.LoadOrStore(key, new(atomic.Uint64))
+	}
+	value.(*atomic.Uint64).Add(delta)
+}
+
+// Returns current value of the counter for the given label values
+func (c *CounterVec) Value(labelValues ...string) uint64 {
+	value, ok := c.values.Load(strings.Join(labelValues, "\x00"))
+	if !ok {
+		return 0
+	}
+	return value.(*atomic.Uint64).Load()
+}
+
+func (c *CounterVec) write(sb *strings.Builder) {
+	writeHeader(sb, c.metricName, c.help, "counter")
+	var keys []string
+	c.values.Range(func(key, _ any) bool {
+		keys = append(keys, key.(string))
+		return true
+	})
+	sort.Strings(keys)
+	for _, key := range keys {
+		value, _ := c.values.Load(key)
+		var labelValues []string
+		if len(c.labelNames) > 0 {
+			labelValues = strings.Split(key, "\x00")
+		}
+		fmt.Fprintf(sb, "%s%s %d\n", c.metricName, formatLabels(c.labelNames, labelValues), value.(*atomic.Uint64).Load())
+	}
+}
+
+// Gauge whose value is read from a callback at scrape time
+type GaugeFunc struct {
+	metricName string
+	help       string
+	fn         func() float64
+}
+
+func (r *MetricsRegistry) NewGaugeFunc(name string, help string, fn func() float64) *GaugeFunc {
+	g := &GaugeFunc{metricName: name, help: help, fn: fn}
+	r.register(g)
+	return g
+}
+
+func (g *GaugeFunc) name() string { return g.metricName }
+
+func (g *GaugeFunc) write(sb *strings.Builder) {
+	writeHeader(sb, g.metricName, g.help, "gauge")
+	fmt.Fprintf(sb, "%s %g\n", g.metricName, g.fn())
+}
+
+// Gauge of constant value 1 whose label values are read from a callback at scrape time, for exposing
+// versions and other metadata which can be joined onto other series
+type InfoFunc struct {
+	metricName string
+	help       string
+	labelNames []string
+	fn         func() []string
+}
+
+func (r *MetricsRegistry) NewInfoFunc(name string, help string, fn func() []string, labelNames ...string) *InfoFunc {
+	i := &InfoFunc{metricName: name, help: help, labelNames: labelNames, fn: fn}
+	r.register(i)
+	return i
+}
+
+func (i *InfoFunc) name() string { return i.metricName }
+
+func (i *InfoFunc) write(sb *strings.Builder) {
+	writeHeader(sb, i.metricName, i.help, "gauge")
+	fmt.Fprintf(sb, "%s%s 1\n", i.metricName, formatLabels(i.labelNames, i.fn()))
+}
+
+func writeHeader(sb *strings.Builder, name string, help string, metricType string) {
+	fmt.Fprintf(sb, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+func formatLabels(labelNames []string, labelValues []string) string {
+	if len(labelNames) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(labelNames))
+	for i, labelName := range labelNames {
+		var labelValue string
+		if i < len(labelValues) {
+			labelValue = labelValues[i]
+		}
+		pairs[i] = fmt.Sprintf("%s=%q", labelName, labelValue)
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// Histogram with cumulative buckets and a fixed set of label names
+type HistogramVec struct {
+	metricName string
+	help       string
+	labelNames []string
+	buckets    []float64
+	mu         sync.Mutex
+	series     map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// Default buckets in seconds, tuned for webhook latencies which should stay well under the apiserver timeout
+var DefaultLatencyBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+func (r *MetricsRegistry) NewHistogramVec(name string, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	h := &HistogramVec{metricName: name, help: help, labelNames: labelNames, buckets: buckets, series: map[string]*histogramSeries{}}
+	r.register(h)
+	return h
+}
+
+func (h *HistogramVec) name() string { return h.metricName }
+
+// Records an observation for the given label values, which must match the label names in order
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\x00")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	series, ok := h.series[key]
+	if !ok {
+		series = &histogramSeries{counts: make([]uint64, len(h.buckets))}
+		h.series[key] = series
+	}
+	for i, bound := range h.buckets {
+		if value <= bound {
+			series.counts[i]++
+		}
+	}
+	series.count++
+	series.sum += value
+}
+
+func (h *HistogramVec) write(sb *strings.Builder) {
+	writeHeader(sb, h.metricName, h.help, "histogram")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		series := h.series[key]
+		var labelValues []string
+		if len(h.labelNames) > 0 {
+			labelValues = strings.Split(key, "\x00")
+		}
+		labelNames := append(slices.Clone(h.labelNames), "le")
+		for i, bound := range h.buckets {
+			fmt.Fprintf(sb, "%s_bucket%s %d\n", h.metricName, formatLabels(labelNames, append(slices.Clone(labelValues), fmt.Sprintf("%g", bound))), series.counts[i])
+		}
+		fmt.Fprintf(sb, "%s_bucket%s %d\n", h.metricName, formatLabels(labelNames, append(slices.Clone(labelValues), "+Inf")), series.count)
+		fmt.Fprintf(sb, "%s_sum%s %g\n", h.metricName, formatLabels(h.labelNames, labelValues), series.sum)
+		fmt.Fprintf(sb, "%s_count%s %d\n", h.metricName, formatLabels(h.labelNames, labelValues), series.count)
+	}
+}
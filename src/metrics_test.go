@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestInfoFunc(t *testing.T) {
+	registry := NewMetricsRegistry()
+	hash := "a"
+	registry.NewInfoFunc("test_info", "Test info", func() []string { return []string{hash, "1"} }, "hash", "generation")
+	scrape := func() string {
+		resp := httptest.NewRecorder()
+		registry.Handler()(resp, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		return resp.Body.String()
+	}
+	if body := scrape(); !strings.Contains(body, "# TYPE test_info gauge\ntest_info{hash=\"a\",generation=\"1\"} 1\n") {
+		t.Errorf("Unexpected exposition %q", body)
+	}
+	// Labels are read at scrape time, so changes replace the series
+	hash = "b"
+	if body := scrape(); !strings.Contains(body, `test_info{hash="b",generation="1"} 1`) || strings.Contains(body, `hash="a"`) {
+		t.Errorf("Expected labels to be updated, got %q", body)
+	}
+}
+
+func TestBuildAndPolicyInfo(t *testing.T) {
+	var sb strings.Builder
+	buildInfo.write(&sb)
+	if !strings.Contains(sb.String(), `version="dev"`) || !strings.Contains(sb.String(), `go_version="`+runtime.Version()+`"`) {
+		t.Errorf("Unexpected build info %q", sb.String())
+	}
+
+	config := DefaultPolicyConfig
+	hash := HashPolicy(config)
+	if len(hash) != 16 || HashPolicy(DefaultPolicyConfig) != hash {
+		t.Errorf("Expected stable 16 character hash, got %q", hash)
+	}
+	config.ProtectedNamespaces = append([]string{"openstack-*"}, config.ProtectedNamespaces...)
+	if HashPolicy(config) == hash {
+		t.Error("Expected different policies to have different hashes")
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/policy"
+	"context"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"log"
+	"time"
+)
+
+var mirrorComparisons = Metrics.NewCounterVec("azimuth_authz_mirror_comparisons_total",
+	"Decisions compared with the mirror webhook, by result", "result")
+
+// Sends every SubjectAccessReview to a secondary webhook, such as a new version under test, and
+// compares its decision with ours without ever using it. Comparisons run in the background with
+// bounded concurrency, so a slow mirror can only cause skipped comparisons
+type MirrorWebhook struct {
+	upstream UpstreamDelegate
+	slots    chan struct{}
+}
+
+func NewMirrorWebhook(url string, bearerToken string, timeout time.Duration, maxInflight int, client *OutboundClient) *MirrorWebhook {
+	if maxInflight <= 0 {
+		maxInflight = 64
+	}
+	return &MirrorWebhook{
+		upstream: UpstreamDelegate{URL: url, BearerToken: bearerToken, Timeout: timeout, Client: client},
+		slots:    make(chan struct{}, maxInflight),
+	}
+}
+
+// Compares the mirror's decision for sar with status in the background. Safe to call on a nil mirror
+func (m *MirrorWebhook) Compare(sar policy.SubjectAccessReview, cluster string, status authorizationv1.SubjectAccessReviewStatus) {
+	if m == nil {
+		return
+	}
+	select {
+	case m.slots <- struct{}{}:
+	default:
+		mirrorComparisons.Inc("skipped")
+		return
+	}
+	go func() {
+		defer func() { <-m.slots }()
+		// Detached from the request, which completes without waiting for the mirror
+		mirrored, err := m.upstream.authorize(context.Background(), sar)
+		if err != nil {
+			mirrorComparisons.Inc("error")
+			log.Println("Error querying mirror webhook:", err)
+			return
+		}
+		if policy.DecisionLabel(mirrored) == policy.DecisionLabel(status) {
+			mirrorComparisons.Inc("agree")
+			return
+		}
+		mirrorComparisons.Inc("disagree")
+		log.Printf("Mirror webhook decided %s (%s) for: %s\n", policy.DecisionLabel(mirrored), mirrored.Reason,
+			decisionLogRecord{cluster: cluster, spec: &sar.Spec, status: &status})
+	}()
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"testing"
+	"time"
+)
+
+func TestMirrorDecisionsAreComparedButNotUsed(t *testing.T) {
+	// The mirror denies everything, which must never affect our responses
+	mirror := newTestUpstream(t, authorizationv1.SubjectAccessReviewStatus{Denied: true, Reason: "new version"}, nil)
+	defer mirror.Close()
+	webhook := NewMirrorWebhook(mirror.URL, "", time.Second, 1, NewOutboundClient(DefaultOutboundClientOptions))
+	authorizer := CreateWebhookAuthorizer(WebhookConfig{Config: DefaultPolicyConfig, Mirror: webhook})
+	agreeBefore, disagreeBefore := mirrorComparisons.Value("agree"), mirrorComparisons.Value("disagree")
+
+	accessTest(t, authorizer, false, []byte(unprotectedWriteSAR))
+	waitForMirror(t, webhook)
+	accessTest(t, authorizer, true, tenancySAR("tenant-user", "kube-system", "delete"))
+	waitForMirror(t, webhook)
+
+	if agree := mirrorComparisons.Value("agree") - agreeBefore; agree != 1 {
+		t.Errorf("Expected 1 agreeing comparison, got %d", agree)
+	}
+	if disagree := mirrorComparisons.Value("disagree") - disagreeBefore; disagree != 1 {
+		t.Errorf("Expected 1 disagreeing comparison, got %d", disagree)
+	}
+}
+
+func waitForMirror(t *testing.T, webhook *MirrorWebhook) {
+	// Filling every slot means all in-flight comparisons have finished
+	deadline := time.After(time.Second)
+	for range cap(webhook.slots) {
+		select {
+		case webhook.slots <- struct{}{}:
+		case <-deadline:
+			t.Fatal("Mirror comparison did not finish")
+		}
+	}
+	for range cap(webhook.slots) {
+		<-webhook.slots
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/config"
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/policy"
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/server"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"sigs.k8s.io/yaml"
+)
+
+var namedPolicyRequests = Metrics.NewCounterVec("azimuth_authz_named_policy_requests_total",
+	"Requests selecting a named policy with the named policy header, by policy and result", "policy", "result")
+
+// Policy selectable per request instead of the webhook's own, e.g. a staging policy during a migration
+type NamedPolicyConfig struct {
+	Name string `json:"name"`
+	// Policy file, in the format of --policy-file
+	PolicyFile string `json:"policyFile"`
+	// Patterns matching the namespace/name of identified clusters allowed to select the policy, e.g.
+	// az-staging/*. Required, so callers are always identified before selecting a policy
+	Clusters []string `json:"clusters"`
+}
+
+// Policies selected by name with a trusted request header, so one instance can serve several
+type NamedPolicies struct {
+	header   string
+	policies map[string]*namedPolicy
+}
+
+type namedPolicy struct {
+	source   *policy.Source
+	clusters []string
+}
+
+type namedPolicyKey struct{}
+
+// Returns the name of the policy selected for the request, empty for the webhook's own
+func namedPolicyFrom(ctx context.Context) string {
+	name, _ := ctx.Value(namedPolicyKey{}).(string)
+	return name
+}
+
+// Reads named policies from a YAML file, selected with header
+func LoadNamedPolicies(file string, header string) (*NamedPolicies, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var configs []NamedPolicyConfig
+	if err := yaml.UnmarshalStrict(data, &configs); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", file, err)
+	}
+	return CreateNamedPolicies(configs, header)
+}
+
+func CreateNamedPolicies(configs []NamedPolicyConfig, header string) (*NamedPolicies, error) {
+	if header == "" {
+		return nil, errors.New("named policy header is required")
+	}
+	n := &NamedPolicies{header: header, policies: map[string]*namedPolicy{}}
+	var errs []error
+	for _, namedConfig := range configs {
+		if namedConfig.Name == "" || n.policies[namedConfig.Name] != nil {
+			errs = append(errs, fmt.Errorf("policy names must be unique and not empty, got %q", namedConfig.Name))
+			continue
+		}
+		if len(namedConfig.Clusters) == 0 {
+			errs = append(errs, fmt.Errorf("%s: clusters allowed to select the policy are required", namedConfig.Name))
+		}
+		for _, pattern := range namedConfig.Clusters {
+			if _, err := path.Match(pattern, ""); err != nil {
+				errs = append(errs, fmt.Errorf("%s: invalid cluster pattern %q", namedConfig.Name, pattern))
+			}
+		}
+		policyFile, err := config.LoadPolicyFile(namedConfig.PolicyFile)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", namedConfig.Name, err))
+			continue
+		}
+		n.policies[namedConfig.Name] = &namedPolicy{source: policy.NewSource(policyFile.PolicyConfig()), clusters: namedConfig.Clusters}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, fmt.Errorf("invalid named policies: %w", err)
+	}
+	return n, nil
+}
+
+// Reports whether identity may select the policy. Unidentified callers never may
+func (p *namedPolicy) allows(identity *ClusterIdentity) bool {
+	return clusterPatternsAllow(p.clusters, identity)
+}
+
+// Returns middleware evaluating requests with the named policy their header selects, overriding any
+// policy pinned by earlier middleware. Requests selecting a policy that doesn't exist, or which their
+// cluster may not select, are rejected rather than evaluated with another policy
+func (n *NamedPolicies) Middleware(clusters *ClusterRegistry) server.Middleware {
+	return func(next http.Handler) http.Handler {
+		if n == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			name := r.Header.Get(n.header)
+			if name == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			selected := n.policies[name]
+			if selected == nil {
+				namedPolicyRequests.Inc("", "unknown")
+				server.WriteError(w, http.StatusBadRequest, fmt.Errorf("Unknown policy %q", name))
+				return
+			}
+			if !selected.allows(clusters.Identify(r)) {
+				namedPolicyRequests.Inc(name, "forbidden")
+				server.WriteError(w, http.StatusForbidden, fmt.Errorf("Caller may not select policy %q", name))
+				return
+			}
+			namedPolicyRequests.Inc(name, "selected")
+			ctx := context.WithValue(r.Context(), namedPolicyKey{}, name)
+			next.ServeHTTP(w, r.WithContext(policy.WithSnapshot(ctx, selected.source.Current())))
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/server"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadNamedPolicies(t *testing.T) {
+	dir := t.TempDir()
+	policyPath := filepath.Join(dir, "staging.yaml")
+	os.WriteFile(policyPath, []byte("protectedNamespaces: [kube-system, staging-system]\n"), 0o600)
+	valid := filepath.Join(dir, "valid.yaml")
+	os.WriteFile(valid, []byte("- name: staging\n  policyFile: "+policyPath+"\n  clusters: [az-staging/*]\n"), 0o600)
+	if _, err := LoadNamedPolicies(valid, "X-Azimuth-Policy"); err != nil {
+		t.Fatalf("Unexpected error loading named policies: %s", err)
+	}
+	if _, err := LoadNamedPolicies(valid, ""); err == nil {
+		t.Error("Expected named policies without a header to be rejected")
+	}
+
+	for name, content := range map[string]string{
+		"duplicate":      "- name: staging\n  policyFile: " + policyPath + "\n  clusters: ['*/*']\n- name: staging\n  policyFile: " + policyPath + "\n  clusters: ['*/*']\n",
+		"unnamed":        "- policyFile: " + policyPath + "\n  clusters: ['*/*']\n",
+		"pattern":        "- name: staging\n  policyFile: " + policyPath + "\n  clusters: ['az-[']\n",
+		"policy file":    "- name: staging\n  policyFile: " + filepath.Join(dir, "missing.yaml") + "\n  clusters: ['*/*']\n",
+		"unknown key":    "- name: staging\n  policyFile: " + policyPath + "\n  cluster: [az-staging/*]\n",
+		"no clusters":    "- name: staging\n  policyFile: " + policyPath + "\n",
+		"empty clusters": "- name: staging\n  policyFile: " + policyPath + "\n  clusters: []\n",
+	} {
+		path := filepath.Join(dir, "invalid.yaml")
+		os.WriteFile(path, []byte(content), 0o600)
+		if _, err := LoadNamedPolicies(path, "X-Azimuth-Policy"); err == nil {
+			t.Errorf("Expected named policies with invalid %s to be rejected", name)
+		}
+	}
+}
+
+func TestNamedPolicySelection(t *testing.T) {
+	policyPath := filepath.Join(t.TempDir(), "staging.yaml")
+	os.WriteFile(policyPath, []byte("protectedNamespaces: [staging-system]\n"), 0o600)
+	named, err := CreateNamedPolicies([]NamedPolicyConfig{{Name: "staging", PolicyFile: policyPath, Clusters: []string{"az-staging/*"}}}, "X-Azimuth-Policy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := CreateWebhookAuthorizer(WebhookConfig{Config: DefaultPolicyConfig, NamedPolicies: named, DecisionCache: NewDecisionCache(16, time.Minute, "")})
+	request := func(namespace string, selected string, identity *ClusterIdentity) *httptest.ResponseRecorder {
+		body := `{"apiVersion":"authorization.k8s.io/v1","kind":"SubjectAccessReview","metadata":{"uid":"1"},
+			"spec":{"user":"alice","resourceAttributes":{"namespace":"` + namespace + `","verb":"delete","resource":"pods"}}}`
+		req := httptest.NewRequest(http.MethodPost, "/authorize", strings.NewReader(body))
+		if selected != "" {
+			req.Header.Set("X-Azimuth-Policy", selected)
+		}
+		if identity != nil {
+			req = req.WithContext(withClusterIdentity(req.Context(), identity))
+		}
+		resp := httptest.NewRecorder()
+		handler(resp, req)
+		return resp
+	}
+	denied := func(resp *httptest.ResponseRecorder) bool {
+		var response server.SubjectAccessReviewResponse
+		json.NewDecoder(resp.Body).Decode(&response)
+		return response.Status.Denied
+	}
+	staging := &ClusterIdentity{Namespace: "az-staging", Name: "demo"}
+
+	// Cached decisions of the webhook's own policy aren't reused for requests selecting another
+	if !denied(request("kube-system", "", staging)) {
+		t.Error("Expected write to kube-system to be denied by the webhook's own policy")
+	}
+	if denied(request("kube-system", "staging", staging)) {
+		t.Error("Expected write to kube-system to be allowed by the staging policy")
+	}
+	if !denied(request("staging-system", "staging", staging)) {
+		t.Error("Expected write to staging-system to be denied by the staging policy")
+	}
+
+	before := namedPolicyRequests.Value("staging", "forbidden")
+	if resp := request("kube-system", "staging", &ClusterIdentity{Namespace: "az-prod", Name: "demo"}); resp.Code != http.StatusForbidden {
+		t.Errorf("Expected cluster not allowed to select the policy to be forbidden, got %d", resp.Code)
+	}
+	if resp := request("kube-system", "staging", nil); resp.Code != http.StatusForbidden {
+		t.Errorf("Expected unidentified caller to be forbidden, got %d", resp.Code)
+	}
+	if namedPolicyRequests.Value("staging", "forbidden") != before+2 {
+		t.Error("Expected forbidden selections to be counted")
+	}
+	if resp := request("kube-system", "production", staging); resp.Code != http.StatusBadRequest {
+		t.Errorf("Expected unknown policy to be rejected, got %d", resp.Code)
+	}
+}
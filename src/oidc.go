@@ -0,0 +1,63 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/policy"
+	"context"
+	"slices"
+	"strings"
+)
+
+// Default extra key carrying the issuer of an OIDC user. Set by the OIDC /authenticate backend, and can be
+// set by kube-apiserver's structured authentication configuration with a claimMappings.extra entry
+const OIDCIssuerExtraKey = "authorization.azimuth-cloud.io/oidc-issuer"
+
+type OIDCClaimResolverOptions struct {
+	// Prefixes identifying OIDC usernames and groups, as configured on the authenticator
+	UsernamePrefix string
+	GroupsPrefix   string
+	// Groups, without prefix, granting privileged status
+	PrivilegedGroups []string
+	// Extra key and value pairs granting privileged status, e.g. a role claim mapped into extras
+	PrivilegedExtras map[string][]string
+	// If set, only users whose IssuerExtraKey extra matches are considered
+	Issuer         string
+	IssuerExtraKey string
+}
+
+// Grants privileged status from the OIDC groups and claims already carried in the SubjectAccessReview,
+// so SSO group membership can replace static privileged user lists
+type OIDCClaimResolver struct {
+	options          OIDCClaimResolverOptions
+	privilegedGroups stringSet
+}
+
+func NewOIDCClaimResolver(options OIDCClaimResolverOptions) *OIDCClaimResolver {
+	if options.IssuerExtraKey == "" {
+		options.IssuerExtraKey = OIDCIssuerExtraKey
+	}
+	return &OIDCClaimResolver{options: options, privilegedGroups: toSet(options.PrivilegedGroups)}
+}
+
+func (o *OIDCClaimResolver) Name() string { return "oidc" }
+
+func (o *OIDCClaimResolver) IsPrivileged(_ context.Context, spec *policy.SubjectAccessReviewSpec) (bool, error) {
+	if !strings.HasPrefix(spec.User, o.options.UsernamePrefix) {
+		return false, nil
+	}
+	if o.options.Issuer != "" && !slices.Contains(spec.Extra[o.options.IssuerExtraKey], o.options.Issuer) {
+		return false, nil
+	}
+	for _, group := range spec.Groups {
+		if name, ok := strings.CutPrefix(group, o.options.GroupsPrefix); ok && o.privilegedGroups.Has(name) {
+			return true, nil
+		}
+	}
+	for key, values := range o.options.PrivilegedExtras {
+		for _, value := range spec.Extra[key] {
+			if slices.Contains(values, value) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/policy"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"testing"
+)
+
+func TestOIDCClaimResolver(t *testing.T) {
+	resolver := NewOIDCClaimResolver(OIDCClaimResolverOptions{
+		UsernamePrefix:   "oidc:",
+		GroupsPrefix:     "oidc:",
+		PrivilegedGroups: []string{"platform-admins"},
+		PrivilegedExtras: map[string][]string{"example.com/role": {"admin", "operator"}},
+		Issuer:           "https://idp.example.com",
+	})
+	issuer := map[string]authorizationv1.ExtraValue{OIDCIssuerExtraKey: {"https://idp.example.com"}}
+
+	tests := []struct {
+		name string
+		spec policy.SubjectAccessReviewSpec
+		want bool
+	}{
+		{"privileged group", policy.SubjectAccessReviewSpec{User: "oidc:alice", Groups: []string{"oidc:platform-admins"}, Extra: issuer}, true},
+		{"group without prefix", policy.SubjectAccessReviewSpec{User: "oidc:alice", Groups: []string{"platform-admins"}, Extra: issuer}, false},
+		{"non-OIDC user", policy.SubjectAccessReviewSpec{User: "alice", Groups: []string{"oidc:platform-admins"}, Extra: issuer}, false},
+		{"privileged extra", policy.SubjectAccessReviewSpec{User: "oidc:bob", Extra: map[string]authorizationv1.ExtraValue{
+			OIDCIssuerExtraKey: {"https://idp.example.com"}, "example.com/role": {"viewer", "operator"},
+		}}, true},
+		{"other issuer", policy.SubjectAccessReviewSpec{User: "oidc:alice", Groups: []string{"oidc:platform-admins"}, Extra: map[string]authorizationv1.ExtraValue{
+			OIDCIssuerExtraKey: {"https://other.example.com"},
+		}}, false},
+		{"unprivileged", policy.SubjectAccessReviewSpec{User: "oidc:carol", Groups: []string{"oidc:developers"}, Extra: issuer}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got, _ := resolver.IsPrivileged(t.Context(), &test.spec); got != test.want {
+				t.Errorf("Expected privileged %v, got %v", test.want, got)
+			}
+		})
+	}
+}
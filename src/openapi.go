@@ -0,0 +1,537 @@
+package main
+
+import (
+	"net/http"
+)
+
+// OpenAPI description of the webhook's HTTP endpoints, served at /openapi.json so client tooling and
+// contract tests can be generated from it. Kubernetes API objects are described only as far as the
+// webhook reads or writes them
+const openAPIDocument = `{
+  "openapi": "3.0.3",
+  "info": {
+    "title": "Azimuth Authorization Webhook",
+    "description": "Kubernetes authorization webhook protecting sensitive namespaces",
+    "version": "v1"
+  },
+  "paths": {
+    "/authorize": {
+      "post": {
+        "summary": "Evaluate a SubjectAccessReview",
+        "description": "Kubernetes authorization webhook endpoint. LocalSubjectAccessReview and SelfSubjectAccessReview payloads are also accepted",
+        "operationId": "authorize",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/SubjectAccessReview"}}}},
+        "responses": {
+          "200": {"description": "Decision", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/SubjectAccessReviewResponse"}}}},
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "503": {"description": "Request shed by load shedding in unavailable mode"}
+        }
+      }
+    },
+    "/authorize/batch": {
+      "post": {
+        "summary": "Evaluate a list of SubjectAccessReviews",
+        "description": "For simulation tooling. Decisions are not audited",
+        "operationId": "authorizeBatch",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/BatchAuthorizeRequest"}}}},
+        "responses": {
+          "200": {"description": "Decisions in request order", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/BatchAuthorizeResponse"}}}},
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "413": {"description": "More items than --batch-max-items"}
+        }
+      }
+    },
+    "/admit": {
+      "post": {
+        "summary": "Validate an admission request",
+        "description": "ValidatingAdmissionWebhook endpoint evaluating the equivalent SubjectAccessReview",
+        "operationId": "admit",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/AdmissionReview"}}}},
+        "responses": {
+          "200": {"description": "Admission response", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/AdmissionReview"}}}},
+          "400": {"$ref": "#/components/responses/BadRequest"}
+        }
+      }
+    },
+    "/authenticate": {
+      "post": {
+        "summary": "Authenticate a bearer token",
+        "description": "Kubernetes token authentication webhook endpoint, served when a token authenticator is configured",
+        "operationId": "authenticate",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/TokenReview"}}}},
+        "responses": {
+          "200": {"description": "Authentication result", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/TokenReview"}}}},
+          "400": {"$ref": "#/components/responses/BadRequest"}
+        }
+      }
+    },
+    "/clusters/{namespace}/{name}/authorize": {
+      "post": {
+        "summary": "Evaluate a SubjectAccessReview for a fleet cluster",
+        "description": "Served with --fleet-kubeconfig, using the policy of the named ClusterAuthorization",
+        "operationId": "authorizeFleetCluster",
+        "security": [{"bearerToken": []}],
+        "parameters": [
+          {"name": "namespace", "in": "path", "required": true, "schema": {"type": "string"}},
+          {"name": "name", "in": "path", "required": true, "schema": {"type": "string"}}
+        ],
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/SubjectAccessReview"}}}},
+        "responses": {
+          "200": {"description": "Decision", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/SubjectAccessReviewResponse"}}}},
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "401": {"description": "Token doesn't match the ClusterAuthorization's secret"},
+          "404": {"description": "No ClusterAuthorization is being served with this name"}
+        }
+      }
+    },
+    "/v1/check": {
+      "post": {
+        "summary": "Ask an authorization question",
+        "description": "For Azimuth components that don't speak SubjectAccessReview. Decisions are the same as for the equivalent SubjectAccessReview",
+        "operationId": "check",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/AccessCheckRequest"}}}},
+        "responses": {
+          "200": {"description": "Decision", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/AccessCheckResponse"}}}},
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "405": {"description": "Method other than POST"}
+        }
+      }
+    },
+    "/v1/explain": {
+      "post": {
+        "summary": "Explain how a SubjectAccessReview is decided",
+        "description": "Returns the webhook's decision with a trace of the request's classifications and every policy rule considered. Not audited",
+        "operationId": "explain",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/SubjectAccessReview"}}}},
+        "responses": {
+          "200": {"description": "Decision and trace", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/ExplainResponse"}}}},
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "405": {"description": "Method other than POST"}
+        }
+      }
+    },
+    "/admin/config": {
+      "get": {
+        "summary": "Show the effective settings",
+        "description": "Served on --admin-address. Each flag's value and whether it was set by default, the config file, the environment or the command line",
+        "operationId": "getConfig",
+        "security": [{"bearerToken": []}],
+        "responses": {
+          "200": {"description": "Effective settings", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Setting"}}}}},
+          "401": {"description": "Neither a token listed in --admin-token-auth-file nor a client certificate signed by --admin-client-ca-file"}
+        }
+      }
+    },
+    "/admin/decisions": {
+      "get": {
+        "summary": "Stream decisions as they are made",
+        "description": "Served on --admin-address. Audit events are written as newline delimited JSON until the client disconnects. Clients which fall behind miss events",
+        "operationId": "streamDecisions",
+        "security": [{"bearerToken": []}],
+        "parameters": [
+          {"name": "denied", "in": "query", "description": "Only stream denials", "schema": {"type": "boolean"}}
+        ],
+        "responses": {
+          "200": {"description": "One audit event per line", "content": {"application/x-ndjson": {"schema": {"$ref": "#/components/schemas/AuditEvent"}}}},
+          "401": {"description": "Neither a token listed in --admin-token-auth-file nor a client certificate signed by --admin-client-ca-file"}
+        }
+      }
+    },
+    "/admin/privileges": {
+      "get": {
+        "summary": "List privileges granted at runtime",
+        "description": "Served on --admin-address. Expired grants are not listed",
+        "operationId": "listPrivilegeGrants",
+        "security": [{"bearerToken": []}],
+        "responses": {
+          "200": {"description": "Unexpired grants", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/PrivilegeGrant"}}}}},
+          "401": {"description": "Neither a token listed in --admin-token-auth-file nor a client certificate signed by --admin-client-ca-file"}
+        }
+      },
+      "post": {
+        "summary": "Grant privileged status to a user or group",
+        "description": "Replaces any existing grant to the same subject. Audited and saved to --admin-privileges-file",
+        "operationId": "grantPrivileges",
+        "security": [{"bearerToken": []}],
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/PrivilegeGrantRequest"}}}},
+        "responses": {
+          "201": {"description": "Grant made", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/PrivilegeGrant"}}}},
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "401": {"description": "Neither a token listed in --admin-token-auth-file nor a client certificate signed by --admin-client-ca-file"}
+        }
+      }
+    },
+    "/admin/privileges/{kind}/{name}": {
+      "delete": {
+        "summary": "Revoke privileges granted at runtime",
+        "operationId": "revokePrivileges",
+        "security": [{"bearerToken": []}],
+        "parameters": [
+          {"name": "kind", "in": "path", "required": true, "schema": {"type": "string", "enum": ["user", "group"]}},
+          {"name": "name", "in": "path", "required": true, "schema": {"type": "string"}}
+        ],
+        "responses": {
+          "204": {"description": "Grant revoked"},
+          "401": {"description": "Neither a token listed in --admin-token-auth-file nor a client certificate signed by --admin-client-ca-file"},
+          "404": {"description": "No privileges granted to the subject"}
+        }
+      }
+    },
+    "/admin/protected-namespaces": {
+      "get": {
+        "summary": "List protected namespace overrides",
+        "description": "Served on --admin-address. Includes the protected and exempted entries of the policy in effect",
+        "operationId": "listNamespaceOverrides",
+        "security": [{"bearerToken": []}],
+        "responses": {
+          "200": {"description": "Overrides and entries in effect", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/ProtectedNamespaces"}}}},
+          "401": {"description": "Neither a token listed in --admin-token-auth-file nor a client certificate signed by --admin-client-ca-file"}
+        }
+      }
+    },
+    "/admin/protected-namespaces/{override}/{entry}": {
+      "parameters": [
+        {"name": "override", "in": "path", "required": true, "schema": {"type": "string", "enum": ["protected", "exempted"]}},
+        {"name": "entry", "in": "path", "required": true, "schema": {"type": "string"}, "description": "Namespace or namespace pattern", "example": "openstack-*"}
+      ],
+      "put": {
+        "summary": "Protect a namespace entry, or exempt it from protection",
+        "description": "Replaces any opposite override of the entry. Audited and saved to --admin-namespaces-file",
+        "operationId": "overrideNamespace",
+        "security": [{"bearerToken": []}],
+        "responses": {
+          "204": {"description": "Override made"},
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "401": {"description": "Neither a token listed in --admin-token-auth-file nor a client certificate signed by --admin-client-ca-file"}
+        }
+      },
+      "delete": {
+        "summary": "Remove a namespace override, leaving the entry to the configured policy",
+        "operationId": "removeNamespaceOverride",
+        "security": [{"bearerToken": []}],
+        "responses": {
+          "204": {"description": "Override removed"},
+          "401": {"description": "Neither a token listed in --admin-token-auth-file nor a client certificate signed by --admin-client-ca-file"},
+          "404": {"description": "No such override"}
+        }
+      }
+    },
+    "/metrics": {
+      "get": {
+        "summary": "Prometheus metrics",
+        "operationId": "metrics",
+        "responses": {"200": {"description": "Metrics in the Prometheus text format", "content": {"text/plain": {"schema": {"type": "string"}}}}}
+      }
+    },
+    "/openapi.json": {
+      "get": {
+        "summary": "This document",
+        "operationId": "openapi",
+        "responses": {"200": {"description": "OpenAPI document", "content": {"application/json": {"schema": {"type": "object"}}}}}
+      }
+    },
+    "/debug/pprof/{profile}": {
+      "get": {
+        "summary": "Go runtime profiles",
+        "description": "Served on --admin-address when --enable-pprof-endpoints is set",
+        "operationId": "pprof",
+        "security": [{"bearerToken": []}],
+        "parameters": [{"name": "profile", "in": "path", "required": true, "schema": {"type": "string"}, "example": "heap"}],
+        "responses": {"200": {"description": "Profile", "content": {"application/octet-stream": {"schema": {"type": "string", "format": "binary"}}}}}
+      }
+    }
+  },
+  "components": {
+    "securitySchemes": {
+      "bearerToken": {"type": "http", "scheme": "bearer"}
+    },
+    "responses": {
+      "BadRequest": {"description": "Malformed or unsupported request", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Status"}}}}
+    },
+    "schemas": {
+      "Status": {
+        "type": "object",
+        "description": "metav1.Status describing why the request failed, as kube-apiserver returns",
+        "properties": {
+          "apiVersion": {"type": "string", "enum": ["v1"]},
+          "kind": {"type": "string", "enum": ["Status"]},
+          "status": {"type": "string", "enum": ["Failure"]},
+          "message": {"type": "string"},
+          "reason": {"type": "string", "example": "BadRequest"},
+          "code": {"type": "integer"},
+          "details": {
+            "type": "object",
+            "properties": {
+              "causes": {"type": "array", "items": {"type": "object", "properties": {"reason": {"type": "string"}, "message": {"type": "string"}, "field": {"type": "string"}}}}
+            }
+          }
+        }
+      },
+      "SubjectAccessReview": {
+        "type": "object",
+        "required": ["apiVersion", "kind", "spec"],
+        "properties": {
+          "apiVersion": {"type": "string", "enum": ["authorization.k8s.io/v1"]},
+          "kind": {"type": "string", "enum": ["SubjectAccessReview", "LocalSubjectAccessReview", "SelfSubjectAccessReview"]},
+          "metadata": {"type": "object", "properties": {"namespace": {"type": "string"}}},
+          "spec": {"$ref": "#/components/schemas/SubjectAccessReviewSpec"}
+        }
+      },
+      "SubjectAccessReviewSpec": {
+        "type": "object",
+        "properties": {
+          "user": {"type": "string"},
+          "groups": {"type": "array", "items": {"type": "string"}},
+          "uid": {"type": "string"},
+          "extra": {"type": "object", "additionalProperties": {"type": "array", "items": {"type": "string"}}},
+          "resourceAttributes": {
+            "type": "object",
+            "properties": {
+              "namespace": {"type": "string"},
+              "verb": {"type": "string"},
+              "group": {"type": "string"},
+              "version": {"type": "string"},
+              "resource": {"type": "string"},
+              "subresource": {"type": "string"},
+              "name": {"type": "string"}
+            }
+          },
+          "nonResourceAttributes": {
+            "type": "object",
+            "properties": {"path": {"type": "string"}, "verb": {"type": "string"}}
+          }
+        }
+      },
+      "SubjectAccessReviewStatus": {
+        "type": "object",
+        "description": "Neither allowed nor denied means no opinion",
+        "properties": {
+          "allowed": {"type": "boolean"},
+          "denied": {"type": "boolean"},
+          "reason": {"type": "string"},
+          "evaluationError": {"type": "string"}
+        }
+      },
+      "SubjectAccessReviewResponse": {
+        "type": "object",
+        "properties": {
+          "apiVersion": {"type": "string"},
+          "kind": {"type": "string"},
+          "status": {"$ref": "#/components/schemas/SubjectAccessReviewStatus"}
+        }
+      },
+      "BatchAuthorizeRequest": {
+        "type": "object",
+        "required": ["items"],
+        "properties": {"items": {"type": "array", "items": {"$ref": "#/components/schemas/SubjectAccessReview"}}}
+      },
+      "BatchAuthorizeResponse": {
+        "type": "object",
+        "properties": {
+          "items": {
+            "type": "array",
+            "items": {
+              "allOf": [
+                {"$ref": "#/components/schemas/SubjectAccessReviewResponse"},
+                {"type": "object", "properties": {"error": {"type": "string", "description": "Set instead of a meaningful status if the item couldn't be evaluated"}}}
+              ]
+            }
+          }
+        }
+      },
+      "AccessCheckRequest": {
+        "type": "object",
+        "required": ["subject", "action", "resource"],
+        "properties": {
+          "subject": {
+            "type": "object",
+            "required": ["user"],
+            "properties": {
+              "user": {"type": "string"},
+              "groups": {"type": "array", "items": {"type": "string"}},
+              "extra": {"type": "object", "additionalProperties": {"type": "array", "items": {"type": "string"}}}
+            }
+          },
+          "action": {"type": "string", "example": "get"},
+          "resource": {
+            "type": "object",
+            "description": "Exactly one of type and path must be set",
+            "properties": {
+              "namespace": {"type": "string"},
+              "group": {"type": "string"},
+              "type": {"type": "string", "example": "secrets"},
+              "subresource": {"type": "string"},
+              "name": {"type": "string"},
+              "path": {"type": "string"}
+            }
+          },
+          "context": {"type": "object", "additionalProperties": {"type": "string"}, "description": "Passed to evaluation as extras prefixed with check.azimuth-cloud.io/"}
+        }
+      },
+      "AccessCheckResponse": {
+        "type": "object",
+        "properties": {
+          "allowed": {"type": "boolean", "description": "True unless denied"},
+          "decision": {"type": "string", "enum": ["allowed", "denied", "no-opinion"]},
+          "reason": {"type": "string"}
+        }
+      },
+      "ExplainResponse": {
+        "type": "object",
+        "properties": {
+          "status": {"$ref": "#/components/schemas/SubjectAccessReviewStatus"},
+          "trace": {"$ref": "#/components/schemas/DecisionTrace"}
+        }
+      },
+      "DecisionTrace": {
+        "type": "object",
+        "properties": {
+          "decision": {"type": "string", "enum": ["allowed", "denied", "no-opinion"]},
+          "rule": {"type": "string", "description": "Rule deciding the request"},
+          "reason": {"type": "string"},
+          "protectedBy": {"type": "string"},
+          "privilegedSystemUser": {"type": "boolean"},
+          "verbClass": {"type": "string"},
+          "classifications": {
+            "type": "object",
+            "properties": {
+              "additionalPrivilegedUser": {"type": "boolean"},
+              "privilegedSystemUser": {"type": "boolean"},
+              "protectedNamespace": {"type": "boolean"},
+              "protectedBy": {"type": "string"},
+              "exemptedBy": {"type": "string"},
+              "allNamespaces": {"type": "boolean"},
+              "secret": {"type": "boolean"},
+              "readonlyVerb": {"type": "boolean"},
+              "verbClass": {"type": "string"},
+              "allResources": {"type": "boolean"},
+              "wildcard": {"type": "boolean"},
+              "impersonator": {"type": "string"}
+            }
+          },
+          "rules": {
+            "type": "array",
+            "description": "Every rule in the order they are considered",
+            "items": {
+              "type": "object",
+              "properties": {
+                "rule": {"type": "string"},
+                "description": {"type": "string"},
+                "applies": {"type": "boolean"},
+                "decisive": {"type": "boolean"}
+              }
+            }
+          },
+          "impersonator": {"$ref": "#/components/schemas/DecisionTrace"}
+        }
+      },
+      "PrivilegeGrantRequest": {
+        "type": "object",
+        "required": ["kind", "name"],
+        "properties": {
+          "kind": {"type": "string", "enum": ["user", "group"]},
+          "name": {"type": "string"},
+          "ttl": {"type": "string", "description": "How long the grant lasts, permanent if unset", "example": "1h"},
+          "reason": {"type": "string"}
+        }
+      },
+      "Setting": {
+        "type": "object",
+        "properties": {
+          "name": {"type": "string"},
+          "value": {"type": "string"},
+          "source": {"type": "string", "enum": ["default", "file", "env", "flag"]}
+        }
+      },
+      "AuditEvent": {
+        "type": "object",
+        "properties": {
+          "time": {"type": "string", "format": "date-time"},
+          "cluster": {"type": "string"},
+          "clusterLabels": {"type": "object", "additionalProperties": {"type": "string"}},
+          "user": {"type": "string"},
+          "groups": {"type": "array", "items": {"type": "string"}},
+          "verb": {"type": "string"},
+          "resource": {"type": "string"},
+          "namespace": {"type": "string"},
+          "name": {"type": "string"},
+          "nonResourcePath": {"type": "string"},
+          "allowed": {"type": "boolean"},
+          "denied": {"type": "boolean"},
+          "reason": {"type": "string"},
+          "uid": {"type": "string"},
+          "policyGeneration": {"type": "integer"}
+        }
+      },
+      "PrivilegeGrant": {
+        "type": "object",
+        "properties": {
+          "kind": {"type": "string", "enum": ["user", "group"]},
+          "name": {"type": "string"},
+          "expires": {"type": "string", "format": "date-time"},
+          "reason": {"type": "string"},
+          "grantedBy": {"type": "string"},
+          "granted": {"type": "string", "format": "date-time"}
+        }
+      },
+      "ProtectedNamespaces": {
+        "type": "object",
+        "properties": {
+          "protected": {"type": "array", "items": {"type": "string"}, "description": "Entries protected by overrides"},
+          "exempted": {"type": "array", "items": {"type": "string"}, "description": "Entries exempted from protection by overrides"},
+          "effectiveProtected": {"type": "array", "items": {"type": "string"}},
+          "effectiveExempted": {"type": "array", "items": {"type": "string"}}
+        }
+      },
+      "AdmissionReview": {
+        "type": "object",
+        "description": "admission.k8s.io/v1 AdmissionReview",
+        "properties": {
+          "apiVersion": {"type": "string", "enum": ["admission.k8s.io/v1"]},
+          "kind": {"type": "string", "enum": ["AdmissionReview"]},
+          "request": {"type": "object"},
+          "response": {
+            "type": "object",
+            "properties": {
+              "uid": {"type": "string"},
+              "allowed": {"type": "boolean"},
+              "status": {"type": "object", "properties": {"message": {"type": "string"}, "code": {"type": "integer"}}}
+            }
+          }
+        }
+      },
+      "TokenReview": {
+        "type": "object",
+        "description": "authentication.k8s.io/v1 TokenReview",
+        "properties": {
+          "apiVersion": {"type": "string", "enum": ["authentication.k8s.io/v1"]},
+          "kind": {"type": "string", "enum": ["TokenReview"]},
+          "spec": {"type": "object", "properties": {"token": {"type": "string"}}},
+          "status": {
+            "type": "object",
+            "properties": {
+              "authenticated": {"type": "boolean"},
+              "user": {
+                "type": "object",
+                "properties": {
+                  "username": {"type": "string"},
+                  "uid": {"type": "string"},
+                  "groups": {"type": "array", "items": {"type": "string"}},
+                  "extra": {"type": "object", "additionalProperties": {"type": "array", "items": {"type": "string"}}}
+                }
+              },
+              "error": {"type": "string"}
+            }
+          }
+        }
+      }
+    }
+  }
+}
+`
+
+// Serves the OpenAPI document
+func OpenAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(openAPIDocument))
+}
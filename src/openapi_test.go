@@ -0,0 +1,36 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestOpenAPIDocumentIsValid(t *testing.T) {
+	resp := httptest.NewRecorder()
+	OpenAPIHandler(resp, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	if contentType := resp.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("Expected JSON content type, got %s", contentType)
+	}
+
+	var document struct {
+		Paths      map[string]map[string]any `json:"paths"`
+		Components map[string]map[string]any `json:"components"`
+	}
+	if err := json.Unmarshal(resp.Body.Bytes(), &document); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/authorize", "/authorize/batch", "/admit", "/authenticate", "/v1/check", "/metrics", "/openapi.json"} {
+		if document.Paths[path] == nil {
+			t.Errorf("Expected %s to be documented", path)
+		}
+	}
+	for _, match := range regexp.MustCompile(`"\$ref": "#/components/(\w+)/(\w+)"`).FindAllStringSubmatch(openAPIDocument, -1) {
+		if document.Components[match[1]][match[2]] == nil {
+			t.Errorf("Unresolved reference %s", strings.Trim(match[0], `"`))
+		}
+	}
+}
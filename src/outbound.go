@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Connection pool and timeout settings shared by all outbound backend calls
+type OutboundClientOptions struct {
+	MaxIdleConnsPerHost   int
+	IdleConnTimeout       time.Duration
+	DialTimeout           time.Duration
+	TLSHandshakeTimeout   time.Duration
+	ResponseHeaderTimeout time.Duration
+	// Default deadline for a whole call, including reading the response body
+	Timeout time.Duration
+}
+
+var DefaultOutboundClientOptions = OutboundClientOptions{
+	MaxIdleConnsPerHost:   16,
+	IdleConnTimeout:       90 * time.Second,
+	DialTimeout:           5 * time.Second,
+	TLSHandshakeTimeout:   5 * time.Second,
+	ResponseHeaderTimeout: 10 * time.Second,
+	Timeout:               10 * time.Second,
+}
+
+var outboundRequests = Metrics.NewCounterVec("azimuth_authz_outbound_requests_total",
+	"Requests made to outbound backends", "backend", "result")
+var outboundLatency = Metrics.NewHistogramVec("azimuth_authz_outbound_request_duration_seconds",
+	"Time until response headers were received from outbound backends", DefaultLatencyBuckets, "backend")
+
+// HTTP client used for every remote lookup (audit sinks, delegation, identity backends), so connection
+// reuse, timeouts and metrics are consistent rather than configured ad-hoc per feature
+type OutboundClient struct {
+	client  *http.Client
+	timeout time.Duration
+	options OutboundClientOptions
+}
+
+func NewOutboundClient(options OutboundClientOptions) *OutboundClient {
+	return newOutboundClient(options, nil)
+}
+
+// Returns client with the same pool and timeout settings but its own connection pool using tlsConfig,
+// for backends with a private CA or client certificates
+func (c *OutboundClient) WithTLSConfig(tlsConfig *tls.Config) *OutboundClient {
+	return newOutboundClient(c.options, tlsConfig)
+}
+
+func newOutboundClient(options OutboundClientOptions, tlsConfig *tls.Config) *OutboundClient {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if tlsConfig != nil {
+		transport.TLSClientConfig = tlsConfig
+	}
+	transport.MaxIdleConnsPerHost = options.MaxIdleConnsPerHost
+	transport.IdleConnTimeout = options.IdleConnTimeout
+	transport.TLSHandshakeTimeout = options.TLSHandshakeTimeout
+	transport.ResponseHeaderTimeout = options.ResponseHeaderTimeout
+	transport.DialContext = (&net.Dialer{Timeout: options.DialTimeout, KeepAlive: 30 * time.Second}).DialContext
+	return &OutboundClient{client: &http.Client{Transport: transport}, timeout: options.Timeout, options: options}
+}
+
+// Sends request to the named backend. A timeout of zero uses the client's default. The deadline also
+// covers reading the response body, which must be closed by the caller
+func (c *OutboundClient) Do(backend string, timeout time.Duration, req *http.Request) (*http.Response, error) {
+	if timeout <= 0 {
+		timeout = c.timeout
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), timeout)
+	start := time.Now()
+	resp, err := c.client.Do(req.WithContext(ctx))
+	outboundLatency.Observe(time.Since(start).Seconds(), backend)
+	if err != nil {
+		cancel()
+		outboundRequests.Inc(backend, "error")
+		return nil, err
+	}
+	outboundRequests.Inc(backend, strconv.Itoa(resp.StatusCode/100)+"xx")
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
+
+// Returns TLS config trusting the CA certificates in caFile in place of the system roots
+func tlsConfigWithCA(caFile string) (*tls.Config, error) {
+	caPEM, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("no certificates found in %s", caFile)
+	}
+	return &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}, nil
+}
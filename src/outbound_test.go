@@ -0,0 +1,56 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestOutboundClientRecordsResults(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	client := NewOutboundClient(DefaultOutboundClientOptions)
+	before := outboundRequests.Value("test-ok", "2xx")
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	resp, err := client.Do("test-ok", 0, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if outboundRequests.Value("test-ok", "2xx") != before+1 {
+		t.Error("Expected successful outbound request to be counted")
+	}
+}
+
+func TestOutboundClientPerCallTimeoutCoversBody(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer server.Close()
+
+	client := NewOutboundClient(DefaultOutboundClientOptions)
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	start := time.Now()
+	resp, err := client.Do("test-slow", 50*time.Millisecond, req)
+	if err == nil {
+		_, err = io.ReadAll(resp.Body)
+		resp.Body.Close()
+	}
+	if err == nil {
+		t.Error("Expected slow response body to time out")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Per-call timeout not applied, call took %s", elapsed)
+	}
+}
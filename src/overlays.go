@@ -0,0 +1,118 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/internal/exitcode"
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/config"
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/policy"
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/server"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sigs.k8s.io/yaml"
+	"strings"
+)
+
+var overlaidRequests = Metrics.NewCounterVec("azimuth_authz_overlaid_requests_total",
+	"Requests evaluated with policy overlays, by overlay and result", "overlay", "result")
+
+type overlaysKey struct{}
+
+// Returns the names of the overlays applied to the policy evaluating the request, in the order applied
+func overlaysFrom(ctx context.Context) []string {
+	overlays, _ := ctx.Value(overlaysKey{}).([]string)
+	return overlays
+}
+
+// Returns middleware evaluating requests from identified clusters with the overlays matching them applied
+// to the policy in effect, overriding any policy pinned by earlier middleware. Requests whose overlaid
+// policy is invalid are rejected, as evaluating them with the base policy would drop their cluster's
+// guardrails
+func overlayMiddleware(source *policy.Source, overlays []policy.Overlay, clusters *ClusterRegistry) server.Middleware {
+	return func(next http.Handler) http.Handler {
+		if len(overlays) == 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			identity := clusters.Identify(r)
+			if identity == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			compiled, applied, err := source.Overlaid(overlays, identity.String())
+			if err != nil {
+				for _, name := range applied {
+					overlaidRequests.Inc(name, "error")
+				}
+				log.Printf("Error applying policy overlays for cluster %s: %s\n", identity, err)
+				server.WriteError(w, http.StatusInternalServerError, fmt.Errorf("Invalid policy overlays for cluster %s", identity))
+				return
+			}
+			if len(applied) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			for _, name := range applied {
+				overlaidRequests.Inc(name, "applied")
+			}
+			ctx := context.WithValue(r.Context(), overlaysKey{}, applied)
+			next.ServeHTTP(w, r.WithContext(policy.WithSnapshot(ctx, compiled)))
+		})
+	}
+}
+
+// Checks every overlay, and all of them together, can be applied to base, so mistakes are found when
+// they're loaded rather than by the first request from a cluster they match
+func validateOverlays(base policy.Config, overlays []policy.Overlay) error {
+	for _, overlay := range overlays {
+		if err := overlay.Apply(base).Validate(); err != nil {
+			return fmt.Errorf("overlay %s: %w", overlay.Name, err)
+		}
+	}
+	for _, overlay := range overlays {
+		base = overlay.Apply(base)
+	}
+	if err := base.Validate(); err != nil {
+		return fmt.Errorf("all overlays: %w", err)
+	}
+	return nil
+}
+
+// Prints the policy a cluster is served, the local policy with the overlays matching it applied, as a
+// policy file
+func runRenderPolicy(args []string, out io.Writer) int {
+	flags := flag.NewFlagSet("render-policy", flag.ContinueOnError)
+	overlaysPath := flags.String("overlays-file", "", "YAML file listing policy overlays, as given to --policy-overlays-file. Required")
+	cluster := flags.String("cluster", "", "Cluster to render the policy for, as namespace/name. Required")
+	loadPolicy := addPolicyFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return exitcode.Usage
+	}
+	if *overlaysPath == "" || !strings.Contains(*cluster, "/") {
+		fmt.Fprintln(os.Stderr, "error: --overlays-file and --cluster, as namespace/name, are required")
+		return exitcode.Usage
+	}
+	policyFile, err := loadPolicy()
+	if err != nil {
+		return exitcode.Report(os.Stderr, err)
+	}
+	overlays, err := config.LoadOverlays(*overlaysPath)
+	if err != nil {
+		return exitcode.Report(os.Stderr, policyError(err))
+	}
+	rendered, applied := policyFile.WithOverlays(overlays, *cluster)
+	if err := rendered.PolicyConfig().Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "error: overlays %s: %s\n", strings.Join(applied, ", "), err)
+		return exitcode.Policy
+	}
+	data, err := yaml.Marshal(rendered)
+	if err != nil {
+		return exitcode.Report(os.Stderr, err)
+	}
+	fmt.Fprintf(out, "# Policy for cluster %s with overlays: %s\n", *cluster, dryRunList(applied))
+	out.Write(data)
+	return 0
+}
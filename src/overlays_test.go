@@ -0,0 +1,93 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/policy"
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/server"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPolicyOverlays(t *testing.T) {
+	overlays := []policy.Overlay{{Name: "staging", Clusters: []string{"az-staging/*"}, ProtectedNamespaces: policy.ListPatch{Add: []string{"staging-system"}}}}
+	handler := CreateWebhookAuthorizer(WebhookConfig{Config: DefaultPolicyConfig, Overlays: overlays, DecisionCache: NewDecisionCache(16, time.Minute, "")})
+	denied := func(identity *ClusterIdentity) bool {
+		body := `{"apiVersion":"authorization.k8s.io/v1","kind":"SubjectAccessReview","metadata":{"uid":"1"},
+			"spec":{"user":"alice","resourceAttributes":{"namespace":"staging-system","verb":"delete","resource":"pods"}}}`
+		req := httptest.NewRequest(http.MethodPost, "/authorize", strings.NewReader(body))
+		if identity != nil {
+			req = req.WithContext(withClusterIdentity(req.Context(), identity))
+		}
+		resp := httptest.NewRecorder()
+		handler(resp, req)
+		var response server.SubjectAccessReviewResponse
+		json.NewDecoder(resp.Body).Decode(&response)
+		return response.Status.Denied
+	}
+
+	// Decisions cached for other clusters aren't reused for those with overlays
+	if denied(&ClusterIdentity{Namespace: "az-prod", Name: "demo"}) {
+		t.Error("Expected write to staging-system to be allowed without the staging overlay")
+	}
+	before := overlaidRequests.Value("staging", "applied")
+	if !denied(&ClusterIdentity{Namespace: "az-staging", Name: "demo"}) {
+		t.Error("Expected write to staging-system to be denied with the staging overlay")
+	}
+	if overlaidRequests.Value("staging", "applied") != before+1 {
+		t.Error("Expected overlaid request to be counted")
+	}
+	if denied(nil) {
+		t.Error("Expected overlays not to apply to unidentified callers")
+	}
+}
+
+func TestValidateOverlaysAgainstBase(t *testing.T) {
+	base := policy.Config{ProtectedNamespaces: []string{"kube-system"}, NameRules: []policy.NameRule{{Name: "acme", Effect: policy.NameRuleDeny, Resource: "secrets", ResourceNames: []string{"acme-*"}}}}
+	duplicate := policy.Overlay{Name: "staging", Clusters: []string{"*/*"}, NameRules: policy.NameRulePatch{Add: base.NameRules}}
+	if err := validateOverlays(base, []policy.Overlay{duplicate}); err == nil {
+		t.Error("Expected overlay adding a name rule the base policy has to be rejected")
+	}
+	duplicate.NameRules.Remove = []string{"acme"}
+	if err := validateOverlays(base, []policy.Overlay{duplicate}); err != nil {
+		t.Errorf("Unexpected error replacing a name rule: %s", err)
+	}
+}
+
+func TestRenderPolicyCommand(t *testing.T) {
+	dir := t.TempDir()
+	overlaysPath := filepath.Join(dir, "overlays.yaml")
+	os.WriteFile(overlaysPath, []byte(`- name: staging
+  clusters: [az-staging/*]
+  protectedNamespaces:
+    add: [staging-system]
+    remove: [openstack-system]
+  wildcardRequests: deny
+`), 0o600)
+
+	var out bytes.Buffer
+	args := []string{"--overlays-file", overlaysPath, "--cluster", "az-staging/demo", "--protected-namespaces", "kube-system,openstack-system"}
+	if code := runRenderPolicy(args, &out); code != 0 {
+		t.Fatalf("Expected exit code 0, got %d:\n%s", code, out.String())
+	}
+	for _, expected := range []string{"# Policy for cluster az-staging/demo with overlays: staging", "- kube-system\n- staging-system\n", "wildcardRequests: deny"} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("Expected rendered policy to contain %q:\n%s", expected, out.String())
+		}
+	}
+
+	out.Reset()
+	args[3] = "az-prod/demo"
+	runRenderPolicy(args, &out)
+	if !strings.Contains(out.String(), "with overlays: none") || !strings.Contains(out.String(), "- openstack-system") {
+		t.Errorf("Expected the local policy unchanged for a cluster no overlay matches:\n%s", out.String())
+	}
+	if code := runRenderPolicy([]string{"--overlays-file", overlaysPath}, &out); code != 2 {
+		t.Errorf("Expected exit code 2 without a cluster, got %d", code)
+	}
+}
@@ -0,0 +1,154 @@
+package client
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/policy"
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/server"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Timeout of calls when Options doesn't set one
+const DefaultTimeout = 10 * time.Second
+
+// Settings for reaching a webhook
+type Options struct {
+	// URL of the webhook. SubjectAccessReviews are sent to its path, or '/authorize' if it has none, and
+	// other endpoints are resolved against it
+	URL string
+	// Optional, sent as the Authorization header
+	BearerToken string
+	// Optional, e.g. for a private CA or client certificates. System roots are used if nil
+	TLSConfig *tls.Config
+	Timeout   time.Duration
+}
+
+// Client for a webhook's API
+type Client struct {
+	url        *url.URL
+	token      string
+	httpClient *http.Client
+}
+
+// Returned for responses with an unsuccessful HTTP status
+type StatusError struct {
+	StatusCode int
+	Message    string
+	// Status the webhook responded with, nil if the body wasn't one
+	Status *metav1.Status
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected status from webhook: %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+func New(options Options) (*Client, error) {
+	u, err := url.Parse(options.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid webhook URL: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid webhook URL %q: scheme must be http or https", options.URL)
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = DefaultTimeout
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if options.TLSConfig != nil {
+		transport.TLSClientConfig = options.TLSConfig
+	}
+	return &Client{
+		url:        u,
+		token:      options.BearerToken,
+		httpClient: &http.Client{Timeout: options.Timeout, Transport: transport},
+	}, nil
+}
+
+// Returns SubjectAccessReview asking whether user can make a resource request. The verb is taken from
+// attributes
+func NewResourceRequest(user string, groups []string, attributes authorizationv1.ResourceAttributes) policy.SubjectAccessReview {
+	sar := newSubjectAccessReview(user, groups)
+	sar.Spec.ResourceAttributes = &attributes
+	return sar
+}
+
+// Returns SubjectAccessReview asking whether user can make a non-resource request for path
+func NewNonResourceRequest(user string, groups []string, verb string, path string) policy.SubjectAccessReview {
+	sar := newSubjectAccessReview(user, groups)
+	sar.Spec.NonResourceAttributes = &authorizationv1.NonResourceAttributes{Path: path, Verb: verb}
+	return sar
+}
+
+func newSubjectAccessReview(user string, groups []string) policy.SubjectAccessReview {
+	var sar policy.SubjectAccessReview
+	sar.APIVersion = "authorization.k8s.io/v1"
+	sar.Kind = "SubjectAccessReview"
+	sar.Spec.User = user
+	sar.Spec.Groups = groups
+	return sar
+}
+
+// Sends sar to the webhook, returning its decision
+func (c *Client) Authorize(ctx context.Context, sar policy.SubjectAccessReview) (authorizationv1.SubjectAccessReviewStatus, error) {
+	endpoint := *c.url
+	if endpoint.Path == "" || endpoint.Path == "/" {
+		endpoint.Path = "/authorize"
+	}
+	var review server.SubjectAccessReviewResponse
+	if err := c.post(ctx, &endpoint, sar, &review); err != nil {
+		return authorizationv1.SubjectAccessReviewStatus{}, err
+	}
+	return review.Status, nil
+}
+
+// Posts request as JSON to the endpoint at path, e.g. '/v1/check', decoding the JSON response into
+// response
+func (c *Client) Post(ctx context.Context, path string, request any, response any) error {
+	endpoint, err := c.url.Parse(path)
+	if err != nil {
+		return err
+	}
+	return c.post(ctx, endpoint, request, response)
+}
+
+func (c *Client) post(ctx context.Context, endpoint *url.URL, request any, response any) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		statusErr := &StatusError{StatusCode: resp.StatusCode, Message: string(bytes.TrimSpace(message))}
+		var status metav1.Status
+		if json.Unmarshal(message, &status) == nil && status.Kind == "Status" {
+			statusErr.Status = &status
+			statusErr.Message = status.Message
+		}
+		return statusErr
+	}
+	if err := json.NewDecoder(resp.Body).Decode(response); err != nil {
+		return fmt.Errorf("decoding webhook response: %w", err)
+	}
+	return nil
+}
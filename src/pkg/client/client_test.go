@@ -0,0 +1,76 @@
+package client
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/policy"
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/server"
+	"encoding/json"
+	"errors"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newTestWebhook(t *testing.T) *httptest.Server {
+	source := policy.NewSource(policy.Config{ProtectedNamespaces: []string{"kube-system"}})
+	mux := http.NewServeMux()
+	mux.Handle("POST /authorize", server.Chain(server.NewHandler(server.Options{Evaluate: server.PolicyEvaluator(source, false)}),
+		server.Authenticate(func(r *http.Request) bool { return r.Header.Get("Authorization") == "Bearer secret" })))
+	mux.HandleFunc("POST /v1/echo", func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		json.NewEncoder(w).Encode(body)
+	})
+	webhook := httptest.NewServer(mux)
+	t.Cleanup(webhook.Close)
+	return webhook
+}
+
+func TestAuthorize(t *testing.T) {
+	webhook := newTestWebhook(t)
+	client, err := New(Options{URL: webhook.URL, BearerToken: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, err := client.Authorize(t.Context(), NewResourceRequest("alice", []string{"staff"},
+		authorizationv1.ResourceAttributes{Namespace: "kube-system", Verb: "get", Resource: "secrets"}))
+	if err != nil || !status.Denied {
+		t.Errorf("Expected denial, got %+v, %v", status, err)
+	}
+	status, err = client.Authorize(t.Context(), NewNonResourceRequest("alice", nil, "get", "/healthz"))
+	if err != nil || status.Denied || status.Allowed {
+		t.Errorf("Expected no opinion, got %+v, %v", status, err)
+	}
+}
+
+func TestAuthorizeErrors(t *testing.T) {
+	webhook := newTestWebhook(t)
+	client, err := New(Options{URL: webhook.URL + "/authorize"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var statusErr *StatusError
+	_, err = client.Authorize(t.Context(), NewNonResourceRequest("alice", nil, "get", "/healthz"))
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusUnauthorized || statusErr.Message != "Unauthorized" ||
+		statusErr.Status == nil || statusErr.Status.Reason != metav1.StatusReasonUnauthorized {
+		t.Errorf("Expected unauthorized status error, got %v", err)
+	}
+
+	if _, err := New(Options{URL: "webhook:8080"}); err == nil {
+		t.Error("Expected URL without scheme to be rejected")
+	}
+}
+
+func TestPost(t *testing.T) {
+	webhook := newTestWebhook(t)
+	client, err := New(Options{URL: webhook.URL + "/authorize"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var response map[string]string
+	if err := client.Post(t.Context(), "/v1/echo", map[string]string{"user": "alice"}, &response); err != nil || response["user"] != "alice" {
+		t.Errorf("Expected echoed response, got %v, %v", response, err)
+	}
+}
@@ -0,0 +1,2 @@
+// Package client calls the HTTP API of a running webhook, for tests, the CLI and other Azimuth services
+package client
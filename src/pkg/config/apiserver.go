@@ -0,0 +1,129 @@
+package config
+
+import (
+	"encoding/base64"
+	"sigs.k8s.io/yaml"
+	"time"
+)
+
+// Equivalent of a kube-apiserver structured authorization matchCondition
+type MatchCondition struct {
+	Name       string `json:"name"`
+	Expression string `json:"expression"`
+}
+
+// Settings for the kube-apiserver configuration pointing at a webhook instance
+type ApiserverWebhookOptions struct {
+	// Authorizer name in the structured authorization configuration
+	Name string
+	// URL of the webhook's /authorize endpoint
+	ServerURL string
+	// PEM encoded credentials, embedded in the generated kubeconfig
+	CAData         []byte
+	ClientCertData []byte
+	ClientKeyData  []byte
+	Token          string
+	// Where the generated kubeconfig will be saved on control plane nodes
+	KubeconfigPath  string
+	Timeout         time.Duration
+	AuthorizedTTL   time.Duration
+	UnauthorizedTTL time.Duration
+	// NoOpinion or Deny, applied by kube-apiserver when the webhook can't be reached
+	FailurePolicy   string
+	MatchConditions []MatchCondition
+	// Version of SubjectAccessReview kube-apiserver sends, v1 if empty
+	SubjectAccessReviewVersion string
+}
+
+func (options ApiserverWebhookOptions) subjectAccessReviewVersion() string {
+	if options.SubjectAccessReviewVersion == "" {
+		return "v1"
+	}
+	return options.SubjectAccessReviewVersion
+}
+
+// Returns kubeconfig-format file kube-apiserver uses to call the webhook, with all credentials embedded
+func GenerateWebhookKubeconfig(options ApiserverWebhookOptions) ([]byte, error) {
+	cluster := map[string]string{"server": options.ServerURL}
+	if len(options.CAData) > 0 {
+		cluster["certificate-authority-data"] = base64.StdEncoding.EncodeToString(options.CAData)
+	}
+	user := map[string]string{}
+	if options.Token != "" {
+		user["token"] = options.Token
+	}
+	if len(options.ClientCertData) > 0 {
+		user["client-certificate-data"] = base64.StdEncoding.EncodeToString(options.ClientCertData)
+		user["client-key-data"] = base64.StdEncoding.EncodeToString(options.ClientKeyData)
+	}
+	return yaml.Marshal(map[string]any{
+		"apiVersion":      "v1",
+		"kind":            "Config",
+		"current-context": "webhook",
+		"clusters":        []any{map[string]any{"name": options.Name, "cluster": cluster}},
+		"users":           []any{map[string]any{"name": "kube-apiserver", "user": user}},
+		"contexts": []any{map[string]any{"name": "webhook", "context": map[string]string{
+			"cluster": options.Name,
+			"user":    "kube-apiserver",
+		}}},
+	})
+}
+
+// kube-apiserver structured authorization configuration, apiserver.config.k8s.io/v1beta1
+type AuthorizationConfiguration struct {
+	APIVersion  string                    `json:"apiVersion"`
+	Kind        string                    `json:"kind"`
+	Authorizers []AuthorizerConfiguration `json:"authorizers"`
+}
+
+type AuthorizerConfiguration struct {
+	Type    string                `json:"type"`
+	Name    string                `json:"name"`
+	Webhook *WebhookConfiguration `json:"webhook,omitempty"`
+}
+
+type WebhookConfiguration struct {
+	Timeout                                  string            `json:"timeout"`
+	AuthorizedTTL                            string            `json:"authorizedTTL"`
+	UnauthorizedTTL                          string            `json:"unauthorizedTTL"`
+	SubjectAccessReviewVersion               string            `json:"subjectAccessReviewVersion"`
+	MatchConditionSubjectAccessReviewVersion string            `json:"matchConditionSubjectAccessReviewVersion"`
+	FailurePolicy                            string            `json:"failurePolicy"`
+	ConnectionInfo                           map[string]string `json:"connectionInfo"`
+	MatchConditions                          []MatchCondition  `json:"matchConditions,omitempty"`
+}
+
+// Returns kube-apiserver structured authorization configuration (Kubernetes 1.30+) consulting the webhook
+// after the Node authorizer but before RBAC, so that its denials take effect
+func GenerateAuthorizationConfiguration(options ApiserverWebhookOptions) ([]byte, error) {
+	return yaml.Marshal(AuthorizationConfiguration{
+		APIVersion: "apiserver.config.k8s.io/v1beta1",
+		Kind:       "AuthorizationConfiguration",
+		Authorizers: []AuthorizerConfiguration{
+			{Type: "Node", Name: "node"},
+			{Type: "Webhook", Name: options.Name, Webhook: &WebhookConfiguration{
+				Timeout:                                  options.Timeout.String(),
+				AuthorizedTTL:                            options.AuthorizedTTL.String(),
+				UnauthorizedTTL:                          options.UnauthorizedTTL.String(),
+				SubjectAccessReviewVersion:               options.subjectAccessReviewVersion(),
+				MatchConditionSubjectAccessReviewVersion: "v1",
+				FailurePolicy:                            options.FailurePolicy,
+				ConnectionInfo:                           map[string]string{"type": "KubeConfigFile", "kubeConfigFile": options.KubeconfigPath},
+				MatchConditions:                          options.MatchConditions,
+			}},
+			{Type: "RBAC", Name: "rbac"},
+		},
+	})
+}
+
+// Returns the legacy kube-apiserver flags equivalent to the structured configuration. Match conditions
+// and the failure policy can't be expressed this way
+func GenerateApiserverFlags(options ApiserverWebhookOptions) []string {
+	return []string{
+		"--authorization-mode=Node,Webhook,RBAC",
+		"--authorization-webhook-config-file=" + options.KubeconfigPath,
+		"--authorization-webhook-version=" + options.subjectAccessReviewVersion(),
+		"--authorization-webhook-cache-authorized-ttl=" + options.AuthorizedTTL.String(),
+		"--authorization-webhook-cache-unauthorized-ttl=" + options.UnauthorizedTTL.String(),
+	}
+}
@@ -0,0 +1,69 @@
+package config
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/policy"
+	"os"
+	"path/filepath"
+	"sigs.k8s.io/yaml"
+	"slices"
+	"testing"
+)
+
+func TestLoadPolicyFile(t *testing.T) {
+	dir := t.TempDir()
+	valid, unknownField, badPattern := filepath.Join(dir, "valid.yaml"), filepath.Join(dir, "unknown.yaml"), filepath.Join(dir, "bad.yaml")
+	os.WriteFile(valid, []byte("protectedNamespaces: [kube-system, openstack-*]\nadditionalPrivilegedUsers: [admin]\n"), 0o600)
+	os.WriteFile(unknownField, []byte("protectedNamespace: [kube-system]\n"), 0o600)
+	os.WriteFile(badPattern, []byte("protectedNamespaces: [\"bad-[\"]\n"), 0o600)
+
+	file, err := LoadPolicyFile(valid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config := file.PolicyConfig(); !slices.Equal(config.ProtectedNamespaces, []string{"kube-system", "openstack-*"}) || !slices.Equal(config.AdditionalPrivilegedUsers, []string{"admin"}) {
+		t.Errorf("Unexpected policy %+v", config)
+	}
+	for _, path := range []string{unknownField, badPattern} {
+		if _, err := LoadPolicyFile(path); err == nil {
+			t.Errorf("Expected %s to be rejected", filepath.Base(path))
+		}
+	}
+}
+
+func TestAuthorizationConfigurationReviewVersion(t *testing.T) {
+	for version, expected := range map[string]string{"": "v1", "v1beta1": "v1beta1"} {
+		data, err := GenerateAuthorizationConfiguration(ApiserverWebhookOptions{Name: "webhook", SubjectAccessReviewVersion: version})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var config AuthorizationConfiguration
+		if err := yaml.Unmarshal(data, &config); err != nil {
+			t.Fatal(err)
+		}
+		if actual := config.Authorizers[1].Webhook.SubjectAccessReviewVersion; actual != expected {
+			t.Errorf("Expected version %s for %q, got %s", expected, version, actual)
+		}
+		flags := GenerateApiserverFlags(ApiserverWebhookOptions{SubjectAccessReviewVersion: version})
+		if !slices.Contains(flags, "--authorization-webhook-version="+expected) {
+			t.Errorf("Expected flags for version %s, got %v", expected, flags)
+		}
+	}
+}
+
+func TestLoadNameRules(t *testing.T) {
+	dir := t.TempDir()
+	valid, invalid := filepath.Join(dir, "valid.yaml"), filepath.Join(dir, "invalid.yaml")
+	os.WriteFile(valid, []byte("- name: acme-account-key\n  effect: deny\n  resource: secrets\n  resourceNames: [letsencrypt-*]\n"), 0o600)
+	os.WriteFile(invalid, []byte("- name: acme-account-key\n  effect: deny\n  resource: secrets\n"), 0o600)
+
+	rules, err := LoadNameRules(valid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 1 || rules[0].Effect != policy.NameRuleDeny || !slices.Equal(rules[0].ResourceNames, []string{"letsencrypt-*"}) {
+		t.Errorf("Unexpected rules %+v", rules)
+	}
+	if _, err := LoadNameRules(invalid); err == nil {
+		t.Error("Expected rule without resource names to be rejected")
+	}
+}
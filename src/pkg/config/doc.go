@@ -0,0 +1,3 @@
+// Package config reads policy and settings files and generates the kube-apiserver configuration pointing at a
+// webhook
+package config
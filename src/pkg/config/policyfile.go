@@ -0,0 +1,139 @@
+package config
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/policy"
+	"fmt"
+	"os"
+	"reflect"
+	"sigs.k8s.io/yaml"
+)
+
+// YAML or JSON file equivalent to the policy command line flags, for evaluating policies offline
+type PolicyFile struct {
+	ProtectedNamespaces             []string                           `json:"protectedNamespaces"`
+	AdditionalPrivilegedUsers       []string                           `json:"additionalPrivilegedUsers"`
+	ExemptNamespaces                []string                           `json:"exemptNamespaces,omitempty"`
+	AllowOpinionMode                bool                               `json:"allowOpinionMode"`
+	ClusterScopedResources          []string                           `json:"clusterScopedResources,omitempty"`
+	WildcardRequests                policy.WildcardPolicy              `json:"wildcardRequests,omitempty"`
+	BulkSecretReads                 policy.BulkSecretReadPolicy        `json:"bulkSecretReads,omitempty"`
+	DenyImpersonatedProtectedWrites bool                               `json:"denyImpersonatedProtectedWrites,omitempty"`
+	AdditionalReadonlyVerbs         []string                           `json:"additionalReadonlyVerbs,omitempty"`
+	AdditionalWriteVerbs            []string                           `json:"additionalWriteVerbs,omitempty"`
+	WebhookObjects                  []string                           `json:"webhookObjects,omitempty"`
+	DisableWebhookProtection        bool                               `json:"disableWebhookProtection,omitempty"`
+	NameRules                       []policy.NameRule                  `json:"nameRules,omitempty"`
+	PrivilegedServiceAccounts       []string                           `json:"privilegedServiceAccounts,omitempty"`
+	PrivilegedServiceAccountsMode   policy.ServiceAccountAllowlistMode `json:"privilegedServiceAccountsMode,omitempty"`
+	NamespaceDeletionProtection     bool                               `json:"namespaceDeletionProtection,omitempty"`
+	DeletionProtectedNamespaces     []string                           `json:"deletionProtectedNamespaces,omitempty"`
+	NamespaceDeleters               []string                           `json:"namespaceDeleters,omitempty"`
+	NodeRestriction                 bool                               `json:"nodeRestriction,omitempty"`
+	NamespaceCreationProtection     bool                               `json:"namespaceCreationProtection,omitempty"`
+	ReservedNamespaces              []string                           `json:"reservedNamespaces,omitempty"`
+	CacheHints                      map[string]policy.CacheHint        `json:"cacheHints,omitempty"`
+}
+
+// Reads and validates a policy file
+func LoadPolicyFile(path string) (PolicyFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return PolicyFile{}, err
+	}
+	file, err := ParsePolicyFile(data)
+	if err != nil {
+		return file, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return file, file.PolicyConfig().Validate()
+}
+
+// Parses a YAML or JSON policy file, e.g. from a policy bundle, without validating the policy it gives
+func ParsePolicyFile(data []byte) (PolicyFile, error) {
+	var file PolicyFile
+	if err := ValidateSchema(PolicyFileSchema(), data); err != nil {
+		return file, err
+	}
+	err := yaml.UnmarshalStrict(data, &file)
+	return file, err
+}
+
+// Returns the policy settings given by the file
+func (f PolicyFile) PolicyConfig() policy.Config {
+	return policy.Config{
+		ProtectedNamespaces:             f.ProtectedNamespaces,
+		AdditionalPrivilegedUsers:       f.AdditionalPrivilegedUsers,
+		ExemptNamespaces:                f.ExemptNamespaces,
+		ClusterScopedResources:          f.ClusterScopedResources,
+		WildcardRequests:                f.WildcardRequests,
+		BulkSecretReads:                 f.BulkSecretReads,
+		DenyImpersonatedProtectedWrites: f.DenyImpersonatedProtectedWrites,
+		AdditionalReadonlyVerbs:         f.AdditionalReadonlyVerbs,
+		AdditionalWriteVerbs:            f.AdditionalWriteVerbs,
+		WebhookObjects:                  f.WebhookObjects,
+		DisableWebhookProtection:        f.DisableWebhookProtection,
+		NameRules:                       f.NameRules,
+		PrivilegedServiceAccounts:       f.PrivilegedServiceAccounts,
+		ServiceAccountAllowlist:         f.PrivilegedServiceAccountsMode,
+		NamespaceDeletionProtection:     f.NamespaceDeletionProtection,
+		DeletionProtectedNamespaces:     f.DeletionProtectedNamespaces,
+		NamespaceDeleters:               f.NamespaceDeleters,
+		NodeRestriction:                 f.NodeRestriction,
+		NamespaceCreationProtection:     f.NamespaceCreationProtection,
+		ReservedNamespaces:              f.ReservedNamespaces,
+		CacheHints:                      f.CacheHints,
+	}
+}
+
+// Reads a YAML or JSON list of name rules, as given under nameRules in a policy file
+func LoadNameRules(path string) ([]policy.NameRule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	rules, err := ParseNameRules(data)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return rules, policy.ValidateNameRules(rules)
+}
+
+// Parses a YAML or JSON list of name rules without validating them
+func ParseNameRules(data []byte) ([]policy.NameRule, error) {
+	if err := ValidateSchema(schemaFor(reflect.TypeFor[[]policy.NameRule]()), data); err != nil {
+		return nil, err
+	}
+	var rules []policy.NameRule
+	err := yaml.UnmarshalStrict(data, &rules)
+	return rules, err
+}
+
+// Returns the file with the overlays matching the cluster, given as namespace/name, applied in order, and
+// the names of those applied, so the policy a cluster is served can be rendered
+func (f PolicyFile) WithOverlays(overlays []policy.Overlay, cluster string) (PolicyFile, []string) {
+	config, applied := policy.ApplyOverlays(f.PolicyConfig(), overlays, cluster)
+	f.ProtectedNamespaces = config.ProtectedNamespaces
+	f.ExemptNamespaces = config.ExemptNamespaces
+	f.AdditionalPrivilegedUsers = config.AdditionalPrivilegedUsers
+	f.PrivilegedServiceAccounts = config.PrivilegedServiceAccounts
+	f.WebhookObjects = config.WebhookObjects
+	f.NameRules = config.NameRules
+	f.WildcardRequests = config.WildcardRequests
+	f.BulkSecretReads = config.BulkSecretReads
+	return f, applied
+}
+
+// Reads a YAML or JSON list of policy overlays
+func LoadOverlays(path string) ([]policy.Overlay, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := ValidateSchema(OverlaysSchema(), data); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	var overlays []policy.Overlay
+	if err := yaml.UnmarshalStrict(data, &overlays); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return overlays, policy.ValidateOverlays(overlays)
+}
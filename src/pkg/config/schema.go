@@ -0,0 +1,296 @@
+package config
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/policy"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"maps"
+	"reflect"
+	"sigs.k8s.io/yaml"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// JSON Schema dialect of the schemas, the draft yaml-language-server and most editors support
+const schemaDialect = "http://json-schema.org/draft-07/schema#"
+
+// Identifiers of the schemas, versioned so files can name the version they were written against. A
+// version is only replaced if files valid against it could become invalid
+const (
+	PolicyFileSchemaID   = "urn:azimuth-cloud:authorization-webhook:policy-file:v1"
+	OverlaysSchemaID     = "urn:azimuth-cloud:authorization-webhook:overlays:v1"
+	SettingsFileSchemaID = "urn:azimuth-cloud:authorization-webhook:settings-file:v1"
+)
+
+// JSON Schema, as decoded from JSON
+type Schema map[string]any
+
+// Values of the string types the policy restricts to a set
+var schemaEnums = map[reflect.Type][]string{
+	reflect.TypeFor[policy.WildcardPolicy]():              {string(policy.WildcardProtectedNamespaces), string(policy.WildcardDeny)},
+	reflect.TypeFor[policy.BulkSecretReadPolicy]():        {string(policy.BulkSecretReadsAllow), string(policy.BulkSecretReadsFlag), string(policy.BulkSecretReadsDeny)},
+	reflect.TypeFor[policy.ServiceAccountAllowlistMode](): {string(policy.ServiceAccountAllowlistOff), string(policy.ServiceAccountAllowlistLog), string(policy.ServiceAccountAllowlistEnforce)},
+	reflect.TypeFor[policy.NameRuleEffect]():              {string(policy.NameRuleAllow), string(policy.NameRuleDeny)},
+	reflect.TypeFor[policy.CacheHint]():                   {string(policy.CacheLong), string(policy.CacheNone)},
+}
+
+// Descriptions of properties shown by editors, keyed by type and property name
+var schemaDescriptions = map[string]string{
+	"PolicyFile.protectedNamespaces":             "Namespaces only privileged users may write to or read secrets in. Entries may be names, prefixes ending in '*' or glob patterns",
+	"PolicyFile.additionalPrivilegedUsers":       "Users exempt from the protected namespace rules",
+	"PolicyFile.exemptNamespaces":                "Namespaces never protected, even if matching protectedNamespaces",
+	"PolicyFile.allowOpinionMode":                "Whether the webhook allows requests it doesn't deny, rather than leaving them to other authorizers",
+	"PolicyFile.clusterScopedResources":          "Resources without namespaces besides the built-in ones, as RESOURCE[.GROUP]",
+	"PolicyFile.wildcardRequests":                "Treatment of * verb and * resource requests outside protected namespaces",
+	"PolicyFile.bulkSecretReads":                 "Treatment of list and watch requests for secrets without a name or selector outside protected namespaces",
+	"PolicyFile.denyImpersonatedProtectedWrites": "Deny impersonated writes to protected namespaces, even if both users are privileged",
+	"PolicyFile.additionalReadonlyVerbs":         "Custom verbs which can't modify resources",
+	"PolicyFile.additionalWriteVerbs":            "Custom verbs which modify resources",
+	"PolicyFile.webhookObjects":                  "Objects the webhook depends on besides the defaults, as NAMESPACE/RESOURCE[.GROUP]/NAME",
+	"PolicyFile.disableWebhookProtection":        "Leave the objects the webhook depends on to the protected namespace rules alone",
+	"PolicyFile.nameRules":                       "Rules for requests for particular objects by name, considered in order before the protected namespace rules",
+	"PolicyFile.privilegedServiceAccounts":       "Service accounts of protected namespaces, as namespace/name, privileged when privilegedServiceAccountsMode is enforce",
+	"PolicyFile.privilegedServiceAccountsMode":   "Whether every service account of a protected namespace is privileged",
+	"PolicyFile.namespaceDeletionProtection":     "Deny deleting protected namespaces, and those in deletionProtectedNamespaces, to every user but namespaceDeleters",
+	"PolicyFile.deletionProtectedNamespaces":     "Namespaces whose deletion is protected besides protected namespaces",
+	"PolicyFile.namespaceDeleters":               "Users who may delete namespaces whose deletion is protected",
+	"PolicyFile.nodeRestriction":                 "Only treat node users as privileged for requests plausibly related to their node",
+	"PolicyFile.namespaceCreationProtection":     "Deny creating namespaces with reserved names to users who aren't privileged",
+	"PolicyFile.reservedNamespaces":              "Namespaces only privileged users may create besides protected namespaces",
+	"PolicyFile.cacheHints":                      "How long decisions may be cached, long or none, by the name of the rule deciding them",
+	"NameRule.name":                              "Identifies the rule in deny reasons and explanations",
+	"NameRule.resource":                          "As RESOURCE[.GROUP], e.g. secrets or certificates.cert-manager.io",
+	"NameRule.resourceNames":                     "Names of the objects, which may be glob patterns",
+	"NameRule.namespaces":                        "Namespace entries, as for protected namespaces. Every namespace if empty",
+	"NameRule.verbs":                             "Every verb if empty. 'read' and 'write' match the verbs the policy classifies as such",
+	"NameRule.cache":                             "How long decisions made by the rule may be cached, long or none, overriding the hint for its effect",
+	"Overlay.name":                               "Identifies the overlay in logs, audit events and errors",
+	"Overlay.clusters":                           "Patterns matching the namespace/name of the clusters the overlay applies to, e.g. az-staging/*",
+}
+
+// Returns the JSON Schema of policy files
+func PolicyFileSchema() Schema {
+	schema := schemaFor(reflect.TypeFor[PolicyFile]())
+	schema["$schema"] = schemaDialect
+	schema["$id"] = PolicyFileSchemaID
+	schema["title"] = "azimuth-authorization-webhook policy file"
+	return schema
+}
+
+// Returns the JSON Schema of policy overlay files
+func OverlaysSchema() Schema {
+	schema := schemaFor(reflect.TypeFor[[]policy.Overlay]())
+	schema["$schema"] = schemaDialect
+	schema["$id"] = OverlaysSchemaID
+	schema["title"] = "azimuth-authorization-webhook policy overlays"
+	return schema
+}
+
+// Returns the JSON Schema of settings files setting the flags but fileFlag, each flag being a property
+// described by its usage. Values are given to the flags as written, lists being joined with commas
+func SettingsFileSchema(flags *flag.FlagSet, fileFlag string) Schema {
+	properties := Schema{}
+	flags.VisitAll(func(f *flag.Flag) {
+		if f.Name == fileFlag {
+			return
+		}
+		property := Schema{"description": f.Usage}
+		var value any
+		if getter, ok := f.Value.(flag.Getter); ok {
+			value = getter.Get()
+		}
+		switch value.(type) {
+		case bool:
+			property["type"] = []any{"boolean", "string", "null"}
+		case int, int64, uint, uint64:
+			property["type"] = []any{"integer", "string", "null"}
+		case float64:
+			property["type"] = []any{"number", "string", "null"}
+		case time.Duration:
+			property["type"] = []any{"string", "null"}
+		default:
+			property["type"] = []any{"string", "number", "boolean", "array", "null"}
+			property["items"] = Schema{"type": []any{"string", "number", "boolean", "null"}}
+		}
+		if f.DefValue != "" {
+			property["default"] = f.DefValue
+		}
+		properties[f.Name] = property
+	})
+	return Schema{
+		"$schema":              schemaDialect,
+		"$id":                  SettingsFileSchemaID,
+		"title":                "azimuth-authorization-webhook settings file",
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+}
+
+// Returns the schema of values of typ as decoded from JSON
+func schemaFor(typ reflect.Type) Schema {
+	if values, ok := schemaEnums[typ]; ok {
+		return Schema{"type": "string", "enum": toAny(values)}
+	}
+	switch typ.Kind() {
+	case reflect.String:
+		return Schema{"type": "string"}
+	case reflect.Bool:
+		return Schema{"type": "boolean"}
+	case reflect.Int, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64:
+		return Schema{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return Schema{"type": "number"}
+	case reflect.Slice:
+		return Schema{"type": []any{"array", "null"}, "items": schemaFor(typ.Elem())}
+	case reflect.Map:
+		return Schema{"type": []any{"object", "null"}, "additionalProperties": schemaFor(typ.Elem())}
+	case reflect.Struct:
+		properties := Schema{}
+		for i := range typ.NumField() {
+			field := typ.Field(i)
+			name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if !field.IsExported() || name == "-" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			property := schemaFor(field.Type)
+			if description, ok := schemaDescriptions[typ.Name()+"."+name]; ok {
+				property["description"] = description
+			}
+			properties[name] = property
+		}
+		return Schema{"type": []any{"object", "null"}, "properties": properties, "additionalProperties": false}
+	}
+	panic(fmt.Sprintf("no schema for %s", typ))
+}
+
+func toAny(values []string) []any {
+	result := make([]any, len(values))
+	for i, value := range values {
+		result[i] = value
+	}
+	return result
+}
+
+// Returns error listing every way the YAML or JSON document doesn't match schema, each at the path of
+// the value, e.g. nameRules[0].effect
+func ValidateSchema(schema Schema, data []byte) error {
+	data, err := yaml.YAMLToJSON(data)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var document any
+	if err := decoder.Decode(&document); err != nil {
+		return err
+	}
+	var errs []error
+	validateSchema(schema, document, "", &errs)
+	return errors.Join(errs...)
+}
+
+func validateSchema(schema Schema, value any, path string, errs *[]error) {
+	fail := func(format string, args ...any) {
+		location := path
+		if location == "" {
+			location = "document"
+		}
+		*errs = append(*errs, fmt.Errorf("%s: %s", location, fmt.Sprintf(format, args...)))
+	}
+	if types, ok := schema["type"]; ok {
+		names, _ := types.([]any)
+		if name, ok := types.(string); ok {
+			names = []any{name}
+		}
+		if !slices.ContainsFunc(names, func(name any) bool { return schemaTypeMatches(name.(string), value) }) {
+			fail("expected %s, got %s", joinTypes(names), jsonTypeName(value))
+			return
+		}
+	}
+	if values, ok := schema["enum"].([]any); ok && !slices.Contains(values, value) {
+		fail("%s must be one of %s", formatValue(value), joinTypes(values))
+		return
+	}
+	switch value := value.(type) {
+	case []any:
+		if items, ok := schema["items"].(Schema); ok {
+			for i, item := range value {
+				validateSchema(items, item, fmt.Sprintf("%s[%d]", path, i), errs)
+			}
+		}
+	case map[string]any:
+		properties, _ := schema["properties"].(Schema)
+		for _, name := range slices.Sorted(maps.Keys(value)) {
+			child := name
+			if path != "" {
+				child = path + "." + name
+			}
+			if property, ok := properties[name].(Schema); ok {
+				validateSchema(property, value[name], child, errs)
+			} else if additional, ok := schema["additionalProperties"].(Schema); ok {
+				validateSchema(additional, value[name], child, errs)
+			} else if schema["additionalProperties"] == false {
+				*errs = append(*errs, fmt.Errorf("%s: unknown property", child))
+			}
+		}
+	}
+}
+
+func schemaTypeMatches(name string, value any) bool {
+	switch value := value.(type) {
+	case nil:
+		return name == "null"
+	case bool:
+		return name == "boolean"
+	case string:
+		return name == "string"
+	case json.Number:
+		_, err := strconv.ParseInt(value.String(), 10, 64)
+		return name == "number" || name == "integer" && err == nil
+	case []any:
+		return name == "array"
+	case map[string]any:
+		return name == "object"
+	}
+	return false
+}
+
+func jsonTypeName(value any) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case json.Number:
+		return "number"
+	case []any:
+		return "array"
+	}
+	return "object"
+}
+
+func joinTypes(values []any) string {
+	names := make([]string, len(values))
+	for i, value := range values {
+		names[i] = fmt.Sprint(value)
+	}
+	return strings.Join(names, " or ")
+}
+
+func formatValue(value any) string {
+	if value, ok := value.(string); ok {
+		return strconv.Quote(value)
+	}
+	return fmt.Sprint(value)
+}
@@ -0,0 +1,74 @@
+package config
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateSchema(t *testing.T) {
+	valid := "protectedNamespaces: [kube-system]\nwildcardRequests: deny\nnameRules:\n  - name: acme\n    effect: deny\n    resource: secrets\n    resourceNames: [acme-*]\n"
+	if err := ValidateSchema(PolicyFileSchema(), []byte(valid)); err != nil {
+		t.Errorf("Expected valid policy file, got %v", err)
+	}
+
+	invalid := "protectedNamespaces: kube-system\nwildcardRequests: maybe\nallowOpinionMode: \"yes\"\nnameRules:\n  - name: acme\n    effect: deny\n    verb: [get]\n"
+	err := ValidateSchema(PolicyFileSchema(), []byte(invalid))
+	if err == nil {
+		t.Fatal("Expected invalid policy file to be rejected")
+	}
+	for _, expected := range []string{
+		"allowOpinionMode: expected boolean, got string",
+		"nameRules[0].verb: unknown property",
+		"protectedNamespaces: expected array or null, got string",
+		`wildcardRequests: "maybe" must be one of protected-namespaces or deny`,
+	} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected error %q, got:\n%v", expected, err)
+		}
+	}
+
+	overlays := "- name: staging\n  clusters: [az-staging/*]\n  protectedNamespaces:\n    add: [tenant-*]\n  bulkSecretReads: always\n"
+	if err := ValidateSchema(OverlaysSchema(), []byte(overlays)); err == nil || !strings.Contains(err.Error(), "[0].bulkSecretReads:") {
+		t.Errorf("Expected invalid overlay setting to be rejected, got %v", err)
+	}
+}
+
+func TestLoadPolicyFileReportsSchemaErrors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.yaml")
+	os.WriteFile(path, []byte("protectedNamespaces: [kube-system]\nnameRules:\n  - name: acme\n    effect: maybe\n"), 0o600)
+	if _, err := LoadPolicyFile(path); err == nil || !strings.Contains(err.Error(), "nameRules[0].effect:") {
+		t.Errorf("Expected error at nameRules[0].effect, got %v", err)
+	}
+}
+
+// Editors show descriptions when completing, so every policy file setting needs one
+func TestPolicyFileSchemaDescribesSettings(t *testing.T) {
+	properties := PolicyFileSchema()["properties"].(Schema)
+	for i := range reflect.TypeFor[PolicyFile]().NumField() {
+		name, _, _ := strings.Cut(reflect.TypeFor[PolicyFile]().Field(i).Tag.Get("json"), ",")
+		if _, ok := properties[name].(Schema)["description"]; !ok {
+			t.Errorf("No description of policy file setting %s", name)
+		}
+	}
+}
+
+func TestSettingsFileSchema(t *testing.T) {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.String("config-file", "", "Settings file")
+	flags.String("protected-namespaces", "", "Protected namespaces")
+	flags.Bool("allow-opinion-mode", false, "Allow opinion mode")
+	flags.Int("port", 8443, "Port")
+	schema := SettingsFileSchema(flags, "config-file")
+
+	if err := ValidateSchema(schema, []byte("protected-namespaces: [kube-system, openstack-*]\nallow-opinion-mode: true\nport: 9443\n")); err != nil {
+		t.Errorf("Expected valid settings file, got %v", err)
+	}
+	err := ValidateSchema(schema, []byte("config-file: other.yaml\nport: [1, 2]\n"))
+	if err == nil || !strings.Contains(err.Error(), "config-file: unknown property") || !strings.Contains(err.Error(), "port: expected integer or string or null, got array") {
+		t.Errorf("Expected settings file to be rejected, got %v", err)
+	}
+}
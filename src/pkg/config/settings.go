@@ -0,0 +1,150 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sigs.k8s.io/yaml"
+	"strconv"
+	"strings"
+)
+
+// Origin of a setting's effective value
+type SettingSource string
+
+// Setting sources, in increasing order of precedence
+const (
+	SourceDefault SettingSource = "default"
+	SourceFile    SettingSource = "file"
+	SourceEnv     SettingSource = "env"
+	SourceFlag    SettingSource = "flag"
+)
+
+// Effective value of a flag and where it came from
+type Setting struct {
+	Name   string        `json:"name"`
+	Value  string        `json:"value"`
+	Source SettingSource `json:"source"`
+}
+
+type SettingsOptions struct {
+	// Prefix of the environment variables setting flags, e.g. AZIMUTH_AUTHZ_ for AZIMUTH_AUTHZ_LOG_LEVEL
+	EnvPrefix string
+	// Flag naming the YAML settings file, which may be given by a flag or environment variable but not the file
+	FileFlag string
+	// Looks up environment variables, os.LookupEnv if nil
+	LookupEnv func(key string) (string, bool)
+}
+
+// Returns the environment variable setting the named flag: the flag in upper case with dashes replaced by
+// underscores, after prefix
+func SettingEnvName(prefix string, name string) string {
+	return prefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// Sets each flag not given on the already parsed command line from its environment variable, or failing
+// that from the settings file, returning every flag's effective value and source. The settings file maps
+// flag names to values, lists being joined with commas
+func ApplySettings(flags *flag.FlagSet, options SettingsOptions) ([]Setting, error) {
+	lookupEnv := options.LookupEnv
+	if lookupEnv == nil {
+		lookupEnv = os.LookupEnv
+	}
+	sources := map[string]SettingSource{}
+	flags.Visit(func(f *flag.Flag) { sources[f.Name] = SourceFlag })
+
+	var envErr error
+	flags.VisitAll(func(f *flag.Flag) {
+		envName := SettingEnvName(options.EnvPrefix, f.Name)
+		if value, ok := lookupEnv(envName); ok && sources[f.Name] == "" && envErr == nil {
+			if err := flags.Set(f.Name, value); err != nil {
+				envErr = fmt.Errorf("%s: %w", envName, err)
+			}
+			sources[f.Name] = SourceEnv
+		}
+	})
+	if envErr != nil {
+		return nil, envErr
+	}
+
+	if options.FileFlag != "" {
+		if path := flags.Lookup(options.FileFlag).Value.String(); path != "" {
+			values, err := readSettingsFile(path)
+			if err != nil {
+				return nil, err
+			}
+			for name, value := range values {
+				if name == options.FileFlag || flags.Lookup(name) == nil {
+					return nil, fmt.Errorf("parsing %s: unknown setting %q", path, name)
+				}
+				if sources[name] != "" {
+					continue
+				}
+				if err := flags.Set(name, value); err != nil {
+					return nil, fmt.Errorf("parsing %s: %s: %w", path, name, err)
+				}
+				sources[name] = SourceFile
+			}
+		}
+	}
+
+	var settings []Setting
+	flags.VisitAll(func(f *flag.Flag) {
+		source := sources[f.Name]
+		if source == "" {
+			source = SourceDefault
+		}
+		settings = append(settings, Setting{Name: f.Name, Value: f.Value.String(), Source: source})
+	})
+	return settings, nil
+}
+
+// Reads a YAML settings file into flag values
+func readSettingsFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err = yaml.YAMLToJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	// Numbers are kept as written, so large integers aren't formatted as floats
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var document map[string]any
+	if err := decoder.Decode(&document); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	values := map[string]string{}
+	for name, value := range document {
+		if list, ok := value.([]any); ok {
+			items := make([]string, len(list))
+			for i, item := range list {
+				if items[i], ok = settingScalar(item); !ok {
+					return nil, fmt.Errorf("parsing %s: %s: expected a list of scalars", path, name)
+				}
+			}
+			values[name] = strings.Join(items, ",")
+		} else if values[name], ok = settingScalar(value); !ok {
+			return nil, fmt.Errorf("parsing %s: %s: expected a scalar or list", path, name)
+		}
+	}
+	return values, nil
+}
+
+func settingScalar(value any) (string, bool) {
+	switch value := value.(type) {
+	case nil:
+		return "", true
+	case string:
+		return value, true
+	case bool:
+		return strconv.FormatBool(value), true
+	case json.Number:
+		return value.String(), true
+	}
+	return "", false
+}
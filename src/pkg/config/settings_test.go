@@ -0,0 +1,86 @@
+package config
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newSettingsFlags() *flag.FlagSet {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.String("config-file", "", "")
+	flags.String("protected-namespaces", "kube-system", "")
+	flags.Int("log-level", 1, "")
+	flags.Int64("max-records", 0, "")
+	flags.Bool("allow-opinion-mode", false, "")
+	flags.String("audit-file", "", "")
+	return flags
+}
+
+func TestApplySettingsPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "settings.yaml")
+	os.WriteFile(path, []byte("protected-namespaces: [kube-system, tenant-*]\nlog-level: 2\nmax-records: 10000000\nallow-opinion-mode: true\n"), 0o600)
+	env := map[string]string{"AZIMUTH_AUTHZ_CONFIG_FILE": path, "AZIMUTH_AUTHZ_LOG_LEVEL": "0", "AZIMUTH_AUTHZ_ALLOW_OPINION_MODE": "false"}
+	lookupEnv := func(key string) (string, bool) {
+		value, ok := env[key]
+		return value, ok
+	}
+
+	flags := newSettingsFlags()
+	flags.Parse([]string{"--allow-opinion-mode"})
+	settings, err := ApplySettings(flags, SettingsOptions{EnvPrefix: "AZIMUTH_AUTHZ_", FileFlag: "config-file", LookupEnv: lookupEnv})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]Setting{
+		"allow-opinion-mode":   {Value: "true", Source: SourceFlag},
+		"audit-file":           {Value: "", Source: SourceDefault},
+		"config-file":          {Value: path, Source: SourceEnv},
+		"log-level":            {Value: "0", Source: SourceEnv},
+		"max-records":          {Value: "10000000", Source: SourceFile},
+		"protected-namespaces": {Value: "kube-system,tenant-*", Source: SourceFile},
+	}
+	if len(settings) != len(expected) {
+		t.Fatalf("Expected %d settings, got %+v", len(expected), settings)
+	}
+	for _, setting := range settings {
+		if want := expected[setting.Name]; setting.Value != want.Value || setting.Source != want.Source {
+			t.Errorf("Expected %s to be %q from %s, got %q from %s", setting.Name, want.Value, want.Source, setting.Value, setting.Source)
+		}
+	}
+}
+
+func TestApplySettingsErrors(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		file string
+		env  string
+		err  string
+	}{
+		{"protected-namespace: [kube-system]\n", "", `unknown setting "protected-namespace"`},
+		{"config-file: other.yaml\n", "", `unknown setting "config-file"`},
+		{"log-level: verbose\n", "", "log-level"},
+		{"protected-namespaces: {kube-system: true}\n", "", "expected a scalar or list"},
+		{"", "verbose", "AZIMUTH_AUTHZ_LOG_LEVEL"},
+	}
+	for i, test := range tests {
+		path := filepath.Join(dir, "settings.yaml")
+		os.WriteFile(path, []byte(test.file), 0o600)
+		lookupEnv := func(key string) (string, bool) {
+			return test.env, key == "AZIMUTH_AUTHZ_LOG_LEVEL" && test.env != ""
+		}
+		flags := newSettingsFlags()
+		flags.Parse([]string{"--config-file", path})
+		if _, err := ApplySettings(flags, SettingsOptions{EnvPrefix: "AZIMUTH_AUTHZ_", FileFlag: "config-file", LookupEnv: lookupEnv}); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("Test %d: expected error containing %q, got %v", i, test.err, err)
+		}
+	}
+}
+
+func TestSettingEnvName(t *testing.T) {
+	if name := SettingEnvName("AZIMUTH_AUTHZ_", "record-corpus-sample-rate"); name != "AZIMUTH_AUTHZ_RECORD_CORPUS_SAMPLE_RATE" {
+		t.Errorf("Unexpected environment variable %s", name)
+	}
+}
@@ -0,0 +1,100 @@
+package policy
+
+import (
+	"context"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"strings"
+)
+
+// Outcome of an Authorizer for a request
+type Verdict int
+
+const (
+	// Left to the next authorizer
+	NoOpinion Verdict = iota
+	Allow
+	Deny
+)
+
+func (v Verdict) String() string {
+	switch v {
+	case Allow:
+		return "allow"
+	case Deny:
+		return "deny"
+	}
+	return "no-opinion"
+}
+
+type Decision struct {
+	Verdict Verdict
+	Reason  string
+	// Set if the request couldn't be fully evaluated, whatever the verdict
+	EvaluationError string
+	// Set for denials by rules which apply to privileged users too, so privileged users aren't exempted from them
+	Unexemptible bool
+}
+
+// Makes authorization decisions for normalised SubjectAccessReviews
+type Authorizer interface {
+	Authorize(ctx context.Context, spec *SubjectAccessReviewSpec) Decision
+}
+
+// Adapts a function to the Authorizer interface
+type AuthorizerFunc func(ctx context.Context, spec *SubjectAccessReviewSpec) Decision
+
+func (f AuthorizerFunc) Authorize(ctx context.Context, spec *SubjectAccessReviewSpec) Decision {
+	return f(ctx, spec)
+}
+
+// Authorizers consulted in order, as kube-apiserver does with its own: the first to allow or deny a
+// request decides it, and it gets no opinion if none do. The rest are skipped once ctx is done
+type Chain []Authorizer
+
+func (c Chain) Authorize(ctx context.Context, spec *SubjectAccessReviewSpec) Decision {
+	// Errors from authorizers with no opinion are kept, so failing backends remain visible
+	var evaluationErrors []string
+	for _, authorizer := range c {
+		if err := ctx.Err(); err != nil {
+			evaluationErrors = append(evaluationErrors, "evaluation abandoned: "+err.Error())
+			break
+		}
+		decision := authorizer.Authorize(ctx, spec)
+		if decision.Verdict != NoOpinion {
+			return decision
+		}
+		if decision.EvaluationError != "" {
+			evaluationErrors = append(evaluationErrors, decision.EvaluationError)
+		}
+	}
+	return Decision{EvaluationError: strings.Join(evaluationErrors, "; ")}
+}
+
+// Returns the status to respond with. Requests no authorizer decided are allowed in opinion mode, unless
+// evaluating them failed, so the failure policy rather than opinion mode decides them
+func (d Decision) Status(opinionMode bool) authorizationv1.SubjectAccessReviewStatus {
+	var status authorizationv1.SubjectAccessReviewStatus
+	switch d.Verdict {
+	case Deny:
+		status = authorizationv1.SubjectAccessReviewStatus{Denied: true, Reason: d.Reason}
+	case Allow:
+		status = authorizationv1.SubjectAccessReviewStatus{Allowed: true, Reason: d.Reason}
+	default:
+		status = DecisionStatus(true, "", opinionMode && d.EvaluationError == "")
+	}
+	status.EvaluationError = d.EvaluationError
+	return status
+}
+
+// Authorizer denying requests which break the protected namespace rules of the policy in effect in
+// Source, or the snapshot carried by the context, with no opinion on others
+type RulesAuthorizer struct {
+	Source *Source
+}
+
+func (a RulesAuthorizer) Authorize(ctx context.Context, spec *SubjectAccessReviewSpec) Decision {
+	if rule, authorized, denyReason := MatchRule(SubjectAccessReview{Spec: *spec}, a.Source.Snapshot(ctx)); !authorized {
+		return Decision{Verdict: Deny, Reason: denyReason, Unexemptible: AppliesToPrivilegedUsers(rule)}
+	}
+	return Decision{}
+}
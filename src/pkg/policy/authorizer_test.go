@@ -0,0 +1,69 @@
+package policy
+
+import (
+	"context"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"testing"
+)
+
+func fixedAuthorizer(decision Decision) Authorizer {
+	return AuthorizerFunc(func(context.Context, *SubjectAccessReviewSpec) Decision { return decision })
+}
+
+func TestChainFirstOpinionWins(t *testing.T) {
+	chain := Chain{
+		fixedAuthorizer(Decision{EvaluationError: "backend down"}),
+		fixedAuthorizer(Decision{Verdict: Deny, Reason: "denied"}),
+		fixedAuthorizer(Decision{Verdict: Allow}),
+	}
+	if decision := chain.Authorize(context.Background(), &SubjectAccessReviewSpec{}); decision.Verdict != Deny || decision.Reason != "denied" {
+		t.Errorf("Expected first deny to win, got %+v", decision)
+	}
+
+	decision := Chain{fixedAuthorizer(Decision{EvaluationError: "backend down"}), fixedAuthorizer(Decision{})}.Authorize(context.Background(), &SubjectAccessReviewSpec{})
+	if decision.Verdict != NoOpinion || decision.EvaluationError != "backend down" {
+		t.Errorf("Expected no opinion keeping evaluation error, got %+v", decision)
+	}
+}
+
+func TestDecisionStatus(t *testing.T) {
+	if status := (Decision{}).Status(true); !status.Allowed || status.Denied {
+		t.Errorf("Expected no opinion to be allowed in opinion mode, got %+v", status)
+	}
+	if status := (Decision{EvaluationError: "backend down"}).Status(false); status.Allowed || status.Denied || status.EvaluationError == "" {
+		t.Errorf("Expected no opinion with evaluation error, got %+v", status)
+	}
+	if status := (Decision{EvaluationError: "hook x: timed out"}).Status(true); status.Allowed || status.Denied || status.EvaluationError == "" {
+		t.Errorf("Expected failed evaluation not to be allowed in opinion mode, got %+v", status)
+	}
+	if status := (Decision{Verdict: Deny, Reason: "denied"}).Status(true); !status.Denied || status.Allowed || status.Reason != "denied" {
+		t.Errorf("Expected denial, got %+v", status)
+	}
+}
+
+func TestRulesAuthorizer(t *testing.T) {
+	rules := RulesAuthorizer{Source: NewSource(Config{ProtectedNamespaces: defaultProtectedNamespaces})}
+	spec := &SubjectAccessReviewSpec{User: "alice"}
+	spec.ResourceAttributes = &authorizationv1.ResourceAttributes{Namespace: "kube-system", Verb: "create", Resource: "pods"}
+	if decision := rules.Authorize(context.Background(), spec); decision.Verdict != Deny {
+		t.Errorf("Expected write to protected namespace to be denied, got %+v", decision)
+	}
+	spec.ResourceAttributes.Namespace = "default"
+	if decision := rules.Authorize(context.Background(), spec); decision.Verdict != NoOpinion {
+		t.Errorf("Expected no opinion outside protected namespaces, got %+v", decision)
+	}
+}
+
+func TestChainStopsWhenCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	chain := Chain{
+		AuthorizerFunc(func(context.Context, *SubjectAccessReviewSpec) Decision {
+			cancel()
+			return Decision{}
+		}),
+		fixedAuthorizer(Decision{Verdict: Allow}),
+	}
+	if decision := chain.Authorize(ctx, &SubjectAccessReviewSpec{}); decision.Verdict != NoOpinion || decision.EvaluationError == "" {
+		t.Errorf("Expected remaining authorizers to be skipped once cancelled, got %+v", decision)
+	}
+}
@@ -0,0 +1,36 @@
+package policy
+
+// Treatment of list and watch requests for secrets without a name or selector, which read every secret in a
+// namespace, or in every namespace, at once
+type BulkSecretReadPolicy string
+
+const (
+	// Treated as any other read, restricted in protected namespaces only
+	BulkSecretReadsAllow BulkSecretReadPolicy = "allow"
+	// Treated as any other read, but reported by BulkSecretRead so they can be logged and audited
+	BulkSecretReadsFlag BulkSecretReadPolicy = "flag"
+	// Denied in every namespace, and cluster-wide, unless the user is privileged
+	BulkSecretReadsDeny BulkSecretReadPolicy = "deny"
+)
+
+// Returns true if spec lists or watches secrets without a name, label selector or field selector, so
+// reads every secret in its namespace, or in every namespace if it has none
+func IsBulkSecretRead(spec SubjectAccessReviewSpec) bool {
+	attributes := spec.ResourceAttributes
+	if attributes == nil || attributes.Resource != "secrets" || attributes.Subresource != "" || attributes.Name != "" {
+		return false
+	}
+	if attributes.Verb != "list" && attributes.Verb != "watch" {
+		return false
+	}
+	labelSelector, fieldSelector := attributes.LabelSelector, attributes.FieldSelector
+	if labelSelector != nil && (labelSelector.RawSelector != "" || len(labelSelector.Requirements) > 0) {
+		return false
+	}
+	return fieldSelector == nil || (fieldSelector.RawSelector == "" && len(fieldSelector.Requirements) == 0)
+}
+
+// Returns true if the policy flags spec as a bulk secret read, to be logged and audited though allowed
+func (p *Policy) FlagsBulkSecretRead(spec SubjectAccessReviewSpec) bool {
+	return p.bulkSecretReads == BulkSecretReadsFlag && IsBulkSecretRead(spec)
+}
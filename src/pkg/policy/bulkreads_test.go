@@ -0,0 +1,57 @@
+package policy
+
+import (
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"testing"
+)
+
+func TestIsBulkSecretRead(t *testing.T) {
+	cases := map[string]struct {
+		attributes authorizationv1.ResourceAttributes
+		expected   bool
+	}{
+		"list":            {authorizationv1.ResourceAttributes{Namespace: "default", Verb: "list", Resource: "secrets"}, true},
+		"watch all":       {authorizationv1.ResourceAttributes{Verb: "watch", Resource: "secrets"}, true},
+		"empty selectors": {authorizationv1.ResourceAttributes{Verb: "list", Resource: "secrets", LabelSelector: &authorizationv1.LabelSelectorAttributes{}, FieldSelector: &authorizationv1.FieldSelectorAttributes{}}, true},
+		"named":           {authorizationv1.ResourceAttributes{Verb: "watch", Resource: "secrets", Name: "creds"}, false},
+		"label selector":  {authorizationv1.ResourceAttributes{Verb: "list", Resource: "secrets", LabelSelector: &authorizationv1.LabelSelectorAttributes{RawSelector: "app=web"}}, false},
+		"field selector":  {authorizationv1.ResourceAttributes{Verb: "list", Resource: "secrets", FieldSelector: &authorizationv1.FieldSelectorAttributes{RawSelector: "type=kubernetes.io/tls"}}, false},
+		"get":             {authorizationv1.ResourceAttributes{Verb: "get", Resource: "secrets"}, false},
+		"configmaps":      {authorizationv1.ResourceAttributes{Verb: "list", Resource: "configmaps"}, false},
+	}
+	for name, c := range cases {
+		if actual := IsBulkSecretRead(SubjectAccessReviewSpec{ResourceAttributes: &c.attributes}); actual != c.expected {
+			t.Errorf("%s: expected %t, got %t", name, c.expected, actual)
+		}
+	}
+}
+
+func TestBulkSecretReadPolicies(t *testing.T) {
+	request := func(user string) SubjectAccessReview {
+		return SubjectAccessReview{Spec: SubjectAccessReviewSpec{
+			User:               user,
+			ResourceAttributes: &authorizationv1.ResourceAttributes{Namespace: "default", Verb: "list", Resource: "secrets"},
+		}}
+	}
+	for _, bulkSecretReads := range []BulkSecretReadPolicy{"", BulkSecretReadsAllow, BulkSecretReadsFlag} {
+		policy := Compile(Config{ProtectedNamespaces: []string{"kube-system"}, BulkSecretReads: bulkSecretReads})
+		if authorized, _ := IsRequestAuthorized(request("alice"), policy); !authorized {
+			t.Errorf("Expected bulk secret read outside protected namespaces to be allowed with %q", bulkSecretReads)
+		}
+		if flagged := policy.FlagsBulkSecretRead(request("alice").Spec); flagged != (bulkSecretReads == BulkSecretReadsFlag) {
+			t.Errorf("Unexpected flag %t with %q", flagged, bulkSecretReads)
+		}
+	}
+
+	policy := Compile(Config{ProtectedNamespaces: []string{"kube-system"}, BulkSecretReads: BulkSecretReadsDeny})
+	if rule, authorized, _ := MatchRule(request("alice"), policy); authorized || rule != RuleBulkSecretRead {
+		t.Errorf("Expected bulk secret read to be denied by %s, got %s", RuleBulkSecretRead, rule)
+	}
+	if authorized, _ := IsRequestAuthorized(request("system:kube-controller-manager"), policy); !authorized {
+		t.Error("Expected bulk secret read by privileged system user to be allowed")
+	}
+
+	if err := (Config{BulkSecretReads: "audit"}).Validate(); err == nil {
+		t.Error("Expected unknown bulk secret read policy to be rejected")
+	}
+}
@@ -0,0 +1,62 @@
+package policy
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// How long decisions made by a rule may be cached, by the webhook and by its callers
+type CacheHint string
+
+const (
+	// Cached for the usual time
+	CacheDefault CacheHint = ""
+	// Stable, so cached for longer, e.g. allows for system components
+	CacheLong CacheHint = "long"
+	// Volatile, so never cached, e.g. denials break-glass grants override, which should take effect at once
+	CacheNone CacheHint = "none"
+)
+
+// Names of the rules cache hints may be given for
+var RuleNames = []string{
+	RuleNamespaceDeletion, RuleNameDeny, RuleAdditionalPrivilegedUser, RuleWebhookObject, RuleNameAllow,
+	RuleReservedNamespace, RuleProtectedAllResources, RuleProtectedSecrets, RuleProtectedWrite,
+	RuleWildcardRequest, RuleBulkSecretRead, RuleImpersonatedWrite, RuleDefaultAllow,
+}
+
+// Returns error if a hint is given for a rule that doesn't exist, or isn't a valid hint
+func ValidateCacheHints(hints map[string]CacheHint) error {
+	for _, rule := range slices.Sorted(maps.Keys(hints)) {
+		if !slices.Contains(RuleNames, rule) {
+			return fmt.Errorf("cache hint for unknown rule %q, expected one of %v", rule, RuleNames)
+		}
+		if err := validateCacheHint(hints[rule]); err != nil {
+			return fmt.Errorf("rule %s: %w", rule, err)
+		}
+	}
+	return nil
+}
+
+func validateCacheHint(hint CacheHint) error {
+	switch hint {
+	case CacheDefault, CacheLong, CacheNone:
+		return nil
+	}
+	return fmt.Errorf("invalid cache hint %q, must be %s or %s", hint, CacheLong, CacheNone)
+}
+
+// Returns the cache hint of the decision for spec: that of the name rule deciding it if it has one, or
+// otherwise that given for the rule deciding it in CacheHints
+func (p *Policy) CacheHint(spec SubjectAccessReviewSpec) CacheHint {
+	if !p.cacheHinted {
+		return CacheDefault
+	}
+	rule, _, _ := MatchRule(SubjectAccessReview{Spec: spec}, p)
+	if rule == RuleNameAllow || rule == RuleNameDeny {
+		if nameRule := p.matchNameRule(spec); nameRule != nil && nameRule.Cache != CacheDefault {
+			return nameRule.Cache
+		}
+	}
+	return p.config.CacheHints[rule]
+}
@@ -0,0 +1,54 @@
+package policy
+
+import (
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"testing"
+)
+
+func TestCacheHint(t *testing.T) {
+	config := Config{
+		ProtectedNamespaces:       []string{"kube-system"},
+		AdditionalPrivilegedUsers: []string{"admin"},
+		CacheHints:                map[string]CacheHint{RuleAdditionalPrivilegedUser: CacheLong, RuleProtectedWrite: CacheNone, RuleNameDeny: CacheLong},
+		NameRules: []NameRule{
+			{Name: "acme", Effect: NameRuleDeny, Resource: "secrets", ResourceNames: []string{"acme-*"}, Cache: CacheNone},
+			{Name: "cluster-info", Effect: NameRuleDeny, Resource: "configmaps", ResourceNames: []string{"cluster-info"}},
+		},
+	}
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	policy := Compile(config)
+	request := func(user string, verb string, namespace string, resource string, name string) SubjectAccessReviewSpec {
+		return SubjectAccessReviewSpec{User: user, ResourceAttributes: &authorizationv1.ResourceAttributes{Verb: verb, Namespace: namespace, Resource: resource, Name: name}}
+	}
+	cases := []struct {
+		name string
+		spec SubjectAccessReviewSpec
+		hint CacheHint
+	}{
+		{"privileged user", request("admin", "delete", "kube-system", "pods", "etcd"), CacheLong},
+		{"protected write", request("alice", "delete", "kube-system", "pods", "etcd"), CacheNone},
+		{"name rule's own hint", request("alice", "get", "default", "secrets", "acme-key"), CacheNone},
+		{"name rule effect's hint", request("alice", "get", "default", "configmaps", "cluster-info"), CacheLong},
+		{"unhinted rule", request("alice", "get", "default", "pods", ""), CacheDefault},
+	}
+	for _, c := range cases {
+		if hint := policy.CacheHint(c.spec); hint != c.hint {
+			t.Errorf("%s: expected cache hint %q, got %q", c.name, c.hint, hint)
+		}
+	}
+
+	if hint := Compile(Config{ProtectedNamespaces: []string{"kube-system"}}).CacheHint(cases[1].spec); hint != CacheDefault {
+		t.Errorf("Expected no hint without cache hints, got %q", hint)
+	}
+	for _, invalid := range []Config{
+		{CacheHints: map[string]CacheHint{"protected-writes": CacheNone}},
+		{CacheHints: map[string]CacheHint{RuleDefaultAllow: "forever"}},
+		{NameRules: []NameRule{{Name: "acme", Effect: NameRuleDeny, Resource: "secrets", ResourceNames: []string{"acme-*"}, Cache: "never"}}},
+	} {
+		if err := invalid.Validate(); err == nil {
+			t.Errorf("Expected cache hints %v to be rejected", invalid)
+		}
+	}
+}
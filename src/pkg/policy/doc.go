@@ -0,0 +1,3 @@
+// Package policy is the webhook's decision engine: it normalises SubjectAccessReviews and evaluates them
+// against the protected namespace policy, so other Azimuth components can embed the same decisions
+package policy
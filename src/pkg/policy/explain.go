@@ -0,0 +1,28 @@
+package policy
+
+// Decision for a SubjectAccessReview together with the policy rule which made it
+type Explanation struct {
+	Decision string `json:"decision"`
+	Rule     string `json:"rule"`
+	Reason   string `json:"reason,omitempty"`
+	// Protected namespace entry matching the request's namespace, if any
+	ProtectedBy string `json:"protectedBy,omitempty"`
+	// Set if the user is exempt from the protected namespace rules
+	PrivilegedSystemUser bool `json:"privilegedSystemUser,omitempty"`
+	// Class of the request's verb, unrecognized verbs being treated as writes
+	VerbClass VerbClass `json:"verbClass,omitempty"`
+}
+
+func Explain(sar SubjectAccessReview, policy *Policy, opinionMode bool) Explanation {
+	rule, authorized, denyReason := MatchRule(sar, policy)
+	status := DecisionStatus(authorized, denyReason, opinionMode)
+	explanation := Explanation{Decision: DecisionLabel(status), Rule: rule, Reason: status.Reason}
+	if attributes := sar.Spec.ResourceAttributes; attributes != nil {
+		if policy.IsProtectedNamespace(attributes.Namespace) {
+			explanation.ProtectedBy = policy.protectedNamespaces.MatchingEntry(attributes.Namespace)
+		}
+		explanation.PrivilegedSystemUser = policy.IsPrivilegedSystemUser(sar.Spec.User)
+		explanation.VerbClass = policy.ClassifyVerb(attributes.Verb)
+	}
+	return explanation
+}
@@ -0,0 +1,40 @@
+package policy
+
+import (
+	"maps"
+)
+
+// SubjectAccessReview extra keys giving the identity which impersonated the user, set by proxies that
+// impersonate their callers. Without them the policy only sees the impersonated user
+const (
+	ImpersonatorUserExtraKey   = "authorization.azimuth-cloud.io/impersonator-user"
+	ImpersonatorGroupsExtraKey = "authorization.azimuth-cloud.io/impersonator-groups"
+)
+
+// Returns the spec of the same request made by the identity which impersonated the user, and false if the
+// request isn't impersonated
+func Impersonator(spec SubjectAccessReviewSpec) (SubjectAccessReviewSpec, bool) {
+	users := spec.Extra[ImpersonatorUserExtraKey]
+	if len(users) == 0 || users[0] == "" {
+		return SubjectAccessReviewSpec{}, false
+	}
+	impersonator := spec
+	impersonator.User = users[0]
+	impersonator.Groups = spec.Extra[ImpersonatorGroupsExtraKey]
+	impersonator.UID = ""
+	impersonator.Extra = maps.Clone(spec.Extra)
+	delete(impersonator.Extra, ImpersonatorUserExtraKey)
+	delete(impersonator.Extra, ImpersonatorGroupsExtraKey)
+	return impersonator, true
+}
+
+// Returns true if the policy denies spec as an impersonated write to a protected namespace, whoever the
+// identities involved
+func (p *Policy) DeniesImpersonatedWrite(spec SubjectAccessReviewSpec) bool {
+	attributes := spec.ResourceAttributes
+	if !p.denyImpersonatedProtectedWrites || attributes == nil || p.IsReadonlyVerb(attributes.Verb) || !p.IsProtectedNamespace(attributes.Namespace) {
+		return false
+	}
+	_, impersonated := Impersonator(spec)
+	return impersonated
+}
@@ -0,0 +1,70 @@
+package policy
+
+import (
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"slices"
+	"testing"
+)
+
+func impersonatedSpec(user string, impersonator string, verb string, namespace string) SubjectAccessReviewSpec {
+	spec := SubjectAccessReviewSpec{
+		User:               user,
+		ResourceAttributes: &authorizationv1.ResourceAttributes{Namespace: namespace, Verb: verb, Resource: "pods"},
+	}
+	if impersonator != "" {
+		spec.Extra = map[string]authorizationv1.ExtraValue{
+			ImpersonatorUserExtraKey:   {impersonator},
+			ImpersonatorGroupsExtraKey: {"staff"},
+			"scopes":                   {"all"},
+		}
+	}
+	return spec
+}
+
+func TestImpersonator(t *testing.T) {
+	spec := impersonatedSpec("admin", "alice", "get", "default")
+	spec.UID = "admin-uid"
+	impersonator, ok := Impersonator(spec)
+	if !ok || impersonator.User != "alice" || !slices.Equal(impersonator.Groups, []string{"staff"}) || impersonator.UID != "" {
+		t.Errorf("Expected impersonating identity, got %+v", impersonator)
+	}
+	if len(impersonator.Extra) != 1 || len(spec.Extra) != 3 {
+		t.Errorf("Expected impersonation extras to be removed from a copy, got %v", impersonator.Extra)
+	}
+	if _, ok := Impersonator(impersonatedSpec("admin", "", "get", "default")); ok {
+		t.Error("Expected request without impersonation extras not to be impersonated")
+	}
+}
+
+func TestImpersonatedRequests(t *testing.T) {
+	tests := []struct {
+		name string
+		spec SubjectAccessReviewSpec
+		// Whether authorized without and with DenyImpersonatedProtectedWrites
+		authorized     bool
+		authorizedDeny bool
+	}{
+		{"unprivileged impersonating privileged", impersonatedSpec("admin", "alice", "create", "kube-system"), false, false},
+		{"privileged impersonating privileged", impersonatedSpec("admin", "root", "create", "kube-system"), true, false},
+		{"privileged impersonating privileged read", impersonatedSpec("admin", "root", "get", "kube-system"), true, true},
+		{"unprivileged impersonating outside protected namespaces", impersonatedSpec("admin", "alice", "create", "default"), true, true},
+		{"privileged without impersonation", impersonatedSpec("admin", "", "create", "kube-system"), true, true},
+	}
+	for _, deny := range []bool{false, true} {
+		policy := Compile(Config{ProtectedNamespaces: []string{"kube-system"}, AdditionalPrivilegedUsers: []string{"admin", "root"}, DenyImpersonatedProtectedWrites: deny})
+		for _, test := range tests {
+			expected := test.authorized
+			if deny {
+				expected = test.authorizedDeny
+			}
+			if authorized, reason := IsRequestAuthorized(SubjectAccessReview{Spec: test.spec}, policy); authorized != expected {
+				t.Errorf("%s with deny %v: expected authorized %v, got %v %q", test.name, deny, expected, authorized, reason)
+			}
+		}
+	}
+
+	policy := Compile(Config{ProtectedNamespaces: []string{"kube-system"}, AdditionalPrivilegedUsers: []string{"admin"}})
+	if _, reason := IsRequestAuthorized(SubjectAccessReview{Spec: impersonatedSpec("admin", "alice", "create", "kube-system")}, policy); reason != "Cannot write to protected namespace as impersonator alice" {
+		t.Errorf("Unexpected reason %q", reason)
+	}
+}
@@ -0,0 +1,109 @@
+package policy
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// Caps on the size of SubjectAccessReview specs, which are otherwise only bounded by the request body.
+// Zero fields are unlimited
+type Limits struct {
+	MaxGroups    int
+	MaxExtraKeys int
+	// Values of each extra key
+	MaxExtraValues int
+	// Bytes in any one string, such as the user, a group or an attribute
+	MaxFieldLength int
+	// Drops groups beyond the limits rather than rejecting the request. Other fields are never truncated, as
+	// the user, attributes and extras decide which rules apply
+	TruncateGroups bool
+}
+
+// Limits exceeded, as reported by LimitError
+const (
+	LimitGroups      = "groups"
+	LimitExtraKeys   = "extra-keys"
+	LimitExtraValues = "extra-values"
+	LimitFieldLength = "field-length"
+)
+
+// Error for a SubjectAccessReview exceeding a limit
+type LimitError struct {
+	Limit string
+	// Field exceeding the limit, or holding the string that does
+	Field string
+	Max   int
+}
+
+func (e *LimitError) Error() string {
+	switch e.Limit {
+	case LimitFieldLength:
+		return fmt.Sprintf("SubjectAccessReview %s is longer than %d bytes", e.Field, e.Max)
+	case LimitExtraValues:
+		return fmt.Sprintf("SubjectAccessReview %s has more than %d values", e.Field, e.Max)
+	}
+	return fmt.Sprintf("SubjectAccessReview %s has more than %d entries", e.Field, e.Max)
+}
+
+// Checks spec against the limits, dropping groups beyond them if TruncateGroups is set. Returns the limits on
+// groups that were exceeded and truncated, and a LimitError if any limit was exceeded otherwise
+func (l Limits) Enforce(spec *SubjectAccessReviewSpec) ([]string, error) {
+	var truncated []string
+	if l.MaxFieldLength > 0 && slices.ContainsFunc(spec.Groups, l.tooLong) {
+		if !l.TruncateGroups {
+			return nil, &LimitError{Limit: LimitFieldLength, Field: "spec.groups", Max: l.MaxFieldLength}
+		}
+		spec.Groups = slices.DeleteFunc(slices.Clone(spec.Groups), l.tooLong)
+		truncated = append(truncated, LimitFieldLength)
+	}
+	if l.MaxGroups > 0 && len(spec.Groups) > l.MaxGroups {
+		if !l.TruncateGroups {
+			return nil, &LimitError{Limit: LimitGroups, Field: "spec.groups", Max: l.MaxGroups}
+		}
+		spec.Groups = spec.Groups[:l.MaxGroups:l.MaxGroups]
+		truncated = append(truncated, LimitGroups)
+	}
+
+	if l.MaxExtraKeys > 0 && len(spec.Extra) > l.MaxExtraKeys {
+		return truncated, &LimitError{Limit: LimitExtraKeys, Field: "spec.extra", Max: l.MaxExtraKeys}
+	}
+	for _, key := range slices.Sorted(maps.Keys(spec.Extra)) {
+		values := spec.Extra[key]
+		field := "spec.extra." + key
+		if l.tooLong(key) {
+			return truncated, &LimitError{Limit: LimitFieldLength, Field: "spec.extra", Max: l.MaxFieldLength}
+		}
+		if l.MaxExtraValues > 0 && len(values) > l.MaxExtraValues {
+			return truncated, &LimitError{Limit: LimitExtraValues, Field: field, Max: l.MaxExtraValues}
+		}
+		if slices.ContainsFunc(values, l.tooLong) {
+			return truncated, &LimitError{Limit: LimitFieldLength, Field: field, Max: l.MaxFieldLength}
+		}
+	}
+
+	fields := map[string]string{"spec.user": spec.User, "spec.uid": spec.UID}
+	if attributes := spec.ResourceAttributes; attributes != nil {
+		fields["spec.resourceAttributes.namespace"] = attributes.Namespace
+		fields["spec.resourceAttributes.verb"] = attributes.Verb
+		fields["spec.resourceAttributes.group"] = attributes.Group
+		fields["spec.resourceAttributes.version"] = attributes.Version
+		fields["spec.resourceAttributes.resource"] = attributes.Resource
+		fields["spec.resourceAttributes.subresource"] = attributes.Subresource
+		fields["spec.resourceAttributes.name"] = attributes.Name
+	}
+	if attributes := spec.NonResourceAttributes; attributes != nil {
+		fields["spec.nonResourceAttributes.path"] = attributes.Path
+		fields["spec.nonResourceAttributes.verb"] = attributes.Verb
+	}
+	for _, field := range slices.Sorted(maps.Keys(fields)) {
+		if l.tooLong(fields[field]) {
+			return truncated, &LimitError{Limit: LimitFieldLength, Field: field, Max: l.MaxFieldLength}
+		}
+	}
+	return truncated, nil
+}
+
+func (l Limits) tooLong(value string) bool {
+	return l.MaxFieldLength > 0 && len(value) > l.MaxFieldLength
+}
@@ -0,0 +1,55 @@
+package policy
+
+import (
+	"errors"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestLimits(t *testing.T) {
+	limits := Limits{MaxGroups: 2, MaxExtraKeys: 1, MaxExtraValues: 2, MaxFieldLength: 8}
+	spec := func() SubjectAccessReviewSpec {
+		return SubjectAccessReviewSpec{
+			User:               "alice",
+			Groups:             []string{"staff"},
+			Extra:              map[string]authorizationv1.ExtraValue{"scopes": {"all"}},
+			ResourceAttributes: &authorizationv1.ResourceAttributes{Namespace: "default", Verb: "get", Resource: "pods"},
+		}
+	}
+	tests := []struct {
+		name   string
+		modify func(spec *SubjectAccessReviewSpec)
+		limit  string
+	}{
+		{"within limits", func(*SubjectAccessReviewSpec) {}, ""},
+		{"too many groups", func(spec *SubjectAccessReviewSpec) { spec.Groups = []string{"a", "b", "c"} }, LimitGroups},
+		{"long group", func(spec *SubjectAccessReviewSpec) { spec.Groups = []string{strings.Repeat("a", 9)} }, LimitFieldLength},
+		{"too many extra keys", func(spec *SubjectAccessReviewSpec) { spec.Extra["other"] = nil }, LimitExtraKeys},
+		{"too many extra values", func(spec *SubjectAccessReviewSpec) { spec.Extra["scopes"] = []string{"a", "b", "c"} }, LimitExtraValues},
+		{"long extra value", func(spec *SubjectAccessReviewSpec) { spec.Extra["scopes"] = []string{strings.Repeat("a", 9)} }, LimitFieldLength},
+		{"long user", func(spec *SubjectAccessReviewSpec) { spec.User = strings.Repeat("a", 9) }, LimitFieldLength},
+		{"long namespace", func(spec *SubjectAccessReviewSpec) { spec.ResourceAttributes.Namespace = strings.Repeat("a", 9) }, LimitFieldLength},
+	}
+	for _, test := range tests {
+		spec := spec()
+		test.modify(&spec)
+		_, err := limits.Enforce(&spec)
+		var limitErr *LimitError
+		if test.limit == "" && err != nil || test.limit != "" && (!errors.As(err, &limitErr) || limitErr.Limit != test.limit) {
+			t.Errorf("%s: expected %q limit to be exceeded, got %v", test.name, test.limit, err)
+		}
+	}
+
+	limits.TruncateGroups = true
+	groups := []string{"a", strings.Repeat("b", 9), "c", "d"}
+	truncatedSpec := SubjectAccessReviewSpec{User: "alice", Groups: groups}
+	truncated, err := limits.Enforce(&truncatedSpec)
+	if err != nil || !slices.Equal(truncatedSpec.Groups, []string{"a", "c"}) || !slices.Equal(truncated, []string{LimitFieldLength, LimitGroups}) {
+		t.Errorf("Expected long and excess groups to be dropped, got %v %v %v", truncatedSpec.Groups, truncated, err)
+	}
+	if groups[1] != strings.Repeat("b", 9) {
+		t.Error("Expected request's groups not to be modified in place")
+	}
+}
@@ -0,0 +1,159 @@
+package policy
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Outcome of a name rule for the requests it matches
+type NameRuleEffect string
+
+const (
+	// Exempts the requests from the protected namespace rules
+	NameRuleAllow NameRuleEffect = "allow"
+	NameRuleDeny  NameRuleEffect = "deny"
+)
+
+// Rule for requests for particular objects, by name, e.g. denying reads of an ACME account key in any
+// namespace to everyone but cert-manager. Only requests naming an object match, so lists and watches
+// don't, and the first rule matching a request decides it before the protected namespace rules
+type NameRule struct {
+	// Identifies the rule in deny reasons and explanations
+	Name   string         `json:"name"`
+	Effect NameRuleEffect `json:"effect"`
+	// As RESOURCE[.GROUP], e.g. secrets or certificates.cert-manager.io
+	Resource string `json:"resource"`
+	// Names of the objects, which may be glob patterns
+	ResourceNames []string `json:"resourceNames"`
+	// Namespace entries, as for protected namespaces. Every namespace if empty
+	Namespaces []string `json:"namespaces,omitempty"`
+	// Every verb if empty. 'read' and 'write' match the verbs the policy classifies as such
+	Verbs []string `json:"verbs,omitempty"`
+	// Users and groups the rule applies to, everyone if both are empty
+	Users  []string `json:"users,omitempty"`
+	Groups []string `json:"groups,omitempty"`
+	// Users and groups the rule doesn't apply to, even if given by Users or Groups
+	ExceptUsers  []string `json:"exceptUsers,omitempty"`
+	ExceptGroups []string `json:"exceptGroups,omitempty"`
+	// How long decisions made by the rule may be cached, overriding the hint for its effect
+	Cache CacheHint `json:"cache,omitempty"`
+}
+
+type compiledNameRule struct {
+	NameRule
+	group        string
+	resource     string
+	namespaces   *NamespaceMatcher
+	verbs        stringSet
+	users        stringSet
+	groups       stringSet
+	exceptUsers  stringSet
+	exceptGroups stringSet
+	anyNamespace bool
+	anyRequester bool
+}
+
+// Returns error describing the first invalid rule, if any
+func ValidateNameRules(rules []NameRule) error {
+	names := stringSet{}
+	for _, rule := range rules {
+		if rule.Name == "" || names.Has(rule.Name) {
+			return fmt.Errorf("name rule names must be unique and not empty, got %q", rule.Name)
+		}
+		names[rule.Name] = struct{}{}
+		if rule.Effect != NameRuleAllow && rule.Effect != NameRuleDeny {
+			return fmt.Errorf("name rule %s: invalid effect %q, must be %s or %s", rule.Name, rule.Effect, NameRuleAllow, NameRuleDeny)
+		}
+		if rule.Resource == "" || rule.Resource != canonicalName(rule.Resource) || strings.ContainsAny(rule.Resource, "/*?[") {
+			return fmt.Errorf("name rule %s: invalid resource %q, expected RESOURCE[.GROUP] in lower case", rule.Name, rule.Resource)
+		}
+		if len(rule.ResourceNames) == 0 {
+			return fmt.Errorf("name rule %s: resourceNames is required", rule.Name)
+		}
+		for _, name := range rule.ResourceNames {
+			if _, err := path.Match(name, ""); err != nil || name == "" {
+				return fmt.Errorf("name rule %s: invalid resource name pattern %q", rule.Name, name)
+			}
+		}
+		if err := ValidateNamespacePatterns(rule.Namespaces); err != nil {
+			return fmt.Errorf("name rule %s: %w", rule.Name, err)
+		}
+		if err := validateCacheHint(rule.Cache); err != nil {
+			return fmt.Errorf("name rule %s: %w", rule.Name, err)
+		}
+	}
+	return nil
+}
+
+func compileNameRule(rule NameRule) compiledNameRule {
+	resource, group, _ := strings.Cut(rule.Resource, ".")
+	return compiledNameRule{
+		NameRule:     rule,
+		group:        group,
+		resource:     resource,
+		namespaces:   CompileNamespaceMatcher(rule.Namespaces),
+		verbs:        toSet(rule.Verbs),
+		users:        toSet(rule.Users),
+		groups:       toSet(rule.Groups),
+		exceptUsers:  toSet(rule.ExceptUsers),
+		exceptGroups: toSet(rule.ExceptGroups),
+		anyNamespace: len(toSet(rule.Namespaces)) == 0,
+		anyRequester: len(toSet(rule.Users)) == 0 && len(toSet(rule.Groups)) == 0,
+	}
+}
+
+func (r *compiledNameRule) matches(p *Policy, spec SubjectAccessReviewSpec) bool {
+	attributes := spec.ResourceAttributes
+	if attributes.Name == "" || attributes.Resource != r.resource || attributes.Group != r.group {
+		return false
+	}
+	if !r.anyNamespace && !r.namespaces.Matches(attributes.Namespace) {
+		return false
+	}
+	if len(r.verbs) > 0 && !r.verbs.Has(attributes.Verb) && !r.verbs.Has(p.verbClassName(attributes.Verb)) {
+		return false
+	}
+	if r.exceptUsers.Has(spec.User) || hasAnyGroup(r.exceptGroups, spec.Groups) {
+		return false
+	}
+	if !r.anyRequester && !r.users.Has(spec.User) && !hasAnyGroup(r.groups, spec.Groups) {
+		return false
+	}
+	for _, pattern := range r.ResourceNames {
+		if matched, _ := path.Match(pattern, attributes.Name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func hasAnyGroup(set stringSet, groups []string) bool {
+	for _, group := range groups {
+		if set.Has(group) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns 'read' for read verbs and 'write' for all others, as name rules match verb classes
+func (p *Policy) verbClassName(verb string) string {
+	if p.IsReadonlyVerb(verb) {
+		return "read"
+	}
+	return "write"
+}
+
+// Returns the first name rule matching the request, or nil if none do
+func (p *Policy) matchNameRule(spec SubjectAccessReviewSpec) *compiledNameRule {
+	if spec.ResourceAttributes == nil {
+		return nil
+	}
+	for i := range p.nameRules {
+		if p.nameRules[i].matches(p, spec) {
+			return &p.nameRules[i]
+		}
+	}
+	return nil
+}
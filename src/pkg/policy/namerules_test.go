@@ -0,0 +1,91 @@
+package policy
+
+import (
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"testing"
+)
+
+func TestNameRules(t *testing.T) {
+	config := Config{
+		ProtectedNamespaces:       []string{"kube-system", "kube-public"},
+		AdditionalPrivilegedUsers: []string{"admin"},
+		NameRules: []NameRule{
+			{
+				Name:          "acme-account-key",
+				Effect:        NameRuleDeny,
+				Resource:      "secrets",
+				ResourceNames: []string{"letsencrypt-*"},
+				ExceptUsers:   []string{"system:serviceaccount:cert-manager:cert-manager"},
+			},
+			{Name: "cluster-info", Effect: NameRuleAllow, Resource: "configmaps", ResourceNames: []string{"cluster-info"}, Namespaces: []string{"kube-public"}, Verbs: []string{"read", "update"}},
+		},
+	}
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	policy := Compile(config)
+	request := func(user string, verb string, namespace string, resource string, name string) SubjectAccessReview {
+		return SubjectAccessReview{Spec: SubjectAccessReviewSpec{
+			User:               user,
+			ResourceAttributes: &authorizationv1.ResourceAttributes{Namespace: namespace, Verb: verb, Resource: resource, Name: name},
+		}}
+	}
+
+	for _, c := range []struct {
+		sar        SubjectAccessReview
+		rule       string
+		authorized bool
+	}{
+		{request("alice", "get", "default", "secrets", "letsencrypt-prod"), RuleNameDeny, false},
+		// Name rules apply to privileged system users too, unless excepted
+		{request("system:serviceaccount:kube-system:operator", "get", "tenant", "secrets", "letsencrypt-prod"), RuleNameDeny, false},
+		{request("system:serviceaccount:cert-manager:cert-manager", "get", "default", "secrets", "letsencrypt-prod"), RuleDefaultAllow, true},
+		// Deny rules apply to additional privileged users too, and can't be exempted by privilege resolvers
+		{request("admin", "get", "default", "secrets", "letsencrypt-prod"), RuleNameDeny, false},
+		{request("admin", "update", "kube-system", "configmaps", "cluster-info"), RuleAdditionalPrivilegedUser, true},
+		// Requests without a name don't match
+		{request("alice", "list", "default", "secrets", ""), RuleDefaultAllow, true},
+		{request("alice", "update", "kube-public", "configmaps", "cluster-info"), RuleNameAllow, true},
+		{request("alice", "delete", "kube-public", "configmaps", "cluster-info"), RuleProtectedWrite, false},
+		{request("alice", "update", "kube-system", "configmaps", "cluster-info"), RuleProtectedWrite, false},
+	} {
+		attributes := c.sar.Spec.ResourceAttributes
+		if rule, authorized, _ := MatchRule(c.sar, policy); rule != c.rule || authorized != c.authorized {
+			t.Errorf("Expected %s %s %s/%s by %s to be decided by %s, got %s", attributes.Verb, attributes.Resource, attributes.Namespace, attributes.Name, c.sar.Spec.User, c.rule, rule)
+		}
+	}
+
+	if !AppliesToPrivilegedUsers(RuleNameDeny) || AppliesToPrivilegedUsers(RuleNameAllow) {
+		t.Error("Expected only name deny rules to apply to privileged users")
+	}
+	if _, _, reason := MatchRule(request("alice", "get", "default", "secrets", "letsencrypt-prod"), policy); reason != "Denied by name rule acme-account-key" {
+		t.Errorf("Unexpected deny reason %q", reason)
+	}
+	if trace := TraceDecision(request("alice", "get", "default", "secrets", "letsencrypt-prod"), policy, false); trace.Classifications.NameRule != "acme-account-key" {
+		t.Errorf("Expected matching name rule to be traced, got %+v", trace.Classifications)
+	}
+}
+
+func TestValidateNameRules(t *testing.T) {
+	valid := NameRule{Name: "rule", Effect: NameRuleDeny, Resource: "certificates.cert-manager.io", ResourceNames: []string{"ca"}}
+	if err := ValidateNameRules([]NameRule{valid}); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+	for name, modify := range map[string]func(rule *NameRule){
+		"name":           func(rule *NameRule) { rule.Name = "" },
+		"effect":         func(rule *NameRule) { rule.Effect = "audit" },
+		"resource":       func(rule *NameRule) { rule.Resource = "Secrets" },
+		"resource names": func(rule *NameRule) { rule.ResourceNames = nil },
+		"name pattern":   func(rule *NameRule) { rule.ResourceNames = []string{"["} },
+		"namespace":      func(rule *NameRule) { rule.Namespaces = []string{"["} },
+	} {
+		rule := valid
+		modify(&rule)
+		if err := ValidateNameRules([]NameRule{rule}); err == nil {
+			t.Errorf("Expected rule with invalid %s to be rejected", name)
+		}
+	}
+	if err := ValidateNameRules([]NameRule{valid, valid}); err == nil {
+		t.Error("Expected duplicate rule names to be rejected")
+	}
+}
@@ -0,0 +1,38 @@
+package policy
+
+import "strings"
+
+// Returns true if spec creates a namespace whose name is reserved: a protected namespace, one matching
+// ReservedNamespaces, or one extending the name of a protected namespace, such as kube-system2.
+// kube-apiserver doesn't name the namespace when authorizing its creation, so only admission requests and
+// SubjectAccessReviews naming it can be told apart
+func (p *Policy) IsReservedNamespaceCreation(spec SubjectAccessReviewSpec) bool {
+	attributes := spec.ResourceAttributes
+	if !p.namespaceCreationProtection || attributes == nil || attributes.Verb != "create" {
+		return false
+	}
+	if attributes.Group != "" || attributes.Resource != "namespaces" || attributes.Subresource != "" {
+		return false
+	}
+	namespace := attributes.Name
+	if namespace == "" {
+		namespace = attributes.Namespace
+	}
+	return namespace != "" && p.IsReservedNamespace(namespace)
+}
+
+// Returns true if namespace is reserved for privileged users to create. Exempt namespaces never are
+func (p *Policy) IsReservedNamespace(namespace string) bool {
+	if p.exemptNamespaces.Matches(namespace) {
+		return false
+	}
+	if p.protectedNamespaces.Matches(namespace) || p.reservedNamespaces.Matches(namespace) {
+		return true
+	}
+	for protected := range p.protectedNamespaces.exact {
+		if strings.HasPrefix(namespace, protected) {
+			return true
+		}
+	}
+	return false
+}
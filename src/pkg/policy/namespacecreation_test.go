@@ -0,0 +1,54 @@
+package policy
+
+import (
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"testing"
+)
+
+func TestNamespaceCreationProtection(t *testing.T) {
+	policy := Compile(Config{
+		ProtectedNamespaces:         []string{"kube-system", "openstack-*"},
+		ExemptNamespaces:            []string{"kube-system-dev"},
+		AdditionalPrivilegedUsers:   []string{"admin"},
+		NamespaceCreationProtection: true,
+		ReservedNamespaces:          []string{"azimuth-*"},
+	})
+	create := func(user string, name string) SubjectAccessReview {
+		return SubjectAccessReview{Spec: SubjectAccessReviewSpec{User: user, ResourceAttributes: &authorizationv1.ResourceAttributes{Verb: "create", Resource: "namespaces", Name: name}}}
+	}
+	cases := []struct {
+		name       string
+		sar        SubjectAccessReview
+		authorized bool
+	}{
+		{"protected namespace", create("alice", "kube-system"), false},
+		{"extending protected namespace", create("alice", "kube-system2"), false},
+		{"protected prefix", create("alice", "openstack-squatter"), false},
+		{"reserved namespace", create("alice", "azimuth-identity"), false},
+		{"exempt namespace", create("alice", "kube-system-dev"), true},
+		{"other namespace", create("alice", "tenant-acme"), true},
+		{"unnamed", create("alice", ""), true},
+		{"by privileged user", create("admin", "openstack-services"), true},
+		{"by privileged system user", create("system:serviceaccount:kube-system:namespace-operator", "azimuth-identity"), true},
+	}
+	for _, c := range cases {
+		rule, authorized, _ := MatchRule(c.sar, policy)
+		if authorized != c.authorized {
+			t.Errorf("%s: expected authorized %t, got %t by %s", c.name, c.authorized, authorized, rule)
+		}
+		if !authorized && rule != RuleReservedNamespace {
+			t.Errorf("%s: expected denial by %s, got %s", c.name, RuleReservedNamespace, rule)
+		}
+	}
+
+	// Admission requests name the namespace as its own namespace
+	admission := SubjectAccessReview{Spec: SubjectAccessReviewSpec{User: "alice", ResourceAttributes: &authorizationv1.ResourceAttributes{Verb: "create", Resource: "namespaces", Namespace: "kube-system2"}}}
+	if authorized, _ := IsRequestAuthorized(admission, policy); authorized {
+		t.Error("Expected creating a reserved namespace named only as its own namespace to be denied")
+	}
+
+	policy = Compile(Config{ProtectedNamespaces: []string{"kube-system"}, ReservedNamespaces: []string{"azimuth-*"}})
+	if authorized, _ := IsRequestAuthorized(create("alice", "kube-system2"), policy); !authorized {
+		t.Error("Expected namespace creation to be allowed without namespace creation protection")
+	}
+}
@@ -0,0 +1,27 @@
+package policy
+
+// Returns true if spec deletes a namespace whose deletion the policy protects. Requests without a name
+// delete every namespace, so are protected as long as namespace deletion protection is on
+func (p *Policy) IsProtectedNamespaceDeletion(spec SubjectAccessReviewSpec) bool {
+	attributes := spec.ResourceAttributes
+	if !p.namespaceDeletionProtection || attributes == nil || attributes.Subresource != "" {
+		return false
+	}
+	if attributes.Resource != "namespaces" || attributes.Group != "" && attributes.Group != "*" {
+		return false
+	}
+	if attributes.Verb != "delete" && attributes.Verb != "deletecollection" && attributes.Verb != "*" {
+		return false
+	}
+	// kube-apiserver gives a namespace's own namespace as the namespace of requests for it
+	namespace := attributes.Name
+	if namespace == "" && attributes.Verb != "deletecollection" {
+		namespace = attributes.Namespace
+	}
+	return namespace == "" || p.IsProtectedNamespace(namespace) || p.deletionProtectedNamespaces.Matches(namespace)
+}
+
+// Returns true if user may delete namespaces whose deletion is protected
+func (p *Policy) IsNamespaceDeleter(user string) bool {
+	return p.namespaceDeleters.Has(user)
+}
@@ -0,0 +1,55 @@
+package policy
+
+import (
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"testing"
+)
+
+func TestNamespaceDeletionProtection(t *testing.T) {
+	policy := Compile(Config{
+		ProtectedNamespaces:         []string{"kube-system"},
+		AdditionalPrivilegedUsers:   []string{"admin"},
+		NamespaceDeletionProtection: true,
+		DeletionProtectedNamespaces: []string{"tenant-*"},
+		NamespaceDeleters:           []string{"system:serviceaccount:azimuth:tenant-operator"},
+	})
+	request := func(user string, attributes authorizationv1.ResourceAttributes) SubjectAccessReview {
+		return SubjectAccessReview{Spec: SubjectAccessReviewSpec{User: user, ResourceAttributes: &attributes}}
+	}
+	cases := []struct {
+		name       string
+		sar        SubjectAccessReview
+		authorized bool
+	}{
+		{"protected namespace", request("alice", authorizationv1.ResourceAttributes{Verb: "delete", Resource: "namespaces", Name: "kube-system"}), false},
+		{"protected namespace as its own namespace", request("alice", authorizationv1.ResourceAttributes{Verb: "delete", Resource: "namespaces", Namespace: "kube-system"}), false},
+		{"tenant namespace", request("alice", authorizationv1.ResourceAttributes{Verb: "delete", Resource: "namespaces", Name: "tenant-acme"}), false},
+		{"by privileged user", request("admin", authorizationv1.ResourceAttributes{Verb: "delete", Resource: "namespaces", Name: "tenant-acme"}), false},
+		{"by privileged system user", request("kubernetes-admin", authorizationv1.ResourceAttributes{Verb: "delete", Resource: "namespaces", Name: "kube-system"}), false},
+		{"every namespace", request("alice", authorizationv1.ResourceAttributes{Verb: "deletecollection", Resource: "namespaces"}), false},
+		{"wildcard verb", request("alice", authorizationv1.ResourceAttributes{Verb: "*", Resource: "namespaces", Name: "tenant-acme"}), false},
+		{"by namespace deleter", request("system:serviceaccount:azimuth:tenant-operator", authorizationv1.ResourceAttributes{Verb: "delete", Resource: "namespaces", Name: "tenant-acme"}), true},
+		{"unprotected namespace", request("alice", authorizationv1.ResourceAttributes{Verb: "delete", Resource: "namespaces", Name: "scratch"}), true},
+		{"update", request("alice", authorizationv1.ResourceAttributes{Verb: "update", Resource: "namespaces", Name: "tenant-acme"}), true},
+		{"finalize subresource", request("alice", authorizationv1.ResourceAttributes{Verb: "delete", Resource: "namespaces", Subresource: "finalize", Name: "tenant-acme"}), true},
+		{"custom resource", request("alice", authorizationv1.ResourceAttributes{Verb: "delete", Group: "example.com", Resource: "namespaces", Name: "tenant-acme"}), true},
+	}
+	for _, c := range cases {
+		rule, authorized, _ := MatchRule(c.sar, policy)
+		if authorized != c.authorized {
+			t.Errorf("%s: expected authorized %t, got %t by %s", c.name, c.authorized, authorized, rule)
+		}
+		if !authorized && rule != RuleNamespaceDeletion {
+			t.Errorf("%s: expected denial by %s, got %s", c.name, RuleNamespaceDeletion, rule)
+		}
+	}
+
+	// Off by default, deletion being left to the other rules
+	policy = Compile(Config{ProtectedNamespaces: []string{"kube-system"}, DeletionProtectedNamespaces: []string{"tenant-*"}})
+	if authorized, _ := IsRequestAuthorized(request("alice", authorizationv1.ResourceAttributes{Verb: "delete", Resource: "namespaces", Name: "tenant-acme"}), policy); !authorized {
+		t.Error("Expected namespace deletion to be allowed without namespace deletion protection")
+	}
+	if err := (Config{DeletionProtectedNamespaces: []string{"["}}).Validate(); err == nil {
+		t.Error("Expected invalid deletion protected namespace pattern to be rejected")
+	}
+}
@@ -0,0 +1,126 @@
+package policy
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Matches namespaces against protected namespace entries, which may be exact names, prefixes
+// ending in a single trailing '*' (e.g. 'openstack-*') or glob patterns (e.g. '*-system').
+// Built once at policy compile time so lookups don't scale with the number of entries
+type NamespaceMatcher struct {
+	exact    stringSet
+	prefixes *prefixTrie
+	patterns []string
+}
+
+func CompileNamespaceMatcher(entries []string) *NamespaceMatcher {
+	matcher := &NamespaceMatcher{exact: stringSet{}, prefixes: &prefixTrie{}}
+	for _, entry := range entries {
+		if entry == "" {
+			continue
+		}
+		wildcards := strings.IndexAny(entry, "*?[")
+		switch {
+		case wildcards < 0:
+			matcher.exact[entry] = struct{}{}
+		case wildcards == len(entry)-1 && entry[wildcards] == '*':
+			matcher.prefixes.Insert(entry[:wildcards])
+		default:
+			matcher.patterns = append(matcher.patterns, entry)
+		}
+	}
+	return matcher
+}
+
+// Returns error describing the first malformed namespace pattern, if any
+func ValidateNamespacePatterns(entries []string) error {
+	for _, entry := range entries {
+		if _, err := path.Match(entry, ""); err != nil {
+			return fmt.Errorf("invalid namespace pattern %q: %w", entry, err)
+		}
+	}
+	return nil
+}
+
+// Returns true if namespace is protected. The empty (all namespaces) namespace never matches
+func (m *NamespaceMatcher) Matches(namespace string) bool {
+	if namespace == "" {
+		return false
+	}
+	if m.exact.Has(namespace) || m.prefixes.HasPrefixOf(namespace) {
+		return true
+	}
+	for _, pattern := range m.patterns {
+		if matched, _ := path.Match(pattern, namespace); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the entry protecting namespace, or the empty string if it isn't protected. The shortest
+// matching prefix is reported when several match
+func (m *NamespaceMatcher) MatchingEntry(namespace string) string {
+	if namespace == "" {
+		return ""
+	}
+	if m.exact.Has(namespace) {
+		return namespace
+	}
+	if prefix, ok := m.prefixes.PrefixOf(namespace); ok {
+		return prefix + "*"
+	}
+	for _, pattern := range m.patterns {
+		if matched, _ := path.Match(pattern, namespace); matched {
+			return pattern
+		}
+	}
+	return ""
+}
+
+// Byte-wise trie answering whether any inserted prefix is a prefix of a given string
+type prefixTrie struct {
+	children map[byte]*prefixTrie
+	terminal bool
+}
+
+func (t *prefixTrie) Insert(prefix string) {
+	node := t
+	for i := 0; i < len(prefix); i++ {
+		if node.children == nil {
+			node.children = map[byte]*prefixTrie{}
+		}
+		child, ok := node.children[prefix[i]]
+		if !ok {
+			child = &prefixTrie{}
+			node.children[prefix[i]] = child
+		}
+		node = child
+	}
+	node.terminal = true
+}
+
+func (t *prefixTrie) HasPrefixOf(value string) bool {
+	_, ok := t.PrefixOf(value)
+	return ok
+}
+
+// Returns the shortest inserted prefix of value
+func (t *prefixTrie) PrefixOf(value string) (string, bool) {
+	node := t
+	for i := 0; ; i++ {
+		if node.terminal {
+			return value[:i], true
+		}
+		if i == len(value) {
+			return "", false
+		}
+		child, ok := node.children[value[i]]
+		if !ok {
+			return "", false
+		}
+		node = child
+	}
+}
@@ -0,0 +1,80 @@
+package policy
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func TestNamespaceMatcher(t *testing.T) {
+	matcher := CompileNamespaceMatcher([]string{"kube-system", "openstack-*", "*-secure", "tenant-?-infra", ""})
+	cases := map[string]bool{
+		"kube-system":       true,
+		"kube-system2":      false,
+		"openstack-":        true,
+		"openstack-system":  true,
+		"openstack":         false,
+		"very-secure":       true,
+		"secure":            false,
+		"tenant-a-infra":    true,
+		"tenant-ab-infra":   false,
+		"":                  false,
+		"unrelated-default": false,
+	}
+	for namespace, expected := range cases {
+		if actual := matcher.Matches(namespace); actual != expected {
+			t.Errorf("Matches(%q) = %t, expected %t", namespace, actual, expected)
+		}
+	}
+}
+
+func TestInvalidNamespacePattern(t *testing.T) {
+	if err := (Config{ProtectedNamespaces: []string{"kube-system", "bad-["}}).Validate(); err == nil {
+		t.Error("Expected invalid namespace pattern to be rejected")
+	}
+}
+
+func TestPrefixProtectedNamespaceServiceAccountAllowed(t *testing.T) {
+	policy := Compile(Config{ProtectedNamespaces: []string{"openstack-*"}})
+	if !policy.IsPrivilegedSystemUser("system:serviceaccount:openstack-capi:manager") {
+		t.Error("Expected service account in prefix protected namespace to be privileged")
+	}
+}
+
+func largeNamespaceList(n int) []string {
+	namespaces := make([]string, n)
+	for i := range namespaces {
+		namespaces[i] = fmt.Sprintf("protected-namespace-%d", i)
+	}
+	return namespaces
+}
+
+func BenchmarkNamespaceMatcherExact(b *testing.B) {
+	namespaces := largeNamespaceList(5000)
+	matcher := CompileNamespaceMatcher(namespaces)
+	for b.Loop() {
+		matcher.Matches("protected-namespace-4999")
+	}
+}
+
+func BenchmarkNamespaceMatcherPrefix(b *testing.B) {
+	matcher := CompileNamespaceMatcher(append(largeNamespaceList(5000), "tenant-*"))
+	for b.Loop() {
+		matcher.Matches("tenant-abcdef-workloads")
+	}
+}
+
+func BenchmarkNamespaceMatcherMiss(b *testing.B) {
+	matcher := CompileNamespaceMatcher(append(largeNamespaceList(5000), "tenant-*", "*-system"))
+	for b.Loop() {
+		matcher.Matches("unprotected-namespace")
+	}
+}
+
+// Baseline for comparison with the matcher, equivalent to the previous slice scan
+func BenchmarkNamespaceSliceScan(b *testing.B) {
+	namespaces := largeNamespaceList(5000)
+	for b.Loop() {
+		_ = slices.Contains(namespaces, "protected-namespace-4999")
+	}
+}
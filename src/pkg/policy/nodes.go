@@ -0,0 +1,84 @@
+package policy
+
+import (
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"slices"
+	"strings"
+)
+
+// Namespace of the leases kubelets renew as heartbeats, one named after each node
+const nodeLeaseNamespace = "kube-node-lease"
+
+// Resources kubelets read by name for the pods they run, which the Node authorizer limits to those
+// referenced by the node's pods
+var nodePodDependencies = toSet([]string{"/secrets", "/configmaps", "/serviceaccounts", "/persistentvolumeclaims", "/persistentvolumes", "resource.k8s.io/resourceclaims"})
+
+// Returns true if NodeRestriction is on and spec is a request by a node user which isn't plausibly
+// related to the node, or reads secrets in a protected namespace, so the user isn't treated as a
+// privileged system user for it
+func (p *Policy) RestrictsNodeRequest(spec SubjectAccessReviewSpec) bool {
+	if !p.nodeRestriction || spec.ResourceAttributes == nil {
+		return false
+	}
+	node, ok := strings.CutPrefix(spec.User, NodeUserPrefix)
+	if !ok || node == "" {
+		return false
+	}
+	attributes := spec.ResourceAttributes
+	// Which pods are bound to the node can't be told from the request, so secrets in protected namespaces
+	// are kept from every node rather than trusting it to only read those its pods use
+	protectedSecret := attributes.Group == "" && attributes.Resource == "secrets" && p.IsProtectedNamespace(attributes.Namespace)
+	return protectedSecret || !isNodeScoped(node, attributes)
+}
+
+// Returns true if attributes are plausibly those of a request the kubelet of node makes, mirroring the
+// Node authorizer's intent as far as a request alone allows: its own Node, CSINode and Lease, the pods
+// bound to it, and the objects its pods depend on by name. Which pods are bound to the node can't be
+// told from the request, so named reads of pod dependencies are allowed in every namespace, though
+// RestrictsNodeRequest still restricts those of secrets in protected namespaces
+func isNodeScoped(node string, attributes *authorizationv1.ResourceAttributes) bool {
+	readonly := attributes.Verb == "get" || attributes.Verb == "list" || attributes.Verb == "watch"
+	switch attributes.Group + "/" + attributes.Resource {
+	case "/nodes", "storage.k8s.io/csinodes":
+		return attributes.Name == node || readonly && attributes.Name == "" && attributes.Subresource == "" || attributes.Verb == "create" && attributes.Name == ""
+	case "coordination.k8s.io/leases":
+		return attributes.Namespace == nodeLeaseNamespace && (attributes.Name == node || attributes.Verb == "create" && attributes.Name == "")
+	case "/pods":
+		switch {
+		case attributes.Verb == "list" || attributes.Verb == "watch":
+			return attributes.Name != "" || selectsField(attributes, "spec.nodeName", node)
+		case attributes.Subresource == "status":
+			return attributes.Verb == "get" || attributes.Verb == "update" || attributes.Verb == "patch"
+		case attributes.Subresource != "":
+			return false
+		}
+		// Mirror pods are created and deleted by the kubelet of the node they run on
+		return attributes.Verb == "get" || attributes.Verb == "create" || attributes.Verb == "delete" && attributes.Name != ""
+	case "/events", "events.k8s.io/events":
+		return attributes.Verb == "create" || attributes.Verb == "update" || attributes.Verb == "patch"
+	case "/serviceaccounts":
+		if attributes.Subresource == "token" {
+			return attributes.Verb == "create" && attributes.Name != ""
+		}
+	case "certificates.k8s.io/certificatesigningrequests":
+		return attributes.Verb == "create" || readonly && attributes.Subresource == ""
+	}
+	if !nodePodDependencies.Has(attributes.Group+"/"+attributes.Resource) || attributes.Subresource != "" {
+		return false
+	}
+	// Kubelets watch the objects they mount one at a time
+	return readonly && (attributes.Name != "" || selectsField(attributes, "metadata.name", ""))
+}
+
+// Returns true if attributes' field selector requires key to be value, or any single value if value is
+// empty. The raw selector is ignored, as kube-apiserver parses it into requirements
+func selectsField(attributes *authorizationv1.ResourceAttributes, key string, value string) bool {
+	if attributes.FieldSelector == nil {
+		return false
+	}
+	return slices.ContainsFunc(attributes.FieldSelector.Requirements, func(requirement metav1.FieldSelectorRequirement) bool {
+		return requirement.Key == key && requirement.Operator == metav1.FieldSelectorOpIn && len(requirement.Values) == 1 &&
+			(value == "" || requirement.Values[0] == value)
+	})
+}
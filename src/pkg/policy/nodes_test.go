@@ -0,0 +1,59 @@
+package policy
+
+import (
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"testing"
+)
+
+func TestNodeRestriction(t *testing.T) {
+	policy := Compile(Config{ProtectedNamespaces: []string{"kube-system"}, NodeRestriction: true})
+	request := func(attributes authorizationv1.ResourceAttributes) SubjectAccessReview {
+		return SubjectAccessReview{Spec: SubjectAccessReviewSpec{User: "system:node:worker-1", ResourceAttributes: &attributes}}
+	}
+	onNode := &authorizationv1.FieldSelectorAttributes{Requirements: []metav1.FieldSelectorRequirement{{Key: "spec.nodeName", Operator: metav1.FieldSelectorOpIn, Values: []string{"worker-1"}}}}
+	single := &authorizationv1.FieldSelectorAttributes{Requirements: []metav1.FieldSelectorRequirement{{Key: "metadata.name", Operator: metav1.FieldSelectorOpIn, Values: []string{"kube-proxy-token"}}}}
+	cases := []struct {
+		name       string
+		sar        SubjectAccessReview
+		authorized bool
+	}{
+		{"own node status", request(authorizationv1.ResourceAttributes{Verb: "patch", Resource: "nodes", Subresource: "status", Name: "worker-1"}), true},
+		{"own lease", request(authorizationv1.ResourceAttributes{Verb: "update", Group: "coordination.k8s.io", Resource: "leases", Namespace: "kube-node-lease", Name: "worker-1"}), true},
+		{"named secret", request(authorizationv1.ResourceAttributes{Verb: "get", Resource: "secrets", Namespace: "tenant-a", Name: "db-credentials"}), true},
+		{"single secret watch", request(authorizationv1.ResourceAttributes{Verb: "watch", Resource: "secrets", Namespace: "tenant-a", FieldSelector: single}), true},
+		{"named secret in protected namespace", request(authorizationv1.ResourceAttributes{Verb: "get", Resource: "secrets", Namespace: "kube-system", Name: "kube-proxy-token"}), false},
+		{"single secret watch in protected namespace", request(authorizationv1.ResourceAttributes{Verb: "watch", Resource: "secrets", Namespace: "kube-system", FieldSelector: single}), false},
+		{"named configmap in protected namespace", request(authorizationv1.ResourceAttributes{Verb: "get", Resource: "configmaps", Namespace: "kube-system", Name: "kube-proxy"}), true},
+		{"pods on node", request(authorizationv1.ResourceAttributes{Verb: "list", Resource: "pods", FieldSelector: onNode}), true},
+		{"mirror pod", request(authorizationv1.ResourceAttributes{Verb: "create", Resource: "pods", Namespace: "kube-system"}), true},
+		{"pod status", request(authorizationv1.ResourceAttributes{Verb: "patch", Resource: "pods", Subresource: "status", Namespace: "kube-system", Name: "etcd-worker-1"}), true},
+		{"service account token", request(authorizationv1.ResourceAttributes{Verb: "create", Resource: "serviceaccounts", Subresource: "token", Namespace: "kube-system", Name: "kube-proxy"}), true},
+		{"secrets list", request(authorizationv1.ResourceAttributes{Verb: "list", Resource: "secrets", Namespace: "kube-system"}), false},
+		{"secrets in all namespaces", request(authorizationv1.ResourceAttributes{Verb: "watch", Resource: "secrets"}), false},
+		{"secret write", request(authorizationv1.ResourceAttributes{Verb: "update", Resource: "secrets", Namespace: "kube-system", Name: "kube-proxy-token"}), false},
+		{"other node's lease", request(authorizationv1.ResourceAttributes{Verb: "update", Group: "coordination.k8s.io", Resource: "leases", Namespace: "kube-node-lease", Name: "worker-2"}), true},
+		{"other lease in protected namespace", request(authorizationv1.ResourceAttributes{Verb: "update", Group: "coordination.k8s.io", Resource: "leases", Namespace: "kube-system", Name: "kube-scheduler"}), false},
+		{"pods on every node", request(authorizationv1.ResourceAttributes{Verb: "list", Resource: "pods", Namespace: "kube-system"}), true},
+		{"pod exec", request(authorizationv1.ResourceAttributes{Verb: "create", Resource: "pods", Subresource: "exec", Namespace: "kube-system", Name: "etcd-worker-1"}), false},
+		{"deployment write", request(authorizationv1.ResourceAttributes{Verb: "patch", Group: "apps", Resource: "deployments", Namespace: "kube-system", Name: "coredns"}), false},
+	}
+	for _, c := range cases {
+		if authorized, reason := IsRequestAuthorized(c.sar, policy); authorized != c.authorized {
+			t.Errorf("%s: expected authorized %t, got %t: %s", c.name, c.authorized, authorized, reason)
+		}
+	}
+
+	if !policy.RestrictsNodeRequest(request(authorizationv1.ResourceAttributes{Verb: "update", Group: "coordination.k8s.io", Resource: "leases", Namespace: "kube-node-lease", Name: "worker-2"}).Spec) {
+		t.Error("Expected request for another node's lease to be restricted")
+	}
+	if policy.RestrictsNodeRequest(SubjectAccessReviewSpec{User: "alice", ResourceAttributes: &authorizationv1.ResourceAttributes{Verb: "list", Resource: "secrets"}}) {
+		t.Error("Expected only node users' requests to be restricted")
+	}
+
+	// Off by default, node users being privileged for every request
+	policy = Compile(Config{ProtectedNamespaces: []string{"kube-system"}})
+	if authorized, _ := IsRequestAuthorized(cases[4].sar, policy); !authorized {
+		t.Error("Expected node user to be privileged without node restriction")
+	}
+}
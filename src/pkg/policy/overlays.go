@@ -0,0 +1,127 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+)
+
+// Entries added to and removed from a list setting of the base policy. Removals are made first, so an
+// overlay can't remove what it adds
+type ListPatch struct {
+	Add    []string `json:"add,omitempty"`
+	Remove []string `json:"remove,omitempty"`
+}
+
+func (l ListPatch) apply(values []string) []string {
+	if len(l.Add) == 0 && len(l.Remove) == 0 {
+		return values
+	}
+	values = slices.DeleteFunc(slices.Clone(values), func(value string) bool { return slices.Contains(l.Remove, value) })
+	for _, value := range l.Add {
+		values = appendMissing(values, value)
+	}
+	return values
+}
+
+// Name rules added to and removed from the base policy, removals being by name and made first
+type NameRulePatch struct {
+	Add    []NameRule `json:"add,omitempty"`
+	Remove []string   `json:"remove,omitempty"`
+}
+
+// Changes to the base policy for the clusters an overlay matches, so fleet-wide guardrails can be set once
+// and adjusted per cluster. Every overlay matching a cluster is applied, in the order given, so later
+// overlays take precedence: they see the lists earlier ones produced, and their settings replace earlier
+// ones. The default webhook objects are protected whatever overlays remove
+type Overlay struct {
+	// Identifies the overlay in logs, audit events and errors
+	Name string `json:"name"`
+	// Patterns matching the namespace/name of the clusters the overlay applies to, e.g. az-staging/*
+	Clusters                  []string  `json:"clusters"`
+	ProtectedNamespaces       ListPatch `json:"protectedNamespaces,omitempty"`
+	ExemptNamespaces          ListPatch `json:"exemptNamespaces,omitempty"`
+	AdditionalPrivilegedUsers ListPatch `json:"additionalPrivilegedUsers,omitempty"`
+	PrivilegedServiceAccounts ListPatch `json:"privilegedServiceAccounts,omitempty"`
+	WebhookObjects            ListPatch `json:"webhookObjects,omitempty"`
+	// Added rules are considered after the base policy's, so they can't override its rules for the same
+	// objects
+	NameRules NameRulePatch `json:"nameRules,omitempty"`
+	// Replace the base policy's settings if set
+	WildcardRequests WildcardPolicy       `json:"wildcardRequests,omitempty"`
+	BulkSecretReads  BulkSecretReadPolicy `json:"bulkSecretReads,omitempty"`
+}
+
+// Returns error describing every invalid overlay. Overlays are also checked when applied, as the policy
+// they produce is validated like any other
+func ValidateOverlays(overlays []Overlay) error {
+	var errs []error
+	names := stringSet{}
+	for _, overlay := range overlays {
+		if overlay.Name == "" || names.Has(overlay.Name) {
+			errs = append(errs, fmt.Errorf("overlay names must be unique and not empty, got %q", overlay.Name))
+			continue
+		}
+		names[overlay.Name] = struct{}{}
+		if len(overlay.Clusters) == 0 {
+			errs = append(errs, fmt.Errorf("overlay %s: clusters is required, '*/*' matching every cluster", overlay.Name))
+		}
+		for _, pattern := range overlay.Clusters {
+			if _, err := path.Match(pattern, ""); err != nil || !strings.Contains(pattern, "/") {
+				errs = append(errs, fmt.Errorf("overlay %s: invalid cluster pattern %q, expected NAMESPACE/NAME", overlay.Name, pattern))
+			}
+		}
+		if err := ValidateNamespacePatterns(overlay.ProtectedNamespaces.Add); err != nil {
+			errs = append(errs, fmt.Errorf("overlay %s: %w", overlay.Name, err))
+		}
+		if err := ValidateNameRules(overlay.NameRules.Add); err != nil {
+			errs = append(errs, fmt.Errorf("overlay %s: %w", overlay.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Reports whether the overlay applies to the cluster, given as namespace/name
+func (o Overlay) Matches(cluster string) bool {
+	for _, pattern := range o.Clusters {
+		if matched, _ := path.Match(pattern, cluster); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns config with the overlay applied
+func (o Overlay) Apply(config Config) Config {
+	config.ProtectedNamespaces = o.ProtectedNamespaces.apply(config.ProtectedNamespaces)
+	config.ExemptNamespaces = o.ExemptNamespaces.apply(config.ExemptNamespaces)
+	config.AdditionalPrivilegedUsers = o.AdditionalPrivilegedUsers.apply(config.AdditionalPrivilegedUsers)
+	config.PrivilegedServiceAccounts = o.PrivilegedServiceAccounts.apply(config.PrivilegedServiceAccounts)
+	config.WebhookObjects = o.WebhookObjects.apply(config.WebhookObjects)
+	if len(o.NameRules.Add) > 0 || len(o.NameRules.Remove) > 0 {
+		rules := slices.DeleteFunc(slices.Clone(config.NameRules), func(rule NameRule) bool { return slices.Contains(o.NameRules.Remove, rule.Name) })
+		config.NameRules = append(rules, o.NameRules.Add...)
+	}
+	if o.WildcardRequests != "" {
+		config.WildcardRequests = o.WildcardRequests
+	}
+	if o.BulkSecretReads != "" {
+		config.BulkSecretReads = o.BulkSecretReads
+	}
+	return config
+}
+
+// Returns config with every overlay matching the cluster, given as namespace/name, applied in order, and
+// the names of those applied
+func ApplyOverlays(config Config, overlays []Overlay, cluster string) (Config, []string) {
+	var applied []string
+	for _, overlay := range overlays {
+		if overlay.Matches(cluster) {
+			config = overlay.Apply(config)
+			applied = append(applied, overlay.Name)
+		}
+	}
+	return config, applied
+}
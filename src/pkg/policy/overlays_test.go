@@ -0,0 +1,93 @@
+package policy
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestApplyOverlays(t *testing.T) {
+	base := Config{
+		ProtectedNamespaces:       []string{"kube-system", "monitoring-system"},
+		AdditionalPrivilegedUsers: []string{"admin"},
+		NameRules:                 []NameRule{{Name: "acme", Effect: NameRuleDeny, Resource: "secrets", ResourceNames: []string{"acme-*"}}},
+	}
+	overlays := []Overlay{
+		{
+			Name:                "staging",
+			Clusters:            []string{"az-staging/*"},
+			ProtectedNamespaces: ListPatch{Add: []string{"staging-system", "kube-system"}, Remove: []string{"monitoring-system"}},
+			NameRules:           NameRulePatch{Remove: []string{"acme"}},
+			WildcardRequests:    WildcardDeny,
+		},
+		{
+			Name:                      "all",
+			Clusters:                  []string{"*/*"},
+			AdditionalPrivilegedUsers: ListPatch{Add: []string{"fleet-admin"}},
+			// Sees the list the staging overlay produced
+			ProtectedNamespaces: ListPatch{Remove: []string{"staging-system"}},
+			WildcardRequests:    WildcardProtectedNamespaces,
+		},
+	}
+
+	config, applied := ApplyOverlays(base, overlays, "az-staging/demo")
+	if !slices.Equal(applied, []string{"staging", "all"}) {
+		t.Errorf("Expected both overlays to be applied in order, got %v", applied)
+	}
+	if !slices.Equal(config.ProtectedNamespaces, []string{"kube-system"}) || !slices.Equal(config.AdditionalPrivilegedUsers, []string{"admin", "fleet-admin"}) {
+		t.Errorf("Unexpected lists %v %v", config.ProtectedNamespaces, config.AdditionalPrivilegedUsers)
+	}
+	if len(config.NameRules) != 0 || config.WildcardRequests != WildcardProtectedNamespaces {
+		t.Errorf("Expected the later overlay's settings to take precedence, got %+v", config)
+	}
+	if !slices.Equal(base.ProtectedNamespaces, []string{"kube-system", "monitoring-system"}) || len(base.NameRules) != 1 {
+		t.Error("Expected the base policy to be left unchanged")
+	}
+
+	if config, applied := ApplyOverlays(base, overlays, "az-prod/demo"); !slices.Equal(applied, []string{"all"}) || len(config.NameRules) != 1 {
+		t.Errorf("Expected only the fleet-wide overlay to apply, got %v", applied)
+	}
+}
+
+func TestSourceOverlaid(t *testing.T) {
+	source := NewSource(Config{ProtectedNamespaces: []string{"kube-system"}})
+	overlays := []Overlay{{Name: "staging", Clusters: []string{"az-staging/*"}, ProtectedNamespaces: ListPatch{Add: []string{"staging-system"}}}}
+
+	compiled, applied, err := source.Overlaid(overlays, "az-staging/demo")
+	if err != nil || len(applied) != 1 || !compiled.IsProtectedNamespace("staging-system") || compiled.Generation() != source.Current().Generation() {
+		t.Fatalf("Unexpected overlaid policy %v %v", applied, err)
+	}
+	if again, _, _ := source.Overlaid(overlays, "az-staging/other"); again != compiled {
+		t.Error("Expected the overlaid policy to be compiled once")
+	}
+	if unmatched, applied, _ := source.Overlaid(overlays, "az-prod/demo"); unmatched != source.Current() || applied != nil {
+		t.Error("Expected the policy in effect for clusters no overlay matches")
+	}
+
+	source.Set(Config{ProtectedNamespaces: []string{"kube-system"}, ExemptNamespaces: []string{"staging-*"}})
+	if replaced, _, _ := source.Overlaid(overlays, "az-staging/demo"); replaced == compiled || replaced.IsProtectedNamespace("staging-system") {
+		t.Error("Expected overlaid policies to be recompiled from the replacement policy")
+	}
+
+	invalid := []Overlay{{Name: "broken", Clusters: []string{"*/*"}, ProtectedNamespaces: ListPatch{Add: []string{"["}}}}
+	if _, _, err := source.Overlaid(invalid, "az-staging/demo"); err == nil {
+		t.Error("Expected invalid overlaid policy to be rejected")
+	}
+}
+
+func TestValidateOverlays(t *testing.T) {
+	valid := []Overlay{{Name: "staging", Clusters: []string{"az-staging/*"}}}
+	if err := ValidateOverlays(valid); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+	for name, overlays := range map[string][]Overlay{
+		"unnamed":     {{Clusters: []string{"*/*"}}},
+		"duplicate":   {valid[0], valid[0]},
+		"no clusters": {{Name: "staging"}},
+		"pattern":     {{Name: "staging", Clusters: []string{"az-staging"}}},
+		"name rule":   {{Name: "staging", Clusters: []string{"*/*"}, NameRules: NameRulePatch{Add: []NameRule{{Name: "acme"}}}}},
+	} {
+		if err := ValidateOverlays(overlays); err == nil {
+			t.Errorf("Expected overlays with invalid %s to be rejected", name)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package policy
+
+import (
+	"slices"
+)
+
+// Changes to the protected namespaces made at runtime, e.g. through the admin API, applied on top of every
+// config a Source is given so they outlive policy sync replacing it
+type NamespaceOverrides struct {
+	// Entries protected besides the configured ones
+	Protected []string `json:"protected,omitempty"`
+	// Entries never protected, even if configured
+	Exempted []string `json:"exempted,omitempty"`
+}
+
+// Returns config with the overrides applied
+func (o NamespaceOverrides) Apply(config Config) Config {
+	if len(o.Protected) > 0 {
+		config.ProtectedNamespaces = append(slices.Clone(config.ProtectedNamespaces), o.Protected...)
+	}
+	if len(o.Exempted) > 0 {
+		config.ExemptNamespaces = append(slices.Clone(config.ExemptNamespaces), o.Exempted...)
+	}
+	return config
+}
+
+// Returns the overrides protecting entry, replacing any exemption of it
+func (o NamespaceOverrides) Protect(entry string) NamespaceOverrides {
+	return NamespaceOverrides{
+		Protected: appendMissing(o.Protected, entry),
+		Exempted:  slices.DeleteFunc(slices.Clone(o.Exempted), func(e string) bool { return e == entry }),
+	}
+}
+
+// Returns the overrides exempting entry, replacing any protection of it
+func (o NamespaceOverrides) Exempt(entry string) NamespaceOverrides {
+	return NamespaceOverrides{
+		Protected: slices.DeleteFunc(slices.Clone(o.Protected), func(e string) bool { return e == entry }),
+		Exempted:  appendMissing(o.Exempted, entry),
+	}
+}
+
+// Returns the overrides without any protection or exemption of entry
+func (o NamespaceOverrides) Reset(entry string) NamespaceOverrides {
+	matches := func(e string) bool { return e == entry }
+	return NamespaceOverrides{
+		Protected: slices.DeleteFunc(slices.Clone(o.Protected), matches),
+		Exempted:  slices.DeleteFunc(slices.Clone(o.Exempted), matches),
+	}
+}
+
+func appendMissing(values []string, value string) []string {
+	if slices.Contains(values, value) {
+		return slices.Clone(values)
+	}
+	return append(slices.Clone(values), value)
+}
@@ -0,0 +1,37 @@
+package policy
+
+import (
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"slices"
+	"testing"
+)
+
+func TestNamespaceOverrides(t *testing.T) {
+	source := NewSource(Config{ProtectedNamespaces: []string{"kube-system", "openstack-*"}})
+	overrides := NamespaceOverrides{}.Protect("billing").Exempt("openstack-sandbox")
+	source.SetNamespaceOverrides(overrides)
+
+	expected := map[string]bool{"kube-system": true, "billing": true, "openstack-keystone": true, "openstack-sandbox": false}
+	for namespace, protected := range expected {
+		if source.Current().IsProtectedNamespace(namespace) != protected {
+			t.Errorf("Expected %s to be protected=%v with overrides", namespace, protected)
+		}
+	}
+	explanation := Explain(SubjectAccessReview{Spec: SubjectAccessReviewSpec{User: "alice", ResourceAttributes: &authorizationv1.ResourceAttributes{Namespace: "openstack-sandbox", Verb: "delete", Resource: "pods"}}}, source.Current(), false)
+	if explanation.ProtectedBy != "" || explanation.Decision == "denied" {
+		t.Errorf("Expected exempted namespace to be unprotected, got %+v", explanation)
+	}
+
+	// Overrides outlive the configured policy being replaced, as by policy sync
+	source.Set(Config{ProtectedNamespaces: []string{"kube-system"}})
+	if !source.Current().IsProtectedNamespace("billing") || source.Current().Generation() != 3 {
+		t.Errorf("Expected overrides to be applied to the replacement policy, got %+v", source.Current().Config())
+	}
+
+	if overrides = overrides.Exempt("billing"); len(overrides.Protected) != 0 || !slices.Equal(overrides.Exempted, []string{"openstack-sandbox", "billing"}) {
+		t.Errorf("Expected exempting a protected entry to replace its protection, got %+v", overrides)
+	}
+	if overrides = overrides.Reset("billing"); !slices.Equal(overrides.Exempted, []string{"openstack-sandbox"}) {
+		t.Errorf("Expected reset to remove the override, got %+v", overrides)
+	}
+}
@@ -0,0 +1,638 @@
+package policy
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/internal/lru"
+	"context"
+	"fmt"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Policy settings, as provided on the command line
+type Config struct {
+	ProtectedNamespaces       []string
+	AdditionalPrivilegedUsers []string
+	// Namespace entries never protected, even if matching ProtectedNamespaces
+	ExemptNamespaces []string
+	// Number of users whose privilege classification is memoised. Disabled if 0
+	ClassificationCacheSize int
+	// Resources without namespaces besides the built-in ones, as RESOURCE[.GROUP], e.g. for custom resources
+	ClusterScopedResources []string
+	// Treatment of wildcard requests outside protected namespaces, WildcardProtectedNamespaces if empty
+	WildcardRequests WildcardPolicy
+	// Treatment of list and watch requests for secrets without a name or selector outside protected
+	// namespaces, BulkSecretReadsAllow if empty
+	BulkSecretReads BulkSecretReadPolicy
+	// Denies writes to protected namespaces by impersonated users, even if both identities are privileged
+	DenyImpersonatedProtectedWrites bool
+	// Custom verbs classified as reads or writes besides the built-in ones. Verbs in neither are writes
+	AdditionalReadonlyVerbs []string
+	AdditionalWriteVerbs    []string
+	// Objects the webhook depends on besides DefaultWebhookObjects, as NAMESPACE/RESOURCE[.GROUP]/NAME,
+	// e.g. its Service and Secrets. Modifying them is denied even in exempt namespaces
+	WebhookObjects []string
+	// Leaves DefaultWebhookObjects and WebhookObjects to the protected namespace rules alone
+	DisableWebhookProtection bool
+	// Rules for requests for particular objects, considered in order before the protected namespace rules
+	NameRules []NameRule
+	// Service accounts of protected namespaces, as namespace/name, which are privileged system users when
+	// ServiceAccountAllowlist is enforced
+	PrivilegedServiceAccounts []string
+	// ServiceAccountAllowlistOff if empty, every service account of a protected namespace being privileged
+	ServiceAccountAllowlist ServiceAccountAllowlistMode
+	// Denies deleting protected namespaces, and those matching DeletionProtectedNamespaces, to every user
+	// but NamespaceDeleters, even privileged ones
+	NamespaceDeletionProtection bool
+	// Namespace entries whose deletion is protected besides protected namespaces, e.g. tenant namespaces
+	DeletionProtectedNamespaces []string
+	NamespaceDeleters           []string
+	// Only treats node users as privileged system users for requests plausibly related to their node
+	NodeRestriction bool
+	// Denies creating namespaces with reserved names to users who aren't privileged: protected namespaces,
+	// those matching ReservedNamespaces and those extending the name of a protected namespace
+	NamespaceCreationProtection bool
+	ReservedNamespaces          []string
+	// How long decisions may be cached, by the name of the rule deciding them. Name rules may give their own
+	CacheHints map[string]CacheHint
+}
+
+// Treatment of resource requests for verb '*' or resource '*', which kube-apiserver makes to check access
+// to every verb or resource at once
+type WildcardPolicy string
+
+const (
+	// Restricted in protected namespaces as any write or all resource request is, and treated as any
+	// other request elsewhere
+	WildcardProtectedNamespaces WildcardPolicy = "protected-namespaces"
+	// Denied in every namespace, and cluster-wide, unless the user is privileged
+	WildcardDeny WildcardPolicy = "deny"
+)
+
+// Policy settings compiled into hash sets at startup, so per-request checks are constant time
+// regardless of how many namespaces or users are configured
+type Policy struct {
+	protectedNamespaces *NamespaceMatcher
+	exemptNamespaces    *NamespaceMatcher
+	privilegedUsers     stringSet
+	// Keyed by group/resource
+	clusterScopedResources          stringSet
+	wildcardRequests                WildcardPolicy
+	bulkSecretReads                 BulkSecretReadPolicy
+	denyImpersonatedProtectedWrites bool
+	readonlyVerbs                   stringSet
+	writeVerbs                      stringSet
+	classifications                 *lru.Cache[string, userClassification]
+	webhookObjects                  []webhookObject
+	// Entries webhookObjects were parsed from, by index
+	webhookObjectEntries []string
+	nameRules            []compiledNameRule
+	// Keyed by namespace/name
+	privilegedServiceAccounts stringSet
+	serviceAccountAllowlist   ServiceAccountAllowlistMode
+	// The policy with the allowlist enforced, if it's only logged
+	enforcedAllowlist           *Policy
+	namespaceDeletionProtection bool
+	deletionProtectedNamespaces *NamespaceMatcher
+	namespaceDeleters           stringSet
+	nodeRestriction             bool
+	namespaceCreationProtection bool
+	reservedNamespaces          *NamespaceMatcher
+	// Set if any rule has a cache hint
+	cacheHinted bool
+	// Position in the sequence of policies made current by a Source, zero if never made current
+	generation uint64
+	config     Config
+}
+
+// Returns the generation of the policy, identifying it in logs and metrics
+func (p *Policy) Generation() uint64 {
+	return p.generation
+}
+
+// Returns the settings the policy was compiled from
+func (p *Policy) Config() Config {
+	return p.config
+}
+
+// Holds the policy in effect, which policy sync may replace while requests are being evaluated. Policies
+// are immutable once current, so requests holding one are unaffected by replacements
+type Source struct {
+	current     atomic.Pointer[Policy]
+	generations atomic.Uint64
+	// Serialises replacements, so neither the config nor the overrides are lost when both change at once
+	mu        sync.Mutex
+	config    Config
+	overrides NamespaceOverrides
+	// Policies in effect with overlays applied, keyed by the names of those applied
+	overlaid map[string]*Policy
+}
+
+func NewSource(config Config) *Source {
+	source := &Source{}
+	source.Set(config)
+	return source
+}
+
+func (s *Source) Current() *Policy {
+	return s.current.Load()
+}
+
+// Compiles config, with the source's namespace overrides, and makes it the policy in effect for subsequent
+// requests, with the next generation
+func (s *Source) Set(config Config) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.config = config
+	s.storeLocked()
+}
+
+// Replaces the namespace overrides applied to every config the source is given, recompiling the policy in
+// effect with them
+func (s *Source) SetNamespaceOverrides(overrides NamespaceOverrides) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.overrides = overrides
+	s.storeLocked()
+}
+
+func (s *Source) storeLocked() {
+	compiled := Compile(s.overrides.Apply(s.config))
+	compiled.generation = s.generations.Add(1)
+	s.current.Store(compiled)
+	s.overlaid = nil
+}
+
+// Returns the policy in effect with the overlays matching the cluster, given as namespace/name, applied
+// beneath the namespace overrides, and the names of those applied. The policy in effect is returned if none
+// match. Overlaid policies share its generation, and are compiled once until it's replaced
+func (s *Source) Overlaid(overlays []Overlay, cluster string) (*Policy, []string, error) {
+	var matching []Overlay
+	var applied []string
+	for _, overlay := range overlays {
+		if overlay.Matches(cluster) {
+			matching = append(matching, overlay)
+			applied = append(applied, overlay.Name)
+		}
+	}
+	if len(applied) == 0 {
+		return s.Current(), nil, nil
+	}
+	key := strings.Join(applied, "\n")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if compiled := s.overlaid[key]; compiled != nil {
+		return compiled, applied, nil
+	}
+	config, _ := ApplyOverlays(s.config, matching, cluster)
+	config = s.overrides.Apply(config)
+	if err := config.Validate(); err != nil {
+		return nil, applied, fmt.Errorf("overlays %s: %w", strings.Join(applied, ", "), err)
+	}
+	compiled := Compile(config)
+	compiled.generation = s.current.Load().generation
+	if s.overlaid == nil {
+		s.overlaid = map[string]*Policy{}
+	}
+	s.overlaid[key] = compiled
+	return compiled, applied, nil
+}
+
+type snapshotKey struct{}
+
+// Returns ctx carrying compiled, so every check made for a request uses the same policy even if it's
+// replaced while the request is evaluated
+func WithSnapshot(ctx context.Context, compiled *Policy) context.Context {
+	return context.WithValue(ctx, snapshotKey{}, compiled)
+}
+
+// Returns the policy carried by ctx, or the one currently in effect if ctx carries none
+func (s *Source) Snapshot(ctx context.Context) *Policy {
+	if compiled, ok := ctx.Value(snapshotKey{}).(*Policy); ok {
+		return compiled
+	}
+	return s.Current()
+}
+
+// Privileges held by a user, independent of the request being made
+type userClassification struct {
+	additionalPrivileged bool
+	privilegedSystem     bool
+}
+
+type stringSet map[string]struct{}
+
+func (s stringSet) Has(value string) bool {
+	_, ok := s[value]
+	return ok
+}
+
+var requiredSystemUsers = toSet([]string{"system:kube-controller-manager", "system:kube-scheduler", "kubernetes-admin", "kube-apiserver-kubelet-client"})
+
+const (
+	ServiceAccountUserPrefix = "system:serviceaccount:"
+	NodeUserPrefix           = "system:node:"
+	BootstrapUserPrefix      = "system:bootstrap:"
+)
+
+// Returns error if config can't be compiled into a policy which behaves as configured
+func (c Config) Validate() error {
+	if err := ValidateNamespacePatterns(c.ProtectedNamespaces); err != nil {
+		return err
+	}
+	if err := ValidateNamespacePatterns(c.ExemptNamespaces); err != nil {
+		return err
+	}
+	if err := ValidateNamespacePatterns(c.DeletionProtectedNamespaces); err != nil {
+		return err
+	}
+	if err := ValidateNamespacePatterns(c.ReservedNamespaces); err != nil {
+		return err
+	}
+	if err := ValidateClusterScopedResources(c.ClusterScopedResources); err != nil {
+		return err
+	}
+	if err := validateVerbs(c.AdditionalReadonlyVerbs, c.AdditionalWriteVerbs); err != nil {
+		return err
+	}
+	if err := ValidateWebhookObjects(c.WebhookObjects); err != nil {
+		return err
+	}
+	if err := ValidateNameRules(c.NameRules); err != nil {
+		return err
+	}
+	if err := ValidatePrivilegedServiceAccounts(c.PrivilegedServiceAccounts); err != nil {
+		return err
+	}
+	if err := ValidateCacheHints(c.CacheHints); err != nil {
+		return err
+	}
+	switch c.ServiceAccountAllowlist {
+	case "", ServiceAccountAllowlistOff, ServiceAccountAllowlistLog, ServiceAccountAllowlistEnforce:
+	default:
+		return fmt.Errorf("invalid service account allowlist mode %q, must be %s, %s or %s", c.ServiceAccountAllowlist, ServiceAccountAllowlistOff, ServiceAccountAllowlistLog, ServiceAccountAllowlistEnforce)
+	}
+	switch c.BulkSecretReads {
+	case "", BulkSecretReadsAllow, BulkSecretReadsFlag, BulkSecretReadsDeny:
+	default:
+		return fmt.Errorf("invalid bulk secret read policy %q, must be %s, %s or %s", c.BulkSecretReads, BulkSecretReadsAllow, BulkSecretReadsFlag, BulkSecretReadsDeny)
+	}
+	switch c.WildcardRequests {
+	case "", WildcardProtectedNamespaces, WildcardDeny:
+		return nil
+	}
+	return fmt.Errorf("invalid wildcard request policy %q, must be %s or %s", c.WildcardRequests, WildcardProtectedNamespaces, WildcardDeny)
+}
+
+func Compile(config Config) *Policy {
+	policy := &Policy{
+		protectedNamespaces:             CompileNamespaceMatcher(config.ProtectedNamespaces),
+		exemptNamespaces:                CompileNamespaceMatcher(config.ExemptNamespaces),
+		privilegedUsers:                 toSet(config.AdditionalPrivilegedUsers),
+		wildcardRequests:                config.WildcardRequests,
+		bulkSecretReads:                 config.BulkSecretReads,
+		denyImpersonatedProtectedWrites: config.DenyImpersonatedProtectedWrites,
+		readonlyVerbs:                   toSet(config.AdditionalReadonlyVerbs),
+		writeVerbs:                      toSet(config.AdditionalWriteVerbs),
+		privilegedServiceAccounts:       toSet(config.PrivilegedServiceAccounts),
+		serviceAccountAllowlist:         config.ServiceAccountAllowlist,
+		namespaceDeletionProtection:     config.NamespaceDeletionProtection,
+		deletionProtectedNamespaces:     CompileNamespaceMatcher(config.DeletionProtectedNamespaces),
+		namespaceDeleters:               toSet(config.NamespaceDeleters),
+		nodeRestriction:                 config.NodeRestriction,
+		namespaceCreationProtection:     config.NamespaceCreationProtection,
+		reservedNamespaces:              CompileNamespaceMatcher(config.ReservedNamespaces),
+		config:                          config,
+	}
+	clusterScopedResources := make([]string, len(config.ClusterScopedResources))
+	for i, name := range config.ClusterScopedResources {
+		clusterScopedResources[i] = clusterScopedResourceKey(name)
+	}
+	policy.clusterScopedResources = toSet(clusterScopedResources)
+	if !config.DisableWebhookProtection {
+		for _, entry := range append(slices.Clone(DefaultWebhookObjects), config.WebhookObjects...) {
+			// Invalid entries are rejected by Validate, so are only skipped here
+			if object, err := parseWebhookObject(entry); err == nil {
+				policy.webhookObjects = append(policy.webhookObjects, object)
+				policy.webhookObjectEntries = append(policy.webhookObjectEntries, entry)
+			}
+		}
+	}
+	policy.cacheHinted = len(config.CacheHints) > 0
+	for _, rule := range config.NameRules {
+		policy.nameRules = append(policy.nameRules, compileNameRule(rule))
+		policy.cacheHinted = policy.cacheHinted || rule.Cache != CacheDefault
+	}
+	if config.ServiceAccountAllowlist == ServiceAccountAllowlistLog {
+		enforced := config
+		enforced.ServiceAccountAllowlist = ServiceAccountAllowlistEnforce
+		policy.enforcedAllowlist = Compile(enforced)
+	}
+	if config.ClassificationCacheSize > 0 {
+		policy.classifications = lru.New[string, userClassification](config.ClassificationCacheSize)
+	}
+	return policy
+}
+
+// Builds set from list, ignoring empty entries left by splitting empty comma separated flags
+func toSet(values []string) stringSet {
+	set := make(stringSet, len(values))
+	for _, value := range values {
+		if value != "" {
+			set[value] = struct{}{}
+		}
+	}
+	return set
+}
+
+func (p *Policy) IsProtectedNamespace(namespace string) bool {
+	return p.protectedNamespaces.Matches(namespace) && !p.exemptNamespaces.Matches(namespace)
+}
+
+func (p *Policy) IsAdditionalPrivilegedUser(user string) bool {
+	return p.privilegedUsers.Has(user)
+}
+
+// Returns true if user is a service account with correct privileges or a privileged internal K8s system user
+func (p *Policy) IsPrivilegedSystemUser(user string) bool {
+	if requiredSystemUsers.Has(user) {
+		return true
+	}
+	if serviceAccount, ok := strings.CutPrefix(user, ServiceAccountUserPrefix); ok {
+		// Allows service accounts if they originate from protected namespaces, and are listed if required
+		serviceAccountNamespace, serviceAccountName, found := strings.Cut(serviceAccount, ":")
+		return found && p.IsProtectedNamespace(serviceAccountNamespace) && p.serviceAccountListed(serviceAccountNamespace, serviceAccountName)
+	}
+	// All node and bootstrap accounts allowed, though NodeRestriction limits node accounts to requests related to their node
+	return hasNonEmptySuffixAfter(user, NodeUserPrefix) || hasNonEmptySuffixAfter(user, BootstrapUserPrefix)
+}
+
+// Returns privileges held by user, memoised per user if the classification cache is enabled.
+// Repeated requests from the same controllers then skip classification entirely
+func (p *Policy) classifyUser(user string) userClassification {
+	if p.classifications != nil {
+		if classification, ok := p.classifications.Get(user); ok {
+			return classification
+		}
+	}
+	classification := userClassification{
+		additionalPrivileged: p.IsAdditionalPrivilegedUser(user),
+		privilegedSystem:     p.IsPrivilegedSystemUser(user),
+	}
+	if p.classifications != nil {
+		p.classifications.Add(user, classification)
+	}
+	return classification
+}
+
+// Returns true if user is exempt from the protected namespace rules, as an additional privileged user or
+// a privileged system user
+func (p *Policy) IsPrivilegedUser(user string) bool {
+	classification := p.classifyUser(user)
+	return classification.additionalPrivileged || classification.privilegedSystem
+}
+
+func hasNonEmptySuffixAfter(value string, prefix string) bool {
+	return len(value) > len(prefix) && strings.HasPrefix(value, prefix)
+}
+
+// Names of the policy's rules, reported when explaining decisions
+const (
+	RuleNamespaceDeletion        = "namespace-deletion"
+	RuleAdditionalPrivilegedUser = "additional-privileged-user"
+	RuleWebhookObject            = "webhook-object"
+	RuleNameAllow                = "name-rule-allow"
+	RuleNameDeny                 = "name-rule-deny"
+	RuleReservedNamespace        = "reserved-namespace-creation"
+	RuleProtectedAllResources    = "protected-namespace-all-resources"
+	RuleProtectedSecrets         = "protected-namespace-secrets"
+	RuleProtectedWrite           = "protected-namespace-write"
+	RuleWildcardRequest          = "wildcard-request"
+	RuleBulkSecretRead           = "bulk-secret-read"
+	RuleImpersonatedWrite        = "impersonated-protected-namespace-write"
+	RuleDefaultAllow             = "default-allow"
+)
+
+// Returns true if request passes webhook's resource access checks. If false, string with reason for rejection will also be returned, otherwise nil string
+func IsRequestAuthorized(sar SubjectAccessReview, policy *Policy) (bool, string) {
+	_, authorized, denyReason := MatchRule(sar, policy)
+	return authorized, denyReason
+}
+
+// Returns the first rule applying to the request, whether it authorizes the request and the reason if not.
+// Impersonated requests are only authorized if the impersonator's would be too
+func MatchRule(sar SubjectAccessReview, policy *Policy) (string, bool, string) {
+	if policy.DeniesImpersonatedWrite(sar.Spec) {
+		return RuleImpersonatedWrite, false, "Cannot write to protected namespace while impersonating"
+	}
+	rule, authorized, denyReason := matchIdentityRule(sar, policy)
+	if impersonator, ok := Impersonator(sar.Spec); ok && authorized {
+		if impersonatorRule, impersonatorAuthorized, impersonatorReason := matchIdentityRule(SubjectAccessReview{Spec: impersonator}, policy); !impersonatorAuthorized {
+			return impersonatorRule, false, impersonatorReason + " as impersonator " + impersonator.User
+		}
+	}
+	return rule, authorized, denyReason
+}
+
+// Returns true if rule denies requests by privileged users too, being considered before they're allowed
+func AppliesToPrivilegedUsers(rule string) bool {
+	for _, identityRule := range identityRules {
+		if identityRule.name == RuleAdditionalPrivilegedUser {
+			return false
+		}
+		if identityRule.name == rule {
+			return true
+		}
+	}
+	return false
+}
+
+// Facts about a request which the rules for its user test
+type requestFacts struct {
+	privilegedUser       bool
+	privilegedSystemUser bool
+	protectedNamespace   bool
+	secret               bool
+	readonlyVerb         bool
+	// Set for writes to objects the webhook depends on
+	webhookObject bool
+	// Set for list and watch requests for every secret in a namespace or all namespaces
+	bulkSecretRead bool
+	// Set for deleting namespaces whose deletion is protected, by users other than the namespace deleters
+	protectedNamespaceDeletion bool
+	// Set for creating namespaces with reserved names
+	reservedNamespaceCreation bool
+	// First name rule matching the request, if any
+	nameRule *compiledNameRule
+	// Requests without a namespace are across all namespaces, including protected ones, unless the
+	// resource isn't namespaced
+	allNamespaces bool
+	allResources  bool
+	// Verb '*' includes writes, so isn't read-only
+	wildcard bool
+}
+
+func (p *Policy) requestFacts(spec SubjectAccessReviewSpec) requestFacts {
+	attributes := spec.ResourceAttributes
+	classification := p.classifyUser(spec.User)
+	facts := requestFacts{privilegedUser: classification.additionalPrivileged}
+	if attributes != nil {
+		facts.privilegedSystemUser = classification.privilegedSystem && !p.RestrictsNodeRequest(spec)
+		facts.protectedNamespace = p.IsProtectedNamespace(attributes.Namespace)
+		facts.secret = attributes.Resource == "secrets"
+		facts.readonlyVerb = p.IsReadonlyVerb(attributes.Verb)
+		facts.webhookObject = !facts.readonlyVerb && p.webhookObjectEntry(spec) != ""
+		facts.bulkSecretRead = IsBulkSecretRead(spec)
+		facts.reservedNamespaceCreation = p.IsReservedNamespaceCreation(spec)
+		facts.protectedNamespaceDeletion = p.IsProtectedNamespaceDeletion(spec) && !p.IsNamespaceDeleter(spec.User)
+		facts.nameRule = p.matchNameRule(spec)
+		facts.allNamespaces = attributes.Namespace == "" && !p.IsClusterScoped(attributes.Group, attributes.Resource)
+		facts.allResources = attributes.Resource == "*"
+		facts.wildcard = facts.allResources || attributes.Verb == "*"
+	}
+	return facts
+}
+
+// Rule for a user's own request, which denies it if denyReason is set and otherwise allows it
+type identityRule struct {
+	name        string
+	description string
+	applies     func(p *Policy, facts requestFacts) bool
+	denyReason  string
+	// Replaces denyReason with one specific to the request, if set
+	describeDenial func(facts requestFacts) string
+}
+
+// Rules for a user's own request in the order they are considered, the first applying deciding it.
+// Requests none apply to are allowed by RuleDefaultAllow
+var identityRules = []identityRule{
+	{
+		name:        RuleNamespaceDeletion,
+		description: "Denies deleting protected namespaces, and namespaces whose deletion is protected, if namespace deletion protection is on, unless the user is a namespace deleter. Applies to privileged users too",
+		applies:     func(_ *Policy, facts requestFacts) bool { return facts.protectedNamespaceDeletion },
+		denyReason:  "Cannot delete protected namespace",
+	},
+	{
+		name:        RuleNameDeny,
+		description: "Denies requests matching a name rule with effect deny, if it's the first name rule the request matches. Applies to privileged users too, who are only spared by the rule's exceptions",
+		applies: func(_ *Policy, facts requestFacts) bool {
+			return facts.nameRule != nil && facts.nameRule.Effect == NameRuleDeny
+		},
+		denyReason:     "Denied by name rule",
+		describeDenial: func(facts requestFacts) string { return "Denied by name rule " + facts.nameRule.Name },
+	},
+	{
+		name:        RuleAdditionalPrivilegedUser,
+		description: "Allows every request by an additional privileged user",
+		applies:     func(_ *Policy, facts requestFacts) bool { return facts.privilegedUser },
+	},
+	{
+		name:        RuleWebhookObject,
+		description: "Denies writes to objects the webhook depends on, such as kubeadm-config, in any namespace including exempt ones, unless the user is a privileged system user",
+		applies: func(_ *Policy, facts requestFacts) bool {
+			return facts.webhookObject && !facts.privilegedSystemUser
+		},
+		denyReason: "Cannot modify objects the authorization webhook depends on",
+	},
+	{
+		name:        RuleNameAllow,
+		description: "Allows requests matching a name rule with effect allow, if it's the first name rule the request matches",
+		applies: func(_ *Policy, facts requestFacts) bool {
+			return facts.nameRule != nil && facts.nameRule.Effect == NameRuleAllow
+		},
+	},
+	{
+		name:        RuleReservedNamespace,
+		description: "Denies creating namespaces with reserved names, such as those of protected namespaces or extending them, if namespace creation protection is on, unless the user is a privileged system user",
+		applies: func(_ *Policy, facts requestFacts) bool {
+			return facts.reservedNamespaceCreation && !facts.privilegedSystemUser
+		},
+		denyReason: "Cannot create namespace with a reserved name",
+	},
+	{
+		name:        RuleProtectedAllResources,
+		description: "Denies * resource requests in protected namespaces, or across all namespaces, unless the user is a privileged system user",
+		applies: func(_ *Policy, facts requestFacts) bool {
+			return (facts.allNamespaces || facts.protectedNamespace) && !facts.privilegedSystemUser && facts.allResources
+		},
+		denyReason: "Cannot make * resource requests in protected namespace",
+	},
+	{
+		name:        RuleProtectedSecrets,
+		description: "Denies access to secrets in protected namespaces, or across all namespaces, unless the user is a privileged system user",
+		applies: func(_ *Policy, facts requestFacts) bool {
+			return (facts.allNamespaces || facts.protectedNamespace) && !facts.privilegedSystemUser && facts.secret
+		},
+		denyReason: "Cannot access secrets in protected namespace",
+	},
+	{
+		name:        RuleProtectedWrite,
+		description: "Denies requests with verbs which aren't read-only in protected namespaces, unless the user is a privileged system user",
+		applies: func(_ *Policy, facts requestFacts) bool {
+			return facts.protectedNamespace && !facts.privilegedSystemUser && !facts.readonlyVerb
+		},
+		denyReason: "Cannot write to protected namespace",
+	},
+	{
+		name:        RuleWildcardRequest,
+		description: "Denies * verb and * resource requests anywhere if wildcard requests are denied, unless the user is a privileged system user",
+		applies: func(p *Policy, facts requestFacts) bool {
+			return p.wildcardRequests == WildcardDeny && !facts.privilegedSystemUser && facts.wildcard
+		},
+		denyReason: "Cannot make * verb or * resource requests",
+	},
+	{
+		name:        RuleBulkSecretRead,
+		description: "Denies list and watch requests for secrets without a name or selector anywhere if bulk secret reads are denied, unless the user is a privileged system user",
+		applies: func(p *Policy, facts requestFacts) bool {
+			return p.bulkSecretReads == BulkSecretReadsDeny && !facts.privilegedSystemUser && facts.bulkSecretRead
+		},
+		denyReason: "Cannot list or watch every secret without a name or selector",
+	},
+}
+
+// Returns the first rule applying to the request for its user alone, as MatchRule does
+func matchIdentityRule(sar SubjectAccessReview, policy *Policy) (string, bool, string) {
+	facts := policy.requestFacts(sar.Spec)
+	for _, rule := range identityRules {
+		if rule.applies(policy, facts) {
+			if rule.describeDenial != nil {
+				return rule.name, false, rule.describeDenial(facts)
+			}
+			return rule.name, rule.denyReason == "", rule.denyReason
+		}
+	}
+	return RuleDefaultAllow, true, ""
+}
+
+// Evaluates SubjectAccessReview against policy and returns the status to respond with
+func Decide(sar SubjectAccessReview, policy *Policy, opinionMode bool) authorizationv1.SubjectAccessReviewStatus {
+	authorized, denyReason := IsRequestAuthorized(sar, policy)
+	return DecisionStatus(authorized, denyReason, opinionMode)
+}
+
+func DecisionStatus(authorized bool, denyReason string, opinionMode bool) authorizationv1.SubjectAccessReviewStatus {
+	var status authorizationv1.SubjectAccessReviewStatus
+	status.Denied = !authorized
+	status.Allowed = opinionMode && authorized
+
+	if status.Denied {
+		status.Reason = denyReason
+	} else if !opinionMode {
+		status.Reason = "Webhook doesn't give opinion, delegated to other authorizers"
+	}
+	return status
+}
+
+// Returns metric label value for the outcome of a decision
+func DecisionLabel(status authorizationv1.SubjectAccessReviewStatus) string {
+	switch {
+	case status.Denied:
+		return "denied"
+	case status.Allowed:
+		return "allowed"
+	}
+	return "no-opinion"
+}
@@ -0,0 +1,115 @@
+package policy
+
+import (
+	"context"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"testing"
+)
+
+var defaultProtectedNamespaces = []string{"kube-system", "openstack-system"}
+
+func TestPrivilegedSystemUserClassification(t *testing.T) {
+	policy := Compile(Config{ProtectedNamespaces: defaultProtectedNamespaces})
+	cases := map[string]bool{
+		"system:kube-scheduler":                           true,
+		"kubernetes-admin":                                true,
+		"system:serviceaccount:kube-system:controller":    true,
+		"system:serviceaccount:openstack-system:operator": true,
+		"system:serviceaccount:default:builder":           false,
+		"system:serviceaccount:kube-system":               false,
+		"system:node:worker-1":                            true,
+		"system:node:":                                    false,
+		"system:bootstrap:abcdef":                         true,
+		"system:anonymous":                                false,
+		// Previously matched by unanchored regexes
+		"mallory-system:node:worker-1":                   false,
+		"evil:system:serviceaccount:kube-system:default": false,
+	}
+	for user, expected := range cases {
+		if actual := policy.IsPrivilegedSystemUser(user); actual != expected {
+			t.Errorf("IsPrivilegedSystemUser(%q) = %t, expected %t", user, actual, expected)
+		}
+	}
+}
+
+func TestCompileIgnoresEmptyEntries(t *testing.T) {
+	policy := Compile(Config{ProtectedNamespaces: []string{""}, AdditionalPrivilegedUsers: []string{""}})
+	if policy.IsProtectedNamespace("") || policy.IsAdditionalPrivilegedUser("") {
+		t.Error("Expected empty entries from empty flags to be ignored")
+	}
+}
+
+func TestClassificationCacheMemoisesUsers(t *testing.T) {
+	policy := Compile(Config{ProtectedNamespaces: defaultProtectedNamespaces, ClassificationCacheSize: 2})
+	for _, user := range []string{"system:node:a", "system:node:a", "not-admin", "system:node:b"} {
+		policy.classifyUser(user)
+	}
+	if policy.classifications.Len() != 2 {
+		t.Errorf("Expected cache to be bounded to 2 users, got %d", policy.classifications.Len())
+	}
+	if _, ok := policy.classifications.Get("system:node:a"); ok {
+		t.Error("Expected least recently used user to be evicted")
+	}
+	if classification, ok := policy.classifications.Get("system:node:b"); !ok || !classification.privilegedSystem {
+		t.Error("Expected most recent classification to be cached")
+	}
+}
+
+func TestSourceSnapshots(t *testing.T) {
+	source := NewSource(Config{ProtectedNamespaces: []string{"kube-system"}})
+	pinned := source.Current()
+	if pinned.Generation() != 1 {
+		t.Errorf("Expected first policy to be generation 1, got %d", pinned.Generation())
+	}
+	source.Set(Config{})
+	if generation := source.Current().Generation(); generation != 2 {
+		t.Errorf("Expected replacement policy to be generation 2, got %d", generation)
+	}
+
+	ctx := WithSnapshot(context.Background(), pinned)
+	if source.Snapshot(ctx) != pinned || source.Snapshot(context.Background()) != source.Current() {
+		t.Error("Expected snapshot carried by context to be used in preference to the current policy")
+	}
+	spec := &SubjectAccessReviewSpec{User: "alice"}
+	spec.ResourceAttributes = &authorizationv1.ResourceAttributes{Namespace: "kube-system", Verb: "create", Resource: "pods"}
+	if decision := (RulesAuthorizer{Source: source}).Authorize(ctx, spec); decision.Verdict != Deny {
+		t.Errorf("Expected request to be evaluated with pinned policy, got %+v", decision)
+	}
+}
+
+func TestWildcardRequests(t *testing.T) {
+	tests := []struct {
+		name       string
+		user       string
+		attributes authorizationv1.ResourceAttributes
+		// Whether authorized with each policy
+		protectedNamespaces bool
+		deny                bool
+	}{
+		{"all verbs on pods", "alice", authorizationv1.ResourceAttributes{Namespace: "default", Verb: "*", Resource: "pods"}, true, false},
+		{"get all resources", "alice", authorizationv1.ResourceAttributes{Namespace: "default", Verb: "get", Resource: "*"}, true, false},
+		{"all verbs on nodes", "alice", authorizationv1.ResourceAttributes{Verb: "*", Resource: "nodes"}, true, false},
+		{"all verbs in protected namespace", "alice", authorizationv1.ResourceAttributes{Namespace: "kube-system", Verb: "*", Resource: "pods"}, false, false},
+		{"get pods", "alice", authorizationv1.ResourceAttributes{Namespace: "default", Verb: "get", Resource: "pods"}, true, true},
+		{"privileged user", "admin", authorizationv1.ResourceAttributes{Namespace: "default", Verb: "*", Resource: "*"}, true, true},
+		{"privileged system user", "system:kube-scheduler", authorizationv1.ResourceAttributes{Verb: "*", Resource: "*"}, true, true},
+	}
+	for _, wildcardRequests := range []WildcardPolicy{"", WildcardProtectedNamespaces, WildcardDeny} {
+		policy := Compile(Config{ProtectedNamespaces: []string{"kube-system"}, AdditionalPrivilegedUsers: []string{"admin"}, WildcardRequests: wildcardRequests})
+		for _, test := range tests {
+			attributes := test.attributes
+			sar := SubjectAccessReview{Spec: SubjectAccessReviewSpec{User: test.user, ResourceAttributes: &attributes}}
+			expected := test.protectedNamespaces
+			if wildcardRequests == WildcardDeny {
+				expected = test.deny
+			}
+			if authorized, _ := IsRequestAuthorized(sar, policy); authorized != expected {
+				t.Errorf("%s with %q: expected authorized %v, got %v", test.name, wildcardRequests, expected, authorized)
+			}
+		}
+	}
+
+	if err := (Config{WildcardRequests: "allow"}).Validate(); err == nil {
+		t.Error("Expected unknown wildcard request policy to be rejected")
+	}
+}
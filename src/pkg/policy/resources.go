@@ -0,0 +1,128 @@
+package policy
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Plural names of built-in resources by their singular and short names, as kubectl's RESTMapper resolves
+// them. kube-apiserver only sends plurals, but aggregated API servers relaying requests may not
+var resourcePlurals = map[string]string{
+	"binding":                  "bindings",
+	"cj":                       "cronjobs",
+	"clusterrole":              "clusterroles",
+	"clusterrolebinding":       "clusterrolebindings",
+	"cm":                       "configmaps",
+	"configmap":                "configmaps",
+	"crd":                      "customresourcedefinitions",
+	"cronjob":                  "cronjobs",
+	"customresourcedefinition": "customresourcedefinitions",
+	"daemonset":                "daemonsets",
+	"deploy":                   "deployments",
+	"deployment":               "deployments",
+	"ds":                       "daemonsets",
+	"endpoint":                 "endpoints",
+	"ep":                       "endpoints",
+	"ev":                       "events",
+	"event":                    "events",
+	"hpa":                      "horizontalpodautoscalers",
+	"ing":                      "ingresses",
+	"ingress":                  "ingresses",
+	"job":                      "jobs",
+	"limitrange":               "limitranges",
+	"limits":                   "limitranges",
+	"namespace":                "namespaces",
+	"netpol":                   "networkpolicies",
+	"networkpolicy":            "networkpolicies",
+	"no":                       "nodes",
+	"node":                     "nodes",
+	"ns":                       "namespaces",
+	"pdb":                      "poddisruptionbudgets",
+	"persistentvolume":         "persistentvolumes",
+	"persistentvolumeclaim":    "persistentvolumeclaims",
+	"po":                       "pods",
+	"pod":                      "pods",
+	"pv":                       "persistentvolumes",
+	"pvc":                      "persistentvolumeclaims",
+	"quota":                    "resourcequotas",
+	"rc":                       "replicationcontrollers",
+	"replicaset":               "replicasets",
+	"replicationcontroller":    "replicationcontrollers",
+	"resourcequota":            "resourcequotas",
+	"role":                     "roles",
+	"rolebinding":              "rolebindings",
+	"rs":                       "replicasets",
+	"sa":                       "serviceaccounts",
+	"sc":                       "storageclasses",
+	"secret":                   "secrets",
+	"service":                  "services",
+	"serviceaccount":           "serviceaccounts",
+	"statefulset":              "statefulsets",
+	"storageclass":             "storageclasses",
+	"sts":                      "statefulsets",
+	"svc":                      "services",
+}
+
+// Returns value lower case and without surrounding whitespace, as names in attributes always are when
+// sent by kube-apiserver
+func canonicalName(value string) string {
+	return strings.ToLower(strings.TrimSpace(value))
+}
+
+// Rewrites the attributes of spec into the form kube-apiserver sends, so rules matching names exactly
+// can't be bypassed by variations of them
+func normalizeAttributes(spec *SubjectAccessReviewSpec) {
+	if attributes := spec.ResourceAttributes; attributes != nil {
+		attributes.Namespace = canonicalName(attributes.Namespace)
+		attributes.Verb = canonicalName(attributes.Verb)
+		attributes.Group = canonicalName(attributes.Group)
+		attributes.Version = canonicalName(attributes.Version)
+		attributes.Resource = canonicalName(attributes.Resource)
+		attributes.Subresource = canonicalName(attributes.Subresource)
+		if plural, ok := resourcePlurals[attributes.Resource]; ok {
+			attributes.Resource = plural
+		}
+	}
+	if attributes := spec.NonResourceAttributes; attributes != nil {
+		attributes.Verb = canonicalName(attributes.Verb)
+		attributes.Path = strings.TrimSpace(attributes.Path)
+	}
+}
+
+// Built-in resources which aren't namespaced, by group and resource
+var builtinClusterScopedResources = toSet([]string{
+	"/namespaces", "/nodes", "/persistentvolumes", "/componentstatuses",
+	"admissionregistration.k8s.io/mutatingwebhookconfigurations", "admissionregistration.k8s.io/validatingwebhookconfigurations",
+	"admissionregistration.k8s.io/validatingadmissionpolicies", "admissionregistration.k8s.io/validatingadmissionpolicybindings",
+	"apiextensions.k8s.io/customresourcedefinitions", "apiregistration.k8s.io/apiservices",
+	"certificates.k8s.io/certificatesigningrequests", "flowcontrol.apiserver.k8s.io/flowschemas",
+	"flowcontrol.apiserver.k8s.io/prioritylevelconfigurations", "networking.k8s.io/ingressclasses",
+	"node.k8s.io/runtimeclasses", "rbac.authorization.k8s.io/clusterroles", "rbac.authorization.k8s.io/clusterrolebindings",
+	"scheduling.k8s.io/priorityclasses", "storage.k8s.io/csidrivers", "storage.k8s.io/csinodes",
+	"storage.k8s.io/storageclasses", "storage.k8s.io/volumeattachments",
+})
+
+// Returns the group/resource key of a cluster-scoped resource given as RESOURCE[.GROUP], as kubectl
+// names them, e.g. 'clusterissuers.cert-manager.io'
+func clusterScopedResourceKey(name string) string {
+	resource, group, _ := strings.Cut(name, ".")
+	return group + "/" + resource
+}
+
+// Returns error if names aren't all resources given as RESOURCE[.GROUP], ignoring empty entries
+func ValidateClusterScopedResources(names []string) error {
+	for _, name := range names {
+		resource, _, _ := strings.Cut(name, ".")
+		if name != "" && (name != canonicalName(name) || resource == "" || strings.ContainsAny(name, "/*")) {
+			return fmt.Errorf("invalid cluster-scoped resource %q, expected RESOURCE[.GROUP] in lower case", name)
+		}
+	}
+	return nil
+}
+
+// Returns true if requests for the resource in group aren't for any namespace, as it's a built-in or
+// configured cluster-scoped resource. Others without a namespace are requests across all namespaces
+func (p *Policy) IsClusterScoped(group string, resource string) bool {
+	key := group + "/" + resource
+	return builtinClusterScopedResources.Has(key) || p.clusterScopedResources.Has(key)
+}
@@ -0,0 +1,61 @@
+package policy
+
+import (
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"testing"
+)
+
+func TestNormalizeAttributes(t *testing.T) {
+	sar := SubjectAccessReview{Spec: SubjectAccessReviewSpec{User: "alice", ResourceAttributes: &authorizationv1.ResourceAttributes{
+		Namespace: " Kube-System", Verb: "DELETE ", Resource: "Secret", Subresource: "Status",
+	}}}
+	sar.APIVersion, sar.Kind = "authorization.k8s.io/v1", "SubjectAccessReview"
+	if err := Normalize(&sar, nil); err != nil {
+		t.Fatal(err)
+	}
+	want := authorizationv1.ResourceAttributes{Namespace: "kube-system", Verb: "delete", Resource: "secrets", Subresource: "status"}
+	if *sar.Spec.ResourceAttributes != want {
+		t.Errorf("Expected %+v, got %+v", want, *sar.Spec.ResourceAttributes)
+	}
+	if authorized, _ := IsRequestAuthorized(sar, Compile(Config{ProtectedNamespaces: []string{"kube-system"}})); authorized {
+		t.Error("Expected normalised request to be denied")
+	}
+
+	sar.Spec = SubjectAccessReviewSpec{User: "alice", ResourceAttributes: &authorizationv1.ResourceAttributes{Verb: "get", Resource: "svc"}}
+	Normalize(&sar, nil)
+	if sar.Spec.ResourceAttributes.Resource != "services" {
+		t.Errorf("Expected short name to be expanded, got %s", sar.Spec.ResourceAttributes.Resource)
+	}
+	sar.Spec = SubjectAccessReviewSpec{User: "alice", NonResourceAttributes: &authorizationv1.NonResourceAttributes{Verb: " GET", Path: "/Healthz "}}
+	Normalize(&sar, nil)
+	if *sar.Spec.NonResourceAttributes != (authorizationv1.NonResourceAttributes{Verb: "get", Path: "/Healthz"}) {
+		t.Errorf("Expected verb to be lower case and path trimmed, got %+v", *sar.Spec.NonResourceAttributes)
+	}
+}
+
+func TestClusterScopedRequests(t *testing.T) {
+	policy := Compile(Config{ProtectedNamespaces: []string{"kube-system"}, ClusterScopedResources: []string{"secrets.example.io"}})
+	tests := []struct {
+		name       string
+		attributes authorizationv1.ResourceAttributes
+		authorized bool
+	}{
+		{"list nodes", authorizationv1.ResourceAttributes{Verb: "list", Resource: "nodes"}, true},
+		{"create namespace", authorizationv1.ResourceAttributes{Verb: "create", Resource: "namespaces"}, true},
+		{"list secrets in all namespaces", authorizationv1.ResourceAttributes{Verb: "list", Resource: "secrets"}, false},
+		{"list all resources in all namespaces", authorizationv1.ResourceAttributes{Verb: "list", Resource: "*"}, false},
+		{"list configured cluster-scoped secrets", authorizationv1.ResourceAttributes{Verb: "list", Group: "example.io", Resource: "secrets"}, true},
+		{"list namespaced custom secrets in all namespaces", authorizationv1.ResourceAttributes{Verb: "list", Group: "other.io", Resource: "secrets"}, false},
+	}
+	for _, test := range tests {
+		attributes := test.attributes
+		sar := SubjectAccessReview{Spec: SubjectAccessReviewSpec{User: "alice", ResourceAttributes: &attributes}}
+		if authorized, _ := IsRequestAuthorized(sar, policy); authorized != test.authorized {
+			t.Errorf("%s: expected authorized %v, got %v", test.name, test.authorized, authorized)
+		}
+	}
+
+	if err := (Config{ClusterScopedResources: []string{"ClusterIssuers.cert-manager.io"}}).Validate(); err == nil {
+		t.Error("Expected resource not in lower case to be rejected")
+	}
+}
@@ -0,0 +1,225 @@
+package policy
+
+import (
+	"encoding/json"
+	"errors"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// authorizationv1.SubjectAccessReview with a spec accepting either group key
+// Should not be written as HTTP response
+type SubjectAccessReview struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   SubjectAccessReviewSpec                   `json:"spec"`
+	Status authorizationv1.SubjectAccessReviewStatus `json:"status,omitempty"`
+}
+
+// authorizationv1.SubjectAccessReviewSpec, decoding groups from both the v1 'groups' key and the
+// v1beta1 'group' key, which some clients also send in v1 reviews
+type SubjectAccessReviewSpec authorizationv1.SubjectAccessReviewSpec
+
+// JSON encoding of a SubjectAccessReviewSpec
+type subjectAccessReviewSpecJSON struct {
+	authorizationv1.SubjectAccessReviewSpec
+	Group []string `json:"group,omitempty"`
+}
+
+// Returns the struct the spec's JSON is decoded into, so strict decoders can find unknown fields
+func (s *SubjectAccessReviewSpec) JSONFields() any {
+	return subjectAccessReviewSpecJSON{}
+}
+
+func (s *SubjectAccessReviewSpec) UnmarshalJSON(data []byte) error {
+	var spec subjectAccessReviewSpecJSON
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return err
+	}
+	*s = SubjectAccessReviewSpec(spec.SubjectAccessReviewSpec)
+	for _, group := range spec.Group {
+		if !slices.Contains(s.Groups, group) {
+			s.Groups = append(s.Groups, group)
+		}
+	}
+	return nil
+}
+
+// Returns error describing why a normalised SubjectAccessReview can't be evaluated, if it can't
+func Validate(sar SubjectAccessReview) error {
+	// Most other issues will have been caught as JSON decoding errors
+	if sar.Kind != "SubjectAccessReview" || !HasSubject(sar.Spec) {
+		return errors.New("Malformed SubjectAccessReview")
+	}
+	if sar.APIVersion != "authorization.k8s.io/v1" {
+		return &UnsupportedAPIVersionError{APIVersion: sar.APIVersion, Accepted: DefaultAPIVersions}
+	}
+	if inconsistency := Inconsistency(sar.Spec); inconsistency != "" && inconsistency != InconsistencyNamespacedClusterResource {
+		return &InconsistencyError{Inconsistency: inconsistency}
+	}
+	return nil
+}
+
+// Returns true if spec identifies who is making the request. The API allows any of the user, groups and
+// UID to be empty, and some authentication proxies only send groups
+func HasSubject(spec SubjectAccessReviewSpec) bool {
+	return spec.User != "" || len(spec.Groups) > 0 || spec.UID != ""
+}
+
+// Returns description of who is making the request for logs, the user if there is one
+func DescribeSubject(spec SubjectAccessReviewSpec) string {
+	switch {
+	case spec.User != "":
+		return spec.User
+	case len(spec.Groups) > 0:
+		return "groups " + strings.Join(spec.Groups, ",")
+	}
+	return "UID " + spec.UID
+}
+
+// apiVersions of SubjectAccessReviews accepted unless configured otherwise
+var DefaultAPIVersions = []string{"authorization.k8s.io/v1", "authorization.k8s.io/v1beta1"}
+
+// Error for a SubjectAccessReview with an apiVersion that isn't accepted
+type UnsupportedAPIVersionError struct {
+	APIVersion string
+	Accepted   []string
+}
+
+func (e *UnsupportedAPIVersionError) Error() string {
+	return e.APIVersion + " not supported. Currently support apiVersions: '" + strings.Join(e.Accepted, "', '") + "'"
+}
+
+// Returns error if versions can't be accepted, as they aren't versions of the authorization.k8s.io group
+func ValidateAPIVersions(versions []string) error {
+	for _, version := range versions {
+		if !strings.HasPrefix(version, "authorization.k8s.io/") || version == "authorization.k8s.io/" {
+			return errors.New("invalid apiVersion " + version + ", must be authorization.k8s.io/VERSION")
+		}
+	}
+	return nil
+}
+
+// Returns error if the apiVersion of sar isn't one of accepted, or DefaultAPIVersions if empty. Accepted
+// versions other than v1beta1, which Normalize converts, are decoded as v1, so are rewritten to v1. Must be
+// called before Normalize
+func AcceptAPIVersion(sar *SubjectAccessReview, accepted []string) error {
+	if len(accepted) == 0 {
+		accepted = DefaultAPIVersions
+	}
+	if !slices.Contains(accepted, sar.APIVersion) {
+		return &UnsupportedAPIVersionError{APIVersion: sar.APIVersion, Accepted: accepted}
+	}
+	if sar.APIVersion != "authorization.k8s.io/v1beta1" {
+		sar.APIVersion = "authorization.k8s.io/v1"
+	}
+	return nil
+}
+
+// Inconsistent combinations of attributes, which kube-apiserver doesn't send for valid requests
+const (
+	InconsistencyNoAttributes              = "no-attributes"
+	InconsistencyBothAttributes            = "both-attributes"
+	InconsistencyEmptyVerb                 = "empty-verb"
+	InconsistencyNamespacedClusterResource = "namespaced-cluster-resource"
+)
+
+var inconsistencyMessages = map[string]string{
+	InconsistencyNoAttributes:              "SubjectAccessReview has neither resourceAttributes nor nonResourceAttributes",
+	InconsistencyBothAttributes:            "SubjectAccessReview has both resourceAttributes and nonResourceAttributes",
+	InconsistencyEmptyVerb:                 "SubjectAccessReview has no verb",
+	InconsistencyNamespacedClusterResource: "SubjectAccessReview has a namespace for a cluster-scoped resource",
+}
+
+// Error for a SubjectAccessReview with inconsistent attributes
+type InconsistencyError struct {
+	Inconsistency string
+}
+
+func (e *InconsistencyError) Error() string {
+	return inconsistencyMessages[e.Inconsistency]
+}
+
+// Returns the first inconsistency in the attributes of spec, or empty string if there's none.
+// Cluster-scoped resources other than namespaces requested in a namespace are still evaluated, as if namespaced, so the
+// namespace's protections apply. Requests with other inconsistencies are invalid
+func Inconsistency(spec SubjectAccessReviewSpec) string {
+	resource, nonResource := spec.ResourceAttributes, spec.NonResourceAttributes
+	switch {
+	case resource == nil && nonResource == nil:
+		return InconsistencyNoAttributes
+	case resource != nil && nonResource != nil:
+		return InconsistencyBothAttributes
+	case resource != nil && resource.Verb == "", nonResource != nil && nonResource.Verb == "":
+		return InconsistencyEmptyVerb
+	case resource != nil && resource.Namespace != "" && resource.Resource != "namespaces" && builtinClusterScopedResources.Has(resource.Group+"/"+resource.Resource):
+		return InconsistencyNamespacedClusterResource
+	}
+	return ""
+}
+
+// Request headers carrying user info for SelfSubjectAccessReviews relayed by aggregated API servers, the
+// kube-apiserver requestheader defaults
+const (
+	remoteUserHeader        = "X-Remote-User"
+	remoteGroupHeader       = "X-Remote-Group"
+	remoteExtraHeaderPrefix = "X-Remote-Extra-"
+)
+
+// Rewrites v1beta1, LocalSubjectAccessReview and SelfSubjectAccessReview payloads into the equivalent
+// v1 SubjectAccessReview, with attributes in the form kube-apiserver sends them. Returns error describing
+// why sar can't be rewritten, if it can't
+func Normalize(sar *SubjectAccessReview, header http.Header) error {
+	sar.Namespace = canonicalName(sar.Namespace)
+	normalizeAttributes(&sar.Spec)
+	if sar.APIVersion == "authorization.k8s.io/v1beta1" {
+		// Identical to v1 apart from the name of the groups key, which decoding merges
+		sar.APIVersion = "authorization.k8s.io/v1"
+	}
+	switch sar.Kind {
+	case "LocalSubjectAccessReview":
+		// The namespace is carried at the object level and must agree with any in the attributes
+		attributes := sar.Spec.ResourceAttributes
+		if attributes == nil {
+			return errors.New("LocalSubjectAccessReview must have resourceAttributes")
+		}
+		if attributes.Namespace == "" {
+			attributes.Namespace = sar.Namespace
+		} else if sar.Namespace != "" && attributes.Namespace != sar.Namespace {
+			return errors.New("LocalSubjectAccessReview namespace " + sar.Namespace + " doesn't match resourceAttributes namespace " + attributes.Namespace)
+		}
+	case "SelfSubjectAccessReview":
+		// The user is implied by the relaying server's authentication, so comes from request headers if
+		// not in the spec. Callers can already name any user in a SubjectAccessReview, so this grants nothing
+		if sar.Spec.User == "" && header != nil {
+			sar.Spec.User = header.Get(remoteUserHeader)
+			sar.Spec.Groups = append(sar.Spec.Groups, header.Values(remoteGroupHeader)...)
+			for name, values := range header {
+				key, isExtra := strings.CutPrefix(name, remoteExtraHeaderPrefix)
+				if !isExtra {
+					continue
+				}
+				if unescaped, err := url.PathUnescape(key); err == nil {
+					key = unescaped
+				}
+				if sar.Spec.Extra == nil {
+					sar.Spec.Extra = map[string]authorizationv1.ExtraValue{}
+				}
+				key = strings.ToLower(key)
+				sar.Spec.Extra[key] = append(sar.Spec.Extra[key], values...)
+			}
+		}
+		if !HasSubject(sar.Spec) {
+			return errors.New("SelfSubjectAccessReview has no user, expected " + remoteUserHeader + " header")
+		}
+	default:
+		return nil
+	}
+	sar.Kind = "SubjectAccessReview"
+	return nil
+}
@@ -0,0 +1,111 @@
+package policy
+
+import (
+	"encoding/json"
+	"errors"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"slices"
+	"testing"
+)
+
+func TestSubjectAccessReviewSpecGroups(t *testing.T) {
+	tests := map[string][]string{
+		`{"user":"alice","groups":["staff"]}`:                            {"staff"},
+		`{"user":"alice","group":["staff"]}`:                             {"staff"},
+		`{"user":"alice","groups":["staff","admins"],"group":["staff"]}`: {"staff", "admins"},
+		`{"user":"alice","Groups":["staff"],"group":["admins"]}`:         {"staff", "admins"},
+	}
+	for body, groups := range tests {
+		var spec SubjectAccessReviewSpec
+		if err := json.Unmarshal([]byte(body), &spec); err != nil || spec.User != "alice" || !slices.Equal(spec.Groups, groups) {
+			t.Errorf("%s: expected groups %v, got %+v, %v", body, groups, spec, err)
+		}
+	}
+}
+
+func TestInconsistency(t *testing.T) {
+	resource := &authorizationv1.ResourceAttributes{Verb: "get", Resource: "pods", Namespace: "default"}
+	nonResource := &authorizationv1.NonResourceAttributes{Verb: "get", Path: "/healthz"}
+	tests := []struct {
+		name          string
+		spec          SubjectAccessReviewSpec
+		inconsistency string
+		invalid       bool
+	}{
+		{"resource", SubjectAccessReviewSpec{ResourceAttributes: resource}, "", false},
+		{"non-resource", SubjectAccessReviewSpec{NonResourceAttributes: nonResource}, "", false},
+		{"no attributes", SubjectAccessReviewSpec{}, InconsistencyNoAttributes, true},
+		{"both attributes", SubjectAccessReviewSpec{ResourceAttributes: resource, NonResourceAttributes: nonResource}, InconsistencyBothAttributes, true},
+		{"empty verb", SubjectAccessReviewSpec{NonResourceAttributes: &authorizationv1.NonResourceAttributes{Path: "/"}}, InconsistencyEmptyVerb, true},
+		{"namespaced node", SubjectAccessReviewSpec{ResourceAttributes: &authorizationv1.ResourceAttributes{Verb: "delete", Resource: "nodes", Namespace: "kube-system"}},
+			InconsistencyNamespacedClusterResource, false},
+		{"namespace object", SubjectAccessReviewSpec{ResourceAttributes: &authorizationv1.ResourceAttributes{Verb: "get", Resource: "namespaces", Namespace: "kube-system"}}, "", false},
+	}
+	for _, test := range tests {
+		if inconsistency := Inconsistency(test.spec); inconsistency != test.inconsistency {
+			t.Errorf("%s: expected inconsistency %q, got %q", test.name, test.inconsistency, inconsistency)
+		}
+		sar := SubjectAccessReview{Spec: test.spec}
+		sar.APIVersion, sar.Kind, sar.Spec.User = "authorization.k8s.io/v1", "SubjectAccessReview", "alice"
+		var inconsistencyErr *InconsistencyError
+		if err := Validate(sar); errors.As(err, &inconsistencyErr) != test.invalid {
+			t.Errorf("%s: expected invalid %v, got %v", test.name, test.invalid, err)
+		}
+	}
+}
+
+func TestValidateSubject(t *testing.T) {
+	attributes := &authorizationv1.ResourceAttributes{Verb: "get", Resource: "pods"}
+	tests := []struct {
+		spec    SubjectAccessReviewSpec
+		valid   bool
+		subject string
+	}{
+		{SubjectAccessReviewSpec{User: "alice", Groups: []string{"staff"}}, true, "alice"},
+		{SubjectAccessReviewSpec{Groups: []string{"staff", "admins"}}, true, "groups staff,admins"},
+		{SubjectAccessReviewSpec{UID: "6f1c"}, true, "UID 6f1c"},
+		{SubjectAccessReviewSpec{}, false, ""},
+	}
+	for _, test := range tests {
+		test.spec.ResourceAttributes = attributes
+		sar := SubjectAccessReview{Spec: test.spec}
+		sar.APIVersion, sar.Kind = "authorization.k8s.io/v1", "SubjectAccessReview"
+		if err := Validate(sar); (err == nil) != test.valid {
+			t.Errorf("%+v: expected valid=%v, got %v", test.spec, test.valid, err)
+		}
+		if test.valid && DescribeSubject(test.spec) != test.subject {
+			t.Errorf("%+v: expected subject %q, got %q", test.spec, test.subject, DescribeSubject(test.spec))
+		}
+	}
+}
+
+func TestAcceptAPIVersion(t *testing.T) {
+	tests := []struct {
+		apiVersion string
+		accepted   []string
+		converted  string
+	}{
+		{"authorization.k8s.io/v1", nil, "authorization.k8s.io/v1"},
+		{"authorization.k8s.io/v1beta1", nil, "authorization.k8s.io/v1beta1"},
+		{"authorization.k8s.io/v2", nil, ""},
+		{"authorization.k8s.io/v1beta1", []string{"authorization.k8s.io/v1"}, ""},
+		{"authorization.k8s.io/v2", []string{"authorization.k8s.io/v1", "authorization.k8s.io/v2"}, "authorization.k8s.io/v1"},
+	}
+	for _, test := range tests {
+		sar := SubjectAccessReview{}
+		sar.APIVersion = test.apiVersion
+		err := AcceptAPIVersion(&sar, test.accepted)
+		var versionErr *UnsupportedAPIVersionError
+		if test.converted == "" {
+			if !errors.As(err, &versionErr) || versionErr.APIVersion != test.apiVersion {
+				t.Errorf("%s with %v: expected unsupported apiVersion, got %v", test.apiVersion, test.accepted, err)
+			}
+		} else if err != nil || sar.APIVersion != test.converted {
+			t.Errorf("%s with %v: expected %s, got %s, %v", test.apiVersion, test.accepted, test.converted, sar.APIVersion, err)
+		}
+	}
+
+	if ValidateAPIVersions([]string{"authorization.k8s.io/v1", "v1"}) == nil || ValidateAPIVersions([]string{""}) == nil {
+		t.Error("Expected versions outside authorization.k8s.io to be invalid")
+	}
+}
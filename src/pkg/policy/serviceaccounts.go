@@ -0,0 +1,70 @@
+package policy
+
+import (
+	"fmt"
+	"strings"
+)
+
+// How the service accounts of protected namespaces are limited to those in Config.PrivilegedServiceAccounts
+type ServiceAccountAllowlistMode string
+
+const (
+	// Every service account of a protected namespace is a privileged system user
+	ServiceAccountAllowlistOff ServiceAccountAllowlistMode = "off"
+	// As ServiceAccountAllowlistOff, but ServiceAccountAllowlistViolation reports the requests of service
+	// accounts which aren't listed that ServiceAccountAllowlistEnforce would deny, so they can be logged
+	ServiceAccountAllowlistLog ServiceAccountAllowlistMode = "log"
+	// Only the listed service accounts of protected namespaces are privileged system users
+	ServiceAccountAllowlistEnforce ServiceAccountAllowlistMode = "enforce"
+)
+
+// Returns error if entries aren't all service accounts given as namespace/name, ignoring empty entries
+func ValidatePrivilegedServiceAccounts(entries []string) error {
+	for _, entry := range entries {
+		namespace, name, found := strings.Cut(entry, "/")
+		if entry != "" && (!found || namespace == "" || name == "" || strings.ContainsAny(entry, ":*")) {
+			return fmt.Errorf("invalid privileged service account %q, expected namespace/name", entry)
+		}
+	}
+	return nil
+}
+
+// Returns true if the service account of a protected namespace may be a privileged system user
+func (p *Policy) serviceAccountListed(namespace string, name string) bool {
+	return p.serviceAccountAllowlist != ServiceAccountAllowlistEnforce || p.privilegedServiceAccounts.Has(namespace+"/"+name)
+}
+
+// Returns the namespace and name of the service account of a protected namespace making the request, or
+// its impersonator, if the allowlist doesn't list it
+func (p *Policy) unlistedServiceAccount(spec SubjectAccessReviewSpec) (string, bool) {
+	users := []string{spec.User}
+	if impersonator, ok := Impersonator(spec); ok {
+		users = append(users, impersonator.User)
+	}
+	for _, user := range users {
+		serviceAccount, ok := strings.CutPrefix(user, ServiceAccountUserPrefix)
+		namespace, name, found := strings.Cut(serviceAccount, ":")
+		if ok && found && p.IsProtectedNamespace(namespace) && !p.privilegedServiceAccounts.Has(namespace+"/"+name) {
+			return namespace + "/" + name, true
+		}
+	}
+	return "", false
+}
+
+// Returns the service account of a protected namespace, as namespace/name, whose request the policy
+// authorizes only because it doesn't enforce the allowlist, if the allowlist is in log mode, and the
+// reason enforcing it would deny the request
+func (p *Policy) ServiceAccountAllowlistViolation(sar SubjectAccessReview) (string, string, bool) {
+	if p.enforcedAllowlist == nil {
+		return "", "", false
+	}
+	serviceAccount, unlisted := p.unlistedServiceAccount(sar.Spec)
+	if !unlisted {
+		return "", "", false
+	}
+	if authorized, _ := IsRequestAuthorized(sar, p); !authorized {
+		return "", "", false
+	}
+	authorized, reason := IsRequestAuthorized(sar, p.enforcedAllowlist)
+	return serviceAccount, reason, !authorized
+}
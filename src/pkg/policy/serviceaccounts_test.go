@@ -0,0 +1,64 @@
+package policy
+
+import (
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"testing"
+)
+
+func TestServiceAccountAllowlist(t *testing.T) {
+	config := Config{
+		ProtectedNamespaces:       []string{"kube-system"},
+		PrivilegedServiceAccounts: []string{"kube-system/coredns"},
+	}
+	request := func(serviceAccount string) SubjectAccessReview {
+		return SubjectAccessReview{Spec: SubjectAccessReviewSpec{
+			User:               ServiceAccountUserPrefix + serviceAccount,
+			ResourceAttributes: &authorizationv1.ResourceAttributes{Namespace: "kube-system", Verb: "update", Resource: "configmaps", Name: "coredns"},
+		}}
+	}
+
+	for mode, expected := range map[ServiceAccountAllowlistMode]bool{"": true, ServiceAccountAllowlistOff: true, ServiceAccountAllowlistLog: true, ServiceAccountAllowlistEnforce: false} {
+		config.ServiceAccountAllowlist = mode
+		policy := Compile(config)
+		if authorized, _ := IsRequestAuthorized(request("kube-system:coredns"), policy); !authorized {
+			t.Errorf("%q: expected listed service account to be authorized", mode)
+		}
+		if authorized, _ := IsRequestAuthorized(request("kube-system:debug"), policy); authorized != expected {
+			t.Errorf("%q: expected unlisted service account authorized to be %t", mode, expected)
+		}
+		if _, _, violation := policy.ServiceAccountAllowlistViolation(request("kube-system:debug")); violation != (mode == ServiceAccountAllowlistLog) {
+			t.Errorf("%q: expected violation only to be reported in log mode", mode)
+		}
+	}
+
+	config.ServiceAccountAllowlist = ServiceAccountAllowlistLog
+	policy := Compile(config)
+	serviceAccount, reason, violation := policy.ServiceAccountAllowlistViolation(request("kube-system:debug"))
+	if !violation || serviceAccount != "kube-system/debug" || reason == "" {
+		t.Errorf("Expected violation by kube-system/debug with a reason, got %q %q %t", serviceAccount, reason, violation)
+	}
+	// Requests the allowlist makes no difference to aren't violations
+	for _, sar := range []SubjectAccessReview{
+		request("kube-system:coredns"),
+		request("default:debug"),
+		{Spec: SubjectAccessReviewSpec{User: ServiceAccountUserPrefix + "kube-system:debug", ResourceAttributes: &authorizationv1.ResourceAttributes{Namespace: "default", Verb: "update", Resource: "configmaps"}}},
+	} {
+		if _, _, violation := policy.ServiceAccountAllowlistViolation(sar); violation {
+			t.Errorf("Unexpected violation for %s", sar.Spec.User)
+		}
+	}
+}
+
+func TestValidatePrivilegedServiceAccounts(t *testing.T) {
+	if err := ValidatePrivilegedServiceAccounts([]string{"", "kube-system/coredns"}); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+	for _, entry := range []string{"coredns", "kube-system/", "/coredns", "kube-system:coredns", "kube-system/*"} {
+		if err := ValidatePrivilegedServiceAccounts([]string{entry}); err == nil {
+			t.Errorf("Expected %q to be rejected", entry)
+		}
+	}
+	if err := (Config{ServiceAccountAllowlist: "audit"}).Validate(); err == nil {
+		t.Error("Expected invalid allowlist mode to be rejected")
+	}
+}
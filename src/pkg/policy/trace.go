@@ -0,0 +1,102 @@
+package policy
+
+// Facts about a request which the policy's rules test
+type Classifications struct {
+	AdditionalPrivilegedUser bool `json:"additionalPrivilegedUser"`
+	PrivilegedSystemUser     bool `json:"privilegedSystemUser"`
+	ProtectedNamespace       bool `json:"protectedNamespace"`
+	// Protected namespace entry matching the request's namespace, and the exempt entry overruling it, if any
+	ProtectedBy string `json:"protectedBy,omitempty"`
+	ExemptedBy  string `json:"exemptedBy,omitempty"`
+	// Webhook object entry matching the request, if it's a write
+	WebhookObject string `json:"webhookObject,omitempty"`
+	// First name rule matching the request, if any
+	NameRule string `json:"nameRule,omitempty"`
+	// Set for requests without a namespace for namespaced resources
+	AllNamespaces bool `json:"allNamespaces"`
+	Secret        bool `json:"secret"`
+	// Set for list and watch requests for secrets without a name or selector
+	BulkSecretRead bool      `json:"bulkSecretRead"`
+	ReadonlyVerb   bool      `json:"readonlyVerb"`
+	VerbClass      VerbClass `json:"verbClass,omitempty"`
+	// Set for * resource requests, and for * verb requests
+	AllResources bool `json:"allResources"`
+	Wildcard     bool `json:"wildcard"`
+	// User impersonating the request's user, if any
+	Impersonator string `json:"impersonator,omitempty"`
+}
+
+// Outcome of one of the policy's rules for a request
+type RuleTrace struct {
+	Rule        string `json:"rule"`
+	Description string `json:"description"`
+	// Set if the rule's conditions hold for the request
+	Applies bool `json:"applies"`
+	// Set for the first rule which applies, which decides the request unless an impersonator's is denied
+	Decisive bool `json:"decisive,omitempty"`
+}
+
+// Step by step account of a decision, answering why a request was allowed or denied
+type Trace struct {
+	Explanation
+	Classifications Classifications `json:"classifications"`
+	// Every rule in the order they are considered
+	Rules []RuleTrace `json:"rules"`
+	// Trace of the impersonator's own request, which must be authorized too for an impersonated
+	// request to be
+	Impersonator *Trace `json:"impersonator,omitempty"`
+}
+
+// Explains the decision for a SubjectAccessReview, with the classifications of the request and the
+// outcome of every rule
+func TraceDecision(sar SubjectAccessReview, policy *Policy, opinionMode bool) Trace {
+	trace := Trace{Explanation: Explain(sar, policy, opinionMode)}
+	facts := policy.requestFacts(sar.Spec)
+	trace.Classifications = Classifications{
+		AdditionalPrivilegedUser: facts.privilegedUser,
+		PrivilegedSystemUser:     facts.privilegedSystemUser,
+		ProtectedNamespace:       facts.protectedNamespace,
+		AllNamespaces:            facts.allNamespaces,
+		Secret:                   facts.secret,
+		BulkSecretRead:           facts.bulkSecretRead,
+		ReadonlyVerb:             facts.readonlyVerb,
+		AllResources:             facts.allResources,
+		Wildcard:                 facts.wildcard,
+	}
+	if attributes := sar.Spec.ResourceAttributes; attributes != nil {
+		trace.Classifications.ProtectedBy = policy.protectedNamespaces.MatchingEntry(attributes.Namespace)
+		if trace.Classifications.ProtectedBy != "" {
+			trace.Classifications.ExemptedBy = policy.exemptNamespaces.MatchingEntry(attributes.Namespace)
+		}
+		trace.Classifications.VerbClass = policy.ClassifyVerb(attributes.Verb)
+		if facts.nameRule != nil {
+			trace.Classifications.NameRule = facts.nameRule.Name
+		}
+		if facts.webhookObject {
+			trace.Classifications.WebhookObject = policy.webhookObjectEntry(sar.Spec)
+		}
+	}
+
+	decided := false
+	add := func(rule RuleTrace) {
+		rule.Decisive = rule.Applies && !decided
+		decided = decided || rule.Applies
+		trace.Rules = append(trace.Rules, rule)
+	}
+	add(RuleTrace{
+		Rule:        RuleImpersonatedWrite,
+		Description: "Denies impersonated writes to protected namespaces if impersonated writes are denied",
+		Applies:     policy.DeniesImpersonatedWrite(sar.Spec),
+	})
+	for _, rule := range identityRules {
+		add(RuleTrace{Rule: rule.name, Description: rule.description, Applies: rule.applies(policy, facts)})
+	}
+	add(RuleTrace{Rule: RuleDefaultAllow, Description: "Allows requests no other rule applies to", Applies: true})
+
+	if impersonator, ok := Impersonator(sar.Spec); ok {
+		trace.Classifications.Impersonator = impersonator.User
+		impersonatorTrace := TraceDecision(SubjectAccessReview{Spec: impersonator}, policy, opinionMode)
+		trace.Impersonator = &impersonatorTrace
+	}
+	return trace
+}
@@ -0,0 +1,71 @@
+package policy
+
+import (
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"testing"
+)
+
+func TestTraceDecision(t *testing.T) {
+	policy := Compile(Config{ProtectedNamespaces: []string{"openstack-*"}, ExemptNamespaces: []string{"openstack-sandbox"}, AdditionalPrivilegedUsers: []string{"admin"}})
+	request := func(user string, verb string, namespace string, resource string) SubjectAccessReview {
+		return SubjectAccessReview{Spec: SubjectAccessReviewSpec{
+			User:               user,
+			ResourceAttributes: &authorizationv1.ResourceAttributes{Namespace: namespace, Verb: verb, Resource: resource},
+		}}
+	}
+
+	trace := TraceDecision(request("alice", "get", "openstack-system", "secrets"), policy, false)
+	if trace.Decision != "denied" || trace.Rule != RuleProtectedSecrets {
+		t.Errorf("Expected denial by %s, got %+v", RuleProtectedSecrets, trace.Explanation)
+	}
+	if c := trace.Classifications; !c.ProtectedNamespace || c.ProtectedBy != "openstack-*" || !c.Secret || !c.ReadonlyVerb || c.AdditionalPrivilegedUser {
+		t.Errorf("Unexpected classifications %+v", c)
+	}
+	decisive := 0
+	for _, rule := range trace.Rules {
+		if rule.Decisive {
+			decisive++
+			if rule.Rule != RuleProtectedSecrets {
+				t.Errorf("Expected %s to be decisive, got %s", RuleProtectedSecrets, rule.Rule)
+			}
+		}
+	}
+	if decisive != 1 || trace.Rules[len(trace.Rules)-1].Rule != RuleDefaultAllow {
+		t.Errorf("Expected one decisive rule and every rule to be listed, got %+v", trace.Rules)
+	}
+
+	// The exempt entry is reported alongside the protected entry it overrules
+	trace = TraceDecision(request("alice", "create", "openstack-sandbox", "pods"), policy, false)
+	if c := trace.Classifications; c.ProtectedNamespace || c.ProtectedBy != "openstack-*" || c.ExemptedBy != "openstack-sandbox" || trace.Rule != RuleDefaultAllow {
+		t.Errorf("Expected exempted namespace to be allowed by default, got %+v %+v", c, trace.Explanation)
+	}
+}
+
+func TestTraceDecisionMatchesRules(t *testing.T) {
+	policy := Compile(Config{ProtectedNamespaces: []string{"kube-system"}, AdditionalPrivilegedUsers: []string{"admin"}, WildcardRequests: WildcardDeny, DenyImpersonatedProtectedWrites: true})
+	for _, user := range []string{"alice", "admin", "system:kube-scheduler"} {
+		for _, verb := range []string{"get", "create", "*"} {
+			for _, namespace := range []string{"", "kube-system", "default"} {
+				for _, resource := range []string{"pods", "secrets", "*"} {
+					sar := SubjectAccessReview{Spec: impersonatedSpec(user, "", verb, namespace)}
+					sar.Spec.ResourceAttributes.Resource = resource
+					rule, _, _ := MatchRule(sar, policy)
+					var decisive string
+					for _, traced := range TraceDecision(sar, policy, false).Rules {
+						if traced.Decisive {
+							decisive = traced.Rule
+						}
+					}
+					if decisive != rule {
+						t.Errorf("%s %s %s in %q: trace decided by %s, rules by %s", user, verb, resource, namespace, decisive, rule)
+					}
+				}
+			}
+		}
+	}
+
+	trace := TraceDecision(SubjectAccessReview{Spec: impersonatedSpec("admin", "alice", "create", "kube-system")}, policy, false)
+	if trace.Classifications.Impersonator != "alice" || trace.Impersonator == nil || trace.Rule != RuleImpersonatedWrite || !trace.Rules[0].Decisive {
+		t.Errorf("Expected impersonated write to be traced, got %+v", trace)
+	}
+}
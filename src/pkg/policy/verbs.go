@@ -0,0 +1,58 @@
+package policy
+
+import (
+	"fmt"
+)
+
+// Classification of a resource request's verb
+type VerbClass string
+
+const (
+	VerbRead  VerbClass = "read"
+	VerbWrite VerbClass = "write"
+	// Neither a known read nor write verb, e.g. a custom verb of an aggregated API. Treated as a write,
+	// so new verbs are restricted until classified
+	VerbUnrecognized VerbClass = "unrecognized"
+)
+
+var readonlyVerbs = toSet([]string{"get", "list", "watch", "proxy"})
+
+// Returns true if verb can't modify resources, as a built-in read verb
+func IsReadonlyVerb(verb string) bool {
+	return readonlyVerbs.Has(verb)
+}
+
+// Verbs which modify resources or grant access to do so, including the special verbs of RBAC,
+// impersonation and certificate signing
+var writeVerbs = toSet([]string{"create", "update", "patch", "delete", "deletecollection", "bind", "escalate", "impersonate", "approve", "sign"})
+
+// Returns error if verbs are classified as both read and write, or include the wildcard verb
+func validateVerbs(readonly []string, write []string) error {
+	for _, verb := range readonly {
+		if verb == "*" || writeVerbs.Has(verb) || toSet(write).Has(verb) {
+			return fmt.Errorf("verb %q can't be read-only", verb)
+		}
+	}
+	for _, verb := range write {
+		if verb == "*" || readonlyVerbs.Has(verb) {
+			return fmt.Errorf("verb %q can't be a write verb", verb)
+		}
+	}
+	return nil
+}
+
+// Returns the class of verb. The wildcard verb includes writes
+func (p *Policy) ClassifyVerb(verb string) VerbClass {
+	switch {
+	case readonlyVerbs.Has(verb) || p.readonlyVerbs.Has(verb):
+		return VerbRead
+	case verb == "*" || writeVerbs.Has(verb) || p.writeVerbs.Has(verb):
+		return VerbWrite
+	}
+	return VerbUnrecognized
+}
+
+// Returns true if verb can't modify resources, as a built-in or configured read verb
+func (p *Policy) IsReadonlyVerb(verb string) bool {
+	return p.ClassifyVerb(verb) == VerbRead
+}
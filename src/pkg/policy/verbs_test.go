@@ -0,0 +1,55 @@
+package policy
+
+import (
+	"testing"
+)
+
+func TestClassifyVerb(t *testing.T) {
+	compiled := Compile(Config{AdditionalReadonlyVerbs: []string{"view"}, AdditionalWriteVerbs: []string{"rollout"}})
+	tests := map[string]VerbClass{
+		"get":              VerbRead,
+		"proxy":            VerbRead,
+		"view":             VerbRead,
+		"patch":            VerbWrite,
+		"deletecollection": VerbWrite,
+		"escalate":         VerbWrite,
+		"rollout":          VerbWrite,
+		"*":                VerbWrite,
+		"approve-all":      VerbUnrecognized,
+	}
+	for verb, class := range tests {
+		if got := compiled.ClassifyVerb(verb); got != class {
+			t.Errorf("%s: expected %s, got %s", verb, class, got)
+		}
+	}
+}
+
+func TestUnrecognizedVerbsAreWrites(t *testing.T) {
+	sar := func(verb string) SubjectAccessReview {
+		return SubjectAccessReview{Spec: impersonatedSpec("alice", "", verb, "kube-system")}
+	}
+	compiled := Compile(Config{ProtectedNamespaces: []string{"kube-system"}})
+	if rule, authorized, _ := MatchRule(sar("view"), compiled); authorized || rule != RuleProtectedWrite {
+		t.Errorf("Expected unrecognized verb to be denied as a write, got %s", rule)
+	}
+	compiled = Compile(Config{ProtectedNamespaces: []string{"kube-system"}, AdditionalReadonlyVerbs: []string{"view"}})
+	if rule, authorized, _ := MatchRule(sar("view"), compiled); !authorized {
+		t.Errorf("Expected configured read verb to be allowed, got %s", rule)
+	}
+}
+
+func TestValidateVerbs(t *testing.T) {
+	for _, config := range []Config{
+		{AdditionalReadonlyVerbs: []string{"delete"}},
+		{AdditionalReadonlyVerbs: []string{"*"}},
+		{AdditionalWriteVerbs: []string{"watch"}},
+		{AdditionalReadonlyVerbs: []string{"view"}, AdditionalWriteVerbs: []string{"view"}},
+	} {
+		if config.Validate() == nil {
+			t.Errorf("Expected %+v to be invalid", config)
+		}
+	}
+	if err := (Config{AdditionalReadonlyVerbs: []string{"view", ""}, AdditionalWriteVerbs: []string{"rollout"}}).Validate(); err != nil {
+		t.Errorf("Expected custom verbs to be valid, got %v", err)
+	}
+}
@@ -0,0 +1,82 @@
+package policy
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Objects kube-apiserver reads its own configuration from, protected unless DisableWebhookProtection is
+// set, as changing them is the quickest way to drop the webhook from the authorizer chain
+var DefaultWebhookObjects = []string{
+	"kube-system/configmaps/kubeadm-config",
+	"kube-system/configmaps/kube-apiserver-*",
+	"kube-system/configmaps/extension-apiserver-authentication",
+}
+
+// Object the webhook depends on, given as NAMESPACE/RESOURCE[.GROUP]/NAME. The namespace and name may be
+// glob patterns
+type webhookObject struct {
+	namespace string
+	group     string
+	resource  string
+	name      string
+}
+
+func parseWebhookObject(entry string) (webhookObject, error) {
+	parts := strings.Split(entry, "/")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" || parts[1] != canonicalName(parts[1]) || strings.ContainsAny(parts[1], "*?[") {
+		return webhookObject{}, fmt.Errorf("invalid webhook object %q, expected NAMESPACE/RESOURCE[.GROUP]/NAME", entry)
+	}
+	for _, pattern := range []string{parts[0], parts[2]} {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return webhookObject{}, fmt.Errorf("invalid webhook object %q: %w", entry, err)
+		}
+	}
+	resource, group, _ := strings.Cut(parts[1], ".")
+	return webhookObject{namespace: parts[0], group: group, resource: resource, name: parts[2]}, nil
+}
+
+// Returns error describing the first malformed webhook object, ignoring empty entries
+func ValidateWebhookObjects(entries []string) error {
+	for _, entry := range entries {
+		if entry == "" {
+			continue
+		}
+		if _, err := parseWebhookObject(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the webhook objects for the Service in namespace kube-apiserver reaches the webhook through, and
+// the Endpoints and EndpointSlices routing it to the webhook's pods
+func WebhookServiceObjects(namespace string, name string) []string {
+	return []string{
+		namespace + "/services/" + name,
+		namespace + "/endpoints/" + name,
+		namespace + "/endpointslices.discovery.k8s.io/" + name + "-*",
+	}
+}
+
+// Returns the entry protecting the object a resource request is for, or the empty string if there is
+// none. Requests without a name match any object, except creates, which can't replace an existing one
+func (p *Policy) webhookObjectEntry(spec SubjectAccessReviewSpec) string {
+	attributes := spec.ResourceAttributes
+	if attributes == nil {
+		return ""
+	}
+	for i, object := range p.webhookObjects {
+		if (attributes.Resource != "*" && attributes.Resource != object.resource) || (attributes.Group != "*" && attributes.Group != object.group) {
+			continue
+		}
+		if matched, _ := path.Match(object.namespace, attributes.Namespace); !matched && attributes.Namespace != "" {
+			continue
+		}
+		if matched, _ := path.Match(object.name, attributes.Name); matched || (attributes.Name == "" && attributes.Verb != "create") {
+			return p.webhookObjectEntries[i]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,74 @@
+package policy
+
+import (
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"testing"
+)
+
+func TestWebhookObjects(t *testing.T) {
+	config := Config{
+		ProtectedNamespaces: []string{"kube-system", "azimuth-*"},
+		// Exempting the namespaces the objects are in doesn't leave them unprotected
+		ExemptNamespaces: []string{"kube-system", "azimuth-authz"},
+		WebhookObjects:   append(WebhookServiceObjects("azimuth-authz", "webhook"), "azimuth-authz/secrets/webhook-tls"),
+	}
+	policy := Compile(config)
+	request := func(user string, verb string, namespace string, group string, resource string, name string) SubjectAccessReview {
+		return SubjectAccessReview{Spec: SubjectAccessReviewSpec{
+			User:               user,
+			ResourceAttributes: &authorizationv1.ResourceAttributes{Namespace: namespace, Verb: verb, Group: group, Resource: resource, Name: name},
+		}}
+	}
+
+	for _, sar := range []SubjectAccessReview{
+		request("alice", "update", "kube-system", "", "configmaps", "kubeadm-config"),
+		request("alice", "delete", "kube-system", "", "configmaps", "kube-apiserver-legacy-service-account-token-tracking"),
+		request("alice", "deletecollection", "kube-system", "", "configmaps", ""),
+		request("alice", "patch", "azimuth-authz", "", "services", "webhook"),
+		request("alice", "update", "azimuth-authz", "", "endpoints", "webhook"),
+		request("alice", "delete", "azimuth-authz", "discovery.k8s.io", "endpointslices", "webhook-x7k2p"),
+		request("alice", "delete", "azimuth-authz", "", "secrets", "webhook-tls"),
+		request("alice", "*", "azimuth-authz", "*", "*", ""),
+		// Across all namespaces
+		request("alice", "deletecollection", "", "", "secrets", ""),
+	} {
+		if rule, authorized, _ := MatchRule(sar, policy); authorized || rule != RuleWebhookObject {
+			t.Errorf("Expected %+v to be denied by %s, got %s", *sar.Spec.ResourceAttributes, RuleWebhookObject, rule)
+		}
+	}
+
+	for _, sar := range []SubjectAccessReview{
+		request("alice", "get", "kube-system", "", "configmaps", "kubeadm-config"),
+		request("alice", "update", "kube-system", "", "configmaps", "coredns"),
+		request("alice", "create", "kube-system", "", "configmaps", ""),
+		request("alice", "delete", "azimuth-authz", "", "services", "other"),
+		request("alice", "delete", "default", "", "services", "webhook"),
+		request("system:kube-controller-manager", "update", "azimuth-authz", "", "endpoints", "webhook"),
+	} {
+		if rule, authorized, _ := MatchRule(sar, policy); !authorized && rule == RuleWebhookObject {
+			t.Errorf("Expected %+v not to be denied by %s", *sar.Spec.ResourceAttributes, RuleWebhookObject)
+		}
+	}
+
+	config.DisableWebhookProtection = true
+	sar := request("alice", "update", "kube-system", "", "configmaps", "kubeadm-config")
+	if _, authorized, _ := MatchRule(sar, Compile(config)); !authorized {
+		t.Error("Expected write to exempt namespace to be allowed with webhook protection disabled")
+	}
+
+	trace := TraceDecision(sar, policy, false)
+	if trace.Classifications.WebhookObject != "kube-system/configmaps/kubeadm-config" {
+		t.Errorf("Expected matching webhook object to be traced, got %+v", trace.Classifications)
+	}
+}
+
+func TestValidateWebhookObjects(t *testing.T) {
+	if err := ValidateWebhookObjects([]string{"", "kube-system/configmaps/kubeadm-config", "az-*/certificates.cert-manager.io/webhook-*"}); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+	for _, entry := range []string{"kube-system/configmaps", "kube-system/ConfigMaps/kubeadm-config", "kube-system/*/kubeadm-config", "kube-system/configmaps/[", "/configmaps/kubeadm-config"} {
+		if err := ValidateWebhookObjects([]string{entry}); err == nil {
+			t.Errorf("Expected %q to be rejected", entry)
+		}
+	}
+}
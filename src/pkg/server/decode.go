@@ -0,0 +1,218 @@
+package server
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// Unknown fields tolerated in SubjectAccessReviews, as prefixes of their paths. kube-apiserver adds
+// fields to the spec, its attributes and the status in new releases, which mustn't break authorization
+// when it's upgraded first. Unknown fields are dropped, so rules only see the fields this release knows
+var CompatibleSubjectAccessReviewFields = []string{"spec.", "status."}
+
+// Request body which couldn't be decoded, locating the problem for whoever sent it
+type DecodeError struct {
+	// Path of the offending field, e.g. 'spec.user', empty if the body isn't valid JSON
+	Field string
+	// Offset in the body at which the problem was found, zero if unknown
+	Offset  int64
+	Message string
+}
+
+func (e *DecodeError) Error() string {
+	var sb strings.Builder
+	if e.Field != "" {
+		sb.WriteString(e.Field)
+		sb.WriteString(": ")
+	}
+	sb.WriteString(e.Message)
+	if e.Offset > 0 {
+		fmt.Fprintf(&sb, " (at byte %d)", e.Offset)
+	}
+	return sb.String()
+}
+
+// Implemented by types with custom JSON decoding, returning the struct their JSON is decoded into so
+// that DecodeJSON can find unknown fields in it
+type JSONFielder interface {
+	JSONFields() any
+}
+
+// Decodes JSON from body into v. Unknown fields are rejected, unless their path starts with one of
+// compatible, and errors are returned as a DecodeError
+func DecodeJSON(body io.Reader, v any, compatible []string) error {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	err = json.Unmarshal(data, v)
+	var syntaxErr *json.SyntaxError
+	switch {
+	case errors.As(err, &syntaxErr):
+		return &DecodeError{Offset: syntaxErr.Offset, Message: "invalid JSON: " + strings.TrimPrefix(syntaxErr.Error(), "json: ")}
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return &DecodeError{Message: "invalid JSON: unexpected end of body"}
+	}
+
+	// Fields are checked separately, as decoders can't locate problems inside custom JSON decoding
+	walker := &fieldWalker{}
+	walker.walk(data, 0, reflect.TypeOf(v), "")
+	if walker.typeErr != nil {
+		err = walker.typeErr
+	}
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &typeErr):
+		return &DecodeError{Field: typeErr.Field, Offset: typeErr.Offset, Message: fmt.Sprintf("expected %s, got %s", jsonTypeName(typeErr.Type), typeErr.Value)}
+	case err != nil:
+		return &DecodeError{Message: strings.TrimPrefix(err.Error(), "json: ")}
+	}
+	slices.SortFunc(walker.unknown, func(a, b DecodeError) int { return cmp.Compare(a.Offset, b.Offset) })
+	for _, field := range walker.unknown {
+		if !hasAnyPrefix(field.Field, compatible) {
+			return &field
+		}
+	}
+	return nil
+}
+
+// Returns whether value starts with any of prefixes, ignoring case as encoding/json does for field names
+func hasAnyPrefix(value string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if len(value) >= len(prefix) && strings.EqualFold(value[:len(prefix)], prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	unmarshalerType = reflect.TypeFor[json.Unmarshaler]()
+	jsonFielderType = reflect.TypeFor[JSONFielder]()
+)
+
+// Finds fields of JSON which don't exist in the type it's decoded into, matching names case-insensitively
+// as encoding/json does, and type errors inside JSONFielders
+type fieldWalker struct {
+	unknown []DecodeError
+	typeErr *json.UnmarshalTypeError
+}
+
+// Walks data at offset in the body, decoded into t at path. Array elements share the path of the
+// array, as in json.UnmarshalTypeError
+func (w *fieldWalker) walk(data []byte, offset int64, t reflect.Type, path string) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if reflect.PointerTo(t).Implements(jsonFielderType) {
+		t = reflect.TypeOf(reflect.New(t).Interface().(JSONFielder).JSONFields())
+		var typeErr *json.UnmarshalTypeError
+		if err := json.Unmarshal(data, reflect.New(t).Interface()); w.typeErr == nil && errors.As(err, &typeErr) {
+			typeErr.Field = strings.TrimPrefix(path+"."+typeErr.Field, ".")
+			typeErr.Offset += offset
+			w.typeErr = typeErr
+		}
+	} else if reflect.PointerTo(t).Implements(unmarshalerType) {
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		fields := jsonFields(t)
+		eachJSONValue(data, offset, '{', func(key string, value []byte, valueOffset int64) {
+			fieldPath := strings.TrimPrefix(path+"."+key, ".")
+			if field, ok := fields[strings.ToLower(key)]; ok {
+				w.walk(value, valueOffset, field, fieldPath)
+			} else {
+				w.unknown = append(w.unknown, DecodeError{Field: fieldPath, Offset: valueOffset, Message: "unknown field"})
+			}
+		})
+	case reflect.Map:
+		eachJSONValue(data, offset, '{', func(key string, value []byte, valueOffset int64) {
+			w.walk(value, valueOffset, t.Elem(), path+"."+key)
+		})
+	case reflect.Slice, reflect.Array:
+		eachJSONValue(data, offset, '[', func(_ string, value []byte, valueOffset int64) {
+			w.walk(value, valueOffset, t.Elem(), path)
+		})
+	}
+}
+
+// Calls f with each member of the object or element of the array in data, as given by delim, and its
+// offset in the body. Does nothing if data is something else
+func eachJSONValue(data []byte, offset int64, delim json.Delim, f func(key string, value []byte, valueOffset int64)) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if token, err := decoder.Token(); err != nil || token != delim {
+		return
+	}
+	for decoder.More() {
+		var key string
+		if delim == '{' {
+			token, err := decoder.Token()
+			if err != nil {
+				return
+			}
+			key, _ = token.(string)
+		}
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err != nil {
+			return
+		}
+		f(key, value, offset+decoder.InputOffset()-int64(len(value)))
+	}
+}
+
+// Returns types of the fields encoding/json decodes into a struct, by lower case name, including those
+// of embedded structs without a name
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	fields := map[string]reflect.Type{}
+	for i := range t.NumField() {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" || (!field.IsExported() && !field.Anonymous) {
+			continue
+		}
+		embedded := field.Type
+		if embedded.Kind() == reflect.Pointer {
+			embedded = embedded.Elem()
+		}
+		if field.Anonymous && name == "" && embedded.Kind() == reflect.Struct {
+			for embeddedName, embeddedType := range jsonFields(embedded) {
+				if _, ok := fields[embeddedName]; !ok {
+					fields[embeddedName] = embeddedType
+				}
+			}
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields[strings.ToLower(name)] = field.Type
+	}
+	return fields
+}
+
+// Returns the JSON type decoded into Go values of type t
+func jsonTypeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	case reflect.Map, reflect.Struct:
+		return "object"
+	}
+	return t.String()
+}
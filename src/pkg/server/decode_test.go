@@ -0,0 +1,104 @@
+package server
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/policy"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestDecodeJSON(t *testing.T) {
+	var sar policy.SubjectAccessReview
+	err := DecodeJSON(strings.NewReader(`{
+		"apiVersion":"authorization.k8s.io/v1",
+		"Spec":{"user":"alice","resourceAttributes":{"verb":"get","resource":"pods","futureAttribute":true}}
+	}`), &sar, CompatibleSubjectAccessReviewFields)
+	if err != nil || sar.Spec.User != "alice" || sar.Spec.ResourceAttributes.Verb != "get" {
+		t.Errorf("Expected compatible unknown field to be tolerated, got %+v, %v", sar.Spec, err)
+	}
+
+	tests := []struct {
+		body  string
+		field string
+		error string
+	}{
+		{`{"spec":{"user":"alice"},"specs":{}}`, "specs", "specs: unknown field"},
+		{`{"metadata":{"uuid":"x"},"spec":{"user":"alice"}}`, "metadata.uuid", "metadata.uuid: unknown field"},
+		{`{"spec":{"user":"alice","resourceAttributes":{"namespace":1}}}`, "spec.resourceAttributes.namespace",
+			"spec.resourceAttributes.namespace: expected string, got number (at byte 59)"},
+		{`{"spec":{"user":"alice","groups":"staff"}}`, "spec.groups", "expected array, got string"},
+		{`{"spec":`, "", "invalid JSON"},
+		{`{"spec":}`, "", "invalid JSON: invalid character '}' looking for beginning of value (at byte 9)"},
+	}
+	for _, test := range tests {
+		var sar policy.SubjectAccessReview
+		err := DecodeJSON(strings.NewReader(test.body), &sar, CompatibleSubjectAccessReviewFields)
+		var decodeErr *DecodeError
+		if !errors.As(err, &decodeErr) || decodeErr.Field != test.field || !strings.Contains(err.Error(), test.error) {
+			t.Errorf("%s: expected error on field %q containing %q, got %v", test.body, test.field, test.error, err)
+		}
+	}
+}
+
+func TestDecodeJSONSlices(t *testing.T) {
+	var batch struct {
+		Items []policy.SubjectAccessReview `json:"items"`
+	}
+	err := DecodeJSON(strings.NewReader(`{"items":[{"spec":{"nonResourceAttributes":{"path":"/","future":1}}},{"spec":{"extra":{"a":["b"]},"unknown":1}}]}`),
+		&batch, []string{"items.spec.nonResourceAttributes."})
+	var decodeErr *DecodeError
+	if !errors.As(err, &decodeErr) || decodeErr.Field != "items.spec.unknown" {
+		t.Errorf("Expected unknown field in second item, got %v", err)
+	}
+}
+
+// SubjectAccessReviews as sent by newer kube-apiserver releases, with fields this release may not know
+var newerSubjectAccessReviewFixtures = map[string]string{
+	// Field and label selectors, added in 1.31
+	"selectors": `{
+		"apiVersion":"authorization.k8s.io/v1","kind":"SubjectAccessReview",
+		"spec":{
+			"user":"alice","groups":["system:authenticated"],
+			"resourceAttributes":{
+				"namespace":"default","verb":"list","resource":"pods",
+				"fieldSelector":{"rawSelector":"spec.nodeName=node-1","requirements":[{"key":"spec.nodeName","operator":"In","values":["node-1"]}]},
+				"labelSelector":{"requirements":[{"key":"app","operator":"Exists"}]}
+			}
+		}
+	}`,
+	// Hypothetical fields of later releases, at each level kube-apiserver may add them
+	"future": `{
+		"apiVersion":"authorization.k8s.io/v1","kind":"SubjectAccessReview",
+		"metadata":{"uid":"f7a6"},
+		"spec":{
+			"user":"alice","groups":["system:authenticated"],
+			"serviceAccountInfo":{"audience":["api"],"boundObject":{"kind":"Pod"}},
+			"resourceAttributes":{"namespace":"default","verb":"get","resource":"pods","conditions":[{"type":"Future"}]},
+			"requestPriority":3
+		},
+		"status":{"allowed":false,"conditions":[]}
+	}`,
+	"future-non-resource": `{
+		"apiVersion":"authorization.k8s.io/v1","kind":"SubjectAccessReview",
+		"spec":{"user":"alice","nonResourceAttributes":{"path":"/healthz","verb":"get","query":{"verbose":"1"}},"sessionID":"abc"}
+	}`,
+}
+
+func TestDecodeNewerSubjectAccessReviews(t *testing.T) {
+	for name, fixture := range newerSubjectAccessReviewFixtures {
+		var sar policy.SubjectAccessReview
+		if err := DecodeJSON(strings.NewReader(fixture), &sar, CompatibleSubjectAccessReviewFields); err != nil {
+			t.Errorf("%s: expected newer fields to be tolerated, got %v", name, err)
+			continue
+		}
+		if sar.Spec.User != "alice" || policy.Validate(sar) != nil {
+			t.Errorf("%s: expected known fields to be decoded, got %+v", name, sar.Spec)
+		}
+	}
+
+	var sar policy.SubjectAccessReview
+	DecodeJSON(strings.NewReader(newerSubjectAccessReviewFixtures["selectors"]), &sar, CompatibleSubjectAccessReviewFields)
+	if attributes := sar.Spec.ResourceAttributes; attributes.FieldSelector == nil || attributes.FieldSelector.RawSelector != "spec.nodeName=node-1" || attributes.LabelSelector == nil {
+		t.Errorf("Expected selectors to be decoded, got %+v", attributes)
+	}
+}
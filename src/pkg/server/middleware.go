@@ -0,0 +1,179 @@
+package server
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/policy"
+	"context"
+	"errors"
+	"fmt"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"log"
+	"net/http"
+	"runtime/debug"
+)
+
+// Wraps a handler with behaviour shared across requests, such as authentication, rate limiting or
+// logging
+type Middleware func(next http.Handler) http.Handler
+
+// Returns handler wrapped in middleware, the first being outermost
+func Chain(handler http.Handler, middleware ...Middleware) http.Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+	return handler
+}
+
+// Responds with an internal server error, rather than dropping the connection, if next panics
+func Recover(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if err := recover(); err != nil {
+				if err == http.ErrAbortHandler {
+					panic(err)
+				}
+				log.Printf("Panic handling %s: %v\n%s", r.URL.Path, err, debug.Stack())
+				WriteError(w, http.StatusInternalServerError, errors.New("Internal server error"))
+			}
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+type recoveryKey struct{}
+
+// Returns middleware answering requests whose handling panics with a SubjectAccessReview response, with
+// no verdict or denied as failurePolicy says, so kube-apiserver gets a decision rather than an error. The
+// response has the apiVersion, kind and UID of the request if Decode got that far. If not nil, panicked is
+// called with the value of each panic
+func RecoverWith(failurePolicy FailurePolicy, panicked func(r *http.Request, err any)) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Filled in by Decode, which sees the request before anything likely to panic
+			decoded := &decodedRequest{}
+			tracked := &trackingResponseWriter{ResponseWriter: w}
+			defer func() {
+				if err := recover(); err != nil {
+					if err == http.ErrAbortHandler {
+						panic(err)
+					}
+					log.Printf("Panic handling %s: %v\n%s", r.URL.Path, err, debug.Stack())
+					if panicked != nil {
+						panicked(r, err)
+					}
+					if tracked.written {
+						// Too late for another response, so the connection is closed
+						panic(http.ErrAbortHandler)
+					}
+					status := failurePolicy.apply(authorizationv1.SubjectAccessReviewStatus{EvaluationError: fmt.Sprintf("internal error: %v", err)})
+					WriteResponse(w, NewResponse(decoded.request, decoded.sar.UID, status))
+				}
+			}()
+			next.ServeHTTP(tracked, r.WithContext(context.WithValue(r.Context(), recoveryKey{}, decoded)))
+		})
+	}
+}
+
+// Records whether a response has been started
+type trackingResponseWriter struct {
+	http.ResponseWriter
+	written bool
+}
+
+func (w *trackingResponseWriter) WriteHeader(statusCode int) {
+	w.written = true
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *trackingResponseWriter) Write(data []byte) (int, error) {
+	w.written = true
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *trackingResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Rejects requests for which authenticated returns false as unauthorized
+func Authenticate(authenticated func(r *http.Request) bool) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !authenticated(r) {
+				WriteError(w, http.StatusUnauthorized, errors.New("Unauthorized"))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Returns middleware making the policy in effect in source when a request arrives the one it's evaluated
+// and recorded with, so replacing the policy never affects requests in flight
+func PinPolicy(source *policy.Source) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(policy.WithSnapshot(r.Context(), source.Current())))
+		})
+	}
+}
+
+type subjectAccessReviewKey struct{}
+
+type decodedRequest struct {
+	sar     policy.SubjectAccessReview
+	request metav1.TypeMeta
+}
+
+// Decodes the SubjectAccessReview in the request body as DecodeRequest does, making it available to
+// later middleware and the handler with RequestSubjectAccessReview
+func Decode(next http.Handler) http.Handler {
+	return DecodeWith(RejectMalformed, nil)(next)
+}
+
+// Returns middleware decoding requests as Decode does, responding to those which can't be evaluated as
+// malformed says. If not nil, rejected is called with the error for each of them
+func DecodeWith(malformed MalformedRequestPolicy, rejected func(r *http.Request, err error)) Middleware {
+	return decode(Options{MalformedRequests: malformed, Rejected: rejected})
+}
+
+// Returns middleware decoding requests with the limits, malformed request policy and callbacks of options
+func decode(options Options) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			sar, request, truncated, err := readRequest(r, options.Limits, options.APIVersions)
+			if decoded, ok := r.Context().Value(recoveryKey{}).(*decodedRequest); ok {
+				*decoded = decodedRequest{sar: sar, request: request}
+			}
+			if options.Truncated != nil {
+				for _, limit := range truncated {
+					options.Truncated(r, limit)
+				}
+			}
+			if err != nil {
+				log.Println(err)
+				if options.Rejected != nil {
+					options.Rejected(r, err)
+				}
+				if options.MalformedRequests != DenyMalformed {
+					WriteError(w, http.StatusBadRequest, err)
+					return
+				}
+				WriteResponse(w, NewResponse(request, sar.UID, authorizationv1.SubjectAccessReviewStatus{
+					Denied:          true,
+					Reason:          "Malformed request",
+					EvaluationError: err.Error(),
+				}))
+				return
+			}
+			ctx := context.WithValue(r.Context(), subjectAccessReviewKey{}, decodedRequest{sar: sar, request: request})
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// Returns the normalised SubjectAccessReview decoded by Decode and the apiVersion and kind it was sent
+// with, or false if ctx has none
+func RequestSubjectAccessReview(ctx context.Context) (policy.SubjectAccessReview, metav1.TypeMeta, bool) {
+	decoded, ok := ctx.Value(subjectAccessReviewKey{}).(decodedRequest)
+	return decoded.sar, decoded.request, ok
+}
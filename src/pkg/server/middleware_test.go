@@ -0,0 +1,127 @@
+package server
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/policy"
+	"context"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestChainOrder(t *testing.T) {
+	var order []string
+	named := func(name string) Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				order = append(order, name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	handler := Chain(http.HandlerFunc(func(http.ResponseWriter, *http.Request) { order = append(order, "handler") }),
+		named("outer"), named("inner"))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/authorize", nil))
+	if strings.Join(order, ",") != "outer,inner,handler" {
+		t.Errorf("Expected middleware to run outermost first, got %v", order)
+	}
+}
+
+func TestRecover(t *testing.T) {
+	handler := Chain(http.HandlerFunc(func(http.ResponseWriter, *http.Request) { panic("broken") }), Recover)
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/authorize", nil))
+	if resp.Code != http.StatusInternalServerError {
+		t.Errorf("Expected panic to give internal server error, got %d", resp.Code)
+	}
+}
+
+func TestHandlerMiddleware(t *testing.T) {
+	var seen string
+	handler := NewHandler(Options{
+		Evaluate: PolicyEvaluator(policy.NewSource(policy.Config{}), false),
+		Middleware: []Middleware{
+			Authenticate(func(r *http.Request) bool {
+				sar, _, _ := RequestSubjectAccessReview(r.Context())
+				seen = sar.Spec.User
+				return sar.Spec.User != "mallory"
+			}),
+		},
+	})
+
+	resp, review := serve(t, handler, `{"apiVersion":"authorization.k8s.io/v1","kind":"SubjectAccessReview","spec":{"user":"alice","resourceAttributes":{"verb":"get","resource":"pods"}}}`)
+	if resp.Code != http.StatusOK || review.Kind != "SubjectAccessReview" || seen != "alice" {
+		t.Errorf("Expected decoded request to reach middleware and be evaluated, got %d for %q", resp.Code, seen)
+	}
+	if resp, _ := serve(t, handler, `{"apiVersion":"authorization.k8s.io/v1","kind":"SubjectAccessReview","spec":{"user":"mallory","resourceAttributes":{"verb":"get","resource":"pods"}}}`); resp.Code != http.StatusUnauthorized {
+		t.Errorf("Expected middleware to reject request, got %d", resp.Code)
+	}
+}
+
+func TestDecodeDenyingMalformed(t *testing.T) {
+	handler := NewHandler(Options{
+		Evaluate:          PolicyEvaluator(policy.NewSource(policy.Config{}), true),
+		MalformedRequests: DenyMalformed,
+	})
+	for _, body := range []string{
+		`{`,
+		`{"apiVersion":"authorization.k8s.io/v1beta1","kind":"SubjectAccessReview","spec":{"user":"alice","usr":"bob"}}`,
+		`{"apiVersion":"authorization.k8s.io/v1","kind":"SubjectAccessReview","spec":{}}`,
+	} {
+		resp, review := serve(t, handler, body)
+		if resp.Code != http.StatusOK || !review.Status.Denied || review.Status.EvaluationError == "" {
+			t.Errorf("Expected %s to be denied, got %d %+v", body, resp.Code, review.Status)
+		}
+	}
+	if _, review := serve(t, handler, `{"apiVersion":"authorization.k8s.io/v1beta1","kind":"SubjectAccessReview","spec":{"usr":"bob"}}`); review.ApiVersion != "authorization.k8s.io/v1beta1" {
+		t.Errorf("Expected denial in the request's apiVersion, got %+v", review)
+	}
+}
+
+func TestPinPolicy(t *testing.T) {
+	source := policy.NewSource(policy.Config{ProtectedNamespaces: []string{"kube-system"}})
+	evaluate := PolicyEvaluator(source, false)
+	handler := NewHandler(Options{
+		// Replaces the policy while the request is in flight
+		Evaluate: func(ctx context.Context, sar policy.SubjectAccessReview) authorizationv1.SubjectAccessReviewStatus {
+			source.Set(policy.Config{})
+			return evaluate(ctx, sar)
+		},
+		Middleware: []Middleware{PinPolicy(source)},
+	})
+	_, review := serve(t, handler, `{"apiVersion":"authorization.k8s.io/v1","kind":"SubjectAccessReview","spec":{"user":"alice","resourceAttributes":{"namespace":"kube-system","verb":"create","resource":"pods"}}}`)
+	if !review.Status.Denied {
+		t.Errorf("Expected request to be evaluated with the policy in effect when it arrived, got %+v", review.Status)
+	}
+}
+
+func TestRecoverWith(t *testing.T) {
+	var recovered any
+	handler := NewHandler(Options{
+		Evaluate:           PolicyEvaluator(policy.NewSource(policy.Config{}), false),
+		PanicFailurePolicy: FailDeny,
+		Panicked:           func(_ *http.Request, err any) { recovered = err },
+		Middleware: []Middleware{func(http.Handler) http.Handler {
+			return http.HandlerFunc(func(http.ResponseWriter, *http.Request) { panic("broken") })
+		}},
+	})
+	resp, review := serve(t, handler, `{"apiVersion":"authorization.k8s.io/v1beta1","kind":"SubjectAccessReview","metadata":{"uid":"0a1b2c"},"spec":{"user":"alice","resourceAttributes":{"verb":"get","resource":"pods"}}}`)
+	if resp.Code != http.StatusOK || !review.Status.Denied || review.Status.EvaluationError != "internal error: broken" {
+		t.Errorf("Expected panic to be answered with a denial, got %d %+v", resp.Code, review.Status)
+	}
+	if review.ApiVersion != "authorization.k8s.io/v1beta1" || review.Metadata == nil || review.Metadata.UID != "0a1b2c" || recovered != "broken" {
+		t.Errorf("Expected response to the request that panicked, got %+v", review)
+	}
+
+	written := Chain(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		panic("broken")
+	}), RecoverWith(FailDeny, nil))
+	defer func() {
+		if err := recover(); err != http.ErrAbortHandler {
+			t.Errorf("Expected panic after the response started to abort it, got %v", err)
+		}
+	}()
+	written.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/authorize", nil))
+}
@@ -0,0 +1,220 @@
+// Package server serves SubjectAccessReview requests from kube-apiserver, so other Azimuth components
+// can embed the webhook's decision engine behind their own listeners
+package server
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/policy"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"log"
+	"net/http"
+	"runtime/debug"
+)
+
+// Minimal SubjectAccessReview HTTP response
+type SubjectAccessReviewResponse struct {
+	ApiVersion string                                    `json:"apiVersion"`
+	Kind       string                                    `json:"kind"`
+	Metadata   *ResponseMetadata                         `json:"metadata,omitempty"`
+	Status     authorizationv1.SubjectAccessReviewStatus `json:"status"`
+}
+
+// Metadata echoed from the request, so clients can match responses to requests
+type ResponseMetadata struct {
+	UID types.UID `json:"uid"`
+}
+
+// Returns response with status to a request of the given type and UID. Responses have the apiVersion and
+// kind the request was sent with, as some apiservers discard others, defaulting to a v1 SubjectAccessReview
+func NewResponse(request metav1.TypeMeta, uid types.UID, status authorizationv1.SubjectAccessReviewStatus) SubjectAccessReviewResponse {
+	response := SubjectAccessReviewResponse{
+		ApiVersion: cmp.Or(request.APIVersion, "authorization.k8s.io/v1"),
+		Kind:       cmp.Or(request.Kind, "SubjectAccessReview"),
+		Status:     status,
+	}
+	if uid != "" {
+		response.Metadata = &ResponseMetadata{UID: uid}
+	}
+	return response
+}
+
+// Makes the decision for a normalised SubjectAccessReview
+type Evaluator func(ctx context.Context, sar policy.SubjectAccessReview) authorizationv1.SubjectAccessReviewStatus
+
+// Returns evaluator deciding requests with the policy in effect in source, or pinned by PinPolicy, and
+// nothing else
+func PolicyEvaluator(source *policy.Source, opinionMode bool) Evaluator {
+	return func(ctx context.Context, sar policy.SubjectAccessReview) authorizationv1.SubjectAccessReviewStatus {
+		return policy.Decide(sar, source.Snapshot(ctx), opinionMode)
+	}
+}
+
+// Decision for requests whose evaluation fails without a verdict
+type FailurePolicy string
+
+const (
+	// Left to other authorizers, as if the webhook were unreachable
+	FailNoOpinion FailurePolicy = "no-opinion"
+	// Denied, so failures can't leave access to authorizers the policy would have overruled
+	FailDeny FailurePolicy = "deny"
+)
+
+// Returns evaluator reporting internal failures of evaluate, including panics, as the evaluationError of
+// a well-formed status rather than failing the request. Failures without a verdict are decided by
+// failurePolicy. If not nil, panicked is called with the value of each panic
+func WithFailurePolicy(evaluate Evaluator, failurePolicy FailurePolicy, panicked func(err any)) Evaluator {
+	return func(ctx context.Context, sar policy.SubjectAccessReview) (status authorizationv1.SubjectAccessReviewStatus) {
+		defer func() {
+			if err := recover(); err != nil {
+				log.Printf("Panic evaluating SubjectAccessReview: %v\n%s", err, debug.Stack())
+				if panicked != nil {
+					panicked(err)
+				}
+				status = authorizationv1.SubjectAccessReviewStatus{EvaluationError: fmt.Sprintf("internal error: %v", err)}
+			}
+			status = failurePolicy.apply(status)
+		}()
+		return evaluate(ctx, sar)
+	}
+}
+
+// Returns status decided by the failure policy if evaluation failed without a verdict, otherwise unchanged
+func (failurePolicy FailurePolicy) apply(status authorizationv1.SubjectAccessReviewStatus) authorizationv1.SubjectAccessReviewStatus {
+	if status.EvaluationError != "" && !status.Allowed && !status.Denied && failurePolicy == FailDeny {
+		status.Denied = true
+		status.Reason = cmp.Or(status.Reason, "Evaluation failed")
+	}
+	return status
+}
+
+// Response to requests which can't be decoded or evaluated
+type MalformedRequestPolicy string
+
+const (
+	// Rejected with HTTP 400, which kube-apiserver treats as the webhook failing, so its failurePolicy applies
+	RejectMalformed MalformedRequestPolicy = "reject"
+	// Answered with a denied SubjectAccessReview, failing closed whatever kube-apiserver's failurePolicy
+	DenyMalformed MalformedRequestPolicy = "deny"
+)
+
+// Settings for the SubjectAccessReview request handler
+type Options struct {
+	Evaluate Evaluator
+	// Response to requests which can't be evaluated, rejected if empty
+	MalformedRequests MalformedRequestPolicy
+	// Optional, called with the error for each request which can't be evaluated
+	Rejected func(r *http.Request, err error)
+	// Caps on the size of requests, which are rejected as malformed if they exceed them
+	Limits policy.Limits
+	// apiVersions of requests accepted, policy.DefaultAPIVersions if empty. Others are rejected as malformed
+	APIVersions []string
+	// Optional, called with each limit for which a request's groups were truncated
+	Truncated func(r *http.Request, limit string)
+	// Optional, called with each decision before the response is written
+	Decided func(r *http.Request, sar policy.SubjectAccessReview, status authorizationv1.SubjectAccessReviewStatus)
+	// Optional, returns the status to answer with in place of the decision passed to Decided, e.g. no opinion
+	// for requests which are only simulations
+	Answer func(r *http.Request, status authorizationv1.SubjectAccessReviewStatus) authorizationv1.SubjectAccessReviewStatus
+	// Optional, called with the context's error for each request abandoned because the caller disconnected or
+	// timed out. Abandoned requests aren't passed to Decided or answered
+	Cancelled func(r *http.Request, err error)
+	// Decision in the response to requests whose handling panics, no opinion if empty
+	PanicFailurePolicy FailurePolicy
+	// Optional, called with the value of each panic while handling a request
+	Panicked func(r *http.Request, err any)
+	// Optional, applied in order after the request is decoded and before it is evaluated, so can read it
+	// with RequestSubjectAccessReview
+	Middleware []Middleware
+}
+
+// Returns HTTP request handler to handle SubjectAccessReview API requests
+func NewHandler(options Options) http.HandlerFunc {
+	evaluate := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sar, request, _ := RequestSubjectAccessReview(r.Context())
+		if abandoned(r, options.Cancelled) {
+			return
+		}
+		status := options.Evaluate(r.Context(), sar)
+		if abandoned(r, options.Cancelled) {
+			return
+		}
+		if options.Decided != nil {
+			options.Decided(r, sar, status)
+		}
+		if options.Answer != nil {
+			status = options.Answer(r, status)
+		}
+		WriteResponse(w, NewResponse(request, sar.UID, status))
+	})
+	// Bodies cut short by callers giving up aren't malformed
+	rejected := func(r *http.Request, err error) {
+		if !abandoned(r, options.Cancelled) && options.Rejected != nil {
+			options.Rejected(r, err)
+		}
+	}
+	decodeOptions := options
+	decodeOptions.Rejected = rejected
+	middleware := append([]Middleware{RecoverWith(options.PanicFailurePolicy, options.Panicked), decode(decodeOptions)}, options.Middleware...)
+	return Chain(evaluate, middleware...).ServeHTTP
+}
+
+// Returns true, calling cancelled if not nil, if the caller has given up on r, so nothing more needs doing
+func abandoned(r *http.Request, cancelled func(r *http.Request, err error)) bool {
+	err := r.Context().Err()
+	if err != nil && cancelled != nil {
+		cancelled(r, err)
+	}
+	return err != nil
+}
+
+// Reads a SubjectAccessReview, or a variant of one, from the request body and normalises it into a v1
+// SubjectAccessReview, returning it with the apiVersion and kind it was sent with. Responds with an error
+// and returns false if it can't be evaluated
+func DecodeRequest(w http.ResponseWriter, r *http.Request) (policy.SubjectAccessReview, metav1.TypeMeta, bool) {
+	sar, request, _, err := readRequest(r, policy.Limits{}, nil)
+	if err != nil {
+		log.Println(err)
+		WriteError(w, http.StatusBadRequest, err)
+		return sar, metav1.TypeMeta{}, false
+	}
+	return sar, request, true
+}
+
+// Returns the normalised SubjectAccessReview in the request body and the apiVersion and kind it was sent
+// with, the limits for which it was truncated, and error describing why it can't be evaluated if it can't.
+// The apiVersion and kind are returned with errors too, as far as they could be decoded, so the request can
+// still be answered
+func readRequest(r *http.Request, limits policy.Limits, apiVersions []string) (policy.SubjectAccessReview, metav1.TypeMeta, []string, error) {
+	defer r.Body.Close()
+	var sar policy.SubjectAccessReview
+	if err := DecodeJSON(r.Body, &sar, CompatibleSubjectAccessReviewFields); err != nil {
+		return sar, sar.TypeMeta, nil, fmt.Errorf("JSON decoding error: %w", err)
+	}
+
+	// Responses must have the version and kind of the request, which normalisation converts
+	request := sar.TypeMeta
+	// Checked after normalisation, which may add groups and extras from headers
+	var truncated []string
+	err := policy.AcceptAPIVersion(&sar, apiVersions)
+	if err == nil {
+		err = policy.Normalize(&sar, r.Header)
+	}
+	if err == nil {
+		truncated, err = limits.Enforce(&sar.Spec)
+	}
+	if err == nil {
+		err = policy.Validate(sar)
+	}
+	return sar, request, truncated, err
+}
+
+// Writes SubjectAccessReview response
+func WriteResponse(w http.ResponseWriter, response SubjectAccessReviewResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
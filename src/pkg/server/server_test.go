@@ -0,0 +1,144 @@
+package server
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/policy"
+	"context"
+	"encoding/json"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func serve(t *testing.T, handler http.Handler, body string) (*httptest.ResponseRecorder, SubjectAccessReviewResponse) {
+	t.Helper()
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/authorize", strings.NewReader(body)))
+	var review SubjectAccessReviewResponse
+	if resp.Code == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(&review); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp, review
+}
+
+func TestHandlerEvaluatesPolicy(t *testing.T) {
+	source := policy.NewSource(policy.Config{ProtectedNamespaces: []string{"kube-system"}})
+	decided := 0
+	handler := NewHandler(Options{
+		Evaluate: PolicyEvaluator(source, false),
+		Decided:  func(*http.Request, policy.SubjectAccessReview, authorizationv1.SubjectAccessReviewStatus) { decided++ },
+	})
+
+	_, review := serve(t, handler, `{
+		"apiVersion":"authorization.k8s.io/v1beta1",
+		"kind":"SubjectAccessReview",
+		"spec":{"user":"alice","group":["staff"],"resourceAttributes":{"namespace":"kube-system","verb":"get","resource":"secrets"}}
+	}`)
+	if !review.Status.Denied || review.ApiVersion != "authorization.k8s.io/v1beta1" {
+		t.Errorf("Expected v1beta1 denial, got %+v", review)
+	}
+	_, review = serve(t, handler, `{
+		"apiVersion":"authorization.k8s.io/v1",
+		"kind":"SubjectAccessReview",
+		"spec":{"user":"alice","resourceAttributes":{"namespace":"default","verb":"get","resource":"secrets"}}
+	}`)
+	if review.Status.Denied || review.Status.Allowed {
+		t.Errorf("Expected no opinion, got %+v", review)
+	}
+	if decided != 2 {
+		t.Errorf("Expected 2 decisions to be reported, got %d", decided)
+	}
+}
+
+func TestHandlerEchoesRequest(t *testing.T) {
+	handler := NewHandler(Options{Evaluate: PolicyEvaluator(policy.NewSource(policy.Config{}), false)})
+	_, review := serve(t, handler, `{
+		"apiVersion":"authorization.k8s.io/v1beta1",
+		"kind":"LocalSubjectAccessReview",
+		"metadata":{"namespace":"default","uid":"1234"},
+		"spec":{"user":"alice","resourceAttributes":{"verb":"get","resource":"pods"}}
+	}`)
+	if review.ApiVersion != "authorization.k8s.io/v1beta1" || review.Kind != "LocalSubjectAccessReview" || review.Metadata == nil || review.Metadata.UID != "1234" {
+		t.Errorf("Expected response to echo request's apiVersion, kind and UID, got %+v", review)
+	}
+	_, review = serve(t, handler, `{
+		"apiVersion":"authorization.k8s.io/v1",
+		"kind":"SubjectAccessReview",
+		"spec":{"user":"alice","nonResourceAttributes":{"verb":"get","path":"/healthz"}}
+	}`)
+	if review.ApiVersion != "authorization.k8s.io/v1" || review.Kind != "SubjectAccessReview" || review.Metadata != nil {
+		t.Errorf("Expected v1 response without metadata, got %+v", review)
+	}
+}
+
+func TestHandlerRejectsMalformedRequests(t *testing.T) {
+	handler := NewHandler(Options{Evaluate: PolicyEvaluator(policy.NewSource(policy.Config{}), false)})
+	for _, body := range []string{
+		`{`,
+		`{"apiVersion":"authorization.k8s.io/v0","kind":"SubjectAccessReview","spec":{"user":"alice"}}`,
+		`{"apiVersion":"authorization.k8s.io/v1","kind":"SubjectAccessReview","spec":{}}`,
+	} {
+		if resp, _ := serve(t, handler, body); resp.Code != http.StatusBadRequest {
+			t.Errorf("Expected %s to be rejected, got %d", body, resp.Code)
+		}
+	}
+}
+
+func TestWithFailurePolicy(t *testing.T) {
+	failing := func(context.Context, policy.SubjectAccessReview) authorizationv1.SubjectAccessReviewStatus {
+		return authorizationv1.SubjectAccessReviewStatus{EvaluationError: "backend timed out"}
+	}
+	panicking := func(context.Context, policy.SubjectAccessReview) authorizationv1.SubjectAccessReviewStatus {
+		panic("broken")
+	}
+	failingInOpinionMode := func(context.Context, policy.SubjectAccessReview) authorizationv1.SubjectAccessReviewStatus {
+		return policy.Decision{EvaluationError: "hook x: timed out"}.Status(true)
+	}
+	tests := []struct {
+		name          string
+		evaluate      Evaluator
+		failurePolicy FailurePolicy
+		denied        bool
+		error         string
+	}{
+		{"no opinion on failure", failing, FailNoOpinion, false, "backend timed out"},
+		{"deny on failure", failing, FailDeny, true, "backend timed out"},
+		{"deny on panic", panicking, FailDeny, true, "internal error: broken"},
+		{"no opinion on panic", panicking, "", false, "internal error: broken"},
+		{"deny on failure in opinion mode", failingInOpinionMode, FailDeny, true, "hook x: timed out"},
+		{"no opinion on failure in opinion mode", failingInOpinionMode, FailNoOpinion, false, "hook x: timed out"},
+	}
+	for _, test := range tests {
+		handler := NewHandler(Options{Evaluate: WithFailurePolicy(test.evaluate, test.failurePolicy, nil)})
+		resp, review := serve(t, handler, `{"apiVersion":"authorization.k8s.io/v1","kind":"SubjectAccessReview","spec":{"user":"alice","nonResourceAttributes":{"verb":"get","path":"/"}}}`)
+		if resp.Code != http.StatusOK || review.Status.Denied != test.denied || review.Status.Allowed || review.Status.EvaluationError != test.error {
+			t.Errorf("%s: expected denied %v with evaluation error %q, got %d %+v", test.name, test.denied, test.error, resp.Code, review.Status)
+		}
+	}
+}
+
+func TestHandlerAbandonsCancelledRequests(t *testing.T) {
+	var cancelled error
+	decided := false
+	handler := NewHandler(Options{
+		Evaluate: func(context.Context, policy.SubjectAccessReview) authorizationv1.SubjectAccessReviewStatus {
+			return authorizationv1.SubjectAccessReviewStatus{Allowed: true}
+		},
+		Decided: func(*http.Request, policy.SubjectAccessReview, authorizationv1.SubjectAccessReviewStatus) {
+			decided = true
+		},
+		Cancelled: func(_ *http.Request, err error) { cancelled = err },
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := httptest.NewRequestWithContext(ctx, http.MethodPost, "/authorize", strings.NewReader(
+		`{"apiVersion":"authorization.k8s.io/v1","kind":"SubjectAccessReview","spec":{"user":"alice","resourceAttributes":{"verb":"get","resource":"pods"}}}`))
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	if cancelled != context.Canceled || decided || resp.Body.Len() != 0 {
+		t.Errorf("Expected cancelled request to be abandoned unanswered, got %v, decided=%v, %q", cancelled, decided, resp.Body)
+	}
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/policy"
+	"encoding/json"
+	"errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"net/http"
+	"strings"
+)
+
+var statusReasons = map[int]metav1.StatusReason{
+	http.StatusBadRequest:            metav1.StatusReasonBadRequest,
+	http.StatusUnauthorized:          metav1.StatusReasonUnauthorized,
+	http.StatusNotFound:              metav1.StatusReasonNotFound,
+	http.StatusMethodNotAllowed:      metav1.StatusReasonMethodNotAllowed,
+	http.StatusRequestEntityTooLarge: metav1.StatusReasonRequestEntityTooLarge,
+	http.StatusUnsupportedMediaType:  metav1.StatusReasonUnsupportedMediaType,
+	http.StatusInternalServerError:   metav1.StatusReasonInternalError,
+	http.StatusServiceUnavailable:    metav1.StatusReasonServiceUnavailable,
+}
+
+// Returns the metav1.Status kube-apiserver would respond with for err and code. Fields causing
+// DecodeErrors, LimitErrors and UnsupportedAPIVersionErrors are given as causes, so tooling can point at them
+func NewStatus(code int, err error) metav1.Status {
+	status := metav1.Status{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Status"},
+		Status:   metav1.StatusFailure,
+		Message:  err.Error(),
+		Reason:   statusReasons[code],
+		Code:     int32(code),
+	}
+	var cause *metav1.StatusCause
+	var decodeErr *DecodeError
+	var limitErr *policy.LimitError
+	var versionErr *policy.UnsupportedAPIVersionError
+	switch {
+	case errors.As(err, &decodeErr) && decodeErr.Field != "":
+		cause = &metav1.StatusCause{Type: metav1.CauseTypeFieldValueInvalid, Message: decodeErr.Message, Field: decodeErr.Field}
+		if strings.HasPrefix(decodeErr.Message, "expected ") {
+			cause.Type = metav1.CauseTypeTypeInvalid
+		}
+	case errors.As(err, &limitErr):
+		cause = &metav1.StatusCause{Type: metav1.CauseTypeTooMany, Message: limitErr.Error(), Field: limitErr.Field}
+		if limitErr.Limit == policy.LimitFieldLength {
+			cause.Type = metav1.CauseTypeTooLong
+		}
+	case errors.As(err, &versionErr):
+		cause = &metav1.StatusCause{Type: metav1.CauseTypeFieldValueNotSupported, Message: versionErr.Error(), Field: "apiVersion"}
+	}
+	if cause != nil {
+		status.Details = &metav1.StatusDetails{Causes: []metav1.StatusCause{*cause}}
+	}
+	return status
+}
+
+// Responds with err as a metav1.Status, which kube-apiserver and client tooling can parse, rather than
+// as plain text
+func WriteError(w http.ResponseWriter, code int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(NewStatus(code, err))
+}
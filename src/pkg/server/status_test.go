@@ -0,0 +1,50 @@
+package server
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/policy"
+	"encoding/json"
+	"errors"
+	"fmt"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWriteError(t *testing.T) {
+	tests := []struct {
+		code   int
+		err    error
+		reason metav1.StatusReason
+		cause  metav1.StatusCause
+	}{
+		{http.StatusMethodNotAllowed, errors.New("Method not allowed"), metav1.StatusReasonMethodNotAllowed, metav1.StatusCause{}},
+		{http.StatusBadRequest, fmt.Errorf("JSON decoding error: %w", &DecodeError{Field: "spec.user", Offset: 9, Message: "expected string, got number"}),
+			metav1.StatusReasonBadRequest, metav1.StatusCause{Type: metav1.CauseTypeTypeInvalid, Message: "expected string, got number", Field: "spec.user"}},
+		{http.StatusBadRequest, &DecodeError{Field: "specs", Message: "unknown field"},
+			metav1.StatusReasonBadRequest, metav1.StatusCause{Type: metav1.CauseTypeFieldValueInvalid, Message: "unknown field", Field: "specs"}},
+		{http.StatusBadRequest, &policy.LimitError{Limit: policy.LimitGroups, Field: "spec.groups", Max: 2},
+			metav1.StatusReasonBadRequest, metav1.StatusCause{Type: metav1.CauseTypeTooMany, Message: "SubjectAccessReview spec.groups has more than 2 entries", Field: "spec.groups"}},
+		{http.StatusInternalServerError, errors.New("Internal server error"), metav1.StatusReasonInternalError, metav1.StatusCause{}},
+	}
+	for _, test := range tests {
+		resp := httptest.NewRecorder()
+		WriteError(resp, test.code, test.err)
+
+		var status metav1.Status
+		if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+			t.Fatal(err)
+		}
+		if resp.Code != test.code || resp.Header().Get("Content-Type") != "application/json" || status.Kind != "Status" || status.APIVersion != "v1" ||
+			status.Status != metav1.StatusFailure || status.Code != int32(test.code) || status.Reason != test.reason || status.Message != test.err.Error() {
+			t.Errorf("%v: expected %d %s status, got %d %+v", test.err, test.code, test.reason, resp.Code, status)
+		}
+		if test.cause == (metav1.StatusCause{}) {
+			if status.Details != nil {
+				t.Errorf("%v: expected no details, got %+v", test.err, status.Details)
+			}
+		} else if status.Details == nil || len(status.Details.Causes) != 1 || status.Details.Causes[0] != test.cause {
+			t.Errorf("%v: expected cause %+v, got %+v", test.err, test.cause, status.Details)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"archive/tar"
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/config"
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/policy"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// Files of a policy archive besides its documents: the manifest and the raw Ed25519 signature of its bytes
+const (
+	policyManifestFile  = "manifest.json"
+	policySignatureFile = "manifest.sig"
+)
+
+// Documents a policy archive may hold. The policy document is required
+const (
+	// Policy file, in the format of --policy-file, replacing the whole policy
+	policyDocument = "policy.yaml"
+	// Name rules, in the format of --name-rules-file, added after those of the policy document
+	nameRulesDocument = "name-rules.yaml"
+)
+
+// Largest policy archive read, once decompressed
+const maxPolicyArchiveSize = 16 << 20
+
+// Signed manifest of a policy archive, a tar file, optionally gzip compressed, distributing a policy as several
+// documents. Signing the digests of every document means none can be altered, added or removed unnoticed
+type policyManifest struct {
+	// Increases with every published policy, so an older archive can't be replayed
+	Version int64 `json:"version"`
+	// Hex encoded SHA-256 digests of the documents, by file name
+	Documents map[string]string `json:"documents"`
+}
+
+// Returns true if data is a policy archive rather than a signed JSON bundle
+func isPolicyArchive(data []byte) bool {
+	gzipped := len(data) >= 2 && data[0] == 0x1f && data[1] == 0x8b
+	return gzipped || (len(data) >= 262 && string(data[257:262]) == "ustar")
+}
+
+// Returns the regular files of the archive in data by name, rejecting anything else which could be
+// mistaken for a document, such as links or repeated names
+func readPolicyArchive(data []byte) (map[string][]byte, error) {
+	var reader io.Reader = bytes.NewReader(data)
+	if data[0] == 0x1f {
+		gz, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		reader = gz
+	}
+	reader = io.LimitReader(reader, maxPolicyArchiveSize)
+	archive := tar.NewReader(reader)
+	files := map[string][]byte{}
+	for {
+		header, err := archive.Next()
+		if errors.Is(err, io.EOF) {
+			return files, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if header.Typeflag == tar.TypeDir {
+			continue
+		}
+		name := path.Clean(strings.TrimPrefix(header.Name, "./"))
+		if header.Typeflag != tar.TypeReg {
+			return nil, fmt.Errorf("%s isn't a regular file", name)
+		}
+		if _, ok := files[name]; ok {
+			return nil, fmt.Errorf("%s appears more than once", name)
+		}
+		if files[name], err = io.ReadAll(archive); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Returns the policy given by the archive in data and its version if its manifest is validly signed, no
+// older than the policy in effect and lists exactly the documents the archive holds, with their digests
+func (p *PolicySync) verifyArchive(data []byte) (int64, policy.Config, error) {
+	files, err := readPolicyArchive(data)
+	if err != nil {
+		return 0, policy.Config{}, fmt.Errorf("malformed policy archive: %w", err)
+	}
+	manifestData, ok := files[policyManifestFile]
+	if !ok {
+		return 0, policy.Config{}, fmt.Errorf("policy archive has no %s", policyManifestFile)
+	}
+	if !p.trusted(manifestData, files[policySignatureFile]) {
+		return 0, policy.Config{}, fmt.Errorf("policy archive signature is invalid")
+	}
+	var manifest policyManifest
+	if err := json.Unmarshal(manifestData, &manifest); err != nil {
+		return 0, policy.Config{}, fmt.Errorf("malformed policy archive manifest: %w", err)
+	}
+	if err := p.checkVersion(manifest.Version); err != nil {
+		return 0, policy.Config{}, err
+	}
+	delete(files, policyManifestFile)
+	delete(files, policySignatureFile)
+	for name := range files {
+		if _, ok := manifest.Documents[name]; !ok {
+			return 0, policy.Config{}, fmt.Errorf("policy archive document %s isn't in the signed manifest", name)
+		}
+	}
+	for name, digest := range manifest.Documents {
+		if name != policyDocument && name != nameRulesDocument {
+			return 0, policy.Config{}, fmt.Errorf("unknown policy archive document %s", name)
+		}
+		document, ok := files[name]
+		if !ok {
+			return 0, policy.Config{}, fmt.Errorf("policy archive document %s is missing", name)
+		}
+		if sum := sha256.Sum256(document); hex.EncodeToString(sum[:]) != strings.ToLower(digest) {
+			return 0, policy.Config{}, fmt.Errorf("digest of policy archive document %s doesn't match the signed manifest", name)
+		}
+	}
+
+	if _, ok := files[policyDocument]; !ok {
+		return 0, policy.Config{}, fmt.Errorf("policy archive has no %s", policyDocument)
+	}
+	policyFile, err := config.ParsePolicyFile(files[policyDocument])
+	if err != nil {
+		return 0, policy.Config{}, fmt.Errorf("parsing %s: %w", policyDocument, err)
+	}
+	policyConfig := policyFile.PolicyConfig()
+	if document, ok := files[nameRulesDocument]; ok {
+		rules, err := config.ParseNameRules(document)
+		if err != nil {
+			return 0, policy.Config{}, fmt.Errorf("parsing %s: %w", nameRulesDocument, err)
+		}
+		policyConfig.NameRules = append(policyConfig.NameRules, rules...)
+	}
+	// Settings of the webhook rather than the policy aren't distributed
+	policyConfig.ClassificationCacheSize = p.base.ClassificationCacheSize
+	return manifest.Version, policyConfig, nil
+}
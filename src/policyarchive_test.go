@@ -0,0 +1,133 @@
+package main
+
+import (
+	"archive/tar"
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/policy"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+)
+
+// Returns a gzip compressed policy archive of documents, listing those in manifest as signed with key, and
+// any in extra unlisted
+func buildPolicyArchive(t *testing.T, key ed25519.PrivateKey, version int64, documents map[string]string, extra map[string]string) []byte {
+	manifest := policyManifest{Version: version, Documents: map[string]string{}}
+	for name, document := range documents {
+		sum := sha256.Sum256([]byte(document))
+		manifest.Documents[name] = hex.EncodeToString(sum[:])
+	}
+	manifestData, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{policyManifestFile: string(manifestData), policySignatureFile: string(ed25519.Sign(key, manifestData))}
+	for name, document := range documents {
+		files[name] = document
+	}
+	for name, document := range extra {
+		files[name] = document
+	}
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	archive := tar.NewWriter(gz)
+	for name, content := range files {
+		if err := archive.WriteHeader(&tar.Header{Name: "./" + name, Mode: 0o644, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		archive.Write([]byte(content))
+	}
+	archive.Close()
+	gz.Close()
+	return buf.Bytes()
+}
+
+func TestPolicySyncAppliesArchives(t *testing.T) {
+	oldKey, oldPrivateKey, _ := ed25519.GenerateKey(rand.Reader)
+	newKey, newPrivateKey, _ := ed25519.GenerateKey(rand.Reader)
+	service := &testPolicyService{}
+	server := httptest.NewServer(service)
+	defer server.Close()
+	serve := func(archive []byte, etag string) {
+		service.mu.Lock()
+		defer service.mu.Unlock()
+		service.bundle = archive
+		service.etag = etag
+	}
+
+	source := policy.NewSource(DefaultPolicyConfig)
+	policySync := NewPolicySync(PolicySyncOptions{
+		URL:         server.URL,
+		BearerToken: "sync-token",
+		PublicKeys:  []ed25519.PublicKey{oldKey, newKey},
+	}, NewOutboundClient(DefaultOutboundClientOptions), source, DefaultPolicyConfig)
+	handler := CreateAccessCheckHandler(WebhookConfig{Config: DefaultPolicyConfig, Policy: source})
+
+	documents := map[string]string{
+		policyDocument:    "protectedNamespaces: [kube-system, az-demo]\nadditionalPrivilegedUsers: [admin]\nallowOpinionMode: false\n",
+		nameRulesDocument: "- name: cloud-credentials\n  effect: deny\n  resource: secrets\n  resourceNames: [cloud-credentials]\n  namespaces: [tenant-acme]\n",
+	}
+	serve(buildPolicyArchive(t, oldPrivateKey, 1, documents, nil), `"v1"`)
+	if err := policySync.Sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, response := accessCheckTest(t, handler, `{"subject":{"user":"not-admin"},"action":"delete","resource":{"namespace":"az-demo","type":"secrets"}}`); response.Decision != "denied" {
+		t.Errorf("Expected denial once az-demo is protected by the archive, got %+v", response)
+	}
+	if _, response := accessCheckTest(t, handler, `{"subject":{"user":"not-admin"},"action":"get","resource":{"namespace":"tenant-acme","type":"secrets","name":"cloud-credentials"}}`); response.Decision != "denied" {
+		t.Errorf("Expected name rule from the archive to deny, got %+v", response)
+	}
+
+	// Signed with the new key after rotation
+	documents[policyDocument] = "protectedNamespaces: [kube-system]\nadditionalPrivilegedUsers: []\nallowOpinionMode: false\n"
+	serve(buildPolicyArchive(t, newPrivateKey, 2, documents, nil), `"v2"`)
+	if err := policySync.Sync(context.Background()); err != nil || policySync.version.Load() != 2 {
+		t.Fatalf("Expected archive signed with the new key to be applied, got version %d: %v", policySync.version.Load(), err)
+	}
+
+	_, untrustedKey, _ := ed25519.GenerateKey(rand.Reader)
+	truncated := buildPolicyArchive(t, newPrivateKey, 3, documents, nil)
+	cases := []struct {
+		name    string
+		archive []byte
+	}{
+		{"untrusted key", buildPolicyArchive(t, untrustedKey, 3, documents, nil)},
+		{"older version", buildPolicyArchive(t, newPrivateKey, 1, documents, nil)},
+		{"unsigned document", buildPolicyArchive(t, newPrivateKey, 3, documents, map[string]string{"overlays.yaml": "[]"})},
+		{"unknown document", buildPolicyArchive(t, newPrivateKey, 3, map[string]string{policyDocument: documents[policyDocument], "overlays.yaml": "[]"}, nil)},
+		{"no policy document", buildPolicyArchive(t, newPrivateKey, 3, map[string]string{nameRulesDocument: documents[nameRulesDocument]}, nil)},
+		{"invalid policy document", buildPolicyArchive(t, newPrivateKey, 3, map[string]string{policyDocument: "protectedNamespaces: kube-system\n"}, nil)},
+		{"truncated", truncated[:len(truncated)/2]},
+	}
+	for i, c := range cases {
+		serve(c.archive, fmt.Sprintf(`"rejected-%d"`, i))
+		if err := policySync.Sync(context.Background()); err == nil {
+			t.Errorf("%s: expected archive to be rejected", c.name)
+		}
+	}
+	if policySync.version.Load() != 2 {
+		t.Errorf("Expected version 2 to stay in effect, got %d", policySync.version.Load())
+	}
+
+	// A document altered after signing doesn't match its digest
+	manifest := policyManifest{Version: 3, Documents: map[string]string{policyDocument: hex.EncodeToString(make([]byte, sha256.Size))}}
+	manifestData, _ := json.Marshal(manifest)
+	var buf bytes.Buffer
+	archive := tar.NewWriter(&buf)
+	for name, content := range map[string][]byte{policyManifestFile: manifestData, policySignatureFile: ed25519.Sign(newPrivateKey, manifestData), policyDocument: []byte(documents[policyDocument])} {
+		archive.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(content)), Typeflag: tar.TypeReg})
+		archive.Write(content)
+	}
+	archive.Close()
+	serve(buf.Bytes(), `"altered"`)
+	if err := policySync.Sync(context.Background()); err == nil {
+		t.Error("Expected uncompressed archive with an altered document to be rejected")
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Media types of the manifests policy bundles may be pushed as, e.g. by oras push
+const (
+	ociManifestMediaType       = "application/vnd.oci.image.manifest.v1+json"
+	dockerManifestMediaType    = "application/vnd.docker.distribution.manifest.v2+json"
+	ociManifestAcceptMediaType = ociManifestMediaType + ", " + dockerManifestMediaType
+)
+
+var (
+	ociRepositoryPattern = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*(?:/[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*)*$`)
+	ociTagPattern        = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9._-]{0,127}$`)
+	ociDigestPattern     = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+)
+
+// Policy bundle in an OCI registry, as REGISTRY/REPOSITORY:TAG or REGISTRY/REPOSITORY@sha256:DIGEST, so
+// bundles can be promoted between environments as images are. Registries are reached over HTTPS unless
+// the reference starts with http://
+type OCIReference struct {
+	// http or https
+	Scheme     string
+	Registry   string
+	Repository string
+	Tag        string
+	// Pins the manifest, which is then never refreshed, if not empty
+	Digest string
+}
+
+func ParseOCIReference(reference string) (*OCIReference, error) {
+	ref := &OCIReference{Scheme: "https"}
+	rest := reference
+	if after, ok := strings.CutPrefix(rest, "http://"); ok {
+		ref.Scheme, rest = "http", after
+	} else {
+		rest = strings.TrimPrefix(rest, "https://")
+	}
+	registry, rest, ok := strings.Cut(rest, "/")
+	if !ok || registry == "" {
+		return nil, fmt.Errorf("OCI reference %q has no registry", reference)
+	}
+	ref.Registry = registry
+	if repository, digest, ok := strings.Cut(rest, "@"); ok {
+		if !ociDigestPattern.MatchString(digest) {
+			return nil, fmt.Errorf("OCI reference %q has an invalid digest, expected sha256:HEX", reference)
+		}
+		rest, ref.Digest = repository, digest
+	}
+	if i := strings.LastIndex(rest, ":"); i >= 0 {
+		rest, ref.Tag = rest[:i], rest[i+1:]
+		if !ociTagPattern.MatchString(ref.Tag) {
+			return nil, fmt.Errorf("OCI reference %q has an invalid tag", reference)
+		}
+	}
+	if !ociRepositoryPattern.MatchString(rest) {
+		return nil, fmt.Errorf("OCI reference %q has an invalid repository", reference)
+	}
+	ref.Repository = rest
+	if ref.Tag == "" && ref.Digest == "" {
+		return nil, fmt.Errorf("OCI reference %q needs a tag or digest", reference)
+	}
+	return ref, nil
+}
+
+func (r *OCIReference) String() string {
+	s := r.Registry + "/" + r.Repository
+	if r.Tag != "" {
+		s += ":" + r.Tag
+	}
+	if r.Digest != "" {
+		s += "@" + r.Digest
+	}
+	return s
+}
+
+// Returns the URL of the manifest or blob named by reference in the repository
+func (r *OCIReference) url(kind string, reference string) string {
+	return r.Scheme + "://" + r.Registry + "/v2/" + r.Repository + "/" + kind + "/" + reference
+}
+
+type ociDescriptor struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+}
+
+type ociManifest struct {
+	MediaType string          `json:"mediaType"`
+	Layers    []ociDescriptor `json:"layers"`
+}
+
+// Pulls the bundle, the single layer of the manifest, returning the manifest digest as its ETag, or no data if
+// the manifest is unchanged. Manifests and layers are checked against their digests, so neither the
+// registry nor the network can alter them unnoticed; the bundle's signature is verified as for the URL
+func (p *PolicySync) fetchOCI(ctx context.Context) ([]byte, string, error) {
+	ref := p.options.OCI
+	if ref.Digest != "" && p.etag == ref.Digest {
+		return nil, p.etag, nil
+	}
+	manifestReference := ref.Digest
+	if manifestReference == "" {
+		manifestReference = ref.Tag
+	}
+	data, err := p.pullOCI(ctx, ref.url("manifests", manifestReference), ociManifestAcceptMediaType, maxPolicyBundleSize)
+	if err != nil {
+		return nil, "", err
+	}
+	sum := sha256.Sum256(data)
+	digest := "sha256:" + hex.EncodeToString(sum[:])
+	if ref.Digest != "" && digest != ref.Digest {
+		return nil, "", fmt.Errorf("manifest of %s has digest %s", ref, digest)
+	}
+	if digest == p.etag {
+		return nil, p.etag, nil
+	}
+	var manifest ociManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, "", fmt.Errorf("malformed manifest of %s: %w", ref, err)
+	}
+	if manifest.MediaType != "" && manifest.MediaType != ociManifestMediaType && manifest.MediaType != dockerManifestMediaType {
+		return nil, "", fmt.Errorf("%s is a %s rather than an image manifest", ref, manifest.MediaType)
+	}
+	if len(manifest.Layers) != 1 {
+		return nil, "", fmt.Errorf("%s has %d layers, expected the policy bundle alone", ref, len(manifest.Layers))
+	}
+	layer := manifest.Layers[0]
+	if !ociDigestPattern.MatchString(layer.Digest) || layer.Size > maxPolicyBundleSize {
+		return nil, "", fmt.Errorf("layer of %s has an invalid digest or is larger than %d bytes", ref, maxPolicyBundleSize)
+	}
+	bundle, err := p.pullOCI(ctx, ref.url("blobs", layer.Digest), "*/*", layer.Size)
+	if err != nil {
+		return nil, "", err
+	}
+	if sum := sha256.Sum256(bundle); "sha256:"+hex.EncodeToString(sum[:]) != layer.Digest {
+		return nil, "", fmt.Errorf("layer of %s doesn't match digest %s", ref, layer.Digest)
+	}
+	log.Printf("Pulled policy bundle from %s with manifest digest %s\n", ref, digest)
+	return bundle, digest, nil
+}
+
+// Fetches at most limit bytes from the registry, answering an authentication challenge once. Tokens are kept
+// until the registry rejects them
+func (p *PolicySync) pullOCI(ctx context.Context, target string, accept string, limit int64) ([]byte, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", accept)
+		if p.registryAuth != "" {
+			req.Header.Set("Authorization", p.registryAuth)
+		}
+		resp, err := p.client.Do("policy-sync", p.options.Timeout, req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode == http.StatusUnauthorized && attempt == 0 {
+			challenge := resp.Header.Get("WWW-Authenticate")
+			resp.Body.Close()
+			if p.registryAuth, err = p.authenticateOCI(ctx, challenge); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("unexpected status from registry: %s", resp.Status)
+		}
+		return io.ReadAll(io.LimitReader(resp.Body, limit))
+	}
+}
+
+// Returns the Authorization header answering the registry's challenge: the credentials for Basic challenges,
+// or a token from the realm for Bearer challenges, requested with the credentials if there are any
+func (p *PolicySync) authenticateOCI(ctx context.Context, challenge string) (string, error) {
+	scheme, params := parseAuthChallenge(challenge)
+	basic := ""
+	if p.options.Username != "" {
+		basic = "Basic " + base64.StdEncoding.EncodeToString([]byte(p.options.Username+":"+p.options.Password))
+	}
+	switch {
+	case strings.EqualFold(scheme, "Basic") && basic != "":
+		return basic, nil
+	case !strings.EqualFold(scheme, "Bearer") || params["realm"] == "":
+		return "", fmt.Errorf("registry requires authentication the webhook can't provide: %q", challenge)
+	}
+	realm, err := url.Parse(params["realm"])
+	if err != nil {
+		return "", fmt.Errorf("invalid registry token realm: %w", err)
+	}
+	query := realm.Query()
+	if params["service"] != "" {
+		query.Set("service", params["service"])
+	}
+	scope := params["scope"]
+	if scope == "" {
+		scope = "repository:" + p.options.OCI.Repository + ":pull"
+	}
+	query.Set("scope", scope)
+	realm.RawQuery = query.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if basic != "" {
+		req.Header.Set("Authorization", basic)
+	} else if p.options.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+p.options.BearerToken)
+	}
+	resp, err := p.client.Do("policy-sync", p.options.Timeout, req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status from registry token service: %s", resp.Status)
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&token); err != nil {
+		return "", fmt.Errorf("malformed registry token: %w", err)
+	}
+	if token.Token == "" {
+		token.Token = token.AccessToken
+	}
+	if token.Token == "" {
+		return "", fmt.Errorf("registry token service returned no token")
+	}
+	return "Bearer " + token.Token, nil
+}
+
+// Splits a WWW-Authenticate challenge into its scheme and parameters, whose quoted values may contain commas,
+// e.g. Bearer realm="https://auth.example.com/token",scope="repository:policy:pull,push"
+func parseAuthChallenge(challenge string) (string, map[string]string) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(challenge), " ")
+	params := map[string]string{}
+	for rest != "" {
+		var key string
+		key, rest, _ = strings.Cut(strings.TrimLeft(rest, " ,"), "=")
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				end = len(rest) - 1
+			}
+			value, rest = rest[1:end+1], rest[min(end+2, len(rest)):]
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+		}
+		if key = strings.ToLower(strings.TrimSpace(key)); key != "" {
+			params[key] = value
+		}
+	}
+	return scheme, params
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/policy"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestParseOCIReference(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("ab", 32)
+	cases := []struct {
+		reference string
+		expected  *OCIReference
+	}{
+		{"ghcr.io/azimuth-cloud/policy:v1", &OCIReference{Scheme: "https", Registry: "ghcr.io", Repository: "azimuth-cloud/policy", Tag: "v1"}},
+		{"http://localhost:5000/policy@" + digest, &OCIReference{Scheme: "http", Registry: "localhost:5000", Repository: "policy", Digest: digest}},
+		{"registry.example.com/policy:prod@" + digest, &OCIReference{Scheme: "https", Registry: "registry.example.com", Repository: "policy", Tag: "prod", Digest: digest}},
+		{"ghcr.io/azimuth-cloud/policy", nil},
+		{"policy:v1", nil},
+		{"ghcr.io/Policy:v1", nil},
+		{"ghcr.io/policy@sha256:short", nil},
+	}
+	for _, c := range cases {
+		ref, err := ParseOCIReference(c.reference)
+		if c.expected == nil {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %+v", c.reference, ref)
+			}
+		} else if err != nil || *ref != *c.expected {
+			t.Errorf("%s: expected %+v, got %+v: %v", c.reference, c.expected, ref, err)
+		}
+	}
+}
+
+// Registry serving one repository, whose tags and blobs can be replaced, behind a token service
+type testRegistry struct {
+	mu        sync.Mutex
+	manifests map[string][]byte
+	blobs     map[string][]byte
+	pulls     int
+}
+
+func (r *testRegistry) push(t *testing.T, tag string, bundle []byte) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	sum := sha256.Sum256(bundle)
+	layer := ociDescriptor{MediaType: "application/vnd.azimuth.policy.bundle.v1+json", Digest: "sha256:" + hex.EncodeToString(sum[:]), Size: int64(len(bundle))}
+	manifest, err := json.Marshal(map[string]any{"schemaVersion": 2, "mediaType": ociManifestMediaType, "layers": []ociDescriptor{layer}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum = sha256.Sum256(manifest)
+	digest := "sha256:" + hex.EncodeToString(sum[:])
+	r.blobs[layer.Digest] = bundle
+	r.manifests[tag] = manifest
+	r.manifests[digest] = manifest
+	return digest
+}
+
+func (r *testRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if req.URL.Path == "/token" {
+		if user, password, _ := req.BasicAuth(); user != "robot" || password != "secret" || req.URL.Query().Get("scope") != "repository:azimuth/policy:pull" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"token": "pull-token"})
+		return
+	}
+	if req.Header.Get("Authorization") != "Bearer pull-token" {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="http://`+req.Host+`/token",service="registry",scope="repository:azimuth/policy:pull"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if reference, ok := strings.CutPrefix(req.URL.Path, "/v2/azimuth/policy/manifests/"); ok && r.manifests[reference] != nil {
+		w.Header().Set("Content-Type", ociManifestMediaType)
+		w.Write(r.manifests[reference])
+	} else if digest, ok := strings.CutPrefix(req.URL.Path, "/v2/azimuth/policy/blobs/"); ok && r.blobs[digest] != nil {
+		r.pulls++
+		w.Write(r.blobs[digest])
+	} else {
+		http.NotFound(w, req)
+	}
+}
+
+func TestPolicySyncPullsFromRegistry(t *testing.T) {
+	publicKey, privateKey, _ := ed25519.GenerateKey(rand.Reader)
+	registry := &testRegistry{manifests: map[string][]byte{}, blobs: map[string][]byte{}}
+	server := httptest.NewServer(registry)
+	defer server.Close()
+	bundle := func(version int64, namespaces ...string) []byte {
+		service := &testPolicyService{}
+		service.publish(t, privateKey, PolicyBundle{Version: version, ProtectedNamespaces: namespaces})
+		return service.bundle
+	}
+	newSync := func(reference string) *PolicySync {
+		ref, err := ParseOCIReference(reference)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return NewPolicySync(PolicySyncOptions{
+			OCI:        ref,
+			Username:   "robot",
+			Password:   "secret",
+			PublicKeys: []ed25519.PublicKey{publicKey},
+		}, NewOutboundClient(DefaultOutboundClientOptions), policy.NewSource(DefaultPolicyConfig), DefaultPolicyConfig)
+	}
+	base := server.URL + "/azimuth/policy"
+
+	first := registry.push(t, "prod", bundle(1, "kube-system"))
+	policySync := newSync(base + ":prod")
+	if err := policySync.Sync(context.Background()); err != nil || policySync.version.Load() != 1 {
+		t.Fatalf("Expected version 1 to be pulled by tag, got %d: %v", policySync.version.Load(), err)
+	}
+	if err := policySync.Sync(context.Background()); err != nil || registry.pulls != 1 {
+		t.Errorf("Expected unchanged manifest not to be pulled again, got %d pulls: %v", registry.pulls, err)
+	}
+
+	// Promoting a new bundle to the tag is picked up on refresh
+	registry.push(t, "prod", bundle(2, "kube-system", "az-demo"))
+	if err := policySync.Sync(context.Background()); err != nil || policySync.version.Load() != 2 {
+		t.Errorf("Expected version 2 to be pulled once promoted, got %d: %v", policySync.version.Load(), err)
+	}
+	if !policySync.source.Current().IsProtectedNamespace("az-demo") {
+		t.Error("Expected pulled bundle to be applied")
+	}
+
+	// Pinned digests ignore later pushes to the tag
+	pinned := newSync(base + "@" + first)
+	if err := pinned.Sync(context.Background()); err != nil || pinned.version.Load() != 1 {
+		t.Errorf("Expected pinned version 1, got %d: %v", pinned.version.Load(), err)
+	}
+	pulls := registry.pulls
+	if err := pinned.Sync(context.Background()); err != nil || registry.pulls != pulls {
+		t.Errorf("Expected pinned manifest not to be refreshed, got %d pulls: %v", registry.pulls-pulls, err)
+	}
+
+	// Blobs which don't match their digest are rejected
+	registry.push(t, "tampered", bundle(3, "kube-system"))
+	registry.mu.Lock()
+	var manifest ociManifest
+	json.Unmarshal(registry.manifests["tampered"], &manifest)
+	registry.blobs[manifest.Layers[0].Digest] = bundle(3)
+	registry.mu.Unlock()
+	if err := newSync(base + ":tampered").Sync(context.Background()); err == nil || !strings.Contains(err.Error(), "doesn't match digest") {
+		t.Errorf("Expected tampered layer to be rejected, got %v", err)
+	}
+	if err := newSync(base + ":missing").Sync(context.Background()); err == nil {
+		t.Error("Expected missing tag to fail")
+	}
+}
+
+func TestParseAuthChallenge(t *testing.T) {
+	scheme, params := parseAuthChallenge(`Bearer realm="https://auth.example.com/token",service="registry.example.com",scope="repository:policy:pull,push"`)
+	if scheme != "Bearer" || params["realm"] != "https://auth.example.com/token" || params["service"] != "registry.example.com" || params["scope"] != "repository:policy:pull,push" {
+		t.Errorf("Unexpected challenge %s %v", scheme, params)
+	}
+	if scheme, params := parseAuthChallenge(`Basic realm=registry`); scheme != "Basic" || params["realm"] != "registry" {
+		t.Errorf("Unexpected challenge %s %v", scheme, params)
+	}
+}
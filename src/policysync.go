@@ -0,0 +1,302 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/policy"
+	"context"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var policySyncs = Metrics.NewCounterVec("azimuth_authz_policy_syncs_total",
+	"Policy bundle fetches from the central policy service, by result", "result")
+
+// Policy distributed by the central Azimuth policy service. Bundles are JSON objects whose payload is
+// the base64 encoded JSON of this struct and whose signature is the base64 encoded Ed25519 signature
+// of the payload bytes
+type PolicyBundle struct {
+	// Increases with every published policy, so an older bundle can't be replayed
+	Version                   int64    `json:"version"`
+	ProtectedNamespaces       []string `json:"protectedNamespaces"`
+	AdditionalPrivilegedUsers []string `json:"additionalPrivilegedUsers"`
+}
+
+// Largest policy bundle fetched
+const maxPolicyBundleSize = 4 << 20
+
+type signedPolicyBundle struct {
+	Payload   []byte `json:"payload"`
+	Signature []byte `json:"signature"`
+}
+
+type PolicySyncOptions struct {
+	URL string
+	// Registry reference to pull the bundle from instead of URL
+	OCI         *OCIReference
+	BearerToken string
+	// Credentials exchanged for a token by registries which require them, anonymous if empty
+	Username string
+	Password string
+	// Keys bundles may be signed with, any one of them sufficing so keys can be rotated
+	PublicKeys []ed25519.PublicKey
+	Interval   time.Duration
+	Timeout    time.Duration
+	// Called with each policy before it is applied, rejecting the bundle if it returns an error
+	Check func(policy.Config) error
+	// Called with each policy applied, e.g. to discard decisions made with the previous one
+	OnUpdate func(policy.Config)
+	// Keeps readiness passing while syncs fail once a policy has been applied, the last-known-good
+	// policy staying in effect and the failure being reported as readiness detail instead
+	FailStatic bool
+}
+
+// Keeps a policy.Source in sync with the central policy service, so fleets of webhooks don't need
+// per-cluster configuration pushes. Unchanged bundles are skipped using ETags, and bundles with an
+// invalid signature or older version are rejected, leaving the current policy in effect
+type PolicySync struct {
+	options PolicySyncOptions
+	client  *OutboundClient
+	source  *policy.Source
+	// Local settings not distributed in bundles
+	base policy.Config
+	etag string
+	// Authorization sent to the registry, from the token or the latest challenge answered
+	registryAuth string
+	version      atomic.Int64
+	health       *health
+	mu           sync.Mutex
+	// Error of the latest sync while it failed after a policy was applied, so that policy is stale
+	stale error
+}
+
+func NewPolicySync(options PolicySyncOptions, client *OutboundClient, source *policy.Source, base policy.Config) *PolicySync {
+	if options.Interval <= 0 {
+		options.Interval = time.Minute
+	}
+	p := &PolicySync{options: options, client: client, source: source, base: base, health: newHealth(true)}
+	if options.BearerToken != "" {
+		p.registryAuth = "Bearer " + options.BearerToken
+	}
+	p.version.Store(-1)
+	Metrics.NewGaugeFunc("azimuth_authz_policy_version", "Version of the policy bundle in effect, -1 before the first sync",
+		func() float64 { return float64(p.version.Load()) })
+	Metrics.NewGaugeFunc("azimuth_authz_policy_stale", "1 while the latest policy sync failed and the last-known-good policy is in effect",
+		func() float64 {
+			if p.staleError() != nil {
+				return 1
+			}
+			return 0
+		})
+	return p
+}
+
+// Fetches the policy every interval until ctx is cancelled
+func (p *PolicySync) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.options.Interval)
+	defer ticker.Stop()
+	for {
+		p.observe(p.Sync(ctx))
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Fetches the bundle from the policy service, returning its ETag, or no data if it is unchanged
+func (p *PolicySync) fetchURL(ctx context.Context) ([]byte, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.options.URL, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("Accept", "application/json")
+	if p.options.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+p.options.BearerToken)
+	}
+	if p.etag != "" {
+		req.Header.Set("If-None-Match", p.etag)
+	}
+	resp, err := p.client.Do("policy-sync", p.options.Timeout, req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		return nil, p.etag, nil
+	case http.StatusOK:
+	default:
+		return nil, "", fmt.Errorf("unexpected status from policy service: %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxPolicyBundleSize))
+	return data, resp.Header.Get("ETag"), err
+}
+
+// Records the outcome of a sync. Failures after a policy was applied leave it in effect as stale
+func (p *PolicySync) observe(err error) {
+	if err == nil {
+		p.setStale(nil)
+		p.health.succeeded()
+		return
+	}
+	log.Println("Error syncing policy:", err)
+	applied := p.version.Load() >= 0
+	if applied {
+		p.setStale(err)
+	}
+	if !applied || !p.options.FailStatic {
+		p.health.failed(err)
+	}
+}
+
+// Fetches the policy bundle once, applying it if it has changed
+func (p *PolicySync) Sync(ctx context.Context) error {
+	fetch := p.fetchURL
+	if p.options.OCI != nil {
+		fetch = p.fetchOCI
+	}
+	data, etag, err := fetch(ctx)
+	if err != nil {
+		policySyncs.Inc("error")
+		return err
+	}
+	if data == nil {
+		policySyncs.Inc("unchanged")
+		return nil
+	}
+	version, config, err := p.verify(data)
+	if err != nil {
+		policySyncs.Inc("rejected")
+		return err
+	}
+	if version == p.version.Load() {
+		p.etag = etag
+		policySyncs.Inc("unchanged")
+		return nil
+	}
+
+	// The whole policy is validated rather than the bundle alone, so a bundle which doesn't combine with
+	// the local settings is never partially applied
+	if err := config.Validate(); err != nil {
+		policySyncs.Inc("rejected")
+		return fmt.Errorf("invalid policy bundle version %d: %w", version, err)
+	}
+	if p.options.Check != nil {
+		if err := p.options.Check(config); err != nil {
+			policySyncs.Inc("rejected")
+			return fmt.Errorf("policy bundle version %d rejected: %w", version, err)
+		}
+	}
+	p.etag = etag
+	p.source.Set(config)
+	p.version.Store(version)
+	if p.options.OnUpdate != nil {
+		p.options.OnUpdate(config)
+	}
+	policySyncs.Inc("updated")
+	log.Printf("Applied policy bundle version %d as generation %d\n", version, p.source.Current().Generation())
+	return nil
+}
+
+// Returns the policy given by the bundle in data and its version if it is validly signed and no older than
+// the policy in effect. Bundles are either signed JSON bundles or policy archives
+func (p *PolicySync) verify(data []byte) (int64, policy.Config, error) {
+	if isPolicyArchive(data) {
+		return p.verifyArchive(data)
+	}
+	var signed signedPolicyBundle
+	if err := json.Unmarshal(data, &signed); err != nil {
+		return 0, policy.Config{}, fmt.Errorf("malformed policy bundle: %w", err)
+	}
+	if !p.trusted(signed.Payload, signed.Signature) {
+		return 0, policy.Config{}, fmt.Errorf("policy bundle signature is invalid")
+	}
+	var bundle PolicyBundle
+	if err := json.Unmarshal(signed.Payload, &bundle); err != nil {
+		return 0, policy.Config{}, fmt.Errorf("malformed policy bundle payload: %w", err)
+	}
+	if err := p.checkVersion(bundle.Version); err != nil {
+		return 0, policy.Config{}, err
+	}
+	config := p.base
+	config.ProtectedNamespaces = bundle.ProtectedNamespaces
+	config.AdditionalPrivilegedUsers = bundle.AdditionalPrivilegedUsers
+	return bundle.Version, config, nil
+}
+
+// Returns true if signature is of message by any of the public keys
+func (p *PolicySync) trusted(message []byte, signature []byte) bool {
+	for _, key := range p.options.PublicKeys {
+		if ed25519.Verify(key, message, signature) {
+			return true
+		}
+	}
+	return false
+}
+
+// Rejects versions older than the policy in effect, so an older bundle can't be replayed
+func (p *PolicySync) checkVersion(version int64) error {
+	if version < p.version.Load() {
+		return fmt.Errorf("policy bundle version %d is older than version %d in effect", version, p.version.Load())
+	}
+	return nil
+}
+
+func (p *PolicySync) setStale(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stale = err
+}
+
+func (p *PolicySync) staleError() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.stale
+}
+
+// Describes the last-known-good policy in effect while syncs fail, for readiness detail
+func (p *PolicySync) staleDetail() string {
+	if err := p.staleError(); err != nil {
+		return fmt.Sprintf("serving last-known-good policy version %d: %s", p.version.Load(), err)
+	}
+	return ""
+}
+
+// Reads one or more PEM encoded PKIX Ed25519 public keys
+func readEd25519PublicKeys(path string) ([]ed25519.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var publicKeys []ed25519.PublicKey
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		publicKey, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("%s contains a key which isn't an Ed25519 public key", path)
+		}
+		publicKeys = append(publicKeys, publicKey)
+	}
+	if len(publicKeys) == 0 {
+		return nil, fmt.Errorf("%s contains no PEM data", path)
+	}
+	return publicKeys, nil
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/policy"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Serves the most recently published bundle, answering conditional requests with 304
+type testPolicyService struct {
+	mu     sync.Mutex
+	bundle []byte
+	etag   string
+}
+
+func (s *testPolicyService) publish(t *testing.T, key ed25519.PrivateKey, bundle PolicyBundle) {
+	payload, err := json.Marshal(bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(signedPolicyBundle{Payload: payload, Signature: ed25519.Sign(key, payload)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bundle = data
+	s.etag = fmt.Sprintf(`"v%d"`, bundle.Version)
+}
+
+func (s *testPolicyService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r.Header.Get("Authorization") != "Bearer sync-token" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Header.Get("If-None-Match") == s.etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("ETag", s.etag)
+	w.Write(s.bundle)
+}
+
+func TestPolicySyncAppliesSignedBundles(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	service := &testPolicyService{}
+	service.publish(t, privateKey, PolicyBundle{Version: 1, ProtectedNamespaces: []string{"kube-system"}})
+	server := httptest.NewServer(service)
+	defer server.Close()
+
+	source := policy.NewSource(DefaultPolicyConfig)
+	updates := 0
+	policySync := NewPolicySync(PolicySyncOptions{
+		URL:         server.URL,
+		BearerToken: "sync-token",
+		PublicKeys:  []ed25519.PublicKey{publicKey},
+		OnUpdate:    func(policy.Config) { updates++ },
+	}, NewOutboundClient(DefaultOutboundClientOptions), source, DefaultPolicyConfig)
+	handler := CreateAccessCheckHandler(WebhookConfig{Config: DefaultPolicyConfig, Policy: source})
+	tenantCheck := `{"subject":{"user":"not-admin"},"action":"delete","resource":{"namespace":"az-demo","type":"secrets"}}`
+
+	if err := policySync.Sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := policySync.Sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if updates != 1 || policySync.version.Load() != 1 {
+		t.Fatalf("Expected a single update to version 1, got %d updates to version %d", updates, policySync.version.Load())
+	}
+	if _, response := accessCheckTest(t, handler, tenantCheck); response.Decision != "no-opinion" {
+		t.Errorf("Expected no opinion before az-demo is protected, got %+v", response)
+	}
+
+	service.publish(t, privateKey, PolicyBundle{Version: 2, ProtectedNamespaces: []string{"kube-system", "az-demo"}})
+	if err := policySync.Sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, response := accessCheckTest(t, handler, tenantCheck); response.Decision != "denied" {
+		t.Errorf("Expected denial once az-demo is protected, got %+v", response)
+	}
+
+	// Neither a replayed older bundle nor one signed with another key replaces the policy in effect
+	service.publish(t, privateKey, PolicyBundle{Version: 1, ProtectedNamespaces: []string{"kube-system"}})
+	if err := policySync.Sync(context.Background()); err == nil {
+		t.Error("Expected older bundle to be rejected")
+	}
+	_, otherKey, _ := ed25519.GenerateKey(rand.Reader)
+	service.publish(t, otherKey, PolicyBundle{Version: 3, ProtectedNamespaces: []string{"kube-system"}})
+	if err := policySync.Sync(context.Background()); err == nil {
+		t.Error("Expected bundle with invalid signature to be rejected")
+	}
+	if _, response := accessCheckTest(t, handler, tenantCheck); response.Decision != "denied" || policySync.version.Load() != 2 {
+		t.Errorf("Expected version 2 to stay in effect, got version %d and %+v", policySync.version.Load(), response)
+	}
+}
+
+func TestPolicySyncFailsStatic(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	service := &testPolicyService{}
+	service.publish(t, privateKey, PolicyBundle{Version: 1, ProtectedNamespaces: []string{"kube-system"}})
+	server := httptest.NewServer(service)
+	defer server.Close()
+
+	source := policy.NewSource(DefaultPolicyConfig)
+	policySync := NewPolicySync(PolicySyncOptions{
+		URL:         server.URL,
+		BearerToken: "sync-token",
+		PublicKeys:  []ed25519.PublicKey{publicKey},
+		Check: func(config policy.Config) error {
+			if len(config.ProtectedNamespaces) == 0 {
+				return fmt.Errorf("no protected namespaces")
+			}
+			return nil
+		},
+		FailStatic: true,
+	}, NewOutboundClient(DefaultOutboundClientOptions), source, DefaultPolicyConfig)
+	readyz := readinessHandler([]readinessCheck{{name: "policy-sync", health: policySync.health, detail: policySync.staleDetail}}, 0)
+	ready := func() (int, string) {
+		resp := httptest.NewRecorder()
+		readyz(resp, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		return resp.Code, resp.Body.String()
+	}
+
+	// Failing before any policy was applied fails readiness, there being nothing known to be good
+	service.publish(t, privateKey, PolicyBundle{Version: 1, ProtectedNamespaces: []string{"["}})
+	policySync.observe(policySync.Sync(context.Background()))
+	if code, body := ready(); code != http.StatusServiceUnavailable || policySync.staleError() != nil {
+		t.Errorf("Expected unready before the first policy is applied, got %d %s", code, body)
+	}
+
+	service.publish(t, privateKey, PolicyBundle{Version: 2, ProtectedNamespaces: []string{"kube-system"}})
+	policySync.observe(policySync.Sync(context.Background()))
+	generation := source.Current().Generation()
+
+	// Neither an invalid bundle nor one failing the check is applied, the last-known-good policy staying in effect
+	for _, bundle := range []PolicyBundle{{Version: 3, ProtectedNamespaces: []string{"["}}, {Version: 4}} {
+		service.publish(t, privateKey, bundle)
+		err := policySync.Sync(context.Background())
+		if err == nil {
+			t.Fatalf("Expected version %d to be rejected", bundle.Version)
+		}
+		policySync.observe(err)
+		if source.Current().Generation() != generation || policySync.version.Load() != 2 {
+			t.Errorf("Expected version 2 to stay in effect, got version %d", policySync.version.Load())
+		}
+		if code, body := ready(); code != http.StatusOK || !strings.Contains(body, "[+]policy-sync ok: serving last-known-good policy version 2: ") {
+			t.Errorf("Expected stale policy to be reported as readiness detail, got %d %s", code, body)
+		}
+	}
+
+	service.publish(t, privateKey, PolicyBundle{Version: 5, ProtectedNamespaces: []string{"kube-system", "az-demo"}})
+	policySync.observe(policySync.Sync(context.Background()))
+	if code, body := ready(); code != http.StatusOK || policySync.staleError() != nil || !strings.Contains(body, "[+]policy-sync ok\n") {
+		t.Errorf("Expected stale policy to be cleared once a bundle is applied, got %d %s", code, body)
+	}
+
+	// Without failing static, failed syncs still fail readiness
+	policySync.options.FailStatic = false
+	service.publish(t, privateKey, PolicyBundle{Version: 6, ProtectedNamespaces: []string{"["}})
+	policySync.observe(policySync.Sync(context.Background()))
+	if code, body := ready(); code != http.StatusServiceUnavailable || policySync.staleError() == nil {
+		t.Errorf("Expected failed sync to fail readiness, got %d %s", code, body)
+	}
+}
+
+func TestPolicySyncResetsDecisionCache(t *testing.T) {
+	config := WebhookConfig{Config: DefaultPolicyConfig}
+	config.DecisionCache = NewDecisionCache(10, time.Minute, HashDecisionInputs(config))
+	spec := policy.SubjectAccessReviewSpec{}
+	spec.User = "not-admin"
+	config.DecisionCache.Add(spec, authorizationv1.SubjectAccessReviewStatus{Denied: true}, policy.CacheDefault)
+
+	updated := config
+	updated.Config.ProtectedNamespaces = []string{"az-demo"}
+	config.DecisionCache.Reset(HashDecisionInputs(updated))
+	if _, _, ok := config.DecisionCache.Get(spec); ok {
+		t.Error("Expected reset to discard cached decisions")
+	}
+}
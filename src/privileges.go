@@ -0,0 +1,39 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/policy"
+	"context"
+	"log"
+)
+
+// Backend which may grant privileged status to users the static policy doesn't privilege, such as
+// members of an identity provider's admin role
+type PrivilegeResolver interface {
+	Name() string
+	IsPrivileged(ctx context.Context, spec *policy.SubjectAccessReviewSpec) (bool, error)
+}
+
+var privilegeLookups = Metrics.NewCounterVec("azimuth_authz_privilege_lookups_total",
+	"Privilege resolver results, by backend", "backend", "result")
+
+// Privilege resolvers consulted in order, the first to privilege the user wins
+type PrivilegeResolvers []PrivilegeResolver
+
+// Returns true if any resolver privileges the user. A failing resolver is skipped, so backend
+// outages can only ever withhold privileges rather than grant them
+func (p PrivilegeResolvers) Resolve(ctx context.Context, spec *policy.SubjectAccessReviewSpec) bool {
+	for _, resolver := range p {
+		privileged, err := resolver.IsPrivileged(ctx, spec)
+		switch {
+		case err != nil:
+			privilegeLookups.Inc(resolver.Name(), "error")
+			log.Println("Error resolving privileges from "+resolver.Name()+":", err)
+		case privileged:
+			privilegeLookups.Inc(resolver.Name(), "privileged")
+			return true
+		default:
+			privilegeLookups.Inc(resolver.Name(), "unprivileged")
+		}
+	}
+	return false
+}
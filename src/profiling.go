@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"runtime/pprof"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var profilesPushed = Metrics.NewCounterVec("azimuth_authz_profiles_pushed_total",
+	"Profiles pushed to the continuous profiling server", "profile", "result")
+
+type ProfilerOptions struct {
+	// Base URL of Pyroscope compatible server accepting profiles on /ingest
+	ServerURL string
+	// Application name profiles are reported under
+	ApplicationName string
+	// Extra labels attached to every profile, e.g. cluster
+	Labels map[string]string
+	// Time between the start of consecutive profiling rounds
+	Interval time.Duration
+	// Length of each CPU profile, must be shorter than Interval
+	CPUDuration time.Duration
+}
+
+// Periodically captures CPU and heap profiles and pushes them to a continuous profiling server, so
+// performance regressions in the decision path are visible across the fleet without manual sessions
+type Profiler struct {
+	options ProfilerOptions
+	client  *OutboundClient
+}
+
+func NewProfiler(options ProfilerOptions, client *OutboundClient) *Profiler {
+	if options.CPUDuration <= 0 || options.CPUDuration >= options.Interval {
+		options.CPUDuration = options.Interval / 2
+	}
+	return &Profiler{options: options, client: client}
+}
+
+// Pushes profiles until ctx is cancelled
+func (p *Profiler) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.options.Interval)
+	defer ticker.Stop()
+	for {
+		p.pushRound(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *Profiler) pushRound(ctx context.Context) {
+	var cpu bytes.Buffer
+	from := time.Now()
+	if err := pprof.StartCPUProfile(&cpu); err != nil {
+		// Most likely a profile is being taken manually
+		log.Println("Error starting CPU profile:", err)
+	} else {
+		select {
+		case <-ctx.Done():
+		case <-time.After(p.options.CPUDuration):
+		}
+		pprof.StopCPUProfile()
+		p.push("cpu", from, time.Now(), &cpu)
+	}
+
+	var heap bytes.Buffer
+	now := time.Now()
+	if err := pprof.Lookup("heap").WriteTo(&heap, 0); err != nil {
+		log.Println("Error writing heap profile:", err)
+		return
+	}
+	p.push("heap", now, now, &heap)
+}
+
+func (p *Profiler) push(profile string, from time.Time, until time.Time, data *bytes.Buffer) {
+	if err := p.upload(profile, from, until, data); err != nil {
+		profilesPushed.Inc(profile, "error")
+		log.Println("Error pushing "+profile+" profile:", err)
+		return
+	}
+	profilesPushed.Inc(profile, "success")
+}
+
+// Returns Pyroscope application name with labels, e.g. 'app.cpu{cluster=a}'
+func (p *Profiler) profileName(profile string) string {
+	var labels []string
+	for _, key := range sortedKeys(p.options.Labels) {
+		labels = append(labels, key+"="+p.options.Labels[key])
+	}
+	return p.options.ApplicationName + "." + profile + "{" + strings.Join(labels, ",") + "}"
+}
+
+func (p *Profiler) upload(profile string, from time.Time, until time.Time, data *bytes.Buffer) error {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("profile", "profile.pprof")
+	if err != nil {
+		return err
+	}
+	if _, err := part.Write(data.Bytes()); err != nil {
+		return err
+	}
+	if err := form.Close(); err != nil {
+		return err
+	}
+
+	query := url.Values{}
+	query.Set("name", p.profileName(profile))
+	query.Set("from", strconv.FormatInt(from.Unix(), 10))
+	query.Set("until", strconv.FormatInt(until.Unix(), 10))
+	query.Set("format", "pprof")
+	query.Set("spyName", "gospy")
+	req, err := http.NewRequest(http.MethodPost, p.options.ServerURL+"/ingest?"+query.Encode(), &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+
+	resp, err := p.client.Do("profiling", 0, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status from profiling server: %s", resp.Status)
+	}
+	return nil
+}
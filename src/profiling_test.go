@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestProfilerPushesPprofProfiles(t *testing.T) {
+	var mu sync.Mutex
+	names := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		file, _, err := r.FormFile("profile")
+		if err != nil || r.URL.Path != "/ingest" || r.URL.Query().Get("format") != "pprof" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		data, _ := io.ReadAll(file)
+		mu.Lock()
+		names[r.URL.Query().Get("name")] = len(data)
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	profiler := NewProfiler(ProfilerOptions{
+		ServerURL:       server.URL,
+		ApplicationName: "webhook",
+		Labels:          map[string]string{"region": "a", "cluster": "test"},
+		Interval:        time.Hour,
+		CPUDuration:     10 * time.Millisecond,
+	}, NewOutboundClient(DefaultOutboundClientOptions))
+	profiler.pushRound(context.Background())
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, name := range []string{"webhook.cpu{cluster=test,region=a}", "webhook.heap{cluster=test,region=a}"} {
+		if size, ok := names[name]; !ok || size == 0 {
+			t.Errorf("Expected non-empty profile pushed as %s, got %v", name, names)
+		}
+	}
+}
+
+func TestParseKeyValueList(t *testing.T) {
+	values, err := parseKeyValueList("cluster=prod,tenant=")
+	if err != nil || values["cluster"] != "prod" || values["tenant"] != "" || len(values) != 2 {
+		t.Errorf("Unexpected result %v (%v)", values, err)
+	}
+	if _, err := parseKeyValueList("novalue"); err == nil {
+		t.Error("Expected error for pair without '='")
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/server"
+	"errors"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Response sent for requests over their cluster's rate limit
+type RateLimitMode string
+
+const (
+	// Valid SubjectAccessReview response with neither allowed nor denied set, so other authorizers decide
+	RateLimitNoOpinion RateLimitMode = "no-opinion"
+	// HTTP 429 with Retry-After, leaving the outcome to the apiserver's webhook failure handling
+	RateLimitTooManyRequests RateLimitMode = "too-many-requests"
+)
+
+var requestsThrottled = Metrics.NewCounterVec("azimuth_authz_cluster_requests_throttled_total",
+	"Authorization requests rejected by per-cluster rate limits, by cluster and mode", "cluster", "mode")
+
+type ClusterRateLimiterOptions struct {
+	// Requests per second allowed from each cluster, unlimited if 0
+	Rate float64
+	// Requests a cluster can make at once after being idle, the rate rounded up if 0
+	Burst int
+	// Rates for particular clusters by namespace/name, replacing Rate
+	Overrides map[string]float64
+	Mode      RateLimitMode
+}
+
+// Token bucket rate limits for each identified cluster, so that one noisy cluster can't use up the
+// capacity the others' decisions need. Callers that aren't identified share one limit
+type ClusterRateLimiter struct {
+	options ClusterRateLimiterOptions
+	now     func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket // namespace/name -> bucket
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+func NewClusterRateLimiter(options ClusterRateLimiterOptions) *ClusterRateLimiter {
+	if options.Mode == "" {
+		options.Mode = RateLimitNoOpinion
+	}
+	return &ClusterRateLimiter{options: options, now: time.Now, buckets: map[string]*tokenBucket{}}
+}
+
+// Takes a token from cluster's bucket if one is left, otherwise returns the time until there will be
+func (l *ClusterRateLimiter) reserve(cluster string) (bool, time.Duration) {
+	rate, ok := l.options.Overrides[cluster]
+	if !ok {
+		rate = l.options.Rate
+	}
+	if rate <= 0 {
+		return true, 0
+	}
+	burst := float64(l.options.Burst)
+	if burst < 1 {
+		burst = math.Ceil(rate)
+	}
+
+	now := l.now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	bucket := l.buckets[cluster]
+	if bucket == nil {
+		bucket = &tokenBucket{tokens: burst, updated: now}
+		l.buckets[cluster] = bucket
+	}
+	bucket.tokens = math.Min(burst, bucket.tokens+now.Sub(bucket.updated).Seconds()*rate)
+	bucket.updated = now
+	if bucket.tokens < 1 {
+		return false, time.Duration((1 - bucket.tokens) / rate * float64(time.Second))
+	}
+	bucket.tokens--
+	return true, 0
+}
+
+// Takes a token from cluster's bucket, counting the request as throttled if none is left. Allows every
+// request if l is nil
+func (l *ClusterRateLimiter) take(cluster string) bool {
+	if l == nil {
+		return true
+	}
+	if allowed, _ := l.reserve(cluster); allowed {
+		return true
+	}
+	requestsThrottled.Inc(cluster, string(l.options.Mode))
+	return false
+}
+
+// Returns middleware rejecting decoded SubjectAccessReviews over the limit of the cluster they are
+// identified as coming from. Passes every request if l is nil
+func (l *ClusterRateLimiter) Middleware(clusters *ClusterRegistry) server.Middleware {
+	return func(next http.Handler) http.Handler {
+		if l == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			cluster := clusters.Identify(r).String()
+			allowed, retryAfter := l.reserve(cluster)
+			if allowed {
+				next.ServeHTTP(w, r)
+				return
+			}
+			requestsThrottled.Inc(cluster, string(l.options.Mode))
+			if l.options.Mode == RateLimitTooManyRequests {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+				server.WriteError(w, http.StatusTooManyRequests, errors.New("Cluster rate limit exceeded"))
+				return
+			}
+			sar, request, _ := server.RequestSubjectAccessReview(r.Context())
+			server.WriteResponse(w, server.NewResponse(request, sar.UID,
+				authorizationv1.SubjectAccessReviewStatus{Reason: "Cluster rate limit exceeded, delegated to other authorizers"}))
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/server"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClusterRateLimiterBuckets(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	limiter := NewClusterRateLimiter(ClusterRateLimiterOptions{Rate: 2, Overrides: map[string]float64{"az-tenant-a/big": 10}})
+	limiter.now = func() time.Time { return now }
+
+	for i := range 2 {
+		if allowed, _ := limiter.reserve("az-tenant-a/demo"); !allowed {
+			t.Fatalf("Expected request %d within the burst to be allowed", i)
+		}
+	}
+	allowed, retryAfter := limiter.reserve("az-tenant-a/demo")
+	if allowed || retryAfter != 500*time.Millisecond {
+		t.Errorf("Expected request over the limit to wait 500ms, got %v, %s", allowed, retryAfter)
+	}
+	// Other clusters have their own buckets, with their own rates
+	for i := range 10 {
+		if allowed, _ := limiter.reserve("az-tenant-a/big"); !allowed {
+			t.Fatalf("Expected request %d from cluster with a higher rate to be allowed", i)
+		}
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if allowed, _ := limiter.reserve("az-tenant-a/demo"); !allowed {
+		t.Error("Expected request to be allowed once a token has been refilled")
+	}
+}
+
+func TestClusterRateLimiterModes(t *testing.T) {
+	for _, mode := range []RateLimitMode{RateLimitNoOpinion, RateLimitTooManyRequests} {
+		config := WebhookConfig{Config: DefaultPolicyConfig, RateLimiter: NewClusterRateLimiter(ClusterRateLimiterOptions{Rate: 1, Mode: mode})}
+		handler := CreateWebhookAuthorizer(config)
+		request := func(cluster string) *httptest.ResponseRecorder {
+			body := `{"apiVersion":"authorization.k8s.io/v1","kind":"SubjectAccessReview","metadata":{"uid":"1"},
+				"spec":{"user":"alice","resourceAttributes":{"namespace":"kube-system","verb":"delete","resource":"pods"}}}`
+			req := httptest.NewRequest(http.MethodPost, "/authorize", strings.NewReader(body))
+			identity := &ClusterIdentity{Namespace: "az-tenant-a", Name: cluster}
+			req = req.WithContext(withClusterIdentity(req.Context(), identity))
+			resp := httptest.NewRecorder()
+			handler(resp, req)
+			return resp
+		}
+
+		request("noisy")
+		before := requestsThrottled.Value("az-tenant-a/noisy", string(mode))
+		resp := request("noisy")
+		if requestsThrottled.Value("az-tenant-a/noisy", string(mode)) != before+1 {
+			t.Errorf("Expected throttled request to be counted for mode %s", mode)
+		}
+		switch mode {
+		case RateLimitTooManyRequests:
+			if resp.Code != http.StatusTooManyRequests || resp.Header().Get("Retry-After") != "1" {
+				t.Errorf("Expected 429 with Retry-After, got %d %q", resp.Code, resp.Header().Get("Retry-After"))
+			}
+		case RateLimitNoOpinion:
+			var response server.SubjectAccessReviewResponse
+			json.NewDecoder(resp.Body).Decode(&response)
+			if resp.Code != http.StatusOK || response.Status.Allowed || response.Status.Denied || response.Metadata.UID != "1" {
+				t.Errorf("Expected no opinion for request 1, got %d %+v", resp.Code, response)
+			}
+		}
+
+		// The noisy cluster doesn't affect decisions for others
+		var response server.SubjectAccessReviewResponse
+		json.NewDecoder(request("quiet").Body).Decode(&response)
+		if !response.Status.Denied {
+			t.Errorf("Expected other cluster's request to be evaluated, got %+v", response.Status)
+		}
+	}
+}
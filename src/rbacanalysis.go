@@ -0,0 +1,216 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/internal/exitcode"
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/policy"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// RBAC grant the webhook would deny, as the subject isn't privileged by the policy
+type rbacFinding struct {
+	// Subject as in the binding, e.g. 'User alice'
+	Subject string `json:"subject"`
+	// Username to list in additionalPrivilegedUsers, empty for groups
+	User string `json:"user,omitempty"`
+	// Protected namespace the grant applies in, '*' for every namespace
+	Namespace  string   `json:"namespace"`
+	Binding    string   `json:"binding"`
+	Role       string   `json:"role"`
+	Access     []string `json:"access"`
+	Suggestion string   `json:"suggestion"`
+}
+
+func (f rbacFinding) String() string {
+	namespace := "namespace " + f.Namespace
+	if f.Namespace == "*" {
+		namespace = "all namespaces"
+	}
+	return fmt.Sprintf("%s can %s in %s via %s (%s): %s", f.Subject, strings.Join(f.Access, " and "), namespace, f.Binding, f.Role, f.Suggestion)
+}
+
+// Groups whose members the webhook never restricts. kube-apiserver allows system:masters before
+// consulting any authorizer, and node users are privileged system users
+var privilegedGroups = toSet([]string{"system:masters", "system:nodes"})
+
+// Returns the accesses rules grant which the protected namespace rules restrict
+func restrictedAccess(rules []rbacv1.PolicyRule) []string {
+	var write, readSecrets bool
+	for _, rule := range rules {
+		if len(rule.Resources) == 0 {
+			continue
+		}
+		secrets := slices.Contains(rule.Resources, "*") || slices.Contains(rule.Resources, "secrets")
+		for _, verb := range rule.Verbs {
+			if verb == "*" || !policy.IsReadonlyVerb(verb) {
+				write = true
+			}
+			if secrets {
+				readSecrets = true
+			}
+		}
+	}
+	var access []string
+	if write {
+		access = append(access, "write")
+	}
+	if readSecrets {
+		access = append(access, "read secrets")
+	}
+	return access
+}
+
+// Returns findings for subjects of a binding granting roleRules in namespace, '*' for a ClusterRoleBinding
+func analyzeBinding(p *policy.Policy, binding string, role string, namespace string, subjects []rbacv1.Subject, roleRules []rbacv1.PolicyRule) []rbacFinding {
+	if namespace != "*" && !p.IsProtectedNamespace(namespace) {
+		return nil
+	}
+	access := restrictedAccess(roleRules)
+	if len(access) == 0 {
+		return nil
+	}
+	var findings []rbacFinding
+	for _, subject := range subjects {
+		finding := rbacFinding{Subject: subject.Kind + " " + subject.Name, Namespace: namespace, Binding: binding, Role: role, Access: access}
+		switch subject.Kind {
+		case rbacv1.UserKind:
+			finding.User = subject.Name
+		case rbacv1.ServiceAccountKind:
+			finding.Subject = fmt.Sprintf("ServiceAccount %s/%s", subject.Namespace, subject.Name)
+			finding.User = policy.ServiceAccountUserPrefix + subject.Namespace + ":" + subject.Name
+		case rbacv1.GroupKind:
+			serviceAccountNamespace, isServiceAccounts := strings.CutPrefix(subject.Name, "system:serviceaccounts:")
+			if privilegedGroups.Has(subject.Name) || (isServiceAccounts && p.IsProtectedNamespace(serviceAccountNamespace)) {
+				continue
+			}
+			finding.Suggestion = "groups can't be privileged, so list its members in additionalPrivilegedUsers or remove it from the binding"
+			findings = append(findings, finding)
+			continue
+		default:
+			continue
+		}
+		if p.IsPrivilegedUser(finding.User) {
+			continue
+		}
+		finding.Suggestion = "add " + finding.User + " to additionalPrivilegedUsers if intended, otherwise remove it from the binding"
+		findings = append(findings, finding)
+	}
+	return findings
+}
+
+// Returns findings for every binding, resolving the roles they refer to
+func analyzeRBAC(p *policy.Policy, clusterRoles []rbacv1.ClusterRole, roles []rbacv1.Role, clusterRoleBindings []rbacv1.ClusterRoleBinding, roleBindings []rbacv1.RoleBinding) []rbacFinding {
+	clusterRoleRules := map[string][]rbacv1.PolicyRule{}
+	for _, role := range clusterRoles {
+		clusterRoleRules[role.Name] = role.Rules
+	}
+	roleRules := map[string][]rbacv1.PolicyRule{}
+	for _, role := range roles {
+		roleRules[role.Namespace+"/"+role.Name] = role.Rules
+	}
+
+	var findings []rbacFinding
+	for _, binding := range clusterRoleBindings {
+		findings = append(findings, analyzeBinding(p, "ClusterRoleBinding "+binding.Name, "ClusterRole "+binding.RoleRef.Name, "*",
+			binding.Subjects, clusterRoleRules[binding.RoleRef.Name])...)
+	}
+	for _, binding := range roleBindings {
+		rules, role := clusterRoleRules[binding.RoleRef.Name], "ClusterRole "+binding.RoleRef.Name
+		if binding.RoleRef.Kind == "Role" {
+			rules, role = roleRules[binding.Namespace+"/"+binding.RoleRef.Name], "Role "+binding.RoleRef.Name
+		}
+		findings = append(findings, analyzeBinding(p, "RoleBinding "+binding.Namespace+"/"+binding.Name, role, binding.Namespace,
+			binding.Subjects, rules)...)
+	}
+	return findings
+}
+
+// Lists RBAC objects of one type from the cluster's API server
+func listRBAC[T any](conn *ClusterConnection, client *OutboundClient, resource string, timeout time.Duration) ([]T, error) {
+	resp, err := kubeGet(context.Background(), conn, client, "analyze-rbac", "/apis/rbac.authorization.k8s.io/v1/"+resource, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("listing %s: %w", resource, err)
+	}
+	defer resp.Body.Close()
+	var list struct {
+		Items []T `json:"items"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("decoding %s: %w", resource, err)
+	}
+	return list.Items, nil
+}
+
+// Implements the analyze-rbac command, reporting subjects whose RBAC permissions would be restricted
+// by the webhook's protected namespace rules, so policy can be corrected before it breaks them.
+// Exits 0 unless the cluster can't be read
+func runAnalyzeRBAC(args []string, out io.Writer) int {
+	flags := flag.NewFlagSet("analyze-rbac", flag.ContinueOnError)
+	kubeconfigPath := flags.String("kubeconfig", "", "Kubeconfig for the cluster to analyse, whose user must be allowed to list RBAC resources. Required")
+	contextName := flags.String("context", "", "Context to use from the kubeconfig, current context if empty")
+	loadPolicy := addPolicyFlags(flags)
+	output := flags.String("output", "text", "Output format. Values: [text, json]")
+	timeout := flags.Duration("timeout", 10*time.Second, "Timeout for each request to the apiserver")
+	if err := flags.Parse(args); err != nil {
+		return exitcode.Usage
+	}
+	if *kubeconfigPath == "" || (*output != "text" && *output != "json") {
+		fmt.Fprintln(os.Stderr, "error: --kubeconfig is required and --output must be text or json")
+		return exitcode.Usage
+	}
+
+	policyFile, err := loadPolicy()
+	if err != nil {
+		return exitcode.Report(os.Stderr, err)
+	}
+	conn, err := LoadKubeconfig(*kubeconfigPath, *contextName)
+	if err != nil {
+		return exitcode.Report(os.Stderr, exitcode.Wrap(exitcode.Config, err))
+	}
+	client := NewOutboundClient(DefaultOutboundClientOptions).WithTLSConfig(conn.TLSConfig)
+
+	clusterRoles, err := listRBAC[rbacv1.ClusterRole](conn, client, "clusterroles", *timeout)
+	var roles []rbacv1.Role
+	if err == nil {
+		roles, err = listRBAC[rbacv1.Role](conn, client, "roles", *timeout)
+	}
+	var clusterRoleBindings []rbacv1.ClusterRoleBinding
+	if err == nil {
+		clusterRoleBindings, err = listRBAC[rbacv1.ClusterRoleBinding](conn, client, "clusterrolebindings", *timeout)
+	}
+	var roleBindings []rbacv1.RoleBinding
+	if err == nil {
+		roleBindings, err = listRBAC[rbacv1.RoleBinding](conn, client, "rolebindings", *timeout)
+	}
+	if err != nil {
+		return exitcode.Report(os.Stderr, exitcode.Wrap(exitcode.Connectivity, err))
+	}
+
+	findings := analyzeRBAC(policy.Compile(policyFile.PolicyConfig()), clusterRoles, roles, clusterRoleBindings, roleBindings)
+	if *output == "json" {
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(findings)
+		return 0
+	}
+	var users []string
+	for _, finding := range findings {
+		fmt.Fprintln(out, finding)
+		if finding.User != "" {
+			users = append(users, finding.User)
+		}
+	}
+	fmt.Fprintf(out, "%d subjects granted access the webhook would deny\n", len(findings))
+	if len(users) > 0 {
+		fmt.Fprintf(out, "Suggested additionalPrivilegedUsers: %s\n", strings.Join(sortedKeys(toSet(append(policyFile.AdditionalPrivilegedUsers, users...))), ","))
+	}
+	return 0
+}
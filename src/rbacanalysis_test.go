@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Fake apiserver listing RBAC resources
+var testRBACResources = map[string]string{
+	"clusterroles": `{"items":[
+		{"metadata":{"name":"edit"},"rules":[{"apiGroups":[""],"resources":["pods"],"verbs":["create","delete"]}]},
+		{"metadata":{"name":"view"},"rules":[{"apiGroups":[""],"resources":["pods"],"verbs":["get","list"]}]}
+	]}`,
+	"roles": `{"items":[
+		{"metadata":{"name":"secret-reader","namespace":"kube-system"},"rules":[{"apiGroups":[""],"resources":["secrets"],"verbs":["get"]}]}
+	]}`,
+	"clusterrolebindings": `{"items":[
+		{"metadata":{"name":"editors"},"roleRef":{"kind":"ClusterRole","name":"edit"},"subjects":[
+			{"kind":"Group","name":"developers"},
+			{"kind":"Group","name":"system:masters"},
+			{"kind":"User","name":"admin"}
+		]},
+		{"metadata":{"name":"viewers"},"roleRef":{"kind":"ClusterRole","name":"view"},"subjects":[{"kind":"User","name":"viewer"}]}
+	]}`,
+	"rolebindings": `{"items":[
+		{"metadata":{"name":"readers","namespace":"kube-system"},"roleRef":{"kind":"Role","name":"secret-reader"},"subjects":[
+			{"kind":"ServiceAccount","name":"backup","namespace":"velero"},
+			{"kind":"ServiceAccount","name":"coredns","namespace":"kube-system"}
+		]},
+		{"metadata":{"name":"tenant-editors","namespace":"tenant"},"roleRef":{"kind":"ClusterRole","name":"edit"},"subjects":[{"kind":"User","name":"alice"}]}
+	]}`,
+}
+
+func TestAnalyzeRBAC(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resource, ok := strings.CutPrefix(r.URL.Path, "/apis/rbac.authorization.k8s.io/v1/")
+		if !ok || r.Header.Get("Authorization") != "Bearer admin" {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		w.Write([]byte(testRBACResources[resource]))
+	}))
+	defer server.Close()
+	kubeconfig := writeKubeconfig(t, server, "    token: admin\n")
+
+	var out bytes.Buffer
+	if code := runAnalyzeRBAC([]string{"--kubeconfig", kubeconfig, "--additional-privileged-users", "admin", "--output", "json"}, &out); code != 0 {
+		t.Fatalf("Expected success, got %d", code)
+	}
+	var findings []rbacFinding
+	if err := json.Unmarshal(out.Bytes(), &findings); err != nil {
+		t.Fatal(err)
+	}
+	var subjects []string
+	for _, finding := range findings {
+		subjects = append(subjects, finding.Subject+" "+finding.Namespace+" "+strings.Join(finding.Access, ","))
+	}
+	// Privileged users, system:masters, service accounts of protected namespaces, read-only grants and
+	// unprotected namespaces aren't reported
+	expected := "Group developers * write;ServiceAccount velero/backup kube-system read secrets"
+	if strings.Join(subjects, ";") != expected {
+		t.Errorf("Expected findings %q, got %q", expected, strings.Join(subjects, ";"))
+	}
+	if findings[1].User != "system:serviceaccount:velero:backup" {
+		t.Errorf("Expected service account username, got %q", findings[1].User)
+	}
+
+	out.Reset()
+	if code := runAnalyzeRBAC([]string{"--kubeconfig", kubeconfig, "--additional-privileged-users", "admin"}, &out); code != 0 {
+		t.Fatalf("Expected success, got %d", code)
+	}
+	if !strings.Contains(out.String(), "Suggested additionalPrivilegedUsers: admin,system:serviceaccount:velero:backup\n") {
+		t.Errorf("Expected suggested users, got:\n%s", out.String())
+	}
+}
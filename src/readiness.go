@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Health of a dependency, from the outcomes of its operations. Safe to use when nil, as always healthy
+type health struct {
+	mu sync.Mutex
+	// Zero while succeeding
+	failingSince time.Time
+	err          error
+}
+
+// Returns health of a dependency. Sources which must sync before decisions can be trusted start out failing
+func newHealth(needsSync bool) *health {
+	h := &health{}
+	if needsSync {
+		h.failingSince = time.Now()
+		h.err = errors.New("not synced yet")
+	}
+	return h
+}
+
+func (h *health) succeeded() {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.failingSince = time.Time{}
+	h.err = nil
+}
+
+func (h *health) failed(err error) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.failingSince.IsZero() {
+		h.failingSince = time.Now()
+	}
+	h.err = err
+}
+
+// Returns the latest error if the dependency has been failing for longer than grace
+func (h *health) check(grace time.Duration) error {
+	if h == nil {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.failingSince.IsZero() || time.Since(h.failingSince) <= grace {
+		return nil
+	}
+	return h.err
+}
+
+// Dependency whose health decides readiness
+type readinessCheck struct {
+	name   string
+	health *health
+	// Fails as soon as the dependency does, for failures which waiting won't resolve
+	immediate bool
+	// Describes a degraded state which doesn't fail the check, if any
+	detail func() string
+}
+
+func (c readinessCheck) describe() string {
+	if c.detail == nil {
+		return ""
+	}
+	return c.detail()
+}
+
+// Returns handler for /readyz, failing with 503 while any dependency has been failing for longer than grace
+// so traffic goes to instances which can make decisions. Results of each check are listed as
+// kube-apiserver does, e.g. '[-]delegate failed: ...'
+func readinessHandler(checks []readinessCheck, grace time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var sb strings.Builder
+		ready := true
+		for _, check := range checks {
+			checkGrace := grace
+			if check.immediate {
+				checkGrace = 0
+			}
+			if err := check.health.check(checkGrace); err != nil {
+				ready = false
+				sb.WriteString("[-]" + check.name + " failed: " + err.Error() + "\n")
+			} else if detail := check.describe(); detail != "" {
+				sb.WriteString("[+]" + check.name + " ok: " + detail + "\n")
+			} else {
+				sb.WriteString("[+]" + check.name + " ok\n")
+			}
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if !ready {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			sb.WriteString("readyz check failed\n")
+		} else {
+			sb.WriteString("readyz check passed\n")
+		}
+		w.Write([]byte(sb.String()))
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHealth(t *testing.T) {
+	unsynced := newHealth(true)
+	if unsynced.check(time.Hour) != nil || unsynced.check(0) == nil {
+		t.Error("Expected unsynced source to fail only after the grace period")
+	}
+	unsynced.succeeded()
+	if unsynced.check(0) != nil {
+		t.Error("Expected synced source to be healthy")
+	}
+
+	delegate := newHealth(false)
+	if delegate.check(0) != nil {
+		t.Error("Expected unused dependency to be healthy")
+	}
+	delegate.failed(errors.New("connection refused"))
+	if err := delegate.check(0); err == nil || err.Error() != "connection refused" {
+		t.Errorf("Expected failing dependency to report its error, got %v", err)
+	}
+	if (*health)(nil).check(0) != nil {
+		t.Error("Expected nil health to be healthy")
+	}
+}
+
+func TestReadinessHandler(t *testing.T) {
+	failing := newHealth(false)
+	handler := readinessHandler([]readinessCheck{{name: "policy-sync", health: newHealth(false)}, {name: "delegate", health: failing}}, 0)
+
+	resp := httptest.NewRecorder()
+	handler(resp, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if resp.Code != http.StatusOK {
+		t.Errorf("Expected ready, got %d %s", resp.Code, resp.Body)
+	}
+
+	failing.failed(errors.New("timeout"))
+	resp = httptest.NewRecorder()
+	handler(resp, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if resp.Code != http.StatusServiceUnavailable || !strings.Contains(resp.Body.String(), "[-]delegate failed: timeout") || !strings.Contains(resp.Body.String(), "[+]policy-sync ok") {
+		t.Errorf("Expected failing delegate to be reported, got %d %s", resp.Code, resp.Body)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/internal/exitcode"
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/policy"
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// Recorded request whose denial the replayed policy changes
+type ReplayChange struct {
+	// Line of the record in the corpus, and when it was recorded
+	Line        int                        `json:"line"`
+	Time        time.Time                  `json:"time"`
+	Request     policy.SubjectAccessReview `json:"request"`
+	WasDenied   bool                       `json:"wasDenied"`
+	Explanation policy.Explanation         `json:"explanation"`
+}
+
+// Replays a corpus recorded with --record-corpus against a local policy, reporting requests whose denial
+// it changes, so policy changes can be tried against real traffic. Only the policy is evaluated, so
+// decisions made by other authorizers are compared as if the webhook gave no opinion. Exits 0 if no
+// denial changes
+func runReplay(args []string, out io.Writer) int {
+	flags := flag.NewFlagSet("replay", flag.ContinueOnError)
+	corpusPath := flags.String("corpus", "", "JSON lines corpus recorded with --record-corpus, '-' for stdin. Required")
+	loadPolicy := addPolicyFlags(flags)
+	output := flags.String("output", "text", "Output format. Values: [text, json]")
+	if err := flags.Parse(args); err != nil {
+		return exitcode.Usage
+	}
+	if *corpusPath == "" || (*output != "text" && *output != "json") {
+		fmt.Fprintln(os.Stderr, "error: --corpus is required and --output must be text or json")
+		return exitcode.Usage
+	}
+	policyFile, err := loadPolicy()
+	if err != nil {
+		return exitcode.Report(os.Stderr, err)
+	}
+	input := os.Stdin
+	if *corpusPath != "-" {
+		if input, err = os.Open(*corpusPath); err != nil {
+			return exitcode.Report(os.Stderr, err)
+		}
+		defer input.Close()
+	}
+
+	changes, replayed, err := replayCorpus(input, policy.Compile(policyFile.PolicyConfig()), policyFile.AllowOpinionMode, time.Time{})
+	if err != nil {
+		return exitcode.Report(os.Stderr, err)
+	}
+	if *output == "json" {
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(changes)
+	} else {
+		for _, change := range changes {
+			fmt.Fprintf(out, "Line %d: %s by %s was ", change.Line, describeRequest(change.Request.Spec), policy.DescribeSubject(change.Request.Spec))
+			if change.WasDenied {
+				fmt.Fprintf(out, "denied, now %s by rule %s\n", change.Explanation.Decision, change.Explanation.Rule)
+			} else {
+				fmt.Fprintf(out, "not denied, now denied by rule %s: %s\n", change.Explanation.Rule, change.Explanation.Reason)
+			}
+		}
+		fmt.Fprintf(out, "Replayed %d records, %d changed\n", replayed, len(changes))
+	}
+	if len(changes) > 0 {
+		return exitcode.Mismatch
+	}
+	return 0
+}
+
+// Evaluates each record of the corpus in input recorded at or after since against compiled, returning the
+// records whose denial changes and the number replayed. Every record is replayed if since is zero
+func replayCorpus(input io.Reader, compiled *policy.Policy, opinionMode bool, since time.Time) ([]ReplayChange, int, error) {
+	var changes []ReplayChange
+	replayed := 0
+	scanner := bufio.NewScanner(input)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		// CorpusRecord, with the request decoded as the webhook would
+		var record struct {
+			Time    time.Time                  `json:"time"`
+			Request policy.SubjectAccessReview `json:"request"`
+			Status  struct {
+				Denied bool `json:"denied"`
+			} `json:"status"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, replayed, fmt.Errorf("line %d: %w", line, err)
+		}
+		if record.Time.Before(since) {
+			continue
+		}
+		sar := record.Request
+		err := policy.Normalize(&sar, nil)
+		if err == nil {
+			err = policy.Validate(sar)
+		}
+		if err != nil {
+			return nil, replayed, fmt.Errorf("line %d: %w", line, err)
+		}
+		replayed++
+		explanation := policy.Explain(sar, compiled, opinionMode)
+		if denied := explanation.Decision == "denied"; denied != record.Status.Denied {
+			changes = append(changes, ReplayChange{Line: line, Time: record.Time, Request: sar, WasDenied: record.Status.Denied, Explanation: explanation})
+		}
+	}
+	return changes, replayed, scanner.Err()
+}
+
+// Returns a short description of the request spec makes, e.g. 'delete pods in kube-system'
+func describeRequest(spec policy.SubjectAccessReviewSpec) string {
+	if attributes := spec.NonResourceAttributes; attributes != nil {
+		return attributes.Verb + " " + attributes.Path
+	}
+	attributes := spec.ResourceAttributes
+	resource := attributes.Resource
+	if attributes.Subresource != "" {
+		resource += "/" + attributes.Subresource
+	}
+	if attributes.Namespace == "" {
+		return attributes.Verb + " " + resource + " in all namespaces"
+	}
+	return attributes.Verb + " " + resource + " in " + attributes.Namespace
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/internal/exitcode"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const replayTestCorpus = `{"request":{"apiVersion":"authorization.k8s.io/v1","kind":"SubjectAccessReview","spec":{"user":"alice","resourceAttributes":{"namespace":"tenant-a","verb":"delete","resource":"pods"}}},"status":{"allowed":false}}
+{"request":{"apiVersion":"authorization.k8s.io/v1","kind":"SubjectAccessReview","spec":{"user":"alice","resourceAttributes":{"namespace":"kube-system","verb":"delete","resource":"pods"}}},"status":{"allowed":false,"denied":true}}
+
+{"request":{"apiVersion":"authorization.k8s.io/v1","kind":"SubjectAccessReview","spec":{"user":"alice","nonResourceAttributes":{"verb":"get","path":"/healthz"}}},"status":{"allowed":false}}
+`
+
+func TestReplayCommand(t *testing.T) {
+	corpusPath := filepath.Join(t.TempDir(), "corpus.jsonl")
+	os.WriteFile(corpusPath, []byte(replayTestCorpus), 0o600)
+
+	var out bytes.Buffer
+	if code := runReplay([]string{"--corpus", corpusPath, "--protected-namespaces", "kube-system"}, &out); code != 0 {
+		t.Errorf("Expected no changes with the recorded policy, got exit code %d:\n%s", code, out.String())
+	}
+	if !strings.Contains(out.String(), "Replayed 3 records, 0 changed") {
+		t.Errorf("Unexpected summary:\n%s", out.String())
+	}
+
+	out.Reset()
+	code := runReplay([]string{"--corpus", corpusPath, "--protected-namespaces", "tenant-*", "--output", "json"}, &out)
+	if code != exitcode.Mismatch {
+		t.Fatalf("Expected changes exit code, got %d:\n%s", code, out.String())
+	}
+	var changes []ReplayChange
+	if err := json.Unmarshal(out.Bytes(), &changes); err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 2 || changes[0].Line != 1 || changes[0].WasDenied || changes[1].Line != 2 || !changes[1].WasDenied {
+		t.Errorf("Unexpected changes %+v", changes)
+	}
+}
+
+func TestReplayCommandRejectsInvalidRecords(t *testing.T) {
+	corpusPath := filepath.Join(t.TempDir(), "corpus.jsonl")
+	os.WriteFile(corpusPath, []byte("{\"request\":{\"spec\":{}}}\n"), 0o600)
+	if code := runReplay([]string{"--corpus", corpusPath}, &bytes.Buffer{}); code != 1 {
+		t.Errorf("Expected exit code 1 for an invalid record, got %d", code)
+	}
+	if code := runReplay(nil, &bytes.Buffer{}); code != 2 {
+		t.Errorf("Expected exit code 2 without a corpus, got %d", code)
+	}
+}
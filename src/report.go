@@ -0,0 +1,405 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/internal/exitcode"
+	"bufio"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Distinct reasons and users counted per tenant per day, beyond which they're counted as other, so a
+// tenant generating unique reasons or users can't grow the report without bound
+const reportBucketLimit = 1000
+
+// Name decisions beyond reportBucketLimit are counted under
+const reportOther = "(other)"
+
+// Decisions aggregated per tenant over a period, for tenant security reports
+type DecisionReport struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+	// What tenants are: namespace, cluster or label:NAME
+	GroupBy string `json:"groupBy"`
+	// Sorted by denials, most first
+	Tenants []TenantReport `json:"tenants"`
+}
+
+type TenantReport struct {
+	Tenant          string         `json:"tenant"`
+	Decisions       int            `json:"decisions"`
+	Denials         int            `json:"denials"`
+	DenialsByReason map[string]int `json:"denialsByReason"`
+	// Users denied most often, most first
+	TopDeniedUsers []UserDenials `json:"topDeniedUsers"`
+	// Decisions per day, oldest first, for days with any
+	Trend []DailyDecisions `json:"trend"`
+}
+
+type UserDenials struct {
+	User    string `json:"user"`
+	Denials int    `json:"denials"`
+}
+
+type DailyDecisions struct {
+	Day       string `json:"day"`
+	Decisions int    `json:"decisions"`
+	Denials   int    `json:"denials"`
+}
+
+// Decisions of one tenant on one UTC day
+type reportBucket struct {
+	decisions int
+	denials   int
+	reasons   map[string]int
+	users     map[string]int
+}
+
+type reportKey struct {
+	day    time.Time
+	tenant string
+}
+
+// Aggregates decisions per tenant per day
+type reportAggregator struct {
+	groupBy string
+	buckets map[reportKey]*reportBucket
+}
+
+// Checks groupBy is namespace, cluster or label:NAME
+func validateReportGroupBy(groupBy string) error {
+	if groupBy == "namespace" || groupBy == "cluster" || strings.HasPrefix(groupBy, "label:") && len(groupBy) > len("label:") {
+		return nil
+	}
+	return fmt.Errorf("invalid grouping %q, expected namespace, cluster or label:NAME", groupBy)
+}
+
+func newReportAggregator(groupBy string) *reportAggregator {
+	return &reportAggregator{groupBy: groupBy, buckets: map[reportKey]*reportBucket{}}
+}
+
+// Returns the tenant a decision belongs to
+func (a *reportAggregator) tenant(event AuditEvent) string {
+	switch {
+	case a.groupBy == "cluster":
+		return cmp.Or(event.Cluster, "(unidentified)")
+	case strings.HasPrefix(a.groupBy, "label:"):
+		return cmp.Or(event.ClusterLabels[strings.TrimPrefix(a.groupBy, "label:")], "(none)")
+	default:
+		return cmp.Or(event.Namespace, "(cluster-scoped)")
+	}
+}
+
+func (a *reportAggregator) add(event AuditEvent) {
+	// Writes reaching admission were authorized first, so would otherwise be counted twice. Simulations
+	// aren't tenants' traffic
+	if event.Source == admissionEventSource || event.Simulated {
+		return
+	}
+	key := reportKey{day: event.Time.UTC().Truncate(24 * time.Hour), tenant: a.tenant(event)}
+	bucket, ok := a.buckets[key]
+	if !ok {
+		bucket = &reportBucket{reasons: map[string]int{}, users: map[string]int{}}
+		a.buckets[key] = bucket
+	}
+	bucket.decisions++
+	if !event.Denied {
+		return
+	}
+	bucket.denials++
+	countBounded(bucket.reasons, event.Reason)
+	countBounded(bucket.users, event.User)
+}
+
+func countBounded(counts map[string]int, name string) {
+	if _, ok := counts[name]; !ok && len(counts) >= reportBucketLimit {
+		name = reportOther
+	}
+	counts[name]++
+}
+
+// Drops days before cutoff
+func (a *reportAggregator) expire(cutoff time.Time) {
+	cutoff = cutoff.UTC().Truncate(24 * time.Hour)
+	maps.DeleteFunc(a.buckets, func(key reportKey, _ *reportBucket) bool {
+		return key.day.Before(cutoff)
+	})
+}
+
+// Reports decisions of the days from from to to, of only tenant if not empty, with the top users
+// denied most often for each tenant
+func (a *reportAggregator) report(from time.Time, to time.Time, tenant string, top int) DecisionReport {
+	report := DecisionReport{From: from, To: to, GroupBy: a.groupBy, Tenants: []TenantReport{}}
+	first, last := from.UTC().Truncate(24*time.Hour), to.UTC().Truncate(24*time.Hour)
+	tenants := map[string]*TenantReport{}
+	users := map[string]map[string]int{}
+	keys := slices.SortedFunc(maps.Keys(a.buckets), func(x, y reportKey) int {
+		return x.day.Compare(y.day)
+	})
+	for _, key := range keys {
+		if key.day.Before(first) || key.day.After(last) || tenant != "" && key.tenant != tenant {
+			continue
+		}
+		bucket := a.buckets[key]
+		summary, ok := tenants[key.tenant]
+		if !ok {
+			summary = &TenantReport{Tenant: key.tenant, DenialsByReason: map[string]int{}, TopDeniedUsers: []UserDenials{}}
+			tenants[key.tenant] = summary
+			users[key.tenant] = map[string]int{}
+		}
+		summary.Decisions += bucket.decisions
+		summary.Denials += bucket.denials
+		for reason, count := range bucket.reasons {
+			summary.DenialsByReason[reason] += count
+		}
+		for user, count := range bucket.users {
+			users[key.tenant][user] += count
+		}
+		summary.Trend = append(summary.Trend, DailyDecisions{Day: key.day.Format(time.DateOnly), Decisions: bucket.decisions, Denials: bucket.denials})
+	}
+	for name, summary := range tenants {
+		for user, count := range users[name] {
+			summary.TopDeniedUsers = append(summary.TopDeniedUsers, UserDenials{User: user, Denials: count})
+		}
+		slices.SortFunc(summary.TopDeniedUsers, func(x, y UserDenials) int {
+			if x.Denials != y.Denials {
+				return y.Denials - x.Denials
+			}
+			return strings.Compare(x.User, y.User)
+		})
+		summary.TopDeniedUsers = summary.TopDeniedUsers[:min(len(summary.TopDeniedUsers), top)]
+		report.Tenants = append(report.Tenants, *summary)
+	}
+	slices.SortFunc(report.Tenants, func(x, y TenantReport) int {
+		if x.Denials != y.Denials {
+			return y.Denials - x.Denials
+		}
+		return strings.Compare(x.Tenant, y.Tenant)
+	})
+	return report
+}
+
+// Audit sink aggregating decisions per tenant per day for retention, served from the admin interface
+// for monthly tenant security reports without exporting the audit log
+type DecisionReporter struct {
+	mu         sync.Mutex
+	aggregator *reportAggregator
+	retention  time.Duration
+}
+
+func NewDecisionReporter(groupBy string, retention time.Duration) *DecisionReporter {
+	r := &DecisionReporter{aggregator: newReportAggregator(groupBy), retention: retention}
+	Metrics.NewGaugeFunc("azimuth_authz_report_buckets", "Tenant days of decisions held for reports",
+		func() float64 {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			return float64(len(r.aggregator.buckets))
+		})
+	return r
+}
+
+func (r *DecisionReporter) Name() string {
+	return "report"
+}
+
+func (r *DecisionReporter) Write(events []AuditEvent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, event := range events {
+		r.aggregator.add(event)
+	}
+	r.aggregator.expire(time.Now().Add(-r.retention))
+	return nil
+}
+
+// Serves the report as JSON, for the period given by ?since=, a duration or RFC 3339 time defaulting to
+// the retention, of only the tenant given by ?tenant= if set, with the ?top= users denied most often
+func (r *DecisionReporter) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	now := time.Now()
+	from := now.Add(-r.retention)
+	if since := req.URL.Query().Get("since"); since != "" {
+		parsed, err := parseSince(since, now)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid since %q, expected a duration or RFC 3339 time", since), http.StatusBadRequest)
+			return
+		}
+		from = parsed
+	}
+	top := 10
+	if value := req.URL.Query().Get("top"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			http.Error(w, fmt.Sprintf("invalid top %q, expected a positive number", value), http.StatusBadRequest)
+			return
+		}
+		top = parsed
+	}
+	r.mu.Lock()
+	report := r.aggregator.report(from, now, req.URL.Query().Get("tenant"), top)
+	r.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// Reports decisions per tenant, from a running webhook's admin interface or an audit file, for tenant
+// security reports
+func runReport(args []string, out io.Writer) int {
+	flags := flag.NewFlagSet("report", flag.ContinueOnError)
+	serverURL := flags.String("server-url", "http://localhost:8081", "URL of the webhook's admin interface. '/admin/report' is appended if there is no path")
+	caFile := flags.String("ca-file", "", "CA bundle used to verify the admin interface, system roots if empty")
+	clientCertFile := flags.String("client-cert-file", "", "Client certificate presented to the admin interface")
+	clientKeyFile := flags.String("client-key-file", "", "Private key of the client certificate")
+	tokenFile := flags.String("token-file", "", "File containing the admin token")
+	auditPath := flags.String("audit-file", "", "Audit file written with --audit-file to report from, '-' for stdin, instead of a running webhook")
+	groupBy := flags.String("group-by", "namespace", "What tenants are when reporting from an audit file: namespace, cluster or label:NAME for a cluster label")
+	since := flags.String("since", "720h", "Start of the period reported, as a duration before now or an RFC 3339 time")
+	tenant := flags.String("tenant", "", "Tenant to report on, all if empty")
+	top := flags.Int("top", 10, "Number of users denied most often reported for each tenant")
+	output := flags.String("output", "text", "Output format: text or json")
+	if err := flags.Parse(args); err != nil {
+		return exitcode.Usage
+	}
+	if flags.NArg() != 0 || *top <= 0 || *output != "text" && *output != "json" {
+		flags.Usage()
+		return exitcode.Usage
+	}
+	now := time.Now()
+	from, err := parseSince(*since, now)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: invalid --since %q, expected a duration or RFC 3339 time\n", *since)
+		return exitcode.Usage
+	}
+	if err := validateReportGroupBy(*groupBy); err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return exitcode.Usage
+	}
+
+	var report DecisionReport
+	if *auditPath != "" {
+		report, err = reportFromAuditFile(*auditPath, *groupBy, from, now, *tenant, *top)
+	} else {
+		report, err = fetchReport(*serverURL, *caFile, *clientCertFile, *clientKeyFile, *tokenFile, *since, *tenant, *top)
+	}
+	if err != nil {
+		return exitcode.Report(os.Stderr, err)
+	}
+	if *output == "json" {
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(report)
+		return 0
+	}
+	renderReport(out, report)
+	return 0
+}
+
+func reportFromAuditFile(path string, groupBy string, from time.Time, to time.Time, tenant string, top int) (DecisionReport, error) {
+	input := io.Reader(os.Stdin)
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return DecisionReport{}, err
+		}
+		defer file.Close()
+		input = file
+	}
+	aggregator := newReportAggregator(groupBy)
+	decoder := json.NewDecoder(bufio.NewReader(input))
+	for line := 1; ; line++ {
+		var event AuditEvent
+		if err := decoder.Decode(&event); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return DecisionReport{}, fmt.Errorf("%s: event %d: %w", path, line, err)
+		}
+		if !event.Time.Before(from) && !event.Time.After(to) {
+			aggregator.add(event)
+		}
+	}
+	return aggregator.report(from, to, tenant, top), nil
+}
+
+func fetchReport(serverURL string, caFile string, clientCertFile string, clientKeyFile string, tokenFile string, since string, tenant string, top int) (DecisionReport, error) {
+	reportURL, err := url.Parse(serverURL)
+	if err != nil {
+		return DecisionReport{}, exitcode.Wrap(exitcode.Config, err)
+	}
+	if reportURL.Path == "" || reportURL.Path == "/" {
+		reportURL.Path = "/admin/report"
+	}
+	reportURL.RawQuery = url.Values{"since": {since}, "tenant": {tenant}, "top": {strconv.Itoa(top)}}.Encode()
+	conn, err := webhookConnection(reportURL.String(), caFile, clientCertFile, clientKeyFile, tokenFile)
+	if err != nil {
+		return DecisionReport{}, exitcode.Wrap(exitcode.Config, err)
+	}
+	request, err := http.NewRequest(http.MethodGet, conn.Server, nil)
+	if err != nil {
+		return DecisionReport{}, err
+	}
+	if conn.BearerToken != "" {
+		request.Header.Set("Authorization", "Bearer "+conn.BearerToken)
+	}
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: conn.TLSConfig, Proxy: http.ProxyFromEnvironment}, Timeout: 30 * time.Second}
+	response, err := client.Do(request)
+	if err != nil {
+		return DecisionReport{}, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+		return DecisionReport{}, exitcode.Wrap(exitcode.Connectivity, fmt.Errorf("report returned %s: %s", response.Status, strings.TrimSpace(string(body))))
+	}
+	var report DecisionReport
+	if err := json.NewDecoder(response.Body).Decode(&report); err != nil {
+		return DecisionReport{}, fmt.Errorf("decoding report: %w", err)
+	}
+	return report, nil
+}
+
+func renderReport(out io.Writer, report DecisionReport) {
+	fmt.Fprintf(out, "Decisions from %s to %s by %s\n", report.From.UTC().Format(time.RFC3339), report.To.UTC().Format(time.RFC3339), report.GroupBy)
+	if len(report.Tenants) == 0 {
+		fmt.Fprintln(out, "\nNo decisions")
+	}
+	for _, tenant := range report.Tenants {
+		fmt.Fprintf(out, "\n%s: %d decisions, %d denied\n", tenant.Tenant, tenant.Decisions, tenant.Denials)
+		if tenant.Denials > 0 {
+			reasons := slices.Collect(maps.Keys(tenant.DenialsByReason))
+			slices.SortFunc(reasons, func(x, y string) int {
+				if tenant.DenialsByReason[x] != tenant.DenialsByReason[y] {
+					return tenant.DenialsByReason[y] - tenant.DenialsByReason[x]
+				}
+				return strings.Compare(x, y)
+			})
+			w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "  DENIALS\tREASON")
+			for _, reason := range reasons {
+				fmt.Fprintf(w, "  %d\t%s\n", tenant.DenialsByReason[reason], reason)
+			}
+			fmt.Fprintln(w, "  DENIALS\tUSER")
+			for _, user := range tenant.TopDeniedUsers {
+				fmt.Fprintf(w, "  %d\t%s\n", user.Denials, user.User)
+			}
+			w.Flush()
+		}
+		w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "  DAY\tDECISIONS\tDENIALS")
+		for _, day := range tenant.Trend {
+			fmt.Fprintf(w, "  %s\t%d\t%d\n", day.Day, day.Decisions, day.Denials)
+		}
+		w.Flush()
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReportAggregator(t *testing.T) {
+	now := time.Date(2026, 5, 31, 12, 0, 0, 0, time.UTC)
+	aggregator := newReportAggregator("label:tenant")
+	acme := map[string]string{"tenant": "acme"}
+	for _, event := range []AuditEvent{
+		{Time: now.Add(-48 * time.Hour), ClusterLabels: acme, User: "alice", Denied: true, Reason: "protected namespace"},
+		{Time: now.Add(-24 * time.Hour), ClusterLabels: acme, User: "bob", Denied: true, Reason: "protected namespace"},
+		{Time: now.Add(-24 * time.Hour), ClusterLabels: acme, User: "bob", Denied: true, Reason: "wildcard"},
+		{Time: now, ClusterLabels: acme, User: "alice", Allowed: true},
+		{Time: now, User: "carol", Denied: true, Reason: "wildcard"},
+		// Before the period reported
+		{Time: now.Add(-96 * time.Hour), ClusterLabels: acme, User: "dave", Denied: true, Reason: "wildcard"},
+	} {
+		aggregator.add(event)
+	}
+
+	report := aggregator.report(now.Add(-72*time.Hour), now, "", 1)
+	if len(report.Tenants) != 2 || report.Tenants[0].Tenant != "acme" || report.Tenants[1].Tenant != "(none)" {
+		t.Fatalf("Expected tenants sorted by denials, got %+v", report.Tenants)
+	}
+	acmeReport := report.Tenants[0]
+	if acmeReport.Decisions != 4 || acmeReport.Denials != 3 || acmeReport.DenialsByReason["protected namespace"] != 2 || acmeReport.DenialsByReason["wildcard"] != 1 {
+		t.Errorf("Unexpected counts %+v", acmeReport)
+	}
+	if len(acmeReport.TopDeniedUsers) != 1 || acmeReport.TopDeniedUsers[0] != (UserDenials{User: "bob", Denials: 2}) {
+		t.Errorf("Expected bob to be the top denied user, got %v", acmeReport.TopDeniedUsers)
+	}
+	expected := []DailyDecisions{{"2026-05-29", 1, 1}, {"2026-05-30", 2, 2}, {"2026-05-31", 1, 0}}
+	if len(acmeReport.Trend) != len(expected) {
+		t.Fatalf("Expected trend %v, got %v", expected, acmeReport.Trend)
+	}
+	for i := range expected {
+		if acmeReport.Trend[i] != expected[i] {
+			t.Errorf("Expected trend %v, got %v", expected, acmeReport.Trend)
+		}
+	}
+
+	if report := aggregator.report(now.Add(-72*time.Hour), now, "acme", 10); len(report.Tenants) != 1 {
+		t.Errorf("Expected only the tenant asked for, got %+v", report.Tenants)
+	}
+	aggregator.expire(now.Add(-24 * time.Hour))
+	if report := aggregator.report(time.Time{}, now, "acme", 10); report.Tenants[0].Denials != 2 {
+		t.Errorf("Expected expired days to be dropped, got %+v", report.Tenants[0])
+	}
+}
+
+func TestDecisionReporter(t *testing.T) {
+	reporter := NewDecisionReporter("namespace", 30*24*time.Hour)
+	reporter.Write([]AuditEvent{
+		{Time: time.Now(), Namespace: "tenant-a", User: "alice", Denied: true, Reason: "protected namespace"},
+		// Dropped once written, being older than the retention
+		{Time: time.Now().Add(-60 * 24 * time.Hour), Namespace: "tenant-b", User: "bob", Denied: true},
+	})
+
+	resp := httptest.NewRecorder()
+	reporter.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/admin/report?since=720h", nil))
+	var report DecisionReport
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Tenants) != 1 || report.Tenants[0].Tenant != "tenant-a" || report.GroupBy != "namespace" {
+		t.Errorf("Unexpected report %+v", report)
+	}
+
+	for _, query := range []string{"since=yesterday", "top=0"} {
+		resp := httptest.NewRecorder()
+		reporter.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/admin/report?"+query, nil))
+		if resp.Code != http.StatusBadRequest {
+			t.Errorf("Expected %s to be rejected, got %d", query, resp.Code)
+		}
+	}
+}
+
+func TestReportCommand(t *testing.T) {
+	auditPath := filepath.Join(t.TempDir(), "audit.jsonl")
+	var events bytes.Buffer
+	for _, event := range []AuditEvent{
+		{Time: time.Now(), Cluster: "az-acme/demo", User: "alice", Denied: true, Reason: "protected namespace"},
+		{Time: time.Now(), Cluster: "az-acme/demo", User: "alice", Allowed: true},
+	} {
+		json.NewEncoder(&events).Encode(event)
+	}
+	os.WriteFile(auditPath, events.Bytes(), 0o600)
+
+	var out bytes.Buffer
+	if code := runReport([]string{"--audit-file", auditPath, "--group-by", "cluster"}, &out); code != 0 {
+		t.Fatalf("Expected exit code 0, got %d", code)
+	}
+	for _, expected := range []string{"az-acme/demo: 2 decisions, 1 denied", "1        protected namespace", "1        alice"} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("Expected report to contain %q:\n%s", expected, out.String())
+		}
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/admin/report" || r.URL.Query().Get("tenant") != "acme" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(DecisionReport{GroupBy: "label:tenant", Tenants: []TenantReport{{Tenant: "acme", Decisions: 3}}})
+	}))
+	defer server.Close()
+	out.Reset()
+	if code := runReport([]string{"--server-url", server.URL, "--tenant", "acme", "--output", "json"}, &out); code != 0 {
+		t.Fatalf("Expected exit code 0, got %d", code)
+	}
+	var report DecisionReport
+	if err := json.Unmarshal(out.Bytes(), &report); err != nil || len(report.Tenants) != 1 || report.Tenants[0].Decisions != 3 {
+		t.Errorf("Expected the webhook's report, got %s", out.String())
+	}
+
+	if code := runReport([]string{"--group-by", "tenant"}, &out); code != 2 {
+		t.Errorf("Expected exit code 2 for an invalid grouping, got %d", code)
+	}
+}
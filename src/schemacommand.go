@@ -0,0 +1,47 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/internal/exitcode"
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/config"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Schemas printed by the schema command, by kind
+var schemaKinds = map[string]func(webhookFlags *flag.FlagSet) config.Schema{
+	"policy":   func(*flag.FlagSet) config.Schema { return config.PolicyFileSchema() },
+	"overlays": func(*flag.FlagSet) config.Schema { return config.OverlaysSchema() },
+	"settings": func(webhookFlags *flag.FlagSet) config.Schema {
+		return config.SettingsFileSchema(webhookFlags, "config-file")
+	},
+}
+
+func runSchema(args []string, out io.Writer) int {
+	return serve(nil, func(webhookFlags *flag.FlagSet) int {
+		return printSchema(args, out, webhookFlags)
+	})
+}
+
+// Prints the JSON Schema of policy files, overlay files or settings files, for editors to validate and
+// complete them with
+func printSchema(args []string, out io.Writer, webhookFlags *flag.FlagSet) int {
+	flags := flag.NewFlagSet("schema", flag.ContinueOnError)
+	kind := flags.String("kind", "policy", "File to print the schema of: policy, as given to --policy-file of the offline commands, overlays, as given to --policy-overlays-file, or settings, as given to --config-file")
+	if err := flags.Parse(args); err != nil {
+		return exitcode.Usage
+	}
+	schema, ok := schemaKinds[*kind]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "error: unknown kind %q, expected policy, overlays or settings\n", *kind)
+		return exitcode.Usage
+	}
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(schema(webhookFlags)); err != nil {
+		return exitcode.Report(os.Stderr, err)
+	}
+	return 0
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/policy"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"log"
+	"strings"
+)
+
+var selfTestRuns = Metrics.NewCounterVec("azimuth_authz_self_tests_total",
+	"Self-tests of the policy in effect against the built-in corpus, by result", "result")
+
+// Placeholder in the built-in corpus for a namespace the policy protects
+const selfTestProtectedNamespace = "$PROTECTED"
+
+//go:embed selftest.json
+var selfTestCorpusJSON []byte
+
+// Canonical request of the built-in corpus, with the decision any sane policy makes for it
+type selfTestCase struct {
+	Name        string   `json:"name"`
+	User        string   `json:"user"`
+	Groups      []string `json:"groups"`
+	Verb        string   `json:"verb"`
+	Group       string   `json:"group"`
+	Resource    string   `json:"resource"`
+	Subresource string   `json:"subresource"`
+	Namespace   string   `json:"namespace"`
+	Allowed     bool     `json:"allowed"`
+}
+
+var selfTestCorpus = mustParseSelfTestCorpus(selfTestCorpusJSON)
+
+func mustParseSelfTestCorpus(data []byte) []selfTestCase {
+	var cases []selfTestCase
+	if err := json.Unmarshal(data, &cases); err != nil {
+		panic(fmt.Sprintf("invalid self-test corpus: %s", err))
+	}
+	return cases
+}
+
+// Returns the invariants of the built-in corpus that compiled, built from config, violates. Cases in a
+// protected namespace are skipped if none of the policy's entries can be instantiated
+func selfTestViolations(config policy.Config, compiled *policy.Policy) []string {
+	protected := ""
+	for _, entry := range config.ProtectedNamespaces {
+		if namespace := conformanceNamespace(entry); namespace != "" && compiled.IsProtectedNamespace(namespace) {
+			protected = namespace
+			break
+		}
+	}
+
+	var violations []string
+	for _, c := range selfTestCorpus {
+		if strings.Contains(c.User+c.Namespace, selfTestProtectedNamespace) && protected == "" {
+			continue
+		}
+		replacer := strings.NewReplacer(selfTestProtectedNamespace, protected)
+		attributes := authorizationv1.ResourceAttributes{
+			Namespace:   replacer.Replace(c.Namespace),
+			Group:       c.Group,
+			Resource:    c.Resource,
+			Subresource: c.Subresource,
+		}
+		sar := newRequestSAR(replacer.Replace(c.User), c.Groups, c.Verb, attributes, "")
+		if authorized, _ := policy.IsRequestAuthorized(sar, compiled); authorized != c.Allowed {
+			expected := "allowed"
+			if !c.Allowed {
+				expected = "denied"
+			}
+			violations = append(violations, fmt.Sprintf("%s should be %s", c.Name, expected))
+		}
+	}
+	return violations
+}
+
+// Checks each policy made current against the built-in corpus, failing readiness while the policy in
+// effect violates its invariants
+type SelfTest struct {
+	health *health
+}
+
+func NewSelfTest() *SelfTest {
+	return &SelfTest{health: newHealth(false)}
+}
+
+// Checks compiled, built from config, against the built-in corpus and returns an error listing the
+// invariants it violates
+func (s *SelfTest) Run(config policy.Config, compiled *policy.Policy) error {
+	violations := selfTestViolations(config, compiled)
+	if len(violations) == 0 {
+		selfTestRuns.Inc("passed")
+		s.health.succeeded()
+		return nil
+	}
+	selfTestRuns.Inc("failed")
+	err := fmt.Errorf("policy generation %d violates invariants: %s", compiled.Generation(), strings.Join(violations, "; "))
+	log.Println("Self-test failed:", err)
+	s.health.failed(err)
+	return err
+}
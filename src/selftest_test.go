@@ -0,0 +1,48 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/policy"
+	"strings"
+	"testing"
+)
+
+func TestSelfTest(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		config    policy.Config
+		violation string
+	}{
+		{"default policy", DefaultPolicyConfig, ""},
+		{"no protected namespaces", policy.Config{}, ""},
+		{"anonymous privileged", policy.Config{ProtectedNamespaces: []string{"kube-system"}, AdditionalPrivilegedUsers: []string{"system:anonymous"}}, "anonymous reads secrets in protected namespace should be denied"},
+		{"tenant user privileged", policy.Config{AdditionalPrivilegedUsers: []string{"azimuth-self-test:tenant"}}, "tenant user lists secrets in all namespaces should be denied"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			selfTest := NewSelfTest()
+			err := selfTest.Run(test.config, policy.Compile(test.config))
+			if test.violation == "" {
+				if err != nil {
+					t.Errorf("Expected self-test to pass, got %s", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.violation) {
+				t.Errorf("Expected violation %q, got %v", test.violation, err)
+			}
+			if selfTest.health.check(0) == nil {
+				t.Error("Expected failed self-test to fail readiness")
+			}
+		})
+	}
+}
+
+func TestSelfTestRecovers(t *testing.T) {
+	selfTest := NewSelfTest()
+	broken := policy.Config{ProtectedNamespaces: []string{"kube-system"}, AdditionalPrivilegedUsers: []string{"system:anonymous"}}
+	if selfTest.Run(broken, policy.Compile(broken)) == nil {
+		t.Fatal("Expected self-test to fail")
+	}
+	if err := selfTest.Run(DefaultPolicyConfig, policy.Compile(DefaultPolicyConfig)); err != nil || selfTest.health.check(0) != nil {
+		t.Errorf("Expected fixed policy to pass the self-test, got %v", err)
+	}
+}
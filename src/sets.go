@@ -0,0 +1,32 @@
+package main
+
+import (
+	"sort"
+)
+
+type stringSet map[string]struct{}
+
+func (s stringSet) Has(value string) bool {
+	_, ok := s[value]
+	return ok
+}
+
+// Builds set from list, ignoring empty entries left by splitting empty comma separated flags
+func toSet(values []string) stringSet {
+	set := make(stringSet, len(values))
+	for _, value := range values {
+		if value != "" {
+			set[value] = struct{}{}
+		}
+	}
+	return set
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
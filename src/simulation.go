@@ -0,0 +1,106 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/policy"
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/server"
+	"context"
+	"errors"
+	"fmt"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"net/http"
+	"path"
+	"strconv"
+)
+
+var simulatedDecisions = Metrics.NewCounterVec("azimuth_authz_simulated_decisions_total",
+	"Requests evaluated as simulations with the simulation header and answered with no opinion, by the decision they would have had", "decision")
+
+var simulationRequestsForbidden = Metrics.NewCounterVec("azimuth_authz_simulation_requests_forbidden_total",
+	"Requests setting the simulation header rejected as their cluster may not simulate, by cluster", "cluster")
+
+// Requests marked as simulations with a trusted request header, so test harnesses can send traffic through
+// production instances: they are evaluated, logged and audited as usual, tagged as simulated, but always
+// answered with no opinion
+type Simulation struct {
+	header   string
+	clusters []string
+}
+
+type simulatedKey struct{}
+
+// Returns true if the request is a simulation
+func simulatedFrom(ctx context.Context) bool {
+	simulated, _ := ctx.Value(simulatedKey{}).(bool)
+	return simulated
+}
+
+// Returns simulation marked by header, allowed for identified clusters matching the namespace/name
+// patterns in clusters, at least one of which is required. Empty patterns are ignored
+func NewSimulation(header string, clusters []string) (*Simulation, error) {
+	if header == "" {
+		return nil, errors.New("simulation header is required")
+	}
+	s := &Simulation{header: header}
+	for _, pattern := range clusters {
+		if pattern == "" {
+			continue
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid simulation cluster pattern %q", pattern)
+		}
+		s.clusters = append(s.clusters, pattern)
+	}
+	if len(s.clusters) == 0 {
+		return nil, errors.New("clusters allowed to send simulations are required")
+	}
+	return s, nil
+}
+
+// Returns middleware marking requests setting the header to true as simulations. Requests setting it to
+// something other than a boolean, or from clusters which may not simulate, are rejected rather than
+// answered with a real decision
+func (s *Simulation) Middleware(clusters *ClusterRegistry) server.Middleware {
+	return func(next http.Handler) http.Handler {
+		if s == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			value := r.Header.Get(s.header)
+			if value == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			simulated, err := strconv.ParseBool(value)
+			if err != nil {
+				server.WriteError(w, http.StatusBadRequest, fmt.Errorf("Invalid %s header %q, expected true or false", s.header, value))
+				return
+			}
+			if !simulated {
+				next.ServeHTTP(w, r)
+				return
+			}
+			identity := clusters.Identify(r)
+			if !s.allows(identity) {
+				simulationRequestsForbidden.Inc(identity.String())
+				server.WriteError(w, http.StatusForbidden, errors.New("Caller may not simulate requests"))
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), simulatedKey{}, true)))
+		})
+	}
+}
+
+// Reports whether identity may send simulations. Unidentified callers never may
+func (s *Simulation) allows(identity *ClusterIdentity) bool {
+	return clusterPatternsAllow(s.clusters, identity)
+}
+
+// Returns no opinion for simulations, with the decision they would have had as the reason, and status
+// otherwise
+func simulationAnswer(r *http.Request, status authorizationv1.SubjectAccessReviewStatus) authorizationv1.SubjectAccessReviewStatus {
+	if !simulatedFrom(r.Context()) {
+		return status
+	}
+	simulatedDecisions.Inc(policy.DecisionLabel(status))
+	return authorizationv1.SubjectAccessReviewStatus{Reason: fmt.Sprintf("Simulated, would have been %s: %s", policy.DecisionLabel(status), status.Reason)}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/server"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSimulation(t *testing.T) {
+	if _, err := NewSimulation("", nil); err == nil {
+		t.Error("Expected simulation without a header to be rejected")
+	}
+	if _, err := NewSimulation("X-Azimuth-Authz-Dry-Run", []string{""}); err == nil {
+		t.Error("Expected simulation without clusters to be rejected")
+	}
+	if _, err := NewSimulation("X-Azimuth-Authz-Dry-Run", []string{"az-["}); err == nil {
+		t.Error("Expected invalid cluster pattern to be rejected")
+	}
+	simulation, err := NewSimulation("X-Azimuth-Authz-Dry-Run", []string{"", "az-staging/*"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := CreateWebhookAuthorizer(WebhookConfig{Config: DefaultPolicyConfig, Simulation: simulation})
+	request := func(value string, identity *ClusterIdentity) (*httptest.ResponseRecorder, server.SubjectAccessReviewResponse) {
+		body := `{"apiVersion":"authorization.k8s.io/v1","kind":"SubjectAccessReview",
+			"spec":{"user":"alice","resourceAttributes":{"namespace":"kube-system","verb":"delete","resource":"pods"}}}`
+		req := httptest.NewRequest(http.MethodPost, "/authorize", strings.NewReader(body))
+		if value != "" {
+			req.Header.Set("X-Azimuth-Authz-Dry-Run", value)
+		}
+		if identity != nil {
+			req = req.WithContext(withClusterIdentity(req.Context(), identity))
+		}
+		resp := httptest.NewRecorder()
+		handler(resp, req)
+		var response server.SubjectAccessReviewResponse
+		json.NewDecoder(resp.Body).Decode(&response)
+		return resp, response
+	}
+	staging := &ClusterIdentity{Namespace: "az-staging", Name: "demo"}
+
+	before := simulatedDecisions.Value("denied")
+	_, response := request("true", staging)
+	if response.Status.Denied || response.Status.Allowed || !strings.HasPrefix(response.Status.Reason, "Simulated, would have been denied: ") {
+		t.Errorf("Expected simulated denial to be answered with no opinion, got %+v", response.Status)
+	}
+	if simulatedDecisions.Value("denied") != before+1 {
+		t.Error("Expected simulated denial to be counted")
+	}
+	if _, response := request("false", staging); !response.Status.Denied {
+		t.Errorf("Expected request which isn't a simulation to be denied, got %+v", response.Status)
+	}
+	if resp, _ := request("maybe", staging); resp.Code != http.StatusBadRequest {
+		t.Errorf("Expected invalid header to be rejected, got %d", resp.Code)
+	}
+	if resp, _ := request("true", &ClusterIdentity{Namespace: "az-prod", Name: "demo"}); resp.Code != http.StatusForbidden {
+		t.Errorf("Expected cluster not allowed to simulate to be forbidden, got %d", resp.Code)
+	}
+	if resp, _ := request("true", nil); resp.Code != http.StatusForbidden {
+		t.Errorf("Expected unidentified caller to be forbidden, got %d", resp.Code)
+	}
+}
+
+func TestSimulatedDecisionsExcludedFromReports(t *testing.T) {
+	reporter := NewDecisionReporter("namespace", 24*time.Hour)
+	reporter.Write([]AuditEvent{{Time: time.Now(), User: "alice", Namespace: "tenant-acme", Denied: true, Simulated: true}})
+	if len(reporter.aggregator.buckets) != 0 {
+		t.Error("Expected simulated decisions to be left out of reports")
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/internal/exitcode"
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/config"
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/policy"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"time"
+)
+
+// Machine-readable summary of a policy change for review tooling, such as a bot commenting on merge
+// requests. Fields are only ever added, so tooling can rely on those it reads
+type PolicySummary struct {
+	// Start of the window of recorded traffic replayed
+	Since time.Time `json:"since"`
+	// Settings differing between the base and candidate policies, empty without a base policy
+	PolicyChanges []PolicyChange `json:"policyChanges"`
+	// Number of recorded requests in the window replayed against the candidate policy
+	Replayed    int               `json:"replayed"`
+	Divergences DivergenceSummary `json:"divergences"`
+}
+
+// Setting of a policy file, by its key, whose value differs between the base and candidate policies.
+// Before or After is null if the setting is unset in that policy
+type PolicyChange struct {
+	Setting string          `json:"setting"`
+	Before  json.RawMessage `json:"before"`
+	After   json.RawMessage `json:"after"`
+}
+
+// Aggregate of the recorded requests whose denial the candidate policy changes
+type DivergenceSummary struct {
+	Total          int `json:"total"`
+	NewlyDenied    int `json:"newlyDenied"`
+	NoLongerDenied int `json:"noLongerDenied"`
+	// Counts by the rule deciding the request under the candidate policy
+	ByRule map[string]int `json:"byRule"`
+	// The first divergences, in corpus order, up to the limit given
+	Examples []ReplayChange `json:"examples"`
+}
+
+// Summarizes the settings a candidate policy changes and the recorded decisions it would change, as JSON,
+// for posting to merge requests. Exits 0 whether or not anything changed, as the summary reports it
+func runSummarize(args []string, out io.Writer) int {
+	flags := flag.NewFlagSet("summarize", flag.ContinueOnError)
+	since := flags.String("since", "", "Start of the window of recorded traffic to replay, as a duration before now, e.g. 24h, or an RFC 3339 time. Required")
+	corpusPath := flags.String("corpus", "", "JSON lines corpus recorded with --record-corpus, '-' for stdin. No traffic is replayed if empty")
+	basePolicyPath := flags.String("base-policy-file", "", "YAML policy file the candidate policy changes, e.g. from the target branch. Policy changes aren't summarized if empty")
+	loadPolicy := addPolicyFlags(flags)
+	maxExamples := flags.Int("max-examples", 20, "Maximum number of divergences included in full")
+	if err := flags.Parse(args); err != nil {
+		return exitcode.Usage
+	}
+	start, err := parseSince(*since, time.Now())
+	if err != nil || *maxExamples < 0 {
+		fmt.Fprintln(os.Stderr, "error: --since must be a duration or RFC 3339 time and --max-examples must not be negative")
+		return exitcode.Usage
+	}
+	candidate, err := loadPolicy()
+	if err != nil {
+		return exitcode.Report(os.Stderr, err)
+	}
+
+	summary := PolicySummary{Since: start, PolicyChanges: []PolicyChange{}, Divergences: summarizeDivergences(nil, *maxExamples)}
+	if *basePolicyPath != "" {
+		base, err := config.LoadPolicyFile(*basePolicyPath)
+		if err != nil {
+			return exitcode.Report(os.Stderr, policyError(err))
+		}
+		if summary.PolicyChanges, err = diffPolicyFiles(base, candidate); err != nil {
+			return exitcode.Report(os.Stderr, err)
+		}
+	}
+	if *corpusPath != "" {
+		input := os.Stdin
+		if *corpusPath != "-" {
+			if input, err = os.Open(*corpusPath); err != nil {
+				return exitcode.Report(os.Stderr, err)
+			}
+			defer input.Close()
+		}
+		changes, replayed, err := replayCorpus(input, policy.Compile(candidate.PolicyConfig()), candidate.AllowOpinionMode, start)
+		if err != nil {
+			return exitcode.Report(os.Stderr, err)
+		}
+		summary.Replayed = replayed
+		summary.Divergences = summarizeDivergences(changes, *maxExamples)
+	}
+
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(summary); err != nil {
+		return exitcode.Report(os.Stderr, err)
+	}
+	return 0
+}
+
+// Returns the start of the window given by value, either a duration before now or an RFC 3339 time
+func parseSince(value string, now time.Time) (time.Time, error) {
+	if duration, err := time.ParseDuration(value); err == nil && duration >= 0 {
+		return now.Add(-duration), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// Aggregates changes found by replaying a corpus, keeping the first maxExamples in full
+func summarizeDivergences(changes []ReplayChange, maxExamples int) DivergenceSummary {
+	summary := DivergenceSummary{Total: len(changes), ByRule: map[string]int{}, Examples: changes[:min(len(changes), maxExamples)]}
+	if summary.Examples == nil {
+		summary.Examples = []ReplayChange{}
+	}
+	for _, change := range changes {
+		if change.WasDenied {
+			summary.NoLongerDenied++
+		} else {
+			summary.NewlyDenied++
+		}
+		summary.ByRule[change.Explanation.Rule]++
+	}
+	return summary
+}
+
+// Returns the settings whose values differ between the policy files, sorted by key
+func diffPolicyFiles(base config.PolicyFile, candidate config.PolicyFile) ([]PolicyChange, error) {
+	before, err := policyFileSettings(base)
+	if err != nil {
+		return nil, err
+	}
+	after, err := policyFileSettings(candidate)
+	if err != nil {
+		return nil, err
+	}
+	settings := make([]string, 0, len(before)+len(after))
+	for setting := range before {
+		settings = append(settings, setting)
+	}
+	for setting := range after {
+		if _, ok := before[setting]; !ok {
+			settings = append(settings, setting)
+		}
+	}
+	slices.Sort(settings)
+	changes := []PolicyChange{}
+	for _, setting := range settings {
+		if !bytes.Equal(before[setting], after[setting]) {
+			changes = append(changes, PolicyChange{Setting: setting, Before: orNull(before[setting]), After: orNull(after[setting])})
+		}
+	}
+	return changes, nil
+}
+
+// Returns the settings of file by key, as encoded in a policy file, omitting those left unset
+func policyFileSettings(file config.PolicyFile) (map[string]json.RawMessage, error) {
+	data, err := json.Marshal(file)
+	if err != nil {
+		return nil, err
+	}
+	var settings map[string]json.RawMessage
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+func orNull(value json.RawMessage) json.RawMessage {
+	if value == nil {
+		return json.RawMessage("null")
+	}
+	return value
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSummarizeCommand(t *testing.T) {
+	dir := t.TempDir()
+	corpusPath := filepath.Join(dir, "corpus.jsonl")
+	os.WriteFile(corpusPath, []byte(`{"time":"2024-01-01T00:00:00Z","request":{"apiVersion":"authorization.k8s.io/v1","kind":"SubjectAccessReview","spec":{"user":"alice","resourceAttributes":{"namespace":"tenant-a","verb":"get","resource":"pods"}}},"status":{"allowed":false}}
+`+replayTestCorpus), 0o600)
+	basePath := filepath.Join(dir, "base.yaml")
+	os.WriteFile(basePath, []byte("protectedNamespaces: [kube-system]\nallowOpinionMode: false\n"), 0o600)
+	candidatePath := filepath.Join(dir, "candidate.yaml")
+	os.WriteFile(candidatePath, []byte("protectedNamespaces: [tenant-*]\nwildcardRequests: deny\n"), 0o600)
+
+	var out bytes.Buffer
+	args := []string{"--since", "2025-01-01T00:00:00Z", "--corpus", corpusPath, "--base-policy-file", basePath, "--policy-file", candidatePath, "--max-examples", "1"}
+	if code := runSummarize(args, &out); code != 0 {
+		t.Fatalf("Expected exit code 0, got %d:\n%s", code, out.String())
+	}
+	var summary PolicySummary
+	if err := json.Unmarshal(out.Bytes(), &summary); err != nil {
+		t.Fatal(err)
+	}
+	if len(summary.PolicyChanges) != 2 || summary.PolicyChanges[0].Setting != "protectedNamespaces" || string(summary.PolicyChanges[1].Before) != "null" {
+		t.Errorf("Unexpected policy changes %+v", summary.PolicyChanges)
+	}
+	// Records from before --since aren't replayed, nor are those without a time, whose age is unknown
+	divergences := summary.Divergences
+	if summary.Replayed != 0 || divergences.Total != 0 {
+		t.Errorf("Expected records from before --since to be skipped, got %+v", summary)
+	}
+
+	out.Reset()
+	args[1] = "2023-01-01T00:00:00Z"
+	runSummarize(args, &out)
+	json.Unmarshal(out.Bytes(), &summary)
+	divergences = summary.Divergences
+	if summary.Replayed != 1 || divergences.Total != 0 {
+		t.Errorf("Expected only the timestamped record to be replayed, got %+v", summary)
+	}
+}
+
+func TestSummarizeDivergences(t *testing.T) {
+	changes := []ReplayChange{{Line: 1}, {Line: 2, WasDenied: true}, {Line: 3}}
+	for i := range changes {
+		changes[i].Explanation.Rule = "protected-namespace-write"
+	}
+	summary := summarizeDivergences(changes, 2)
+	if summary.Total != 3 || summary.NewlyDenied != 2 || summary.NoLongerDenied != 1 || summary.ByRule["protected-namespace-write"] != 3 || len(summary.Examples) != 2 {
+		t.Errorf("Unexpected summary %+v", summary)
+	}
+	if summary := summarizeDivergences(nil, 2); summary.Examples == nil || summary.ByRule == nil {
+		t.Error("Expected empty summary to encode empty collections rather than null")
+	}
+}
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	if start, err := parseSince("24h", now); err != nil || !start.Equal(now.Add(-24*time.Hour)) {
+		t.Errorf("Unexpected start %s for duration: %v", start, err)
+	}
+	if start, err := parseSince("2025-05-01T00:00:00Z", now); err != nil || start.Month() != time.May {
+		t.Errorf("Unexpected start %s for time: %v", start, err)
+	}
+	for _, value := range []string{"", "-1h", "yesterday"} {
+		if _, err := parseSince(value, now); err == nil {
+			t.Errorf("Expected %q to be rejected", value)
+		}
+	}
+}
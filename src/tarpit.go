@@ -0,0 +1,150 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/internal/lru"
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/policy"
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+)
+
+var flaggedIdentities = Metrics.NewCounterVec("azimuth_authz_repeated_denials_flagged_total",
+	"Identities flagged for being denied by the same rule more than --tarpit-threshold times within --tarpit-window, by rule", "rule")
+
+var tarpittedRequests = Metrics.NewCounterVec("azimuth_authz_tarpitted_requests_total",
+	"Denials answered late because the identity keeps being denied by the same rule, by rule", "rule")
+
+// Identities tracked at once, the least recently denied being forgotten first
+const tarpitIdentities = 10000
+
+// Delayed responses outstanding at once, beyond which denials are answered at once so probing can't tie up
+// the webhook's goroutines
+const maxTarpitted = 256
+
+type TarpitOptions struct {
+	// Denials of an identity by the same rule within Window before it is flagged and its denials delayed
+	Threshold int
+	Window    time.Duration
+	// Delay of the first denial past the threshold, doubling with each one after, up to MaxDelay. Identities
+	// are only flagged if MaxDelay is 0
+	Delay    time.Duration
+	MaxDelay time.Duration
+	// Deny rules whose denials are counted, every one if empty
+	Rules []string
+}
+
+// Slows down and flags identities repeatedly denied by the same rule, so probing the policy for gaps is slow
+// and noisy. Only denials are delayed, so requests the policy allows are unaffected, even for flagged
+// identities. Identities are users of an identified cluster, or of the caller's address
+type Tarpit struct {
+	options TarpitOptions
+	now     func() time.Time
+	// Denials by cluster, user and rule
+	denials *lru.Cache[tarpitKey, *tarpitCount]
+	// Serialises counting, and guards delayed
+	mu      sync.Mutex
+	delayed int
+}
+
+type tarpitKey struct {
+	cluster string
+	user    string
+	rule    string
+}
+
+// Denials within the window starting at start
+type tarpitCount struct {
+	start time.Time
+	count int
+}
+
+// Returns tarpit for the options, ignoring empty rules
+func NewTarpit(options TarpitOptions) (*Tarpit, error) {
+	if options.Threshold <= 0 {
+		return nil, fmt.Errorf("tarpit threshold must be positive")
+	}
+	if options.Window <= 0 {
+		return nil, fmt.Errorf("tarpit window must be positive")
+	}
+	if options.MaxDelay > 0 && options.Delay <= 0 {
+		return nil, fmt.Errorf("tarpit delay must be positive")
+	}
+	var rules []string
+	for _, rule := range options.Rules {
+		if rule == "" {
+			continue
+		}
+		if !slices.Contains(policy.RuleNames, rule) || rule == policy.RuleNameAllow || rule == policy.RuleAdditionalPrivilegedUser || rule == policy.RuleDefaultAllow {
+			return nil, fmt.Errorf("unknown deny rule %q", rule)
+		}
+		rules = append(rules, rule)
+	}
+	options.Rules = rules
+	return &Tarpit{options: options, now: time.Now, denials: lru.New[tarpitKey, *tarpitCount](tarpitIdentities)}, nil
+}
+
+// Counts a denial of user from cluster by rule, returning the number of denials within the window once past
+// the threshold, and how long to delay the response. The identity is flagged once, when it first passes the
+// threshold in a window. Counts nothing if t is nil
+func (t *Tarpit) observe(cluster string, user string, rule string) (int, time.Duration) {
+	if t == nil || (len(t.options.Rules) > 0 && !slices.Contains(t.options.Rules, rule)) {
+		return 0, 0
+	}
+	key := tarpitKey{cluster: cluster, user: user, rule: rule}
+	now := t.now()
+	t.mu.Lock()
+	counter, ok := t.denials.Get(key)
+	if !ok {
+		counter = &tarpitCount{}
+		t.denials.Add(key, counter)
+	}
+	if now.Sub(counter.start) > t.options.Window {
+		counter.start, counter.count = now, 0
+	}
+	counter.count++
+	count := counter.count
+	t.mu.Unlock()
+
+	excess := count - t.options.Threshold
+	if excess <= 0 {
+		return 0, 0
+	}
+	if excess == 1 {
+		flaggedIdentities.Inc(rule)
+		log.Printf("Repeated denials: %s from cluster %q denied %d times by rule %s within %s\n", user, cluster, count, rule, t.options.Window)
+	}
+	if t.options.MaxDelay <= 0 {
+		return count, 0
+	}
+	delay := t.options.Delay
+	for i := 1; i < excess && delay < t.options.MaxDelay; i++ {
+		delay *= 2
+	}
+	return count, min(delay, t.options.MaxDelay)
+}
+
+// Waits for delay unless ctx is done first, or too many responses are already delayed
+func (t *Tarpit) wait(ctx context.Context, rule string, delay time.Duration) {
+	t.mu.Lock()
+	if t.delayed >= maxTarpitted {
+		t.mu.Unlock()
+		return
+	}
+	t.delayed++
+	t.mu.Unlock()
+	defer func() {
+		t.mu.Lock()
+		t.delayed--
+		t.mu.Unlock()
+	}()
+	tarpittedRequests.Inc(rule)
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
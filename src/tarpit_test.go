@@ -0,0 +1,99 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/policy"
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/server"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTarpitEscalation(t *testing.T) {
+	if _, err := NewTarpit(TarpitOptions{Threshold: 3, Window: time.Minute, Rules: []string{policy.RuleDefaultAllow}}); err == nil {
+		t.Error("Expected allow rule to be rejected")
+	}
+	tarpit, err := NewTarpit(TarpitOptions{Threshold: 3, Window: time.Minute, Delay: 100 * time.Millisecond, MaxDelay: time.Second, Rules: []string{"", policy.RuleProtectedSecrets, policy.RuleProtectedWrite}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	tarpit.now = func() time.Time { return now }
+
+	before := flaggedIdentities.Value(policy.RuleProtectedSecrets)
+	var delays []time.Duration
+	for range 8 {
+		_, delay := tarpit.observe("az-demo/demo", "alice", policy.RuleProtectedSecrets)
+		delays = append(delays, delay)
+	}
+	expected := []time.Duration{0, 0, 0, 100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second}
+	for i := range expected {
+		if delays[i] != expected[i] {
+			t.Errorf("Expected delays %v, got %v", expected, delays)
+			break
+		}
+	}
+	if flaggedIdentities.Value(policy.RuleProtectedSecrets) != before+1 {
+		t.Error("Expected identity to be flagged once")
+	}
+
+	// Counted separately for each user, cluster and rule, and only for the rules given
+	if count, delay := tarpit.observe("az-demo/demo", "alice", policy.RuleProtectedWrite); count != 0 || delay != 0 {
+		t.Errorf("Expected denials by another rule to be counted separately, got %d and %s", count, delay)
+	}
+	if count, delay := tarpit.observe("az-other/demo", "alice", policy.RuleProtectedSecrets); count != 0 || delay != 0 {
+		t.Errorf("Expected denials from another cluster to be counted separately, got %d and %s", count, delay)
+	}
+	for range 5 {
+		if _, delay := tarpit.observe("az-demo/demo", "alice", policy.RuleWildcardRequest); delay != 0 {
+			t.Fatal("Expected denials by rules not given not to be counted")
+		}
+	}
+
+	// Reset once the window has passed
+	now = now.Add(2 * time.Minute)
+	if count, delay := tarpit.observe("az-demo/demo", "alice", policy.RuleProtectedSecrets); count != 0 || delay != 0 {
+		t.Errorf("Expected count to restart after the window, got %d and %s", count, delay)
+	}
+
+	flagOnly, _ := NewTarpit(TarpitOptions{Threshold: 1, Window: time.Minute})
+	if count, delay := flagOnly.observe("", "alice", policy.RuleProtectedWrite); count != 0 || delay != 0 {
+		t.Errorf("Expected first denial to be under the threshold, got %d and %s", count, delay)
+	}
+	if count, delay := flagOnly.observe("", "alice", policy.RuleProtectedWrite); count != 2 || delay != 0 {
+		t.Errorf("Expected identity to be flagged without delay, got %d and %s", count, delay)
+	}
+	if count, delay := (*Tarpit)(nil).observe("", "alice", policy.RuleProtectedWrite); count != 0 || delay != 0 {
+		t.Error("Expected nil tarpit to count nothing")
+	}
+}
+
+func TestTarpitDelaysOnlyDenials(t *testing.T) {
+	tarpit, err := NewTarpit(TarpitOptions{Threshold: 1, Window: time.Minute, Delay: 50 * time.Millisecond, MaxDelay: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := CreateWebhookAuthorizer(WebhookConfig{Config: DefaultPolicyConfig, Tarpit: tarpit})
+	request := func(namespace string) (time.Duration, server.SubjectAccessReviewResponse) {
+		body := `{"apiVersion":"authorization.k8s.io/v1","kind":"SubjectAccessReview",
+			"spec":{"user":"alice","resourceAttributes":{"namespace":"` + namespace + `","verb":"delete","resource":"pods"}}}`
+		start := time.Now()
+		resp := httptest.NewRecorder()
+		handler(resp, httptest.NewRequest(http.MethodPost, "/authorize", strings.NewReader(body)))
+		var response server.SubjectAccessReviewResponse
+		json.NewDecoder(resp.Body).Decode(&response)
+		return time.Since(start), response
+	}
+
+	if elapsed, response := request("kube-system"); !response.Status.Denied || elapsed >= 50*time.Millisecond {
+		t.Errorf("Expected first denial to be answered at once, got %+v after %s", response.Status, elapsed)
+	}
+	if elapsed, response := request("kube-system"); !response.Status.Denied || elapsed < 50*time.Millisecond {
+		t.Errorf("Expected repeated denial to be delayed, got %+v after %s", response.Status, elapsed)
+	}
+	if elapsed, response := request("tenant-acme"); response.Status.Denied || elapsed >= 50*time.Millisecond {
+		t.Errorf("Expected request the policy doesn't deny to be answered at once, got %+v after %s", response.Status, elapsed)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/internal/lru"
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/policy"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+var tenancyLookups = Metrics.NewCounterVec("azimuth_authz_tenancy_lookups_total",
+	"Azimuth tenancy lookups, by result", "result")
+
+// Response from the Azimuth tenancy endpoint listing the tenancies a user belongs to
+type tenancyResponse struct {
+	Tenancies []struct {
+		ID         string   `json:"id"`
+		Name       string   `json:"name"`
+		Namespaces []string `json:"namespaces"`
+	} `json:"tenancies"`
+}
+
+type cachedTenancyNamespaces struct {
+	namespaces stringSet
+	expires    time.Time
+}
+
+type TenancyResolverOptions struct {
+	URL         string
+	BearerToken string
+	Timeout     time.Duration
+	// Namespaces subject to tenancy ownership checks. Writes elsewhere are unaffected
+	TenantNamespaces []string
+	CacheSize        int
+	CacheTTL         time.Duration
+}
+
+// Restricts writes in tenant namespaces to users whose Azimuth tenancy owns the namespace, so that
+// Azimuth's tenancy model is enforced inside shared workload clusters
+type TenancyResolver struct {
+	options          TenancyResolverOptions
+	client           *OutboundClient
+	tenantNamespaces *policy.NamespaceMatcher
+	cache            *lru.Cache[string, cachedTenancyNamespaces]
+}
+
+func NewTenancyResolver(options TenancyResolverOptions, client *OutboundClient) *TenancyResolver {
+	if options.CacheSize <= 0 {
+		options.CacheSize = 1024
+	}
+	return &TenancyResolver{
+		options:          options,
+		client:           client,
+		tenantNamespaces: policy.CompileNamespaceMatcher(options.TenantNamespaces),
+		cache:            lru.New[string, cachedTenancyNamespaces](options.CacheSize),
+	}
+}
+
+// Denies writes to tenant namespaces not owned by the user's tenancies, with no opinion on reads and
+// privileged users. Lookup failures deny, since allowing would bypass tenancy
+func (t *TenancyResolver) Authorize(ctx context.Context, spec *policy.SubjectAccessReviewSpec) policy.Decision {
+	attributes := spec.ResourceAttributes
+	if attributes == nil || attributes.Namespace == "" || policy.IsReadonlyVerb(attributes.Verb) {
+		return policy.Decision{}
+	}
+	if !t.tenantNamespaces.Matches(attributes.Namespace) || isPrivileged(ctx) {
+		return policy.Decision{}
+	}
+
+	// Users only known by their groups or UID belong to no tenancy
+	if spec.User == "" {
+		return policy.Decision{Verdict: policy.Deny, Reason: "Cannot write to namespace not owned by user's tenancy"}
+	}
+	namespaces, err := t.namespacesFor(ctx, spec.User)
+	if err != nil {
+		return policy.Decision{Verdict: policy.Deny, Reason: "Unable to resolve Azimuth tenancy", EvaluationError: err.Error()}
+	}
+	if !namespaces.Has(attributes.Namespace) {
+		return policy.Decision{Verdict: policy.Deny, Reason: "Cannot write to namespace not owned by user's tenancy"}
+	}
+	return policy.Decision{}
+}
+
+// Returns namespaces owned by the tenancies user belongs to, cached for the configured TTL
+func (t *TenancyResolver) namespacesFor(ctx context.Context, user string) (stringSet, error) {
+	if cached, ok := t.cache.Get(user); ok && time.Now().Before(cached.expires) {
+		tenancyLookups.Inc("cached")
+		return cached.namespaces, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, t.options.URL+"?"+url.Values{"user": {user}}.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if t.options.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+t.options.BearerToken)
+	}
+	resp, err := t.client.Do("azimuth", t.options.Timeout, req)
+	if err != nil {
+		tenancyLookups.Inc("error")
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	namespaces := stringSet{}
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		// User unknown to Azimuth, so belongs to no tenancies
+	case resp.StatusCode != http.StatusOK:
+		tenancyLookups.Inc("error")
+		return nil, fmt.Errorf("unexpected status from Azimuth tenancy endpoint: %s", resp.Status)
+	default:
+		var body tenancyResponse
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			tenancyLookups.Inc("error")
+			return nil, fmt.Errorf("decoding Azimuth tenancy response: %w", err)
+		}
+		for _, tenancy := range body.Tenancies {
+			for _, namespace := range tenancy.Namespaces {
+				namespaces[namespace] = struct{}{}
+			}
+		}
+	}
+	tenancyLookups.Inc("fetched")
+	t.cache.Add(user, cachedTenancyNamespaces{namespaces: namespaces, expires: time.Now().Add(t.options.CacheTTL)})
+	return namespaces, nil
+}
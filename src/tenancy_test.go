@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newTestTenancyAPI(t *testing.T, lookups *int) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*lookups++
+		if r.URL.Query().Get("user") != "tenant-user" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{
+			"tenancies": []map[string]any{{"id": "1", "name": "demo", "namespaces": []string{"az-demo"}}},
+		})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func tenancySAR(user string, namespace string, verb string) []byte {
+	return []byte(`{
+	"kind":"SubjectAccessReview",
+	"apiVersion":"authorization.k8s.io/v1",
+	"spec":{
+		"resourceAttributes":{"namespace":"` + namespace + `","verb":"` + verb + `","resource":"pods"},
+		"user":"` + user + `"
+	}
+	}`)
+}
+
+func TestTenancyRestrictsWritesToOwnedNamespaces(t *testing.T) {
+	var lookups int
+	server := newTestTenancyAPI(t, &lookups)
+	authorizer := CreateWebhookAuthorizer(WebhookConfig{
+		Config: DefaultPolicyConfig,
+		Tenancy: NewTenancyResolver(TenancyResolverOptions{
+			URL:              server.URL,
+			TenantNamespaces: []string{"az-*"},
+			CacheTTL:         time.Minute,
+		}, NewOutboundClient(DefaultOutboundClientOptions)),
+	})
+
+	accessTest(t, authorizer, false, tenancySAR("tenant-user", "az-demo", "create"))
+	accessTest(t, authorizer, true, tenancySAR("tenant-user", "az-other", "create"))
+	accessTest(t, authorizer, true, tenancySAR("unknown-user", "az-demo", "delete"))
+	// Reads and namespaces outside the tenant patterns are unaffected
+	accessTest(t, authorizer, false, tenancySAR("unknown-user", "az-demo", "get"))
+	accessTest(t, authorizer, false, tenancySAR("unknown-user", "default", "create"))
+	// Privileged system users aren't subject to tenancy
+	accessTest(t, authorizer, false, tenancySAR("system:kube-controller-manager", "az-other", "delete"))
+	// Users only known by their groups belong to no tenancy, and aren't looked up
+	accessTest(t, authorizer, true, []byte(`{
+	"kind":"SubjectAccessReview",
+	"apiVersion":"authorization.k8s.io/v1",
+	"spec":{"resourceAttributes":{"namespace":"az-demo","verb":"create","resource":"pods"},"groups":["tenants"]}
+	}`))
+
+	if lookups != 2 {
+		t.Errorf("Expected one cached lookup per user, got %d lookups", lookups)
+	}
+}
+
+func TestTenancyLookupFailureDenies(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	authorizer := CreateWebhookAuthorizer(WebhookConfig{
+		Config: DefaultPolicyConfig,
+		Tenancy: NewTenancyResolver(TenancyResolverOptions{URL: server.URL, TenantNamespaces: []string{"az-*"}},
+			NewOutboundClient(DefaultOutboundClientOptions)),
+	})
+	accessTest(t, authorizer, true, tenancySAR("tenant-user", "az-demo", "create"))
+}
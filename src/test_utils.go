@@ -1,10 +1,17 @@
 package main
 
 import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/policy"
 	"net/http"
 )
 
 var DefaultProtectedNamespaces = []string{"kube-system", "openstack-system"}
 var DefaultAdditionalPrivilegedUsers = []string{}
 
-var DefaultAuthorizer func(w http.ResponseWriter, r *http.Request) = CreateWebhookAuthorizer(DefaultProtectedNamespaces, DefaultAdditionalPrivilegedUsers, false, 0)
+var DefaultPolicyConfig = policy.Config{
+	ProtectedNamespaces:       DefaultProtectedNamespaces,
+	AdditionalPrivilegedUsers: DefaultAdditionalPrivilegedUsers,
+	ClassificationCacheSize:   16,
+}
+
+var DefaultAuthorizer func(w http.ResponseWriter, r *http.Request) = CreateWebhookAuthorizer(WebhookConfig{Config: DefaultPolicyConfig})
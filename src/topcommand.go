@@ -0,0 +1,241 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/internal/exitcode"
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Clears the terminal and moves the cursor to the top left
+const clearScreen = "\x1b[H\x1b[2J"
+
+// Shows live decision rates, the most denied users and recent denials from a running webhook's decision
+// stream, redrawn every interval. For operators without access to dashboards
+func runTop(args []string, out io.Writer) int {
+	flags := flag.NewFlagSet("top", flag.ContinueOnError)
+	serverURL := flags.String("server-url", "http://localhost:8081", "URL of the webhook's admin interface. '/admin/decisions' is appended if there is no path")
+	caFile := flags.String("ca-file", "", "CA bundle used to verify the admin interface, system roots if empty")
+	clientCertFile := flags.String("client-cert-file", "", "Client certificate presented to the admin interface")
+	clientKeyFile := flags.String("client-key-file", "", "Private key of the client certificate")
+	tokenFile := flags.String("token-file", "", "File containing the admin token")
+	interval := flags.Duration("interval", 2*time.Second, "Time between redraws")
+	window := flags.Duration("window", time.Minute, "Period rates and top denied users are computed over")
+	limit := flags.Int("limit", 10, "Number of top denied users and recent denials shown")
+	if err := flags.Parse(args); err != nil {
+		return exitcode.Usage
+	}
+	if flags.NArg() != 0 || *interval <= 0 || *window <= 0 || *limit <= 0 {
+		flags.Usage()
+		return exitcode.Usage
+	}
+
+	streamURL, err := url.Parse(*serverURL)
+	if err != nil {
+		return exitcode.Report(os.Stderr, exitcode.Wrap(exitcode.Config, err))
+	}
+	if streamURL.Path == "" || streamURL.Path == "/" {
+		streamURL.Path = "/admin/decisions"
+	}
+	conn, err := webhookConnection(streamURL.String(), *caFile, *clientCertFile, *clientKeyFile, *tokenFile)
+	if err != nil {
+		return exitcode.Report(os.Stderr, exitcode.Wrap(exitcode.Config, err))
+	}
+	request, err := http.NewRequest(http.MethodGet, conn.Server, nil)
+	if err != nil {
+		return exitcode.Report(os.Stderr, err)
+	}
+	if conn.BearerToken != "" {
+		request.Header.Set("Authorization", "Bearer "+conn.BearerToken)
+	}
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: conn.TLSConfig, Proxy: http.ProxyFromEnvironment}}
+	response, err := client.Do(request)
+	if err != nil {
+		return exitcode.Report(os.Stderr, err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+		fmt.Fprintf(os.Stderr, "error: decision stream returned %s: %s\n", response.Status, strings.TrimSpace(string(body)))
+		return exitcode.Connectivity
+	}
+
+	events := make(chan AuditEvent)
+	streamErr := make(chan error, 1)
+	go func() {
+		decoder := json.NewDecoder(bufio.NewReader(response.Body))
+		for {
+			var event AuditEvent
+			if err := decoder.Decode(&event); err != nil {
+				streamErr <- err
+				return
+			}
+			events <- event
+		}
+	}()
+
+	stats := newDecisionStats(*window, *limit)
+	clear := isTerminal(out)
+	draw := func() {
+		if clear {
+			fmt.Fprint(out, clearScreen)
+		}
+		stats.render(out, streamURL.Redacted(), time.Now())
+	}
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	draw()
+	for {
+		select {
+		case event := <-events:
+			stats.add(event)
+		case <-ticker.C:
+			draw()
+		case err := <-streamErr:
+			draw()
+			if errors.Is(err, io.EOF) {
+				err = errors.New("decision stream closed by the webhook")
+			}
+			return exitcode.Report(os.Stderr, exitcode.Wrap(exitcode.Connectivity, err))
+		}
+	}
+}
+
+// Reports whether output is written to a terminal, so escape codes are only written to terminals
+func isTerminal(out io.Writer) bool {
+	file, ok := out.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// Decisions seen on the stream, aggregated for display
+type decisionStats struct {
+	window time.Duration
+	limit  int
+	// Decisions within the window, oldest first
+	recent []AuditEvent
+	// Most recent denials, newest first
+	denials []AuditEvent
+	// Decisions since connecting, by outcome
+	totals map[string]int
+}
+
+func newDecisionStats(window time.Duration, limit int) *decisionStats {
+	return &decisionStats{window: window, limit: limit, totals: map[string]int{}}
+}
+
+// Outcome of a decision as reported by the webhook
+func decisionOutcome(event AuditEvent) string {
+	switch {
+	case event.Denied:
+		return "denied"
+	case event.Allowed:
+		return "allowed"
+	default:
+		return "no-opinion"
+	}
+}
+
+func (s *decisionStats) add(event AuditEvent) {
+	s.totals[decisionOutcome(event)]++
+	s.recent = append(s.recent, event)
+	if event.Denied {
+		s.denials = slices.Insert(s.denials, 0, event)
+		if len(s.denials) > s.limit {
+			s.denials = s.denials[:s.limit]
+		}
+	}
+}
+
+// Drops decisions which have left the window
+func (s *decisionStats) expire(now time.Time) {
+	cutoff := now.Add(-s.window)
+	expired := 0
+	for expired < len(s.recent) && s.recent[expired].Time.Before(cutoff) {
+		expired++
+	}
+	s.recent = slices.Delete(s.recent, 0, expired)
+}
+
+func (s *decisionStats) render(out io.Writer, source string, now time.Time) {
+	s.expire(now)
+	outcomes := []string{"allowed", "denied", "no-opinion"}
+	windowed := map[string]int{}
+	deniedUsers := map[string]int{}
+	for _, event := range s.recent {
+		windowed[decisionOutcome(event)]++
+		if event.Denied {
+			deniedUsers[event.User]++
+		}
+	}
+
+	fmt.Fprintf(out, "%s  %s\n\n", source, now.Format(time.TimeOnly))
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "\t%s\n", strings.Join(outcomes, "\t"))
+	fmt.Fprintf(w, "Per second over %s", s.window)
+	for _, outcome := range outcomes {
+		fmt.Fprintf(w, "\t%.1f", float64(windowed[outcome])/s.window.Seconds())
+	}
+	fmt.Fprint(w, "\nTotal since connected")
+	for _, outcome := range outcomes {
+		fmt.Fprintf(w, "\t%d", s.totals[outcome])
+	}
+	fmt.Fprintln(w)
+	w.Flush()
+
+	users := make([]string, 0, len(deniedUsers))
+	for user := range deniedUsers {
+		users = append(users, user)
+	}
+	slices.SortFunc(users, func(a, b string) int {
+		if deniedUsers[a] != deniedUsers[b] {
+			return deniedUsers[b] - deniedUsers[a]
+		}
+		return strings.Compare(a, b)
+	})
+	if len(users) > s.limit {
+		users = users[:s.limit]
+	}
+	fmt.Fprintf(out, "\nTop denied users over %s\n", s.window)
+	w = tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "DENIALS\tUSER")
+	for _, user := range users {
+		fmt.Fprintf(w, "%d\t%s\n", deniedUsers[user], user)
+	}
+	w.Flush()
+
+	fmt.Fprintln(out, "\nRecent denials")
+	w = tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tCLUSTER\tUSER\tVERB\tRESOURCE\tNAMESPACE\tREASON")
+	for _, event := range s.denials {
+		resource := event.Resource
+		if event.NonResourcePath != "" {
+			resource = event.NonResourcePath
+		} else if event.Name != "" {
+			resource += "/" + event.Name
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", event.Time.Local().Format(time.TimeOnly),
+			orDash(event.Cluster), event.User, event.Verb, orDash(resource), orDash(event.Namespace), event.Reason)
+	}
+	w.Flush()
+}
+
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/internal/exitcode"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecisionStatsRender(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	stats := newDecisionStats(time.Minute, 2)
+	stats.add(AuditEvent{Time: now.Add(-2 * time.Minute), User: "carol", Denied: true, Verb: "get", Resource: "secrets"})
+	for i := range 3 {
+		stats.add(AuditEvent{Time: now.Add(-time.Duration(i) * time.Second), User: "alice", Denied: true, Verb: "delete", Resource: "pods", Namespace: "kube-system"})
+	}
+	stats.add(AuditEvent{Time: now, User: "bob", Denied: true, Verb: "get", NonResourcePath: "/metrics", Reason: "forbidden"})
+	for range 6 {
+		stats.add(AuditEvent{Time: now, User: "dave", Allowed: true})
+	}
+	stats.add(AuditEvent{Time: now, User: "erin"})
+
+	var out bytes.Buffer
+	stats.render(&out, "http://localhost:8081/admin/decisions", now)
+	rendered := out.String()
+	words := strings.Join(strings.Fields(rendered), " ")
+	for _, expected := range []string{"1m0s 0.1 0.1 0.0", "connected 6 5 1", "USER 3 alice 1 bob", "get /metrics"} {
+		if !strings.Contains(words, expected) {
+			t.Errorf("Expected %q in output:\n%s", expected, rendered)
+		}
+	}
+	// Denials outside the window aren't counted, and only the most recent are listed
+	if strings.Contains(rendered, "carol") || strings.Count(rendered, "kube-system") != 1 {
+		t.Errorf("Expected old and excess denials to be left out:\n%s", rendered)
+	}
+	if len(stats.recent) != 11 {
+		t.Errorf("Expected expired decisions to be dropped, %d remain", len(stats.recent))
+	}
+}
+
+func TestTopCommand(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/admin/decisions" || r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(AuditEvent{Time: time.Now(), User: "alice", Denied: true, Verb: "delete", Resource: "pods"})
+	}))
+	defer server.Close()
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	os.WriteFile(tokenFile, []byte("secret\n"), 0o600)
+
+	// The stream ending is reported once the last decisions are shown
+	var out bytes.Buffer
+	if code := runTop([]string{"--server-url", server.URL, "--token-file", tokenFile, "--interval", "1h"}, &out); code != exitcode.Connectivity {
+		t.Errorf("Expected connectivity error when the stream ends, got exit code %d", code)
+	}
+	if !strings.Contains(out.String(), "alice") || strings.Contains(out.String(), clearScreen) {
+		t.Errorf("Expected denial to be shown without escape codes, got:\n%s", out.String())
+	}
+
+	out.Reset()
+	if code := runTop([]string{"--server-url", server.URL}, &out); code != exitcode.Connectivity || out.Len() != 0 {
+		t.Errorf("Expected unauthenticated request to fail, got %d", code)
+	}
+}
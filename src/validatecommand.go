@@ -0,0 +1,34 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/internal/exitcode"
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/policy"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Validates a local policy and self-tests it against the built-in corpus, as the webhook does before
+// becoming ready, so broken policies are caught before they're deployed. Exits 0 if it passes both
+func runValidate(args []string, out io.Writer) int {
+	flags := flag.NewFlagSet("validate", flag.ContinueOnError)
+	loadPolicy := addPolicyFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return exitcode.Usage
+	}
+	policyFile, err := loadPolicy()
+	if err != nil {
+		return exitcode.Report(os.Stderr, err)
+	}
+	policyConfig := policyFile.PolicyConfig()
+	violations := selfTestViolations(policyConfig, policy.Compile(policyConfig))
+	for _, violation := range violations {
+		fmt.Fprintln(out, "Self-test failed:", violation)
+	}
+	if len(violations) > 0 {
+		return exitcode.Policy
+	}
+	fmt.Fprintln(out, "Policy is valid and passes the self-test")
+	return 0
+}
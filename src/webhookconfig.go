@@ -0,0 +1,115 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/internal/exitcode"
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/config"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Implements the gen-webhook-config command, writing the kubeconfig and apiserver configuration for
+// this webhook to out. Returns the process exit code
+func runGenWebhookConfig(args []string, out io.Writer) int {
+	flags := flag.NewFlagSet("gen-webhook-config", flag.ContinueOnError)
+	name := flags.String("name", "azimuth-authorization-webhook", "Name of the webhook in generated configuration")
+	serverURL := flags.String("server-url", "", "URL kube-apiserver uses to reach the webhook. '/authorize' is appended if there is no path. Required")
+	caFile := flags.String("ca-file", "", "CA bundle kube-apiserver uses to verify the webhook, system roots if empty")
+	clientCertFile := flags.String("client-cert-file", "", "Client certificate kube-apiserver presents to the webhook")
+	clientKeyFile := flags.String("client-key-file", "", "Private key of the client certificate")
+	tokenFile := flags.String("token-file", "", "File containing bearer token kube-apiserver sends to the webhook")
+	kubeconfigPath := flags.String("kubeconfig-path", "/etc/kubernetes/azimuth-authorization-webhook.yaml", "Path the generated kubeconfig will be saved to on control plane nodes")
+	timeout := flags.Duration("timeout", 3*time.Second, "Timeout for webhook calls")
+	authorizedTTL := flags.Duration("authorized-ttl", 5*time.Minute, "Time kube-apiserver caches allowed decisions")
+	unauthorizedTTL := flags.Duration("unauthorized-ttl", 30*time.Second, "Time kube-apiserver caches denied decisions")
+	failurePolicy := flags.String("failure-policy", "NoOpinion", "Decision when the webhook can't be reached. Values: [NoOpinion, Deny]")
+	matchConditionsFile := flags.String("match-conditions-file", "", "YAML file listing CEL match conditions to include in the structured configuration")
+	output := flags.String("output", "all", "Configuration to write. Values: [all, kubeconfig, authorization-config, flags]")
+	if err := flags.Parse(args); err != nil {
+		return exitcode.Usage
+	}
+	if *failurePolicy != "NoOpinion" && *failurePolicy != "Deny" {
+		fmt.Fprintf(os.Stderr, "error: unknown failure policy %q\n", *failurePolicy)
+		return exitcode.Usage
+	}
+
+	options, err := apiserverWebhookOptions(*name, *serverURL, *caFile, *clientCertFile, *clientKeyFile, *tokenFile, *matchConditionsFile)
+	if err != nil {
+		return exitcode.Report(os.Stderr, exitcode.Wrap(exitcode.Config, err))
+	}
+	options.KubeconfigPath = *kubeconfigPath
+	options.Timeout = *timeout
+	options.AuthorizedTTL = *authorizedTTL
+	options.UnauthorizedTTL = *unauthorizedTTL
+	options.FailurePolicy = *failurePolicy
+
+	kubeconfig, err := config.GenerateWebhookKubeconfig(options)
+	if err != nil {
+		return exitcode.Report(os.Stderr, exitcode.Wrap(exitcode.Config, err))
+	}
+	authorizationConfig, err := config.GenerateAuthorizationConfiguration(options)
+	if err != nil {
+		return exitcode.Report(os.Stderr, exitcode.Wrap(exitcode.Config, err))
+	}
+	apiserverFlags := strings.Join(config.GenerateApiserverFlags(options), "\n")
+
+	switch *output {
+	case "kubeconfig":
+		out.Write(kubeconfig)
+	case "authorization-config":
+		out.Write(authorizationConfig)
+	case "flags":
+		fmt.Fprintln(out, apiserverFlags)
+	case "all":
+		fmt.Fprintf(out, "# Webhook kubeconfig, to be saved on each control plane node as %s\n%s", options.KubeconfigPath, kubeconfig)
+		fmt.Fprintf(out, "---\n# Structured authorization configuration (Kubernetes 1.30+), passed to kube-apiserver with --authorization-config\n%s", authorizationConfig)
+		fmt.Fprintf(out, "# Alternatively, kube-apiserver flags for clusters without structured authorization configuration:\n#   %s\n",
+			strings.ReplaceAll(apiserverFlags, "\n", "\n#   "))
+	default:
+		fmt.Fprintf(os.Stderr, "error: unknown output %q\n", *output)
+		return exitcode.Usage
+	}
+	return 0
+}
+
+// Reads credential and match condition files into options
+func apiserverWebhookOptions(name string, serverURL string, caFile string, clientCertFile string, clientKeyFile string, tokenFile string, matchConditionsFile string) (config.ApiserverWebhookOptions, error) {
+	options := config.ApiserverWebhookOptions{Name: name}
+	u, err := url.Parse(serverURL)
+	if err != nil || u.Host == "" {
+		return options, fmt.Errorf("--server-url must be an absolute URL")
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/authorize"
+	}
+	options.ServerURL = u.String()
+
+	if (clientCertFile == "") != (clientKeyFile == "") {
+		return options, fmt.Errorf("--client-cert-file and --client-key-file must be set together")
+	}
+	for _, file := range []struct {
+		path string
+		data *[]byte
+	}{{caFile, &options.CAData}, {clientCertFile, &options.ClientCertData}, {clientKeyFile, &options.ClientKeyData}} {
+		if file.path == "" {
+			continue
+		}
+		if *file.data, err = os.ReadFile(file.path); err != nil {
+			return options, err
+		}
+	}
+	if options.Token, err = readSecretFile(tokenFile); err != nil {
+		return options, err
+	}
+	if matchConditionsFile != "" {
+		// Not compiled, as kube-apiserver supports more of CEL than the webhook's own pre-filter
+		if options.MatchConditions, err = readMatchConditions(matchConditionsFile); err != nil {
+			return options, err
+		}
+	}
+	return options, nil
+}
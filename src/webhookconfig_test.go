@@ -0,0 +1,78 @@
+package main
+
+import (
+	"azimuth-cloud/azimuth-authorizaton-webhook/pkg/config"
+	"bytes"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sigs.k8s.io/yaml"
+	"strings"
+	"testing"
+)
+
+func TestGeneratedWebhookKubeconfigIsLoadable(t *testing.T) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+	dir := t.TempDir()
+	caFile, tokenFile := filepath.Join(dir, "ca.crt"), filepath.Join(dir, "token")
+	os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0o600)
+	os.WriteFile(tokenFile, []byte("apiserver-token\n"), 0o600)
+
+	var out bytes.Buffer
+	if code := runGenWebhookConfig([]string{"--server-url", server.URL, "--ca-file", caFile, "--token-file", tokenFile, "--output", "kubeconfig"}, &out); code != 0 {
+		t.Fatalf("Expected success, got exit code %d", code)
+	}
+	path := filepath.Join(dir, "kubeconfig")
+	os.WriteFile(path, out.Bytes(), 0o600)
+	conn, err := LoadKubeconfig(path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conn.Server != server.URL+"/authorize" || conn.BearerToken != "apiserver-token" || conn.TLSConfig.RootCAs == nil {
+		t.Errorf("Unexpected connection %+v", conn)
+	}
+}
+
+func TestGeneratedAuthorizationConfiguration(t *testing.T) {
+	conditionsFile := filepath.Join(t.TempDir(), "conditions.yaml")
+	os.WriteFile(conditionsFile, []byte("- name: skip-kube-system\n  expression: \"!request.user.startsWith('system:serviceaccount:kube-system:')\"\n"), 0o600)
+
+	var out bytes.Buffer
+	args := []string{"--server-url", "https://webhook.example.com", "--match-conditions-file", conditionsFile, "--failure-policy", "Deny", "--output", "authorization-config"}
+	if code := runGenWebhookConfig(args, &out); code != 0 {
+		t.Fatalf("Expected success, got exit code %d", code)
+	}
+	var config config.AuthorizationConfiguration
+	if err := yaml.Unmarshal(out.Bytes(), &config); err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Authorizers) != 3 || config.Authorizers[1].Webhook == nil {
+		t.Fatalf("Expected webhook between Node and RBAC authorizers, got %+v", config.Authorizers)
+	}
+	webhook := config.Authorizers[1].Webhook
+	if webhook.FailurePolicy != "Deny" || len(webhook.MatchConditions) != 1 || webhook.MatchConditions[0].Name != "skip-kube-system" {
+		t.Errorf("Unexpected webhook configuration %+v", webhook)
+	}
+
+	out.Reset()
+	runGenWebhookConfig([]string{"--server-url", "https://webhook.example.com/custom", "--output", "flags"}, &out)
+	if !strings.Contains(out.String(), "--authorization-mode=Node,Webhook,RBAC") {
+		t.Errorf("Expected authorization mode flag, got %s", out.String())
+	}
+}
+
+func TestGenWebhookConfigRejectsInvalidOptions(t *testing.T) {
+	for _, args := range [][]string{
+		{},
+		{"--server-url", "webhook.example.com"},
+		{"--server-url", "https://webhook.example.com", "--client-cert-file", "client.crt"},
+		{"--server-url", "https://webhook.example.com", "--failure-policy", "Allow"},
+	} {
+		if code := runGenWebhookConfig(args, &bytes.Buffer{}); code == 0 {
+			t.Errorf("Expected %v to be rejected", args)
+		}
+	}
+}